@@ -0,0 +1,194 @@
+package ogo
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The role of a principal of the ops API. Each role may do what
+// the roles below it may.
+type Role int
+
+const (
+	// May read every endpoint but the runtime profiles.
+	RoleReader Role = iota
+	// May also change the network: flows, ports, queues,
+	// drains and switch connections.
+	RoleOperator
+	// May also hand switches off, change switch configuration
+	// and maintenance mode, and read the runtime profiles.
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// Who a request to the ops API was made by.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// A request to the ops API that may have changed something.
+type AccessRecord struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Role      string    `json:"role,omitempty"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+}
+
+// An AccessControl authenticates the requests to the ops API by
+// API key, sent as "Authorization: Bearer KEY", and authorizes
+// them by the role of the key. /healthz and /readyz are served to
+// everyone, for health checks.
+type AccessControl struct {
+	// If set, an AccessRecord is written to it, one JSON object
+	// per line, for every request other than GET and HEAD,
+	// whether it was allowed or not. Open files with
+	// os.O_APPEND, so records are only ever added.
+	Log io.Writer
+
+	mu   sync.Mutex
+	keys map[[sha256.Size]byte]Principal
+}
+
+func NewAccessControl() *AccessControl {
+	return &AccessControl{keys: make(map[[sha256.Size]byte]Principal)}
+}
+
+// Gives the holder of key the name and role of p. Only a hash of
+// key is kept.
+func (a *AccessControl) AddKey(key string, p Principal) {
+	a.mu.Lock()
+	a.keys[sha256.Sum256([]byte(key))] = p
+	a.mu.Unlock()
+}
+
+// Revokes key.
+func (a *AccessControl) RemoveKey(key string) {
+	a.mu.Lock()
+	delete(a.keys, sha256.Sum256([]byte(key)))
+	a.mu.Unlock()
+}
+
+// Returns the principal holding the key of r.
+func (a *AccessControl) authenticate(r *http.Request) (Principal, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return Principal{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.keys[sha256.Sum256([]byte(auth[len("Bearer "):]))]
+	return p, ok
+}
+
+// Returns the role needed for request r.
+func requiredRole(r *http.Request) Role {
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+		return RoleAdmin
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return RoleReader
+	}
+	switch r.URL.Path {
+	case "/handoff", "/config", "/maintenance":
+		return RoleAdmin
+	}
+	return RoleOperator
+}
+
+// The principals of the requests being served, by request, read
+// by requestUser.
+var principals = struct {
+	sync.Mutex
+	m map[*http.Request]string
+}{m: make(map[*http.Request]string)}
+
+// Returns a handler serving the requests to h that a's keys allow.
+func (a *AccessControl) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := AccessRecord{Time: time.Now(), Principal: r.RemoteAddr, Method: r.Method, URL: r.URL.String()}
+		p, ok := a.authenticate(r)
+		switch {
+		case !ok:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			rec.Status = http.StatusUnauthorized
+		case p.Role < requiredRole(r):
+			rec.Principal, rec.Role = p.Name, p.Role.String()
+			http.Error(w, requiredRole(r).String()+" role required", http.StatusForbidden)
+			rec.Status = http.StatusForbidden
+		default:
+			rec.Principal, rec.Role = p.Name, p.Role.String()
+			principals.Lock()
+			principals.m[r] = p.Name
+			principals.Unlock()
+			sw := &statusWriter{w, http.StatusOK}
+			h.ServeHTTP(sw, r)
+			principals.Lock()
+			delete(principals.m, r)
+			principals.Unlock()
+			rec.Status = sw.status
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			a.record(rec)
+		}
+	})
+}
+
+func (a *AccessControl) record(rec AccessRecord) {
+	if a.Log == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := json.NewEncoder(a.Log).Encode(rec); err != nil {
+		log.Println("Failed to write access record:", err)
+	}
+}
+
+var ErrOpsNotLoopback = errors.New("Without an AccessControl the ops API only listens on loopback addresses.")
+
+// Returns the address the ops API listens on for addr. Without
+// access control only loopback addresses are allowed, and a port
+// alone means the port on 127.0.0.1.
+func opsAddr(addr string, open bool) (string, error) {
+	if !open {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", ErrOpsNotLoopback
+	}
+	return addr, nil
+}
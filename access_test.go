@@ -0,0 +1,193 @@
+package ogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessControl(t *testing.T) {
+	a := NewAccessControl()
+	var audit bytes.Buffer
+	a.Log = &audit
+	a.AddKey("r", Principal{"alice", RoleReader})
+	a.AddKey("o", Principal{"bob", RoleOperator})
+	a.AddKey("a", Principal{"carol", RoleAdmin})
+	var user string
+	h := a.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = requestUser(r)
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{"GET", "/healthz", "", 200},
+		{"GET", "/topology", "", 401},
+		{"GET", "/topology", "wrong", 401},
+		{"GET", "/topology", "r", 200},
+		{"DELETE", "/switch/flows", "r", 403},
+		{"DELETE", "/switch/flows", "o", 200},
+		{"GET", "/debug/pprof/heap", "o", 403},
+		{"GET", "/debug/pprof/heap", "a", 200},
+		{"POST", "/maintenance", "o", 403},
+		{"POST", "/maintenance", "a", 200},
+	}
+	records := 0
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if test.key != "" {
+			r.Header.Set("Authorization", "Bearer "+test.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s with key %q: got %d, expected %d.", test.method, test.path, test.key, w.Code, test.status)
+		}
+		if test.method != "GET" {
+			records += 1
+		}
+	}
+	if user != "carol" {
+		t.Errorf("The last request was made by %q.", user)
+	}
+
+	dec := json.NewDecoder(&audit)
+	n := 0
+	for ; dec.More(); n++ {
+		var rec AccessRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Method == "GET" {
+			t.Errorf("Recorded a read: %+v", rec)
+		}
+	}
+	if n != records {
+		t.Errorf("Got %d access records, expected %d.", n, records)
+	}
+
+	a.RemoveKey("a")
+	r := httptest.NewRequest("GET", "/topology", nil)
+	r.Header.Set("Authorization", "Bearer a")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Errorf("A revoked key got %d.", w.Code)
+	}
+}
+
+func TestOpsAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		open bool
+		exp  string
+		ok   bool
+	}{
+		{":8080", true, "127.0.0.1:8080", true},
+		{"localhost:8080", true, "localhost:8080", true},
+		{"[::1]:8080", true, "[::1]:8080", true},
+		{"10.0.0.1:8080", true, "", false},
+		{"0.0.0.0:8080", true, "", false},
+		{":8080", false, ":8080", true},
+		{"10.0.0.1:8080", false, "10.0.0.1:8080", true},
+	}
+	for _, test := range tests {
+		addr, err := opsAddr(test.addr, test.open)
+		if (err == nil) != test.ok || addr != test.exp {
+			t.Errorf("%s: got %q, %v.", test.addr, addr, err)
+		}
+	}
+}
+
+func TestAccessLogOperations(t *testing.T) {
+	network = NewNetwork()
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	sw, conn := testSwitch(dpid)
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, conn)
+	defer sw.stream.Close()
+
+	c := &Controller{Access: NewAccessControl(), Interlock: NewInterlock()}
+	var audit bytes.Buffer
+	c.Access.Log = &audit
+	c.Access.AddKey("r", Principal{"alice", RoleReader})
+	c.Access.AddKey("o", Principal{"bob", RoleOperator})
+	c.Access.AddKey("a", Principal{"carol", RoleAdmin})
+	c.Interlock.SetMaintenance(true, "test", "carol")
+	h := c.opsHandler()
+
+	// Each request that may change something is logged with who
+	// made it and how it ended. Those reaching the interlock are
+	// also recorded there with their result.
+	tests := []struct {
+		method string
+		url    string
+		key    string
+		status int
+		// The interlock's result, or "" if it isn't reached.
+		result string
+	}{
+		{"GET", "/topology", "r", 200, ""},
+		{"DELETE", "/switch/flows?dpid=" + dpid.String(), "o", 204, "done"},
+		{"POST", "/switch/port?dpid=" + dpid.String() + "&port=7", "o", 500, "Switch " + dpid.String() + " has no port 7."},
+		{"POST", "/switch/disconnect?dpid=" + dpid.String(), "r", 403, ""},
+		{"POST", "/switch/disconnect?dpid=" + dpid.String(), "", 401, ""},
+		{"POST", "/maintenance?on=false", "a", 200, ""},
+		{"DELETE", "/switch/flows?dpid=" + dpid.String(), "o", 428, "confirm"},
+		{"DELETE", "/switch/flows?dpid=" + dpid.String() + "&confirm=bad", "a", 403, "refused"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if test.key != "" {
+			r.Header.Set("Authorization", "Bearer "+test.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s with key %q: got %d, expected %d.", test.method, test.url, test.key, w.Code, test.status)
+		}
+	}
+
+	names := map[string]string{"": "192.0.2.1:1234", "r": "alice", "o": "bob", "a": "carol"}
+	roles := map[string]string{"r": "reader", "o": "operator", "a": "admin"}
+	dec := json.NewDecoder(&audit)
+	records := c.Interlock.Records()
+	for _, test := range tests {
+		if test.method == "GET" {
+			continue
+		}
+		var rec AccessRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("%s %s: %s", test.method, test.url, err)
+		}
+		if rec.Method != test.method || rec.URL != test.url || rec.Principal != names[test.key] ||
+			rec.Role != roles[test.key] || rec.Status != test.status {
+			t.Errorf("%s %s with key %q: logged %+v.", test.method, test.url, test.key, rec)
+		}
+		if test.result == "" {
+			continue
+		}
+		if len(records) == 0 {
+			t.Errorf("%s %s: not recorded by the interlock.", test.method, test.url)
+			continue
+		}
+		if r := records[0]; r.User != names[test.key] || r.Result != test.result || r.DPID != dpid.String() {
+			t.Errorf("%s %s with key %q: recorded %+v.", test.method, test.url, test.key, r)
+		}
+		records = records[1:]
+	}
+	if dec.More() {
+		t.Error("Logged more requests than were made.")
+	}
+	if len(records) > 0 {
+		t.Errorf("Recorded more operations than were made: %+v", records)
+	}
+}
@@ -0,0 +1,272 @@
+package ogo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long to wait for the flow counters of each switch.
+var AccountingRequestTimeout = time.Second * 5
+
+// How long usage records are kept.
+var AccountingRetention = 31 * 24 * time.Hour
+
+// Attributes the traffic of flows whose cookie, masked with Mask,
+// is Cookie to Tenant.
+type AccountingRule struct {
+	Tenant string `json:"tenant"`
+	Cookie uint64 `json:"cookie"`
+	Mask   uint64 `json:"mask"`
+}
+
+// The traffic of a tenant's flows on a switch between Start and
+// End. Owner is the application that installed the flows, see
+// RegisterFlowOwner, so traffic can be billed per service.
+type UsageRecord struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Tenant  string    `json:"tenant"`
+	Owner   string    `json:"owner,omitempty"`
+	DPID    string    `json:"dpid"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+}
+
+// Writes records as CSV with a header row.
+func WriteUsageCSV(w io.Writer, records []UsageRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"start", "end", "tenant", "owner", "dpid", "packets", "bytes"})
+	for _, r := range records {
+		cw.Write([]string{r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Tenant,
+			r.Owner, r.DPID, strconv.FormatUint(r.Packets, 10), strconv.FormatUint(r.Bytes, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Accounting polls the flow counters of every switch each
+// Interval and turns the traffic of the flows of each tenant
+// during the interval into usage records.
+//
+// Counters are read by cookie. The traffic of a flow deleted
+// between two polls since the first of them is lost, and a
+// cookie whose counters went down, because some of its flows
+// were deleted or replaced, is counted from zero again. Flows
+// matching no rule aren't billed.
+type Accounting struct {
+	Interval time.Duration
+	mu       sync.Mutex
+	rules    []AccountingRule
+	// The counters at the last poll of each switch, by cookie.
+	last    map[string]map[uint64][2]uint64
+	polled  map[string]time.Time
+	records []UsageRecord
+	stop    chan bool
+}
+
+func NewAccounting(interval time.Duration) *Accounting {
+	a := new(Accounting)
+	a.Interval = interval
+	a.rules = make([]AccountingRule, 0)
+	a.last = make(map[string]map[uint64][2]uint64)
+	a.polled = make(map[string]time.Time)
+	a.records = make([]UsageRecord, 0)
+	a.stop = make(chan bool, 1)
+	return a
+}
+
+// Bills the flows whose cookie, masked with mask, is cookie to
+// tenant. Flows are billed to the first matching rule.
+func (a *Accounting) AddTenant(tenant string, cookie, mask uint64) {
+	a.mu.Lock()
+	a.rules = append(a.rules, AccountingRule{tenant, cookie & mask, mask})
+	a.mu.Unlock()
+}
+
+func (a *Accounting) Rules() []AccountingRule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AccountingRule(nil), a.rules...)
+}
+
+// Returns the tenant of flows with cookie, false if none.
+func (a *Accounting) tenant(cookie uint64) (string, bool) {
+	for _, r := range a.rules {
+		if cookie&r.Mask == r.Cookie {
+			return r.Tenant, true
+		}
+	}
+	return "", false
+}
+
+func (a *Accounting) Start() {
+	go func() {
+		a.Sample()
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				a.Sample()
+			}
+		}
+	}()
+}
+
+func (a *Accounting) Stop() {
+	select {
+	case a.stop <- true:
+	default:
+	}
+}
+
+// Polls the flow counters of every switch once, adding a record
+// for each tenant and owner with traffic since the previous poll
+// of the switch. The first poll of a switch only sets the
+// baseline.
+func (a *Accounting) Sample() {
+	for _, sw := range Switches() {
+		counts, err := sw.cookieCounters(0, 0, AccountingRequestTimeout)
+		if err != nil {
+			log.Println("Failed to read the flow counters of", SwitchLabel(sw.DPID())+":", err)
+			continue
+		}
+		a.add(sw.DPID().String(), time.Now(), counts)
+	}
+	a.expire(time.Now())
+}
+
+// Records the traffic in counts, the counters of Switch dpid at
+// now, since the last poll.
+func (a *Accounting) add(dpid string, now time.Time, counts map[uint64][2]uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	last, ok := a.last[dpid]
+	start := a.polled[dpid]
+	a.last[dpid] = counts
+	a.polled[dpid] = now
+	if !ok {
+		return
+	}
+
+	usage := make(map[[2]string]*UsageRecord)
+	for cookie, c := range counts {
+		tenant, ok := a.tenant(cookie)
+		if !ok {
+			continue
+		}
+		delta := c
+		if prev, ok := last[cookie]; ok && c[0] >= prev[0] && c[1] >= prev[1] {
+			delta = [2]uint64{c[0] - prev[0], c[1] - prev[1]}
+		}
+		if delta[0] == 0 && delta[1] == 0 {
+			continue
+		}
+		owner := FlowOwner(cookie)
+		key := [2]string{tenant, owner}
+		r, ok := usage[key]
+		if !ok {
+			r = &UsageRecord{Start: start, End: now, Tenant: tenant, Owner: owner, DPID: dpid}
+			usage[key] = r
+		}
+		r.Packets += delta[0]
+		r.Bytes += delta[1]
+	}
+	records := make([]UsageRecord, 0, len(usage))
+	for _, r := range usage {
+		records = append(records, *r)
+	}
+	sort.Sort(usageRecords(records))
+	a.records = append(a.records, records...)
+}
+
+func (a *Accounting) expire(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := 0
+	for i < len(a.records) && now.Sub(a.records[i].End) > AccountingRetention {
+		i++
+	}
+	a.records = a.records[i:]
+}
+
+type usageRecords []UsageRecord
+
+func (r usageRecords) Len() int      { return len(r) }
+func (r usageRecords) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r usageRecords) Less(i, j int) bool {
+	if r[i].Tenant != r[j].Tenant {
+		return r[i].Tenant < r[j].Tenant
+	}
+	return r[i].Owner < r[j].Owner
+}
+
+// Returns the records ending after since, of tenant if it isn't
+// empty, oldest first.
+func (a *Accounting) Records(since time.Time, tenant string) []UsageRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	records := make([]UsageRecord, 0)
+	for _, r := range a.records {
+		if r.End.After(since) && (tenant == "" || r.Tenant == tenant) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Returns the traffic of each tenant in the records ending after
+// since, summed over switches and owners.
+func (a *Accounting) Totals(since time.Time) map[string]UsageRecord {
+	totals := make(map[string]UsageRecord)
+	for _, r := range a.Records(since, "") {
+		t, ok := totals[r.Tenant]
+		if !ok {
+			t = UsageRecord{Start: r.Start, End: r.End, Tenant: r.Tenant}
+		}
+		if r.Start.Before(t.Start) {
+			t.Start = r.Start
+		}
+		if r.End.After(t.End) {
+			t.End = r.End
+		}
+		t.Packets += r.Packets
+		t.Bytes += r.Bytes
+		totals[r.Tenant] = t
+	}
+	return totals
+}
+
+// Serves the usage records as JSON, or as CSV with format=csv.
+// since, in RFC 3339, and tenant select the records.
+//
+//	/accounting?format=csv&since=2016-05-01T00:00:00Z&tenant=lab1
+func (a *Accounting) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, fmt.Sprintf("bad since: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	records := a.Records(since, q.Get("tenant"))
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		WriteUsageCSV(w, records)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
@@ -0,0 +1,432 @@
+// Package anomaly watches packet-ins and port counters for signs
+// of attacks, such as SYN floods and port scans, and publishes an
+// event on the controller's event bus for each one found. It can
+// optionally install temporary drop rules for the offending
+// sources, and rate limits for the targets of floods whose
+// sources can't be told apart.
+package anomaly
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Event types published by the detector. The event data is an
+// Alert.
+const (
+	EventPacketInRate = "anomaly.packetin"
+	EventNewFlowRate  = "anomaly.newflows"
+	EventSynFlood     = "anomaly.synflood"
+	EventPortScan     = "anomaly.scan"
+	EventPortRate     = "anomaly.port"
+)
+
+// Thresholds are per switch and per second unless noted. A zero
+// threshold disables the check.
+type Config struct {
+	Interval time.Duration
+	// Packet-ins received from a switch.
+	PacketInRate float64
+	// Distinct source and destination pairs seen in
+	// packet-ins.
+	NewFlowRate float64
+	// TCP SYNs without ACK sent to a single destination.
+	SynRate float64
+	// Distinct destination ports probed by a single source in
+	// one interval.
+	ScanPorts int
+	// Packets received on a single port, from port stats.
+	// Only polled on OpenFlow 1.0 switches.
+	PortPacketRate float64
+	// If set, sources of SYN floods and scans are dropped for
+	// BlockTime. A SYN flood's source is the host that sent
+	// more than SynRate SYNs to the target by itself; if there
+	// is none the flood is rate limited instead, if RateLimit
+	// is set.
+	Mitigate  bool
+	BlockTime time.Duration
+	// TCP traffic to the target of a SYN flood is limited to
+	// RateLimit kilobits per second for BlockTime with a meter,
+	// on OpenFlow 1.3+ switches. Traffic under the limit
+	// continues in RateLimitTable, which must forward it and
+	// can't be 0. Meters are numbered from RateLimitMeter.
+	RateLimit      uint32
+	RateLimitTable uint8
+	RateLimitMeter uint32
+}
+
+var DefaultConfig = Config{
+	Interval:       time.Second * 5,
+	PacketInRate:   1000,
+	NewFlowRate:    500,
+	SynRate:        200,
+	ScanPorts:      100,
+	PortPacketRate: 100000,
+	BlockTime:      time.Minute,
+	RateLimitTable: 1,
+	RateLimitMeter: 0xa000,
+}
+
+// Published with every anomaly event.
+type Alert struct {
+	Kind   string
+	DPID   net.HardwareAddr
+	Source net.IP
+	Target net.IP
+	Port   uint16
+	Rate   float64
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s on %s: rate %.1f src %v dst %v port %d",
+		a.Kind, a.DPID, a.Rate, a.Source, a.Target, a.Port)
+}
+
+// Counters for one switch, reset every interval.
+type counters struct {
+	packetIns int
+	flows     map[string]bool
+	syns      map[string]int             // by destination ip
+	synSrcs   map[string]map[string]int  // destination to source ip
+	probes    map[string]map[uint16]bool // source ip to dst ports
+	ports     map[uint16]uint64          // last rx packets per port
+}
+
+func newCounters() *counters {
+	c := new(counters)
+	c.flows = make(map[string]bool)
+	c.syns = make(map[string]int)
+	c.synSrcs = make(map[string]map[string]int)
+	c.probes = make(map[string]map[uint16]bool)
+	c.ports = make(map[uint16]uint64)
+	return c
+}
+
+// A rate limit installed for the target of a SYN flood.
+type limit struct {
+	dpid    net.HardwareAddr
+	target  net.IP
+	meter   uint32
+	expires time.Time
+}
+
+type Detector struct {
+	cfg      Config
+	mu       sync.Mutex
+	switches map[string]*counters
+	// By switch and target
+	limits map[string]*limit
+	stop   chan bool
+}
+
+func NewDetector(cfg Config) *Detector {
+	d := new(Detector)
+	d.cfg = cfg
+	d.switches = make(map[string]*counters)
+	d.limits = make(map[string]*limit)
+	d.stop = make(chan bool, 1)
+	return d
+}
+
+// Adds the detector to the front of the packet-in chain of c and
+// starts checking for anomalies every Interval. The detector
+// never consumes packet-ins.
+func (d *Detector) Attach(c *ogo.Controller) {
+	c.AddPacketInHandler("anomaly", 1<<30, d)
+	go d.loop()
+}
+
+func (d *Detector) Stop() {
+	select {
+	case d.stop <- true:
+	default:
+	}
+}
+
+func (d *Detector) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.switches[dpid.String()]
+	if !ok {
+		c = newCounters()
+		d.switches[dpid.String()] = c
+	}
+	c.packetIns += 1
+	c.flows[pkt.Data.HWSrc.String()+pkt.Data.HWDst.String()] = true
+
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok || pkt.Data.Ethertype != eth.IPv4_MSG || ip.Protocol != ipv4.Type_TCP {
+		return false
+	}
+	buf, ok := ip.Data.(*util.Buffer)
+	if !ok || buf.Len() < 14 {
+		return false
+	}
+	tcp := buf.Bytes()
+	dst := binary.BigEndian.Uint16(tcp[2:])
+	flags := tcp[13]
+	// SYN set, ACK clear.
+	if flags&0x02 != 0 && flags&0x10 == 0 {
+		c.syns[ip.NWDst.String()] += 1
+		srcs, ok := c.synSrcs[ip.NWDst.String()]
+		if !ok {
+			srcs = make(map[string]int)
+			c.synSrcs[ip.NWDst.String()] = srcs
+		}
+		srcs[ip.NWSrc.String()] += 1
+		p, ok := c.probes[ip.NWSrc.String()]
+		if !ok {
+			p = make(map[uint16]bool)
+			c.probes[ip.NWSrc.String()] = p
+		}
+		p[dst] = true
+	}
+	return false
+}
+
+func (d *Detector) loop() {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.check()
+		}
+	}
+}
+
+func (d *Detector) check() {
+	secs := d.cfg.Interval.Seconds()
+	alerts := make([]Alert, 0)
+
+	d.mu.Lock()
+	for key, c := range d.switches {
+		dpid, _ := net.ParseMAC(key)
+		if r := float64(c.packetIns) / secs; d.cfg.PacketInRate > 0 && r > d.cfg.PacketInRate {
+			alerts = append(alerts, Alert{Kind: EventPacketInRate, DPID: dpid, Rate: r})
+		}
+		if r := float64(len(c.flows)) / secs; d.cfg.NewFlowRate > 0 && r > d.cfg.NewFlowRate {
+			alerts = append(alerts, Alert{Kind: EventNewFlowRate, DPID: dpid, Rate: r})
+		}
+		for dst, n := range c.syns {
+			if r := float64(n) / secs; d.cfg.SynRate > 0 && r > d.cfg.SynRate {
+				a := Alert{Kind: EventSynFlood, DPID: dpid, Target: net.ParseIP(dst), Rate: r}
+				if src, n := topSource(c.synSrcs[dst]); float64(n)/secs > d.cfg.SynRate {
+					a.Source = net.ParseIP(src)
+				}
+				alerts = append(alerts, a)
+			}
+		}
+		for src, ports := range c.probes {
+			if d.cfg.ScanPorts > 0 && len(ports) > d.cfg.ScanPorts {
+				alerts = append(alerts, Alert{Kind: EventPortScan, DPID: dpid, Source: net.ParseIP(src), Rate: float64(len(ports))})
+			}
+		}
+		last := c.ports
+		*c = *newCounters()
+		c.ports = last
+	}
+	d.mu.Unlock()
+
+	if d.cfg.PortPacketRate > 0 {
+		alerts = append(alerts, d.checkPorts(secs)...)
+	}
+	d.expireLimits(time.Now())
+	for _, a := range alerts {
+		log.Println("Anomaly:", a)
+		ogo.Publish(a.Kind, a.DPID, a)
+		if !d.cfg.Mitigate {
+			continue
+		}
+		if a.Source != nil {
+			d.block(a.DPID, a.Source)
+		} else if a.Kind == EventSynFlood && d.cfg.RateLimit > 0 {
+			d.limit(a.DPID, a.Target)
+		}
+	}
+}
+
+// Returns the source in srcs with the highest count.
+func topSource(srcs map[string]int) (top string, n int) {
+	for src, c := range srcs {
+		if c > n || (c == n && src < top) {
+			top, n = src, c
+		}
+	}
+	return top, n
+}
+
+// Polls the receive counters of every port of every OpenFlow 1.0
+// switch.
+func (d *Detector) checkPorts(secs float64) []Alert {
+	alerts := make([]Alert, 0)
+	for _, sw := range ogo.Switches() {
+		if sw.Version() != ofp10.VERSION {
+			continue
+		}
+		for _, port := range sw.Ports() {
+			req := ofp10.NewPortStatsRequest()
+			req.PortNo = port.PortNo
+			rep, err := sw.SendAndReceive(ofp10.NewStatsRequest(ofp10.StatsType_Port, req), time.Second*2)
+			if err != nil {
+				continue
+			}
+			r, ok := rep.(*ofp10.StatsReply)
+			if !ok {
+				continue
+			}
+			s, ok := r.Body.(*ofp10.PortStats)
+			if !ok {
+				continue
+			}
+
+			d.mu.Lock()
+			c, ok := d.switches[sw.DPID().String()]
+			if !ok {
+				c = newCounters()
+				d.switches[sw.DPID().String()] = c
+			}
+			prev, seen := c.ports[port.PortNo]
+			c.ports[port.PortNo] = s.RxPackets
+			d.mu.Unlock()
+
+			if !seen || s.RxPackets < prev {
+				continue
+			}
+			if rate := float64(s.RxPackets-prev) / secs; rate > d.cfg.PortPacketRate {
+				alerts = append(alerts, Alert{Kind: EventPortRate, DPID: sw.DPID(), Port: port.PortNo, Rate: rate})
+			}
+		}
+	}
+	return alerts
+}
+
+// Drops IPv4 traffic from src at Switch dpid for BlockTime.
+func (d *Detector) block(dpid net.HardwareAddr, src net.IP) {
+	sw, ok := ogo.Switch(dpid)
+	if !ok || src.To4() == nil {
+		return
+	}
+	r := &ogo.FlowRule{
+		Priority:    0xfff0,
+		HardTimeout: uint16(d.cfg.BlockTime.Seconds()),
+		Match:       ogo.FlowMatch{EthType: eth.IPv4_MSG, IPSrc: src.To4()},
+	}
+	log.Println("Blocking", src, "on", ogo.SwitchLabel(dpid), "for", d.cfg.BlockTime)
+	if err := sw.InstallFlowRule(r); err != nil {
+		log.Println("Failed to block", src, "on", ogo.SwitchLabel(dpid), err)
+	}
+}
+
+// Limits TCP traffic to target at Switch dpid to RateLimit for
+// BlockTime. A flood that goes on while it is limited extends
+// the limit.
+func (d *Detector) limit(dpid net.HardwareAddr, target net.IP) {
+	sw, ok := ogo.Switch(dpid)
+	if !ok || target.To4() == nil {
+		return
+	}
+	if sw.Version() == ofp10.VERSION || d.cfg.RateLimitTable == 0 {
+		log.Println("Can't rate limit", target, "on", ogo.SwitchLabel(dpid))
+		return
+	}
+	key := dpid.String() + "/" + target.String()
+	d.mu.Lock()
+	l, ok := d.limits[key]
+	if !ok {
+		l = &limit{dpid: dpid, target: target, meter: d.freeMeter(dpid)}
+		d.limits[key] = l
+	}
+	l.expires = time.Now().Add(d.cfg.BlockTime)
+	d.mu.Unlock()
+
+	msgs, err := limitMessages(sw.Version(), l, d.cfg, !ok)
+	if err != nil {
+		log.Println("Failed to rate limit", target, "on", ogo.SwitchLabel(dpid), err)
+		return
+	}
+	log.Println("Rate limiting", target, "on", ogo.SwitchLabel(dpid), "for", d.cfg.BlockTime)
+	for _, msg := range msgs {
+		if err := sw.Send(msg); err != nil {
+			log.Println("Failed to rate limit", target, "on", ogo.SwitchLabel(dpid), err)
+			return
+		}
+	}
+}
+
+// Returns the lowest meter id not used by a limit on Switch dpid.
+// Must be called with d.mu held.
+func (d *Detector) freeMeter(dpid net.HardwareAddr) uint32 {
+	used := make(map[uint32]bool)
+	for _, l := range d.limits {
+		if l.dpid.String() == dpid.String() {
+			used[l.meter] = true
+		}
+	}
+	id := d.cfg.RateLimitMeter
+	for used[id] {
+		id += 1
+	}
+	return id
+}
+
+// Returns the messages installing limit l on a switch speaking
+// version: the meter, if add is set, and the flow, which expires
+// by itself after BlockTime.
+func limitMessages(version uint8, l *limit, cfg Config, add bool) ([]util.Message, error) {
+	r := &ogo.FlowRule{
+		Priority:    0xffe0,
+		HardTimeout: uint16(cfg.BlockTime.Seconds()),
+		Match:       ogo.FlowMatch{EthType: eth.IPv4_MSG, IPProto: ipv4.Type_TCP, IPDst: l.target.To4()},
+		GotoTable:   cfg.RateLimitTable,
+	}
+	msg, err := r.FlowMod(version)
+	if err != nil {
+		return nil, err
+	}
+	f := msg.(*ofp14.FlowMod)
+	f.Instructions = append([]ofp14.Instruction{ofp14.NewInstrMeter(l.meter)}, f.Instructions...)
+	if !add {
+		return []util.Message{f}, nil
+	}
+	m := ofp14.NewMeterMod(ofp14.MC_ADD, l.meter)
+	m.Header.Version = version
+	m.AddBand(ofp14.NewMeterBandDrop(cfg.RateLimit, cfg.RateLimit/10))
+	return []util.Message{m, f}, nil
+}
+
+// Deletes the meters of limits that ended before now. Their flows
+// have already timed out.
+func (d *Detector) expireLimits(now time.Time) {
+	expired := make([]*limit, 0)
+	d.mu.Lock()
+	for key, l := range d.limits {
+		if now.After(l.expires) {
+			expired = append(expired, l)
+			delete(d.limits, key)
+		}
+	}
+	d.mu.Unlock()
+	for _, l := range expired {
+		sw, ok := ogo.Switch(l.dpid)
+		if !ok {
+			continue
+		}
+		m := ofp14.NewMeterMod(ofp14.MC_DELETE, l.meter)
+		m.Header.Version = sw.Version()
+		sw.Send(m)
+	}
+}
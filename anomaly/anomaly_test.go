@@ -0,0 +1,103 @@
+package anomaly
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Returns a packet-in of a TCP SYN from src to dst.
+func testSyn(src, dst string) *ofp10.PacketIn {
+	pkt := ofp10.NewPacketIn()
+	pkt.Data.Ethertype = eth.IPv4_MSG
+	ip := ipv4.New()
+	ip.Protocol = ipv4.Type_TCP
+	ip.NWSrc = net.ParseIP(src).To4()
+	ip.NWDst = net.ParseIP(dst).To4()
+	tcp := make([]byte, 20)
+	tcp[2], tcp[3] = 0, 80 // Destination port
+	tcp[13] = 0x02         // SYN
+	ip.Data = util.NewBuffer(tcp)
+	pkt.Data.Data = ip
+	return pkt
+}
+
+func TestSynFloodSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		source  net.IP
+	}{
+		{"single source", []string{"198.51.100.1"}, net.ParseIP("198.51.100.1")},
+		{"one source over the rate", []string{"198.51.100.1", "198.51.100.1", "198.51.100.2"}, net.ParseIP("198.51.100.1")},
+		{"spread over sources", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}, nil},
+	}
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	for _, test := range tests {
+		cfg := Config{Interval: time.Second, SynRate: 10}
+		d := NewDetector(cfg)
+		for i := 0; i < 30; i++ {
+			d.HandlePacketIn(dpid, testSyn(test.sources[i%len(test.sources)], "192.0.2.1"))
+		}
+		sub := ogo.Subscribe(4, EventSynFlood)
+		d.check()
+		sub.Cancel()
+		e, ok := <-sub.C
+		if !ok {
+			t.Errorf("%s: no SYN flood detected.", test.name)
+			continue
+		}
+		a := e.Data.(Alert)
+		if !a.Target.Equal(net.ParseIP("192.0.2.1")) || !a.Source.Equal(test.source) {
+			t.Errorf("%s: got alert %v.", test.name, a)
+		}
+	}
+}
+
+func TestLimitMessages(t *testing.T) {
+	cfg := Config{BlockTime: time.Minute, RateLimit: 1000, RateLimitTable: 1}
+	l := &limit{target: net.ParseIP("192.0.2.1"), meter: 7}
+	tests := []struct {
+		name string
+		add  bool
+		msgs int
+	}{
+		{"new limit", true, 2},
+		{"extended limit", false, 1},
+	}
+	for _, test := range tests {
+		msgs, err := limitMessages(4, l, cfg, test.add)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(msgs) != test.msgs {
+			t.Fatalf("%s: got %d messages.", test.name, len(msgs))
+		}
+		if test.add {
+			m, ok := msgs[0].(*ofp14.MeterMod)
+			if !ok || m.Command != ofp14.MC_ADD || m.MeterId != 7 || m.Bands[0].Rate != 1000 {
+				t.Errorf("%s: got meter mod %+v.", test.name, msgs[0])
+			}
+		}
+		f := msgs[len(msgs)-1].(*ofp14.FlowMod)
+		if f.Header.Version != 4 || f.HardTimeout != 60 || len(f.Instructions) != 2 {
+			t.Fatalf("%s: got flow mod %+v.", test.name, f)
+		}
+		if i, ok := f.Instructions[0].(*ofp14.InstrMeter); !ok || i.MeterId != 7 {
+			t.Errorf("%s: flow doesn't start with the meter.", test.name)
+		}
+		if i, ok := f.Instructions[1].(*ofp14.InstrGotoTable); !ok || i.TableId != 1 {
+			t.Errorf("%s: flow doesn't continue in table 1.", test.name)
+		}
+	}
+	if _, err := limitMessages(1, l, cfg, true); err == nil {
+		t.Error("Built a rate limit for OpenFlow 1.0.")
+	}
+}
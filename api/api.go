@@ -0,0 +1,183 @@
+// Package api is the stable interface of the controller for
+// applications. It hides the concrete types of package ogo behind
+// small interfaces and plain value types, so the internals of the
+// controller can be reworked without breaking applications built
+// on it.
+//
+// Within a major Version, changes to this package are only
+// additive: methods are never removed from or added to its
+// interfaces, and fields are only added to its structs. Code
+// written against package ogo directly keeps compiling; Wrap and
+// Unwrap convert between the two while it moves over.
+//
+//	net := api.New(ctrl)
+//	sub := net.Subscribe(16, api.EventSwitchUp)
+//	for e := range sub.Events() {
+//		sw, _ := net.Switch(e.DPID)
+//		sw.Install(api.Rule{Priority: 10, Match: api.Match{EthType: 0x88cc},
+//			Outputs: []uint16{api.PortController}})
+//	}
+package api
+
+import (
+	"net"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The version of this interface.
+const Version = "1.0"
+
+// Events published by the controller.
+const (
+	EventSwitchUp   = "switch.up"
+	EventSwitchDown = "switch.down"
+	EventLinkUp     = "link.up"
+	EventLinkDown   = "link.down"
+	EventHostAdded  = "host.added"
+	EventHostMoved  = "host.moved"
+	EventHostDown   = "host.down"
+)
+
+// Reserved ports, in OpenFlow 1.0 numbering. They are translated
+// for switches of later versions.
+const (
+	PortController = ofp10.P_CONTROLLER
+	PortFlood      = ofp10.P_FLOOD
+	PortNormal     = ofp10.P_NORMAL
+)
+
+// The controller as seen by an application.
+type Network interface {
+	// Returns the connected switch dpid.
+	Switch(dpid net.HardwareAddr) (Switch, bool)
+	// Returns every connected switch, in order of DPID.
+	Switches() []Switch
+	// Returns a snapshot of the switches, links and hosts.
+	Topology() Topology
+	// Subscribes to events whose type starts with one of
+	// prefixes, or to every event if none is given.
+	Subscribe(buffer int, prefixes ...string) Subscription
+	Publish(eventType string, dpid net.HardwareAddr, data interface{})
+	// Adds h to the packet-in handlers, higher priorities
+	// first.
+	HandlePacketIns(name string, priority int, h PacketInHandler)
+}
+
+// A connected switch.
+type Switch interface {
+	DPID() net.HardwareAddr
+	// The negotiated OpenFlow version.
+	Version() uint8
+	Ports() []Port
+	// Links discovered from this switch to others.
+	Links() []Link
+	// Sends a message built with the protocol packages.
+	Send(msg util.Message) error
+	// Sends msg and waits up to timeout for the reply.
+	Request(msg util.Message, timeout time.Duration) (util.Message, error)
+	// Installs or removes a flow, for any OpenFlow version.
+	Install(r Rule) error
+	Remove(r Rule) error
+}
+
+// Receives the events of a subscription until Cancel is called.
+// Events are dropped if the channel is full.
+type Subscription interface {
+	Events() <-chan Event
+	Cancel()
+}
+
+// Handles a packet-in. Returning true stops the handlers of lower
+// priority from seeing it.
+type PacketInHandler interface {
+	HandlePacketIn(sw Switch, pkt *ofp10.PacketIn) bool
+}
+
+// Adapts an ordinary function to a PacketInHandler.
+type PacketInHandlerFunc func(sw Switch, pkt *ofp10.PacketIn) bool
+
+func (f PacketInHandlerFunc) HandlePacketIn(sw Switch, pkt *ofp10.PacketIn) bool {
+	return f(sw, pkt)
+}
+
+type Event struct {
+	Type string
+	DPID net.HardwareAddr
+	Time time.Time
+	Data interface{}
+}
+
+type Port struct {
+	Number uint16
+	Name   string
+	HWAddr net.HardwareAddr
+	// False if the port is administratively down or has no
+	// link.
+	Up bool
+}
+
+// A link from a port of a switch to Peer.
+type Link struct {
+	Port    uint16
+	Peer    net.HardwareAddr
+	Latency time.Duration
+	// True if the link crosses a legacy L2 cloud.
+	Indirect bool
+}
+
+// Selects traffic. Zero valued fields match everything.
+type Match struct {
+	InPort    uint16
+	EthSrc    net.HardwareAddr
+	EthDst    net.HardwareAddr
+	EthType   uint16
+	VLAN      uint16
+	IPSrc     net.IP
+	IPSrcMask net.IPMask
+	IPDst     net.IP
+	IPDstMask net.IPMask
+	IPProto   uint8
+	TPSrc     uint16
+	TPDst     uint16
+}
+
+// A flow. Matching packets have their destination rewritten by
+// the non-nil Set fields and are sent out Outputs, or dropped if
+// there are none.
+type Rule struct {
+	Priority    uint16
+	Cookie      uint64
+	IdleTimeout uint16
+	HardTimeout uint16
+	Match       Match
+	SetEthDst   net.HardwareAddr
+	SetIPDst    net.IP
+	Outputs     []uint16
+}
+
+type Topology struct {
+	Switches []net.HardwareAddr
+	Links    []TopologyLink
+	Hosts    []Host
+}
+
+// A unidirectional link from Src out SrcPort to Dst.
+type TopologyLink struct {
+	Src      net.HardwareAddr
+	SrcPort  uint16
+	Dst      net.HardwareAddr
+	Latency  time.Duration
+	Indirect bool
+	// In bits per second, 0 if unknown.
+	Capacity uint64
+	Load     uint64
+}
+
+type Host struct {
+	MAC  net.HardwareAddr
+	DPID net.HardwareAddr
+	Port uint16
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo"
+)
+
+var _ Switch = (*ofSwitch)(nil)
+var _ Network = (*network)(nil)
+
+// The constants of this package must keep naming what the
+// controller publishes.
+func TestEventNames(t *testing.T) {
+	names := map[string]string{
+		EventSwitchUp:   ogo.EventSwitchUp,
+		EventSwitchDown: ogo.EventSwitchDown,
+		EventLinkUp:     ogo.EventLinkUp,
+		EventLinkDown:   ogo.EventLinkDown,
+		EventHostAdded:  ogo.EventHostAdded,
+		EventHostMoved:  ogo.EventHostMoved,
+		EventHostDown:   ogo.EventHostDown,
+	}
+	for ours, theirs := range names {
+		if ours != theirs {
+			t.Errorf("Event %s is published as %s", ours, theirs)
+		}
+	}
+}
+
+func TestRuleRecipe(t *testing.T) {
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	r := Rule{Priority: 7, Cookie: 9, Outputs: []uint16{3},
+		Match: Match{InPort: 1, EthDst: mac, VLAN: 100, IPProto: 6, TPDst: 80}}
+	rec := r.recipe()
+	if rec.Priority != 7 || rec.Cookie != 9 || len(rec.Outputs) != 1 || rec.Outputs[0] != 3 {
+		t.Errorf("Unexpected recipe %+v", rec)
+	}
+	m := rec.Match
+	if m.InPort != 1 || m.EthDst.String() != mac.String() || m.VLAN != 100 || m.IPProto != 6 || m.TPDst != 80 {
+		t.Errorf("Unexpected match %+v", m)
+	}
+	if err := rec.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	n := New(nil)
+	sub := n.Subscribe(4, "api.test")
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	n.Publish("api.test.event", dpid, 5)
+	select {
+	case e := <-sub.Events():
+		if e.Type != "api.test.event" || e.DPID.String() != dpid.String() || e.Data != 5 {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	sub.Cancel()
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Error("Unexpected event after Cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("Events not closed by Cancel")
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Returns the Network of controller c.
+func New(c *ogo.Controller) Network {
+	return &network{c}
+}
+
+// Returns sw as a Switch, for code moving from package ogo.
+func Wrap(sw *ogo.OFSwitch) Switch {
+	return &ofSwitch{sw}
+}
+
+// Returns the switch of package ogo behind sw, for the calls this
+// package doesn't offer yet.
+func Unwrap(sw Switch) (*ogo.OFSwitch, bool) {
+	if s, ok := sw.(*ofSwitch); ok {
+		return s.sw, true
+	}
+	return nil, false
+}
+
+type network struct {
+	c *ogo.Controller
+}
+
+func (n *network) Switch(dpid net.HardwareAddr) (Switch, bool) {
+	sw, ok := ogo.Switch(dpid)
+	if !ok {
+		return nil, false
+	}
+	return Wrap(sw), true
+}
+
+func (n *network) Switches() []Switch {
+	sws := ogo.Switches()
+	sort.Sort(byDPID(sws))
+	a := make([]Switch, len(sws))
+	for i, sw := range sws {
+		a[i] = Wrap(sw)
+	}
+	return a
+}
+
+type byDPID []*ogo.OFSwitch
+
+func (a byDPID) Len() int           { return len(a) }
+func (a byDPID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDPID) Less(i, j int) bool { return bytes.Compare(a[i].DPID(), a[j].DPID()) < 0 }
+
+func (n *network) Topology() Topology {
+	t := ogo.CurrentTopology()
+	r := Topology{Switches: make([]net.HardwareAddr, 0, len(t.Switches)),
+		Links: make([]TopologyLink, 0, len(t.Links)), Hosts: make([]Host, 0, len(t.Hosts))}
+	for _, s := range t.Switches {
+		if dpid, err := net.ParseMAC(s.DPID); err == nil {
+			r.Switches = append(r.Switches, dpid)
+		}
+	}
+	for _, l := range t.Links {
+		src, err1 := net.ParseMAC(l.Src)
+		dst, err2 := net.ParseMAC(l.Dst)
+		if err1 == nil && err2 == nil {
+			r.Links = append(r.Links, TopologyLink{src, l.SrcPort, dst, time.Duration(l.Latency), l.Indirect,
+				l.Capacity, l.Load})
+		}
+	}
+	for _, h := range t.Hosts {
+		mac, err1 := net.ParseMAC(h.MAC)
+		dpid, err2 := net.ParseMAC(h.DPID)
+		if err1 == nil && err2 == nil {
+			r.Hosts = append(r.Hosts, Host{mac, dpid, h.Port})
+		}
+	}
+	return r
+}
+
+func (n *network) Subscribe(buffer int, prefixes ...string) Subscription {
+	s := &subscription{ogo.Subscribe(buffer, prefixes...), make(chan Event, buffer)}
+	go s.forward()
+	return s
+}
+
+func (n *network) Publish(eventType string, dpid net.HardwareAddr, data interface{}) {
+	ogo.Publish(eventType, dpid, data)
+}
+
+func (n *network) HandlePacketIns(name string, priority int, h PacketInHandler) {
+	n.c.AddPacketInHandler(name, priority, ogo.PacketInHandlerFunc(
+		func(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+			sw, ok := ogo.Switch(dpid)
+			if !ok {
+				return false
+			}
+			return h.HandlePacketIn(Wrap(sw), pkt)
+		}))
+}
+
+type subscription struct {
+	sub *ogo.Subscription
+	c   chan Event
+}
+
+// Copies events from the bus until the subscription is
+// cancelled, dropping them if c is full like the bus does.
+func (s *subscription) forward() {
+	for e := range s.sub.C {
+		select {
+		case s.c <- Event{e.Type, e.DPID, e.Time, e.Data}:
+		default:
+		}
+	}
+	close(s.c)
+}
+
+func (s *subscription) Events() <-chan Event {
+	return s.c
+}
+
+func (s *subscription) Cancel() {
+	s.sub.Cancel()
+}
+
+type ofSwitch struct {
+	sw *ogo.OFSwitch
+}
+
+func (s *ofSwitch) DPID() net.HardwareAddr { return s.sw.DPID() }
+func (s *ofSwitch) Version() uint8         { return s.sw.Version() }
+
+func (s *ofSwitch) Ports() []Port {
+	ports := s.sw.Ports()
+	a := make([]Port, 0, len(ports))
+	for _, p := range ports {
+		a = append(a, Port{Number: p.PortNo, Name: strings.TrimRight(string(p.Name), "\x00"),
+			HWAddr: p.HWAddr, Up: p.Config&ofp10.PC_PORT_DOWN == 0 && p.State&ofp10.PS_LINK_DOWN == 0})
+	}
+	sort.Sort(portsByNumber(a))
+	return a
+}
+
+type portsByNumber []Port
+
+func (a portsByNumber) Len() int           { return len(a) }
+func (a portsByNumber) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a portsByNumber) Less(i, j int) bool { return a[i].Number < a[j].Number }
+
+func (s *ofSwitch) Links() []Link {
+	links := s.sw.Links()
+	a := make([]Link, len(links))
+	for i, l := range links {
+		a[i] = Link{l.Port, l.DPID, l.Latency, l.Indirect}
+	}
+	return a
+}
+
+func (s *ofSwitch) Send(msg util.Message) error {
+	return s.sw.Send(msg)
+}
+
+func (s *ofSwitch) Request(msg util.Message, timeout time.Duration) (util.Message, error) {
+	return s.sw.SendAndReceive(msg, timeout)
+}
+
+func (s *ofSwitch) Install(r Rule) error {
+	return s.sw.InstallRecipe(r.recipe())
+}
+
+func (s *ofSwitch) Remove(r Rule) error {
+	return s.sw.RemoveRecipe(r.recipe())
+}
+
+func (r Rule) recipe() *ogo.Recipe {
+	m := r.Match
+	return &ogo.Recipe{Priority: r.Priority, Cookie: r.Cookie, IdleTimeout: r.IdleTimeout,
+		HardTimeout: r.HardTimeout, SetEthDst: r.SetEthDst, SetIPDst: r.SetIPDst, Outputs: r.Outputs,
+		Match: ogo.FlowMatch{InPort: m.InPort, EthSrc: m.EthSrc, EthDst: m.EthDst, EthType: m.EthType,
+			VLAN: m.VLAN, IPSrc: m.IPSrc, IPSrcMask: m.IPSrcMask, IPDst: m.IPDst, IPDstMask: m.IPDstMask,
+			IPProto: m.IPProto, TPSrc: m.TPSrc, TPDst: m.TPDst}}
+}
@@ -0,0 +1,93 @@
+package ogo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+)
+
+// An AsyncPolicy selects which asynchronous messages a switch
+// delivers to the controller. Each mask is a bitmap of reasons,
+// e.g. 1<<ofp14.R_TABLE_MISS for packet-ins. Index 0 applies
+// when the controller is master or equal, index 1 when it is a
+// slave. Only OpenFlow 1.3+ switches support async configuration.
+type AsyncPolicy struct {
+	PacketIn    [2]uint32
+	PortStatus  [2]uint32
+	FlowRemoved [2]uint32
+}
+
+// The switch defaults from the OpenFlow specification. A master
+// receives everything, a slave only receives port status.
+var DefaultAsyncPolicy = AsyncPolicy{
+	PacketIn:    [2]uint32{1<<ofp14.R_TABLE_MISS | 1<<ofp14.R_APPLY_ACTION, 0},
+	PortStatus:  [2]uint32{1<<ofp14.PR_ADD | 1<<ofp14.PR_DELETE | 1<<ofp14.PR_MODIFY, 1<<ofp14.PR_ADD | 1<<ofp14.PR_DELETE | 1<<ofp14.PR_MODIFY},
+	FlowRemoved: [2]uint32{1<<ofp14.FRR_IDLE_TIMEOUT | 1<<ofp14.FRR_HARD_TIMEOUT | 1<<ofp14.FRR_DELETE, 0},
+}
+
+var errAsyncUnsupported = errors.New("Async configuration requires OpenFlow 1.3 or later.")
+
+// Configures which asynchronous messages Switch s sends to the
+// controller.
+func (s *OFSwitch) SetAsync(p AsyncPolicy) error {
+	switch s.Version() {
+	case ofp13.VERSION:
+		a := ofp13.NewSetAsync()
+		a.PacketInMask = p.PacketIn
+		a.PortStatusMask = p.PortStatus
+		a.FlowRemovedMask = p.FlowRemoved
+		return s.Send(a)
+	case ofp14.VERSION, ofp15.VERSION:
+		a := ofp14.NewSetAsync()
+		a.Header.Version = s.Version()
+		a.AddProperty(ofp14.ACPT_PACKET_IN_MASTER, p.PacketIn[0])
+		a.AddProperty(ofp14.ACPT_PACKET_IN_SLAVE, p.PacketIn[1])
+		a.AddProperty(ofp14.ACPT_PORT_STATUS_MASTER, p.PortStatus[0])
+		a.AddProperty(ofp14.ACPT_PORT_STATUS_SLAVE, p.PortStatus[1])
+		a.AddProperty(ofp14.ACPT_FLOW_REMOVED_MASTER, p.FlowRemoved[0])
+		a.AddProperty(ofp14.ACPT_FLOW_REMOVED_SLAVE, p.FlowRemoved[1])
+		return s.Send(a)
+	}
+	return errAsyncUnsupported
+}
+
+// Returns the asynchronous message configuration of Switch s.
+func (s *OFSwitch) GetAsync(timeout time.Duration) (p AsyncPolicy, err error) {
+	switch s.Version() {
+	case ofp13.VERSION:
+		rep, e := s.SendAndReceive(ofp13.NewGetAsyncRequest(), timeout)
+		if e != nil {
+			return p, e
+		}
+		a, ok := rep.(*ofp13.AsyncConfig)
+		if !ok {
+			return p, errors.New("Unexpected reply to get async request.")
+		}
+		p.PacketIn = a.PacketInMask
+		p.PortStatus = a.PortStatusMask
+		p.FlowRemoved = a.FlowRemovedMask
+	case ofp14.VERSION, ofp15.VERSION:
+		req := ofp14.NewGetAsyncRequest()
+		req.Version = s.Version()
+		rep, e := s.SendAndReceive(req, timeout)
+		if e != nil {
+			return p, e
+		}
+		a, ok := rep.(*ofp14.AsyncConfig)
+		if !ok {
+			return p, errors.New("Unexpected reply to get async request.")
+		}
+		p.PacketIn[0], _ = a.Property(ofp14.ACPT_PACKET_IN_MASTER)
+		p.PacketIn[1], _ = a.Property(ofp14.ACPT_PACKET_IN_SLAVE)
+		p.PortStatus[0], _ = a.Property(ofp14.ACPT_PORT_STATUS_MASTER)
+		p.PortStatus[1], _ = a.Property(ofp14.ACPT_PORT_STATUS_SLAVE)
+		p.FlowRemoved[0], _ = a.Property(ofp14.ACPT_FLOW_REMOVED_MASTER)
+		p.FlowRemoved[1], _ = a.Property(ofp14.ACPT_FLOW_REMOVED_SLAVE)
+	default:
+		err = errAsyncUnsupported
+	}
+	return
+}
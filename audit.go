@@ -0,0 +1,210 @@
+package ogo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The number of removals kept for Removed.
+var MaxAuditRecords = 100
+
+// A switch removed by a SwitchAudit. Reason is "error" for a
+// failed connection, with the error in Error, "probe" for a
+// connection closed after a missed probe, and "closed" for a
+// connection closed by the controller.
+type AuditRecord struct {
+	DPID    string    `json:"dpid"`
+	Addr    string    `json:"addr"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+	Down    time.Time `json:"down,omitempty"`
+	Removed time.Time `json:"removed"`
+}
+
+// A SwitchAudit periodically checks the connection of every
+// switch in the network and removes the dead ones, so Switches
+// only returns switches that can be talked to.
+//
+// Every Interval each connected switch is probed with a barrier
+// request, and its connection is closed if no answer arrives
+// within ProbeTimeout. A switch whose connection failed, through
+// a read or write error or a missed probe, is kept for Grace so
+// it can reconnect and keep its links and flow shadow, and is
+// removed after that. Removals are logged, published as
+// EventSwitchRemoved, counted in the metrics, and served as JSON
+// by ServeHTTP.
+type SwitchAudit struct {
+	Interval     time.Duration
+	Grace        time.Duration
+	ProbeTimeout time.Duration
+
+	mu      sync.Mutex
+	removed []AuditRecord
+	done    chan bool
+}
+
+// Removal counters by reason of running audits, for the metrics
+// endpoint.
+var auditRemovals = struct {
+	sync.Mutex
+	m map[string]uint64
+}{m: make(map[string]uint64)}
+
+var errProbeTimeout = errors.New("No answer to a probe.")
+
+func NewSwitchAudit() *SwitchAudit {
+	a := new(SwitchAudit)
+	a.Interval = time.Second * 10
+	a.Grace = time.Minute
+	a.ProbeTimeout = time.Second * 5
+	a.removed = make([]AuditRecord, 0)
+	return a
+}
+
+func (a *SwitchAudit) Start() {
+	a.done = make(chan bool)
+	go func() {
+		t := time.NewTicker(a.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				a.Audit()
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+func (a *SwitchAudit) Stop() {
+	if a.done != nil {
+		close(a.done)
+	}
+}
+
+// Checks every switch once.
+func (a *SwitchAudit) Audit() {
+	var wg sync.WaitGroup
+	for _, sw := range Switches() {
+		wg.Add(1)
+		go func(sw *OFSwitch) {
+			defer wg.Done()
+			a.check(sw)
+		}(sw)
+	}
+	wg.Wait()
+}
+
+func (a *SwitchAudit) check(sw *OFSwitch) {
+	if sw.connected() {
+		if _, err := sw.SendAndReceive(sw.newBarrierRequest(), a.ProbeTimeout); err == ErrRequestTimeout {
+			log.Println("Switch", SwitchLabel(sw.DPID()), "missed a probe, closing its connection.")
+			sw.downMu.Lock()
+			sw.downErr, sw.downAt = errProbeTimeout, time.Now()
+			sw.downMu.Unlock()
+			stream, _ := sw.session()
+			stream.Close()
+		}
+		return
+	}
+	sw.downMu.Lock()
+	err, at := sw.downErr, sw.downAt
+	sw.downMu.Unlock()
+	if !at.IsZero() && time.Since(at) < a.Grace {
+		return
+	}
+	if at.IsZero() {
+		// The receive loop hasn't seen the failure yet.
+		sw.downMu.Lock()
+		if sw.downAt.IsZero() {
+			sw.downAt = time.Now()
+		}
+		sw.downMu.Unlock()
+		return
+	}
+	if !removeSwitch(sw) {
+		return
+	}
+	stream, _ := sw.session()
+	r := AuditRecord{DPID: sw.DPID().String(), Addr: fmt.Sprint(stream.GetAddr()),
+		Reason: "closed", Down: at, Removed: time.Now()}
+	switch {
+	case err == errProbeTimeout:
+		r.Reason = "probe"
+	case err != nil:
+		r.Reason = "error"
+		r.Error = err.Error()
+	}
+	a.record(sw, r)
+}
+
+// Removes sw from the network unless it reconnected. Returns
+// true if it was removed.
+func removeSwitch(sw *OFSwitch) bool {
+	topology.Lock()
+	shard := network.shard(sw.DPID())
+	shard.Lock()
+	defer shard.Unlock()
+	if shard.switches[sw.DPID().String()] != sw || sw.connected() {
+		topology.unlock(false)
+		return false
+	}
+	defer topology.unlock(true)
+	sw.auxMu.Lock()
+	for _, s := range sw.aux {
+		s.Close()
+	}
+	sw.auxMu.Unlock()
+	shard.set(sw.DPID().String(), nil)
+	return true
+}
+
+func (a *SwitchAudit) record(sw *OFSwitch, r AuditRecord) {
+	log.Println("Removed dead switch", SwitchLabel(sw.DPID()), "at", r.Addr, r.Reason, r.Error)
+	a.mu.Lock()
+	a.removed = append(a.removed, r)
+	if len(a.removed) > MaxAuditRecords {
+		a.removed = a.removed[len(a.removed)-MaxAuditRecords:]
+	}
+	a.mu.Unlock()
+	auditRemovals.Lock()
+	auditRemovals.m[r.Reason] += 1
+	auditRemovals.Unlock()
+	Publish(EventSwitchRemoved, sw.DPID(), r)
+}
+
+// Returns the recent removals, oldest first.
+func (a *SwitchAudit) Removed() []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditRecord(nil), a.removed...)
+}
+
+func (a *SwitchAudit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Removed())
+}
+
+// Writes the number of switches removed by audits, by reason, in
+// the Prometheus text format.
+func writeAuditMetrics(w io.Writer) {
+	auditRemovals.Lock()
+	defer auditRemovals.Unlock()
+	reasons := make([]string, 0, len(auditRemovals.m))
+	for r := range auditRemovals.m {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	fmt.Fprintln(w, "# TYPE ogo_switches_removed_total counter")
+	for _, r := range reasons {
+		fmt.Fprintf(w, "ogo_switches_removed_total{reason=%q} %d\n", r, auditRemovals.m[r])
+	}
+}
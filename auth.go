@@ -0,0 +1,252 @@
+package ogo
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+const (
+	EventAuthGranted = "auth.granted"
+	EventAuthRevoked = "auth.revoked"
+)
+
+// Priority of the flows sending the traffic of guarded ports to
+// the controller. Authenticated hosts get flows one above it.
+var AuthPriority uint16 = 0xb000
+
+// An authenticated host.
+type AuthSession struct {
+	MAC net.HardwareAddr
+	// Where the host was last seen, nil until it sends.
+	DPID    net.HardwareAddr
+	Port    uint16
+	Expires time.Time
+}
+
+// An AuthGate keeps the hosts on guarded access ports off the
+// network until they are authenticated, in the manner of
+// 802.1X. All traffic arriving on a guarded port is sent to the
+// controller and dropped, except for that of hosts with a
+// session, which get a flow forwarding their traffic out
+// Forward. Sessions expire after their timeout or when revoked,
+// removing the flow.
+//
+// It serves an HTTP API: GET returns the sessions, POST
+// authenticates and DELETE revokes the host in a body like
+// {"mac": "00:00:00:00:00:0a", "timeout": "1h"}.
+type AuthGate struct {
+	// Ports the traffic of authenticated hosts is sent out of,
+	// ofp10.P_NORMAL by default.
+	Forward []uint16
+	// Session length if not given when authenticating.
+	SessionTimeout time.Duration
+	mu             sync.Mutex
+	guarded        map[string]map[uint16]bool
+	sessions       map[string]*AuthSession
+	stop           chan struct{}
+}
+
+func NewAuthGate() *AuthGate {
+	g := new(AuthGate)
+	g.Forward = []uint16{ofp10.P_NORMAL}
+	g.SessionTimeout = time.Hour * 8
+	g.guarded = make(map[string]map[uint16]bool)
+	g.sessions = make(map[string]*AuthSession)
+	g.stop = make(chan struct{})
+	return g
+}
+
+// Starts gating packet-ins on c and expiring sessions.
+func (g *AuthGate) Attach(c *Controller) {
+	c.AddPacketInHandler("auth", 1<<29+1, g)
+	go g.expireLoop()
+}
+
+func (g *AuthGate) Stop() {
+	close(g.stop)
+}
+
+// Guards port of Switch dpid.
+func (g *AuthGate) Guard(dpid net.HardwareAddr, port uint16) error {
+	g.mu.Lock()
+	if _, ok := g.guarded[dpid.String()]; !ok {
+		g.guarded[dpid.String()] = make(map[uint16]bool)
+	}
+	g.guarded[dpid.String()][port] = true
+	g.mu.Unlock()
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	return sw.installOutputs(FlowMatch{InPort: port}, AuthPriority, []uint16{ofp10.P_CONTROLLER})
+}
+
+// Stops guarding port of Switch dpid.
+func (g *AuthGate) Unguard(dpid net.HardwareAddr, port uint16) error {
+	g.mu.Lock()
+	delete(g.guarded[dpid.String()], port)
+	g.mu.Unlock()
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	return sw.installOutputs(FlowMatch{InPort: port}, AuthPriority, nil)
+}
+
+// Authenticates the host mac for timeout, or SessionTimeout if
+// timeout is 0. Authenticating a host with a session extends it.
+func (g *AuthGate) Authenticate(mac net.HardwareAddr, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = g.SessionTimeout
+	}
+	g.mu.Lock()
+	s, ok := g.sessions[mac.String()]
+	if !ok {
+		s = &AuthSession{MAC: mac}
+		g.sessions[mac.String()] = s
+	}
+	s.Expires = time.Now().Add(timeout)
+	session := *s
+	g.mu.Unlock()
+	if !ok {
+		Publish(EventAuthGranted, session.DPID, session)
+	}
+}
+
+// Ends the session of host mac.
+func (g *AuthGate) Revoke(mac net.HardwareAddr) {
+	g.mu.Lock()
+	s, ok := g.sessions[mac.String()]
+	delete(g.sessions, mac.String())
+	g.mu.Unlock()
+	if ok {
+		g.end(*s)
+	}
+}
+
+// Returns the sessions.
+func (g *AuthGate) Sessions() []AuthSession {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a := make([]AuthSession, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		a = append(a, *s)
+	}
+	return a
+}
+
+func (g *AuthGate) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	mac := pkt.Data.HWSrc
+	g.mu.Lock()
+	if !g.guarded[dpid.String()][pkt.InPort] {
+		g.mu.Unlock()
+		return false
+	}
+	s, ok := g.sessions[mac.String()]
+	if !ok {
+		g.mu.Unlock()
+		// Unauthenticated traffic goes no further.
+		return true
+	}
+	moved := s.DPID == nil || s.DPID.String() != dpid.String() || s.Port != pkt.InPort
+	old := *s
+	s.DPID = dpid
+	s.Port = pkt.InPort
+	g.mu.Unlock()
+
+	if moved {
+		if old.DPID != nil {
+			g.end(old)
+		}
+		if sw, ok := Switch(dpid); ok {
+			m := FlowMatch{InPort: pkt.InPort, EthSrc: mac}
+			if err := sw.installOutputs(m, AuthPriority+1, g.Forward); err != nil {
+				log.Println("Failed to admit", mac, "on", SwitchLabel(dpid), err)
+			}
+		}
+	}
+	// Let the rest of the chain handle the frame in flight.
+	return false
+}
+
+// Removes the flow of session s.
+func (g *AuthGate) end(s AuthSession) {
+	Publish(EventAuthRevoked, s.DPID, s)
+	if s.DPID == nil {
+		return
+	}
+	if sw, ok := Switch(s.DPID); ok {
+		sw.installOutputs(FlowMatch{InPort: s.Port, EthSrc: s.MAC}, AuthPriority+1, nil)
+	}
+}
+
+func (g *AuthGate) expireLoop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			expired := make([]AuthSession, 0)
+			g.mu.Lock()
+			for k, s := range g.sessions {
+				if now.After(s.Expires) {
+					expired = append(expired, *s)
+					delete(g.sessions, k)
+				}
+			}
+			g.mu.Unlock()
+			for _, s := range expired {
+				g.end(s)
+			}
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+type authRequest struct {
+	MAC     string `json:"mac"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (g *AuthGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g.Sessions())
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req authRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mac, err := net.ParseMAC(req.MAC)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == "DELETE" {
+		g.Revoke(mac)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	g.Authenticate(mac, timeout)
+	w.WriteHeader(http.StatusNoContent)
+}
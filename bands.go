@@ -0,0 +1,167 @@
+package ogo
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A PriorityBand is a range of flow priorities in a table
+// reserved for one application, which is identified by the
+// cookies registered for it with RegisterFlowOwner.
+type PriorityBand struct {
+	App   string `json:"app"`
+	Table uint8  `json:"table"`
+	Min   uint16 `json:"min"`
+	Max   uint16 `json:"max"`
+}
+
+// Returns the priority offset above the bottom of band b,
+// clamped to the band.
+func (b PriorityBand) Priority(offset uint16) uint16 {
+	if offset > b.Max-b.Min {
+		return b.Max
+	}
+	return b.Min + offset
+}
+
+func (b PriorityBand) contains(table uint8, priority uint16) bool {
+	return b.Table == table && priority >= b.Min && priority <= b.Max
+}
+
+// Returned when a flow is added outside the bands of its owner,
+// or inside the band of another application.
+type PriorityBandError struct {
+	App      string
+	Table    uint8
+	Priority uint16
+	Band     *PriorityBand
+}
+
+func (e *PriorityBandError) Error() string {
+	if e.Band == nil {
+		return fmt.Sprintf("Priority %d in table %d is outside the priority bands of %s.",
+			e.Priority, e.Table, e.App)
+	}
+	owner := "flows of " + e.App
+	if e.App == "" {
+		owner = "flows without an owner"
+	}
+	return fmt.Sprintf("Priority %d in table %d is reserved for %s (%d-%d), not %s.",
+		e.Priority, e.Table, e.Band.App, e.Band.Min, e.Band.Max, owner)
+}
+
+var bands = struct {
+	sync.RWMutex
+	a []PriorityBand
+}{}
+
+// Reserves priorities min to max of table for app. Once an app
+// has a band in a table, flows it adds or modifies there must
+// use a priority in one of its bands, and no other flow may use
+// a priority in them. Flows belong to the app through their
+// cookie, see RegisterFlowOwner. Deletes aren't checked.
+func ReservePriorityBand(app string, table uint8, min, max uint16) (PriorityBand, error) {
+	b := PriorityBand{app, table, min, max}
+	if min > max {
+		return b, fmt.Errorf("Priority band %d-%d is empty.", min, max)
+	}
+	bands.Lock()
+	defer bands.Unlock()
+	for _, o := range bands.a {
+		if o.Table == table && o.Min <= max && min <= o.Max {
+			if o == b {
+				return b, nil
+			}
+			return b, fmt.Errorf("Priority band %d-%d of table %d overlaps %d-%d of %s.",
+				min, max, table, o.Min, o.Max, o.App)
+		}
+	}
+	bands.a = append(bands.a, b)
+	return b, nil
+}
+
+// Releases the priority bands of app.
+func ReleasePriorityBands(app string) {
+	bands.Lock()
+	defer bands.Unlock()
+	a := bands.a[:0]
+	for _, b := range bands.a {
+		if b.App != app {
+			a = append(a, b)
+		}
+	}
+	bands.a = a
+}
+
+// Returns the reserved priority bands by table and priority.
+func PriorityBands() []PriorityBand {
+	bands.RLock()
+	a := append([]PriorityBand(nil), bands.a...)
+	bands.RUnlock()
+	sort.Sort(priorityBands(a))
+	return a
+}
+
+// Checks that app may use priority in table.
+func CheckPriority(app string, table uint8, priority uint16) error {
+	bands.RLock()
+	defer bands.RUnlock()
+	owns := false
+	for _, b := range bands.a {
+		if b.contains(table, priority) {
+			if b.App == app {
+				return nil
+			}
+			return &PriorityBandError{app, table, priority, &b}
+		}
+		owns = owns || (b.App == app && b.Table == table)
+	}
+	if owns {
+		return &PriorityBandError{app, table, priority, nil}
+	}
+	return nil
+}
+
+// Checks a flow mod against the priority bands.
+func checkFlowModPriority(msg util.Message) error {
+	var table uint8
+	var priority uint16
+	var cookie uint64
+	switch f := msg.(type) {
+	case *ofp10.FlowMod:
+		if f.Command != ofp10.FC_ADD && f.Command != ofp10.FC_MODIFY && f.Command != ofp10.FC_MODIFY_STRICT {
+			return nil
+		}
+		priority, cookie = f.Priority, f.Cookie
+	case *ofp14.FlowMod:
+		if f.Command != ofp14.FC_ADD && f.Command != ofp14.FC_MODIFY && f.Command != ofp14.FC_MODIFY_STRICT {
+			return nil
+		}
+		table, priority, cookie = f.TableId, f.Priority, f.Cookie
+	default:
+		return nil
+	}
+	bands.RLock()
+	empty := len(bands.a) == 0
+	bands.RUnlock()
+	if empty {
+		return nil
+	}
+	return CheckPriority(FlowOwner(cookie), table, priority)
+}
+
+type priorityBands []PriorityBand
+
+func (a priorityBands) Len() int      { return len(a) }
+func (a priorityBands) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a priorityBands) Less(i, j int) bool {
+	if a[i].Table != a[j].Table {
+		return a[i].Table < a[j].Table
+	}
+	return a[i].Min < a[j].Min
+}
@@ -0,0 +1,160 @@
+package ogo
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/jonstout/ogo/ovsdb"
+)
+
+// BFD transmit and receive intervals in milliseconds. A link is
+// declared down after three intervals without a packet.
+var (
+	BFDMinTx = 100
+	BFDMinRx = 100
+)
+
+// BFD runs Bidirectional Forwarding Detection on the links
+// between Open vSwitch switches, configured through OVSDB. Links
+// with BFD are taken down as soon as Open vSwitch reports them
+// not forwarding, instead of after LinkTimeout. Switches without
+// OVSDB access keep relying on link discovery timeouts.
+type BFD struct {
+	mu       sync.Mutex
+	switches map[string]*bfdSwitch
+	sub      *Subscription
+}
+
+type bfdSwitch struct {
+	dpid net.HardwareAddr
+	db   *ovsdb.Client
+	// Whether BFD reported each OpenFlow port forwarding.
+	forwarding map[uint16]bool
+}
+
+func NewBFD() *BFD {
+	b := new(BFD)
+	b.switches = make(map[string]*bfdSwitch)
+	return b
+}
+
+// Starts enabling BFD on links as they are discovered.
+func (b *BFD) Start() {
+	b.sub = Subscribe(64, EventLinkUp)
+	go func() {
+		for e := range b.sub.C {
+			if l, ok := e.Data.(Link); ok {
+				b.enable(e.DPID, l.Port)
+			}
+		}
+	}()
+}
+
+func (b *BFD) Stop() {
+	if b.sub != nil {
+		b.sub.Cancel()
+	}
+}
+
+// Adds Switch dpid, whose OVSDB server db is connected to, and
+// enables BFD on its known links. db must not be used for other
+// monitors.
+func (b *BFD) AddSwitch(dpid net.HardwareAddr, db *ovsdb.Client) error {
+	s := &bfdSwitch{dpid, db, make(map[uint16]bool)}
+	b.mu.Lock()
+	b.switches[dpid.String()] = s
+	b.mu.Unlock()
+
+	tables := map[string][]string{"Interface": {"ofport", "bfd_status"}}
+	initial, err := db.Monitor("Open_vSwitch", "ogo-bfd", tables, func(_ string, u ovsdb.TableUpdates) {
+		b.update(s, u)
+	})
+	if err != nil {
+		return err
+	}
+	b.update(s, initial)
+
+	if sw, ok := Switch(dpid); ok {
+		for _, l := range sw.Links() {
+			if err := b.enable(dpid, l.Port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Enables BFD on the interface with OpenFlow port number port.
+func (b *BFD) enable(dpid net.HardwareAddr, port uint16) error {
+	b.mu.Lock()
+	s, ok := b.switches[dpid.String()]
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	where := []ovsdb.Condition{ovsdb.Where("ofport", "==", int(port))}
+	row := map[string]interface{}{
+		"bfd": ovsdb.Map(map[string]string{
+			"enable": "true",
+			"min_tx": fmt.Sprint(BFDMinTx),
+			"min_rx": fmt.Sprint(BFDMinRx),
+		}),
+	}
+	if _, err := s.db.Transact("Open_vSwitch", ovsdb.Update("Interface", where, row)); err != nil {
+		log.Println("Failed to enable BFD on", SwitchLabel(dpid), port, err)
+		return err
+	}
+	if sw, ok := Switch(dpid); ok {
+		sw.setLinkBFD(port, true)
+	}
+	return nil
+}
+
+// Takes down the links of s whose interfaces BFD reports as no
+// longer forwarding.
+func (b *BFD) update(s *bfdSwitch, u ovsdb.TableUpdates) {
+	for _, row := range u["Interface"] {
+		if row.New == nil {
+			continue
+		}
+		n, ok := row.New["ofport"].(float64)
+		if !ok || n <= 0 {
+			continue
+		}
+		port := uint16(n)
+		status := ovsdb.ParseMap(row.New["bfd_status"])
+		if _, ok := status["forwarding"]; !ok {
+			continue
+		}
+		forwarding := status["forwarding"] == "true"
+
+		b.mu.Lock()
+		was, seen := s.forwarding[port]
+		s.forwarding[port] = forwarding
+		b.mu.Unlock()
+		if forwarding || (seen && !was) {
+			continue
+		}
+		if sw, ok := Switch(s.dpid); ok {
+			for _, l := range sw.Links() {
+				if l.Port == port {
+					sw.deleteLink(l.DPID)
+				}
+			}
+		}
+	}
+}
+
+// Marks the link of Switch s out port as tracked by BFD, or not.
+func (s *OFSwitch) setLinkBFD(port uint16, bfd bool) {
+	s.linksMu.Lock()
+	defer s.linksMu.Unlock()
+	for _, l := range s.links {
+		if l.Port == port {
+			l.BFD = bfd
+		}
+	}
+}
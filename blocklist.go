@@ -0,0 +1,410 @@
+package ogo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Priority of the flows dropping blocked traffic.
+var BlocklistPriority uint16 = 0xf000
+
+// The cookie of blocklist flows is BlocklistCookie with the id
+// of their entry in the low 32 bits.
+var BlocklistCookie uint64 = 0xb1 << 56
+
+const blocklistCookieMask = 0xffffffff00000000
+
+// An address blocked by a Blocklist: a MAC address, an IPv4
+// address or an IPv4 prefix like 10.1.0.0/16. Traffic from and
+// to it is dropped on the switches in DPIDs, or on every switch
+// if it's empty.
+type BlockEntry struct {
+	Id      int      `json:"id"`
+	Address string   `json:"address"`
+	DPIDs   []string `json:"dpids,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// A blocked address with the traffic dropped because of it,
+// summed over the switches.
+type BlockStatus struct {
+	BlockEntry
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// A Blocklist drops the traffic of blocked addresses across the
+// network. Every entry becomes a pair of drop flows, matching
+// the address as source and as destination, installed with
+// BlocklistPriority on the switches of the entry. Switches get
+// the flows again whenever they connect.
+//
+// It serves an HTTP API: GET lists the entries with their hit
+// counts, POST blocks the BlockEntry in the body and replies
+// with it, and DELETE with an id parameter unblocks an entry.
+type Blocklist struct {
+	mu      sync.Mutex
+	entries map[int]*blockEntry
+	nextId  int
+	sub     *Subscription
+}
+
+type blockEntry struct {
+	BlockEntry
+	mac net.HardwareAddr
+	ip  *net.IPNet
+}
+
+func NewBlocklist() *Blocklist {
+	b := new(Blocklist)
+	b.entries = make(map[int]*blockEntry)
+	b.nextId = 1
+	return b
+}
+
+func (b *Blocklist) Start() {
+	RegisterFlowOwner("blocklist", BlocklistCookie, blocklistCookieMask)
+	b.sub = Subscribe(64, EventSwitchUp)
+	go func() {
+		for {
+			select {
+			case e, ok := <-b.sub.C:
+				if !ok {
+					return
+				}
+				if sw, ok := Switch(e.DPID); ok {
+					b.installAll(sw)
+				}
+			case <-b.sub.Lost:
+				// A switch.up may be among the events
+				// dropped.
+				for _, sw := range Switches() {
+					b.installAll(sw)
+				}
+			}
+		}
+	}()
+	for _, sw := range Switches() {
+		go b.installAll(sw)
+	}
+}
+
+func (b *Blocklist) Stop() {
+	if b.sub != nil {
+		b.sub.Cancel()
+	}
+}
+
+// Blocks the address of e, returning the entry with its id.
+func (b *Blocklist) Block(e BlockEntry) (BlockEntry, error) {
+	entry, err := newBlockEntry(e)
+	if err != nil {
+		return e, err
+	}
+	b.mu.Lock()
+	entry.Id = b.nextId
+	b.nextId += 1
+	b.entries[entry.Id] = entry
+	b.mu.Unlock()
+	for _, sw := range Switches() {
+		if entry.on(sw.DPID()) {
+			b.install(sw, entry, false)
+		}
+	}
+	return entry.BlockEntry, nil
+}
+
+// Unblocks entry id.
+func (b *Blocklist) Unblock(id int) bool {
+	b.mu.Lock()
+	entry, ok := b.entries[id]
+	delete(b.entries, id)
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+	for _, sw := range Switches() {
+		if entry.on(sw.DPID()) {
+			b.install(sw, entry, true)
+		}
+	}
+	return true
+}
+
+// Returns the entries by id.
+func (b *Blocklist) Entries() []BlockEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]int, 0, len(b.entries))
+	for id := range b.entries {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	a := make([]BlockEntry, len(ids))
+	for i, id := range ids {
+		a[i] = b.entries[id].BlockEntry
+	}
+	return a
+}
+
+// Returns the entries by id, with the traffic their flows
+// dropped. Switches that don't answer within timeout aren't
+// counted.
+func (b *Blocklist) Status(timeout time.Duration) []BlockStatus {
+	entries := b.Entries()
+	hits := make(map[int]*BlockStatus)
+	a := make([]BlockStatus, len(entries))
+	for i, e := range entries {
+		a[i].BlockEntry = e
+		hits[e.Id] = &a[i]
+	}
+	for _, sw := range Switches() {
+		counts, err := sw.cookieCounters(BlocklistCookie, blocklistCookieMask, timeout)
+		if err != nil {
+			continue
+		}
+		for cookie, c := range counts {
+			if s, ok := hits[int(cookie&^blocklistCookieMask)]; ok {
+				s.Packets += c[0]
+				s.Bytes += c[1]
+			}
+		}
+	}
+	return a
+}
+
+func newBlockEntry(e BlockEntry) (*blockEntry, error) {
+	entry := &blockEntry{BlockEntry: e}
+	for i, d := range e.DPIDs {
+		mac, err := net.ParseMAC(d)
+		if err != nil {
+			return nil, err
+		}
+		entry.DPIDs[i] = mac.String()
+	}
+	if mac, err := net.ParseMAC(e.Address); err == nil {
+		entry.mac = mac
+		entry.Address = mac.String()
+		return entry, nil
+	}
+	if !strings.Contains(e.Address, "/") {
+		e.Address += "/32"
+	}
+	_, ip, err := net.ParseCIDR(e.Address)
+	if err != nil || ip.IP.To4() == nil {
+		return nil, fmt.Errorf("Bad address %q, expected a MAC address or an IPv4 address or prefix.", e.Address)
+	}
+	entry.ip = ip
+	entry.Address = ip.String()
+	if ones, _ := ip.Mask.Size(); ones == 32 {
+		entry.Address = ip.IP.String()
+	}
+	return entry, nil
+}
+
+// Returns whether e applies to Switch dpid.
+func (e *blockEntry) on(dpid net.HardwareAddr) bool {
+	if len(e.DPIDs) == 0 {
+		return true
+	}
+	for _, d := range e.DPIDs {
+		if d == dpid.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Blocklist) installAll(sw *OFSwitch) {
+	b.mu.Lock()
+	entries := make([]*blockEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		if e.on(sw.DPID()) {
+			entries = append(entries, e)
+		}
+	}
+	b.mu.Unlock()
+	for _, e := range entries {
+		b.install(sw, e, false)
+	}
+}
+
+// Installs the drop flows of e on Switch sw, or deletes them.
+func (b *Blocklist) install(sw *OFSwitch, e *blockEntry, remove bool) {
+	for _, m := range e.flowMods(sw.Version(), remove) {
+		if err := sw.Send(m); err != nil {
+			log.Println("Failed to update blocklist flow on", SwitchLabel(sw.DPID()), err)
+			return
+		}
+	}
+}
+
+// Returns the flow mods adding or deleting the drop flows of e
+// on a switch of version.
+func (e *blockEntry) flowMods(version uint8, remove bool) []util.Message {
+	cookie := BlocklistCookie | uint64(e.Id)
+	mods := make([]util.Message, 0, 2)
+	for _, src := range []bool{true, false} {
+		if version == ofp10.VERSION {
+			f := ofp10.NewFlowMod()
+			f.Match = e.ofp10Match(src)
+			f.Priority = BlocklistPriority
+			f.Cookie = cookie
+			if remove {
+				f.Command = ofp10.FC_DELETE_STRICT
+			}
+			mods = append(mods, f)
+			continue
+		}
+		f := ofp14.NewFlowMod()
+		f.Header.Version = version
+		f.Match = e.ofp14Match(src)
+		f.Priority = BlocklistPriority
+		f.Cookie = cookie
+		if remove {
+			f.Command = ofp14.FC_DELETE_STRICT
+			f.CookieMask = 0xffffffffffffffff
+		}
+		mods = append(mods, f)
+	}
+	return mods
+}
+
+func (e *blockEntry) ofp10Match(src bool) ofp10.Match {
+	if e.mac != nil {
+		if src {
+			return FlowMatch{EthSrc: e.mac}.ofp10()
+		}
+		return FlowMatch{EthDst: e.mac}.ofp10()
+	}
+	m := FlowMatch{EthType: 0x0800}.ofp10()
+	ones, _ := e.ip.Mask.Size()
+	// OpenFlow 1.0 wildcards count the ignored low bits.
+	if src {
+		copy(m.NWSrc, e.ip.IP.To4())
+		m.Wildcards &^= ofp10.FW_NW_SRC_MASK
+		m.Wildcards |= uint32(32-ones) << ofp10.FW_NW_SRC_SHIFT
+	} else {
+		copy(m.NWDst, e.ip.IP.To4())
+		m.Wildcards &^= ofp10.FW_NW_DST_MASK
+		m.Wildcards |= uint32(32-ones) << ofp10.FW_NW_DST_SHIFT
+	}
+	return m
+}
+
+func (e *blockEntry) ofp14Match(src bool) ofp14.Match {
+	if e.mac != nil {
+		if src {
+			return FlowMatch{EthSrc: e.mac}.ofp14()
+		}
+		return FlowMatch{EthDst: e.mac}.ofp14()
+	}
+	m := FlowMatch{EthType: 0x0800}.ofp14()
+	field := uint8(ofp14.XMT_OFB_IPV4_SRC)
+	if !src {
+		field = ofp14.XMT_OFB_IPV4_DST
+	}
+	if ones, _ := e.ip.Mask.Size(); ones == 32 {
+		m.AddField(field, e.ip.IP.To4())
+	} else {
+		m.AddMaskedField(field, e.ip.IP.To4(), e.ip.Mask)
+	}
+	return m
+}
+
+// Returns the packet and byte counts of the flows of Switch s
+// whose cookie, masked with mask, is cookie, by cookie.
+func (s *OFSwitch) cookieCounters(cookie, mask uint64, timeout time.Duration) (map[uint64][2]uint64, error) {
+	counts := make(map[uint64][2]uint64)
+	add := func(c, packets, bytes uint64) {
+		if c&mask == cookie&mask {
+			n := counts[c]
+			counts[c] = [2]uint64{n[0] + packets, n[1] + bytes}
+		}
+	}
+	switch s.Version() {
+	case ofp10.VERSION:
+		// OpenFlow 1.0 can't select flows by cookie.
+		req := ofp10.NewFlowStatsRequest()
+		req.TableId = 0xff // All tables
+		req.OutPort = ofp10.P_NONE
+		reps, err := s.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Flow, req), timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range reps {
+			if body, ok := rep.Body.(*ofp10.FlowStatsReply); ok {
+				for _, f := range body.Flows {
+					add(f.Cookie, f.PacketCount, f.ByteCount)
+				}
+			}
+		}
+	case ofp13.VERSION, ofp14.VERSION:
+		body := ofp14.NewFlowStatsRequest()
+		body.Cookie = cookie
+		body.CookieMask = mask
+		reps, err := s.requestMultipart(s.newMultipartRequest(ofp14.MultipartType_Flow, body), timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range reps {
+			if body, ok := rep.Body.(*ofp14.FlowStatsReply); ok {
+				for _, f := range body.Flows {
+					add(f.Cookie, f.PacketCount, f.ByteCount)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("Flow counters aren't supported for this OpenFlow version.")
+	}
+	return counts, nil
+}
+
+func (b *Blocklist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b.Status(SyncTimeout))
+	case "POST":
+		var e BlockEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, err := b.Block(e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+		if !b.Unblock(id) {
+			http.Error(w, "no such entry", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
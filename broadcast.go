@@ -0,0 +1,212 @@
+package ogo
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// A port of a switch.
+type SwitchPort struct {
+	DPID net.HardwareAddr `json:"dpid"`
+	Port uint16           `json:"port"`
+}
+
+func (p SwitchPort) key() string {
+	return p.DPID.String() + "/" + strconv.Itoa(int(p.Port))
+}
+
+// A BroadcastDomain is a set of edge ports that hear each
+// other's broadcasts, like a VLAN spread over several switches.
+// Every edge port belongs to one domain: the one it was added
+// to, or else DefaultBroadcastDomain. Ports with a link to
+// another switch are never flooded to, whatever their domain, so
+// a flood crosses the network exactly once per edge port.
+type BroadcastDomain struct {
+	Name string
+
+	mu    sync.RWMutex
+	ports map[string]SwitchPort
+}
+
+// The domain of the edge ports that weren't added to any other.
+const DefaultBroadcastDomain = "default"
+
+var broadcastDomains = struct {
+	sync.RWMutex
+	m map[string]*BroadcastDomain
+	// Maps ports to the name of their domain.
+	byPort map[string]string
+}{m: make(map[string]*BroadcastDomain), byPort: make(map[string]string)}
+
+// Returns the broadcast domain called name, creating it if it
+// doesn't exist.
+func AddBroadcastDomain(name string) *BroadcastDomain {
+	broadcastDomains.Lock()
+	defer broadcastDomains.Unlock()
+	if d, ok := broadcastDomains.m[name]; ok {
+		return d
+	}
+	d := &BroadcastDomain{Name: name, ports: make(map[string]SwitchPort)}
+	broadcastDomains.m[name] = d
+	return d
+}
+
+// Removes the broadcast domain called name. Its ports go back
+// to the default domain.
+func RemoveBroadcastDomain(name string) {
+	broadcastDomains.Lock()
+	defer broadcastDomains.Unlock()
+	d, ok := broadcastDomains.m[name]
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	for k := range d.ports {
+		delete(broadcastDomains.byPort, k)
+	}
+	d.ports = make(map[string]SwitchPort)
+	d.mu.Unlock()
+	delete(broadcastDomains.m, name)
+}
+
+// Returns the names of the broadcast domains, without the
+// default one.
+func BroadcastDomains() []string {
+	broadcastDomains.RLock()
+	defer broadcastDomains.RUnlock()
+	a := make([]string, 0, len(broadcastDomains.m))
+	for name := range broadcastDomains.m {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Returns the name of the broadcast domain of port of Switch
+// dpid.
+func BroadcastDomainOf(dpid net.HardwareAddr, port uint16) string {
+	broadcastDomains.RLock()
+	defer broadcastDomains.RUnlock()
+	if name, ok := broadcastDomains.byPort[SwitchPort{dpid, port}.key()]; ok {
+		return name
+	}
+	return DefaultBroadcastDomain
+}
+
+// Moves port of Switch dpid into d, out of the domain it was in.
+func (d *BroadcastDomain) AddPort(dpid net.HardwareAddr, port uint16) {
+	p := SwitchPort{dpid, port}
+	broadcastDomains.Lock()
+	defer broadcastDomains.Unlock()
+	if name, ok := broadcastDomains.byPort[p.key()]; ok {
+		if old, ok := broadcastDomains.m[name]; ok {
+			old.mu.Lock()
+			delete(old.ports, p.key())
+			old.mu.Unlock()
+		}
+	}
+	broadcastDomains.byPort[p.key()] = d.Name
+	d.mu.Lock()
+	d.ports[p.key()] = p
+	d.mu.Unlock()
+}
+
+// Moves port of Switch dpid back to the default domain.
+func (d *BroadcastDomain) RemovePort(dpid net.HardwareAddr, port uint16) {
+	p := SwitchPort{dpid, port}
+	broadcastDomains.Lock()
+	defer broadcastDomains.Unlock()
+	if broadcastDomains.byPort[p.key()] == d.Name {
+		delete(broadcastDomains.byPort, p.key())
+	}
+	d.mu.Lock()
+	delete(d.ports, p.key())
+	d.mu.Unlock()
+}
+
+// Returns the edge ports of the broadcast domain called name on
+// the connected switches.
+func BroadcastDomainPorts(name string) []SwitchPort {
+	a := make([]SwitchPort, 0)
+	for _, sw := range Switches() {
+		for _, p := range sw.floodPorts(name) {
+			a = append(a, SwitchPort{sw.DPID(), p})
+		}
+	}
+	return a
+}
+
+// Returns the edge ports of Switch s in the broadcast domain
+// called name, by number.
+func (s *OFSwitch) floodPorts(name string) []uint16 {
+	a := make([]uint16, 0)
+	for _, p := range s.Ports() {
+		if p.PortNo > ofp10.P_MAX || !s.isEdgePort(p.PortNo) {
+			continue
+		}
+		if BroadcastDomainOf(s.DPID(), p.PortNo) == name {
+			a = append(a, p.PortNo)
+		}
+	}
+	sort.Sort(portNumbers(a))
+	return a
+}
+
+// Floods frame, received on inPort of Switch dpid, to the other
+// edge ports of the broadcast domain of that port, on every
+// switch. Use it instead of outputting to ofp10.P_FLOOD or
+// P_ALL, which loop on redundant links and leak frames between
+// domains. inPort is ofp10.P_NONE for frames originated by the
+// controller, which are flooded to the default domain. Only
+// OpenFlow 1.0 switches are flooded to.
+func Flood(dpid net.HardwareAddr, inPort uint16, frame *eth.Ethernet) error {
+	name := DefaultBroadcastDomain
+	if inPort != ofp10.P_NONE {
+		name = BroadcastDomainOf(dpid, inPort)
+	}
+	return FloodDomain(name, SwitchPort{dpid, inPort}, frame)
+}
+
+// Floods frame to the edge ports of the broadcast domain called
+// name, except the port it came from.
+func FloodDomain(name string, from SwitchPort, frame *eth.Ethernet) error {
+	var err error
+	for _, sw := range Switches() {
+		if sw.Version() != ofp10.VERSION {
+			continue
+		}
+		same := sw.DPID().String() == from.DPID.String()
+		out := ofp10.NewPacketOut()
+		out.Data = frame
+		for _, p := range sw.floodPorts(name) {
+			if same && p == from.Port {
+				continue
+			}
+			out.AddAction(ofp10.NewActionOutput(p))
+		}
+		if len(out.Actions) == 0 {
+			continue
+		}
+		if same {
+			// Lets the switch tell the frame apart from one
+			// originated by the controller.
+			out.InPort = from.Port
+		}
+		if e := sw.Send(out); e != nil && err == nil {
+			err = fmt.Errorf("Failed to flood to %s: %v", sw.DPID(), e)
+		}
+	}
+	return err
+}
+
+type portNumbers []uint16
+
+func (a portNumbers) Len() int           { return len(a) }
+func (a portNumbers) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a portNumbers) Less(i, j int) bool { return a[i] < a[j] }
@@ -0,0 +1,224 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How long to wait for the switch to acknowledge a bundle operation.
+var BundleTimeout = time.Second * 5
+
+var ErrBundleClosed = errors.New("Bundle has already been committed or discarded.")
+
+var bundleId uint32
+
+// A Bundle groups messages so they are applied to a switch as a
+// single operation. OpenFlow 1.4+ switches commit bundles
+// atomically themselves. For older switches the messages are
+// held by the controller until commit and then sent followed by
+// a barrier, so failures are reported but not rolled back.
+type Bundle struct {
+	Id   uint32
+	sw   *OFSwitch
+	msgs []util.Message
+	xids []uint32
+	errs chan util.Message
+	done bool
+}
+
+// Returns true if Switch s supports OpenFlow bundles.
+func (s *OFSwitch) bundles() bool {
+	return s.Version() >= ofp14.VERSION
+}
+
+// Opens a new bundle on Switch s.
+func (s *OFSwitch) OpenBundle() (*Bundle, error) {
+	b := new(Bundle)
+	b.Id = atomic.AddUint32(&bundleId, 1)
+	b.sw = s
+	b.msgs = make([]util.Message, 0)
+	b.xids = make([]uint32, 0)
+	b.errs = make(chan util.Message, 64)
+	if !s.bundles() {
+		return b, nil
+	}
+
+	req := s.newBundleCtrl(b.Id, ofp14.BCT_OPEN_REQUEST)
+	rep, err := s.SendAndReceive(req, BundleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = bundleReply(rep, ofp14.BCT_OPEN_REPLY); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Adds msg to bundle b. On OpenFlow 1.4+ switches msg must use
+// the negotiated OpenFlow version.
+func (s *OFSwitch) AddToBundle(b *Bundle, msg util.Message) error {
+	if b.done {
+		return ErrBundleClosed
+	}
+	if b.sw != s {
+		return errors.New("Bundle was opened on a different switch.")
+	}
+	if !s.bundles() {
+		b.msgs = append(b.msgs, msg)
+		return nil
+	}
+
+	var add *ofp14.BundleAdd
+	if s.Version() == ofp15.VERSION {
+		add = ofp15.NewBundleAdd(b.Id, ofp14.BF_ATOMIC|ofp14.BF_ORDERED, msg)
+	} else {
+		add = ofp14.NewBundleAdd(b.Id, ofp14.BF_ATOMIC|ofp14.BF_ORDERED, msg)
+	}
+	// The switch only replies to a bundle add if it fails.
+	b.xids = append(b.xids, add.Xid)
+	s.expect(add.Xid, b.errs)
+	return s.Send(add)
+}
+
+// Commits bundle b. Returns an error if any message in the
+// bundle was rejected by the switch. On OpenFlow 1.4+ switches
+// a failed bundle is discarded and none of its messages are
+// applied.
+func (s *OFSwitch) CommitBundle(b *Bundle) error {
+	if b.done {
+		return ErrBundleClosed
+	}
+	b.done = true
+	defer b.release()
+
+	if !s.bundles() {
+		for _, m := range b.msgs {
+			if x, ok := xid(m); ok {
+				b.xids = append(b.xids, x)
+				s.expect(x, b.errs)
+			}
+			if err := s.Send(m); err != nil {
+				return err
+			}
+		}
+		if err := b.sync(); err != nil {
+			return err
+		}
+		return b.failed()
+	}
+
+	if err := b.sync(); err != nil {
+		return err
+	}
+	if err := b.failed(); err != nil {
+		s.Send(s.newBundleCtrl(b.Id, ofp14.BCT_DISCARD_REQUEST))
+		return err
+	}
+	rep, err := s.SendAndReceive(s.newBundleCtrl(b.Id, ofp14.BCT_COMMIT_REQUEST), BundleTimeout)
+	if err != nil {
+		return err
+	}
+	return bundleReply(rep, ofp14.BCT_COMMIT_REPLY)
+}
+
+// Discards bundle b without applying any of its messages.
+func (s *OFSwitch) DiscardBundle(b *Bundle) error {
+	if b.done {
+		return ErrBundleClosed
+	}
+	b.done = true
+	defer b.release()
+
+	if !s.bundles() {
+		return nil
+	}
+	rep, err := s.SendAndReceive(s.newBundleCtrl(b.Id, ofp14.BCT_DISCARD_REQUEST), BundleTimeout)
+	if err != nil {
+		return err
+	}
+	return bundleReply(rep, ofp14.BCT_DISCARD_REPLY)
+}
+
+func (s *OFSwitch) newBundleCtrl(id uint32, t uint16) *ofp14.BundleCtrl {
+	if s.Version() == ofp15.VERSION {
+		return ofp15.NewBundleCtrl(id, t, ofp14.BF_ATOMIC|ofp14.BF_ORDERED)
+	}
+	return ofp14.NewBundleCtrl(id, t, ofp14.BF_ATOMIC|ofp14.BF_ORDERED)
+}
+
+// Waits for the switch to process every message sent so far,
+// so any errors caused by the bundle have been received.
+func (b *Bundle) sync() error {
+	_, err := b.sw.SendAndReceive(b.sw.newBarrierRequest(), BundleTimeout)
+	return err
+}
+
+func (s *OFSwitch) newBarrierRequest() *ofpxx.Header {
+	switch s.Version() {
+	case ofp10.VERSION:
+		return ofp10.NewBarrierRequest()
+	case ofp13.VERSION:
+		return ofp13.NewBarrierRequest()
+	case ofp14.VERSION:
+		return ofp14.NewBarrierRequest()
+	}
+	return ofp15.NewBarrierRequest()
+}
+
+// Returns an error describing the messages rejected by the switch.
+func (b *Bundle) failed() error {
+	n := 0
+	var last util.Message
+	for empty := false; !empty; {
+		select {
+		case m := <-b.errs:
+			n += 1
+			last = m
+		default:
+			empty = true
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d message(s) in bundle %d failed, last error: %s", n, b.Id, errorString(last))
+}
+
+func (b *Bundle) release() {
+	for _, x := range b.xids {
+		b.sw.forget(x)
+	}
+	b.msgs = nil
+}
+
+// Checks that rep is a BundleCtrl message of type t.
+func bundleReply(rep util.Message, t uint16) error {
+	switch r := rep.(type) {
+	case *ofp14.BundleCtrl:
+		if r.Type != t {
+			return fmt.Errorf("Unexpected bundle reply type %d.", r.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Bundle operation failed: %s", errorString(rep))
+	}
+}
+
+func errorString(msg util.Message) string {
+	switch m := msg.(type) {
+	case *ofp10.ErrorMsg:
+		return fmt.Sprintf("error code %d", m.Code)
+	case *ofp14.ErrorMsg:
+		return fmt.Sprintf("error type %d code %d", m.Type, m.Code)
+	}
+	return fmt.Sprintf("unexpected message %T", msg)
+}
@@ -0,0 +1,235 @@
+package ogo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// If set, Send refuses OpenFlow 1.3+ flow mods adding or modifying
+// flows that the capability model of their switch says its table
+// can't hold, see ValidateFlow. Switches whose table features
+// haven't been received accept every flow.
+var ValidateCapabilities = true
+
+// The matches, instructions and actions supported by a single
+// flow table, built from the switch's table features.
+type TableCapability struct {
+	TableId      uint8
+	Name         string
+	MaxEntries   uint32
+	Instructions map[uint16]bool
+	NextTables   map[uint8]bool
+	WriteActions map[uint16]bool
+	ApplyActions map[uint16]bool
+	// OXM ids, see ofp14.OxmId.
+	Match         map[uint32]bool
+	Wildcards     map[uint32]bool
+	WriteSetField map[uint32]bool
+	ApplySetField map[uint32]bool
+}
+
+// A switch's capability model, one entry per flow table.
+type Capabilities struct {
+	Tables map[uint8]*TableCapability
+}
+
+func NewCapabilities(features []ofp14.TableFeatures) *Capabilities {
+	c := new(Capabilities)
+	c.Tables = make(map[uint8]*TableCapability)
+	for _, f := range features {
+		t := new(TableCapability)
+		t.TableId = f.TableId
+		t.Name = string(bytes.TrimRight(f.Name, "\x00"))
+		t.MaxEntries = f.MaxEntries
+		t.Instructions = make(map[uint16]bool)
+		t.NextTables = make(map[uint8]bool)
+		t.WriteActions = make(map[uint16]bool)
+		t.ApplyActions = make(map[uint16]bool)
+		t.Match = make(map[uint32]bool)
+		t.Wildcards = make(map[uint32]bool)
+		t.WriteSetField = make(map[uint32]bool)
+		t.ApplySetField = make(map[uint32]bool)
+
+		// Only the properties for regular flows are used,
+		// table-miss properties are ignored.
+		for _, p := range f.Properties {
+			for _, id := range p.Ids {
+				switch p.Type {
+				case ofp14.TFPT_INSTRUCTIONS:
+					t.Instructions[uint16(id)] = true
+				case ofp14.TFPT_NEXT_TABLES:
+					t.NextTables[uint8(id)] = true
+				case ofp14.TFPT_WRITE_ACTIONS:
+					t.WriteActions[uint16(id)] = true
+				case ofp14.TFPT_APPLY_ACTIONS:
+					t.ApplyActions[uint16(id)] = true
+				case ofp14.TFPT_MATCH:
+					t.Match[id&0xfffffe00] = true
+				case ofp14.TFPT_WILDCARDS:
+					t.Wildcards[id&0xfffffe00] = true
+				case ofp14.TFPT_WRITE_SETFIELD:
+					t.WriteSetField[id&0xfffffe00] = true
+				case ofp14.TFPT_APPLY_SETFIELD:
+					t.ApplySetField[id&0xfffffe00] = true
+				}
+			}
+		}
+		c.Tables[t.TableId] = t
+	}
+	return c
+}
+
+// Describes what a flow needs from the table it is installed in.
+type FlowRequirements struct {
+	TableId      uint8
+	Match        []uint32 // OXM ids of matched fields
+	Instructions []uint16
+	WriteActions []uint16
+	ApplyActions []uint16
+	SetFields    []uint32 // OXM ids of fields set by apply actions
+	GotoTable    int      // -1 if the flow has no goto-table
+}
+
+// Returns an error describing the first requirement of r that
+// the target table doesn't support.
+func (c *Capabilities) Validate(r FlowRequirements) error {
+	t, ok := c.Tables[r.TableId]
+	if !ok {
+		return fmt.Errorf("Table %d does not exist.", r.TableId)
+	}
+	for _, id := range r.Match {
+		if !t.Match[id&0xfffffe00] {
+			return fmt.Errorf("Table %d (%s) can't match OXM field 0x%08x.", t.TableId, t.Name, id)
+		}
+	}
+	for _, i := range r.Instructions {
+		if !t.Instructions[i] {
+			return fmt.Errorf("Table %d (%s) doesn't support instruction type %d.", t.TableId, t.Name, i)
+		}
+	}
+	for _, a := range r.WriteActions {
+		if !t.WriteActions[a] {
+			return fmt.Errorf("Table %d (%s) doesn't support write action type %d.", t.TableId, t.Name, a)
+		}
+	}
+	for _, a := range r.ApplyActions {
+		if !t.ApplyActions[a] {
+			return fmt.Errorf("Table %d (%s) doesn't support apply action type %d.", t.TableId, t.Name, a)
+		}
+	}
+	for _, id := range r.SetFields {
+		if !t.ApplySetField[id&0xfffffe00] {
+			return fmt.Errorf("Table %d (%s) can't set OXM field 0x%08x.", t.TableId, t.Name, id)
+		}
+	}
+	if r.GotoTable >= 0 && !t.NextTables[uint8(r.GotoTable)] {
+		return fmt.Errorf("Table %d (%s) can't go to table %d.", t.TableId, t.Name, r.GotoTable)
+	}
+	return nil
+}
+
+// Requests the table features of Switch s and stores them as
+// its capability model. Requires OpenFlow 1.3 or later.
+func (s *OFSwitch) RequestTableFeatures(timeout time.Duration) (*Capabilities, error) {
+	if s.Version() < ofp13.VERSION {
+		return nil, errors.New("Table features require OpenFlow 1.3 or later.")
+	}
+	req := s.newMultipartRequest(ofp14.MultipartType_TableFeatures, nil)
+	reps, err := s.requestMultipart(req, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]ofp14.TableFeatures, 0)
+	for _, rep := range reps {
+		if body, ok := rep.Body.(*ofp14.TableFeaturesReply); ok {
+			features = append(features, body.Tables...)
+		}
+	}
+	// An empty model would refuse every flow.
+	if len(features) == 0 {
+		return nil, errors.New("Switch reported no table features.")
+	}
+	c := NewCapabilities(features)
+	s.capsMu.Lock()
+	s.caps = c
+	s.capsMu.Unlock()
+	return c, nil
+}
+
+// Returns the capability model of Switch s, or nil if its
+// table features haven't been received.
+func (s *OFSwitch) Capabilities() *Capabilities {
+	s.capsMu.RLock()
+	defer s.capsMu.RUnlock()
+	return s.caps
+}
+
+// Validates r against the capability model of Switch s. Flows
+// are assumed valid if the model isn't known.
+func (s *OFSwitch) ValidateFlow(r FlowRequirements) error {
+	if c := s.Capabilities(); c != nil {
+		return c.Validate(r)
+	}
+	return nil
+}
+
+// Returns what flow mod f needs from the table it adds a flow to.
+func flowModRequirements(f *ofp14.FlowMod) FlowRequirements {
+	r := FlowRequirements{TableId: f.TableId, Match: oxmIds(f.Match.Fields), GotoTable: -1}
+	for _, i := range f.Instructions {
+		r.Instructions = append(r.Instructions, i.InstructionType())
+		switch i := i.(type) {
+		case *ofp14.InstrGotoTable:
+			r.GotoTable = int(i.TableId)
+		case *ofp14.InstrActions:
+			for _, a := range i.Actions {
+				switch i.Type {
+				case ofp14.IT_WRITE_ACTIONS:
+					r.WriteActions = append(r.WriteActions, a.ActionType())
+				case ofp14.IT_APPLY_ACTIONS:
+					r.ApplyActions = append(r.ApplyActions, a.ActionType())
+					if sf, ok := a.(*ofp14.ActionSetField); ok {
+						r.SetFields = append(r.SetFields, oxmIds(sf.Field)...)
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+// Returns the OXM ids of the fields in b, a list of OXM TLVs.
+func oxmIds(b []byte) []uint32 {
+	ids := make([]uint32, 0)
+	for len(b) >= 4 {
+		h := binary.BigEndian.Uint32(b)
+		n := 4 + int(h&0xff)
+		if n > len(b) {
+			break
+		}
+		ids = append(ids, h)
+		b = b[n:]
+	}
+	return ids
+}
+
+// Checks a message for Switch s against its capability model, if
+// it adds or modifies flows.
+func (s *OFSwitch) validateCapabilities(msg interface{}) error {
+	f, ok := msg.(*ofp14.FlowMod)
+	if !ok || !ValidateCapabilities {
+		return nil
+	}
+	switch f.Command {
+	case ofp14.FC_ADD, ofp14.FC_MODIFY, ofp14.FC_MODIFY_STRICT:
+		return s.ValidateFlow(flowModRequirements(f))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package ogo
+
+import (
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+func TestValidateFlowMod(t *testing.T) {
+	c := &Capabilities{Tables: map[uint8]*TableCapability{0: {
+		TableId:       0,
+		Name:          "classifier",
+		Instructions:  map[uint16]bool{ofp14.IT_APPLY_ACTIONS: true, ofp14.IT_GOTO_TABLE: true},
+		NextTables:    map[uint8]bool{1: true},
+		WriteActions:  map[uint16]bool{},
+		ApplyActions:  map[uint16]bool{ofp14.AT_OUTPUT: true, ofp14.AT_SET_FIELD: true},
+		Match:         map[uint32]bool{ofp14.OxmId(ofp14.XMT_OFB_IN_PORT): true, ofp14.OxmId(ofp14.XMT_OFB_ETH_DST): true},
+		ApplySetField: map[uint32]bool{ofp14.OxmId(ofp14.XMT_OFB_ETH_SRC): true},
+	}}}
+
+	mac := []byte{2, 0, 0, 0, 0, 1}
+	tests := []struct {
+		name  string
+		build func(f *ofp14.FlowMod)
+		ok    bool
+	}{
+		{"supported", func(f *ofp14.FlowMod) {
+			f.Match.AddField(ofp14.XMT_OFB_IN_PORT, []byte{0, 0, 0, 1})
+			f.Match.AddField(ofp14.XMT_OFB_ETH_DST, mac)
+			a := ofp14.NewInstrApplyActions()
+			a.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_ETH_SRC, mac))
+			a.AddAction(ofp14.NewActionOutput(2))
+			f.AddInstruction(a)
+			f.AddInstruction(ofp14.NewInstrGotoTable(1))
+		}, true},
+		{"unsupported match field", func(f *ofp14.FlowMod) {
+			f.Match.AddField(ofp14.XMT_OFB_ETH_SRC, mac)
+		}, false},
+		{"unsupported set field", func(f *ofp14.FlowMod) {
+			a := ofp14.NewInstrApplyActions()
+			a.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_ETH_DST, mac))
+			f.AddInstruction(a)
+		}, false},
+		{"unsupported instruction", func(f *ofp14.FlowMod) {
+			a := ofp14.NewInstrWriteActions()
+			a.AddAction(ofp14.NewActionOutput(2))
+			f.AddInstruction(a)
+		}, false},
+		{"unreachable table", func(f *ofp14.FlowMod) {
+			f.AddInstruction(ofp14.NewInstrGotoTable(2))
+		}, false},
+		{"unknown table", func(f *ofp14.FlowMod) {
+			f.TableId = 3
+		}, false},
+	}
+	for _, test := range tests {
+		f := ofp14.NewFlowMod()
+		test.build(f)
+		err := c.Validate(flowModRequirements(f))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v.", test.name, err)
+		}
+	}
+}
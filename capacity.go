@@ -0,0 +1,288 @@
+package ogo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// How long table samples are kept to estimate the growth of
+// each table.
+var CapacityWindow = 7 * 24 * time.Hour
+
+// How long to wait for each table stats reply.
+var CapacityRequestTimeout = time.Second * 5
+
+// The use of a flow table at the time of a report.
+type TableUsage struct {
+	DPID  string `json:"dpid"`
+	Name  string `json:"name,omitempty"`
+	Table uint8  `json:"table"`
+	// Flows in the table, as reported by the switch.
+	Active uint32 `json:"active"`
+	// The size of the table, 0 if unknown. OpenFlow 1.0
+	// switches report it in their table stats, later versions
+	// in their table features, see RequestTableFeatures.
+	MaxEntries uint32 `json:"max_entries"`
+	// Active/MaxEntries, 0 if the size is unknown.
+	Used float64 `json:"used"`
+	// Flows in the table counted by owner, see
+	// RegisterFlowOwner, from the flow shadow of switches with
+	// an active flow monitor. Flows of no known owner are
+	// counted under "".
+	Owners map[string]int `json:"owners,omitempty"`
+	// Flows added per day over CapacityWindow.
+	GrowthPerDay float64 `json:"growth_per_day"`
+	// Days until the table is full at that growth, -1 if it
+	// isn't growing or its size is unknown.
+	DaysUntilFull float64 `json:"days_until_full"`
+	Samples       int     `json:"samples"`
+}
+
+type CapacityReport struct {
+	Time   time.Time    `json:"time"`
+	Tables []TableUsage `json:"tables"`
+}
+
+// Writes r as a table for people, tables closest to full first.
+func (r *CapacityReport) WriteText(w io.Writer) {
+	tables := append([]TableUsage(nil), r.Tables...)
+	sort.Sort(tablesByDaysUntilFull(tables))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DPID\tNAME\tTABLE\tACTIVE\tMAX\tUSED\tGROWTH/DAY\tDAYS LEFT\tSAMPLES")
+	for _, t := range tables {
+		max, used, left := "-", "-", "-"
+		if t.MaxEntries > 0 {
+			max = fmt.Sprint(t.MaxEntries)
+			used = fmt.Sprintf("%.1f%%", t.Used*100)
+		}
+		if t.DaysUntilFull >= 0 {
+			left = fmt.Sprintf("%.1f", t.DaysUntilFull)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%.1f\t%s\t%d\n", t.DPID, t.Name, t.Table,
+			t.Active, max, used, t.GrowthPerDay, left, t.Samples)
+	}
+	tw.Flush()
+}
+
+// Tables that will never fill sort last.
+type tablesByDaysUntilFull []TableUsage
+
+func (a tablesByDaysUntilFull) Len() int      { return len(a) }
+func (a tablesByDaysUntilFull) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a tablesByDaysUntilFull) Less(i, j int) bool {
+	di, dj := a[i].DaysUntilFull, a[j].DaysUntilFull
+	if (di < 0) != (dj < 0) {
+		return dj < 0
+	}
+	if di != dj {
+		return di < dj
+	}
+	if a[i].DPID != a[j].DPID {
+		return a[i].DPID < a[j].DPID
+	}
+	return a[i].Table < a[j].Table
+}
+
+type tableSample struct {
+	time   time.Time
+	active uint32
+	max    uint32
+}
+
+// A CapacityPlanner periodically samples the table stats of
+// every switch and estimates from their growth when each table
+// will be full, which matters most for hardware switches with
+// small TCAMs. OpenFlow 1.0 switches only report their first
+// table.
+type CapacityPlanner struct {
+	Interval time.Duration
+	mu       sync.Mutex
+	history  map[string][]tableSample
+	latest   map[string]TableUsage
+	stop     chan bool
+}
+
+func NewCapacityPlanner(interval time.Duration) *CapacityPlanner {
+	p := new(CapacityPlanner)
+	p.Interval = interval
+	p.history = make(map[string][]tableSample)
+	p.latest = make(map[string]TableUsage)
+	p.stop = make(chan bool, 1)
+	return p
+}
+
+func (p *CapacityPlanner) Start() {
+	go func() {
+		p.Sample()
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Sample()
+			}
+		}
+	}()
+}
+
+func (p *CapacityPlanner) Stop() {
+	select {
+	case p.stop <- true:
+	default:
+	}
+}
+
+// Polls the table stats of every switch once.
+func (p *CapacityPlanner) Sample() {
+	now := time.Now()
+	for _, sw := range Switches() {
+		tables, err := tableStats(sw)
+		if err != nil {
+			log.Println("Failed to sample the tables of", SwitchLabel(sw.DPID())+":", err)
+			continue
+		}
+		p.mu.Lock()
+		for _, t := range tables {
+			t.Name = SwitchName(sw.DPID())
+			key := fmt.Sprintf("%s/%d", t.DPID, t.Table)
+			a := append(p.history[key], tableSample{now, t.Active, t.MaxEntries})
+			for len(a) > 0 && now.Sub(a[0].time) > CapacityWindow {
+				a = a[1:]
+			}
+			p.history[key] = a
+			p.latest[key] = t
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Returns the current use of every table of sw.
+func tableStats(sw *OFSwitch) ([]TableUsage, error) {
+	dpid := sw.DPID().String()
+	tables := make([]TableUsage, 0)
+	if sw.Version() == ofp10.VERSION {
+		reps, err := sw.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Table, nil), CapacityRequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range reps {
+			if s, ok := rep.Body.(*ofp10.TableStats); ok {
+				tables = append(tables, TableUsage{DPID: dpid, Table: s.TableId,
+					Active: s.ActiveCount, MaxEntries: s.MaxEntries})
+			}
+		}
+		return tables, nil
+	}
+
+	req := sw.newMultipartRequest(ofp14.MultipartType_Table, nil)
+	req.Header.Version = sw.Version()
+	reps, err := sw.requestMultipart(req, CapacityRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	caps := sw.Capabilities()
+	for _, rep := range reps {
+		body, ok := rep.Body.(*ofp14.TableStatsReply)
+		if !ok {
+			continue
+		}
+		for _, s := range body.Tables {
+			t := TableUsage{DPID: dpid, Table: s.TableId, Active: s.ActiveCount}
+			if caps != nil && caps.Tables[s.TableId] != nil {
+				t.MaxEntries = caps.Tables[s.TableId].MaxEntries
+			}
+			tables = append(tables, t)
+		}
+	}
+	return tables, nil
+}
+
+// Returns the latest sample of every table with its estimated
+// growth.
+func (p *CapacityPlanner) Report() *CapacityReport {
+	r := &CapacityReport{Time: time.Now(), Tables: make([]TableUsage, 0)}
+	owners := make(map[string]map[string]int)
+	for _, sw := range Switches() {
+		for _, f := range sw.Flows() {
+			key := fmt.Sprintf("%s/%d", sw.DPID(), f.TableId)
+			if owners[key] == nil {
+				owners[key] = make(map[string]int)
+			}
+			owners[key][FlowOwner(f.Cookie)] += 1
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, a := range p.history {
+		if len(a) == 0 {
+			continue
+		}
+		t := p.latest[key]
+		last := a[len(a)-1]
+		t.Active, t.MaxEntries = last.active, last.max
+		t.Owners = owners[key]
+		t.Samples = len(a)
+		t.GrowthPerDay = growthPerDay(a)
+		t.DaysUntilFull = -1
+		if t.MaxEntries > 0 {
+			t.Used = float64(t.Active) / float64(t.MaxEntries)
+			if t.Active >= t.MaxEntries {
+				t.DaysUntilFull = 0
+			} else if t.GrowthPerDay > 0 {
+				t.DaysUntilFull = float64(t.MaxEntries-t.Active) / t.GrowthPerDay
+			}
+		}
+		r.Tables = append(r.Tables, t)
+	}
+	sort.Sort(tablesByDaysUntilFull(r.Tables))
+	return r
+}
+
+// Returns the slope of the least squares line through the
+// active counts of a, in flows per day.
+func growthPerDay(a []tableSample) float64 {
+	if len(a) < 2 {
+		return 0
+	}
+	var sx, sy, sxx, sxy float64
+	for _, s := range a {
+		x := s.time.Sub(a[0].time).Hours() / 24
+		y := float64(s.active)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(a))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// Serves the report as JSON, or as text with format=text.
+//
+//	/capacity?format=text
+func (p *CapacityPlanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rep := p.Report()
+	if r.URL.Query().Get("format") == "text" {
+		rep.WriteText(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
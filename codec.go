@@ -0,0 +1,27 @@
+package ogo
+
+import (
+	"github.com/jonstout/ogo/protocol/ofp"
+)
+
+// Wraps codecs so they can share an atomic.Value whatever their
+// type.
+type codecBox struct {
+	codec ofp.Codec
+}
+
+// Makes m parse the messages of c's version with c rather than
+// the codec registered for that version or the built-in parser.
+// Passing nil restores them. The handshake sets the registered
+// codec of the negotiated version, see ofp.RegisterCodec.
+func (m *MessageStream) SetCodec(c ofp.Codec) {
+	m.codec.Store(codecBox{c})
+}
+
+// Returns the codec set by SetCodec, nil if none.
+func (m *MessageStream) Codec() ofp.Codec {
+	if b, ok := m.codec.Load().(codecBox); ok {
+		return b.codec
+	}
+	return nil
+}
@@ -1,14 +1,60 @@
 package ogo
 
 import (
-	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/kv"
 	"github.com/jonstout/ogo/protocol/ofp10"
 	"log"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
-type Controller struct{}
+// OpenFlow versions the controller offers a switch. Both sides
+// settle on the highest version they share, so a switch speaking
+// 1.0 and 1.3 uses 1.3 once it's offered. Only OpenFlow 1.0 is
+// offered by default: port discovery, link discovery and the
+// default flows only work with OpenFlow 1.0 switches, so a switch
+// on a newer version gets no ports or links. Append ofp13.VERSION,
+// ofp14.VERSION or ofp15.VERSION, before switches connect, to
+// negotiate them with switches that need them.
+var SupportedVersions = []uint8{ofp10.VERSION}
+
+type Controller struct {
+	// If set, applied to every OpenFlow 1.3+ switch when it
+	// connects, before applications are notified.
+	AsyncPolicy *AsyncPolicy
+	// The number of connected switches required before the
+	// ops endpoint reports the controller ready.
+	ReadySwitches int
+	// If set, called once a switch's features are known,
+	// before it is added. Returning an error rejects the
+	// switch.
+	Admit func(dpid net.HardwareAddr, addr net.Addr, version uint8) error
+	// If set, called with every failed handshake instead of
+	// logging it.
+	HandshakeFailed func(err *HandshakeError)
+	// What to do when a switch connects with the DPID of a
+	// connected switch. Defaults to DuplicateReplace.
+	DuplicateDPID DuplicateDPIDPolicy
+	// If set, its report is served at /capacity by ServeOps.
+	Capacity *CapacityPlanner
+	// If set, its usage records are served at /accounting by
+	// ServeOps.
+	Accounting *Accounting
+	// If set, the report of its last window is served at
+	// /latency by ServeOps.
+	Latency *LatencyBudget
+	// Guards the destructive endpoints of ServeOps.
+	Interlock *Interlock
+	// If set, authenticates and authorizes the requests to
+	// ServeOps.
+	Access *AccessControl
+	// State shared between applications, each in its own
+	// namespace. In memory unless replaced by a store from
+	// kv.Open.
+	Store *kv.Store
+}
 type ApplicationInstanceGenerator func() interface{}
 
 var Applications []ApplicationInstanceGenerator
@@ -17,6 +63,9 @@ func NewController() *Controller {
 	c := new(Controller)
 	Applications = *new([]ApplicationInstanceGenerator)
 	network = NewNetwork()
+	startMessageRates()
+	c.Store = kv.New()
+	c.Interlock = NewInterlock()
 
 	c.RegisterApplication(NewInstance)
 	return c
@@ -30,6 +79,8 @@ func (c *Controller) Listen(port string) {
 		log.Fatal(err)
 	}
 	defer sock.Close()
+	atomic.StoreInt32(&listening, 1)
+	defer atomic.StoreInt32(&listening, 0)
 
 	log.Println("Listening for connections on", addr)
 	for {
@@ -41,67 +92,77 @@ func (c *Controller) Listen(port string) {
 	}
 }
 
-func (c *Controller) handleConnection(conn *net.TCPConn) {
-	stream := NewMessageStream(conn)
-	h, err := ofpxx.NewHello(1)
+// Listens for connections on the Unix domain socket at path,
+// like Listen. Switches connect with "unix:path". A stale socket
+// left at path is removed first.
+func (c *Controller) ListenUnix(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	sock, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
 	if err != nil {
-		return
+		log.Fatal(err)
 	}
-	stream.Outbound <- h
+	defer sock.Close()
+	atomic.StoreInt32(&listening, 1)
+	defer atomic.StoreInt32(&listening, 0)
 
+	log.Println("Listening for connections on", path)
 	for {
-		select {
-		//case stream.Outbound <- ofp10.NewHello():
-		// Send hello message with latest protocol version.
-		case msg := <-stream.Inbound:
-			switch m := msg.(type) {
-			// A Hello message of the appropriate type
-			// completes version negotiation. If version
-			// types are incompatable, it is possible the
-			// connection may be servered without error.
-			case *ofpxx.Header:
-				if m.Version == ofp10.VERSION {
-					// Version negotiation is
-					// considered complete. Create
-					// new Switch and notifiy listening
-					// applications.
-					stream.Version = m.Version
-					stream.Outbound <- ofp10.NewFeaturesRequest()
-				} else {
-					// Connection should be severed if controller
-					// doesn't support switch version.
-					log.Println("Received unsupported ofp version", m.Version)
-					stream.Shutdown <- true
-				}
-			// After a vaild FeaturesReply has been received we
-			// have all the information we need. Create a new
-			// switch object and notify applications.
-			case *ofp10.SwitchFeatures:
-				NewSwitch(stream, *m)
-				for _, newInstance := range Applications {
-					if sw, ok := Switch(m.DPID); ok {
-						i := newInstance()
-						sw.AddInstance(i)
-					}
-				}
-				return
-			// An error message may indicate a version mismatch. We
-			// disconnect if an error occurs this early.
-			case *ofp10.ErrorMsg:
-				log.Println(m)
-				stream.Version = m.Header.Version
-				stream.Shutdown <- true
+		conn, err := sock.AcceptUnix()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go c.handleConnection(conn)
+	}
+}
+
+func (c *Controller) handleConnection(conn net.Conn) {
+	c.serve(NewMessageStream(conn))
+}
+
+// Runs the handshake on stream and adds the switch. Returns
+// false if the handshake failed.
+func (c *Controller) serve(stream *MessageStream) bool {
+	h := &handshake{c: c, stream: stream}
+	dpid, main, err := h.run()
+	if err != nil {
+		h.stream.Close()
+		c.handshakeFailed(err)
+		return false
+	}
+	if !main {
+		return true
+	}
+
+	// Create a new switch object and notify applications.
+	if sw, ok := Switch(dpid); ok {
+		if _, err := sw.RequestDescription(DescriptionTimeout); err != nil {
+			log.Println("Failed to get description of", SwitchLabel(dpid), err)
+		}
+		sw.applyQuirks()
+		if sw.Version() != ofp10.VERSION {
+			if c.AsyncPolicy != nil {
+				sw.SetAsync(*c.AsyncPolicy)
 			}
-		case err := <-stream.Error:
-			// The connection has been shutdown.
-			log.Println(err)
-			return
-		case <-time.After(time.Second * 3):
-			// This shouldn't happen. If it does, both the controller
-			// and switch are no longer communicating. The TCPConn is
-			// still established though.
-			log.Println("Connection timed out.")
-			return
+			go sw.RequestTableFeatures(time.Second * 10)
+		}
+	}
+	c.addInstances(dpid)
+	return true
+}
+
+// Creates a new instance of every registered application, and
+// of every application registered for its domain, for the
+// switch dpid.
+func (c *Controller) addInstances(dpid net.HardwareAddr) {
+	apps := make([]ApplicationInstanceGenerator, 0)
+	apps = append(apps, Applications...)
+	apps = append(apps, domainApplications(dpid)...)
+	for _, newInstance := range apps {
+		if sw, ok := Switch(dpid); ok {
+			i := newInstance()
+			sw.addInstance(i, newInstance)
 		}
 	}
 }
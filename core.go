@@ -12,7 +12,9 @@ import (
 
 // OgoInstance generator.
 func NewInstance() interface{} {
-	return new(OgoInstance)
+	o := new(OgoInstance)
+	o.shutdown = make(chan bool, 1)
+	return o
 }
 
 type OgoInstance struct {
@@ -20,6 +22,12 @@ type OgoInstance struct {
 }
 
 func (o *OgoInstance) ConnectionUp(dpid net.HardwareAddr) {
+	// Default flows and link discovery are only implemented
+	// for OpenFlow 1.0 switches.
+	if sw, ok := Switch(dpid); !ok || sw.Version() != ofp10.VERSION {
+		return
+	}
+
 	dropMod := ofp10.NewFlowMod()
 	dropMod.Priority = 1
 
@@ -33,19 +41,28 @@ func (o *OgoInstance) ConnectionUp(dpid net.HardwareAddr) {
 	dscFmod.Match.DLType = 0xa0f1 // Link Discovery Messages
 	dscFmod.AddAction(ofp10.NewActionOutput(ofp10.P_CONTROLLER))
 
+	bddpFmod := ofp10.NewFlowMod()
+	bddpFmod.Priority = 0xffff
+	bddpFmod.Match.DLType = BDDPEthertype // Broadcast Domain Discovery
+	bddpFmod.AddAction(ofp10.NewActionOutput(ofp10.P_CONTROLLER))
+
 	if sw, ok := Switch(dpid); ok {
 		sw.Send(ofp10.NewFeaturesRequest())
 		sw.Send(dropMod)
 		sw.Send(arpFmod)
 		sw.Send(dscFmod)
+		sw.Send(bddpFmod)
 		sw.Send(ofp10.NewEchoRequest())
 	}
 	go o.linkDiscoveryLoop(dpid)
 }
 
-func (o *OgoInstance) ConnectionDown(dpid net.HardwareAddr) {
-	o.shutdown <- true
-	log.Println("Switch Disconnected:", dpid)
+func (o *OgoInstance) ConnectionDown(dpid net.HardwareAddr, err error) {
+	select {
+	case o.shutdown <- true:
+	default:
+	}
+	log.Println("Switch Disconnected:", SwitchLabel(dpid), err)
 }
 
 func (o *OgoInstance) EchoRequest(dpid net.HardwareAddr) {
@@ -54,6 +71,7 @@ func (o *OgoInstance) EchoRequest(dpid net.HardwareAddr) {
 		<-time.After(time.Second * 3)
 		if sw, ok := Switch(dpid); ok {
 			res := ofp10.NewEchoReply()
+			res.Version = sw.Version()
 			sw.Send(res)
 		}
 	}()
@@ -65,6 +83,7 @@ func (o *OgoInstance) EchoReply(dpid net.HardwareAddr) {
 		<-time.After(time.Second * 3)
 		if sw, ok := Switch(dpid); ok {
 			res := ofp10.NewEchoRequest()
+			res.Version = sw.Version()
 			sw.Send(res)
 		}
 	}()
@@ -78,17 +97,48 @@ func (o *OgoInstance) FeaturesReply(dpid net.HardwareAddr, features *ofp10.Switc
 	}
 }
 
+// Keeps the ports of the switch up to date as ports are added at
+// runtime, such as tunnel and patch ports created on Open vSwitch.
+// Links out a removed port go down with it.
+func (o *OgoInstance) PortStatus(dpid net.HardwareAddr, msg *ofp10.PortStatus) {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return
+	}
+	p := msg.Desc
+	switch msg.Reason {
+	case ofp10.PR_ADD:
+		sw.SetPort(p.PortNo, p)
+		Publish(EventPortAdded, dpid, p)
+	case ofp10.PR_MODIFY:
+		sw.SetPort(p.PortNo, p)
+		Publish(EventPortModified, dpid, p)
+	case ofp10.PR_DELETE:
+		sw.deletePort(p.PortNo)
+		for _, l := range sw.Links() {
+			if l.Port == p.PortNo {
+				sw.deleteLink(l.DPID)
+			}
+		}
+		Publish(EventPortDeleted, dpid, p)
+	}
+}
+
 func (o *OgoInstance) PacketIn(dpid net.HardwareAddr, msg *ofp10.PacketIn) {
 	eth := msg.Data
-	if buf, ok := eth.Data.(*util.Buffer); ok && eth.Ethertype == 0xa0f1 {
+	if buf, ok := eth.Data.(*util.Buffer); ok && (eth.Ethertype == 0xa0f1 || eth.Ethertype == BDDPEthertype) {
 		linkMsg := NewLinkDiscovery()
 		if err := linkMsg.UnmarshalBinary(buf.Bytes()); err != nil {
 			log.Println(err)
 			return
 		}
+		if !acceptProbe(dpid, msg.InPort, linkMsg, eth.Ethertype == BDDPEthertype) {
+			return
+		}
 
 		latency := time.Since(time.Unix(0, linkMsg.Nsec))
-		l := &Link{linkMsg.SrcDPID, msg.InPort, latency, -1}
+		l := &Link{DPID: linkMsg.SrcDPID, Port: msg.InPort, Latency: latency,
+			Bandwidth: -1, Updated: time.Now(), Indirect: eth.Ethertype == BDDPEthertype}
 
 		if sw, ok := Switch(dpid); ok {
 			sw.setLink(dpid, l)
@@ -103,20 +153,55 @@ func (o *OgoInstance) linkDiscoveryLoop(dpid net.HardwareAddr) {
 			return
 		// Every two seconds send a link discovery packet.
 		case <-time.After(time.Second * 2):
-			e := eth.New()
-			e.Ethertype = 0xa0f1
-			e.HWSrc = dpid[2:]
-			linkDsc := NewLinkDiscovery()
-			linkDsc.SrcDPID = dpid
-			e.Data = linkDsc
-
-			pkt := ofp10.NewPacketOut()
-			pkt.Data = e
-			pkt.AddAction(ofp10.NewActionOutput(ofp10.P_ALL))
-
-			if sw, ok := Switch(dpid); ok {
-				sw.Send(pkt)
+			sw, ok := Switch(dpid)
+			if !ok {
+				continue
+			}
+			if sendProbes(sw, 0xa0f1) == ErrSwitchDisconnected {
+				return
+			}
+			if DiscoverIndirectLinks {
+				sendProbes(sw, BDDPEthertype)
 			}
+			sw.expireLinks(LinkTimeout)
 		}
 	}
 }
+
+// Sends a link discovery probe of ethertype out each port of
+// Switch sw. Each probe names, and with a DiscoveryKey signs, the
+// port it is sent out, so it can't be passed off as sent out
+// another.
+func sendProbes(sw *OFSwitch, ethertype uint16) error {
+	for _, p := range sw.Ports() {
+		if p.PortNo >= ofp10.P_MAX {
+			continue
+		}
+		if err := sw.Send(discoveryPacket(sw.DPID(), p.PortNo, ethertype)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a packet out sending a link discovery probe of
+// ethertype out port of the switch dpid. BDDP probes are
+// broadcast.
+func discoveryPacket(dpid net.HardwareAddr, port uint16, ethertype uint16) *ofp10.PacketOut {
+	e := eth.New()
+	e.Ethertype = ethertype
+	e.HWSrc = dpid[2:]
+	if ethertype == BDDPEthertype {
+		e.HWDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+	linkDsc := NewLinkDiscovery()
+	linkDsc.SrcDPID = dpid
+	linkDsc.SrcPort = port
+	signProbe(linkDsc)
+	e.Data = linkDsc
+
+	pkt := ofp10.NewPacketOut()
+	pkt.Data = e
+	pkt.AddAction(ofp10.NewActionOutput(port))
+	return pkt
+}
@@ -0,0 +1,184 @@
+package ogo
+
+import (
+	"sync"
+	"time"
+)
+
+// Published by a Dampener with a TopologyChange once topology
+// events settle.
+const EventTopologyChanged = "topology.changed"
+
+type Dampening struct {
+	// Events within Window of the first one are reported as
+	// a single change.
+	Window time.Duration
+	// A link that changes state FlapThreshold times within
+	// HoldDown is suppressed until it has been stable for
+	// HoldDown.
+	HoldDown      time.Duration
+	FlapThreshold int
+}
+
+var DefaultDampening = Dampening{
+	Window:        time.Millisecond * 500,
+	HoldDown:      time.Second * 10,
+	FlapThreshold: 3,
+}
+
+// A stabilized change to the topology. Topology leaves out
+// suppressed links.
+type TopologyChange struct {
+	Events   []Event
+	Topology *Topology
+}
+
+// The flap history of a link.
+type LinkFlaps struct {
+	Src        string
+	Dst        string
+	Flaps      int
+	Suppressed bool
+	LastChange time.Time
+}
+
+// A Dampener coalesces switch and link events into
+// topology.changed events and holds down flapping links, so
+// consumers recompute once per burst of changes.
+type Dampener struct {
+	cfg   Dampening
+	sub   *Subscription
+	mu    sync.Mutex
+	links map[string]*linkFlaps
+	// The clock suppressed links are released by.
+	now func() time.Time
+}
+
+type linkFlaps struct {
+	LinkFlaps
+	recent []time.Time
+}
+
+func NewDampener(cfg Dampening) *Dampener {
+	d := new(Dampener)
+	d.cfg = cfg
+	d.links = make(map[string]*linkFlaps)
+	d.now = time.Now
+	return d
+}
+
+func (d *Dampener) Start() {
+	d.sub = Subscribe(256, "switch.", "link.")
+	go d.loop()
+}
+
+func (d *Dampener) Stop() {
+	if d.sub != nil {
+		d.sub.Cancel()
+	}
+}
+
+// Returns the flap history of every link that has changed
+// state.
+func (d *Dampener) Flaps() []LinkFlaps {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a := make([]LinkFlaps, 0, len(d.links))
+	for _, l := range d.links {
+		a = append(a, l.LinkFlaps)
+	}
+	return a
+}
+
+func (d *Dampener) loop() {
+	pending := make([]Event, 0)
+	var window <-chan time.Time
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-d.sub.C:
+			if !ok {
+				return
+			}
+			if !d.record(e) {
+				continue
+			}
+			pending = append(pending, e)
+			if window == nil {
+				window = time.After(d.cfg.Window)
+			}
+		case <-window:
+			d.publish(pending)
+			pending = make([]Event, 0)
+			window = nil
+		case <-ticker.C:
+			// Links stable for HoldDown are released, which
+			// is itself a change.
+			if released := d.release(d.now()); len(released) > 0 {
+				pending = append(pending, released...)
+				if window == nil {
+					window = time.After(d.cfg.Window)
+				}
+			}
+		}
+	}
+}
+
+// Records e and returns false if it is for a suppressed link.
+func (d *Dampener) record(e Event) bool {
+	l, ok := e.Data.(Link)
+	if !ok {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := e.DPID.String() + "/" + l.DPID.String()
+	f, ok := d.links[key]
+	if !ok {
+		f = &linkFlaps{LinkFlaps: LinkFlaps{Src: e.DPID.String(), Dst: l.DPID.String()}}
+		d.links[key] = f
+	}
+	f.Flaps += 1
+	f.LastChange = e.Time
+	recent := make([]time.Time, 0, len(f.recent)+1)
+	for _, t := range f.recent {
+		if e.Time.Sub(t) < d.cfg.HoldDown {
+			recent = append(recent, t)
+		}
+	}
+	f.recent = append(recent, e.Time)
+	if d.cfg.FlapThreshold > 0 && len(f.recent) >= d.cfg.FlapThreshold {
+		f.Suppressed = true
+	}
+	return !f.Suppressed
+}
+
+func (d *Dampener) release(now time.Time) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a := make([]Event, 0)
+	for _, f := range d.links {
+		if f.Suppressed && now.Sub(f.LastChange) >= d.cfg.HoldDown {
+			f.Suppressed = false
+			f.recent = nil
+			a = append(a, Event{Type: "link.released", Time: now, Mono: now.Sub(processStart), Data: f.LinkFlaps})
+		}
+	}
+	return a
+}
+
+func (d *Dampener) publish(events []Event) {
+	t := CurrentTopology()
+	d.mu.Lock()
+	links := make([]TopologyLink, 0, len(t.Links))
+	for _, l := range t.Links {
+		if f, ok := d.links[l.Src+"/"+l.Dst]; ok && f.Suppressed {
+			continue
+		}
+		links = append(links, l)
+	}
+	d.mu.Unlock()
+	t.Links = links
+	Publish(EventTopologyChanged, nil, TopologyChange{events, t})
+}
@@ -0,0 +1,76 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDampenerHoldDown(t *testing.T) {
+	cfg := Dampening{Window: time.Millisecond, HoldDown: time.Second * 10, FlapThreshold: 3}
+	src := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	dst := net.HardwareAddr{0, 0, 0, 0, 0, 2}
+
+	tests := []struct {
+		name string
+		// When the link changes state, and whether each change
+		// is reported.
+		flaps    []time.Duration
+		reported []bool
+		// When the dampener looks for stable links, and how
+		// many it releases each time.
+		checks   []time.Duration
+		released []int
+	}{
+		{"below threshold",
+			[]time.Duration{0, time.Second},
+			[]bool{true, true},
+			[]time.Duration{time.Second * 20}, []int{0}},
+		{"suppressed at threshold",
+			[]time.Duration{0, time.Second, time.Second * 2},
+			[]bool{true, true, false},
+			[]time.Duration{time.Second * 5, time.Second*12 - 1, time.Second * 12, time.Second * 13}, []int{0, 0, 1, 0}},
+		{"old flaps decay",
+			[]time.Duration{0, time.Second * 10, time.Second * 20, time.Second * 30},
+			[]bool{true, true, true, true},
+			[]time.Duration{time.Second * 40}, []int{0}},
+		{"flaps extend hold down",
+			[]time.Duration{0, time.Second, time.Second * 2, time.Second * 8},
+			[]bool{true, true, false, false},
+			[]time.Duration{time.Second * 12, time.Second * 18}, []int{0, 1}},
+		{"flaps after release",
+			[]time.Duration{0, time.Second, time.Second * 2, time.Second * 13, time.Second * 14, time.Second * 15},
+			[]bool{true, true, false, true, true, false},
+			[]time.Duration{time.Second * 12, time.Second * 16}, []int{1, 0}},
+	}
+	for _, test := range tests {
+		base := time.Unix(1000, 0)
+		var now time.Time
+		d := NewDampener(cfg)
+		d.now = func() time.Time { return now }
+
+		// Flaps and checks happen in time order.
+		checks := 0
+		check := func(until time.Duration) {
+			for ; checks < len(test.checks) && test.checks[checks] <= until; checks++ {
+				now = base.Add(test.checks[checks])
+				if n := len(d.release(d.now())); n != test.released[checks] {
+					t.Errorf("%s: released %d links at %s, expected %d.", test.name, n, test.checks[checks], test.released[checks])
+				}
+			}
+		}
+		for i, at := range test.flaps {
+			check(at)
+			e := Event{Type: EventLinkDown, DPID: src, Time: base.Add(at), Data: Link{DPID: dst}}
+			if ok := d.record(e); ok != test.reported[i] {
+				t.Errorf("%s: flap at %s reported %v, expected %v.", test.name, at, ok, test.reported[i])
+			}
+		}
+		check(time.Hour)
+
+		flaps := d.Flaps()
+		if len(flaps) != 1 || flaps[0].Flaps != len(test.flaps) {
+			t.Errorf("%s: got flaps %+v, expected %d.", test.name, flaps, len(test.flaps))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package ogo
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// The default rules of a switch.
+type DefaultRulesConfig struct {
+	// The most bytes of a packet sent to the controller by the
+	// controller actions of Rules, and on OpenFlow 1.0 switches
+	// by table misses. 0xffff sends whole packets.
+	MissSendLen uint16     `json:"miss_send_len"`
+	Rules       []FlowSpec `json:"rules"`
+}
+
+// Sends table misses to the controller.
+var DefaultTableMiss = DefaultRulesConfig{
+	MissSendLen: 128,
+	Rules:       []FlowSpec{{Priority: 0, Actions: []string{"controller"}}},
+}
+
+// DefaultRules installs a DefaultRulesConfig on every switch as
+// it connects, the default one unless the switch has its own,
+// and keeps the rules pinned: every PinInterval the flow tables
+// are read back and missing or changed rules are reinstalled.
+// A rule without actions is a drop rule, so a config with the
+// rule {"priority": 0} drops table misses.
+//
+// It serves the configuration of the switch given by the dpid
+// parameter over HTTP: GET returns it and PUT replaces it with
+// the body and applies it.
+type DefaultRules struct {
+	Default     DefaultRulesConfig
+	PinInterval time.Duration
+
+	mu      sync.Mutex
+	configs map[string]DefaultRulesConfig
+	sub     *Subscription
+	done    chan bool
+}
+
+func NewDefaultRules() *DefaultRules {
+	d := new(DefaultRules)
+	d.Default = DefaultTableMiss
+	d.PinInterval = time.Second * 30
+	d.configs = make(map[string]DefaultRulesConfig)
+	return d
+}
+
+func (d *DefaultRules) Start() {
+	d.sub = Subscribe(64, EventSwitchUp)
+	d.done = make(chan bool)
+	for _, sw := range Switches() {
+		go d.apply(sw)
+	}
+	go func() {
+		for e := range d.sub.C {
+			if sw, ok := Switch(e.DPID); ok {
+				d.apply(sw)
+			}
+		}
+	}()
+	go d.pin()
+}
+
+func (d *DefaultRules) Stop() {
+	if d.sub != nil {
+		d.sub.Cancel()
+		close(d.done)
+	}
+}
+
+// Sets the configuration of Switch dpid, applying it now if the
+// switch is connected. Rules left out of the new configuration
+// stay on the switch.
+func (d *DefaultRules) Configure(dpid net.HardwareAddr, cfg DefaultRulesConfig) error {
+	fs := FlowSet{cfg.Rules}
+	if err := fs.Validate(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.configs[dpid.String()] = cfg
+	d.mu.Unlock()
+	if sw, ok := Switch(dpid); ok {
+		d.apply(sw)
+	}
+	return nil
+}
+
+// Returns the configuration of Switch dpid.
+func (d *DefaultRules) Config(dpid net.HardwareAddr) DefaultRulesConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cfg, ok := d.configs[dpid.String()]; ok {
+		return cfg
+	}
+	return d.Default
+}
+
+// Installs the default rules of Switch sw.
+func (d *DefaultRules) apply(sw *OFSwitch) {
+	cfg := d.Config(sw.DPID())
+	if sw.Version() == ofp10.VERSION {
+		// Keep the fragment mode of the switch.
+		c, err := sw.SwitchConfig(ConfigTimeout)
+		if err != nil {
+			c = SwitchConfig{}
+		}
+		c.MissSendLen = cfg.MissSendLen
+		if err := sw.SetSwitchConfig(c); err != nil {
+			return
+		}
+	}
+	for _, r := range cfg.Rules {
+		if err := d.install(sw, r, cfg.MissSendLen); err != nil {
+			log.Println("Failed to install default rule on", SwitchLabel(sw.DPID()), err)
+		}
+	}
+}
+
+func (d *DefaultRules) install(sw *OFSwitch, r FlowSpec, missSendLen uint16) error {
+	m, err := sw.flowSpecMod(r)
+	if err != nil {
+		return err
+	}
+	switch f := m.(type) {
+	case *ofp10.FlowMod:
+		for _, a := range f.Actions {
+			if o, ok := a.(*ofp10.ActionOutput); ok && o.Port == ofp10.P_CONTROLLER {
+				o.MaxLen = missSendLen
+			}
+		}
+	case *ofp14.FlowMod:
+		for _, i := range f.Instructions {
+			if apply, ok := i.(*ofp14.InstrActions); ok {
+				for _, a := range apply.Actions {
+					if o, ok := a.(*ofp14.ActionOutput); ok && o.Port == ofp14.P_CONTROLLER {
+						o.MaxLen = missSendLen
+					}
+				}
+			}
+		}
+	}
+	return sw.Send(m)
+}
+
+func (d *DefaultRules) pin() {
+	t := time.NewTicker(d.PinInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			for _, sw := range Switches() {
+				d.check(sw)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Reinstalls the default rules missing from Switch sw.
+func (d *DefaultRules) check(sw *OFSwitch) {
+	cfg := d.Config(sw.DPID())
+	live, err := sw.DumpFlows(SyncTimeout)
+	if err != nil {
+		log.Println("Failed to check the default rules of", SwitchLabel(sw.DPID())+":", err)
+		return
+	}
+	// Only compare the flows with the key of a rule, other
+	// flows aren't the business of DefaultRules.
+	rules := make(map[string]bool)
+	for _, r := range cfg.Rules {
+		if k, err := r.key(); err == nil {
+			rules[k] = true
+		}
+	}
+	have := &FlowSet{Flows: make([]FlowSpec, 0)}
+	for _, f := range live.Flows {
+		if k, err := f.key(); err == nil && rules[k] {
+			have.Flows = append(have.Flows, f)
+		}
+	}
+	diff, err := DiffFlows(have, &FlowSet{cfg.Rules})
+	if err != nil {
+		return
+	}
+	for _, r := range append(diff.Added, diff.Modified...) {
+		log.Println("Reinstalling default rule on", SwitchLabel(sw.DPID()), "priority", r.Priority)
+		if err := d.install(sw, r, cfg.MissSendLen); err != nil {
+			log.Println("Failed to install default rule on", SwitchLabel(sw.DPID()), err)
+		}
+	}
+}
+
+func (d *DefaultRules) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dpid, err := net.ParseMAC(r.URL.Query().Get("dpid"))
+	if err != nil {
+		http.Error(w, "bad dpid", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.Config(dpid))
+	case "PUT":
+		var cfg DefaultRulesConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := d.Configure(dpid, cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,76 @@
+package ogo
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// How long to wait for a switch's description when it connects.
+var DescriptionTimeout = time.Second * 2
+
+// What a switch reports about itself in its description
+// statistics.
+type SwitchDescription struct {
+	Manufacturer string `json:"manufacturer"`
+	Hardware     string `json:"hardware"`
+	Software     string `json:"software"`
+	SerialNumber string `json:"serial_number"`
+	Datapath     string `json:"datapath"`
+}
+
+// Requests the description of Switch s and stores it.
+func (s *OFSwitch) RequestDescription(timeout time.Duration) (*SwitchDescription, error) {
+	var d *SwitchDescription
+	if s.Version() == ofp10.VERSION {
+		rep, err := s.SendAndReceive(ofp10.NewStatsRequest(ofp10.StatsType_Desc, nil), timeout)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := rep.(*ofp10.StatsReply)
+		if !ok {
+			return nil, errors.New("Unexpected reply to description request.")
+		}
+		body, ok := r.Body.(*ofp10.DescStats)
+		if !ok {
+			return nil, errors.New("Unexpected reply to description request.")
+		}
+		d = &SwitchDescription{cstring(body.MfrDesc), cstring(body.HWDesc),
+			cstring(body.SWDesc), cstring(body.SerialNum), cstring(body.DPDesc)}
+	} else {
+		reps, err := s.requestMultipart(s.newMultipartRequest(ofp14.MultipartType_Desc, nil), timeout)
+		if err != nil {
+			return nil, err
+		}
+		body, ok := reps[0].Body.(*ofp14.DescStats)
+		if !ok {
+			return nil, errors.New("Unexpected reply to description request.")
+		}
+		d = &SwitchDescription{cstring(body.MfrDesc), cstring(body.HWDesc),
+			cstring(body.SWDesc), cstring(body.SerialNum), cstring(body.DPDesc)}
+	}
+
+	s.descMu.Lock()
+	s.desc = d
+	s.descMu.Unlock()
+	return d, nil
+}
+
+// Returns the description of Switch s, or nil if it hasn't been
+// received.
+func (s *OFSwitch) Description() *SwitchDescription {
+	s.descMu.RLock()
+	defer s.descMu.RUnlock()
+	return s.desc
+}
+
+// Returns a null terminated string.
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,120 @@
+package ogo
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long to wait for a switch to accept a connection, and the
+// range of delays between failed attempts. The delay doubles
+// after every failure and is reset once a switch completes the
+// handshake. A Dialer uses the values set when it is started.
+var (
+	DialTimeout    = time.Second * 5
+	DialMinBackoff = time.Second
+	DialMaxBackoff = time.Minute
+)
+
+// A Dialer connects to a switch listening for controllers, for
+// switches configured with a passive ("ptcp") controller
+// connection. It reconnects whenever the connection is lost
+// until stopped.
+type Dialer struct {
+	Addr   string
+	c      *Controller
+	mu     sync.Mutex
+	stream *MessageStream
+	stop   chan struct{}
+	once   sync.Once
+
+	// DialTimeout, DialMinBackoff and DialMaxBackoff
+	timeout, min, max time.Duration
+}
+
+// Starts connecting to the switch listening on addr.
+func (c *Controller) Dial(addr string) *Dialer {
+	d := &Dialer{Addr: addr, c: c, stop: make(chan struct{})}
+	d.timeout, d.min, d.max = DialTimeout, DialMinBackoff, DialMaxBackoff
+	go d.loop()
+	return d
+}
+
+// Stops reconnecting to the switch and closes the connection.
+func (d *Dialer) Stop() {
+	d.once.Do(func() {
+		close(d.stop)
+		d.mu.Lock()
+		if d.stream != nil {
+			d.stream.Close()
+		}
+		d.mu.Unlock()
+	})
+}
+
+// Returns true if the switch is connected.
+func (d *Dialer) Connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stream != nil
+}
+
+func (d *Dialer) loop() {
+	backoff := d.min
+	for {
+		if d.stopped() {
+			return
+		}
+		wait := d.min
+		if d.connect() {
+			backoff = d.min
+		} else {
+			wait = backoff
+			backoff *= 2
+			if backoff > d.max {
+				backoff = d.max
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Connects to the switch and serves the connection until it is
+// closed. Returns true if the handshake succeeded.
+func (d *Dialer) connect() bool {
+	conn, err := net.DialTimeout("tcp", d.Addr, d.timeout)
+	if err != nil {
+		log.Println("Failed to connect to", d.Addr, err)
+		return false
+	}
+	stream := NewMessageStream(conn)
+	d.mu.Lock()
+	d.stream = stream
+	d.mu.Unlock()
+	// Stop may have missed the stream.
+	if d.stopped() {
+		stream.Close()
+	}
+
+	ok := d.c.serve(stream)
+	<-stream.Done()
+
+	d.mu.Lock()
+	d.stream = nil
+	d.mu.Unlock()
+	return ok
+}
+
+func (d *Dialer) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,95 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Completes the handshake as a switch with dpid on conn.
+func acceptHandshake(t *testing.T, conn net.Conn, dpid net.HardwareAddr) {
+	if _, err := readRaw(conn); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Write(marshal(t, ofpxx.NewHelloVersions(ofp10.VERSION)))
+	if _, err := readRaw(conn); err != nil {
+		t.Error(err)
+		return
+	}
+	features := ofp10.NewFeaturesReply()
+	features.DPID = dpid
+	conn.Write(marshal(t, features))
+}
+
+func TestDialerBackoff(t *testing.T) {
+	defer func(min, max time.Duration) {
+		DialMinBackoff, DialMaxBackoff = min, max
+	}(DialMinBackoff, DialMaxBackoff)
+	DialMinBackoff, DialMaxBackoff = time.Millisecond*50, time.Millisecond*200
+	network = NewNetwork()
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c := &Controller{HandshakeFailed: func(*HandshakeError) {}}
+	d := c.Dial(l.Addr().String())
+
+	// Each attempt is refused by closing the connection, but
+	// the fifth completes the handshake. The delay before an
+	// attempt doubles after each failure, up to the maximum,
+	// and starts over once a switch connects.
+	expected := []time.Duration{0, 50, 100, 200, 200, 50, 50, 100}
+	last := time.Now()
+	for i, e := range expected {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait := time.Since(last); i > 0 && (wait < e*time.Millisecond*9/10 || wait > e*time.Millisecond+time.Millisecond*150) {
+			t.Errorf("Attempt %d came after %s, expected %dms.", i, wait, e)
+		}
+		if i == 4 {
+			acceptHandshake(t, conn, dpid)
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if _, ok := Switch(dpid); ok {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if _, ok := Switch(dpid); !ok {
+				t.Error("The switch wasn't added.")
+			}
+			if !d.Connected() {
+				t.Error("Not connected after the handshake.")
+			}
+		}
+		conn.Close()
+		last = time.Now()
+	}
+
+	d.Stop()
+	deadline := time.Now().Add(time.Second)
+	for d.Connected() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if d.Connected() {
+		t.Error("Still connected after Stop.")
+	}
+	if sw, ok := Switch(dpid); ok {
+		closeSession(sw)
+	}
+	// Stopped dialers don't reconnect.
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(DialMaxBackoff * 2))
+	if conn, err := l.Accept(); err == nil {
+		conn.Close()
+		t.Error("Reconnected after Stop.")
+	}
+}
@@ -0,0 +1,203 @@
+package ogo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Published when a link discovery probe fails verification, with
+// the ForgedProbe as data.
+const EventProbeForged = "discovery.forged"
+
+// Identifies this controller instance in the link discovery
+// probes it sends. Random unless set before switches connect.
+var ControllerID uint64
+
+// If set, link discovery probes are signed with this key and only
+// probes signed with it are believed, so frames forged by hosts
+// or sent by controllers not sharing the key can't add links to
+// the topology. Controllers of one network share the key.
+var DiscoveryKey []byte
+
+// If true, signed probes sent by other controller instances are
+// ignored too.
+var DiscoveryOwnProbesOnly = false
+
+// Probes older than this are rejected as replayed when a
+// DiscoveryKey is set. Probes are sent every two seconds, so a
+// genuine probe is never much older than that.
+var DiscoveryMaxAge = time.Second * 2
+
+// The most forged probes remembered.
+const maxForgedProbes = 64
+
+func init() {
+	var b [8]byte
+	rand.Read(b[:])
+	ControllerID = binary.BigEndian.Uint64(b[:])
+}
+
+// A probe that failed verification, received from Switch DPID on
+// Port, claiming to come from Claimed.
+type ForgedProbe struct {
+	Time         time.Time `json:"time"`
+	DPID         string    `json:"dpid"`
+	Port         uint16    `json:"port"`
+	Claimed      string    `json:"claimed"`
+	ControllerID uint64    `json:"controller_id"`
+	Reason       string    `json:"reason"`
+}
+
+// Counts of the link discovery probes received.
+type DiscoveryStats struct {
+	// Probes believed, by whether this controller sent them.
+	Own  uint64 `json:"own"`
+	Peer uint64 `json:"peer"`
+	// Probes rejected, by reason.
+	Forged map[string]uint64 `json:"forged"`
+	// The last probes rejected, oldest first.
+	Recent []ForgedProbe `json:"recent"`
+}
+
+var discovery = struct {
+	sync.Mutex
+	own, peer uint64
+	forged    map[string]uint64
+	recent    []ForgedProbe
+	// The tokens of the probes believed, in two generations
+	// replaced every DiscoveryMaxAge, so each is kept at least
+	// until it is stale.
+	seen, lastSeen map[string]bool
+	rotated        time.Time
+}{forged: make(map[string]uint64), seen: make(map[string]bool)}
+
+// Returns the token of probe d under key.
+func probeToken(d *LinkDiscovery, key []byte) []byte {
+	var b [26]byte
+	copy(b[:8], d.SrcDPID)
+	binary.BigEndian.PutUint64(b[8:], uint64(d.Nsec))
+	binary.BigEndian.PutUint64(b[16:], d.ControllerID)
+	binary.BigEndian.PutUint16(b[24:], d.SrcPort)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b[:])
+	return mac.Sum(nil)
+}
+
+// Sets the controller ID of probe d and signs it if a
+// DiscoveryKey is set.
+func signProbe(d *LinkDiscovery) {
+	d.ControllerID = ControllerID
+	if DiscoveryKey != nil {
+		d.Token = probeToken(d, DiscoveryKey)
+	}
+}
+
+// Returns why probe d, received at now, must not be believed, or
+// "". Without a DiscoveryKey every probe is believed.
+func verifyProbe(d *LinkDiscovery, now time.Time) string {
+	if DiscoveryKey == nil {
+		return ""
+	}
+	switch {
+	case d.Legacy:
+		return "unsigned"
+	case !hmac.Equal(d.Token, probeToken(d, DiscoveryKey)):
+		return "bad token"
+	case now.Sub(time.Unix(0, d.Nsec)) > DiscoveryMaxAge:
+		return "stale"
+	case d.ControllerID != ControllerID && DiscoveryOwnProbesOnly:
+		return "other controller"
+	}
+	return ""
+}
+
+// Returns true if a probe with key was believed before, and
+// remembers key otherwise. Called with discovery locked.
+func probeSeen(key string, now time.Time) bool {
+	if now.Sub(discovery.rotated) > DiscoveryMaxAge {
+		discovery.seen, discovery.lastSeen = make(map[string]bool), discovery.seen
+		discovery.rotated = now
+	}
+	if discovery.seen[key] || discovery.lastSeen[key] {
+		return true
+	}
+	discovery.seen[key] = true
+	return false
+}
+
+// Verifies probe d, received from Switch dpid on port, and counts
+// it. Returns true if it is believed. Each signed probe is
+// believed once. BDDP probes are broadcast and may reach several
+// switches, so they are believed once per receiving port.
+func acceptProbe(dpid net.HardwareAddr, port uint16, d *LinkDiscovery, indirect bool) bool {
+	now := time.Now()
+	reason := verifyProbe(d, now)
+	discovery.Lock()
+	if reason == "" && DiscoveryKey != nil {
+		key := string(d.Token)
+		if indirect {
+			key += fmt.Sprint(dpid, port)
+		}
+		if probeSeen(key, now) {
+			reason = "replayed"
+		}
+	}
+	if reason == "" {
+		if !d.Legacy && d.ControllerID == ControllerID {
+			discovery.own += 1
+		} else {
+			discovery.peer += 1
+		}
+		discovery.Unlock()
+		return true
+	}
+	f := ForgedProbe{now, dpid.String(), port, d.SrcDPID.String(), d.ControllerID, reason}
+	discovery.forged[reason] += 1
+	discovery.recent = append(discovery.recent, f)
+	if len(discovery.recent) > maxForgedProbes {
+		discovery.recent = discovery.recent[len(discovery.recent)-maxForgedProbes:]
+	}
+	discovery.Unlock()
+	log.Println("Rejected link discovery probe on", SwitchLabel(dpid), port, "claiming", SwitchLabel(d.SrcDPID)+":", reason)
+	Publish(EventProbeForged, dpid, f)
+	return false
+}
+
+// Returns the counts of the link discovery probes received.
+func ProbeStats() DiscoveryStats {
+	discovery.Lock()
+	defer discovery.Unlock()
+	s := DiscoveryStats{Own: discovery.own, Peer: discovery.peer, Forged: make(map[string]uint64),
+		Recent: make([]ForgedProbe, len(discovery.recent))}
+	for r, n := range discovery.forged {
+		s.Forged[r] = n
+	}
+	copy(s.Recent, discovery.recent)
+	return s
+}
+
+func serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProbeStats())
+}
+
+func writeDiscoveryMetrics(w io.Writer) {
+	s := ProbeStats()
+	fmt.Fprintln(w, "# TYPE ogo_discovery_probes_total counter")
+	fmt.Fprintf(w, "ogo_discovery_probes_total{source=\"own\"} %d\n", s.Own)
+	fmt.Fprintf(w, "ogo_discovery_probes_total{source=\"peer\"} %d\n", s.Peer)
+	fmt.Fprintln(w, "# TYPE ogo_discovery_forged_total counter")
+	for _, r := range []string{"unsigned", "bad token", "stale", "replayed", "other controller"} {
+		fmt.Fprintf(w, "ogo_discovery_forged_total{reason=%q} %d\n", r, s.Forged[r])
+	}
+}
@@ -0,0 +1,69 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptProbe(t *testing.T) {
+	defer func(key []byte) { DiscoveryKey = key }(DiscoveryKey)
+	DiscoveryKey = []byte("secret")
+	src := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	dst := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 2}
+	probe := func(port uint16) *LinkDiscovery {
+		d := NewLinkDiscovery()
+		d.SrcDPID, d.SrcPort = src, port
+		signProbe(d)
+		return d
+	}
+
+	sent := probe(1)
+	moved := probe(2)
+	moved.SrcPort = 3
+	forged := probe(4)
+	forged.Token = probeToken(forged, []byte("guess"))
+	expired := NewLinkDiscovery()
+	expired.SrcDPID, expired.SrcPort = src, 5
+	expired.Nsec = time.Now().Add(-2 * DiscoveryMaxAge).UnixNano()
+	signProbe(expired)
+	unsigned := probe(6)
+	unsigned.Legacy = true
+	broadcast := probe(7)
+
+	tests := []struct {
+		d        *LinkDiscovery
+		port     uint16
+		indirect bool
+		accepted bool
+	}{
+		{sent, 1, false, true},
+		// Replayed, even on another port.
+		{sent, 1, false, false},
+		{sent, 2, false, false},
+		// Claiming another egress port.
+		{moved, 1, false, false},
+		{forged, 1, false, false},
+		{expired, 1, false, false},
+		{unsigned, 1, false, false},
+		// Broadcast probes reach several ports, but each once.
+		{broadcast, 1, true, true},
+		{broadcast, 2, true, true},
+		{broadcast, 2, true, false},
+	}
+	for i, test := range tests {
+		if acceptProbe(dst, test.port, test.d, test.indirect) != test.accepted {
+			t.Errorf("Test %d: expected the probe accepted to be %v.", i, test.accepted)
+		}
+	}
+
+	// Probes survive the wire with their port.
+	data, _ := sent.MarshalBinary()
+	d := NewLinkDiscovery()
+	if err := d.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if d.SrcPort != 1 || verifyProbe(d, time.Now()) != "" {
+		t.Errorf("Got port %d and %q back.", d.SrcPort, verifyProbe(d, time.Now()))
+	}
+}
@@ -0,0 +1,429 @@
+package ogo
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/dns"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/udp"
+)
+
+// Priority of the flows sending DNS responses to the controller.
+var DNSPuntPriority uint16 = 0x9100
+
+// Priority of the flows of a DNSBlock.
+var DNSBlockPriority uint16 = 0x9200
+
+// The shortest time a resolved address is kept, so records with
+// tiny TTLs don't make policies churn flows.
+var DNSMinTTL = time.Second * 10
+
+// Published when a name resolves to a new address and when the
+// address expires. The event data is a DNSMapping.
+const (
+	EventDNSAdded   = "dns.added"
+	EventDNSExpired = "dns.expired"
+)
+
+// An address a name resolved to, learnt from a DNS response, and
+// when it expires by the TTL of the response.
+type DNSMapping struct {
+	Name    string    `json:"name"`
+	IP      net.IP    `json:"ip"`
+	Expires time.Time `json:"expires"`
+}
+
+// Reports that m was added, or expired if added is false.
+type DNSWatchFunc func(m DNSMapping, added bool)
+
+type dnsWatch struct {
+	pattern string
+	fn      DNSWatchFunc
+}
+
+// A DNSSnooper sends the DNS responses switches forward to the
+// controller and learns the addresses names resolve to from
+// them, so policies can follow names, like blocking traffic to
+// *.example.com, with rules on addresses. A name an alias
+// resolves through is learnt with the alias: a response for
+// www.example.com that is a CNAME for example.com, with an A
+// record for example.com, maps both names to the address.
+//
+// Responses are only snooped from OpenFlow 1.0 packet-ins and
+// aren't consumed, so the packet-in handlers and applications
+// forwarding traffic must still forward them.
+type DNSSnooper struct {
+	mu sync.Mutex
+	// Expiry of the addresses of each name, by name then
+	// address.
+	names   map[string]map[string]time.Time
+	watches []dnsWatch
+	sub     *Subscription
+}
+
+func NewDNSSnooper() *DNSSnooper {
+	d := new(DNSSnooper)
+	d.names = make(map[string]map[string]time.Time)
+	d.watches = make([]dnsWatch, 0)
+	return d
+}
+
+// Starts punting DNS responses on the switches of c and learning
+// from them.
+func (d *DNSSnooper) Attach(c *Controller) {
+	c.AddPacketInHandler("dns", 1<<21, d)
+	d.sub = Subscribe(64, EventSwitchUp)
+	for _, sw := range Switches() {
+		d.punt(sw)
+	}
+	go d.loop()
+}
+
+func (d *DNSSnooper) Stop() {
+	if d.sub != nil {
+		d.sub.Cancel()
+	}
+}
+
+func (d *DNSSnooper) loop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case e, ok := <-d.sub.C:
+			if !ok {
+				return
+			}
+			if sw, ok := Switch(e.DPID); ok {
+				d.punt(sw)
+			}
+		case now := <-t.C:
+			d.expire(now)
+		}
+	}
+}
+
+// Sends DNS responses from Switch sw to the controller whole.
+func (d *DNSSnooper) punt(sw *OFSwitch) {
+	r := newRecipe(FlowMatch{IPProto: ipv4.Type_UDP, TPSrc: 53}, ofp10.P_CONTROLLER)
+	r.Priority = DNSPuntPriority
+	f, err := r.FlowMod(sw.Version())
+	if err == nil {
+		// Newer switches send packets to the controller whole
+		// by default.
+		if f, ok := f.(*ofp10.FlowMod); ok {
+			for _, a := range f.Actions {
+				if o, ok := a.(*ofp10.ActionOutput); ok {
+					o.MaxLen = 0xffff
+				}
+			}
+		}
+		err = sw.Send(f)
+	}
+	if err != nil {
+		log.Println("Failed to send DNS responses to controller:", SwitchLabel(sw.DPID()), err)
+	}
+}
+
+// Learns from the DNS responses in packet-ins, leaving them to
+// the rest of the chain.
+func (d *DNSSnooper) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok || ip.Protocol != ipv4.Type_UDP || ip.IsFragment() {
+		return false
+	}
+	u, ok := ip.Data.(*udp.UDP)
+	if !ok || u.PortSrc != 53 {
+		return false
+	}
+	msg := dns.New()
+	if err := msg.UnmarshalBinary(u.Data); err == nil {
+		d.Learn(msg)
+	}
+	return false
+}
+
+// Learns the addresses in response msg.
+func (d *DNSSnooper) Learn(msg *dns.DNS) {
+	if !msg.IsResponse() || msg.Rcode() != dns.Rcode_OK {
+		return
+	}
+	// The names each name is an alias of, by CNAME records.
+	aliases := make(map[string][]string)
+	for _, r := range msg.Answers {
+		if r.Type == dns.Type_CNAME {
+			target := canonicalName(r.Target)
+			aliases[target] = append(aliases[target], canonicalName(r.Name))
+		}
+	}
+	now := time.Now()
+	added := make([]DNSMapping, 0)
+	d.mu.Lock()
+	for _, r := range msg.Answers {
+		ip := r.IP()
+		if ip == nil || r.Class != dns.Class_IN {
+			continue
+		}
+		ttl := time.Duration(r.TTL) * time.Second
+		if ttl < DNSMinTTL {
+			ttl = DNSMinTTL
+		}
+		for _, name := range withAliases(canonicalName(r.Name), aliases) {
+			if d.add(name, ip, now.Add(ttl)) {
+				added = append(added, DNSMapping{name, ip, now.Add(ttl)})
+			}
+		}
+	}
+	watches := d.watches
+	d.mu.Unlock()
+	for _, m := range added {
+		Publish(EventDNSAdded, nil, m)
+		notifyWatches(watches, m, true)
+	}
+}
+
+// Returns name and every name that is an alias of it, directly
+// or through other aliases.
+func withAliases(name string, aliases map[string][]string) []string {
+	names := []string{name}
+	seen := map[string]bool{name: true}
+	for i := 0; i < len(names); i++ {
+		for _, a := range aliases[names[i]] {
+			if !seen[a] {
+				seen[a] = true
+				names = append(names, a)
+			}
+		}
+	}
+	return names
+}
+
+// Records that name resolves to ip until expires, and returns
+// true if it didn't already. d.mu must be held.
+func (d *DNSSnooper) add(name string, ip net.IP, expires time.Time) bool {
+	ips, ok := d.names[name]
+	if !ok {
+		ips = make(map[string]time.Time)
+		d.names[name] = ips
+	}
+	old, ok := ips[ip.String()]
+	if !ok || expires.After(old) {
+		ips[ip.String()] = expires
+	}
+	return !ok
+}
+
+// Forgets the addresses that expired by now.
+func (d *DNSSnooper) expire(now time.Time) {
+	expired := make([]DNSMapping, 0)
+	d.mu.Lock()
+	for name, ips := range d.names {
+		for ip, expires := range ips {
+			if now.After(expires) {
+				delete(ips, ip)
+				expired = append(expired, DNSMapping{name, net.ParseIP(ip), expires})
+			}
+		}
+		if len(ips) == 0 {
+			delete(d.names, name)
+		}
+	}
+	watches := d.watches
+	d.mu.Unlock()
+	for _, m := range expired {
+		Publish(EventDNSExpired, nil, m)
+		notifyWatches(watches, m, false)
+	}
+}
+
+func notifyWatches(watches []dnsWatch, m DNSMapping, added bool) {
+	for _, w := range watches {
+		if MatchName(w.pattern, m.Name) {
+			w.fn(m, added)
+		}
+	}
+}
+
+// Calls fn with every address of the names matching pattern, see
+// MatchName, as it's learnt and as it expires, starting with
+// those already learnt.
+func (d *DNSSnooper) Watch(pattern string, fn DNSWatchFunc) {
+	d.mu.Lock()
+	// Watches are copied on write so they can be called
+	// without holding d.mu.
+	watches := make([]dnsWatch, len(d.watches), len(d.watches)+1)
+	copy(watches, d.watches)
+	d.watches = append(watches, dnsWatch{pattern, fn})
+	d.mu.Unlock()
+	for _, m := range d.Mappings(pattern) {
+		fn(m, true)
+	}
+}
+
+// Returns the addresses of the names matching pattern, see
+// MatchName, in order of name.
+func (d *DNSSnooper) Mappings(pattern string) []DNSMapping {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0)
+	for name := range d.names {
+		if MatchName(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	a := make([]DNSMapping, 0)
+	for _, name := range names {
+		for ip, expires := range d.names[name] {
+			a = append(a, DNSMapping{name, net.ParseIP(ip), expires})
+		}
+	}
+	return a
+}
+
+// Returns the addresses name resolved to.
+func (d *DNSSnooper) Resolve(name string) []net.IP {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ips := make([]net.IP, 0)
+	for ip := range d.names[canonicalName(name)] {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips
+}
+
+// Returns the names that resolved to ip.
+func (d *DNSSnooper) Names(ip net.IP) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0)
+	for name, ips := range d.names {
+		if _, ok := ips[ip.String()]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Returns true if name matches pattern: a name, or a name
+// starting with "*." matching every name below it, so
+// "*.example.com" matches www.example.com but not example.com.
+// A pattern of "*" matches every name. Case is ignored.
+func MatchName(pattern, name string) bool {
+	pattern, name = canonicalName(pattern), canonicalName(name)
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+	return pattern == name
+}
+
+// A DNSBlock drops traffic to the addresses of the names matching
+// its patterns on every switch, following the addresses a
+// DNSSnooper learns as they're added and expire.
+type DNSBlock struct {
+	snooper *DNSSnooper
+	mu      sync.Mutex
+	// The names each blocked address is blocked for, by
+	// address.
+	blocked map[string]map[string]bool
+	sub     *Subscription
+}
+
+func NewDNSBlock(s *DNSSnooper) *DNSBlock {
+	b := new(DNSBlock)
+	b.snooper = s
+	b.blocked = make(map[string]map[string]bool)
+	b.sub = Subscribe(64, EventSwitchUp)
+	go b.loop()
+	return b
+}
+
+// Drops traffic to the names matching pattern.
+func (b *DNSBlock) Block(pattern string) {
+	b.snooper.Watch(pattern, b.update)
+}
+
+func (b *DNSBlock) Stop() {
+	b.sub.Cancel()
+}
+
+// Returns the blocked addresses.
+func (b *DNSBlock) Blocked() []net.IP {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := make([]net.IP, 0, len(b.blocked))
+	for ip := range b.blocked {
+		a = append(a, net.ParseIP(ip))
+	}
+	return a
+}
+
+func (b *DNSBlock) update(m DNSMapping, added bool) {
+	// Flows only match IPv4 addresses.
+	if m.IP.To4() == nil {
+		return
+	}
+	key := m.IP.String()
+	b.mu.Lock()
+	names := b.blocked[key]
+	first := added && len(names) == 0
+	if added {
+		if names == nil {
+			names = make(map[string]bool)
+			b.blocked[key] = names
+		}
+		names[m.Name] = true
+	} else {
+		delete(names, m.Name)
+	}
+	last := !added && names != nil && len(names) == 0
+	if last {
+		delete(b.blocked, key)
+	}
+	b.mu.Unlock()
+	if !first && !last {
+		return
+	}
+	for _, sw := range Switches() {
+		b.install(sw, m.IP, added)
+	}
+}
+
+func (b *DNSBlock) install(sw *OFSwitch, ip net.IP, block bool) {
+	m := FlowMatch{IPDst: ip}
+	var err error
+	if block {
+		err = sw.installDrop(m, DNSBlockPriority)
+	} else {
+		err = sw.installOutputs(m, DNSBlockPriority, nil)
+	}
+	if err != nil {
+		log.Println("Failed to update DNS block of", ip, "on", SwitchLabel(sw.DPID()), err)
+	}
+}
+
+func (b *DNSBlock) loop() {
+	for e := range b.sub.C {
+		sw, ok := Switch(e.DPID)
+		if !ok {
+			continue
+		}
+		for _, ip := range b.Blocked() {
+			b.install(sw, ip, true)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package ogo
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// A Domain is a named group of switches, such as a pod or a
+// site. Applications can be registered for a single domain and
+// topology queries can be scoped to one.
+type Domain struct {
+	Name     string
+	mu       sync.RWMutex
+	switches map[string]bool
+	apps     []ApplicationInstanceGenerator
+}
+
+var domains = struct {
+	sync.RWMutex
+	m map[string]*Domain
+	// Maps switch DPIDs to the name of their domain.
+	byDPID map[string]string
+}{m: make(map[string]*Domain), byDPID: make(map[string]string)}
+
+// Returns the domain called name, creating it if it doesn't
+// exist.
+func (c *Controller) AddDomain(name string) *Domain {
+	domains.Lock()
+	defer domains.Unlock()
+	if d, ok := domains.m[name]; ok {
+		return d
+	}
+	d := &Domain{Name: name, switches: make(map[string]bool)}
+	domains.m[name] = d
+	return d
+}
+
+// Returns the domain called name.
+func LookupDomain(name string) (*Domain, bool) {
+	domains.RLock()
+	defer domains.RUnlock()
+	d, ok := domains.m[name]
+	return d, ok
+}
+
+// Returns the names of all domains.
+func Domains() []string {
+	domains.RLock()
+	defer domains.RUnlock()
+	a := make([]string, 0, len(domains.m))
+	for name := range domains.m {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Returns the name of the domain Switch dpid belongs to.
+func DomainOf(dpid net.HardwareAddr) (string, bool) {
+	domains.RLock()
+	defer domains.RUnlock()
+	name, ok := domains.byDPID[dpid.String()]
+	return name, ok
+}
+
+// Adds Switch dpid to domain d, removing it from any other
+// domain. Domain applications are only created for switches
+// that are in the domain when they connect.
+func (d *Domain) AddSwitch(dpid net.HardwareAddr) {
+	domains.Lock()
+	old, ok := domains.byDPID[dpid.String()]
+	domains.byDPID[dpid.String()] = d.Name
+	prev := domains.m[old]
+	domains.Unlock()
+
+	if ok && prev != nil && prev != d {
+		prev.mu.Lock()
+		delete(prev.switches, dpid.String())
+		prev.mu.Unlock()
+	}
+	d.mu.Lock()
+	d.switches[dpid.String()] = true
+	d.mu.Unlock()
+}
+
+// Removes Switch dpid from domain d.
+func (d *Domain) RemoveSwitch(dpid net.HardwareAddr) {
+	domains.Lock()
+	if domains.byDPID[dpid.String()] == d.Name {
+		delete(domains.byDPID, dpid.String())
+	}
+	domains.Unlock()
+	d.mu.Lock()
+	delete(d.switches, dpid.String())
+	d.mu.Unlock()
+}
+
+// Returns true if Switch dpid is in domain d.
+func (d *Domain) Contains(dpid net.HardwareAddr) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.switches[dpid.String()]
+}
+
+// Returns the connected switches in domain d.
+func (d *Domain) Switches() []*OFSwitch {
+	a := make([]*OFSwitch, 0)
+	for _, sw := range Switches() {
+		if d.Contains(sw.DPID()) {
+			a = append(a, sw)
+		}
+	}
+	return a
+}
+
+// Registers an application that is only instantiated for
+// switches in domain d.
+func (d *Domain) RegisterApplication(fn ApplicationInstanceGenerator) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.apps = append(d.apps, fn)
+}
+
+// Returns the applications registered for the domain of Switch
+// dpid.
+func domainApplications(dpid net.HardwareAddr) []ApplicationInstanceGenerator {
+	name, ok := DomainOf(dpid)
+	if !ok {
+		return nil
+	}
+	d, ok := LookupDomain(name)
+	if !ok {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]ApplicationInstanceGenerator(nil), d.apps...)
+}
+
+// Returns the part of topology t inside domain name: its
+// switches, the links between them and their hosts.
+func (t *Topology) Domain(name string) *Topology {
+	d, ok := LookupDomain(name)
+	s := &Topology{make([]TopologySwitch, 0), make([]TopologyLink, 0), make([]TopologyHost, 0)}
+	if !ok {
+		return s
+	}
+	in := func(dpid string) bool {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.switches[dpid]
+	}
+	for _, sw := range t.Switches {
+		if in(sw.DPID) {
+			s.Switches = append(s.Switches, sw)
+		}
+	}
+	for _, l := range t.Links {
+		if in(l.Src) && in(l.Dst) {
+			s.Links = append(s.Links, l)
+		}
+	}
+	for _, h := range t.Hosts {
+		if in(h.DPID) {
+			s.Hosts = append(s.Hosts, h)
+		}
+	}
+	return s
+}
+
+// A link between switches in two different domains.
+type InterDomainLink struct {
+	TopologyLink
+	SrcDomain string
+	DstDomain string
+}
+
+// Returns the links of t that connect different domains.
+// Switches without a domain are treated as their own domain
+// named after their DPID.
+func (t *Topology) InterDomainLinks() []InterDomainLink {
+	a := make([]InterDomainLink, 0)
+	for _, l := range t.Links {
+		src, dst := domainName(l.Src), domainName(l.Dst)
+		if src != dst {
+			a = append(a, InterDomainLink{l, src, dst})
+		}
+	}
+	return a
+}
+
+func domainName(dpid string) string {
+	domains.RLock()
+	defer domains.RUnlock()
+	if name, ok := domains.byDPID[dpid]; ok {
+		return name
+	}
+	return dpid
+}
+
+// Returns the shortest sequence of domains from domain src to
+// domain dst over the inter-domain links of t. Paths can then be
+// computed within each domain separately. Returns nil if dst
+// can't be reached.
+func (t *Topology) DomainPath(src, dst string) []string {
+	adj := make(map[string][]string)
+	for _, l := range t.InterDomainLinks() {
+		adj[l.SrcDomain] = append(adj[l.SrcDomain], l.DstDomain)
+	}
+	prev := map[string]string{src: ""}
+	queue := []string{src}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		if d == dst {
+			path := make([]string, 0)
+			for ; d != ""; d = prev[d] {
+				path = append([]string{d}, path...)
+			}
+			return path
+		}
+		for _, n := range adj[d] {
+			if _, ok := prev[n]; !ok {
+				prev[n] = d
+				queue = append(queue, n)
+			}
+		}
+	}
+	return nil
+}
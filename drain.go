@@ -0,0 +1,407 @@
+package ogo
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// How long Drain and DrainLink wait for the flows pinned to a
+// switch or link to be removed.
+var DrainTimeout = 10 * time.Second
+
+// The applications whose flows carry traffic along paths, so
+// every switch they are installed on is pinned by them.
+var DrainOwners = []string{"ecmp"}
+
+// How often Drain looks at the flow shadow.
+const drainPoll = 100 * time.Millisecond
+
+// The switches and the ports drained for maintenance, by DPID,
+// and since when. Guarded by the topology lock, as they change
+// the topology.
+var (
+	drainedSwitches = make(map[string]time.Time)
+	drainedPorts    = make(map[string]map[uint16]time.Time)
+)
+
+// Returns true if Switch dpid is drained. The topology lock must
+// be held.
+func switchDrained(dpid string) bool {
+	_, ok := drainedSwitches[dpid]
+	return ok
+}
+
+// Returns true if port of Switch dpid is drained. The topology
+// lock must be held.
+func portDrained(dpid string, port uint16) bool {
+	_, ok := drainedPorts[dpid][port]
+	return ok
+}
+
+// Returns the drained ports of Switch dpid, sorted. The topology
+// lock must be held.
+func drainedPortsOf(dpid string) []uint16 {
+	a := make([]uint16, 0, len(drainedPorts[dpid]))
+	for port := range drainedPorts[dpid] {
+		a = append(a, port)
+	}
+	sort.Sort(portNumbers(a))
+	return a
+}
+
+// Marks the switches of t for which switchDrained returns true
+// drained, and the links to and from them, and the links out of a
+// port for which portDrained returns true and the links back
+// along them.
+func (t *Topology) markDrained(switchDrained func(dpid string) bool, portDrained func(dpid string, port uint16) bool) {
+	for i, s := range t.Switches {
+		t.Switches[i].Drained = switchDrained(s.DPID)
+	}
+	back := make(map[string]bool)
+	for i, l := range t.Links {
+		if portDrained(l.Src, l.SrcPort) {
+			t.Links[i].Drained = true
+			back[l.Dst+" "+l.Src] = true
+		}
+	}
+	for i, l := range t.Links {
+		if back[l.Src+" "+l.Dst] || switchDrained(l.Src) || switchDrained(l.Dst) {
+			t.Links[i].Drained = true
+		}
+	}
+}
+
+// Drains Switch dpid: paths computed from the topology no longer
+// cross it, and EventSwitchDrained makes the path services
+// reroute the traffic crossing it now. The switch stays drained,
+// across reconnections, until UndrainSwitch. Returns false if it
+// was already drained.
+func DrainSwitch(dpid net.HardwareAddr) bool {
+	topology.Lock()
+	_, ok := drainedSwitches[dpid.String()]
+	if !ok {
+		drainedSwitches[dpid.String()] = time.Now()
+	}
+	topology.unlock(!ok)
+	if ok {
+		return false
+	}
+	log.Println("Draining", SwitchLabel(dpid))
+	Publish(EventSwitchDrained, dpid, nil)
+	return true
+}
+
+// Returns Switch dpid to service. Returns false if it wasn't
+// drained.
+func UndrainSwitch(dpid net.HardwareAddr) bool {
+	topology.Lock()
+	_, ok := drainedSwitches[dpid.String()]
+	delete(drainedSwitches, dpid.String())
+	topology.unlock(ok)
+	if !ok {
+		return false
+	}
+	log.Println("Returned", SwitchLabel(dpid), "to service")
+	Publish(EventSwitchUndrained, dpid, nil)
+	return true
+}
+
+// Returns true if Switch dpid is drained.
+func SwitchDrained(dpid net.HardwareAddr) bool {
+	topology.RLock()
+	defer topology.RUnlock()
+	return switchDrained(dpid.String())
+}
+
+// Drains port of Switch dpid, as if the link on it were
+// administratively down: paths no longer take the link, in either
+// direction, and EventLinkDrained makes the path services move
+// the traffic taking it now to other paths. The port stays
+// drained until UndrainPort. Returns false if it was already
+// drained.
+func DrainPort(dpid net.HardwareAddr, port uint16) bool {
+	topology.Lock()
+	ports, ok := drainedPorts[dpid.String()]
+	if !ok {
+		ports = make(map[uint16]time.Time)
+		drainedPorts[dpid.String()] = ports
+	}
+	_, ok = ports[port]
+	if !ok {
+		ports[port] = time.Now()
+	}
+	topology.unlock(!ok)
+	if ok {
+		return false
+	}
+	log.Println("Draining", SwitchLabel(dpid), "port", port)
+	Publish(EventLinkDrained, dpid, port)
+	return true
+}
+
+// Returns port of Switch dpid to service. Returns false if it
+// wasn't drained.
+func UndrainPort(dpid net.HardwareAddr, port uint16) bool {
+	topology.Lock()
+	_, ok := drainedPorts[dpid.String()][port]
+	delete(drainedPorts[dpid.String()], port)
+	if len(drainedPorts[dpid.String()]) == 0 {
+		delete(drainedPorts, dpid.String())
+	}
+	topology.unlock(ok)
+	if !ok {
+		return false
+	}
+	log.Println("Returned", SwitchLabel(dpid), "port", port, "to service")
+	Publish(EventLinkUndrained, dpid, port)
+	return true
+}
+
+// Returns true if port of Switch dpid is drained.
+func PortDrained(dpid net.HardwareAddr, port uint16) bool {
+	topology.RLock()
+	defer topology.RUnlock()
+	return portDrained(dpid.String(), port)
+}
+
+// The state of a drained switch or port. Pinned lists the flows
+// still keeping traffic on it: for a switch, flows of DrainOwners
+// and flows sending out a port with a link; for a port, flows
+// sending out it or out the port at the other end of its link.
+// Routes lists the ECMP routes with a path still crossing it.
+// Flows can only be checked on switches with a flow shadow, so it
+// is Clear, and a port safe to unplug, only if every switch
+// involved has one and nothing is pinned.
+type DrainReport struct {
+	DPID string `json:"dpid"`
+	// The drained port, 0 for a drained switch, and the switch
+	// and port at the other end of its link, if it has one.
+	Port         uint16       `json:"port,omitempty"`
+	PeerDPID     string       `json:"peer_dpid,omitempty"`
+	PeerPort     uint16       `json:"peer_port,omitempty"`
+	Since        time.Time    `json:"since"`
+	Shadowed     bool         `json:"shadowed"`
+	Pinned       []FlowRecord `json:"pinned"`
+	Routes       []string     `json:"routes"`
+	Clear        bool         `json:"clear"`
+	Disconnected bool         `json:"disconnected,omitempty"`
+}
+
+// Drains Switch s and waits up to timeout for the flows pinned to
+// it to be removed.
+func (s *OFSwitch) Drain(timeout time.Duration) *DrainReport {
+	DrainSwitch(s.DPID())
+	return waitDrained(s.DrainReport, timeout)
+}
+
+// Drains port of Switch s and waits up to timeout for the flows
+// pinned to it, and to the link on it, to be removed.
+func (s *OFSwitch) DrainLink(port uint16, timeout time.Duration) *DrainReport {
+	DrainPort(s.DPID(), port)
+	return waitDrained(func() *DrainReport {
+		return s.PortDrainReport(port)
+	}, timeout)
+}
+
+func waitDrained(report func() *DrainReport, timeout time.Duration) *DrainReport {
+	deadline := time.Now().Add(timeout)
+	for {
+		r := report()
+		if r.Clear || !r.Shadowed || !time.Now().Before(deadline) {
+			return r
+		}
+		time.Sleep(drainPoll)
+	}
+}
+
+// Returns the state of Switch s as a drained switch.
+func (s *OFSwitch) DrainReport() *DrainReport {
+	dpid := s.DPID().String()
+	topology.RLock()
+	since := drainedSwitches[dpid]
+	topology.RUnlock()
+
+	r := &DrainReport{DPID: dpid, Since: since, Shadowed: s.flowShadowed(), Pinned: make([]FlowRecord, 0)}
+	for _, f := range s.PinnedFlows() {
+		r.Pinned = append(r.Pinned, newFlowRecord(dpid, f))
+	}
+	r.Routes = drainRoutes(func(l TopologyLink) bool {
+		return l.Src == dpid || l.Dst == dpid
+	})
+	r.Clear = r.Shadowed && len(r.Pinned) == 0 && len(r.Routes) == 0
+	return r
+}
+
+// Returns the state of port of Switch s as a drained port.
+func (s *OFSwitch) PortDrainReport(port uint16) *DrainReport {
+	dpid := s.DPID().String()
+	topology.RLock()
+	since := drainedPorts[dpid][port]
+	topology.RUnlock()
+
+	r := &DrainReport{DPID: dpid, Port: port, Since: since, Shadowed: s.flowShadowed(),
+		Pinned: make([]FlowRecord, 0)}
+	for _, f := range s.flowsOutputTo(port) {
+		r.Pinned = append(r.Pinned, newFlowRecord(dpid, f))
+	}
+	for _, l := range s.Links() {
+		if l.Port != port {
+			continue
+		}
+		r.PeerDPID = l.DPID.String()
+		if peer, ok := Switch(l.DPID); ok {
+			if back, ok := peer.Link(s.DPID()); ok {
+				r.PeerPort = back.Port
+				for _, f := range peer.flowsOutputTo(back.Port) {
+					r.Pinned = append(r.Pinned, newFlowRecord(r.PeerDPID, f))
+				}
+			}
+			r.Shadowed = r.Shadowed && peer.flowShadowed()
+		}
+	}
+	r.Routes = drainRoutes(func(l TopologyLink) bool {
+		return (l.Src == dpid && l.SrcPort == port) || (l.Src == r.PeerDPID && l.SrcPort == r.PeerPort)
+	})
+	r.Clear = r.Shadowed && len(r.Pinned) == 0 && len(r.Routes) == 0
+	return r
+}
+
+// Returns true if Switch s has a flow shadow.
+func (s *OFSwitch) flowShadowed() bool {
+	s.flowsMu.RLock()
+	defer s.flowsMu.RUnlock()
+	return len(s.monitors) > 0
+}
+
+// Returns the routes of the running ECMP with a path taking a
+// link for which uses returns true.
+func drainRoutes(uses func(l TopologyLink) bool) []string {
+	if e := ecmpService; e != nil {
+		return e.routesUsing(uses)
+	}
+	return make([]string, 0)
+}
+
+// Returns the flows in the flow shadow of Switch s owned by one
+// of DrainOwners or sending packets out a port with a link.
+func (s *OFSwitch) PinnedFlows() []FlowEntry {
+	links := make(map[uint32]bool)
+	for _, l := range s.Links() {
+		links[ofp14Port(l.Port)] = true
+	}
+	a := make([]FlowEntry, 0)
+	for _, f := range s.Flows() {
+		if drainOwned(f.Cookie) || outputsTo(f, links) {
+			a = append(a, f)
+		}
+	}
+	sort.Sort(flowEntries(a))
+	return a
+}
+
+// Returns the flows in the flow shadow of Switch s sending packets
+// out port.
+func (s *OFSwitch) flowsOutputTo(port uint16) []FlowEntry {
+	ports := map[uint32]bool{ofp14Port(port): true}
+	a := make([]FlowEntry, 0)
+	for _, f := range s.Flows() {
+		if outputsTo(f, ports) {
+			a = append(a, f)
+		}
+	}
+	sort.Sort(flowEntries(a))
+	return a
+}
+
+func drainOwned(cookie uint64) bool {
+	owner := FlowOwner(cookie)
+	if owner == "" {
+		return false
+	}
+	for _, o := range DrainOwners {
+		if owner == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if an action of f outputs to one of ports.
+func outputsTo(f FlowEntry, ports map[uint32]bool) bool {
+	instrs, err := ofp14.DecodeInstructions(f.Instructions)
+	if err != nil {
+		return false
+	}
+	for _, i := range instrs {
+		actions, ok := i.(*ofp14.InstrActions)
+		if !ok {
+			continue
+		}
+		for _, a := range actions.Actions {
+			if o, ok := a.(*ofp14.ActionOutput); ok && ports[o.Port] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the reports of every drained switch that is connected,
+// by DPID, then of every drained port of a connected switch, by
+// DPID and port.
+func DrainReports() []*DrainReport {
+	topology.RLock()
+	dpids := make([]string, 0, len(drainedSwitches))
+	for dpid := range drainedSwitches {
+		dpids = append(dpids, dpid)
+	}
+	ports := make(map[string][]uint16)
+	portDPIDs := make([]string, 0, len(drainedPorts))
+	for dpid := range drainedPorts {
+		ports[dpid] = drainedPortsOf(dpid)
+		portDPIDs = append(portDPIDs, dpid)
+	}
+	topology.RUnlock()
+	sort.Strings(dpids)
+	sort.Strings(portDPIDs)
+
+	a := make([]*DrainReport, 0, len(dpids))
+	for _, dpid := range dpids {
+		if sw, ok := switchByString(dpid); ok {
+			a = append(a, sw.DrainReport())
+		}
+	}
+	for _, dpid := range portDPIDs {
+		if sw, ok := switchByString(dpid); ok {
+			for _, port := range ports[dpid] {
+				a = append(a, sw.PortDrainReport(port))
+			}
+		}
+	}
+	return a
+}
+
+// Drains Switch s and, if disconnect is true, closes the
+// connection to it once nothing is pinned to it, or at once if
+// force is true.
+func (s *OFSwitch) drainAndDisconnect(disconnect, force bool) (*DrainReport, error) {
+	r := s.Drain(DrainTimeout)
+	if !disconnect {
+		return r, nil
+	}
+	if !r.Clear && !force {
+		if !r.Shadowed {
+			return r, fmt.Errorf("Switch %s has no flow shadow to confirm the drain with.", s.DPID())
+		}
+		return r, fmt.Errorf("Switch %s still has %d pinned flows and %d routes.", s.DPID(),
+			len(r.Pinned), len(r.Routes))
+	}
+	s.Disconnect()
+	r.Disconnected = true
+	return r, nil
+}
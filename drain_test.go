@@ -0,0 +1,303 @@
+package ogo
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// Returns every drained switch and port to service.
+func resetDrains() {
+	topology.Lock()
+	drainedSwitches = make(map[string]time.Time)
+	drainedPorts = make(map[string]map[uint16]time.Time)
+	topology.unlock(true)
+}
+
+// Adds four switches with a flow shadow, linked in a square:
+// 1 on port 1 to 2 on port 1, 1 on 2 to 3 on 1, 2 on 2 to 4 on 1
+// and 3 on 2 to 4 on 2. Nothing is drained.
+func drainNetwork() []*OFSwitch {
+	network = NewNetwork()
+	resetDrains()
+
+	sws := make([]*OFSwitch, 4)
+	for i := range sws {
+		sw, conn := testSwitch(net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, byte(i + 1)})
+		go io.Copy(ioutil.Discard, conn)
+		sw.links = make(map[string]*Link)
+		sw.flows = make(map[string]*FlowEntry)
+		sw.monitors = map[uint32]*ofp14.FlowMonitorRequest{1: nil}
+		sws[i] = sw
+	}
+	link := func(a *OFSwitch, aPort uint16, b *OFSwitch, bPort uint16) {
+		a.setLink(a.DPID(), &Link{DPID: b.DPID(), Port: aPort, Updated: time.Now()})
+		b.setLink(b.DPID(), &Link{DPID: a.DPID(), Port: bPort, Updated: time.Now()})
+	}
+	link(sws[0], 1, sws[1], 1)
+	link(sws[0], 2, sws[2], 1)
+	link(sws[1], 2, sws[3], 1)
+	link(sws[2], 2, sws[3], 2)
+	return sws
+}
+
+// Adds a flow sending packets out port to the flow shadow of sw.
+func addOutputFlow(t *testing.T, sw *OFSwitch, priority, port uint16) FlowEntry {
+	instr := ofp14.NewInstrApplyActions()
+	instr.AddAction(ofp14.NewActionOutput(ofp14Port(port)))
+	b, err := instr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := FlowEntry{Priority: priority, Match: *ofp14.NewMatch(), Instructions: b}
+	sw.flowsMu.Lock()
+	sw.flows[flowKey(f.TableId, f.Priority, &f.Match)] = &f
+	sw.flowsMu.Unlock()
+	return f
+}
+
+// Removes the flows of priority from the flow shadow of sw.
+func removeFlows(sw *OFSwitch, priority uint16) {
+	sw.flowsMu.Lock()
+	for k, f := range sw.flows {
+		if f.Priority == priority {
+			delete(sw.flows, k)
+		}
+	}
+	sw.flowsMu.Unlock()
+}
+
+// Returns the next event of type typ about dpid from sub.
+func waitEvent(t *testing.T, sub *Subscription, typ string, dpid net.HardwareAddr) (Event, bool) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-sub.C:
+			if e.Type == typ && e.DPID.String() == dpid.String() {
+				return e, true
+			}
+		case <-timeout:
+			t.Errorf("No %s event for %s.", typ, dpid)
+			return Event{}, false
+		}
+	}
+}
+
+// Returns true if a link of path leaves or enters dpid.
+func crosses(path []TopologyLink, dpid string) bool {
+	for _, l := range path {
+		if l.Src == dpid || l.Dst == dpid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDrainSwitch(t *testing.T) {
+	defer func(timeout time.Duration) { DrainTimeout = timeout }(DrainTimeout)
+	DrainTimeout = time.Millisecond * 300
+	sws := drainNetwork()
+	defer resetDrains()
+	src, drained, dst := sws[0].DPID(), sws[1].DPID(), sws[3].DPID()
+	if paths := CurrentTopology().EqualCostPaths(src.String(), dst.String(), 4); len(paths) != 2 {
+		t.Fatalf("Got %d paths before the drain, expected 2.", len(paths))
+	}
+
+	// A flow out the link to 4 keeps traffic on the switch, one
+	// out a port without a link doesn't.
+	addOutputFlow(t, sws[1], 10, 2)
+	addOutputFlow(t, sws[1], 20, 5)
+	sub := Subscribe(64, EventSwitchDrained)
+	defer sub.Cancel()
+	reports := make(chan *DrainReport)
+	go func() { reports <- sws[1].Drain(time.Second * 5) }()
+	waitEvent(t, sub, EventSwitchDrained, drained)
+
+	// New paths go around the drained switch, including those
+	// of routes moved off it.
+	if !SwitchDrained(drained) || DrainSwitch(drained) {
+		t.Error("The switch isn't drained.")
+	}
+	top := CurrentTopology()
+	for _, l := range top.Links {
+		if touches := l.Src == drained.String() || l.Dst == drained.String(); l.Drained != touches {
+			t.Errorf("Link %s %d to %s drained: %t.", l.Src, l.SrcPort, l.Dst, l.Drained)
+		}
+	}
+	paths := top.EqualCostPaths(src.String(), dst.String(), 4)
+	if len(paths) != 1 || crosses(paths[0], drained.String()) {
+		t.Errorf("Got paths %v, expected one around the drained switch.", paths)
+	}
+	if path := top.ShortestPath(src.String(), dst.String()); crosses(path, drained.String()) {
+		t.Errorf("The shortest path %v crosses the drained switch.", path)
+	}
+	r := &ecmpRoute{ECMPRoute: ECMPRoute{ID: "web", Src: src, Dst: dst}}
+	if paths, _, err := ecmpPaths(r, top); err != nil || len(paths) != 1 || crosses(paths[0].Links, drained.String()) {
+		t.Errorf("Got ECMP paths %v, %v, expected one around the drained switch.", paths, err)
+	}
+
+	// The drain waits for the pinned flow to go.
+	if r := sws[1].DrainReport(); r.Clear || len(r.Pinned) != 1 || r.Pinned[0].Priority != 10 {
+		t.Errorf("Got report %+v, expected the flow out port 2 pinned.", r)
+	}
+	select {
+	case r := <-reports:
+		t.Fatalf("The drain finished with a pinned flow: %+v", r)
+	case <-time.After(drainPoll * 2):
+	}
+	removeFlows(sws[1], 10)
+	select {
+	case r := <-reports:
+		if !r.Clear || !r.Shadowed || len(r.Pinned) != 0 || r.DPID != drained.String() || r.Since.IsZero() {
+			t.Errorf("Got report %+v, expected it clear.", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The drain didn't finish once nothing was pinned.")
+	}
+
+	// A drained switch is only disconnected once nothing is
+	// pinned to it, unless forced.
+	tests := []struct {
+		name   string
+		pinned bool
+		force  bool
+		err    bool
+	}{
+		{"pinned", true, false, true},
+		{"forced", true, true, false},
+		{"clear", false, false, false},
+	}
+	for _, test := range tests {
+		sw := sws[2]
+		sw.sessionMu.Lock()
+		client, server := net.Pipe()
+		go io.Copy(ioutil.Discard, server)
+		sw.stream = NewMessageStream(client)
+		sw.sessionMu.Unlock()
+		removeFlows(sw, 10)
+		if test.pinned {
+			addOutputFlow(t, sw, 10, 2)
+		}
+		r, err := sw.drainAndDisconnect(true, test.force)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v.", test.name, err)
+		}
+		if r.Disconnected == test.err || r.Clear == test.pinned {
+			t.Errorf("%s: got report %+v.", test.name, r)
+		}
+		if !test.err {
+			<-sw.stream.Done()
+		}
+		if sw.connected() == !test.err {
+			t.Errorf("%s: connected: %t.", test.name, sw.connected())
+		}
+		sw.stream.Close()
+		server.Close()
+	}
+}
+
+func TestUndrain(t *testing.T) {
+	sws := drainNetwork()
+	defer resetDrains()
+	a, b := sws[0].DPID(), sws[1].DPID()
+	i := NewInterlock()
+	status := func() []*DrainReport {
+		w := httptest.NewRecorder()
+		i.serveDrain(w, httptest.NewRequest("GET", "/switch/drain", nil))
+		var reports []*DrainReport
+		if err := json.NewDecoder(w.Body).Decode(&reports); err != nil {
+			t.Fatal(err)
+		}
+		return reports
+	}
+	undrain := func(query string) int {
+		w := httptest.NewRecorder()
+		i.serveDrain(w, httptest.NewRequest("DELETE", "/switch/drain?"+query, nil))
+		return w.Code
+	}
+	if reports := status(); len(reports) != 0 {
+		t.Errorf("Got drain reports %+v before draining.", reports)
+	}
+
+	// The port of 1 to 2 and the switch 3 are drained, with a
+	// flow out each end of the link.
+	addOutputFlow(t, sws[0], 10, 1)
+	addOutputFlow(t, sws[1], 10, 1)
+	sub := Subscribe(64, EventLinkUndrained, EventSwitchUndrained)
+	defer sub.Cancel()
+	if !DrainPort(a, 1) || DrainPort(a, 1) || !DrainSwitch(sws[2].DPID()) {
+		t.Fatal("Draining didn't start.")
+	}
+	if !PortDrained(a, 1) || PortDrained(b, 1) {
+		t.Error("Only the drained port should be drained.")
+	}
+	for _, l := range CurrentTopology().Links {
+		if l.Drained != ((l.Src == a.String() && l.Dst == b.String()) || (l.Src == b.String() && l.Dst == a.String()) ||
+			l.Src == sws[2].DPID().String() || l.Dst == sws[2].DPID().String()) {
+			t.Errorf("Link %s %d to %s drained: %t.", l.Src, l.SrcPort, l.Dst, l.Drained)
+		}
+	}
+
+	// Switches come before ports, and a port's report covers
+	// both ends of its link.
+	reports := status()
+	if len(reports) != 2 {
+		t.Fatalf("Got %d drain reports, expected 2.", len(reports))
+	}
+	if r := reports[0]; r.DPID != sws[2].DPID().String() || r.Port != 0 || !r.Clear {
+		t.Errorf("Got switch report %+v.", r)
+	}
+	if r := reports[1]; r.DPID != a.String() || r.Port != 1 || r.PeerDPID != b.String() || r.PeerPort != 1 ||
+		len(r.Pinned) != 2 || r.Clear {
+		t.Errorf("Got port report %+v, expected both flows out the link pinned.", r)
+	}
+	removeFlows(sws[0], 10)
+	removeFlows(sws[1], 10)
+	if r := sws[0].DrainLink(1, time.Second); !r.Clear || len(r.Pinned) != 0 {
+		t.Errorf("Got report %+v once nothing is pinned.", r)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"dpid=" + a.String() + "&port=2", 404},
+		{"dpid=" + a.String() + "&port=1", 204},
+		{"dpid=" + a.String() + "&port=1", 404},
+		{"dpid=" + a.String(), 404},
+		{"dpid=" + sws[2].DPID().String(), 204},
+		{"dpid=" + sws[2].DPID().String(), 404},
+		{"dpid=bad", 400},
+		{"dpid=" + a.String() + "&port=bad", 400},
+	}
+	for _, test := range tests {
+		if status := undrain(test.query); status != test.status {
+			t.Errorf("Undraining %s: got %d, expected %d.", test.query, status, test.status)
+		}
+	}
+	waitEvent(t, sub, EventLinkUndrained, a)
+	waitEvent(t, sub, EventSwitchUndrained, sws[2].DPID())
+
+	// Everything is back in service.
+	if PortDrained(a, 1) || SwitchDrained(sws[2].DPID()) {
+		t.Error("Still drained after undraining.")
+	}
+	if reports := status(); len(reports) != 0 {
+		t.Errorf("Got drain reports %+v after undraining.", reports)
+	}
+	top := CurrentTopology()
+	for _, l := range top.Links {
+		if l.Drained {
+			t.Errorf("Link %s %d to %s is still drained.", l.Src, l.SrcPort, l.Dst)
+		}
+	}
+	if paths := top.EqualCostPaths(a.String(), sws[3].DPID().String(), 4); len(paths) != 2 {
+		t.Errorf("Got %d paths after undraining, expected 2.", len(paths))
+	}
+}
@@ -0,0 +1,980 @@
+package ogo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// Priority of the flows of ECMP routes. Flows of hashed routes
+// are one above it.
+var ECMPPriority uint16 = 0x8800
+
+// The cookie of the flows of an ECMP route is ECMPCookie with the
+// index of the route in bits 16 to 47 and the index of the path
+// in the low 16 bits.
+var ECMPCookie uint64 = 0x72 << 56
+
+const (
+	ecmpCookieMask = 0xff00000000000000
+	ecmpRouteMask  = 0xffffffffffff0000
+	// The low bits of the cookie of the flow punting the
+	// first packets of hashed flows.
+	ecmpPuntPath = 0xffff
+)
+
+// Select groups of ECMP routes have id ECMPGroupBase plus the
+// index of the route.
+var ECMPGroupBase uint32 = 0x72000000
+
+// The most equal-cost paths a route is spread over.
+var ECMPMaxPaths = 8
+
+// How long the flows of a hashed flow outlive its last packet,
+// in seconds.
+var ECMPIdleTimeout uint16 = 30
+
+// Weights are only adjusted when the busiest path carries this
+// many times the load of the least busy one.
+var ECMPImbalance = 1.25
+
+// The weight of every path of a new route, and the bounds of
+// weights after rebalancing.
+const (
+	ECMPDefaultWeight = 100
+	ECMPMinWeight     = 1
+	ECMPMaxWeight     = 1000
+)
+
+// How a route spreads traffic over its paths.
+const (
+	// Every switch of the route picks among its next hops with
+	// an OpenFlow 1.3 select group.
+	ECMPSelectGroups = "groups"
+	// The controller hashes each flow at the ingress switch and
+	// pins it to one path with exact-match flows.
+	ECMPHashed = "hashed"
+)
+
+// Traffic matching Match that enters the network at Switch Src is
+// delivered out DstPort of Switch Dst over every shortest path.
+// Match can't select VLANs or transport ports, since flows are
+// spread by address.
+type ECMPRoute struct {
+	ID      string
+	Match   FlowMatch
+	Src     net.HardwareAddr
+	Dst     net.HardwareAddr
+	DstPort uint16
+}
+
+// One of the paths of an ECMP route.
+type ECMPPath struct {
+	Links []TopologyLink
+	// The share of flows sent along the path, relative to the
+	// other paths.
+	Weight uint16
+	// The traffic of every route on the busiest link of the
+	// path at the last Sample, in bytes per second.
+	Load float64
+}
+
+// The state of an ECMP route.
+type ECMPStatus struct {
+	ECMPRoute
+	Mode  string
+	Paths []ECMPPath
+}
+
+// ECMP spreads the traffic of routes over equal-cost paths.
+//
+// When every switch of a route runs OpenFlow 1.3 or later, each
+// switch gets a select group with a bucket per next hop, so the
+// switches hash flows themselves. Otherwise the first packet of
+// each flow is sent to the controller at the ingress switch,
+// which hashes its addresses to pick a path and installs flows
+// matching exactly that flow along it.
+//
+// Sample polls the bytes each path carries, from group bucket
+// counters or from the flows of each path at the ingress switch,
+// and shifts weight from paths whose busiest link carries more
+// than the others. Hashed routes only move new flows; select
+// groups move existing flows too. The traffic of hashed flows
+// whose flows expired between two samples is missed. Routes move
+// to the paths of the new topology when it changes, see migrate.
+type ECMP struct {
+	Interval time.Duration
+	mu       sync.Mutex
+	routes   map[string]*ecmpRoute
+	next     uint32
+	punter   *Punter
+	sub      *Subscription
+	stop     chan bool
+}
+
+type ecmpRoute struct {
+	ECMPRoute
+	index uint32
+	mode  string
+	paths []ECMPPath
+	// The ports of each switch's select group, in bucket order.
+	buckets map[string][]uint16
+	// Switches with flows of the route.
+	switches map[string]bool
+	// Bytes sent out each link at the last sample, and when.
+	last   map[string]uint64
+	polled time.Time
+	// The flows of hashed flows on each OpenFlow 1.0 switch, by
+	// ecmpFlowKey, until the switch reports them removed. OpenFlow
+	// 1.0 can't delete flows by cookie, so they are deleted one by
+	// one.
+	flowsMu sync.Mutex
+	flows   map[string]map[string]*Recipe
+}
+
+func NewECMP(interval time.Duration) *ECMP {
+	e := new(ECMP)
+	e.Interval = interval
+	e.routes = make(map[string]*ecmpRoute)
+	e.punter = NewPunter(e.handleFlow)
+	e.punter.Priority = ECMPPriority + 1
+	e.punter.IdleTimeout = ECMPIdleTimeout
+	e.stop = make(chan bool, 1)
+	return e
+}
+
+// The ECMP attached last, consulted by drain reports.
+var ecmpService *ECMP
+
+// Starts catching the first packets of hashed flows on c,
+// following topology changes and sampling every Interval.
+func (e *ECMP) Attach(c *Controller) {
+	ecmpService = e
+	RegisterFlowOwner("ecmp", ECMPCookie, ecmpCookieMask)
+	c.AddPacketInHandler("ecmp", 1<<19, e.punter)
+	c.RegisterApplication(func() interface{} {
+		return &ecmpExpiry{e}
+	})
+	e.sub = Subscribe(64, "switch.", "link.")
+	go func() {
+		// Every route is reinstalled on any change, so a
+		// dropped event only needs another reinstall.
+		for {
+			select {
+			case _, ok := <-e.sub.C:
+				if !ok {
+					return
+				}
+			case <-e.sub.Lost:
+			}
+			e.reinstall()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(e.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.Sample()
+			}
+		}
+	}()
+}
+
+func (e *ECMP) Stop() {
+	if e.sub != nil {
+		e.sub.Cancel()
+	}
+	select {
+	case e.stop <- true:
+	default:
+	}
+	if ecmpService == e {
+		ecmpService = nil
+	}
+}
+
+// Adds route r, or replaces the route with the same ID, and
+// installs it. The route is kept even if it can't be installed
+// now, and installed again when the topology changes.
+func (e *ECMP) Add(r ECMPRoute) error {
+	if r.ID == "" {
+		return errors.New("ECMP route has no ID.")
+	}
+	m := r.Match
+	if m.VLAN != 0 || m.TPSrc != 0 || m.TPDst != 0 || m.laterFragments {
+		return errors.New("ECMP routes can't match VLANs or transport ports.")
+	}
+	e.mu.Lock()
+	old, ok := e.routes[r.ID]
+	e.mu.Unlock()
+	if ok {
+		e.uninstall(old)
+	}
+
+	e.mu.Lock()
+	route := &ecmpRoute{ECMPRoute: r, index: e.next}
+	e.next += 1
+	e.routes[r.ID] = route
+	e.mu.Unlock()
+	return e.install(route)
+}
+
+// Removes route id and its flows.
+func (e *ECMP) Remove(id string) bool {
+	e.mu.Lock()
+	r, ok := e.routes[id]
+	delete(e.routes, id)
+	e.mu.Unlock()
+	if ok {
+		e.uninstall(r)
+	}
+	return ok
+}
+
+func (e *ECMP) Routes() []ECMPStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a := make([]ECMPStatus, 0, len(e.routes))
+	for _, r := range e.routes {
+		a = append(a, ECMPStatus{r.ECMPRoute, r.mode, append([]ECMPPath(nil), r.paths...)})
+	}
+	return a
+}
+
+func (e *ECMP) reinstall() {
+	e.mu.Lock()
+	routes := make([]*ecmpRoute, 0, len(e.routes))
+	for _, r := range e.routes {
+		routes = append(routes, r)
+	}
+	e.mu.Unlock()
+	for _, r := range routes {
+		if err := e.migrate(r); err != nil {
+			log.Println("Failed to reinstall ECMP route", r.ID+":", err)
+		}
+	}
+}
+
+// Moves r to the paths of the current topology, make before
+// break: the groups of switches joining the route are added, and
+// those of switches staying on it modified, before the flows of
+// switches leaving it are deleted, so traffic keeps flowing while
+// a switch or link is drained. Hashed flows stay on the path they
+// were pinned to until they expire, unless it lost a link. Routes
+// changing mode are reinstalled.
+func (e *ECMP) migrate(r *ecmpRoute) error {
+	t := CurrentTopology()
+	paths, mode, err := ecmpPaths(r, t)
+	e.mu.Lock()
+	oldMode, oldPaths, oldBuckets := r.mode, r.paths, r.buckets
+	oldSwitches := make(map[string]bool)
+	for dpid := range r.switches {
+		oldSwitches[dpid] = true
+	}
+	e.mu.Unlock()
+	if err != nil {
+		e.uninstall(r)
+		e.setPaths(r, nil, "")
+		return err
+	}
+	if mode != oldMode || (mode == ECMPHashed && !t.hasPaths(oldPaths)) {
+		e.uninstall(r)
+		e.setPaths(r, paths, mode)
+		return e.installPaths(r, nil)
+	}
+
+	e.setPaths(r, paths, mode)
+	if mode == ECMPHashed {
+		e.mu.Lock()
+		for dpid := range oldSwitches {
+			r.switches[dpid] = true
+		}
+		e.mu.Unlock()
+		return e.installPaths(r, nil)
+	}
+	if err := e.installPaths(r, oldBuckets); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	left := make([]string, 0)
+	for dpid := range oldSwitches {
+		if !r.switches[dpid] {
+			left = append(left, dpid)
+		}
+	}
+	e.mu.Unlock()
+	for _, dpid := range left {
+		if sw, ok := switchByString(dpid); ok {
+			r.clear(sw, true)
+		}
+	}
+	return nil
+}
+
+// Returns true if every link of paths is in t.
+func (t *Topology) hasPaths(paths []ECMPPath) bool {
+	for _, p := range paths {
+		for _, l := range p.Links {
+			found := false
+			for _, k := range t.Links {
+				if k.Src == l.Src && k.SrcPort == l.SrcPort && k.Dst == l.Dst {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Computes the paths of r and installs its flows.
+func (e *ECMP) install(r *ecmpRoute) error {
+	paths, mode, err := ecmpPaths(r, CurrentTopology())
+	if err != nil {
+		return err
+	}
+	e.setPaths(r, paths, mode)
+	return e.installPaths(r, nil)
+}
+
+// Returns the paths of r in t and how r spreads traffic over
+// them.
+func ecmpPaths(r *ecmpRoute, t *Topology) ([]ECMPPath, string, error) {
+	links := t.EqualCostPaths(r.Src.String(), r.Dst.String(), ECMPMaxPaths)
+	if links == nil {
+		return nil, "", fmt.Errorf("%s can't be reached from %s.", SwitchLabel(r.Dst), SwitchLabel(r.Src))
+	}
+	paths := make([]ECMPPath, len(links))
+	mode := ECMPSelectGroups
+	for i, l := range links {
+		paths[i] = ECMPPath{Links: l, Weight: ECMPDefaultWeight}
+		for _, hop := range l {
+			if s, ok := switchByString(hop.Src); !ok || s.Version() == ofp10.VERSION {
+				mode = ECMPHashed
+			}
+		}
+	}
+	if sw, ok := Switch(r.Dst); !ok || sw.Version() == ofp10.VERSION {
+		mode = ECMPHashed
+	}
+	return paths, mode, nil
+}
+
+// Makes paths the paths of r, and forgets the switches it was
+// installed on.
+func (e *ECMP) setPaths(r *ecmpRoute, paths []ECMPPath, mode string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Keep the weights of paths that survived.
+	for i := range paths {
+		for _, p := range r.paths {
+			if samePath(p.Links, paths[i].Links) {
+				paths[i].Weight = p.Weight
+			}
+		}
+	}
+	r.paths, r.mode = paths, mode
+	r.buckets = make(map[string][]uint16)
+	r.switches = make(map[string]bool)
+	r.last = make(map[string]uint64)
+	r.polled = time.Time{}
+}
+
+// Installs the flows of the paths of r. old holds the ports of
+// the select groups r already has on each switch.
+func (e *ECMP) installPaths(r *ecmpRoute, old map[string][]uint16) error {
+	e.mu.Lock()
+	mode := r.mode
+	e.mu.Unlock()
+	if mode == ECMPHashed {
+		sw, ok := Switch(r.Src)
+		if !ok {
+			return ErrSwitchDisconnected
+		}
+		if sw.Version() != ofp10.VERSION {
+			// Only OpenFlow 1.0 packet-ins are handled.
+			return errors.New("Hashed ECMP routes need an OpenFlow 1.0 ingress switch.")
+		}
+		e.mu.Lock()
+		r.switches[r.Src.String()] = true
+		e.mu.Unlock()
+		// Send the first packets of each flow to the
+		// controller.
+		return sw.InstallRecipe(&Recipe{Priority: ECMPPriority, Cookie: r.cookie(ecmpPuntPath),
+			Match: r.Match, Outputs: []uint16{ofp10.P_CONTROLLER}})
+	}
+	return e.installGroups(r, old)
+}
+
+// Installs a select group and a flow using it on every switch of
+// r, modifying the groups in old rather than adding them.
+// Switches joining r get theirs first, so no switch sends traffic
+// to one that can't forward it yet.
+func (e *ECMP) installGroups(r *ecmpRoute, old map[string][]uint16) error {
+	e.mu.Lock()
+	buckets, weights := r.groupBuckets()
+	r.buckets = buckets
+	for dpid := range buckets {
+		r.switches[dpid] = true
+	}
+	r.switches[r.Dst.String()] = true
+	e.mu.Unlock()
+
+	order := make([]string, 0, len(buckets))
+	for dpid := range buckets {
+		if _, ok := old[dpid]; !ok {
+			order = append(order, dpid)
+		}
+	}
+	for dpid := range buckets {
+		if _, ok := old[dpid]; ok {
+			order = append(order, dpid)
+		}
+	}
+	for _, dpid := range order {
+		sw, ok := switchByString(dpid)
+		if !ok {
+			return ErrSwitchDisconnected
+		}
+		cmd := uint16(ofp14.GC_ADD)
+		if _, ok := old[dpid]; ok {
+			cmd = ofp14.GC_MODIFY
+		}
+		if err := r.sendGroup(sw, cmd, buckets[dpid], weights[dpid]); err != nil {
+			return err
+		}
+		f := ofp14.NewFlowMod()
+		f.Header.Version = sw.Version()
+		f.Cookie = r.cookie(0)
+		f.Match = r.Match.ofp14()
+		f.Priority = ECMPPriority
+		actions := ofp14.NewInstrApplyActions()
+		actions.AddAction(ofp14.NewActionGroup(ECMPGroupBase + r.index))
+		f.AddInstruction(actions)
+		if err := sw.Send(f); err != nil {
+			return err
+		}
+	}
+	sw, ok := Switch(r.Dst)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	return sw.InstallRecipe(&Recipe{Priority: ECMPPriority, Cookie: r.cookie(0),
+		Match: r.Match, Outputs: []uint16{r.DstPort}})
+}
+
+// Returns the ports of the select group of each switch of r but
+// the last, and the weight of each port: the sum of the weights
+// of the paths leaving the switch through it. Called with e.mu
+// held.
+func (r *ecmpRoute) groupBuckets() (map[string][]uint16, map[string][]uint16) {
+	buckets := make(map[string][]uint16)
+	weights := make(map[string][]uint16)
+	for _, p := range r.paths {
+		for _, l := range p.Links {
+			i := 0
+			for i < len(buckets[l.Src]) && buckets[l.Src][i] != l.SrcPort {
+				i++
+			}
+			if i == len(buckets[l.Src]) {
+				buckets[l.Src] = append(buckets[l.Src], l.SrcPort)
+				weights[l.Src] = append(weights[l.Src], 0)
+			}
+			w := uint32(weights[l.Src][i]) + uint32(p.Weight)
+			if w > 0xffff {
+				w = 0xffff
+			}
+			weights[l.Src][i] = uint16(w)
+		}
+	}
+	return buckets, weights
+}
+
+func (r *ecmpRoute) sendGroup(sw *OFSwitch, cmd uint16, ports, weights []uint16) error {
+	g := ofp14.NewGroupMod(cmd, ofp14.GT_SELECT, ECMPGroupBase+r.index)
+	g.Header.Version = sw.Version()
+	for i, p := range ports {
+		b := ofp14.NewBucket()
+		b.Weight = weights[i]
+		b.AddAction(ofp14.NewActionOutput(ofp14Port(p)))
+		g.AddBucket(b)
+	}
+	return sw.Send(g)
+}
+
+func (r *ecmpRoute) cookie(path uint16) uint64 {
+	return ECMPCookie | uint64(r.index)<<16 | uint64(path)
+}
+
+// Deletes the flows and groups of r.
+func (e *ECMP) uninstall(r *ecmpRoute) {
+	e.mu.Lock()
+	switches := r.switches
+	r.switches = make(map[string]bool)
+	grouped := r.mode == ECMPSelectGroups
+	e.mu.Unlock()
+	for dpid := range switches {
+		if sw, ok := switchByString(dpid); ok {
+			r.clear(sw, grouped)
+		}
+	}
+}
+
+// Deletes the flows of r from Switch sw, and its select group if
+// grouped. Only flows of r are deleted: by cookie on OpenFlow 1.3
+// and later, and strictly one by one on OpenFlow 1.0.
+func (r *ecmpRoute) clear(sw *OFSwitch, grouped bool) {
+	if sw.Version() == ofp10.VERSION {
+		// The flow punting hashed flows at the ingress switch,
+		// or the flow out DstPort at the egress switch.
+		sw.RemoveRecipe(&Recipe{Priority: ECMPPriority, Match: r.Match})
+		r.flowsMu.Lock()
+		flows := r.flows[sw.DPID().String()]
+		delete(r.flows, sw.DPID().String())
+		r.flowsMu.Unlock()
+		for _, f := range flows {
+			sw.RemoveRecipe(f)
+		}
+		return
+	}
+	f := ofp14.NewFlowMod()
+	f.Header.Version = sw.Version()
+	f.Command = ofp14.FC_DELETE
+	f.TableId = 0xff // All tables
+	f.Cookie = r.cookie(0)
+	f.CookieMask = ecmpRouteMask
+	sw.Send(f)
+	if grouped {
+		g := ofp14.NewGroupMod(ofp14.GC_DELETE, ofp14.GT_SELECT, ECMPGroupBase+r.index)
+		g.Header.Version = sw.Version()
+		sw.Send(g)
+	}
+}
+
+// Identifies the flow matching m that a hashed flow has on Switch
+// dpid, by the fields it matches, as installed and as reported
+// removed.
+func ecmpFlowKey(dpid string, m *ofp10.Match) string {
+	w := m.Wildcards
+	key := dpid
+	field := func(wildcard bool, v interface{}) {
+		if wildcard {
+			key += " *"
+		} else {
+			key += fmt.Sprintf(" %v", v)
+		}
+	}
+	field(w&ofp10.FW_IN_PORT != 0, m.InPort)
+	field(w&ofp10.FW_DL_SRC != 0, m.DLSrc)
+	field(w&ofp10.FW_DL_DST != 0, m.DLDst)
+	field(w&ofp10.FW_DL_TYPE != 0, m.DLType)
+	field(w&ofp10.FW_NW_PROTO != 0, m.NWProto)
+	field(w>>ofp10.FW_NW_SRC_SHIFT&0x3f != 0, m.NWSrc)
+	field(w>>ofp10.FW_NW_DST_SHIFT&0x3f != 0, m.NWDst)
+	return key
+}
+
+// Installs f, a flow of a hashed flow of r, on Switch sw. Flows on
+// OpenFlow 1.0 switches are remembered until they are removed.
+func (r *ecmpRoute) installFlow(sw *OFSwitch, f *Recipe) error {
+	if sw.Version() != ofp10.VERSION {
+		return sw.InstallRecipe(f)
+	}
+	rule := f.Rule()
+	rule.SendRemoved = true
+	if err := sw.InstallFlowRule(rule); err != nil {
+		return err
+	}
+	m := f.Match.ofp10()
+	dpid := sw.DPID().String()
+	r.flowsMu.Lock()
+	defer r.flowsMu.Unlock()
+	if r.flows == nil {
+		r.flows = make(map[string]map[string]*Recipe)
+	}
+	if r.flows[dpid] == nil {
+		r.flows[dpid] = make(map[string]*Recipe)
+	}
+	r.flows[dpid][ecmpFlowKey(dpid, &m)] = f
+	return nil
+}
+
+// Forgets the flows of hashed flows that OpenFlow 1.0 switches
+// removed.
+type ecmpExpiry struct {
+	e *ECMP
+}
+
+func (x *ecmpExpiry) FlowRemoved(dpid net.HardwareAddr, msg *ofp10.FlowRemoved) {
+	if msg.Cookie&ecmpCookieMask != ECMPCookie {
+		return
+	}
+	index := uint32(msg.Cookie >> 16)
+	x.e.mu.Lock()
+	var r *ecmpRoute
+	for _, route := range x.e.routes {
+		if route.index == index {
+			r = route
+		}
+	}
+	x.e.mu.Unlock()
+	if r == nil {
+		return
+	}
+	r.flowsMu.Lock()
+	delete(r.flows[dpid.String()], ecmpFlowKey(dpid.String(), &msg.Match))
+	r.flowsMu.Unlock()
+}
+
+// Returns the IDs of the routes with a path taking a link for
+// which uses returns true, sorted.
+func (e *ECMP) routesUsing(uses func(l TopologyLink) bool) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a := make([]string, 0)
+	for id, r := range e.routes {
+	paths:
+		for _, p := range r.paths {
+			for _, l := range p.Links {
+				if uses(l) {
+					a = append(a, id)
+					break paths
+				}
+			}
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Picks a path for the flow of the first packet pkt of a hashed
+// route and installs flows along it, the ingress switch last so
+// the rest of the flow isn't punted again.
+func (e *ECMP) handleFlow(dpid net.HardwareAddr, pkt *ofp10.PacketIn, m FlowMatch) bool {
+	e.mu.Lock()
+	var r *ecmpRoute
+	for _, route := range e.routes {
+		if route.mode == ECMPHashed && bytes.Equal(route.Src, dpid) && route.Match.covers(m) {
+			r = route
+			break
+		}
+	}
+	if r == nil || len(r.paths) == 0 {
+		e.mu.Unlock()
+		return false
+	}
+	i := pickPath(r.paths, flowHash(m))
+	path := r.paths[i]
+	for _, l := range path.Links {
+		r.switches[l.Src] = true
+	}
+	r.switches[r.Dst.String()] = true
+	e.mu.Unlock()
+
+	t := CurrentTopology()
+	out := r.DstPort
+	for n := len(path.Links); n >= 0; n-- {
+		hop := m
+		if n > 0 {
+			// The port the flow arrives on at this hop.
+			hop.InPort = 0
+			prev := path.Links[n-1]
+			if back, ok := t.link(prev.Dst, prev.Src); ok {
+				hop.InPort = back.SrcPort
+			}
+		}
+		dst := r.Dst
+		if n < len(path.Links) {
+			out = path.Links[n].SrcPort
+			dst, _ = net.ParseMAC(path.Links[n].Src)
+		}
+		sw, ok := Switch(dst)
+		if !ok {
+			e.punter.Release(dpid, m)
+			return true
+		}
+		err := r.installFlow(sw, &Recipe{Priority: ECMPPriority + 1, Cookie: r.cookie(uint16(i)),
+			IdleTimeout: ECMPIdleTimeout, Match: hop, Outputs: []uint16{out}})
+		if err != nil {
+			log.Println("Failed to install ECMP flow on", SwitchLabel(dst)+":", err)
+			e.punter.Release(dpid, m)
+			return true
+		}
+	}
+
+	sw, ok := Switch(dpid)
+	if !ok {
+		return true
+	}
+	po := ofp10.NewPacketOut()
+	po.InPort = pkt.InPort
+	if pkt.BufferId != 0xffffffff {
+		po.BufferId = pkt.BufferId
+	} else {
+		po.Data = &pkt.Data
+	}
+	po.AddAction(ofp10.NewActionOutput(out))
+	sw.Send(po)
+	return true
+}
+
+// Hashes the addresses of the flow of m.
+func flowHash(m FlowMatch) uint32 {
+	h := fnv.New32a()
+	h.Write(m.EthSrc)
+	h.Write(m.EthDst)
+	h.Write(m.IPSrc.To4())
+	h.Write(m.IPDst.To4())
+	h.Write([]byte{m.IPProto})
+	return h.Sum32()
+}
+
+// Returns the index of the path of paths a flow with hash h
+// takes, each path getting a share of hashes in proportion to its
+// weight.
+func pickPath(paths []ECMPPath, h uint32) int {
+	total := uint32(0)
+	for _, p := range paths {
+		total += uint32(p.Weight)
+	}
+	if total == 0 {
+		return int(h % uint32(len(paths)))
+	}
+	n := h % total
+	for i, p := range paths {
+		if n < uint32(p.Weight) {
+			return i
+		}
+		n -= uint32(p.Weight)
+	}
+	return len(paths) - 1
+}
+
+// Reports whether every packet of the flow of p, an exact match
+// from PacketInMatch, matches m.
+func (m FlowMatch) covers(p FlowMatch) bool {
+	ipType := m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0
+	switch {
+	case m.InPort != 0 && m.InPort != p.InPort:
+		return false
+	case m.EthSrc != nil && !bytes.Equal(m.EthSrc, p.EthSrc):
+		return false
+	case m.EthDst != nil && !bytes.Equal(m.EthDst, p.EthDst):
+		return false
+	case m.EthType != 0 && m.EthType != p.EthType:
+		return false
+	case ipType && p.EthType != 0x0800:
+		return false
+	case m.IPSrc != nil && !maskIP(m.IPSrc, m.IPSrcMask).Equal(maskIP(p.IPSrc, m.IPSrcMask)):
+		return false
+	case m.IPDst != nil && !maskIP(m.IPDst, m.IPDstMask).Equal(maskIP(p.IPDst, m.IPDstMask)):
+		return false
+	case m.IPProto != 0 && m.IPProto != p.IPProto:
+		return false
+	}
+	return true
+}
+
+func samePath(a, b []TopologyLink) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Src != b[i].Src || a[i].SrcPort != b[i].SrcPort || a[i].Dst != b[i].Dst {
+			return false
+		}
+	}
+	return true
+}
+
+func switchByString(dpid string) (*OFSwitch, bool) {
+	mac, err := net.ParseMAC(dpid)
+	if err != nil {
+		return nil, false
+	}
+	return Switch(mac)
+}
+
+// Reads the bytes each route sent out each of its links since the
+// last sample, sets the load of every path to that of its busiest
+// link, counting every route, and rebalances the weights of
+// routes whose paths are unevenly loaded.
+func (e *ECMP) Sample() {
+	e.mu.Lock()
+	routes := make([]*ecmpRoute, 0, len(e.routes))
+	for _, r := range e.routes {
+		routes = append(routes, r)
+	}
+	e.mu.Unlock()
+
+	loads := make(map[string]float64)
+	for _, r := range routes {
+		counts, err := e.linkCounters(r)
+		if err != nil {
+			log.Println("Failed to read the counters of ECMP route", r.ID+":", err)
+			continue
+		}
+		now := time.Now()
+		e.mu.Lock()
+		elapsed := now.Sub(r.polled).Seconds()
+		for link, c := range counts {
+			delta := c
+			if prev, ok := r.last[link]; ok && c >= prev {
+				delta = c - prev
+			}
+			if !r.polled.IsZero() && elapsed > 0 {
+				loads[link] += float64(delta) / elapsed
+			}
+		}
+		r.last, r.polled = counts, now
+		e.mu.Unlock()
+	}
+
+	for _, r := range routes {
+		e.mu.Lock()
+		changed := r.rebalance(loads)
+		grouped := r.mode == ECMPSelectGroups
+		buckets, weights := r.groupBuckets()
+		e.mu.Unlock()
+		if !changed || !grouped {
+			continue
+		}
+		for dpid, ports := range buckets {
+			if sw, ok := switchByString(dpid); ok {
+				r.sendGroup(sw, ofp14.GC_MODIFY, ports, weights[dpid])
+			}
+		}
+	}
+}
+
+// Returns the bytes r has sent out each of its links, by the
+// portKey of their source port.
+func (e *ECMP) linkCounters(r *ecmpRoute) (map[string]uint64, error) {
+	e.mu.Lock()
+	mode := r.mode
+	paths := append([]ECMPPath(nil), r.paths...)
+	buckets := make(map[string][]uint16)
+	for dpid, ports := range r.buckets {
+		buckets[dpid] = ports
+	}
+	e.mu.Unlock()
+
+	counts := make(map[string]uint64)
+	if mode == ECMPHashed {
+		sw, ok := Switch(r.Src)
+		if !ok {
+			return nil, ErrSwitchDisconnected
+		}
+		cookies, err := sw.cookieCounters(r.cookie(0), ecmpRouteMask, AccountingRequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for cookie, c := range cookies {
+			i := int(cookie & 0xffff)
+			if i >= len(paths) {
+				continue
+			}
+			for _, l := range paths[i].Links {
+				counts[portKey(l.Src, l.SrcPort)] += c[1]
+			}
+		}
+		return counts, nil
+	}
+
+	for dpid, ports := range buckets {
+		sw, ok := switchByString(dpid)
+		if !ok {
+			continue
+		}
+		req := sw.newMultipartRequest(ofp14.MultipartType_Group,
+			&ofp14.GroupMultipartRequest{GroupId: ECMPGroupBase + r.index})
+		req.Header.Version = sw.Version()
+		reps, err := sw.requestMultipart(req, AccountingRequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range reps {
+			body, ok := rep.Body.(*ofp14.GroupStatsReply)
+			if !ok {
+				continue
+			}
+			for _, s := range body.Stats {
+				for i, b := range s.Buckets {
+					if s.GroupId == ECMPGroupBase+r.index && i < len(ports) {
+						counts[portKey(dpid, ports[i])] += b.ByteCount
+					}
+				}
+			}
+		}
+	}
+	return counts, nil
+}
+
+// Sets the load of each path of r from the loads of links, and
+// scales the weight of each path by the mean load over its own
+// if the paths are unevenly loaded. Returns true if weights
+// changed. Called with e.mu held.
+func (r *ecmpRoute) rebalance(loads map[string]float64) bool {
+	if len(r.paths) < 2 {
+		return false
+	}
+	min, max, sum := -1.0, 0.0, 0.0
+	for i := range r.paths {
+		load := 0.0
+		for _, l := range r.paths[i].Links {
+			if n := loads[portKey(l.Src, l.SrcPort)]; n > load {
+				load = n
+			}
+		}
+		r.paths[i].Load = load
+		sum += load
+		if load > max {
+			max = load
+		}
+		if min < 0 || load < min {
+			min = load
+		}
+	}
+	if max == 0 || max <= min*ECMPImbalance {
+		return false
+	}
+	mean := sum / float64(len(r.paths))
+	changed := false
+	for i := range r.paths {
+		p := &r.paths[i]
+		w := float64(p.Weight) * mean / max64(p.Load, mean/ECMPMaxWeight)
+		if w < ECMPMinWeight {
+			w = ECMPMinWeight
+		} else if w > ECMPMaxWeight {
+			w = ECMPMaxWeight
+		}
+		if uint16(w) != p.Weight {
+			p.Weight = uint16(w)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func max64(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,213 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+func TestPickPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []uint16
+	}{
+		{"equal", []uint16{100, 100}},
+		{"uneven", []uint16{300, 100}},
+		{"three paths", []uint16{50, 100, 250}},
+		{"minimum weight", []uint16{ECMPMinWeight, ECMPMaxWeight}},
+		{"single", []uint16{100}},
+		{"unweighted", []uint16{0, 0, 0}},
+	}
+	const flows = 40000
+	for _, test := range tests {
+		paths := make([]ECMPPath, len(test.weights))
+		total := 0
+		for i, w := range test.weights {
+			paths[i].Weight = w
+			total += int(w)
+		}
+		counts := make([]int, len(paths))
+		for i := 0; i < flows; i++ {
+			m := FlowMatch{EthType: 0x0800, IPProto: 6,
+				IPSrc: net.IPv4(10, 0, byte(i>>8), byte(i)), IPDst: net.IPv4(10, 1, 0, 1)}
+			counts[pickPath(paths, flowHash(m))]++
+		}
+		// Each path gets its share of flows, give or take 2%
+		// of them.
+		for i, n := range counts {
+			share := float64(flows) / float64(len(paths))
+			if total > 0 {
+				share = float64(flows) * float64(test.weights[i]) / float64(total)
+			}
+			if d := float64(n) - share; d > flows/50 || d < -flows/50 {
+				t.Errorf("%s: path %d got %d flows, expected about %.0f.", test.name, i, n, share)
+			}
+		}
+	}
+}
+
+func TestFlowHash(t *testing.T) {
+	a := FlowMatch{InPort: 1, EthType: 0x0800, IPProto: 6, IPSrc: net.IPv4(10, 0, 0, 1), IPDst: net.IPv4(10, 0, 0, 2)}
+	tests := []struct {
+		name string
+		m    FlowMatch
+		same bool
+	}{
+		// Only addresses are hashed, so a flow takes the same
+		// path whichever port it arrives on.
+		{"other in port", FlowMatch{InPort: 2, EthType: a.EthType, IPProto: a.IPProto, IPSrc: a.IPSrc, IPDst: a.IPDst}, true},
+		{"16-byte addresses", FlowMatch{InPort: 1, EthType: a.EthType, IPProto: a.IPProto,
+			IPSrc: net.ParseIP("10.0.0.1"), IPDst: net.ParseIP("10.0.0.2")}, true},
+		{"other source", FlowMatch{InPort: 1, EthType: a.EthType, IPProto: a.IPProto, IPSrc: net.IPv4(10, 0, 0, 3), IPDst: a.IPDst}, false},
+		{"other protocol", FlowMatch{InPort: 1, EthType: a.EthType, IPProto: 17, IPSrc: a.IPSrc, IPDst: a.IPDst}, false},
+	}
+	for _, test := range tests {
+		if same := flowHash(test.m) == flowHash(a); same != test.same {
+			t.Errorf("%s: hashes equal: %t, expected %t.", test.name, same, test.same)
+		}
+	}
+}
+
+func TestECMPRebalance(t *testing.T) {
+	path := func(src string, port uint16) ECMPPath {
+		return ECMPPath{Links: []TopologyLink{{Src: src, SrcPort: port, Dst: "00:00:00:00:00:00:00:09"}},
+			Weight: ECMPDefaultWeight}
+	}
+	a, b := "00:00:00:00:00:00:00:01", "00:00:00:00:00:00:00:02"
+	tests := []struct {
+		name    string
+		loads   map[string]float64
+		changed bool
+		// Whether the first path ends up with less weight than
+		// the second.
+		shifted bool
+	}{
+		{"idle", map[string]float64{}, false, false},
+		{"balanced", map[string]float64{portKey(a, 1): 1000, portKey(b, 1): 1000}, false, false},
+		{"within imbalance", map[string]float64{portKey(a, 1): 1200, portKey(b, 1): 1000}, false, false},
+		{"first loaded", map[string]float64{portKey(a, 1): 3000, portKey(b, 1): 1000}, true, true},
+		{"second idle", map[string]float64{portKey(a, 1): 3000}, true, true},
+		{"second loaded", map[string]float64{portKey(a, 1): 1000, portKey(b, 1): 3000}, true, false},
+		// Other links don't count.
+		{"other port", map[string]float64{portKey(a, 2): 3000, portKey(b, 1): 1000}, true, false},
+	}
+	for _, test := range tests {
+		r := &ecmpRoute{paths: []ECMPPath{path(a, 1), path(b, 1)}}
+		changed := r.rebalance(test.loads)
+		if changed != test.changed {
+			t.Errorf("%s: rebalance returned %t.", test.name, changed)
+		}
+		if shifted := r.paths[0].Weight < r.paths[1].Weight; shifted != test.shifted {
+			t.Errorf("%s: got weights %d and %d.", test.name, r.paths[0].Weight, r.paths[1].Weight)
+		}
+		for i, p := range r.paths {
+			if p.Weight < ECMPMinWeight || p.Weight > ECMPMaxWeight {
+				t.Errorf("%s: path %d has weight %d.", test.name, i, p.Weight)
+			}
+			if p.Load != test.loads[portKey(p.Links[0].Src, 1)] {
+				t.Errorf("%s: path %d has load %.0f.", test.name, i, p.Load)
+			}
+		}
+	}
+
+	// Repeated samples of the same uneven load keep moving
+	// weight away from the busy path, within the bounds.
+	r := &ecmpRoute{paths: []ECMPPath{path(a, 1), path(b, 1)}}
+	loads := map[string]float64{portKey(a, 1): 1e6, portKey(b, 1): 1}
+	for i := 0; i < 20; i++ {
+		r.rebalance(loads)
+	}
+	if r.paths[0].Weight != ECMPMinWeight || r.paths[1].Weight != ECMPMaxWeight {
+		t.Errorf("Got weights %d and %d after rebalancing.", r.paths[0].Weight, r.paths[1].Weight)
+	}
+}
+
+func TestECMPClear(t *testing.T) {
+	network = NewNetwork()
+	e := NewECMP(time.Hour)
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	_, subnet, _ := net.ParseCIDR("10.1.0.0/16")
+	r := &ecmpRoute{ECMPRoute: ECMPRoute{ID: "web", Match: FlowMatch{IPDst: subnet.IP, IPDstMask: subnet.Mask}},
+		index: 3}
+	e.routes = map[string]*ecmpRoute{r.ID: r}
+	flow := func(src byte) *Recipe {
+		return &Recipe{Priority: ECMPPriority + 1, Cookie: r.cookie(1), IdleTimeout: ECMPIdleTimeout,
+			Match: FlowMatch{InPort: 1, EthType: 0x0800, IPProto: 6, IPSrc: net.IPv4(10, 0, 0, src),
+				IPDst: net.IPv4(10, 1, 0, 1)}, Outputs: []uint16{2}}
+	}
+
+	// OpenFlow 1.0: only the flows of the route are deleted,
+	// strictly, and flows the switch removed are forgotten.
+	sw, conn := testSwitch(dpid)
+	defer conn.Close()
+	go func() {
+		for _, src := range []byte{1, 2} {
+			if err := r.installFlow(sw, flow(src)); err != nil {
+				t.Error(err)
+			}
+		}
+		removed := &ofp10.FlowRemoved{Match: flow(1).Match.ofp10(), Cookie: r.cookie(1),
+			Priority: ECMPPriority + 1}
+		(&ecmpExpiry{e}).FlowRemoved(dpid, removed)
+		r.clear(sw, false)
+	}()
+	want := []struct {
+		command  uint16
+		priority uint16
+		match    FlowMatch
+	}{
+		{ofp10.FC_ADD, ECMPPriority + 1, flow(1).Match},
+		{ofp10.FC_ADD, ECMPPriority + 1, flow(2).Match},
+		{ofp10.FC_DELETE_STRICT, ECMPPriority, r.Match},
+		{ofp10.FC_DELETE_STRICT, ECMPPriority + 1, flow(2).Match},
+	}
+	for i, w := range want {
+		msg, err := readMessage(conn)
+		if err != nil {
+			t.Fatalf("Message %d: %v", i, err)
+		}
+		f, ok := msg.(*ofp10.FlowMod)
+		if !ok {
+			t.Fatalf("Message %d is a %T.", i, msg)
+		}
+		m := w.match.ofp10()
+		if f.Command != w.command || f.Priority != w.priority ||
+			ecmpFlowKey("", &f.Match) != ecmpFlowKey("", &m) {
+			t.Errorf("Message %d: got command %d priority %d, expected %d and %d.", i,
+				f.Command, f.Priority, w.command, w.priority)
+		}
+		if f.Command == ofp10.FC_ADD && f.Flags&ofp10.FF_SEND_FLOW_REM == 0 {
+			t.Errorf("Message %d: hashed flow isn't reported when removed.", i)
+		}
+	}
+	if len(r.flows[dpid.String()]) != 0 {
+		t.Errorf("Flows %v are still remembered.", r.flows)
+	}
+
+	// OpenFlow 1.3: every flow with the cookie of the route,
+	// then its group.
+	network = NewNetwork()
+	sw, conn = testSwitch(dpid)
+	defer conn.Close()
+	sw.stream.Version = ofp13.VERSION
+	go r.clear(sw, true)
+	msg, err := readMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := msg.(*ofp14.FlowMod)
+	if !ok || f.Command != ofp14.FC_DELETE || f.TableId != 0xff || f.Cookie != r.cookie(0) ||
+		f.CookieMask != ecmpRouteMask || len(f.Match.Fields) != 0 {
+		t.Errorf("Got %+v, expected a delete of the flows with the cookie of the route.", msg)
+	}
+	if msg, err := readMessage(conn); err != nil {
+		t.Error(err)
+	} else if g, ok := msg.(*ofp14.GroupMod); !ok || g.Command != ofp14.GC_DELETE ||
+		g.GroupId != ECMPGroupBase+r.index {
+		t.Errorf("Got %+v, expected the group of the route deleted.", msg)
+	}
+}
@@ -0,0 +1,468 @@
+// Package emulator emulates networks of OpenFlow 1.0 switches in
+// process, cheaply enough to run hundreds of them against a
+// controller. Switches answer the handshake, echo, barrier,
+// config and stats requests, keep a count of the flows added to
+// them and deliver packet-outs over their links as packet-ins on
+// the switch at the other end, so link discovery works. They
+// don't forward traffic through their flows.
+package emulator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// The size of the flow table switches report.
+var MaxFlows uint32 = 8192
+
+var ErrNotConnected = errors.New("The switch isn't connected to a controller.")
+
+type endpoint struct {
+	dpid string
+	port uint16
+}
+
+// A Network of switches joined by point to point links.
+type Network struct {
+	mu       sync.Mutex
+	switches map[string]*Switch
+	links    map[endpoint]endpoint
+	down     map[endpoint]bool
+}
+
+func NewNetwork() *Network {
+	n := new(Network)
+	n.switches = make(map[string]*Switch)
+	n.links = make(map[endpoint]endpoint)
+	n.down = make(map[endpoint]bool)
+	return n
+}
+
+// Adds a switch with ports numbered 1 to ports.
+func (n *Network) AddSwitch(dpid net.HardwareAddr, ports int) *Switch {
+	s := new(Switch)
+	s.DPID = dpid
+	s.Ports = make([]uint16, ports)
+	for i := range s.Ports {
+		s.Ports[i] = uint16(i + 1)
+	}
+	s.network = n
+	s.flows = make(map[string]bool)
+	s.done = make(chan struct{})
+	close(s.done)
+
+	n.mu.Lock()
+	n.switches[dpid.String()] = s
+	n.mu.Unlock()
+	return s
+}
+
+// Returns the switches of n.
+func (n *Network) Switches() []*Switch {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	a := make([]*Switch, 0, len(n.switches))
+	for _, s := range n.switches {
+		a = append(a, s)
+	}
+	return a
+}
+
+// Links port ap of switch a with port bp of switch b.
+func (n *Network) Link(a *Switch, ap uint16, b *Switch, bp uint16) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	x, y := endpoint{a.DPID.String(), ap}, endpoint{b.DPID.String(), bp}
+	n.links[x] = y
+	n.links[y] = x
+}
+
+// Takes the link at port p of switch s down, or brings it up.
+// Both switches report the change with a port status message.
+func (n *Network) SetLink(s *Switch, p uint16, up bool) {
+	n.mu.Lock()
+	x := endpoint{s.DPID.String(), p}
+	y, ok := n.links[x]
+	if !ok {
+		n.mu.Unlock()
+		return
+	}
+	n.down[x], n.down[y] = !up, !up
+	peer := n.switches[y.dpid]
+	n.mu.Unlock()
+
+	s.portStatus(p, up)
+	if peer != nil {
+		peer.portStatus(y.port, up)
+	}
+}
+
+// Returns the number of links that are up. Each link counts
+// once in each direction, as in the controller's topology.
+func (n *Network) Links() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := 0
+	for x := range n.links {
+		if !n.down[x] {
+			c += 1
+		}
+	}
+	return c
+}
+
+// Returns false if the link at port p of switch s is down.
+func (n *Network) up(s *Switch, p uint16) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return !n.down[endpoint{s.DPID.String(), p}]
+}
+
+// Returns the switch and port at the other end of the link at
+// port p of switch s, if the link is up.
+func (n *Network) peer(s *Switch, p uint16) (*Switch, uint16, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	x := endpoint{s.DPID.String(), p}
+	y, ok := n.links[x]
+	if !ok || n.down[x] {
+		return nil, 0, false
+	}
+	peer, ok := n.switches[y.dpid]
+	return peer, y.port, ok
+}
+
+// Counts of the messages a switch exchanged.
+type Stats struct {
+	Received   uint64
+	Sent       uint64
+	PacketIns  uint64
+	PacketOuts uint64
+	FlowMods   uint64
+}
+
+// An emulated OpenFlow 1.0 switch.
+type Switch struct {
+	DPID    net.HardwareAddr
+	Ports   []uint16
+	network *Network
+
+	connMu sync.Mutex
+	conn   net.Conn
+	done   chan struct{}
+
+	flowsMu sync.Mutex
+	flows   map[string]bool
+	stats   Stats
+}
+
+// Connects s to the controller at the TCP address addr.
+func (s *Switch) Dial(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.Connect(conn)
+	return nil
+}
+
+// Runs the switch side of the OpenFlow protocol on conn until it
+// is closed. Any previous connection is closed first.
+func (s *Switch) Connect(conn net.Conn) {
+	s.Close()
+	done := make(chan struct{})
+	s.connMu.Lock()
+	s.conn = conn
+	s.done = done
+	s.connMu.Unlock()
+
+	go func() {
+		defer close(done)
+		s.serve(conn)
+	}()
+	hello := ofpxx.NewOfp10Header()
+	hello.Type = ofp10.Type_Hello
+	s.send(&hello)
+}
+
+// Disconnects s from the controller and waits until it has
+// stopped.
+func (s *Switch) Close() {
+	s.connMu.Lock()
+	conn, done := s.conn, s.done
+	s.conn = nil
+	s.connMu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	if done != nil {
+		<-done
+	}
+}
+
+// Closed once s is disconnected.
+func (s *Switch) Done() <-chan struct{} {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.done
+}
+
+// Returns the number of flows in the table of s.
+func (s *Switch) Flows() int {
+	s.flowsMu.Lock()
+	defer s.flowsMu.Unlock()
+	return len(s.flows)
+}
+
+func (s *Switch) Stats() Stats {
+	return Stats{
+		atomic.LoadUint64(&s.stats.Received),
+		atomic.LoadUint64(&s.stats.Sent),
+		atomic.LoadUint64(&s.stats.PacketIns),
+		atomic.LoadUint64(&s.stats.PacketOuts),
+		atomic.LoadUint64(&s.stats.FlowMods),
+	}
+}
+
+func (s *Switch) send(msg interface {
+	MarshalBinary() ([]byte, error)
+}) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+func (s *Switch) write(data []byte) error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.conn == nil {
+		return ErrNotConnected
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.stats.Sent, 1)
+	return nil
+}
+
+// Returns a header of type t answering xid, followed by body.
+func reply(t uint8, xid uint32, body []byte) []byte {
+	data := make([]byte, 8+len(body))
+	data[0], data[1] = ofp10.VERSION, t
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)))
+	binary.BigEndian.PutUint32(data[4:], xid)
+	copy(data[8:], body)
+	return data
+}
+
+// Reads messages from conn and answers them.
+func (s *Switch) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(hdr[2:]))
+		if length < 8 {
+			conn.Close()
+			return
+		}
+		msg := make([]byte, length)
+		copy(msg, hdr)
+		if _, err := io.ReadFull(r, msg[8:]); err != nil {
+			return
+		}
+		atomic.AddUint64(&s.stats.Received, 1)
+		if msg[0] != ofp10.VERSION {
+			continue
+		}
+		s.handle(msg)
+	}
+}
+
+func (s *Switch) handle(msg []byte) {
+	xid := binary.BigEndian.Uint32(msg[4:])
+	switch msg[1] {
+	case ofp10.Type_EchoRequest:
+		s.write(reply(ofp10.Type_EchoReply, xid, msg[8:]))
+	case ofp10.Type_FeaturesRequest:
+		f := ofp10.NewFeaturesReply()
+		f.Header.Xid = xid
+		copy(f.DPID, s.DPID)
+		f.Buffers = 0
+		f.Tables = 1
+		for _, p := range s.Ports {
+			f.Ports = append(f.Ports, *s.port(p, s.network.up(s, p)))
+		}
+		s.send(f)
+	case ofp10.Type_GetConfigRequest:
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint16(body[2:], 128)
+		s.write(reply(ofp10.Type_GetConfigReply, xid, body))
+	case ofp10.Type_BarrierRequest:
+		s.write(reply(ofp10.Type_BarrierReply, xid, nil))
+	case ofp10.Type_StatsRequest:
+		s.statsReply(msg, xid)
+	case ofp10.Type_FlowMod:
+		s.flowMod(msg)
+	case ofp10.Type_PacketOut:
+		s.packetOut(msg)
+	}
+}
+
+func (s *Switch) port(p uint16, up bool) *ofp10.PhyPort {
+	port := ofp10.NewPhyPort()
+	port.PortNo = p
+	copy(port.HWAddr, s.DPID[2:])
+	port.HWAddr[5] += uint8(p)
+	copy(port.Name, fmt.Sprintf("eth%d", p))
+	if !up {
+		port.State = ofp10.PS_LINK_DOWN
+	}
+	return port
+}
+
+func (s *Switch) portStatus(p uint16, up bool) {
+	m := ofp10.NewPortStatus()
+	m.Reason = ofp10.PR_MODIFY
+	m.Desc = *s.port(p, up)
+	s.send(m)
+}
+
+func (s *Switch) statsReply(msg []byte, xid uint32) {
+	if len(msg) < 12 {
+		return
+	}
+	t := binary.BigEndian.Uint16(msg[8:])
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint16(body, t)
+	var stats interface {
+		MarshalBinary() ([]byte, error)
+	}
+	switch t {
+	case ofp10.StatsType_Desc:
+		d := ofp10.NewDescStats()
+		copy(d.MfrDesc, "ogo")
+		copy(d.HWDesc, "emulator")
+		copy(d.DPDesc, s.DPID.String())
+		stats = d
+	case ofp10.StatsType_Table:
+		ts := ofp10.NewTableStats()
+		copy(ts.Name, "classifier")
+		ts.Wildcards = ofp10.FW_ALL
+		ts.MaxEntries = MaxFlows
+		ts.ActiveCount = uint32(s.Flows())
+		stats = ts
+	case ofp10.StatsType_Aggregate:
+		a := ofp10.NewAggregateStats()
+		a.FlowCount = uint32(s.Flows())
+		stats = a
+	}
+	if stats != nil {
+		b, err := stats.MarshalBinary()
+		if err != nil {
+			return
+		}
+		body = append(body, b...)
+	}
+	s.write(reply(ofp10.Type_StatsReply, xid, body))
+}
+
+// Flows are told apart by their match and priority, which
+// start at offset 8 and 62 of a flow mod.
+func (s *Switch) flowMod(msg []byte) {
+	if len(msg) < 72 {
+		return
+	}
+	atomic.AddUint64(&s.stats.FlowMods, 1)
+	key := fmt.Sprintf("%x/%x", msg[8:48], msg[62:64])
+	s.flowsMu.Lock()
+	defer s.flowsMu.Unlock()
+	switch binary.BigEndian.Uint16(msg[56:]) {
+	case ofp10.FC_ADD, ofp10.FC_MODIFY, ofp10.FC_MODIFY_STRICT:
+		if uint32(len(s.flows)) < MaxFlows {
+			s.flows[key] = true
+		}
+	case ofp10.FC_DELETE:
+		if binary.BigEndian.Uint32(msg[8:])&ofp10.FW_ALL == ofp10.FW_ALL {
+			s.flows = make(map[string]bool)
+			return
+		}
+		fallthrough
+	case ofp10.FC_DELETE_STRICT:
+		delete(s.flows, key)
+	}
+}
+
+// Sends the packet in a packet-out over the links of its output
+// ports.
+func (s *Switch) packetOut(msg []byte) {
+	if len(msg) < 16 {
+		return
+	}
+	atomic.AddUint64(&s.stats.PacketOuts, 1)
+	inPort := binary.BigEndian.Uint16(msg[12:])
+	actionsLen := int(binary.BigEndian.Uint16(msg[14:]))
+	if 16+actionsLen > len(msg) {
+		return
+	}
+	actions, data := msg[16:16+actionsLen], msg[16+actionsLen:]
+	for len(actions) >= 8 {
+		t := binary.BigEndian.Uint16(actions)
+		l := int(binary.BigEndian.Uint16(actions[2:]))
+		if l < 8 || l > len(actions) {
+			return
+		}
+		if t == ofp10.ActionType_Output {
+			port := binary.BigEndian.Uint16(actions[4:])
+			if port == ofp10.P_ALL || port == ofp10.P_FLOOD {
+				for _, p := range s.Ports {
+					if p != inPort {
+						s.transmit(p, data)
+					}
+				}
+			} else {
+				s.transmit(port, data)
+			}
+		}
+		actions = actions[l:]
+	}
+}
+
+// Sends data out port p, to the controller of the switch at the
+// other end of its link.
+func (s *Switch) transmit(p uint16, data []byte) {
+	peer, port, ok := s.network.peer(s, p)
+	if !ok {
+		return
+	}
+	peer.Receive(port, data)
+}
+
+// Sends the packet data to the controller of s as if it had
+// arrived on port p, for example from a host attached there.
+func (s *Switch) Receive(p uint16, data []byte) error {
+	body := make([]byte, 10+len(data))
+	binary.BigEndian.PutUint32(body, 0xffffffff)
+	binary.BigEndian.PutUint16(body[4:], uint16(len(data)))
+	binary.BigEndian.PutUint16(body[6:], p)
+	body[8] = ofp10.R_NO_MATCH
+	copy(body[10:], data)
+	if err := s.write(reply(ofp10.Type_PacketIn, 0, body)); err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.stats.PacketIns, 1)
+	return nil
+}
@@ -0,0 +1,95 @@
+package emulator
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Connects s to a new controller side stream.
+func connect(s *Switch) *ogo.MessageStream {
+	c, sc := net.Pipe()
+	m := ogo.NewMessageStream(c)
+	s.Connect(sc)
+	return m
+}
+
+// Returns the first message received on m that ok accepts.
+func expect(t *testing.T, m *ogo.MessageStream, ok func(util.Message) bool) util.Message {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case msg := <-m.Inbound:
+			if ok(msg) {
+				return msg
+			}
+		case err := <-m.Error:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatal("The expected message wasn't received.")
+		}
+	}
+}
+
+func TestSwitchFeatures(t *testing.T) {
+	n := NewNetwork()
+	dpid, _ := net.ParseMAC("00:00:00:00:00:00:00:01")
+	s := n.AddSwitch(dpid, 3)
+	m := connect(s)
+	defer s.Close()
+
+	m.Outbound <- ofp10.NewFeaturesRequest()
+	msg := expect(t, m, func(msg util.Message) bool {
+		_, ok := msg.(*ofp10.SwitchFeatures)
+		return ok
+	})
+	f := msg.(*ofp10.SwitchFeatures)
+	if f.DPID.String() != dpid.String() || len(f.Ports) != 3 {
+		t.Errorf("Got dpid %s and %d ports.", f.DPID, len(f.Ports))
+	}
+}
+
+func TestSwitchLink(t *testing.T) {
+	n := NewNetwork()
+	d1, _ := net.ParseMAC("00:00:00:00:00:00:00:01")
+	d2, _ := net.ParseMAC("00:00:00:00:00:00:00:02")
+	a, b := n.AddSwitch(d1, 2), n.AddSwitch(d2, 2)
+	n.Link(a, 1, b, 2)
+	ma, mb := connect(a), connect(b)
+	defer a.Close()
+	defer b.Close()
+
+	e := eth.New()
+	e.Ethertype = 0xa0f1
+	e.HWSrc = d1[2:]
+	e.Data = util.NewBuffer(make([]byte, 16))
+	pkt := ofp10.NewPacketOut()
+	pkt.Data = e
+	pkt.AddAction(ofp10.NewActionOutput(ofp10.P_ALL))
+	ma.Outbound <- pkt
+
+	msg := expect(t, mb, func(msg util.Message) bool {
+		_, ok := msg.(*ofp10.PacketIn)
+		return ok
+	})
+	if p := msg.(*ofp10.PacketIn); p.InPort != 2 || p.Data.Ethertype != 0xa0f1 {
+		t.Errorf("Got a packet-in on port %d of type 0x%x.", p.InPort, p.Data.Ethertype)
+	}
+
+	n.SetLink(b, 2, false)
+	msg = expect(t, ma, func(msg util.Message) bool {
+		_, ok := msg.(*ofp10.PortStatus)
+		return ok
+	})
+	if p := msg.(*ofp10.PortStatus); p.Desc.PortNo != 1 || p.Desc.State&ofp10.PS_LINK_DOWN == 0 {
+		t.Errorf("Got port status %+v.", p.Desc)
+	}
+	if n.Links() != 0 {
+		t.Errorf("Got %d links up, expected 0.", n.Links())
+	}
+}
@@ -0,0 +1,127 @@
+package ogo
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events published by the controller itself.
+const (
+	EventSwitchUp   = "switch.up"
+	EventSwitchDown = "switch.down"
+	EventLinkUp     = "link.up"
+	EventLinkDown   = "link.down"
+	// A switch rejected a flow because its tables are full.
+	EventTableFull = "switch.tablefull"
+	// A dead switch was removed by the switch audit.
+	EventSwitchRemoved = "switch.removed"
+	// A switch sent a message that couldn't be read. The data
+	// is the *StreamError.
+	EventStreamError = "stream.error"
+	// A switch reported a port added, changed or removed. The
+	// data is the ofp10.PhyPort.
+	EventPortAdded    = "port.added"
+	EventPortModified = "port.modified"
+	EventPortDeleted  = "port.deleted"
+	// A switch was drained for maintenance or returned to
+	// service, see DrainSwitch.
+	EventSwitchDrained   = "switch.drained"
+	EventSwitchUndrained = "switch.undrained"
+	// A port, and the link on it, was drained or returned to
+	// service, see DrainPort. The data is the port number.
+	EventLinkDrained   = "link.drained"
+	EventLinkUndrained = "link.undrained"
+)
+
+// An Event is a notification published on the controller's event
+// bus. Type is a dotted name such as "switch.up". DPID is the
+// switch the event is about, if any. Time is when the event was
+// published, and Mono the same time on the monotonic clock of
+// Timestamps.
+type Event struct {
+	Type string
+	DPID net.HardwareAddr
+	Time time.Time
+	Mono time.Duration
+	Data interface{}
+}
+
+// A Subscription receives events published on the event bus on
+// C. Events are dropped, not queued, if C is full, so that a slow
+// subscriber never holds up a switch. Lost then receives a value;
+// subscribers that keep state built from events must rebuild it
+// from the controller, not from the events they missed.
+type Subscription struct {
+	C        <-chan Event
+	Lost     <-chan struct{}
+	c        chan Event
+	lost     chan struct{}
+	prefixes []string
+}
+
+var bus = struct {
+	sync.RWMutex
+	subs map[*Subscription]bool
+}{subs: make(map[*Subscription]bool)}
+
+// Subscribes to events whose type starts with one of prefixes,
+// or to every event if no prefix is given. buffer is the size of
+// the subscription's channel.
+func Subscribe(buffer int, prefixes ...string) *Subscription {
+	s := new(Subscription)
+	s.c = make(chan Event, buffer)
+	s.C = s.c
+	s.lost = make(chan struct{}, 1)
+	s.Lost = s.lost
+	s.prefixes = prefixes
+	bus.Lock()
+	bus.subs[s] = true
+	bus.Unlock()
+	return s
+}
+
+// Stops delivery of events to s and closes s.C.
+func (s *Subscription) Cancel() {
+	bus.Lock()
+	if bus.subs[s] {
+		delete(bus.subs, s)
+		close(s.c)
+	}
+	bus.Unlock()
+}
+
+func (s *Subscription) wants(t string) bool {
+	if len(s.prefixes) == 0 {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(t, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publishes an event of type t about Switch dpid to every
+// interested subscriber. Never blocks.
+func Publish(t string, dpid net.HardwareAddr, data interface{}) {
+	now := stampNow()
+	e := Event{t, dpid, now.Wall, now.Mono, data}
+	bus.RLock()
+	defer bus.RUnlock()
+	for s := range bus.subs {
+		if !s.wants(t) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			select {
+			case s.lost <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package ogo
+
+import (
+	"testing"
+)
+
+func TestSubscriptionLost(t *testing.T) {
+	sub := Subscribe(2, "test.")
+	defer sub.Cancel()
+	for i := 0; i < 2; i++ {
+		Publish("test.event", nil, i)
+	}
+	select {
+	case <-sub.Lost:
+		t.Fatal("Lost events that fit in the buffer.")
+	default:
+	}
+	Publish("other.event", nil, nil)
+	Publish("test.event", nil, 2)
+	Publish("test.event", nil, 3)
+	select {
+	case <-sub.Lost:
+	default:
+		t.Fatal("Dropped events without signalling Lost.")
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-sub.C; e.Data != i {
+			t.Errorf("Got event %v, expected %d.", e.Data, i)
+		}
+	}
+}
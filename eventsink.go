@@ -0,0 +1,295 @@
+package ogo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// The version of the EventRecord schema. It changes only when a
+// field is removed or changes meaning.
+const EventSchema = 1
+
+// The JSON encoding of an event sent outside the controller.
+// Data is the event's data, or for packet-ins a PacketInSummary.
+type EventRecord struct {
+	Schema int         `json:"schema"`
+	Type   string      `json:"type"`
+	DPID   string      `json:"dpid,omitempty"`
+	Time   time.Time   `json:"time"`
+	Mono   int64       `json:"mono_ns,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+func newEventRecord(e Event) EventRecord {
+	r := EventRecord{Schema: EventSchema, Type: e.Type, Time: e.Time, Mono: int64(e.Mono), Data: e.Data}
+	if e.DPID != nil {
+		r.DPID = e.DPID.String()
+	}
+	// Errors have no fields to encode.
+	if err, ok := e.Data.(error); ok {
+		r.Data = err.Error()
+	}
+	return r
+}
+
+// The headers of a packet-in.
+type PacketInSummary struct {
+	InPort    uint16 `json:"in_port"`
+	Reason    uint8  `json:"reason"`
+	Length    uint16 `json:"length"`
+	EthSrc    string `json:"eth_src"`
+	EthDst    string `json:"eth_dst"`
+	EthType   uint16 `json:"eth_type"`
+	BufferId  uint32 `json:"buffer_id"`
+	Truncated bool   `json:"truncated"`
+}
+
+// An EventSink delivers messages to a topic of a message broker.
+// Publish may be called from one goroutine at a time.
+type EventSink interface {
+	Publish(topic string, msg []byte) error
+	Close() error
+}
+
+// An EventStream publishes the events of the event bus, and
+// optionally a summary of every packet-in, to an EventSink as
+// EventRecords. The topic of an event is Prefix followed by its
+// type, like "ogo.switch.down", and that of a packet-in
+// Prefix+"packetin". Only events whose type starts with one of
+// Events are published, or every event if it's empty.
+//
+// Records are queued and published by a single goroutine, so a
+// slow broker never holds up the controller; records arriving
+// while Queue records are waiting are dropped.
+//
+// A NATS sink is provided by DialNATS. Kafka, or any other
+// broker, can be used by wrapping its client in an EventSink.
+type EventStream struct {
+	Prefix    string
+	Events    []string
+	PacketIns bool
+	Queue     int
+
+	c         *Controller
+	sink      EventSink
+	sub       *Subscription
+	records   chan streamRecord
+	done      chan bool
+	published uint64
+	dropped   uint64
+	failed    uint64
+}
+
+type streamRecord struct {
+	topic  string
+	record EventRecord
+}
+
+func NewEventStream(sink EventSink) *EventStream {
+	s := new(EventStream)
+	s.Prefix = "ogo."
+	s.Queue = 4096
+	s.sink = sink
+	return s
+}
+
+// Starts publishing events, and packet-ins if PacketIns is set.
+// Packet-ins are observed ahead of every other handler but never
+// consumed.
+func (s *EventStream) Attach(c *Controller) {
+	s.c = c
+	s.records = make(chan streamRecord, s.Queue)
+	s.done = make(chan bool)
+	if s.PacketIns {
+		c.AddPacketInHandler("eventstream", 1<<30, s)
+	}
+	s.sub = Subscribe(1024, s.Events...)
+	go s.loop()
+	go s.publish()
+}
+
+// Stops publishing and closes the sink.
+func (s *EventStream) Stop() {
+	if s.PacketIns {
+		s.c.RemovePacketInHandler("eventstream")
+	}
+	if s.sub != nil {
+		s.sub.Cancel()
+	}
+}
+
+// Returns the number of records published, dropped because the
+// queue was full, and rejected by the sink.
+func (s *EventStream) Stats() (published, dropped, failed uint64) {
+	return atomic.LoadUint64(&s.published), atomic.LoadUint64(&s.dropped), atomic.LoadUint64(&s.failed)
+}
+
+func (s *EventStream) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	sum := PacketInSummary{InPort: pkt.InPort, Reason: pkt.Reason, Length: pkt.TotalLen,
+		EthSrc: pkt.Data.HWSrc.String(), EthDst: pkt.Data.HWDst.String(),
+		EthType: pkt.Data.Ethertype, BufferId: pkt.BufferId,
+		Truncated: int(pkt.TotalLen) > int(pkt.Data.Len())}
+	t, ok := MessageTime(pkt)
+	if !ok {
+		t = stampNow()
+	}
+	s.enqueue(s.Prefix+"packetin", EventRecord{Schema: EventSchema, Type: "packetin",
+		DPID: dpid.String(), Time: t.Wall, Mono: int64(t.Mono), Data: sum})
+	return false
+}
+
+func (s *EventStream) loop() {
+	for e := range s.sub.C {
+		s.enqueue(s.Prefix+e.Type, newEventRecord(e))
+	}
+	close(s.done)
+}
+
+func (s *EventStream) enqueue(topic string, r EventRecord) {
+	select {
+	case s.records <- streamRecord{topic, r}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *EventStream) publish() {
+	for {
+		var r streamRecord
+		select {
+		case r = <-s.records:
+		case <-s.done:
+			s.sink.Close()
+			return
+		}
+		b, err := json.Marshal(r.record)
+		if err != nil {
+			r.record.Data = fmt.Sprint(r.record.Data)
+			b, _ = json.Marshal(r.record)
+		}
+		if err := s.sink.Publish(r.topic, b); err != nil {
+			if atomic.AddUint64(&s.failed, 1) == 1 {
+				log.Println("Failed to publish event:", err)
+			}
+			continue
+		}
+		atomic.AddUint64(&s.published, 1)
+	}
+}
+
+var errNATSClosed = errors.New("Not connected to the NATS server.")
+
+// A NATSSink publishes to a NATS server using the NATS client
+// protocol. It reconnects once per failed Publish.
+type NATSSink struct {
+	Addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// Connects to the NATS server at addr, a host:port.
+func DialNATS(addr string) (*NATSSink, error) {
+	n := &NATSSink{Addr: addr}
+	if err := n.connect(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *NATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", n.Addr, DialTimeout)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(DialTimeout))
+	info, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return errors.New("NATS server sent no INFO.")
+	}
+	conn.SetReadDeadline(time.Time{})
+	w := bufio.NewWriter(conn)
+	fmt.Fprint(w, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"ogo\"}\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn = conn
+	n.w = w
+	go n.pong(conn, r)
+	return nil
+}
+
+// Answers the server's keepalive pings on conn until it fails.
+func (n *NATSSink) pong(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			n.mu.Lock()
+			if n.conn == conn {
+				fmt.Fprint(n.w, "PONG\r\n")
+				n.w.Flush()
+			}
+			n.mu.Unlock()
+		} else if strings.HasPrefix(line, "-ERR") {
+			log.Println("NATS server error:", strings.TrimSpace(line))
+		}
+	}
+}
+
+func (n *NATSSink) Publish(topic string, msg []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.write(topic, msg); err == nil {
+		return nil
+	}
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	if err := n.connect(); err != nil {
+		return err
+	}
+	return n.write(topic, msg)
+}
+
+func (n *NATSSink) write(topic string, msg []byte) error {
+	if n.conn == nil {
+		return errNATSClosed
+	}
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", topic, len(msg))
+	n.w.Write(msg)
+	n.w.WriteString("\r\n")
+	return n.w.Flush()
+}
+
+func (n *NATSSink) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
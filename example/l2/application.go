@@ -5,6 +5,7 @@ import (
 	"net"
 	"runtime"
 	"sync"	
+	"sync/atomic"
 	
 	"github.com/jonstout/ogo"
 	"github.com/jonstout/ogo/protocol/ofp10"
@@ -50,18 +51,72 @@ var hostMap HostMap
 // DemoInstance will be created for each switch that connects
 // to the network.
 func NewDemoInstance() interface{} {
-	return &DemoInstance{&hostMap}
+	return &DemoInstance{HostMap: &hostMap}
 }
 
 // Acts as a simple learning switch.
 type DemoInstance struct {
 	*HostMap
+	// Set to 1 when the switch learns MAC addresses itself.
+	offload int32
+}
+
+// Returns a learn action that installs a flow forwarding packets
+// destined to the source of the current packet out its in port.
+func newLearnAction() *ofp10.NXActionLearn {
+	l := ofp10.NewNXActionLearn()
+	l.IdleTimeout = 3
+	l.Priority = 1000
+	l.AddSpec(ofp10.NewLearnMatch(ofp10.NXM_OF_ETH_DST, ofp10.NXM_OF_ETH_SRC))
+	l.AddSpec(ofp10.NewLearnOutput(ofp10.NXM_OF_IN_PORT))
+	return l
+}
+
+// Tries to offload MAC learning to the switch with the Nicira
+// learn action. Switches that reject it, anything that isn't
+// Open vSwitch, keep learning through the controller.
+func (b *DemoInstance) ConnectionUp(dpid net.HardwareAddr) {
+	sw, ok := ogo.Switch(dpid)
+	if !ok || sw.Version() != ofp10.VERSION {
+		return
+	}
+	go func() {
+		f1 := ofp10.NewFlowMod()
+		f1.Priority = 3
+		f1.AddAction(newLearnAction())
+		f1.AddAction(ofp10.NewActionOutput(ofp10.P_FLOOD))
+
+		// Keep sending ARP to the controller.
+		f2 := ofp10.NewFlowMod()
+		f2.Priority = 4
+		f2.Match.DLType = 0x0806
+		f2.AddAction(newLearnAction())
+		f2.AddAction(ofp10.NewActionOutput(ofp10.P_FLOOD))
+		f2.AddAction(ofp10.NewActionOutput(ofp10.P_CONTROLLER))
+
+		bundle, err := sw.OpenBundle()
+		if err != nil {
+			return
+		}
+		sw.AddToBundle(bundle, f1)
+		sw.AddToBundle(bundle, f2)
+		if err = sw.CommitBundle(bundle); err != nil {
+			fmt.Println("MAC learning offload not supported by", dpid, err)
+			return
+		}
+		atomic.StoreInt32(&b.offload, 1)
+	}()
 }
 
 func (b *DemoInstance) PacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) {
+	// The switch forwards and learns on its own.
+	if atomic.LoadInt32(&b.offload) == 1 {
+		return
+	}
+
 	eth := pkt.Data
 	// Ignore link discovery packet types.
-	if eth.Ethertype == 0xa0f1 || eth.Ethertype == 0x88cc {
+	if eth.Ethertype == 0xa0f1 || eth.Ethertype == 0x88cc || eth.Ethertype == ogo.BDDPEthertype {
 		return
 	}
 
@@ -84,13 +139,9 @@ func (b *DemoInstance) PacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) {
 			s.Send(f2)
 		}
 	} else {
-		p := ofp10.NewPacketOut()
-		p.InPort = pkt.InPort
-		p.AddAction(ofp10.NewActionOutput(ofp10.P_ALL))
-		p.Data = &eth
-		if sw, ok := ogo.Switch(dpid); ok {
-			sw.Send(p)
-		}
+		// Only the edge ports of the domain, not every port of
+		// every switch the frame reaches.
+		ogo.Flood(dpid, pkt.InPort, &eth)
 	}
 }
 
@@ -0,0 +1,54 @@
+// Starts a controller running the ogo link discovery, brings up
+// a Mininet tree topology against it and checks that every
+// switch connects and every link is discovered. Must be run as
+// root on a host with Mininet installed.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/mininet"
+)
+
+func main() {
+	addr := flag.String("listen", ":6633", "controller listen address")
+	topo := flag.String("topo", "tree,depth=2,fanout=2", "mininet topology")
+	switches := flag.Int("switches", 3, "expected number of switches")
+	links := flag.Int("links", 4, "expected number of unidirectional links")
+	flag.Parse()
+
+	ctrl := ogo.NewController()
+	go ctrl.Listen(*addr)
+
+	net, err := mininet.Start(mininet.Options{
+		Topo:       *topo,
+		Controller: *addr,
+		Protocols:  "OpenFlow10",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	status := 0
+	if err = mininet.WaitForSwitches(*switches, time.Second*30); err != nil {
+		log.Println(err)
+		status = 1
+	}
+	if err = mininet.WaitForLinks(*links, time.Second*30); err != nil {
+		log.Println(err)
+		status = 1
+	}
+	t := ogo.CurrentTopology()
+	if err = mininet.AssertTopology(t, *switches, *links); err != nil {
+		log.Println(err)
+		status = 1
+	}
+	t.WriteDOT(os.Stdout)
+
+	net.Stop()
+	os.Exit(status)
+}
@@ -0,0 +1,152 @@
+// Works with OpenFlow messages outside of a controller. decode
+// pretty-prints messages given as hex dumps, from files or the
+// standard input:
+//
+//	oftool decode dump.txt
+//	echo 01 0e 00 48 ... | oftool decode
+//
+// Whitespace, 0x prefixes and the offsets of tcpdump -X, xxd and
+// hexdump -C are ignored, as is the ASCII column that follows the hex on
+// each of their lines. Several messages may follow each other.
+// Lines starting with # are comments.
+//
+// capacity prints the table capacity report served by a
+// controller's ops endpoint, see ogo.CapacityPlanner:
+//
+//	oftool capacity http://localhost:8081/capacity
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/ofp"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: oftool decode [FILE...]")
+		fmt.Fprintln(os.Stderr, "       oftool capacity URL")
+		os.Exit(2)
+	}
+	switch flag.Arg(0) {
+	case "decode":
+		if flag.NArg() == 1 {
+			decode(os.Stdin)
+			return
+		}
+		for _, name := range flag.Args()[1:] {
+			f, err := os.Open(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			decode(f)
+			f.Close()
+		}
+	case "capacity":
+		if flag.NArg() != 2 {
+			log.Fatal("usage: oftool capacity URL")
+		}
+		capacity(flag.Arg(1))
+	default:
+		log.Fatal("unknown command ", flag.Arg(0))
+	}
+}
+
+// Prints the capacity report served at url.
+func capacity(url string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatal(url, ": ", resp.Status)
+	}
+	r := new(ogo.CapacityReport)
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		log.Fatal(err)
+	}
+	r.WriteText(os.Stdout)
+}
+
+// Prints the messages in the hex dump read from r.
+func decode(r io.Reader) {
+	b, err := readHex(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for len(b) > 0 {
+		if len(b) < 8 {
+			log.Fatalf("%d bytes left, too short for a header", len(b))
+		}
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 8 || length > len(b) {
+			log.Fatalf("bad message length %d with %d bytes left", length, len(b))
+		}
+		fmt.Print(dump(b[:length]))
+		b = b[length:]
+	}
+}
+
+func dump(b []byte) string {
+	msg, err := ofp.Parse(b)
+	if err != nil {
+		return fmt.Sprintf("version %d type %d length %d: %v\n", b[0], b[1], len(b), err)
+	}
+	if b[0] != ofp10.VERSION && b[1] != 0 {
+		return fmt.Sprintf("version %d type %d length %d: %T\n", b[0], b[1], len(b), msg)
+	}
+	return ofp10.Dump(msg)
+}
+
+// Reads the bytes of a hex dump.
+func readHex(r io.Reader) ([]byte, error) {
+	var digits bytes.Buffer
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if i == 0 && isOffset(fields) {
+				continue
+			}
+			f = strings.TrimPrefix(f, "0x")
+			if _, err := hex.DecodeString(f); err != nil {
+				// The ASCII column.
+				break
+			}
+			digits.WriteString(f)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(digits.String())
+}
+
+// Tells whether the line of fields starts with an offset, like
+// "0x0010:" of tcpdump -X, "00000010:" of xxd or "00000010" of
+// hexdump -C.
+func isOffset(fields []string) bool {
+	if strings.HasSuffix(fields[0], ":") {
+		return true
+	}
+	return len(fields[0]) == 8 && len(fields) > 1 && len(fields[1]) == 2
+}
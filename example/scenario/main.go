@@ -0,0 +1,59 @@
+// Starts a controller and runs scenario files against it, printing
+// the outcome of every step. Exits with status 1 if a step failed,
+// so scenarios can serve as regression tests.
+//
+//	scenario ring.yaml failover.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/scenario"
+)
+
+func main() {
+	addr := flag.String("listen", "127.0.0.1:6633", "controller listen address")
+	timeout := flag.Duration("timeout", time.Second*10, "how long expectations are waited for by default")
+	verbose := flag.Bool("v", false, "log each step as it runs")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: scenario [-listen ADDR] [-timeout D] [-v] FILE...")
+		os.Exit(2)
+	}
+
+	ctrl := ogo.NewController()
+	go ctrl.Listen(*addr)
+
+	r := scenario.NewRunner(*addr)
+	r.Timeout = *timeout
+	if *verbose {
+		r.Log = log.Printf
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		sc, err := scenario.Load(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result, err := r.Run(sc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %d steps, %d failed, %s\n", result.Name, len(result.Steps), result.Failures,
+			result.Duration)
+		for _, st := range result.Steps {
+			if st.Error != "" {
+				fmt.Printf("  at %s %s: %s\n", time.Duration(st.At), st.Do, st.Error)
+			}
+		}
+		failed = failed || result.Failures > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
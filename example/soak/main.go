@@ -0,0 +1,306 @@
+// Runs a controller against hundreds of emulated switches for
+// hours, flapping links and pushing flows all the while, and
+// checks that it stays healthy: every switch stays connected, the
+// number of goroutines and the heap don't keep growing, and no
+// switch queue stays stuck. Prints a summary and exits with
+// status 1 if a check failed.
+//
+//	soak -switches 500 -duration 4h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/emulator"
+)
+
+var (
+	addr     = flag.String("listen", "127.0.0.1:6633", "controller listen address")
+	switches = flag.Int("switches", 200, "number of emulated switches, joined in a ring")
+	duration = flag.Duration("duration", time.Hour, "how long to run")
+	warmup   = flag.Duration("warmup", time.Minute, "how long to run before taking the baseline")
+	flap     = flag.Duration("flap", time.Second, "how often a random link goes down or comes back")
+	flows    = flag.Int("flows", 100, "flows added or deleted per second")
+	interval = flag.Duration("sample", time.Second*30, "how often the controller is sampled")
+
+	goroutineSlack = flag.Int("goroutine-slack", 200, "goroutines allowed above the baseline")
+	heapGrowth     = flag.Float64("heap-growth", 1.5, "largest allowed ratio of the final heap to the baseline")
+	stuckSamples   = flag.Int("stuck", 3, "samples a non-empty queue may go without draining")
+)
+
+type sample struct {
+	time       time.Time
+	goroutines int
+	heap       uint64
+	switches   int
+	links      int
+	pending    int
+}
+
+type soak struct {
+	network  *emulator.Network
+	ring     []*emulator.Switch
+	samples  []sample
+	failures []string
+	flaps    int
+	flowMods int
+	// Consecutive samples each switch queue didn't drain.
+	stuck map[string]int
+}
+
+func main() {
+	flag.Parse()
+	ctrl := ogo.NewController()
+	go ctrl.Listen(*addr)
+
+	s := &soak{network: emulator.NewNetwork(), stuck: make(map[string]int)}
+	s.build(*switches)
+	if err := s.connect(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d switches connected, warming up for %s", len(s.ring), *warmup)
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.flapLinks(stop)
+	}()
+	go func() {
+		defer wg.Done()
+		s.pushFlows(stop)
+	}()
+
+	start := time.Now()
+	time.Sleep(*warmup)
+	ticker := time.NewTicker(*interval)
+	for time.Since(start) < *duration {
+		s.sample()
+		<-ticker.C
+	}
+	ticker.Stop()
+	close(stop)
+	wg.Wait()
+	s.sample()
+	s.check()
+	s.report(os.Stdout)
+	if len(s.failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Adds n switches with four ports each, port 1 of each linked to
+// port 2 of the next.
+func (s *soak) build(n int) {
+	for i := 0; i < n; i++ {
+		dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, byte(i >> 8), byte(i)}
+		s.ring = append(s.ring, s.network.AddSwitch(dpid, 4))
+	}
+	for i, sw := range s.ring {
+		s.network.Link(sw, 1, s.ring[(i+1)%n], 2)
+	}
+}
+
+func (s *soak) connect() error {
+	for _, sw := range s.ring {
+		var err error
+		for i := 0; i < 50; i++ {
+			if err = sw.Dial(*addr); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(time.Minute)
+	for len(ogo.Switches()) < len(s.ring) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("only %d of %d switches connected", len(ogo.Switches()), len(s.ring))
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return nil
+}
+
+// Takes a random link down, and brings it back up at the next
+// flap.
+func (s *soak) flapLinks(stop chan bool) {
+	ticker := time.NewTicker(*flap)
+	defer ticker.Stop()
+	var down *emulator.Switch
+	for {
+		select {
+		case <-stop:
+			if down != nil {
+				s.network.SetLink(down, 1, true)
+			}
+			return
+		case <-ticker.C:
+			if down != nil {
+				s.network.SetLink(down, 1, true)
+				down = nil
+			} else {
+				down = s.ring[rand.Intn(len(s.ring))]
+				s.network.SetLink(down, 1, false)
+			}
+			s.flaps += 1
+		}
+	}
+}
+
+// Adds flows to random switches, and deletes some of them again
+// so the tables don't fill.
+func (s *soak) pushFlows(stop chan bool) {
+	if *flows <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(*flows))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		sws := ogo.Switches()
+		if len(sws) == 0 {
+			continue
+		}
+		sw := sws[rand.Intn(len(sws))]
+		host := rand.Intn(256)
+		r := &ogo.Recipe{Priority: 100, Outputs: []uint16{uint16(3 + host%2)},
+			Match: ogo.FlowMatch{EthDst: net.HardwareAddr{2, 0, 0, 0, 0, byte(host)}}}
+		var err error
+		if rand.Intn(2) == 0 {
+			err = sw.InstallRecipe(r)
+		} else {
+			err = sw.RemoveRecipe(r)
+		}
+		if err == nil {
+			s.flowMods += 1
+		}
+	}
+}
+
+func (s *soak) sample() {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	x := sample{time: time.Now(), goroutines: runtime.NumGoroutine(), heap: mem.HeapAlloc,
+		switches: len(ogo.Switches())}
+	for _, sw := range ogo.Switches() {
+		x.links += len(sw.Links())
+	}
+
+	// A queue is stuck if it has messages and hasn't drained
+	// since the last sample.
+	for _, q := range ogo.Queues() {
+		n := q.Pending()
+		x.pending += n
+		if n == 0 {
+			delete(s.stuck, q.DPID)
+			continue
+		}
+		s.stuck[q.DPID] += 1
+		if s.stuck[q.DPID] == *stuckSamples {
+			s.fail("queue of %s stuck with %d messages", q.DPID, n)
+		}
+	}
+	if x.switches != len(s.ring) {
+		s.fail("%d of %d switches connected at %s", x.switches, len(s.ring), x.time.Format(time.RFC3339))
+	}
+	s.samples = append(s.samples, x)
+	log.Printf("goroutines %d, heap %d KiB, switches %d, links %d, queued %d",
+		x.goroutines, x.heap/1024, x.switches, x.links, x.pending)
+}
+
+func (s *soak) fail(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Println("FAIL:", msg)
+	s.failures = append(s.failures, msg)
+}
+
+// Compares the end of the run to the baseline taken after the
+// warmup. The median of the last quarter of the samples is used
+// so a single busy sample doesn't fail the run.
+func (s *soak) check() {
+	if len(s.samples) < 2 {
+		return
+	}
+	base := s.samples[0]
+	last := s.samples[len(s.samples)*3/4:]
+	goroutines := make([]int, 0, len(last))
+	heaps := make([]int, 0, len(last))
+	for _, x := range last {
+		goroutines = append(goroutines, x.goroutines)
+		heaps = append(heaps, int(x.heap))
+	}
+	if g := median(goroutines); g > base.goroutines+*goroutineSlack {
+		s.fail("goroutines grew from %d to %d", base.goroutines, g)
+	}
+	if h := median(heaps); float64(h) > float64(base.heap)**heapGrowth {
+		s.fail("heap grew from %d KiB to %d KiB", base.heap/1024, h/1024)
+	}
+}
+
+func median(a []int) int {
+	sort.Ints(a)
+	return a[len(a)/2]
+}
+
+func (s *soak) report(w *os.File) {
+	var stats emulator.Stats
+	for _, sw := range s.ring {
+		x := sw.Stats()
+		stats.Received += x.Received
+		stats.Sent += x.Sent
+		stats.PacketIns += x.PacketIns
+		stats.PacketOuts += x.PacketOuts
+		stats.FlowMods += x.FlowMods
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "switches\t%d\n", len(s.ring))
+	fmt.Fprintf(tw, "link flaps\t%d\n", s.flaps)
+	fmt.Fprintf(tw, "flow mods pushed\t%d\n", s.flowMods)
+	fmt.Fprintf(tw, "messages to switches\t%d\n", stats.Received)
+	fmt.Fprintf(tw, "messages from switches\t%d\n", stats.Sent)
+	fmt.Fprintf(tw, "packet-outs, packet-ins\t%d, %d\n", stats.PacketOuts, stats.PacketIns)
+	if len(s.samples) > 0 {
+		first, last := s.samples[0], s.samples[len(s.samples)-1]
+		maxG, maxH := 0, uint64(0)
+		for _, x := range s.samples {
+			if x.goroutines > maxG {
+				maxG = x.goroutines
+			}
+			if x.heap > maxH {
+				maxH = x.heap
+			}
+		}
+		fmt.Fprintf(tw, "samples\t%d over %s\n", len(s.samples), last.time.Sub(first.time))
+		fmt.Fprintf(tw, "goroutines baseline, final, max\t%d, %d, %d\n", first.goroutines, last.goroutines, maxG)
+		fmt.Fprintf(tw, "heap KiB baseline, final, max\t%d, %d, %d\n", first.heap/1024, last.heap/1024, maxH/1024)
+		fmt.Fprintf(tw, "links discovered, emulated\t%d, %d\n", last.links, s.network.Links())
+	}
+	tw.Flush()
+	if len(s.failures) == 0 {
+		fmt.Fprintln(w, "PASS")
+		return
+	}
+	for _, f := range s.failures {
+		fmt.Fprintln(w, "FAIL:", f)
+	}
+}
@@ -0,0 +1,159 @@
+package ogo
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/jonstout/ogo/ovsdb"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// What a switch does with traffic while it has no controller.
+type FailMode string
+
+const (
+	// Forward only with the flows already installed.
+	FailSecure FailMode = "secure"
+	// Act as an ordinary learning switch.
+	FailStandalone FailMode = "standalone"
+)
+
+// A rule for a switch to forward with while disconnected from
+// the controller. Empty Ports drops the traffic.
+type EmergencyFlow struct {
+	Match    FlowMatch
+	Priority uint16
+	Ports    []uint16
+}
+
+// How a switch behaves when it loses the controller.
+type FailoverConfig struct {
+	// Set through OVSDB, left alone if empty.
+	Mode FailMode
+	// Installed as soon as the switch connects. OpenFlow 1.0
+	// switches keep them in the emergency flow table, which
+	// replaces the normal table on disconnect. Newer switches
+	// have no emergency table, so they're installed as
+	// permanent flows and only take effect in FailSecure mode.
+	Flows []EmergencyFlow
+}
+
+// Failover applies a FailoverConfig to every switch as it
+// connects, the default one unless the switch has its own.
+type Failover struct {
+	mu      sync.Mutex
+	Default FailoverConfig
+	configs map[string]FailoverConfig
+	bridges map[string]failoverBridge
+	sub     *Subscription
+}
+
+type failoverBridge struct {
+	db   *ovsdb.Client
+	name string
+}
+
+func NewFailover() *Failover {
+	f := new(Failover)
+	f.configs = make(map[string]FailoverConfig)
+	f.bridges = make(map[string]failoverBridge)
+	return f
+}
+
+func (f *Failover) Start() {
+	f.sub = Subscribe(64, EventSwitchUp)
+	go func() {
+		for e := range f.sub.C {
+			if sw, ok := Switch(e.DPID); ok {
+				f.apply(sw)
+			}
+		}
+	}()
+}
+
+func (f *Failover) Stop() {
+	if f.sub != nil {
+		f.sub.Cancel()
+	}
+}
+
+// Sets the configuration of Switch dpid, applying it now if the
+// switch is connected.
+func (f *Failover) Configure(dpid net.HardwareAddr, cfg FailoverConfig) {
+	f.mu.Lock()
+	f.configs[dpid.String()] = cfg
+	f.mu.Unlock()
+	if sw, ok := Switch(dpid); ok {
+		f.apply(sw)
+	}
+}
+
+// Sets the fail mode of Switch dpid through the OVSDB bridge
+// called bridge.
+func (f *Failover) AddBridge(dpid net.HardwareAddr, db *ovsdb.Client, bridge string) {
+	f.mu.Lock()
+	f.bridges[dpid.String()] = failoverBridge{db, bridge}
+	f.mu.Unlock()
+}
+
+func (f *Failover) config(dpid net.HardwareAddr) FailoverConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cfg, ok := f.configs[dpid.String()]; ok {
+		return cfg
+	}
+	return f.Default
+}
+
+func (f *Failover) apply(sw *OFSwitch) {
+	cfg := f.config(sw.DPID())
+	for _, e := range cfg.Flows {
+		if err := sw.installEmergency(e); err != nil {
+			log.Println("Failed to install emergency flow on", SwitchLabel(sw.DPID()), err)
+		}
+	}
+	if cfg.Mode == "" {
+		return
+	}
+	f.mu.Lock()
+	b, ok := f.bridges[sw.DPID().String()]
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := SetFailMode(b.db, b.name, cfg.Mode); err != nil {
+		log.Println("Failed to set fail mode of", SwitchLabel(sw.DPID()), err)
+	}
+}
+
+// Sets the fail mode of the OVSDB bridge called bridge.
+func SetFailMode(db *ovsdb.Client, bridge string, mode FailMode) error {
+	if mode != FailSecure && mode != FailStandalone {
+		return errors.New("Unknown fail mode.")
+	}
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", bridge)}
+	row := map[string]interface{}{"fail_mode": string(mode)}
+	_, err := db.Transact("Open_vSwitch", ovsdb.Update("Bridge", where, row))
+	return err
+}
+
+// Installs e as an emergency flow on Switch s.
+func (s *OFSwitch) installEmergency(e EmergencyFlow) error {
+	if s.Version() != ofp10.VERSION {
+		if len(e.Ports) == 0 {
+			return s.installDrop(e.Match, e.Priority)
+		}
+		return s.installOutputs(e.Match, e.Priority, e.Ports)
+	}
+	// Emergency flows can't time out.
+	f := ofp10.NewFlowMod()
+	f.Match = e.Match.ofp10()
+	f.Priority = e.Priority
+	f.Flags = ofp10.FF_EMERG
+	for _, p := range e.Ports {
+		f.AddAction(ofp10.NewActionOutput(p))
+	}
+	return s.Send(f)
+}
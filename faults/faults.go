@@ -0,0 +1,256 @@
+// Package faults injects network faults into OpenFlow
+// connections, so tests can check deterministically how
+// applications cope with slow, lossy or broken switches. A Conn
+// wraps either end of a connection, for example one end of a
+// net.Pipe whose other end is given to ogo.NewMessageStream, and
+// applies Faults to whole OpenFlow messages in each direction.
+// Rates are drawn from a generator seeded with Seed, so a test
+// sees the same faults on every run.
+package faults
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Returned once a Conn has disconnected after DisconnectAfter
+// messages.
+var ErrDisconnected = errors.New("The connection was closed by fault injection.")
+
+// The faults applied to the messages sent in one direction. The
+// zero value passes every message unchanged.
+type Faults struct {
+	// OpenFlow message types the faults apply to, all if
+	// empty. DisconnectAfter counts every message regardless.
+	Types []uint8
+	// How long each message is held before it is passed on.
+	Delay time.Duration
+	// The fraction of messages dropped, and every how many
+	// messages one is dropped.
+	DropRate  float64
+	DropEvery int
+	// The fraction of messages held back and passed on after
+	// the message following them.
+	ReorderRate float64
+	// The connection is closed after passing this many
+	// messages, never if 0.
+	DisconnectAfter int
+	// The fraction of messages whose header length is replaced
+	// with an invalid one, shorter than the header.
+	CorruptLengthRate float64
+	Seed              int64
+}
+
+// Counts of the faults injected in one direction.
+type Stats struct {
+	Messages  int
+	Delayed   int
+	Dropped   int
+	Reordered int
+	Corrupted int
+}
+
+// Applies Faults to a stream of messages.
+type injector struct {
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+	buf    []byte
+	held   []byte
+	count  int
+	stats  Stats
+	closed bool
+}
+
+func newInjector(f Faults) *injector {
+	i := new(injector)
+	i.set(f)
+	return i
+}
+
+func (i *injector) set(f Faults) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults = f
+	i.rand = rand.New(rand.NewSource(f.Seed))
+}
+
+func (i *injector) applies(msg []byte) bool {
+	if len(i.faults.Types) == 0 {
+		return true
+	}
+	for _, t := range i.faults.Types {
+		if msg[1] == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds data to the stream and returns the bytes to pass on, how
+// long to wait before passing them and whether the connection
+// must be closed after them.
+func (i *injector) feed(data []byte) (out []byte, delay time.Duration, disconnect bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		return nil, 0, true
+	}
+	i.buf = append(i.buf, data...)
+	for len(i.buf) >= 8 {
+		length := int(binary.BigEndian.Uint16(i.buf[2:]))
+		if length < 8 {
+			// Already broken, pass it on as is.
+			out = append(out, i.buf...)
+			i.buf = nil
+			break
+		}
+		if len(i.buf) < length {
+			break
+		}
+		msg := append([]byte(nil), i.buf[:length]...)
+		i.buf = i.buf[length:]
+
+		i.count += 1
+		i.stats.Messages += 1
+		f := i.faults
+		if i.applies(msg) {
+			if f.DropEvery > 0 && i.count%f.DropEvery == 0 || f.DropRate > 0 && i.rand.Float64() < f.DropRate {
+				i.stats.Dropped += 1
+				msg = nil
+			}
+			if msg != nil && f.CorruptLengthRate > 0 && i.rand.Float64() < f.CorruptLengthRate {
+				i.stats.Corrupted += 1
+				binary.BigEndian.PutUint16(msg[2:], 4)
+			}
+			if msg != nil && i.held == nil && f.ReorderRate > 0 && i.rand.Float64() < f.ReorderRate {
+				i.stats.Reordered += 1
+				i.held = msg
+				msg = nil
+			}
+			if msg != nil && f.Delay > 0 {
+				i.stats.Delayed += 1
+				delay += f.Delay
+			}
+		}
+		if msg != nil {
+			out = append(out, msg...)
+			if i.held != nil {
+				out = append(out, i.held...)
+				i.held = nil
+			}
+		}
+		if f.DisconnectAfter > 0 && i.count >= f.DisconnectAfter {
+			if i.held != nil {
+				out = append(out, i.held...)
+				i.held = nil
+			}
+			i.closed = true
+			return out, delay, true
+		}
+	}
+	return out, delay, false
+}
+
+func (i *injector) disconnected() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.closed
+}
+
+// A net.Conn that injects faults into the messages read from
+// and written to it.
+type Conn struct {
+	net.Conn
+	in      *injector
+	out     *injector
+	readMu  sync.Mutex
+	pending []byte
+}
+
+// Wraps c, applying in to the messages read from it and out to
+// the messages written to it.
+func Wrap(c net.Conn, in, out Faults) *Conn {
+	f := new(Conn)
+	f.Conn = c
+	f.in = newInjector(in)
+	f.out = newInjector(out)
+	return f
+}
+
+// Replaces the faults applied to messages read from c. The
+// generator is seeded again with the new Seed.
+func (c *Conn) SetInbound(f Faults) {
+	c.in.set(f)
+}
+
+// Replaces the faults applied to messages written to c.
+func (c *Conn) SetOutbound(f Faults) {
+	c.out.set(f)
+}
+
+// Returns the faults injected so far into the messages read
+// from and written to c.
+func (c *Conn) Stats() (in, out Stats) {
+	c.in.mu.Lock()
+	in = c.in.stats
+	c.in.mu.Unlock()
+	c.out.mu.Lock()
+	out = c.out.stats
+	c.out.mu.Unlock()
+	return
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	buf := make([]byte, 4096)
+	for len(c.pending) == 0 {
+		if c.in.disconnected() {
+			return 0, io.EOF
+		}
+		n, err := c.Conn.Read(buf)
+		if n > 0 {
+			data, delay, disconnect := c.in.feed(buf[:n])
+			time.Sleep(delay)
+			c.pending = append(c.pending, data...)
+			if disconnect {
+				c.Conn.Close()
+				if len(c.pending) == 0 {
+					return 0, io.EOF
+				}
+				break
+			}
+		}
+		if err != nil && len(c.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Returns len(b) when b is accepted, even if the messages in it
+// are dropped or held back.
+func (c *Conn) Write(b []byte) (int, error) {
+	data, delay, disconnect := c.out.feed(b)
+	time.Sleep(delay)
+	if len(data) > 0 {
+		if _, err := c.Conn.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	if disconnect {
+		c.Conn.Close()
+		if len(data) == 0 {
+			return 0, ErrDisconnected
+		}
+	}
+	return len(b), nil
+}
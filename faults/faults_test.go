@@ -0,0 +1,101 @@
+package faults
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo"
+)
+
+// Returns an OpenFlow 1.0 echo request with transaction id xid.
+func echo(xid uint32) []byte {
+	b := make([]byte, 8)
+	b[0], b[1] = 1, 2
+	binary.BigEndian.PutUint16(b[2:], 8)
+	binary.BigEndian.PutUint32(b[4:], xid)
+	return b
+}
+
+// Writes the echo requests xids to w and returns the
+// transaction ids of the messages that reach the other end of
+// the pipe.
+func send(t *testing.T, f Faults, xids ...uint32) []uint32 {
+	a, b := net.Pipe()
+	w := Wrap(a, Faults{}, f)
+	go func() {
+		for _, x := range xids {
+			if _, err := w.Write(echo(x)); err != nil {
+				break
+			}
+		}
+		w.Close()
+	}()
+	got := make([]uint32, 0)
+	buf := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(b, buf); err != nil {
+			return got
+		}
+		got = append(got, binary.BigEndian.Uint32(buf[4:]))
+	}
+}
+
+func equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFaultsDropEvery(t *testing.T) {
+	got := send(t, Faults{DropEvery: 2}, 1, 2, 3, 4, 5)
+	if !equal(got, []uint32{1, 3, 5}) {
+		t.Errorf("Got %v.", got)
+	}
+}
+
+func TestFaultsReorder(t *testing.T) {
+	got := send(t, Faults{ReorderRate: 1}, 1, 2, 3, 4)
+	if !equal(got, []uint32{2, 1, 4, 3}) {
+		t.Errorf("Got %v.", got)
+	}
+}
+
+func TestFaultsDisconnectAfter(t *testing.T) {
+	got := send(t, Faults{DisconnectAfter: 2}, 1, 2, 3)
+	if !equal(got, []uint32{1, 2}) {
+		t.Errorf("Got %v.", got)
+	}
+}
+
+func TestFaultsSeed(t *testing.T) {
+	f := Faults{DropRate: 0.5, Seed: 7}
+	xids := []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if a, b := send(t, f, xids...), send(t, f, xids...); !equal(a, b) {
+		t.Errorf("Got %v, then %v.", a, b)
+	}
+}
+
+func TestFaultsCorruptLength(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	m := ogo.NewMessageStream(Wrap(a, Faults{CorruptLengthRate: 1}, Faults{}))
+	defer m.Close()
+
+	go b.Write(echo(1))
+	select {
+	case <-m.Inbound:
+		t.Error("Received a message with a corrupt length.")
+	case <-m.Error:
+	case <-time.After(time.Second * 5):
+		t.Error("No error for a corrupt length.")
+	}
+}
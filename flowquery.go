@@ -0,0 +1,317 @@
+package ogo
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// The most flows QueryFlows returns at once.
+var MaxFlowPage = 1000
+
+// Match field names usable in a FlowQuery.
+var flowFields = map[string]uint8{
+	"in_port":  ofp14.XMT_OFB_IN_PORT,
+	"eth_dst":  ofp14.XMT_OFB_ETH_DST,
+	"eth_src":  ofp14.XMT_OFB_ETH_SRC,
+	"eth_type": ofp14.XMT_OFB_ETH_TYPE,
+	"vlan_vid": ofp14.XMT_OFB_VLAN_VID,
+	"ip_proto": ofp14.XMT_OFB_IP_PROTO,
+	"ipv4_src": ofp14.XMT_OFB_IPV4_SRC,
+	"ipv4_dst": ofp14.XMT_OFB_IPV4_DST,
+	"tcp_src":  ofp14.XMT_OFB_TCP_SRC,
+	"tcp_dst":  ofp14.XMT_OFB_TCP_DST,
+	"udp_src":  ofp14.XMT_OFB_UDP_SRC,
+	"udp_dst":  ofp14.XMT_OFB_UDP_DST,
+}
+
+type flowOwner struct {
+	name         string
+	cookie, mask uint64
+}
+
+var flowOwners = struct {
+	sync.RWMutex
+	a []flowOwner
+}{}
+
+// Makes app the owner of flows whose cookie, masked with mask,
+// is cookie. Flows are owned by the first matching registration.
+func RegisterFlowOwner(app string, cookie, mask uint64) {
+	flowOwners.Lock()
+	flowOwners.a = append(flowOwners.a, flowOwner{app, cookie & mask, mask})
+	flowOwners.Unlock()
+}
+
+// Returns the application owning flows with cookie.
+func FlowOwner(cookie uint64) string {
+	flowOwners.RLock()
+	defer flowOwners.RUnlock()
+	for _, o := range flowOwners.a {
+		if cookie&o.mask == o.cookie {
+			return o.name
+		}
+	}
+	return ""
+}
+
+// Selects flows from the flow shadows of the switches. Zero
+// valued fields select everything.
+type FlowQuery struct {
+	DPID string
+	// -1 for every table.
+	Table       int
+	Cookie      uint64
+	CookieMask  uint64
+	MinPriority uint16
+	// 0 for no maximum.
+	MaxPriority uint16
+	// Match fields by name, the flow must match the field
+	// with the given value, or at all if the value is empty.
+	Match map[string]string
+	Owner string
+	// "dpid" (the default), "table", "priority" or "cookie",
+	// prefixed with "-" for descending order.
+	Sort string
+	// Defaults to MaxFlowPage.
+	Limit int
+	// The Next of the previous page.
+	Cursor string
+}
+
+// A flow as returned by QueryFlows.
+type FlowRecord struct {
+	DPID         string            `json:"dpid"`
+	Table        uint8             `json:"table"`
+	Priority     uint16            `json:"priority"`
+	Cookie       uint64            `json:"cookie"`
+	IdleTimeout  uint16            `json:"idle_timeout"`
+	HardTimeout  uint16            `json:"hard_timeout"`
+	Owner        string            `json:"owner,omitempty"`
+	Match        map[string]string `json:"match"`
+	Instructions string            `json:"instructions"`
+	Installed    time.Time         `json:"installed,omitempty"`
+	key          string
+}
+
+// A page of flows. Next is empty on the last page.
+type FlowPage struct {
+	Flows []FlowRecord `json:"flows"`
+	Next  string       `json:"next,omitempty"`
+}
+
+// Returns a page of the flows selected by q. Pages continue
+// after the last flow of the previous one rather than at an
+// offset, so flows added or removed between requests don't cause
+// flows to be skipped or repeated.
+func QueryFlows(q FlowQuery) (*FlowPage, error) {
+	order, desc := q.Sort, false
+	if strings.HasPrefix(order, "-") {
+		order, desc = order[1:], true
+	}
+	if order == "" {
+		order = "dpid"
+	}
+	switch order {
+	case "dpid", "table", "priority", "cookie":
+	default:
+		return nil, fmt.Errorf("Unknown sort order %q.", q.Sort)
+	}
+	fields := make(map[uint8]string)
+	for name, v := range q.Match {
+		f, ok := flowFields[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown match field %q.", name)
+		}
+		fields[f] = v
+	}
+	var after *flowCursor
+	if q.Cursor != "" {
+		c, err := parseFlowCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = c
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxFlowPage {
+		limit = MaxFlowPage
+	}
+
+	records := flowRecords{a: make([]FlowRecord, 0), order: order, desc: desc}
+	for _, sw := range Switches() {
+		if q.DPID != "" && q.DPID != sw.DPID().String() {
+			continue
+		}
+		for _, f := range sw.Flows() {
+			if !q.selects(f, fields) {
+				continue
+			}
+			r := newFlowRecord(sw.DPID().String(), f)
+			if q.Owner != "" && r.Owner != q.Owner {
+				continue
+			}
+			records.a = append(records.a, r)
+		}
+	}
+	sort.Sort(records)
+
+	page := &FlowPage{Flows: make([]FlowRecord, 0, limit)}
+	for _, r := range records.a {
+		if after != nil && !records.after(r, after) {
+			continue
+		}
+		if len(page.Flows) == limit {
+			last := page.Flows[len(page.Flows)-1]
+			page.Next = records.cursor(last).String()
+			break
+		}
+		page.Flows = append(page.Flows, r)
+	}
+	return page, nil
+}
+
+func (q FlowQuery) selects(f FlowEntry, fields map[uint8]string) bool {
+	if q.Table >= 0 && int(f.TableId) != q.Table {
+		return false
+	}
+	if f.Cookie&q.CookieMask != q.Cookie&q.CookieMask {
+		return false
+	}
+	if f.Priority < q.MinPriority || (q.MaxPriority != 0 && f.Priority > q.MaxPriority) {
+		return false
+	}
+	for field, want := range fields {
+		v, ok := f.Match.Field(field)
+		if !ok || (want != "" && !matchValue(v, want)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the field value v is want, a MAC or IP
+// address or a number.
+func matchValue(v []byte, want string) bool {
+	if mac, err := net.ParseMAC(want); err == nil {
+		return string(v) == string(mac)
+	}
+	if ip := net.ParseIP(want); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil && len(v) == 4 {
+			return string(v) == string(ip4)
+		}
+		return string(v) == string(ip)
+	}
+	n, err := strconv.ParseUint(want, 0, 64)
+	if err != nil || len(v) > 8 {
+		return false
+	}
+	var got uint64
+	for _, b := range v {
+		got = got<<8 | uint64(b)
+	}
+	return got == n
+}
+
+func newFlowRecord(dpid string, f FlowEntry) FlowRecord {
+	r := FlowRecord{
+		DPID:         dpid,
+		Table:        f.TableId,
+		Priority:     f.Priority,
+		Cookie:       f.Cookie,
+		IdleTimeout:  f.IdleTimeout,
+		HardTimeout:  f.HardTimeout,
+		Owner:        FlowOwner(f.Cookie),
+		Match:        make(map[string]string),
+		Instructions: hex.EncodeToString(f.Instructions),
+		Installed:    f.Installed,
+		key:          flowKey(f.TableId, f.Priority, &f.Match),
+	}
+	for name, field := range flowFields {
+		if v, ok := f.Match.Field(field); ok {
+			r.Match[name] = hex.EncodeToString(v)
+		}
+	}
+	return r
+}
+
+// The position of a flow in the sort order. Flows with equal
+// sort values are ordered by DPID and then flow key, so the
+// order is total.
+type flowCursor struct {
+	value uint64
+	dpid  string
+	key   string
+}
+
+func (c *flowCursor) String() string {
+	s := fmt.Sprintf("%d|%s|%s", c.value, c.dpid, c.key)
+	return base64.URLEncoding.EncodeToString([]byte(s))
+}
+
+var errBadCursor = errors.New("The cursor is not valid.")
+
+func parseFlowCursor(s string) (*flowCursor, error) {
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	a := strings.SplitN(string(b), "|", 3)
+	if len(a) != 3 {
+		return nil, errBadCursor
+	}
+	v, err := strconv.ParseUint(a[0], 10, 64)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	return &flowCursor{v, a[1], a[2]}, nil
+}
+
+type flowRecords struct {
+	a     []FlowRecord
+	order string
+	desc  bool
+}
+
+func (r flowRecords) cursor(f FlowRecord) *flowCursor {
+	c := &flowCursor{dpid: f.DPID, key: f.key}
+	switch r.order {
+	case "table":
+		c.value = uint64(f.Table)
+	case "priority":
+		c.value = uint64(f.Priority)
+	case "cookie":
+		c.value = f.Cookie
+	}
+	return c
+}
+
+// Returns true if f sorts after cursor c.
+func (r flowRecords) after(f FlowRecord, c *flowCursor) bool {
+	return r.less(c, r.cursor(f))
+}
+
+func (r flowRecords) less(a, b *flowCursor) bool {
+	if a.value != b.value {
+		return (a.value < b.value) != r.desc
+	}
+	if a.dpid != b.dpid {
+		return a.dpid < b.dpid
+	}
+	return a.key < b.key
+}
+
+func (r flowRecords) Len() int      { return len(r.a) }
+func (r flowRecords) Swap(i, j int) { r.a[i], r.a[j] = r.a[j], r.a[i] }
+func (r flowRecords) Less(i, j int) bool {
+	return r.less(r.cursor(r.a[i]), r.cursor(r.a[j]))
+}
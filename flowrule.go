@@ -0,0 +1,348 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A FlowRule is a flow written once for every OpenFlow version: it
+// compiles to an OpenFlow 1.0 flow mod with actions, or to an
+// OpenFlow 1.3+ flow mod with instructions, for whatever version
+// the switch it is installed on negotiated.
+//
+//	r := &ogo.FlowRule{Priority: 100, Match: ogo.FlowMatch{InPort: 1, VLAN: 10},
+//		Actions: []ogo.FlowAction{ogo.SetVLAN(20), ogo.Output(2)}}
+//	err := sw.InstallFlowRule(r)
+//
+// Ports use OpenFlow 1.0 numbering; reserved ports such as
+// ofp10.P_CONTROLLER are translated for newer switches.
+type FlowRule struct {
+	// Rules outside table 0, or going to another table, can't be
+	// installed on OpenFlow 1.0 switches.
+	Table       uint8
+	Priority    uint16
+	Cookie      uint64
+	IdleTimeout uint16
+	HardTimeout uint16
+	// Ask the switch for a flow removed message when the rule
+	// expires or is deleted.
+	SendRemoved bool
+	Match       FlowMatch
+	// Applied in order. Packets are dropped if none outputs them.
+	Actions []FlowAction
+	// If not 0, packets continue to this table after the
+	// actions.
+	GotoTable uint8
+}
+
+const (
+	FlowActionOutput = iota
+	FlowActionEnqueue
+	FlowActionSetVLAN
+	FlowActionSetVLANPCP
+	FlowActionStripVLAN
+	FlowActionSetEthSrc
+	FlowActionSetEthDst
+	FlowActionSetIPSrc
+	FlowActionSetIPDst
+	FlowActionSetTPSrc
+	FlowActionSetTPDst
+)
+
+// An action of a FlowRule, made by Output, Enqueue, SetVLAN,
+// SetVLANPCP, StripVLAN, SetEthSrc, SetEthDst, SetIPSrc, SetIPDst,
+// SetTPSrc or SetTPDst.
+type FlowAction struct {
+	Type int
+	// The port of output and enqueue.
+	Port  uint16
+	Queue uint32
+	// The VLAN id or priority, or TCP or UDP port, set.
+	Value uint16
+	MAC   net.HardwareAddr
+	IP    net.IP
+}
+
+// Sends packets out port.
+func Output(port uint16) FlowAction {
+	return FlowAction{Type: FlowActionOutput, Port: port}
+}
+
+// Sends packets to queue of port.
+func Enqueue(port uint16, queue uint32) FlowAction {
+	return FlowAction{Type: FlowActionEnqueue, Port: port, Queue: queue}
+}
+
+// Sets the id of the outermost VLAN tag, adding a tag to packets
+// without one. On OpenFlow 1.3+ switches a tag is pushed unless
+// the rule matches a VLAN or an earlier action added one.
+func SetVLAN(vid uint16) FlowAction {
+	return FlowAction{Type: FlowActionSetVLAN, Value: vid}
+}
+
+// Sets the priority of the outermost VLAN tag, adding a tag like
+// SetVLAN.
+func SetVLANPCP(pcp uint8) FlowAction {
+	return FlowAction{Type: FlowActionSetVLANPCP, Value: uint16(pcp)}
+}
+
+// Removes the outermost VLAN tag.
+func StripVLAN() FlowAction {
+	return FlowAction{Type: FlowActionStripVLAN}
+}
+
+func SetEthSrc(mac net.HardwareAddr) FlowAction {
+	return FlowAction{Type: FlowActionSetEthSrc, MAC: mac}
+}
+
+func SetEthDst(mac net.HardwareAddr) FlowAction {
+	return FlowAction{Type: FlowActionSetEthDst, MAC: mac}
+}
+
+func SetIPSrc(ip net.IP) FlowAction {
+	return FlowAction{Type: FlowActionSetIPSrc, IP: ip}
+}
+
+func SetIPDst(ip net.IP) FlowAction {
+	return FlowAction{Type: FlowActionSetIPDst, IP: ip}
+}
+
+// Sets the TCP or UDP source port, by the ip_proto the rule
+// matches.
+func SetTPSrc(port uint16) FlowAction {
+	return FlowAction{Type: FlowActionSetTPSrc, Value: port}
+}
+
+func SetTPDst(port uint16) FlowAction {
+	return FlowAction{Type: FlowActionSetTPDst, Value: port}
+}
+
+// Checks that r can be installed on a switch of OpenFlow version:
+// its match is valid, and its actions set fields the match gives
+// them, see Recipe.Validate.
+func (r *FlowRule) Validate(version uint8) error {
+	m := r.Match
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if version != ofp10.VERSION && version < ofp13.VERSION {
+		return fmt.Errorf("Flow rules can't be compiled for OpenFlow version %#x.", version)
+	}
+	if version == ofp10.VERSION && (r.Table != 0 || r.GotoTable != 0) {
+		return errors.New("OpenFlow 1.0 switches have a single table.")
+	}
+	if r.GotoTable != 0 && r.GotoTable <= r.Table {
+		return fmt.Errorf("Table %d can't go to table %d.", r.Table, r.GotoTable)
+	}
+	ip := m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0 || m.EthType == 0x0800
+	for _, a := range r.Actions {
+		switch a.Type {
+		case FlowActionOutput, FlowActionEnqueue:
+			if err := m.validateOutput(a.Port); err != nil {
+				return err
+			}
+		case FlowActionSetVLAN:
+			if a.Value > 0xfff {
+				return fmt.Errorf("Bad VLAN %d.", a.Value)
+			}
+		case FlowActionSetVLANPCP:
+			if a.Value > 7 {
+				return fmt.Errorf("Bad VLAN priority %d.", a.Value)
+			}
+		case FlowActionStripVLAN:
+		case FlowActionSetEthSrc, FlowActionSetEthDst:
+			if len(a.MAC) != 6 {
+				return fmt.Errorf("%s is not an Ethernet address.", a.MAC)
+			}
+		case FlowActionSetIPSrc, FlowActionSetIPDst:
+			if a.IP.To4() == nil {
+				return fmt.Errorf("%s is not an IPv4 address.", a.IP)
+			}
+			if !ip || m.EthType != 0 && m.EthType != 0x0800 {
+				return errors.New("Rewriting IP addresses needs a match on IPv4 packets.")
+			}
+		case FlowActionSetTPSrc, FlowActionSetTPDst:
+			if m.IPProto != 6 && m.IPProto != 17 {
+				return errors.New("Rewriting TCP or UDP ports needs ip_proto 6 or 17.")
+			}
+		default:
+			return fmt.Errorf("Unknown action type %d.", a.Type)
+		}
+	}
+	return nil
+}
+
+// Returns a flow mod adding r to a switch of OpenFlow version.
+func (r *FlowRule) FlowMod(version uint8) (util.Message, error) {
+	if err := r.Validate(version); err != nil {
+		return nil, err
+	}
+	if version == ofp10.VERSION {
+		f := r.ofp10()
+		for _, a := range r.Actions {
+			f.AddAction(a.ofp10())
+		}
+		return f, nil
+	}
+	f := r.ofp14(version)
+	if len(r.Actions) > 0 {
+		f.AddInstruction(r.ofp14Actions())
+	}
+	if r.GotoTable != 0 {
+		f.AddInstruction(ofp14.NewInstrGotoTable(r.GotoTable))
+	}
+	return f, nil
+}
+
+// Returns a flow mod deleting r from a switch of OpenFlow
+// version.
+func (r *FlowRule) DeleteMod(version uint8) (util.Message, error) {
+	if err := r.Validate(version); err != nil {
+		return nil, err
+	}
+	if version == ofp10.VERSION {
+		f := r.ofp10()
+		f.Command = ofp10.FC_DELETE_STRICT
+		return f, nil
+	}
+	f := r.ofp14(version)
+	f.Command = ofp14.FC_DELETE_STRICT
+	return f, nil
+}
+
+func (r *FlowRule) ofp10() *ofp10.FlowMod {
+	f := ofp10.NewFlowMod()
+	f.Match = r.Match.ofp10()
+	f.Priority = r.Priority
+	f.Cookie = r.Cookie
+	f.IdleTimeout = r.IdleTimeout
+	f.HardTimeout = r.HardTimeout
+	if r.SendRemoved {
+		f.Flags |= ofp10.FF_SEND_FLOW_REM
+	}
+	return f
+}
+
+func (a FlowAction) ofp10() ofp10.Action {
+	switch a.Type {
+	case FlowActionEnqueue:
+		return ofp10.NewActionEnqueue(a.Port, a.Queue)
+	case FlowActionSetVLAN:
+		return ofp10.NewActionVLANVID(a.Value)
+	case FlowActionSetVLANPCP:
+		return ofp10.NewActionVLANPCP(uint8(a.Value))
+	case FlowActionStripVLAN:
+		return ofp10.NewActionStripVLAN()
+	case FlowActionSetEthSrc:
+		return ofp10.NewActionDLSrc(a.MAC)
+	case FlowActionSetEthDst:
+		return ofp10.NewActionDLDst(a.MAC)
+	case FlowActionSetIPSrc:
+		return ofp10.NewActionNWSrc(a.IP.To4())
+	case FlowActionSetIPDst:
+		return ofp10.NewActionNWDst(a.IP.To4())
+	case FlowActionSetTPSrc:
+		return ofp10.NewActionTPSrc(a.Value)
+	case FlowActionSetTPDst:
+		return ofp10.NewActionTPDst(a.Value)
+	}
+	return ofp10.NewActionOutput(a.Port)
+}
+
+func (r *FlowRule) ofp14(version uint8) *ofp14.FlowMod {
+	f := ofp14.NewFlowMod()
+	f.Header.Version = version
+	f.TableId = r.Table
+	f.Match = r.Match.ofp14()
+	f.Priority = r.Priority
+	f.Cookie = r.Cookie
+	f.IdleTimeout = r.IdleTimeout
+	f.HardTimeout = r.HardTimeout
+	if r.SendRemoved {
+		f.Flags |= ofp14.FF_SEND_FLOW_REM
+	}
+	return f
+}
+
+// Returns the apply actions instruction of r. OpenFlow 1.3+
+// switches only set the VLAN of tagged packets, so a tag is pushed
+// first where OpenFlow 1.0 would add one.
+func (r *FlowRule) ofp14Actions() *ofp14.InstrActions {
+	actions := ofp14.NewInstrApplyActions()
+	tagged := r.Match.VLAN != 0
+	src, dst := uint8(ofp14.XMT_OFB_TCP_SRC), uint8(ofp14.XMT_OFB_TCP_DST)
+	if r.Match.IPProto == 17 {
+		src, dst = ofp14.XMT_OFB_UDP_SRC, ofp14.XMT_OFB_UDP_DST
+	}
+	for _, a := range r.Actions {
+		switch a.Type {
+		case FlowActionOutput:
+			actions.AddAction(ofp14.NewActionOutput(ofp14Port(a.Port)))
+		case FlowActionEnqueue:
+			actions.AddAction(ofp14.NewActionSetQueue(a.Queue))
+			actions.AddAction(ofp14.NewActionOutput(ofp14Port(a.Port)))
+		case FlowActionSetVLAN, FlowActionSetVLANPCP:
+			if !tagged {
+				actions.AddAction(ofp14.NewActionPushVlan(0x8100))
+				tagged = true
+			}
+			if a.Type == FlowActionSetVLAN {
+				actions.AddAction(setVLANAction(a.Value))
+			} else {
+				actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_VLAN_PCP, []byte{byte(a.Value)}))
+			}
+		case FlowActionStripVLAN:
+			actions.AddAction(ofp14.NewActionPopVlan())
+			tagged = false
+		case FlowActionSetEthSrc:
+			actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_ETH_SRC, a.MAC))
+		case FlowActionSetEthDst:
+			actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_ETH_DST, a.MAC))
+		case FlowActionSetIPSrc:
+			actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_IPV4_SRC, a.IP.To4()))
+		case FlowActionSetIPDst:
+			actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_IPV4_DST, a.IP.To4()))
+		case FlowActionSetTPSrc, FlowActionSetTPDst:
+			b := make([]byte, 2)
+			binary.BigEndian.PutUint16(b, a.Value)
+			if a.Type == FlowActionSetTPSrc {
+				actions.AddAction(ofp14.NewActionSetField(src, b))
+			} else {
+				actions.AddAction(ofp14.NewActionSetField(dst, b))
+			}
+		}
+	}
+	return actions
+}
+
+// Installs rule r on Switch s, compiled for the OpenFlow version
+// s negotiated. Rules the tables of s can't hold, by its
+// capability model, are refused before anything is sent.
+func (s *OFSwitch) InstallFlowRule(r *FlowRule) error {
+	f, err := r.FlowMod(s.Version())
+	if err != nil {
+		return err
+	}
+	if fm, ok := f.(*ofp14.FlowMod); ok {
+		if err := s.ValidateFlow(flowModRequirements(fm)); err != nil {
+			return err
+		}
+	}
+	return s.Send(f)
+}
+
+// Removes rule r from Switch s.
+func (s *OFSwitch) RemoveFlowRule(r *FlowRule) error {
+	f, err := r.DeleteMod(s.Version())
+	if err != nil {
+		return err
+	}
+	return s.Send(f)
+}
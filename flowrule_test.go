@@ -0,0 +1,48 @@
+package ogo
+
+import (
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+func TestFlowRuleFlowMod(t *testing.T) {
+	rule := &FlowRule{Priority: 100, Match: FlowMatch{InPort: 1, VLAN: 10},
+		Actions: []FlowAction{SetVLAN(20), Output(2)}}
+	tables := &FlowRule{Table: 1, GotoTable: 2, Actions: []FlowAction{Output(2)}}
+	tests := []struct {
+		name    string
+		rule    *FlowRule
+		version uint8
+		ok      bool
+	}{
+		{"OpenFlow 1.0", rule, 1, true},
+		{"OpenFlow 1.1", rule, 2, false},
+		{"OpenFlow 1.3", rule, 4, true},
+		{"OpenFlow 1.4", rule, 5, true},
+		{"OpenFlow 1.5", rule, 6, true},
+		{"tables on OpenFlow 1.0", tables, 1, false},
+		{"tables on OpenFlow 1.3", tables, 4, true},
+	}
+	for _, test := range tests {
+		msg, err := test.rule.FlowMod(test.version)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v.", test.name, err)
+			continue
+		}
+		switch f := msg.(type) {
+		case *ofp10.FlowMod:
+			if test.version != ofp10.VERSION || len(f.Actions) != len(test.rule.Actions) {
+				t.Errorf("%s: got an OpenFlow 1.0 flow mod with %d actions.", test.name, len(f.Actions))
+			}
+		case *ofp14.FlowMod:
+			if f.Header.Version != test.version || f.TableId != test.rule.Table {
+				t.Errorf("%s: got version %d, table %d.", test.name, f.Header.Version, f.TableId)
+			}
+			if _, err := f.MarshalBinary(); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package ogo
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp13"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A FlowEntry is the controller's copy of a flow installed on a
+// switch, kept up to date by flow monitoring.
+type FlowEntry struct {
+	TableId      uint8
+	Priority     uint16
+	Cookie       uint64
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Match        ofp14.Match
+	Instructions []byte
+	// When the flow was installed on the controller's clock,
+	// zero if unknown. Flows added while monitored get the time
+	// their update arrived; FlowAges fills in the others.
+	Installed time.Time
+}
+
+func flowKey(table uint8, priority uint16, m *ofp14.Match) string {
+	return fmt.Sprintf("%d/%d/%s", table, priority, hex.EncodeToString(m.Fields))
+}
+
+// Subscribes to flow table changes on Switch s. Every flow
+// matching req is reported once initially and then again each
+// time it changes, whichever controller made the change. The
+// changes are applied to the flow shadow returned by Flows and
+// delivered to applications implementing
+// ofp14.FlowChangedReactor. Requires OpenFlow 1.4 or later.
+func (s *OFSwitch) MonitorFlows(req *ofp14.FlowMonitorRequest) error {
+	if s.Version() < ofp14.VERSION {
+		return errors.New("Flow monitoring requires OpenFlow 1.4 or later.")
+	}
+	// Updates for our own changes must not be abbreviated
+	// or the shadow can't be updated from them.
+	req.Flags |= ofp14.FMF_NO_ABBREV
+
+	s.flowsMu.Lock()
+	s.monitors[req.MonitorId] = req
+	s.flowsMu.Unlock()
+
+	return s.Send(s.newMultipartRequest(ofp14.MultipartType_FlowMonitor, req))
+}
+
+// Stops flow monitor id on Switch s.
+func (s *OFSwitch) StopMonitor(id uint32) {
+	s.flowsMu.Lock()
+	req, ok := s.monitors[id]
+	delete(s.monitors, id)
+	s.flowsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	del := *req
+	del.Command = ofp14.FMC_DELETE
+	s.Send(s.newMultipartRequest(ofp14.MultipartType_FlowMonitor, &del))
+}
+
+func (s *OFSwitch) newMultipartRequest(t uint16, body util.Message) *ofp14.MultipartRequest {
+	switch s.Version() {
+	case ofp13.VERSION:
+		return ofp13.NewMultipartRequest(t, body)
+	case ofp15.VERSION:
+		return ofp15.NewMultipartRequest(t, body)
+	}
+	return ofp14.NewMultipartRequest(t, body)
+}
+
+// Returns the flows known to be installed on Switch s. Only
+// switches with an active flow monitor have a flow shadow.
+func (s *OFSwitch) Flows() []FlowEntry {
+	s.flowsMu.RLock()
+	defer s.flowsMu.RUnlock()
+	a := make([]FlowEntry, 0, len(s.flows))
+	for _, f := range s.flows {
+		a = append(a, *f)
+	}
+	return a
+}
+
+// Applies flow monitor updates to the flow shadow.
+func (s *OFSwitch) updateFlows(rep *ofp14.FlowMonitorReply) {
+	resync := false
+	s.flowsMu.Lock()
+	for _, u := range rep.Updates {
+		switch t := u.(type) {
+		case *ofp14.FlowUpdateFull:
+			key := flowKey(t.TableId, t.Priority, &t.Match)
+			if t.Event == ofp14.FME_REMOVED {
+				delete(s.flows, key)
+				continue
+			}
+			f := &FlowEntry{t.TableId, t.Priority, t.Cookie,
+				t.IdleTimeout, t.HardTimeout, t.Match, t.Instructions, time.Time{}}
+			if t.Event == ofp14.FME_ADDED {
+				f.Installed = time.Now()
+			} else if old, ok := s.flows[key]; ok {
+				f.Installed = old.Installed
+			}
+			s.flows[key] = f
+		case *ofp14.FlowUpdatePaused:
+			// Changes made while paused are lost, so
+			// the shadow is rebuilt once resumed.
+			if t.Event == ofp14.FME_PAUSED {
+				log.Println("Flow monitoring paused on:", SwitchLabel(s.DPID()))
+			} else {
+				resync = true
+			}
+		}
+	}
+	s.flowsMu.Unlock()
+
+	if resync {
+		s.resyncFlows()
+	}
+}
+
+// Clears the flow shadow and asks every monitor for its
+// initial flows again.
+func (s *OFSwitch) resyncFlows() {
+	s.flowsMu.Lock()
+	s.flows = make(map[string]*FlowEntry)
+	reqs := make([]ofp14.FlowMonitorRequest, 0, len(s.monitors))
+	for _, req := range s.monitors {
+		reqs = append(reqs, *req)
+	}
+	s.flowsMu.Unlock()
+
+	for i := range reqs {
+		reqs[i].Command = ofp14.FMC_MODIFY
+		reqs[i].Flags |= ofp14.FMF_INITIAL
+		s.Send(s.newMultipartRequest(ofp14.MultipartType_FlowMonitor, &reqs[i]))
+	}
+}
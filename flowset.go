@@ -0,0 +1,632 @@
+package ogo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A FlowSet is a set of flows in a form that can be kept under
+// version control and pushed to a switch in one operation. Its
+// JSON encoding looks like
+//
+//	{"flows": [
+//		{"priority": 100, "match": {"in_port": "1", "eth_type": "0x0800",
+//			"ipv4_dst": "10.0.0.1"}, "actions": ["output:2"]},
+//		{"table": 0, "priority": 0, "actions": ["controller"]}
+//	]}
+//
+// and its text encoding, one flow per line in the syntax of
+// ovs-ofctl add-flows, like
+//
+//	priority=100,in_port=1,dl_type=0x0800,nw_dst=10.0.0.1,actions=output:2
+//	table=0,priority=0,actions=controller
+//
+// Match fields are those of FlowQuery. Actions are output:<port>,
+// with port a number, in_port, normal, flood, all, controller or
+// local, the same reserved port names on their own, and, for
+// OpenFlow 1.3+ switches, group:<id> and set_queue:<id>. A flow
+// without actions drops its packets.
+type FlowSet struct {
+	Flows []FlowSpec `json:"flows"`
+}
+
+type FlowSpec struct {
+	Table       uint8             `json:"table,omitempty"`
+	Priority    uint16            `json:"priority"`
+	Cookie      uint64            `json:"cookie,omitempty"`
+	IdleTimeout uint16            `json:"idle_timeout,omitempty"`
+	HardTimeout uint16            `json:"hard_timeout,omitempty"`
+	Match       map[string]string `json:"match,omitempty"`
+	Actions     []string          `json:"actions"`
+}
+
+// Reserved port names of flow set actions.
+var flowSetPorts = map[string]uint16{
+	"in_port":    ofp10.P_IN_PORT,
+	"normal":     ofp10.P_NORMAL,
+	"flood":      ofp10.P_FLOOD,
+	"all":        ofp10.P_ALL,
+	"controller": ofp10.P_CONTROLLER,
+	"local":      ofp10.P_LOCAL,
+}
+
+// Names of match fields in ovs-ofctl syntax.
+var ofctlFields = map[string]string{
+	"dl_src":   "eth_src",
+	"dl_dst":   "eth_dst",
+	"dl_type":  "eth_type",
+	"dl_vlan":  "vlan_vid",
+	"nw_proto": "ip_proto",
+	"nw_src":   "ipv4_src",
+	"nw_dst":   "ipv4_dst",
+}
+
+// Protocol shorthands of ovs-ofctl.
+var ofctlProtocols = map[string]map[string]string{
+	"ip":   {"eth_type": "0x0800"},
+	"arp":  {"eth_type": "0x0806"},
+	"icmp": {"eth_type": "0x0800", "ip_proto": "1"},
+	"tcp":  {"eth_type": "0x0800", "ip_proto": "6"},
+	"udp":  {"eth_type": "0x0800", "ip_proto": "17"},
+}
+
+// Decodes the JSON encoding of a FlowSet.
+func ReadFlowSet(r io.Reader) (*FlowSet, error) {
+	fs := new(FlowSet)
+	if err := json.NewDecoder(r).Decode(fs); err != nil {
+		return nil, err
+	}
+	return fs, fs.Validate()
+}
+
+// Decodes the text encoding of a FlowSet. Blank lines and lines
+// starting with # are skipped.
+func ReadFlowSetText(r io.Reader) (*FlowSet, error) {
+	fs := &FlowSet{Flows: make([]FlowSpec, 0)}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		f, err := parseFlowSpec(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		fs.Flows = append(fs.Flows, f)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return fs, fs.Validate()
+}
+
+func parseFlowSpec(text string) (FlowSpec, error) {
+	f := FlowSpec{Match: make(map[string]string)}
+	actions := ""
+	if i := strings.Index(text, "actions="); i >= 0 {
+		actions = text[i+len("actions="):]
+		text = text[:i]
+	} else {
+		return f, errors.New("missing actions")
+	}
+	for _, a := range strings.Split(actions, ",") {
+		if a = strings.TrimSpace(a); a != "" && a != "drop" {
+			f.Actions = append(f.Actions, a)
+		}
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' })
+	var err error
+	for _, kv := range fields {
+		a := strings.SplitN(kv, "=", 2)
+		if len(a) == 1 {
+			p, ok := ofctlProtocols[a[0]]
+			if !ok {
+				return f, fmt.Errorf("unknown field %q", a[0])
+			}
+			for k, v := range p {
+				f.Match[k] = v
+			}
+			continue
+		}
+		k, v := a[0], a[1]
+		switch k {
+		case "table":
+			var n uint64
+			n, err = strconv.ParseUint(v, 0, 8)
+			f.Table = uint8(n)
+		case "priority":
+			var n uint64
+			n, err = strconv.ParseUint(v, 0, 16)
+			f.Priority = uint16(n)
+		case "cookie":
+			f.Cookie, err = strconv.ParseUint(v, 0, 64)
+		case "idle_timeout":
+			var n uint64
+			n, err = strconv.ParseUint(v, 0, 16)
+			f.IdleTimeout = uint16(n)
+		case "hard_timeout":
+			var n uint64
+			n, err = strconv.ParseUint(v, 0, 16)
+			f.HardTimeout = uint16(n)
+		default:
+			if name, ok := ofctlFields[k]; ok {
+				k = name
+			}
+			f.Match[k] = v
+		}
+		if err != nil {
+			return f, fmt.Errorf("bad %s %q", k, v)
+		}
+	}
+	// Transport ports are named after the protocol.
+	for _, tp := range []string{"tp_src", "tp_dst"} {
+		if v, ok := f.Match[tp]; ok {
+			delete(f.Match, tp)
+			proto := "tcp"
+			if f.Match["ip_proto"] == "17" {
+				proto = "udp"
+			}
+			f.Match[proto+tp[2:]] = v
+		}
+	}
+	return f, nil
+}
+
+// Checks that every match field and action is known.
+func (fs *FlowSet) Validate() error {
+	for i, f := range fs.Flows {
+		if _, err := f.ofp14Match(); err != nil {
+			return fmt.Errorf("flow %d: %v", i+1, err)
+		}
+		if _, err := f.ofp14Instructions(); err != nil {
+			return fmt.Errorf("flow %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Writes the text encoding of fs to w.
+func (fs *FlowSet) WriteText(w io.Writer) error {
+	names := make(map[string]string)
+	for k, v := range ofctlFields {
+		names[v] = k
+	}
+	for _, f := range fs.Flows {
+		a := []string{fmt.Sprintf("table=%d", f.Table), fmt.Sprintf("priority=%d", f.Priority)}
+		if f.Cookie != 0 {
+			a = append(a, fmt.Sprintf("cookie=%#x", f.Cookie))
+		}
+		if f.IdleTimeout != 0 {
+			a = append(a, fmt.Sprintf("idle_timeout=%d", f.IdleTimeout))
+		}
+		if f.HardTimeout != 0 {
+			a = append(a, fmt.Sprintf("hard_timeout=%d", f.HardTimeout))
+		}
+		keys := make([]string, 0, len(f.Match))
+		for k := range f.Match {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			name := k
+			if n, ok := names[k]; ok {
+				name = n
+			}
+			a = append(a, name+"="+f.Match[k])
+		}
+		actions := "drop"
+		if len(f.Actions) > 0 {
+			actions = strings.Join(f.Actions, ",")
+		}
+		a = append(a, "actions="+actions)
+		if _, err := fmt.Fprintln(w, strings.Join(a, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses a flow set port.
+func flowSetPort(v string) (uint16, error) {
+	if p, ok := flowSetPorts[v]; ok {
+		return p, nil
+	}
+	p, err := strconv.ParseUint(v, 0, 16)
+	if err != nil || p == 0 || p > uint64(ofp10.P_MAX) {
+		return 0, fmt.Errorf("bad port %q", v)
+	}
+	return uint16(p), nil
+}
+
+// Returns the value of match field k in the network byte order
+// encoding of its OXM field.
+func flowSetValue(k, v string) ([]byte, error) {
+	size := map[string]int{"in_port": 4, "eth_type": 2, "vlan_vid": 2, "ip_proto": 1,
+		"tcp_src": 2, "tcp_dst": 2, "udp_src": 2, "udp_dst": 2}
+	switch k {
+	case "eth_src", "eth_dst":
+		mac, err := net.ParseMAC(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s %q", k, v)
+		}
+		return mac, nil
+	case "ipv4_src", "ipv4_dst":
+		ip := net.ParseIP(v).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("bad %s %q", k, v)
+		}
+		return ip, nil
+	}
+	n, ok := size[k]
+	if !ok {
+		return nil, fmt.Errorf("unknown match field %q", k)
+	}
+	x, err := strconv.ParseUint(v, 0, n*8)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s %q", k, v)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, x)
+	return b[8-n:], nil
+}
+
+func (f FlowSpec) ofp14Match() (ofp14.Match, error) {
+	m := *ofp14.NewMatch()
+	keys := make([]string, 0, len(f.Match))
+	for k := range f.Match {
+		keys = append(keys, k)
+	}
+	// In the order of the OXM fields, so prerequisites come
+	// first.
+	sort.Sort(byOXMField(keys))
+	for _, k := range keys {
+		v, err := flowSetValue(k, f.Match[k])
+		if err != nil {
+			return m, err
+		}
+		if k == "vlan_vid" {
+			// OFPVID_PRESENT
+			v[0] |= 0x10
+		}
+		m.AddField(flowFields[k], v)
+	}
+	return m, nil
+}
+
+type byOXMField []string
+
+func (a byOXMField) Len() int           { return len(a) }
+func (a byOXMField) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byOXMField) Less(i, j int) bool { return flowFields[a[i]] < flowFields[a[j]] }
+
+func (f FlowSpec) ofp14Instructions() ([]ofp14.Instruction, error) {
+	if len(f.Actions) == 0 {
+		return nil, nil
+	}
+	apply := ofp14.NewInstrApplyActions()
+	for _, a := range f.Actions {
+		kv := strings.SplitN(a, ":", 2)
+		switch {
+		case kv[0] == "output" && len(kv) == 2:
+			p, err := flowSetPort(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			apply.AddAction(ofp14.NewActionOutput(ofp14Port(p)))
+		case (kv[0] == "group" || kv[0] == "set_queue") && len(kv) == 2:
+			id, err := strconv.ParseUint(kv[1], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad action %q", a)
+			}
+			if kv[0] == "group" {
+				apply.AddAction(ofp14.NewActionGroup(uint32(id)))
+			} else {
+				apply.AddAction(ofp14.NewActionSetQueue(uint32(id)))
+			}
+		case len(kv) == 1:
+			p, ok := flowSetPorts[a]
+			if !ok {
+				return nil, fmt.Errorf("unknown action %q", a)
+			}
+			apply.AddAction(ofp14.NewActionOutput(ofp14Port(p)))
+		default:
+			return nil, fmt.Errorf("unknown action %q", a)
+		}
+	}
+	return []ofp14.Instruction{apply}, nil
+}
+
+func (f FlowSpec) ofp10() (*ofp10.FlowMod, error) {
+	fm := ofp10.NewFlowMod()
+	fm.Priority = f.Priority
+	fm.Cookie = f.Cookie
+	fm.IdleTimeout = f.IdleTimeout
+	fm.HardTimeout = f.HardTimeout
+	if f.Table != 0 {
+		return nil, errors.New("OpenFlow 1.0 switches have a single table.")
+	}
+	m := &fm.Match
+	for k, s := range f.Match {
+		v, err := flowSetValue(k, s)
+		if err != nil {
+			return nil, err
+		}
+		switch k {
+		case "in_port":
+			m.InPort = uint16(binary.BigEndian.Uint32(v))
+			m.Wildcards &^= ofp10.FW_IN_PORT
+		case "eth_src":
+			copy(m.DLSrc, v)
+			m.Wildcards &^= ofp10.FW_DL_SRC
+		case "eth_dst":
+			copy(m.DLDst, v)
+			m.Wildcards &^= ofp10.FW_DL_DST
+		case "eth_type":
+			m.DLType = binary.BigEndian.Uint16(v)
+			m.Wildcards &^= ofp10.FW_DL_TYPE
+		case "vlan_vid":
+			m.DLVLAN = binary.BigEndian.Uint16(v)
+			m.Wildcards &^= ofp10.FW_DL_VLAN
+		case "ip_proto":
+			m.NWProto = v[0]
+			m.Wildcards &^= ofp10.FW_NW_PROTO
+		case "ipv4_src":
+			copy(m.NWSrc, v)
+			m.Wildcards &^= ofp10.FW_NW_SRC_MASK
+		case "ipv4_dst":
+			copy(m.NWDst, v)
+			m.Wildcards &^= ofp10.FW_NW_DST_MASK
+		case "tcp_src", "udp_src":
+			m.TPSrc = binary.BigEndian.Uint16(v)
+			m.Wildcards &^= ofp10.FW_TP_SRC
+		case "tcp_dst", "udp_dst":
+			m.TPDst = binary.BigEndian.Uint16(v)
+			m.Wildcards &^= ofp10.FW_TP_DST
+		}
+	}
+	for _, a := range f.Actions {
+		kv := strings.SplitN(a, ":", 2)
+		port := kv[0]
+		if kv[0] == "output" && len(kv) == 2 {
+			port = kv[1]
+		}
+		p, err := flowSetPort(port)
+		if err != nil {
+			return nil, fmt.Errorf("action %q is not supported by OpenFlow 1.0 switches", a)
+		}
+		fm.AddAction(ofp10.NewActionOutput(p))
+	}
+	return fm, nil
+}
+
+// Returns a flow mod adding f to Switch s.
+func (s *OFSwitch) flowSpecMod(f FlowSpec) (util.Message, error) {
+	if s.Version() == ofp10.VERSION {
+		return f.ofp10()
+	}
+	fm := ofp14.NewFlowMod()
+	fm.Header.Version = s.Version()
+	fm.TableId = f.Table
+	fm.Priority = f.Priority
+	fm.Cookie = f.Cookie
+	fm.IdleTimeout = f.IdleTimeout
+	fm.HardTimeout = f.HardTimeout
+	var err error
+	if fm.Match, err = f.ofp14Match(); err != nil {
+		return nil, err
+	}
+	instrs, err := f.ofp14Instructions()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range instrs {
+		fm.AddInstruction(i)
+	}
+	return fm, nil
+}
+
+// Adds every flow of fs to Switch s in a single bundle, so on
+// OpenFlow 1.4+ switches either all of them are added or none.
+func (s *OFSwitch) ImportFlows(fs *FlowSet) error {
+	msgs := make([]util.Message, len(fs.Flows))
+	for i, f := range fs.Flows {
+		m, err := s.flowSpecMod(f)
+		if err != nil {
+			return fmt.Errorf("flow %d: %v", i+1, err)
+		}
+		msgs[i] = m
+	}
+	b, err := s.OpenBundle()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if err := s.AddToBundle(b, m); err != nil {
+			s.DiscardBundle(b)
+			return err
+		}
+	}
+	return s.CommitBundle(b)
+}
+
+// Returns the flows in the flow shadow of Switch s, which needs
+// an active flow monitor.
+func (s *OFSwitch) ExportFlows() (*FlowSet, error) {
+	if s.Version() < ofp14.VERSION {
+		return nil, errors.New("Flows can only be exported from switches with a flow shadow.")
+	}
+	flows := s.Flows()
+	sort.Sort(flowEntries(flows))
+	fs := &FlowSet{Flows: make([]FlowSpec, 0, len(flows))}
+	for _, e := range flows {
+		f, err := flowSpec(e)
+		if err != nil {
+			return nil, err
+		}
+		fs.Flows = append(fs.Flows, f)
+	}
+	return fs, nil
+}
+
+func flowSpec(e FlowEntry) (FlowSpec, error) {
+	f := FlowSpec{Table: e.TableId, Priority: e.Priority, Cookie: e.Cookie,
+		IdleTimeout: e.IdleTimeout, HardTimeout: e.HardTimeout, Match: make(map[string]string)}
+	for name, field := range flowFields {
+		v, ok := e.Match.Field(field)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "eth_src", "eth_dst":
+			f.Match[name] = net.HardwareAddr(v).String()
+		case "ipv4_src", "ipv4_dst":
+			f.Match[name] = net.IP(v).String()
+		case "eth_type":
+			f.Match[name] = fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(v))
+		case "vlan_vid":
+			f.Match[name] = strconv.Itoa(int(binary.BigEndian.Uint16(v) & 0xfff))
+		default:
+			var x uint64
+			for _, b := range v {
+				x = x<<8 | uint64(b)
+			}
+			f.Match[name] = strconv.FormatUint(x, 10)
+		}
+	}
+	instrs, err := ofp14.DecodeInstructions(e.Instructions)
+	if err != nil {
+		return f, err
+	}
+	names := make(map[uint32]string)
+	for name, p := range flowSetPorts {
+		names[ofp14Port(p)] = name
+	}
+	for _, i := range instrs {
+		apply, ok := i.(*ofp14.InstrActions)
+		if !ok || apply.Type != ofp14.IT_APPLY_ACTIONS {
+			return f, fmt.Errorf("Instruction type %d can't be exported.", i.InstructionType())
+		}
+		for _, a := range apply.Actions {
+			switch t := a.(type) {
+			case *ofp14.ActionOutput:
+				if name, ok := names[t.Port]; ok {
+					f.Actions = append(f.Actions, name)
+				} else {
+					f.Actions = append(f.Actions, fmt.Sprintf("output:%d", t.Port))
+				}
+			case *ofp14.ActionId:
+				if t.Type == ofp14.AT_GROUP {
+					f.Actions = append(f.Actions, fmt.Sprintf("group:%d", t.Id))
+				} else {
+					f.Actions = append(f.Actions, fmt.Sprintf("set_queue:%d", t.Id))
+				}
+			default:
+				return f, fmt.Errorf("Action type %d can't be exported.", a.ActionType())
+			}
+		}
+	}
+	return f, nil
+}
+
+type flowEntries []FlowEntry
+
+func (a flowEntries) Len() int      { return len(a) }
+func (a flowEntries) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a flowEntries) Less(i, j int) bool {
+	if a[i].TableId != a[j].TableId {
+		return a[i].TableId < a[j].TableId
+	}
+	if a[i].Priority != a[j].Priority {
+		return a[i].Priority > a[j].Priority
+	}
+	return flowKey(a[i].TableId, a[i].Priority, &a[i].Match) < flowKey(a[j].TableId, a[j].Priority, &a[j].Match)
+}
+
+// Names the interlock operation of a request to ServeFlowSet.
+// Dry runs change nothing.
+func flowSetOp(r *http.Request) string {
+	switch {
+	case r.Method == "PUT":
+		return OpImportFlows
+	case r.Method == "POST" && r.URL.Query().Get("dry_run") == "":
+		return OpSyncFlows
+	}
+	return ""
+}
+
+// Serves the flow set of the switch given by the dpid
+// parameter: GET exports it, PUT imports the body and POST
+// synchronizes the switch to the body, replying with the
+// FlowDiff applied, or only computed if the dry_run parameter is
+// set. Flow sets are JSON, or text if the format parameter is
+// "text".
+func ServeFlowSet(w http.ResponseWriter, r *http.Request) {
+	dpid, err := net.ParseMAC(r.URL.Query().Get("dpid"))
+	if err != nil {
+		http.Error(w, "bad dpid", http.StatusBadRequest)
+		return
+	}
+	sw, ok := Switch(dpid)
+	if !ok {
+		http.Error(w, ErrSwitchDisconnected.Error(), http.StatusNotFound)
+		return
+	}
+	text := r.URL.Query().Get("format") == "text"
+	switch r.Method {
+	case "GET":
+		fs, err := sw.ExportFlows()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if text {
+			w.Header().Set("Content-Type", "text/plain")
+			fs.WriteText(w)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fs)
+		}
+	case "PUT", "POST":
+		var fs *FlowSet
+		if text {
+			fs, err = ReadFlowSetText(r.Body)
+		} else {
+			fs, err = ReadFlowSet(r.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			d, err := sw.SyncFlows(fs, r.URL.Query().Get("dry_run") != "")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(d)
+			return
+		}
+		if err = sw.ImportFlows(fs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,481 @@
+package ogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How a switch handles IP fragments, the OFPC_FRAG_* flags of
+// its configuration. In FragmentNormal mode fragments go through
+// the flow tables like any packet, but their transport ports
+// read as zero, so flows matching ports only see first fragments
+// of TCP and UDP. FragmentReassemble is optional for switches.
+type FragmentMode uint16
+
+const (
+	FragmentNormal     FragmentMode = ofp10.C_FRAG_NORMAL
+	FragmentDrop       FragmentMode = ofp10.C_FRAG_DROP
+	FragmentReassemble FragmentMode = ofp10.C_FRAG_REASM
+)
+
+var fragmentModes = map[FragmentMode]string{
+	FragmentNormal:     "normal",
+	FragmentDrop:       "drop",
+	FragmentReassemble: "reassemble",
+}
+
+func (m FragmentMode) String() string {
+	if s, ok := fragmentModes[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("FragmentMode(%d)", uint16(m))
+}
+
+// Parses a fragment mode name: normal, drop or reassemble.
+func ParseFragmentMode(s string) (FragmentMode, error) {
+	for m, name := range fragmentModes {
+		if name == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown fragment mode %q.", s)
+}
+
+func (m FragmentMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *FragmentMode) UnmarshalText(b []byte) error {
+	mode, err := ParseFragmentMode(string(b))
+	if err == nil {
+		*m = mode
+	}
+	return err
+}
+
+// How long to wait for a switch to send its configuration.
+var ConfigTimeout = time.Second * 2
+
+// The configuration of a switch, independent of its OpenFlow
+// version.
+type SwitchConfig struct {
+	Fragments   FragmentMode `json:"fragments"`
+	MissSendLen uint16       `json:"miss_send_len"`
+}
+
+// Reads the configuration of Switch s.
+func (s *OFSwitch) SwitchConfig(timeout time.Duration) (SwitchConfig, error) {
+	var req util.Message
+	switch s.Version() {
+	case ofp10.VERSION:
+		req = ofp10.NewConfigRequest()
+	case ofp13.VERSION, ofp14.VERSION, ofp15.VERSION:
+		h := ofp14.NewConfigRequest()
+		h.Version = s.Version()
+		req = h
+	default:
+		return SwitchConfig{}, errors.New("Switch configuration isn't supported for this OpenFlow version.")
+	}
+	rep, err := s.SendAndReceive(req, timeout)
+	if err != nil {
+		return SwitchConfig{}, err
+	}
+	switch c := rep.(type) {
+	case *ofp10.SwitchConfig:
+		return SwitchConfig{FragmentMode(c.Flags & ofp10.C_FRAG_MASK), c.MissSendLen}, nil
+	case *ofp14.SwitchConfig:
+		return SwitchConfig{FragmentMode(c.Flags & ofp14.C_FRAG_MASK), c.MissSendLen}, nil
+	}
+	return SwitchConfig{}, errors.New("Switch sent no configuration.")
+}
+
+// Replaces the configuration of Switch s.
+func (s *OFSwitch) SetSwitchConfig(c SwitchConfig) error {
+	if _, ok := fragmentModes[c.Fragments]; !ok {
+		return fmt.Errorf("Unknown fragment mode %d.", uint16(c.Fragments))
+	}
+	switch s.Version() {
+	case ofp10.VERSION:
+		m := ofp10.NewSetConfig()
+		m.Flags = uint16(c.Fragments)
+		m.MissSendLen = c.MissSendLen
+		return s.Send(m)
+	case ofp13.VERSION, ofp14.VERSION, ofp15.VERSION:
+		m := ofp14.NewSetConfig()
+		m.Header.Version = s.Version()
+		m.Flags = uint16(c.Fragments)
+		m.MissSendLen = c.MissSendLen
+		return s.Send(m)
+	}
+	return errors.New("Switch configuration isn't supported for this OpenFlow version.")
+}
+
+// Sets how Switch s handles IP fragments, keeping the rest of
+// its configuration.
+func (s *OFSwitch) SetFragmentMode(mode FragmentMode) error {
+	c, err := s.SwitchConfig(ConfigTimeout)
+	if err != nil {
+		return err
+	}
+	c.Fragments = mode
+	return s.SetSwitchConfig(c)
+}
+
+// Returns m restricted to IP fragments after the first, as seen
+// by a switch in FragmentNormal mode: m.IPProto must be TCP or
+// UDP, and the returned match requires both transport ports to
+// be zero. Unfragmented packets with both ports zero match too.
+// Non-first fragments carry no transport header, so a rule
+// steering a flow by its ports needs a companion rule with this
+// match, or the rest of its fragmented packets take another path.
+func LaterFragments(m FlowMatch) (FlowMatch, error) {
+	if m.IPProto != ipv4.Type_TCP && m.IPProto != ipv4.Type_UDP {
+		return m, errors.New("Matching later fragments requires TCP or UDP.")
+	}
+	m.laterFragments = true
+	return m, nil
+}
+
+// Number of bytes a Reassembler keeps at most per packet.
+const maxReassembly = 65535
+
+// A Reassembler reassembles the IPv4 fragments sent to the
+// controller, so that applications needing transport headers
+// can classify fragmented traffic. It consumes the fragments it
+// holds; once the last one arrives the whole packet goes through
+// the packet-in chain again and to the applications, as a
+// packet-in of its first fragment without a buffer. Handlers
+// ahead of the Reassembler see the fragments too. Packets whose
+// fragments don't all arrive within Timeout are forgotten.
+//
+// A fragment that overlaps another one of its packet, ends past
+// the end given by the last fragment or is a second last fragment
+// is dropped together with the rest of its packet, as overlapping
+// fragments are used to slip packets past filters. Exact
+// duplicates are ignored.
+//
+// Fragments truncated by the miss send length can't be
+// reassembled and are passed on as they are, so switches should
+// send whole packets, see DefaultRulesConfig.MissSendLen.
+type Reassembler struct {
+	Timeout time.Duration
+	// Most packets being reassembled at once.
+	MaxPending int
+
+	c       *Controller
+	mu      sync.Mutex
+	pending map[fragmentKey]*fragments
+	swept   time.Time
+
+	reassembled uint64
+	expired     uint64
+	truncated   uint64
+	rejected    uint64
+}
+
+type fragmentKey struct {
+	dpid     string
+	src, dst [4]byte
+	id       uint16
+	proto    uint8
+}
+
+type fragments struct {
+	first   *ofp10.PacketIn
+	parts   []fragment
+	size    int // Payload length, known once the last fragment arrives.
+	started time.Time
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+func NewReassembler() *Reassembler {
+	r := new(Reassembler)
+	r.Timeout = time.Second * 5
+	r.MaxPending = 1024
+	r.pending = make(map[fragmentKey]*fragments)
+	return r
+}
+
+// Starts reassembling fragments, after the security handlers
+// and ahead of the others.
+func (r *Reassembler) Attach(c *Controller) {
+	r.c = c
+	c.AddPacketInHandler("reassembler", 1<<29-1, r)
+}
+
+func (r *Reassembler) Stop() {
+	r.c.RemovePacketInHandler("reassembler")
+	r.mu.Lock()
+	r.pending = make(map[fragmentKey]*fragments)
+	r.mu.Unlock()
+}
+
+// Returns the number of packets reassembled, given up on after
+// Timeout, fragments passed on because they were truncated and
+// packets dropped for fragments that don't fit together.
+func (r *Reassembler) Stats() (reassembled, expired, truncated, rejected uint64) {
+	return atomic.LoadUint64(&r.reassembled), atomic.LoadUint64(&r.expired),
+		atomic.LoadUint64(&r.truncated), atomic.LoadUint64(&r.rejected)
+}
+
+func (r *Reassembler) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok || !ip.IsFragment() {
+		return false
+	}
+	if int(pkt.TotalLen) > int(pkt.Data.Len()) {
+		atomic.AddUint64(&r.truncated, 1)
+		return false
+	}
+	payload, err := fragmentPayload(ip)
+	if err != nil {
+		return false
+	}
+	whole, held := r.add(dpid, pkt, ip, payload)
+	if whole == nil {
+		return held
+	}
+	atomic.AddUint64(&r.reassembled, 1)
+	if sw, ok := Switch(dpid); ok {
+		sw.distributeMessages(dpid, whole, noopSpan{})
+	}
+	return true
+}
+
+// Returns the payload of ip, without the Ethernet padding of
+// short frames.
+func fragmentPayload(ip *ipv4.IPv4) ([]byte, error) {
+	b, err := ip.Data.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	n := int(ip.Length) - int(ip.IHL)*4
+	if n < 0 || n > len(b) {
+		return nil, errors.New("Bad IPv4 length.")
+	}
+	return b[:n], nil
+}
+
+// Adds a fragment, returning the reassembled packet-in once
+// every fragment arrived, or whether the fragment is held until
+// then.
+func (r *Reassembler) add(dpid net.HardwareAddr, pkt *ofp10.PacketIn, ip *ipv4.IPv4, payload []byte) (*ofp10.PacketIn, bool) {
+	k := fragmentKey{dpid: dpid.String(), id: ip.Id, proto: ip.Protocol}
+	copy(k.src[:], ip.NWSrc.To4())
+	copy(k.dst[:], ip.NWDst.To4())
+	offset := int(ip.FragmentOffset) * 8
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.swept) > r.Timeout/2 {
+		r.sweep(now)
+	}
+	f, ok := r.pending[k]
+	if !ok {
+		if len(r.pending) >= r.MaxPending {
+			return nil, false
+		}
+		f = &fragments{started: now}
+		r.pending[k] = f
+	}
+	if offset+len(payload) > maxReassembly {
+		delete(r.pending, k)
+		return nil, false
+	}
+	last := ip.Flags&ipv4.Flag_MF == 0
+	switch f.fit(offset, payload, last) {
+	case fragmentDuplicate:
+		return nil, true
+	case fragmentRejected:
+		delete(r.pending, k)
+		atomic.AddUint64(&r.rejected, 1)
+		return nil, true
+	}
+	f.parts = append(f.parts, fragment{offset, payload})
+	if offset == 0 {
+		f.first = pkt
+	}
+	if last {
+		f.size = offset + len(payload)
+	}
+	data, ok := f.assemble()
+	if !ok {
+		return nil, true
+	}
+	delete(r.pending, k)
+	return reassembledPacketIn(f.first, data), true
+}
+
+const (
+	fragmentFits = iota
+	fragmentDuplicate
+	fragmentRejected
+)
+
+// Tells whether the part data at offset, the last one of the
+// packet if last, fits with the parts that arrived, is an exact
+// duplicate of one of them or can't belong to the same packet.
+func (f *fragments) fit(offset int, data []byte, last bool) int {
+	end := offset + len(data)
+	for _, p := range f.parts {
+		if p.offset == offset && bytes.Equal(p.data, data) && (!last || f.size == end) {
+			return fragmentDuplicate
+		}
+	}
+	// Only the last part may have a length that isn't a
+	// multiple of 8.
+	if !last && (len(data) == 0 || len(data)%8 != 0) {
+		return fragmentRejected
+	}
+	if last && f.size != 0 {
+		return fragmentRejected
+	}
+	if f.size != 0 && end > f.size {
+		return fragmentRejected
+	}
+	for _, p := range f.parts {
+		if last && p.offset+len(p.data) > end {
+			return fragmentRejected
+		}
+		if offset < p.offset+len(p.data) && p.offset < end {
+			return fragmentRejected
+		}
+	}
+	return fragmentFits
+}
+
+// Returns the payload of the whole packet, if every part of it
+// arrived.
+func (f *fragments) assemble() ([]byte, bool) {
+	if f.first == nil || f.size == 0 {
+		return nil, false
+	}
+	sort.Sort(byOffset(f.parts))
+	end := 0
+	for _, p := range f.parts {
+		if p.offset > end {
+			return nil, false
+		}
+		if p.offset+len(p.data) > end {
+			end = p.offset + len(p.data)
+		}
+	}
+	if end < f.size {
+		return nil, false
+	}
+	data := make([]byte, f.size)
+	for _, p := range f.parts {
+		copy(data[p.offset:], p.data)
+	}
+	return data, true
+}
+
+func (r *Reassembler) sweep(now time.Time) {
+	r.swept = now
+	for k, f := range r.pending {
+		if now.Sub(f.started) > r.Timeout {
+			delete(r.pending, k)
+			atomic.AddUint64(&r.expired, 1)
+		}
+	}
+}
+
+// Builds a packet-in like first carrying the whole packet.
+func reassembledPacketIn(first *ofp10.PacketIn, payload []byte) *ofp10.PacketIn {
+	ip := *first.Data.Data.(*ipv4.IPv4)
+	ip.Flags &^= ipv4.Flag_MF
+	ip.FragmentOffset = 0
+	ip.Options = *new(util.Buffer)
+	ip.Data = util.NewBuffer(payload)
+	ip.Length = ip.Len()
+	ip.Checksum = 0
+	b, err := ip.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	ip.Checksum = util.Checksum(b[:20])
+	b, _ = ip.MarshalBinary()
+	whole := ipv4.New()
+	if err := whole.UnmarshalBinary(b); err != nil {
+		return nil
+	}
+
+	pkt := *first
+	pkt.BufferId = 0xffffffff
+	pkt.Data.Data = whole
+	pkt.TotalLen = pkt.Data.Len()
+	return &pkt
+}
+
+type byOffset []fragment
+
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byOffset) Less(i, j int) bool { return a[i].offset < a[j].offset }
+
+// Names the interlock operation of a request to
+// serveSwitchConfig.
+func switchConfigOp(r *http.Request) string {
+	if r.Method == "PUT" {
+		return OpSetConfig
+	}
+	return ""
+}
+
+// Serves the configuration of the switch given by the dpid
+// parameter: GET returns it and PUT replaces it with the body.
+func serveSwitchConfig(w http.ResponseWriter, r *http.Request) {
+	dpid, err := net.ParseMAC(r.URL.Query().Get("dpid"))
+	if err != nil {
+		http.Error(w, "bad dpid", http.StatusBadRequest)
+		return
+	}
+	sw, ok := Switch(dpid)
+	if !ok {
+		http.Error(w, ErrSwitchDisconnected.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		c, err := sw.SwitchConfig(ConfigTimeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	case "PUT":
+		var c SwitchConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sw.SetSwitchConfig(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+type testFragment struct {
+	offset int // In bytes, a multiple of 8
+	data   string
+	last   bool
+}
+
+// Builds a packet-in of one fragment of packet 1 from 10.0.0.1 to
+// 10.0.0.2.
+func fragmentPacketIn(f testFragment) (*ofp10.PacketIn, *ipv4.IPv4) {
+	ip := ipv4.New()
+	ip.Version = 4
+	ip.IHL = 5
+	ip.TTL = 64
+	ip.Protocol = 253 // Experimental, so the payload stays raw
+	ip.Id = 1
+	copy(ip.NWSrc, net.IPv4(10, 0, 0, 1).To4())
+	copy(ip.NWDst, net.IPv4(10, 0, 0, 2).To4())
+	ip.FragmentOffset = uint16(f.offset / 8)
+	if !f.last {
+		ip.Flags = ipv4.Flag_MF
+	}
+	ip.Data = util.NewBuffer([]byte(f.data))
+	ip.Length = ip.Len()
+	pkt := ofp10.NewPacketIn()
+	pkt.Data.Data = ip
+	return pkt, ip
+}
+
+func TestReassemble(t *testing.T) {
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	tests := []struct {
+		name  string
+		parts []testFragment
+		whole string // The reassembled payload, if any
+		// Packets dropped for fragments that don't fit.
+		rejected uint64
+	}{
+		{"in order", []testFragment{
+			{0, "aaaaaaaa", false}, {8, "bbbbbbbb", false}, {16, "cc", true}},
+			"aaaaaaaabbbbbbbbcc", 0},
+		{"out of order", []testFragment{
+			{16, "cc", true}, {0, "aaaaaaaa", false}, {8, "bbbbbbbb", false}},
+			"aaaaaaaabbbbbbbbcc", 0},
+		{"missing part", []testFragment{
+			{0, "aaaaaaaa", false}, {16, "cc", true}},
+			"", 0},
+		{"duplicate", []testFragment{
+			{0, "aaaaaaaa", false}, {0, "aaaaaaaa", false}, {8, "bb", true}, {8, "bb", true}},
+			"aaaaaaaabb", 0},
+		{"duplicate offset, other data", []testFragment{
+			{0, "aaaaaaaa", false}, {0, "xxxxxxxx", false}, {8, "bb", true}},
+			"", 1},
+		{"overlap", []testFragment{
+			{0, "aaaaaaaaaaaaaaaa", false}, {8, "xxxxxxxx", false}, {16, "cc", true}},
+			"", 1},
+		{"overlap of the last part", []testFragment{
+			{0, "aaaaaaaa", false}, {8, "bbbbbbbb", false}, {0, "xxxxxxxxxx", true}},
+			"", 1},
+		{"second last part", []testFragment{
+			{16, "cc", true}, {8, "bb", true}, {0, "aaaaaaaa", false}},
+			"", 1},
+		{"past the end", []testFragment{
+			{8, "bb", true}, {16, "cccccccc", false}, {0, "aaaaaaaa", false}},
+			"", 1},
+		{"short middle part", []testFragment{
+			{0, "aaaa", false}, {8, "bb", true}},
+			"", 1},
+	}
+	for _, test := range tests {
+		r := NewReassembler()
+		var whole *ofp10.PacketIn
+		for _, f := range test.parts {
+			pkt, ip := fragmentPacketIn(f)
+			payload, err := fragmentPayload(ip)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if w, _ := r.add(dpid, pkt, ip, payload); w != nil {
+				if whole != nil {
+					t.Errorf("%s: reassembled the packet twice.", test.name)
+				}
+				whole = w
+			}
+		}
+		got := ""
+		if whole != nil {
+			ip := whole.Data.Data.(*ipv4.IPv4)
+			b, _ := ip.Data.MarshalBinary()
+			got = string(b)
+			if ip.Flags&ipv4.Flag_MF != 0 || ip.FragmentOffset != 0 {
+				t.Errorf("%s: reassembled packet is still a fragment.", test.name)
+			}
+		}
+		if got != test.whole {
+			t.Errorf("%s: reassembled %q, expected %q.", test.name, got, test.whole)
+		}
+		if _, _, _, rejected := r.Stats(); rejected != test.rejected {
+			t.Errorf("%s: rejected %d packets, expected %d.", test.name, rejected, test.rejected)
+		}
+	}
+}
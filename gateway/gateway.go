@@ -0,0 +1,156 @@
+// Package gateway makes the controller answer for virtual
+// gateway addresses. ARP requests for a gateway address are
+// answered with the gateway MAC and ICMP echo requests to it are
+// answered with echo replies, both sent as packet-outs, so hosts
+// can resolve and ping their default gateway in routed
+// topologies.
+package gateway
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/arp"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/icmp"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// ICMP types.
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// A Responder answers ARP and ICMP echo requests for its gateway
+// addresses.
+type Responder struct {
+	// The MAC address gateways answer with.
+	MAC      net.HardwareAddr
+	mu       sync.RWMutex
+	gateways map[string]bool
+}
+
+func NewResponder(mac net.HardwareAddr) *Responder {
+	r := new(Responder)
+	r.MAC = mac
+	r.gateways = make(map[string]bool)
+	return r
+}
+
+// Starts answering packet-ins from switches connected to c.
+func (r *Responder) Attach(c *ogo.Controller) {
+	c.AddPacketInHandler("gateway", 1<<24, r)
+}
+
+func (r *Responder) AddGateway(ip net.IP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gateways[ip.To4().String()] = true
+}
+
+func (r *Responder) RemoveGateway(ip net.IP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gateways, ip.To4().String())
+}
+
+func (r *Responder) isGateway(ip net.IP) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gateways[ip.To4().String()]
+}
+
+// Answers requests for gateway addresses and consumes them.
+func (r *Responder) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	var reply *eth.Ethernet
+	switch pkt.Data.Ethertype {
+	case eth.ARP_MSG:
+		req, ok := pkt.Data.Data.(*arp.ARP)
+		if !ok || req.Operation != arp.Type_Request || !r.isGateway(req.IPDst) {
+			return false
+		}
+		reply = r.arpReply(&pkt.Data, req)
+	case eth.IPv4_MSG:
+		ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+		if !ok || ip.Protocol != ipv4.Type_ICMP || !r.isGateway(ip.NWDst) {
+			return false
+		}
+		req, ok := ip.Data.(*icmp.ICMP)
+		if !ok || req.Type != icmpEchoRequest {
+			// Other traffic to the gateway is dropped.
+			return true
+		}
+		reply = r.echoReply(&pkt.Data, ip, req)
+	default:
+		return false
+	}
+
+	if sw, ok := ogo.Switch(dpid); ok {
+		out := ofp10.NewPacketOut()
+		out.Data = reply
+		out.AddAction(ofp10.NewActionOutput(pkt.InPort))
+		sw.Send(out)
+	}
+	return true
+}
+
+func (r *Responder) arpReply(req *eth.Ethernet, a *arp.ARP) *eth.Ethernet {
+	rep, _ := arp.New(arp.Type_Reply)
+	copy(rep.HWSrc, r.MAC)
+	copy(rep.IPSrc, a.IPDst.To4())
+	copy(rep.HWDst, a.HWSrc)
+	copy(rep.IPDst, a.IPSrc.To4())
+
+	e := eth.New()
+	copy(e.HWSrc, r.MAC)
+	copy(e.HWDst, req.HWSrc)
+	e.Ethertype = eth.ARP_MSG
+	e.Data = rep
+	return e
+}
+
+func (r *Responder) echoReply(req *eth.Ethernet, ip *ipv4.IPv4, ping *icmp.ICMP) *eth.Ethernet {
+	pong := icmp.New()
+	pong.Type = icmpEchoReply
+	// The identifier, sequence number and payload are echoed.
+	pong.Data = ping.Data
+	data, _ := pong.MarshalBinary()
+	pong.Checksum = checksum(data)
+
+	rep := ipv4.New()
+	rep.Version = 4
+	rep.TTL = 64
+	rep.Protocol = ipv4.Type_ICMP
+	copy(rep.NWSrc, ip.NWDst.To4())
+	copy(rep.NWDst, ip.NWSrc.To4())
+	rep.Data = pong
+	rep.Length = rep.Len()
+	data, _ = rep.MarshalBinary()
+	rep.Checksum = checksum(data[:20])
+
+	e := eth.New()
+	copy(e.HWSrc, r.MAC)
+	copy(e.HWDst, req.HWSrc)
+	e.Ethertype = eth.IPv4_MSG
+	e.Data = rep
+	return e
+}
+
+// Returns the internet checksum of data, RFC 1071.
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
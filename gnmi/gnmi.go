@@ -0,0 +1,410 @@
+// Package gnmi serves the state of the controller as gNMI
+// streaming telemetry, so collectors can subscribe to port
+// counters, port and link status, and flow statistics instead of
+// polling the REST API.
+//
+// gRPC and the gNMI protocol buffers are implemented here on top
+// of net/http, to avoid depending on the gRPC packages. The
+// Capabilities, Get and Subscribe RPCs are supported, Set is not.
+// Subscriptions may be ONCE, POLL or STREAM; streamed ON_CHANGE
+// subscriptions are sampled every OnChangeInterval and only send
+// changed values. Values are sent as typed scalars whatever
+// encoding is asked for, and compressed messages are refused.
+//
+// Paths have the origin "ogo" and start with the switch:
+//
+//	/switches/switch[dpid=...]/ports/port[number=...]/state/{name,oper-status,admin-status}
+//	/switches/switch[dpid=...]/ports/port[number=...]/state/counters/{in,out}-{pkts,octets,discards,errors}
+//	/switches/switch[dpid=...]/links/link[port=...]/state/{peer-dpid,latency,indirect}
+//	/switches/switch[dpid=...]/flows/state/{count,packets,bytes}
+//
+// Counters and flow statistics are polled from OpenFlow 1.0
+// switches only. Links are reported by the switch receiving the
+// probes, on the port they arrive at; a link going down is sent
+// to streamed subscriptions as a delete of its paths.
+package gnmi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gNMI version implemented, and the model of the paths.
+const (
+	Version      = "0.7.0"
+	Model        = "ogo-telemetry"
+	ModelVersion = "1.0.0"
+)
+
+// The origin of the paths of this server.
+const Origin = "ogo"
+
+// Sampling intervals of streamed subscriptions. Subscriptions
+// asking for a shorter interval than MinSampleInterval are
+// refused.
+var (
+	DefaultSampleInterval = time.Second * 10
+	MinSampleInterval     = time.Second
+	OnChangeInterval      = time.Second * 2
+)
+
+// The longest request message accepted.
+const maxRequestLength = 1 << 20
+
+// gRPC status codes.
+const (
+	codeOK              = 0
+	codeInvalidArgument = 3
+	codeUnimplemented   = 12
+	codeInternal        = 13
+)
+
+// A gRPC error with its status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &statusError{codeInvalidArgument, fmt.Sprintf(format, a...)}
+}
+
+// A gNMI server. It is an http.Handler, so it can also be served
+// by an http.Server configured by the caller, for example with
+// client certificates. gRPC needs HTTP/2, which net/http only
+// speaks over TLS unless configured otherwise, as ListenAndServe
+// does.
+type Server struct {
+	// Returns the current state, one notification per switch,
+	// with paths relative to the prefix of the notification.
+	// ControllerState if nil.
+	State func() []*Notification
+}
+
+func NewServer() *Server {
+	return &Server{State: ControllerState}
+}
+
+// Serves gNMI on addr without TLS, as collectors do when told to
+// connect insecurely.
+func (s *Server) ListenAndServe(addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s, Protocols: new(http.Protocols)}
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv.ListenAndServe()
+}
+
+// Serves gNMI on addr over TLS.
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+func (s *Server) state() []*Notification {
+	if s.State == nil {
+		return ControllerState()
+	}
+	return s.State()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gNMI needs gRPC over HTTP/2", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	st := &stream{w: w, r: r.Body}
+	var err error
+	switch r.URL.Path {
+	case "/gnmi.gNMI/Capabilities":
+		if _, err = st.read(); err == nil {
+			err = st.write(capabilityResponse())
+		}
+	case "/gnmi.gNMI/Get":
+		err = s.get(st)
+	case "/gnmi.gNMI/Subscribe":
+		err = s.subscribe(st, r.Context().Done())
+	default:
+		err = &statusError{codeUnimplemented, "Unsupported RPC " + r.URL.Path + "."}
+	}
+	code, msg := codeOK, ""
+	if e, ok := err.(*statusError); ok {
+		code, msg = e.code, e.msg
+	} else if err != nil {
+		code, msg = codeInternal, err.Error()
+	}
+	if err != nil {
+		log.Println("gNMI", r.URL.Path, "failed:", err)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// The messages of one RPC, framed as gRPC messages.
+type stream struct {
+	r  io.Reader
+	mu sync.Mutex
+	w  http.ResponseWriter
+}
+
+// Reads the next request message.
+func (st *stream) read() ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(st.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, &statusError{codeUnimplemented, "Compressed messages are not supported."}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxRequestLength {
+		return nil, invalid("Request of %d bytes is too long.", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(st.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Sends a response message.
+func (st *stream) write(b []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(b)))
+	if _, err := st.w.Write(append(hdr[:], b...)); err != nil {
+		return err
+	}
+	if f, ok := st.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *Server) get(st *stream) error {
+	b, err := st.read()
+	if err != nil {
+		return err
+	}
+	var req getRequest
+	if err := req.unmarshal(b); err != nil {
+		return invalid("%v", err)
+	}
+	paths := make([]Path, 0)
+	for _, p := range req.paths {
+		paths = append(paths, req.prefix.join(p))
+	}
+	if len(paths) == 0 {
+		paths = append(paths, req.prefix)
+	}
+	ns := selectUpdates(s.state(), paths)
+	return st.write(getResponse(ns))
+}
+
+// Returns the updates of ns selected by any of paths, dropping
+// notifications left without updates.
+func selectUpdates(ns []*Notification, paths []Path) []*Notification {
+	selected := make([]*Notification, 0)
+	for _, n := range ns {
+		m := &Notification{Timestamp: n.Timestamp, Prefix: n.Prefix}
+		for _, u := range n.Update {
+			full := n.Prefix.join(u.Path)
+			for _, p := range paths {
+				if (p.Origin == "" || p.Origin == full.Origin) && p.Matches(full) {
+					m.Update = append(m.Update, u)
+					break
+				}
+			}
+		}
+		if len(m.Update) > 0 {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+func (s *Server) subscribe(st *stream, done <-chan struct{}) error {
+	b, err := st.read()
+	if err != nil {
+		return err
+	}
+	var req subscribeRequest
+	if err := req.unmarshal(b); err != nil {
+		return invalid("%v", err)
+	}
+	if req.poll || len(req.subscriptions) == 0 {
+		return invalid("The first request must be a subscription list.")
+	}
+	paths := make([]Path, len(req.subscriptions))
+	for i, sub := range req.subscriptions {
+		paths[i] = req.prefix.join(sub.path)
+	}
+
+	switch req.mode {
+	case ModeOnce:
+		return s.sendAll(st, paths, req.updatesOnly)
+	case ModePoll:
+		for {
+			if err := s.sendAll(st, paths, req.updatesOnly); err != nil {
+				return err
+			}
+			b, err := st.read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			var poll subscribeRequest
+			if err := poll.unmarshal(b); err != nil || !poll.poll {
+				return invalid("Only polls may follow a poll subscription.")
+			}
+		}
+	case ModeStream:
+		return s.stream(st, &req, paths, done)
+	}
+	return invalid("Unknown subscription mode %d.", req.mode)
+}
+
+// Sends the updates selected by paths, unless updatesOnly is
+// set, then a sync response.
+func (s *Server) sendAll(st *stream, paths []Path, updatesOnly bool) error {
+	if !updatesOnly {
+		for _, n := range selectUpdates(s.state(), paths) {
+			if err := st.write(updateResponse(n)); err != nil {
+				return err
+			}
+		}
+	}
+	return st.write(syncResponse())
+}
+
+// Samples each subscription of req at its own interval until
+// done is closed. After the first sample of every subscription a
+// sync response is sent.
+func (s *Server) stream(st *stream, req *subscribeRequest, paths []Path, done <-chan struct{}) error {
+	samplers := make([]*sampler, len(req.subscriptions))
+	for i, sub := range req.subscriptions {
+		interval := time.Duration(sub.sampleInterval)
+		switch {
+		case sub.mode == OnChange:
+			interval = OnChangeInterval
+		case interval == 0:
+			interval = DefaultSampleInterval
+		case interval < MinSampleInterval:
+			return invalid("Sample interval %v of %v is below the minimum of %v.", interval, paths[i], MinSampleInterval)
+		}
+		samplers[i] = &sampler{path: paths[i], interval: interval,
+			changesOnly: sub.mode == OnChange || sub.suppressRedundant, last: make(map[string]sent)}
+	}
+
+	// The first samples.
+	for _, smp := range samplers {
+		if err := smp.sample(st, s.state(), req.updatesOnly); err != nil {
+			return err
+		}
+	}
+	if err := st.write(syncResponse()); err != nil {
+		return err
+	}
+
+	// Samplers must be done writing when the RPC returns.
+	errs := make(chan error, len(samplers))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(stop)
+	for _, smp := range samplers {
+		wg.Add(1)
+		go func(smp *sampler) {
+			defer wg.Done()
+			t := time.NewTicker(smp.interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := smp.sample(st, s.state(), false); err != nil {
+						errs <- err
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(smp)
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-done:
+		return nil
+	}
+}
+
+// Samples the state selected by one streamed subscription.
+type sampler struct {
+	path        Path
+	interval    time.Duration
+	changesOnly bool
+	// The values last sent, by path.
+	last map[string]sent
+}
+
+type sent struct {
+	path Path
+	val  interface{}
+}
+
+// Sends the updates of ns selected by the subscription, only the
+// changed ones if changesOnly is set, and deletes for the paths
+// sent before that are gone. If quiet is set, nothing is sent
+// and ns is only remembered.
+func (smp *sampler) sample(st *stream, ns []*Notification, quiet bool) error {
+	seen := make(map[string]bool)
+	for _, n := range selectUpdates(ns, []Path{smp.path}) {
+		m := &Notification{Timestamp: n.Timestamp, Prefix: n.Prefix}
+		for _, u := range n.Update {
+			full := n.Prefix.join(u.Path)
+			k := full.String()
+			seen[k] = true
+			if last, ok := smp.last[k]; ok && smp.changesOnly && last.val == u.Val {
+				continue
+			}
+			smp.last[k] = sent{full, u.Val}
+			m.Update = append(m.Update, u)
+		}
+		if len(m.Update) > 0 && !quiet {
+			if err := st.write(updateResponse(m)); err != nil {
+				return err
+			}
+		}
+	}
+	gone := &Notification{Timestamp: time.Now().UnixNano()}
+	for k, last := range smp.last {
+		if !seen[k] {
+			delete(smp.last, k)
+			gone.Delete = append(gone.Delete, last.path)
+		}
+	}
+	if len(gone.Delete) > 0 && !quiet {
+		return st.write(updateResponse(gone))
+	}
+	return nil
+}
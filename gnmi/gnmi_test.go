@@ -0,0 +1,303 @@
+package gnmi
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustPath(s string) Path {
+	p, err := ParsePath(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestPath(t *testing.T) {
+	p := mustPath("ogo:/switches/switch[dpid=00:00:00:00:00:01]/ports/port[number=1]/state")
+	if p.Origin != "ogo" || len(p.Elem) != 5 || p.Elem[1].Key["dpid"] != "00:00:00:00:00:01" {
+		t.Fatalf("Parsed %+v.", p)
+	}
+	if s := p.String(); s != "ogo:/switches/switch[dpid=00:00:00:00:00:01]/ports/port[number=1]/state" {
+		t.Errorf("Got %s back.", s)
+	}
+	var q Path
+	if err := q.unmarshal(p.marshal()); err != nil || q.String() != p.String() {
+		t.Errorf("Got %v and %v back.", q, err)
+	}
+
+	tests := []struct {
+		pattern string
+		matches bool
+	}{
+		{"/", true},
+		{"/switches", true},
+		{"/switches/switch/ports", true},
+		{"/switches/switch[dpid=*]/*/port[number=1]", true},
+		{"/switches/switch[dpid=00:00:00:00:00:01]/ports/port[number=1]/state", true},
+		{"/switches/switch[dpid=00:00:00:00:00:02]", false},
+		{"/switches/switch/links", false},
+		{"/switches/switch/ports/port[number=1]/state/counters", false},
+	}
+	for _, test := range tests {
+		if mustPath(test.pattern).Matches(p) != test.matches {
+			t.Errorf("Expected %s to match to be %v.", test.pattern, test.matches)
+		}
+	}
+}
+
+// The state served in tests, which tests may change.
+type testState struct {
+	sync.Mutex
+	ns []*Notification
+}
+
+func (s *testState) get() []*Notification {
+	s.Lock()
+	defer s.Unlock()
+	return s.ns
+}
+
+func (s *testState) set(ns ...*Notification) {
+	s.Lock()
+	s.ns = ns
+	s.Unlock()
+}
+
+// Returns the state of a switch with a port and its counter,
+// and links out the ports of links.
+func switchNotification(dpid string, packets uint64, links ...string) *Notification {
+	n := &Notification{Timestamp: 1, Prefix: mustPath("ogo:/switches/switch[dpid=" + dpid + "]")}
+	n.Update = append(n.Update, Update{mustPath("/ports/port[number=1]/state/name"), "eth1"},
+		Update{mustPath("/ports/port[number=1]/state/counters/in-pkts"), packets})
+	for _, port := range links {
+		n.Update = append(n.Update, Update{mustPath("/links/link[port=" + port + "]/state/indirect"), false})
+	}
+	return n
+}
+
+// A gRPC call to a test server.
+type call struct {
+	t    *testing.T
+	body *io.PipeWriter
+	resp *http.Response
+}
+
+func startCall(t *testing.T, ts *httptest.Server, method string, reqs ...[]byte) *call {
+	r, w := io.Pipe()
+	req, _ := http.NewRequest("POST", ts.URL+"/gnmi.gNMI/"+method, r)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	c := &call{t: t, body: w}
+	go func() {
+		for _, b := range reqs {
+			c.send(b)
+		}
+	}()
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.resp = resp
+	return c
+}
+
+func (c *call) send(b []byte) {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(b)))
+	c.body.Write(append(hdr[:], b...))
+}
+
+// Returns the next response, or nil at the end of the call.
+func (c *call) recv() []byte {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.resp.Body, hdr[:]); err != nil {
+		return nil
+	}
+	b := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(c.resp.Body, b); err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// Ends the requests of the call, reads the rest of its
+// responses and returns its gRPC status.
+func (c *call) status() string {
+	c.body.Close()
+	for c.recv() != nil {
+	}
+	return c.resp.Trailer.Get("Grpc-Status")
+}
+
+// Returns the notification in SubscribeResponse b, or nil for a
+// sync response.
+func (c *call) notification(b []byte) *Notification {
+	fields, err := decode(b)
+	if err != nil || len(fields) != 1 {
+		c.t.Fatalf("Bad response %x.", b)
+	}
+	if fields[0].num == 3 {
+		return nil
+	}
+	n := new(Notification)
+	if err := n.unmarshal(fields[0].b); err != nil {
+		c.t.Fatal(err)
+	}
+	return n
+}
+
+func newTestServer(state *testState) *httptest.Server {
+	ts := httptest.NewUnstartedServer(&Server{State: state.get})
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+func subscribeRequestOf(mode int, interval time.Duration, subMode int, paths ...string) []byte {
+	list := new(encoder)
+	for _, p := range paths {
+		sub := new(encoder)
+		sub.bytes(1, mustPath(p).marshal())
+		sub.uint(2, uint64(subMode))
+		sub.uint(3, uint64(interval))
+		list.bytes(2, sub.b)
+	}
+	list.uint(5, uint64(mode))
+	e := new(encoder)
+	e.bytes(1, list.b)
+	return e.b
+}
+
+func TestCapabilitiesAndSet(t *testing.T) {
+	ts := newTestServer(new(testState))
+	defer ts.Close()
+
+	c := startCall(t, ts, "Capabilities", nil)
+	fields, err := decode(c.recv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := ""
+	for _, f := range fields {
+		if f.num == 3 {
+			version = string(f.b)
+		}
+	}
+	if version != Version {
+		t.Errorf("Got version %q.", version)
+	}
+	if s := c.status(); s != "0" {
+		t.Errorf("Capabilities ended with status %s.", s)
+	}
+	if s := startCall(t, ts, "Set", nil).status(); s != "12" {
+		t.Errorf("Set ended with status %s, expected unimplemented.", s)
+	}
+}
+
+func TestGet(t *testing.T) {
+	state := new(testState)
+	state.set(switchNotification("01", 5, "2"), switchNotification("02", 7))
+	ts := newTestServer(state)
+	defer ts.Close()
+
+	req := new(encoder)
+	req.bytes(1, mustPath("/switches/switch[dpid=01]").marshal())
+	req.bytes(2, mustPath("/ports/port/state/counters").marshal())
+	c := startCall(t, ts, "Get", req.b)
+	fields, err := decode(c.recv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 {
+		t.Fatalf("Got %d notifications, expected the one of switch 01.", len(fields))
+	}
+	var n Notification
+	if err := n.unmarshal(fields[0].b); err != nil {
+		t.Fatal(err)
+	}
+	if n.Prefix.String() != "ogo:/switches/switch[dpid=01]" || len(n.Update) != 1 || n.Update[0].Val != uint64(5) {
+		t.Errorf("Got %+v.", n)
+	}
+	if s := c.status(); s != "0" {
+		t.Errorf("Get ended with status %s.", s)
+	}
+}
+
+func TestSubscribeOnceAndPoll(t *testing.T) {
+	state := new(testState)
+	state.set(switchNotification("01", 5), switchNotification("02", 7))
+	ts := newTestServer(state)
+	defer ts.Close()
+
+	c := startCall(t, ts, "Subscribe", subscribeRequestOf(ModeOnce, 0, 0, "/switches/switch/ports"))
+	for i := 0; i < 2; i++ {
+		if n := c.notification(c.recv()); n == nil || len(n.Update) != 2 {
+			t.Fatalf("Got %+v, expected the ports of a switch.", n)
+		}
+	}
+	if c.notification(c.recv()) != nil {
+		t.Error("Expected a sync response.")
+	}
+	if s := c.status(); s != "0" {
+		t.Errorf("Subscribe ended with status %s.", s)
+	}
+
+	c = startCall(t, ts, "Subscribe", subscribeRequestOf(ModePoll, 0, 0, "/switches/switch[dpid=02]"))
+	for _, packets := range []uint64{7, 9} {
+		n := c.notification(c.recv())
+		if n == nil || n.Update[1].Val != packets {
+			t.Fatalf("Got %+v, expected %d packets.", n, packets)
+		}
+		if c.notification(c.recv()) != nil {
+			t.Fatal("Expected a sync response.")
+		}
+		state.set(switchNotification("02", 9))
+		poll := new(encoder)
+		poll.bytes(3, nil)
+		c.send(poll.b)
+	}
+	if s := c.status(); s != "0" {
+		t.Errorf("Subscribe ended with status %s.", s)
+	}
+}
+
+func TestSubscribeStream(t *testing.T) {
+	defer func(d time.Duration) { OnChangeInterval = d }(OnChangeInterval)
+	OnChangeInterval = 10 * time.Millisecond
+	state := new(testState)
+	state.set(switchNotification("01", 5, "2", "3"))
+	ts := newTestServer(state)
+	defer ts.Close()
+
+	c := startCall(t, ts, "Subscribe", subscribeRequestOf(ModeStream, 0, OnChange, "/switches/switch"))
+	// Closing the response cancels the stream.
+	defer c.resp.Body.Close()
+	if n := c.notification(c.recv()); n == nil || len(n.Update) != 4 {
+		t.Fatalf("Got %+v, expected every path.", n)
+	}
+	if c.notification(c.recv()) != nil {
+		t.Fatal("Expected a sync response.")
+	}
+	// The counter changes and a link goes down.
+	state.set(switchNotification("01", 6, "2"))
+	n := c.notification(c.recv())
+	if n == nil || len(n.Update) != 1 || n.Update[0].Val != uint64(6) {
+		t.Fatalf("Got %+v, expected the changed counter only.", n)
+	}
+	n = c.notification(c.recv())
+	if n == nil || len(n.Delete) != 1 || n.Delete[0].String() != "ogo:/switches/switch[dpid=01]/links/link[port=3]/state/indirect" {
+		t.Fatalf("Got %+v, expected the link deleted.", n)
+	}
+
+	// Intervals below the minimum are refused.
+	c = startCall(t, ts, "Subscribe", subscribeRequestOf(ModeStream, time.Millisecond, Sample, "/"))
+	if s := c.status(); s != "3" {
+		t.Errorf("Subscribe ended with status %s, expected invalid argument.", s)
+	}
+}
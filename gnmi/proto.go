@@ -0,0 +1,613 @@
+package gnmi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("The protocol buffer message is truncated.")
+
+// Encodes the fields of a protocol buffer message. Fields are
+// written whether or not they have their zero value, so callers
+// skip those outside oneofs themselves.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.b = append(e.b, byte(v)|0x80)
+		v >>= 7
+	}
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) tag(field, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (e *encoder) uint(field int, v uint64) {
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+func (e *encoder) bool(field int, v bool) {
+	var n uint64
+	if v {
+		n = 1
+	}
+	e.uint(field, n)
+}
+
+func (e *encoder) double(field int, v float64) {
+	e.tag(field, wireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.b = append(e.b, b[:]...)
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+// A field of a decoded protocol buffer message. Varint and fixed
+// values are in v, length delimited ones in b.
+type field struct {
+	num  int
+	wire int
+	v    uint64
+	b    []byte
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+// Splits the protocol buffer message b into its fields.
+func decode(b []byte) ([]field, error) {
+	fields := make([]field, 0)
+	for len(b) > 0 {
+		t, n, err := readVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		f := field{num: int(t >> 3), wire: int(t & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n, err = readVarint(b); err != nil {
+				return nil, err
+			}
+		case wireFixed64:
+			if n = 8; len(b) < n {
+				return nil, errTruncated
+			}
+			f.v = binary.LittleEndian.Uint64(b)
+		case wireFixed32:
+			if n = 4; len(b) < n {
+				return nil, errTruncated
+			}
+			f.v = uint64(binary.LittleEndian.Uint32(b))
+		case wireBytes:
+			l, m, err := readVarint(b)
+			if err != nil || uint64(len(b)-m) < l {
+				return nil, errTruncated
+			}
+			f.b, n = b[m:m+int(l)], m+int(l)
+		default:
+			return nil, fmt.Errorf("Unsupported protocol buffer wire type %d.", f.wire)
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// An element of a Path, such as port[number=1].
+type PathElem struct {
+	Name string
+	Key  map[string]string
+}
+
+// A gNMI path. Paths of this server have the origin "ogo".
+type Path struct {
+	Origin string
+	Target string
+	Elem   []PathElem
+}
+
+// Parses a path written like "/ports/port[number=1]/state",
+// optionally preceded by an origin like "ogo:".
+func ParsePath(s string) (Path, error) {
+	var p Path
+	if i := strings.Index(s, ":/"); i > 0 && !strings.ContainsAny(s[:i], "/[") {
+		p.Origin, s = s[:i], s[i+1:]
+	}
+	for _, e := range splitPath(s) {
+		if e == "" {
+			continue
+		}
+		elem := PathElem{Name: e}
+		if i := strings.IndexByte(e, '['); i >= 0 {
+			elem.Name, elem.Key = e[:i], make(map[string]string)
+			for rest := e[i:]; rest != ""; {
+				end := strings.IndexByte(rest, ']')
+				eq := strings.IndexByte(rest, '=')
+				if rest[0] != '[' || end < 0 || eq < 0 || eq > end {
+					return p, fmt.Errorf("Bad path element %q.", e)
+				}
+				elem.Key[rest[1:eq]] = rest[eq+1 : end]
+				rest = rest[end+1:]
+			}
+		}
+		p.Elem = append(p.Elem, elem)
+	}
+	return p, nil
+}
+
+// Splits s at the slashes outside keys.
+func splitPath(s string) []string {
+	a := make([]string, 0)
+	start, inKey := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			inKey = true
+		case ']':
+			inKey = false
+		case '/':
+			if !inKey {
+				a = append(a, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(a, s[start:])
+}
+
+func (p Path) String() string {
+	var b strings.Builder
+	if p.Origin != "" {
+		b.WriteString(p.Origin + ":")
+	}
+	for _, e := range p.Elem {
+		b.WriteString("/" + e.Name)
+		keys := make([]string, 0, len(e.Key))
+		for k := range e.Key {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString("[" + k + "=" + e.Key[k] + "]")
+		}
+	}
+	if len(p.Elem) == 0 {
+		b.WriteString("/")
+	}
+	return b.String()
+}
+
+// Returns p followed by the elements of q. q's origin wins if
+// set.
+func (p Path) join(q Path) Path {
+	r := Path{Origin: p.Origin, Target: p.Target}
+	if q.Origin != "" {
+		r.Origin = q.Origin
+	}
+	r.Elem = append(append(r.Elem, p.Elem...), q.Elem...)
+	return r
+}
+
+// Returns true if p selects q: each element of p names the
+// element of q at its position, or is "*", and its keys have the
+// values of q's keys, or "*". Keys p leaves out match any value,
+// and p selects everything below its last element.
+func (p Path) Matches(q Path) bool {
+	if len(p.Elem) > len(q.Elem) {
+		return false
+	}
+	for i, e := range p.Elem {
+		if e.Name != "*" && e.Name != q.Elem[i].Name {
+			return false
+		}
+		for k, v := range e.Key {
+			if v != "*" && q.Elem[i].Key[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p Path) marshal() []byte {
+	e := new(encoder)
+	if p.Origin != "" {
+		e.string(2, p.Origin)
+	}
+	for _, elem := range p.Elem {
+		pe := new(encoder)
+		pe.string(1, elem.Name)
+		keys := make([]string, 0, len(elem.Key))
+		for k := range elem.Key {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			entry := new(encoder)
+			entry.string(1, k)
+			entry.string(2, elem.Key[k])
+			pe.bytes(2, entry.b)
+		}
+		e.bytes(3, pe.b)
+	}
+	if p.Target != "" {
+		e.string(4, p.Target)
+	}
+	return e.b
+}
+
+func (p *Path) unmarshal(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1: // Deprecated string elements
+			p.Elem = append(p.Elem, PathElem{Name: string(f.b)})
+		case 2:
+			p.Origin = string(f.b)
+		case 3:
+			elem, err := unmarshalPathElem(f.b)
+			if err != nil {
+				return err
+			}
+			p.Elem = append(p.Elem, elem)
+		case 4:
+			p.Target = string(f.b)
+		}
+	}
+	return nil
+}
+
+func unmarshalPathElem(b []byte) (PathElem, error) {
+	var elem PathElem
+	fields, err := decode(b)
+	if err != nil {
+		return elem, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			elem.Name = string(f.b)
+		case 2:
+			entry, err := decode(f.b)
+			if err != nil {
+				return elem, err
+			}
+			var k, v string
+			for _, g := range entry {
+				switch g.num {
+				case 1:
+					k = string(g.b)
+				case 2:
+					v = string(g.b)
+				}
+			}
+			if elem.Key == nil {
+				elem.Key = make(map[string]string)
+			}
+			elem.Key[k] = v
+		}
+	}
+	return elem, nil
+}
+
+// The value of Path. Val is a string, int64, uint64, bool or
+// float64.
+type Update struct {
+	Path Path
+	Val  interface{}
+}
+
+// Encodes v as a TypedValue.
+func marshalValue(v interface{}) []byte {
+	e := new(encoder)
+	switch t := v.(type) {
+	case string:
+		e.string(1, t)
+	case int64:
+		e.uint(2, uint64(t))
+	case uint64:
+		e.uint(3, t)
+	case bool:
+		e.bool(4, t)
+	case float64:
+		e.double(14, t)
+	default:
+		e.string(1, fmt.Sprint(t))
+	}
+	return e.b
+}
+
+func unmarshalValue(b []byte) (interface{}, error) {
+	fields, err := decode(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1, 12:
+			return string(f.b), nil
+		case 2:
+			return int64(f.v), nil
+		case 3:
+			return f.v, nil
+		case 4:
+			return f.v != 0, nil
+		case 14:
+			return math.Float64frombits(f.v), nil
+		}
+	}
+	return nil, errors.New("Unsupported gNMI value type.")
+}
+
+// A set of updates, and deletes, sharing a timestamp and a
+// prefix. Timestamps are nanoseconds since the Unix epoch.
+type Notification struct {
+	Timestamp int64
+	Prefix    Path
+	Update    []Update
+	Delete    []Path
+}
+
+func (n *Notification) marshal() []byte {
+	e := new(encoder)
+	if n.Timestamp != 0 {
+		e.uint(1, uint64(n.Timestamp))
+	}
+	e.bytes(2, n.Prefix.marshal())
+	for _, u := range n.Update {
+		ue := new(encoder)
+		ue.bytes(1, u.Path.marshal())
+		ue.bytes(3, marshalValue(u.Val))
+		e.bytes(4, ue.b)
+	}
+	for _, p := range n.Delete {
+		e.bytes(5, p.marshal())
+	}
+	return e.b
+}
+
+func (n *Notification) unmarshal(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			n.Timestamp = int64(f.v)
+		case 2:
+			if err := n.Prefix.unmarshal(f.b); err != nil {
+				return err
+			}
+		case 4:
+			var u Update
+			uf, err := decode(f.b)
+			if err != nil {
+				return err
+			}
+			for _, g := range uf {
+				switch g.num {
+				case 1:
+					err = u.Path.unmarshal(g.b)
+				case 3:
+					u.Val, err = unmarshalValue(g.b)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			n.Update = append(n.Update, u)
+		case 5:
+			var p Path
+			if err := p.unmarshal(f.b); err != nil {
+				return err
+			}
+			n.Delete = append(n.Delete, p)
+		}
+	}
+	return nil
+}
+
+// Subscription modes of a subscription list.
+const (
+	ModeStream = 0
+	ModeOnce   = 1
+	ModePoll   = 2
+)
+
+// Sampling modes of a streamed subscription.
+const (
+	TargetDefined = 0
+	OnChange      = 1
+	Sample        = 2
+)
+
+// A path subscribed to. The sample interval is in nanoseconds.
+type subscription struct {
+	path              Path
+	mode              int
+	sampleInterval    uint64
+	suppressRedundant bool
+}
+
+func (s *subscription) unmarshal(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			if err := s.path.unmarshal(f.b); err != nil {
+				return err
+			}
+		case 2:
+			s.mode = int(f.v)
+		case 3:
+			s.sampleInterval = f.v
+		case 4:
+			s.suppressRedundant = f.v != 0
+		}
+	}
+	return nil
+}
+
+// A SubscribeRequest, either a subscription list or a poll.
+type subscribeRequest struct {
+	poll          bool
+	prefix        Path
+	subscriptions []subscription
+	mode          int
+	updatesOnly   bool
+}
+
+func (r *subscribeRequest) unmarshal(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			if err := r.unmarshalList(f.b); err != nil {
+				return err
+			}
+		case 3:
+			r.poll = true
+		}
+	}
+	return nil
+}
+
+func (r *subscribeRequest) unmarshalList(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			err = r.prefix.unmarshal(f.b)
+		case 2:
+			var s subscription
+			err = s.unmarshal(f.b)
+			r.subscriptions = append(r.subscriptions, s)
+		case 5:
+			r.mode = int(f.v)
+		case 9:
+			r.updatesOnly = f.v != 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A GetRequest; only the prefix and the paths are used.
+type getRequest struct {
+	prefix Path
+	paths  []Path
+}
+
+func (r *getRequest) unmarshal(b []byte) error {
+	fields, err := decode(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			err = r.prefix.unmarshal(f.b)
+		case 2:
+			var p Path
+			err = p.unmarshal(f.b)
+			r.paths = append(r.paths, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a SubscribeResponse carrying n.
+func updateResponse(n *Notification) []byte {
+	e := new(encoder)
+	e.bytes(1, n.marshal())
+	return e.b
+}
+
+// Returns a SubscribeResponse marking the end of the initial
+// updates.
+func syncResponse() []byte {
+	e := new(encoder)
+	e.bool(3, true)
+	return e.b
+}
+
+// Returns a GetResponse carrying ns.
+func getResponse(ns []*Notification) []byte {
+	e := new(encoder)
+	for _, n := range ns {
+		e.bytes(1, n.marshal())
+	}
+	return e.b
+}
+
+// The PROTO encoding of gNMI.
+const encodingProto = 2
+
+// Returns the CapabilityResponse of this server.
+func capabilityResponse() []byte {
+	e := new(encoder)
+	model := new(encoder)
+	model.string(1, Model)
+	model.string(2, "ogo")
+	model.string(3, ModelVersion)
+	e.bytes(1, model.b)
+	// Values are typed scalars, which is the PROTO encoding.
+	packed := new(encoder)
+	packed.varint(encodingProto)
+	e.bytes(2, packed.b)
+	e.string(3, Version)
+	return e.b
+}
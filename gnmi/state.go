@@ -0,0 +1,117 @@
+package gnmi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How long to wait for each statistics reply.
+var RequestTimeout = time.Second * 2
+
+// Returns the state of every connected switch, one notification
+// per switch. Each call polls the statistics of OpenFlow 1.0
+// switches.
+func ControllerState() []*Notification {
+	ns := make([]*Notification, 0)
+	for _, sw := range ogo.Switches() {
+		ns = append(ns, switchState(sw))
+	}
+	return ns
+}
+
+// Returns a path element, with a key if kv holds a key and its
+// value.
+func elem(name string, kv ...string) PathElem {
+	e := PathElem{Name: name}
+	if len(kv) == 2 {
+		e.Key = map[string]string{kv[0]: kv[1]}
+	}
+	return e
+}
+
+// Returns the path of the elements base followed by names.
+func leaf(base []PathElem, names ...string) Path {
+	p := Path{Elem: append([]PathElem{}, base...)}
+	for _, name := range names {
+		p.Elem = append(p.Elem, PathElem{Name: name})
+	}
+	return p
+}
+
+func status(down bool) string {
+	if down {
+		return "DOWN"
+	}
+	return "UP"
+}
+
+func switchState(sw *ogo.OFSwitch) *Notification {
+	n := &Notification{Timestamp: time.Now().UnixNano(), Prefix: Path{Origin: Origin,
+		Elem: []PathElem{elem("switches"), elem("switch", "dpid", sw.DPID().String())}}}
+	add := func(p Path, v interface{}) {
+		n.Update = append(n.Update, Update{p, v})
+	}
+
+	polled := sw.Version() == ofp10.VERSION
+	for _, port := range sw.Ports() {
+		state := []PathElem{elem("ports"), elem("port", "number", fmt.Sprint(port.PortNo)), elem("state")}
+		add(leaf(state, "name"), strings.TrimRight(string(port.Name), "\x00"))
+		add(leaf(state, "oper-status"), status(port.State&ofp10.PS_LINK_DOWN != 0))
+		add(leaf(state, "admin-status"), status(port.Config&ofp10.PC_PORT_DOWN != 0))
+		if !polled || port.PortNo >= ofp10.P_MAX {
+			continue
+		}
+		req := ofp10.NewPortStatsRequest()
+		req.PortNo = port.PortNo
+		s, ok := stats(sw, ofp10.StatsType_Port, req).(*ofp10.PortStats)
+		if !ok {
+			continue
+		}
+		for name, v := range map[string]uint64{
+			"in-pkts": s.RxPackets, "out-pkts": s.TxPackets,
+			"in-octets": s.RxBytes, "out-octets": s.TxBytes,
+			"in-discards": s.RxDropped, "out-discards": s.TxDropped,
+			"in-errors": s.RxErrors, "out-errors": s.TxErrors,
+		} {
+			add(leaf(state, "counters", name), v)
+		}
+	}
+
+	for _, l := range sw.Links() {
+		state := []PathElem{elem("links"), elem("link", "port", fmt.Sprint(l.Port)), elem("state")}
+		add(leaf(state, "peer-dpid"), l.DPID.String())
+		add(leaf(state, "latency"), uint64(l.Latency))
+		add(leaf(state, "indirect"), l.Indirect)
+	}
+
+	if polled {
+		req := ofp10.NewAggregateStatsRequest()
+		req.TableId = 0xff
+		req.OutPort = ofp10.P_NONE
+		if s, ok := stats(sw, ofp10.StatsType_Aggregate, req).(*ofp10.AggregateStats); ok {
+			state := []PathElem{elem("flows"), elem("state")}
+			add(leaf(state, "count"), uint64(s.FlowCount))
+			add(leaf(state, "packets"), s.PacketCount)
+			add(leaf(state, "bytes"), s.ByteCount)
+		}
+	}
+	return n
+}
+
+// Sends a stats request of type t to sw and returns the body of
+// the reply.
+func stats(sw *ogo.OFSwitch, t uint16, body util.Message) util.Message {
+	rep, err := sw.SendAndReceive(ofp10.NewStatsRequest(t, body), RequestTimeout)
+	if err != nil {
+		return nil
+	}
+	if r, ok := rep.(*ofp10.StatsReply); ok {
+		return r.Body
+	}
+	return nil
+}
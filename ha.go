@@ -0,0 +1,302 @@
+package ogo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// How often an active controller sends its state to standbys.
+var ReplicationInterval = time.Second
+
+// How long a standby waits without hearing from the active
+// controller before taking over.
+var FailoverTimeout = time.Second * 3
+
+// A copy of the controller state sent from an active to a
+// standby controller.
+type ReplicaState struct {
+	Time     time.Time
+	Switches []ReplicaSwitch
+}
+
+type ReplicaSwitch struct {
+	DPID  net.HardwareAddr
+	Links []Link
+	Flows []FlowEntry
+}
+
+// The state received by this controller while running as a
+// standby. Used to restore switches as they reconnect after a
+// takeover.
+var replica struct {
+	sync.RWMutex
+	state *ReplicaState
+}
+
+// Returns a copy of the current controller state.
+func snapshot() *ReplicaState {
+	st := new(ReplicaState)
+	st.Time = time.Now()
+	st.Switches = make([]ReplicaSwitch, 0)
+	for _, sw := range Switches() {
+		st.Switches = append(st.Switches, ReplicaSwitch{sw.DPID(), sw.Links(), sw.Flows()})
+	}
+	return st
+}
+
+// Restores the links and flow shadow of Switch s from the state
+// replicated before a takeover.
+func (s *OFSwitch) restore() {
+	replica.RLock()
+	defer replica.RUnlock()
+	if replica.state == nil {
+		return
+	}
+	for _, r := range replica.state.Switches {
+		if r.DPID.String() != s.dpid.String() {
+			continue
+		}
+		s.linksMu.Lock()
+		for i := range r.Links {
+			l := r.Links[i]
+			// Restored links get a full timeout to be
+			// rediscovered.
+			l.Updated = time.Now()
+			s.links[l.DPID.String()] = &l
+		}
+		s.linksMu.Unlock()
+		s.flowsMu.Lock()
+		for i := range r.Flows {
+			f := r.Flows[i]
+			s.flows[flowKey(f.TableId, f.Priority, &f.Match)] = &f
+		}
+		s.flowsMu.Unlock()
+		log.Println("Restored replicated state for:", SwitchLabel(s.dpid))
+	}
+}
+
+// The key shared by an active controller and its standbys,
+// without which they refuse to replicate. Both ends prove they
+// hold it when a standby connects, and every state sent is signed
+// with it, so only a holder of the key is sent the state and a
+// standby only believes state signed with it. The state isn't
+// encrypted; keep the replication channel on a trusted network.
+var ReplicationKey []byte
+
+var ErrNoReplicationKey = errors.New("Replication needs a ReplicationKey.")
+
+var errReplicationAuth = errors.New("The replication peer doesn't hold the ReplicationKey.")
+
+// A ReplicaState sent over the replication channel. MAC signs
+// State with the ids of the session and the sequence number of
+// the frame, so frames can't be replayed or reordered.
+type replicaFrame struct {
+	Seq   uint64
+	State []byte
+	MAC   []byte
+}
+
+// Returns the HMAC of parts under key, for the role label.
+func replicationMAC(key []byte, label string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// Proves to the standby on conn that this controller holds key,
+// and checks that the standby does. Returns the id of the
+// session, made of a random value from each end.
+func authStandby(conn net.Conn, key []byte) ([]byte, error) {
+	a := make([]byte, 32)
+	if _, err := rand.Read(a); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(a); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	b := buf[:32]
+	if !hmac.Equal(buf[32:], replicationMAC(key, "standby", a, b)) {
+		return nil, errReplicationAuth
+	}
+	if _, err := conn.Write(replicationMAC(key, "active", a, b)); err != nil {
+		return nil, err
+	}
+	return append(a, b...), nil
+}
+
+// The standby end of authStandby.
+func authActive(conn net.Conn, key []byte) ([]byte, error) {
+	a := make([]byte, 32)
+	if _, err := io.ReadFull(conn, a); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(b, replicationMAC(key, "standby", a, b)...)); err != nil {
+		return nil, err
+	}
+	proof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, proof); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(proof, replicationMAC(key, "active", a, b)) {
+		return nil, errReplicationAuth
+	}
+	return append(a, b...), nil
+}
+
+// Returns the MAC of frame f in session.
+func (f *replicaFrame) mac(key, session []byte) []byte {
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], f.Seq)
+	return replicationMAC(key, "state", session, seq[:], f.State)
+}
+
+// Sends st as frame seq of session.
+func sendReplica(enc *gob.Encoder, key, session []byte, seq uint64, st *ReplicaState) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+		return err
+	}
+	f := &replicaFrame{Seq: seq, State: buf.Bytes()}
+	f.MAC = f.mac(key, session)
+	return enc.Encode(f)
+}
+
+// Receives frame seq of session.
+func readReplica(dec *gob.Decoder, key, session []byte, seq uint64) (*ReplicaState, error) {
+	f := new(replicaFrame)
+	if err := dec.Decode(f); err != nil {
+		return nil, err
+	}
+	if f.Seq != seq || !hmac.Equal(f.MAC, f.mac(key, session)) {
+		return nil, errors.New("Replicated state with a bad signature.")
+	}
+	st := new(ReplicaState)
+	if err := gob.NewDecoder(bytes.NewReader(f.State)).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accepts standby controllers on addr and sends each of them
+// the state of this controller every ReplicationInterval.
+func (c *Controller) ServeStandby(addr string) error {
+	if ReplicationKey == nil {
+		return ErrNoReplicationKey
+	}
+	sock, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	log.Println("Accepting standby controllers on", sock.Addr())
+	for {
+		conn, err := sock.Accept()
+		if err != nil {
+			return err
+		}
+		go c.replicate(conn)
+	}
+}
+
+func (c *Controller) replicate(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(FailoverTimeout))
+	session, err := authStandby(conn, ReplicationKey)
+	if err != nil {
+		log.Println("Refused standby controller:", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	log.Println("Standby controller connected:", conn.RemoteAddr())
+	enc := gob.NewEncoder(conn)
+	for seq := uint64(0); ; seq++ {
+		conn.SetWriteDeadline(time.Now().Add(FailoverTimeout))
+		if err := sendReplica(enc, ReplicationKey, session, seq, snapshot()); err != nil {
+			log.Println("Standby controller disconnected:", conn.RemoteAddr(), err)
+			return
+		}
+		time.Sleep(ReplicationInterval)
+	}
+}
+
+// Runs this controller as a standby of the controller serving
+// replication on active. State is synced until the active
+// controller hasn't been heard from for FailoverTimeout, then
+// this controller takes over by listening for switches on
+// listen. Switches must be configured to reconnect to it, or
+// listen must be an address the active controller gave up.
+//
+// A standby that has never received the state of the active
+// controller doesn't take over, however long it waits: it would
+// serve the switches without their links and flows.
+func (c *Controller) Standby(active, listen string) error {
+	if ReplicationKey == nil {
+		return ErrNoReplicationKey
+	}
+	var last time.Time
+	for last.IsZero() || time.Since(last) < FailoverTimeout {
+		conn, err := net.DialTimeout("tcp", active, FailoverTimeout)
+		if err != nil {
+			time.Sleep(ReplicationInterval)
+			continue
+		}
+		if t := c.sync(conn); !t.IsZero() {
+			last = t
+		} else if last.IsZero() {
+			log.Println("Waiting for the initial sync from", active)
+			time.Sleep(ReplicationInterval)
+		}
+	}
+
+	log.Println("Active controller failed, taking over.")
+	c.Listen(listen)
+	return nil
+}
+
+// Receives the state of the active controller on conn until it
+// fails. Returns when the last state was received, zero if none.
+func (c *Controller) sync(conn net.Conn) (last time.Time) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(FailoverTimeout))
+	session, err := authActive(conn, ReplicationKey)
+	if err != nil {
+		log.Println("Refused active controller:", err)
+		return
+	}
+	log.Println("Syncing state from active controller", conn.RemoteAddr())
+	dec := gob.NewDecoder(conn)
+	for seq := uint64(0); ; seq++ {
+		conn.SetReadDeadline(time.Now().Add(FailoverTimeout))
+		st, err := readReplica(dec, ReplicationKey, session, seq)
+		if err != nil {
+			log.Println("Lost active controller:", err)
+			return
+		}
+		replica.Lock()
+		replica.state = st
+		replica.Unlock()
+		last = time.Now()
+	}
+}
@@ -0,0 +1,83 @@
+package ogo
+
+import (
+	"encoding/gob"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplicationAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		active     string
+		standby    string
+		authorized bool
+	}{
+		{"shared key", "secret", "secret", true},
+		{"other key", "secret", "guess", false},
+	}
+	for _, test := range tests {
+		a, b := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := authActive(b, []byte(test.standby))
+			b.Close()
+			done <- err
+		}()
+		session, err := authStandby(a, []byte(test.active))
+		a.Close()
+		if (err == nil) != test.authorized {
+			t.Errorf("%s: active got %v.", test.name, err)
+		}
+		if test.authorized && len(session) != 64 {
+			t.Errorf("%s: got a session id of %d bytes.", test.name, len(session))
+		}
+		if err := <-done; test.authorized && err != nil {
+			t.Errorf("%s: standby got %v.", test.name, err)
+		}
+	}
+}
+
+func TestReplicaFrames(t *testing.T) {
+	key, session := []byte("secret"), make([]byte, 64)
+	st := &ReplicaState{Time: time.Unix(1000, 0), Switches: []ReplicaSwitch{{DPID: net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}}}}
+	tests := []struct {
+		name    string
+		key     []byte
+		seq     uint64
+		tamper  bool
+		want    uint64 // The sequence number the reader expects
+		believe bool
+	}{
+		{"signed", key, 0, false, 0, true},
+		{"other key", []byte("guess"), 0, false, 0, false},
+		{"replayed", key, 0, false, 1, false},
+		{"tampered", key, 0, true, 0, false},
+	}
+	for _, test := range tests {
+		a, b := net.Pipe()
+		go func() {
+			enc := gob.NewEncoder(a)
+			if test.tamper {
+				// Signs one state and sends another.
+				f := &replicaFrame{Seq: test.seq, State: []byte("signed")}
+				f.MAC = f.mac(test.key, session)
+				f.State = []byte("forged")
+				enc.Encode(f)
+			} else {
+				sendReplica(enc, test.key, session, test.seq, st)
+			}
+			a.Close()
+		}()
+		got, err := readReplica(gob.NewDecoder(b), key, session, test.want)
+		b.Close()
+		if (err == nil) != test.believe {
+			t.Errorf("%s: got error %v.", test.name, err)
+			continue
+		}
+		if test.believe && (!got.Time.Equal(st.Time) || got.Switches[0].DPID.String() != st.Switches[0].DPID.String()) {
+			t.Errorf("%s: got state %+v.", test.name, got)
+		}
+	}
+}
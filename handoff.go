@@ -0,0 +1,327 @@
+package ogo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jonstout/ogo/ovsdb"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How long a handoff waits for the peer controller to become
+// master of a switch before taking the switch back.
+var HandoffTimeout = time.Second * 30
+
+// How often a handoff checks whether the peer has become master.
+var HandoffPollInterval = time.Millisecond * 500
+
+// Published when a switch has been handed to a peer controller.
+// The event data is the target of the peer.
+const EventSwitchHandoff = "switch.handoff"
+
+var (
+	errRoleUnsupported = errors.New("Controller roles require OpenFlow 1.3 or later.")
+	errHandoffTimeout  = errors.New("The peer controller didn't become master in time.")
+)
+
+// A switch to hand off. DB is an optional connection to the
+// OVSDB server of the host running Bridge, used to add the peer
+// to the controllers of the bridge and to watch it take over.
+type HandoffSwitch struct {
+	DPID   net.HardwareAddr
+	DB     *ovsdb.Client
+	Bridge string
+}
+
+// A Handoff gracefully moves switches from this controller to a
+// peer, e.g. before taking this controller down for maintenance.
+// Flows stay installed throughout, so forwarding isn't
+// disturbed. For each switch in turn this controller:
+//
+//  1. adds Peer to the controllers of the bridge, if the switch
+//     has an OVSDB connection,
+//  2. demotes itself to slave with a role request,
+//  3. waits for the peer to become master, and
+//  4. removes Self from the controllers of the bridge, if set,
+//     which closes its connection. Otherwise the connection is
+//     kept as a slave.
+//
+// Without OVSDB, the peer is seen taking over when the
+// generation id of the switch advances, so the peer must request
+// the master role with a newer generation id than the last one.
+// If the peer doesn't take over within Timeout, this controller
+// takes back its former role.
+type Handoff struct {
+	Switches []HandoffSwitch
+	// Controller targets in the format of Open vSwitch, like
+	// "tcp:10.0.0.2:6653".
+	Peer string
+	Self string
+	// If zero, HandoffTimeout.
+	Timeout time.Duration
+}
+
+// Hands off every switch of h and returns the errors of those
+// that weren't, by DPID.
+func (h *Handoff) Run() map[string]error {
+	errs := make(map[string]error)
+	for _, hs := range h.Switches {
+		if err := h.handoff(hs); err != nil {
+			log.Println("Failed to hand off", SwitchLabel(hs.DPID), "to", h.Peer+":", err)
+			errs[hs.DPID.String()] = err
+			continue
+		}
+		log.Println("Handed off", SwitchLabel(hs.DPID), "to", h.Peer)
+		Publish(EventSwitchHandoff, hs.DPID, h.Peer)
+	}
+	return errs
+}
+
+func (h *Handoff) handoff(hs HandoffSwitch) error {
+	sw, ok := Switch(hs.DPID)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = HandoffTimeout
+	}
+	watchDB := hs.DB != nil && h.Peer != ""
+	if watchDB {
+		if err := addController(hs.DB, hs.Bridge, h.Peer); err != nil {
+			return err
+		}
+	}
+
+	role, gen, err := sw.RequestRole(ofp14.CR_ROLE_NOCHANGE, 0, timeout)
+	if err != nil {
+		return err
+	}
+	// A switch that has seen no generation id replies with the
+	// largest one.
+	if gen == math.MaxUint64 {
+		gen = 0
+	}
+	if _, _, err := sw.RequestRole(ofp14.CR_ROLE_SLAVE, gen, timeout); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var taken bool
+		if watchDB {
+			taken, err = peerIsMaster(hs.DB, hs.Bridge, h.Peer)
+		} else {
+			var g uint64
+			_, g, err = sw.RequestRole(ofp14.CR_ROLE_NOCHANGE, 0, timeout)
+			taken = g != math.MaxUint64 && int64(g-gen) > 0
+		}
+		if err != nil {
+			log.Println("Failed to check the role of", h.Peer, "on", SwitchLabel(hs.DPID)+":", err)
+		}
+		if taken {
+			break
+		}
+		if time.Now().After(deadline) {
+			if role != ofp14.CR_ROLE_MASTER {
+				role = ofp14.CR_ROLE_EQUAL
+			}
+			if _, _, err := sw.RequestRole(role, gen, timeout); err != nil {
+				log.Println("Failed to take back", SwitchLabel(hs.DPID)+":", err)
+			}
+			return errHandoffTimeout
+		}
+		time.Sleep(HandoffPollInterval)
+	}
+
+	if hs.DB != nil && h.Self != "" {
+		return removeController(hs.DB, hs.Bridge, h.Self)
+	}
+	return nil
+}
+
+// Requests role, one of ofp14.CR_ROLE_*, for this controller on
+// Switch s. generation orders master and slave requests and is
+// ignored for the others. Returns the role and generation id in
+// the reply of the switch.
+func (s *OFSwitch) RequestRole(role uint32, generation uint64, timeout time.Duration) (uint32, uint64, error) {
+	var req util.Message
+	var x uint32
+	switch s.Version() {
+	case ofp13.VERSION:
+		r := ofp13.NewRoleRequest(role, generation)
+		req, x = r, r.Xid
+	case ofp14.VERSION, ofp15.VERSION:
+		r := ofp14.NewRoleRequest(role, generation)
+		r.Header.Version = s.Version()
+		req, x = r, r.Xid
+	default:
+		return 0, 0, errRoleUnsupported
+	}
+	ch := make(chan util.Message, 1)
+	session := s.expect(x, ch)
+	defer s.forget(x)
+	span := s.startTransaction(x, req)
+	defer s.endTransaction(x, span)
+
+	if err := s.Send(req); err != nil {
+		return 0, 0, err
+	}
+	select {
+	case rep := <-ch:
+		switch r := rep.(type) {
+		case *ofp13.RoleRequest:
+			return r.Role, r.GenerationId, nil
+		case *ofp14.RoleRequest:
+			return r.Role, r.GenerationId, nil
+		case *ofp14.ErrorMsg:
+			return 0, 0, fmt.Errorf("Role request failed with error %d/%d.", r.Type, r.Code)
+		}
+		return 0, 0, errors.New("Unexpected reply to role request.")
+	case <-session.Done():
+		return 0, 0, ErrSessionLost
+	case <-time.After(timeout):
+		return 0, 0, ErrRequestTimeout
+	}
+}
+
+// Returns the Controller rows of bridge by target.
+func bridgeControllers(db *ovsdb.Client, bridge string) (map[string]map[string]interface{}, error) {
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", bridge)}
+	res, err := db.Transact("Open_vSwitch",
+		ovsdb.Select("Bridge", where, "controller"),
+		ovsdb.Select("Controller", nil, "_uuid", "target", "role", "is_connected"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 || len(res[0].Rows) != 1 {
+		return nil, fmt.Errorf("Bridge %s doesn't exist.", bridge)
+	}
+	own := make(map[string]bool)
+	for _, id := range ovsdb.ParseUUIDs(res[0].Rows[0]["controller"]) {
+		own[id] = true
+	}
+	rows := make(map[string]map[string]interface{})
+	for _, row := range res[1].Rows {
+		ids := ovsdb.ParseUUIDs(row["_uuid"])
+		target, ok := row["target"].(string)
+		if ok && len(ids) == 1 && own[ids[0]] {
+			rows[target] = row
+		}
+	}
+	return rows, nil
+}
+
+// Adds target to the controllers of bridge, unless it's already
+// one of them.
+func addController(db *ovsdb.Client, bridge, target string) error {
+	rows, err := bridgeControllers(db, bridge)
+	if err != nil {
+		return err
+	}
+	if _, ok := rows[target]; ok {
+		return nil
+	}
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", bridge)}
+	_, err = db.Transact("Open_vSwitch",
+		ovsdb.Insert("Controller", map[string]interface{}{"target": target}, "ctl"),
+		ovsdb.Mutate("Bridge", where, ovsdb.NewMutation("controller", "insert", ovsdb.Set(ovsdb.NamedUUID("ctl")))),
+	)
+	return err
+}
+
+// Removes target from the controllers of bridge. Open vSwitch
+// deletes the unreferenced row and closes its connection.
+func removeController(db *ovsdb.Client, bridge, target string) error {
+	rows, err := bridgeControllers(db, bridge)
+	if err != nil {
+		return err
+	}
+	row, ok := rows[target]
+	if !ok {
+		return nil
+	}
+	ids := ovsdb.ParseUUIDs(row["_uuid"])
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", bridge)}
+	_, err = db.Transact("Open_vSwitch",
+		ovsdb.Mutate("Bridge", where, ovsdb.NewMutation("controller", "delete", ovsdb.Set(ovsdb.UUID(ids[0])))),
+	)
+	return err
+}
+
+// Returns true if target is a connected master of bridge.
+func peerIsMaster(db *ovsdb.Client, bridge, target string) (bool, error) {
+	rows, err := bridgeControllers(db, bridge)
+	if err != nil {
+		return false, err
+	}
+	row, ok := rows[target]
+	return ok && row["role"] == "master" && row["is_connected"] == true, nil
+}
+
+// Names the interlock operation of a request to serveHandoff.
+func handoffOp(r *http.Request) string {
+	if r.Method == "POST" {
+		return OpHandoff
+	}
+	return ""
+}
+
+// Hands off the switches given by the repeatable dpid parameter
+// to the controller given by the peer parameter, which must
+// request the master role with a newer generation id, and
+// returns the errors by DPID as JSON:
+//
+//	curl -X POST '/handoff?peer=tcp:10.0.0.2:6653&dpid=00:00:00:00:00:00:00:01'
+//
+// Handing off with OVSDB needs Handoff.
+func serveHandoff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h := &Handoff{Peer: r.URL.Query().Get("peer")}
+	if h.Peer == "" {
+		http.Error(w, "handoff needs a peer", http.StatusBadRequest)
+		return
+	}
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			http.Error(w, "bad timeout", http.StatusBadRequest)
+			return
+		}
+		h.Timeout = d
+	}
+	for _, d := range r.URL.Query()["dpid"] {
+		dpid, err := net.ParseMAC(d)
+		if err != nil {
+			http.Error(w, "bad dpid", http.StatusBadRequest)
+			return
+		}
+		h.Switches = append(h.Switches, HandoffSwitch{DPID: dpid})
+	}
+	if len(h.Switches) == 0 {
+		http.Error(w, "handoff needs a dpid", http.StatusBadRequest)
+		return
+	}
+	errs := make(map[string]string)
+	for d, err := range h.Run() {
+		errs[d] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(errs)
+}
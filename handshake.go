@@ -0,0 +1,232 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// How long a switch has to send its Hello, and then its
+// features reply, before the connection is dropped.
+var (
+	HelloTimeout    = time.Second * 3
+	FeaturesTimeout = time.Second * 3
+)
+
+// The stages of the OpenFlow handshake.
+type HandshakeState int
+
+const (
+	HandshakeHelloSent HandshakeState = iota
+	HandshakeHelloReceived
+	HandshakeFeaturesRequested
+	HandshakeActive
+)
+
+func (s HandshakeState) String() string {
+	switch s {
+	case HandshakeHelloSent:
+		return "HelloSent"
+	case HandshakeHelloReceived:
+		return "HelloReceived"
+	case HandshakeFeaturesRequested:
+		return "FeaturesRequested"
+	case HandshakeActive:
+		return "Active"
+	}
+	return fmt.Sprintf("HandshakeState(%d)", int(s))
+}
+
+// Why a handshake failed.
+type HandshakeFailure int
+
+const (
+	// The switch didn't answer in time.
+	HandshakeTimeout HandshakeFailure = iota
+	// No OpenFlow version is supported by both sides.
+	HandshakeVersionMismatch
+	// The switch sent an error message.
+	HandshakeSwitchError
+	// The connection was closed or failed.
+	HandshakeConnectionLost
+	// The switch was refused by the controller's Admit or
+	// DuplicateDPID policy.
+	HandshakeRejected
+)
+
+func (f HandshakeFailure) String() string {
+	switch f {
+	case HandshakeTimeout:
+		return "timeout"
+	case HandshakeVersionMismatch:
+		return "version mismatch"
+	case HandshakeSwitchError:
+		return "switch error"
+	case HandshakeConnectionLost:
+		return "connection lost"
+	case HandshakeRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("HandshakeFailure(%d)", int(f))
+}
+
+// A HandshakeError describes a switch that failed to connect.
+// DPID is only known once features have been received.
+type HandshakeError struct {
+	Addr    net.Addr
+	DPID    net.HardwareAddr
+	State   HandshakeState
+	Failure HandshakeFailure
+	Err     error
+}
+
+func (e *HandshakeError) Error() string {
+	s := fmt.Sprintf("Handshake with %s failed in state %s: %s", e.Addr, e.State, e.Failure)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// A handshake with a newly connected switch.
+type handshake struct {
+	c      *Controller
+	stream *MessageStream
+	state  HandshakeState
+	timer  *time.Timer
+}
+
+func (h *handshake) fail(f HandshakeFailure, dpid net.HardwareAddr, err error) *HandshakeError {
+	return &HandshakeError{h.stream.GetAddr(), dpid, h.state, f, err}
+}
+
+// Moves the handshake to state s, which must complete within
+// timeout.
+func (h *handshake) enter(s HandshakeState, timeout time.Duration) {
+	h.state = s
+	if !h.timer.Stop() {
+		select {
+		case <-h.timer.C:
+		default:
+		}
+	}
+	h.timer.Reset(timeout)
+}
+
+// Runs the handshake until the switch is active. Returns the
+// DPID of the switch and false if the connection was added as an
+// auxiliary connection of an already connected switch.
+func (h *handshake) run() (net.HardwareAddr, bool, *HandshakeError) {
+	h.stream.Outbound <- ofpxx.NewHelloVersions(SupportedVersions...)
+	h.timer = time.NewTimer(HelloTimeout)
+	defer h.timer.Stop()
+
+	for {
+		select {
+		case msg := <-h.stream.Inbound:
+			h.stream.takeStamp(msg)
+			switch m := msg.(type) {
+			// A Hello message completes version negotiation.
+			// The highest version supported by both sides is
+			// used for the rest of the connection.
+			case *ofpxx.Hello:
+				if h.state != HandshakeHelloSent {
+					continue
+				}
+				ver, ok := m.Negotiate(SupportedVersions...)
+				if !ok {
+					return nil, false, h.fail(HandshakeVersionMismatch, nil,
+						fmt.Errorf("switch offered version %d", m.Version))
+				}
+				h.state = HandshakeHelloReceived
+				h.stream.Version = ver
+				// Request features to learn the switch's
+				// DPID. A registered codec handles its
+				// version, even a built-in one.
+				codec := h.stream.Codec()
+				if codec == nil || codec.Version() != ver {
+					codec = nil
+					if c, ok := ofp.CodecFor(ver); ok {
+						codec = c
+						h.stream.SetCodec(c)
+					}
+				}
+				switch {
+				case codec != nil:
+					h.stream.Outbound <- codec.NewFeaturesRequest()
+				case ver == ofp10.VERSION:
+					h.stream.Outbound <- ofp10.NewFeaturesRequest()
+				case ver == ofp13.VERSION:
+					h.stream.Outbound <- ofp13.NewFeaturesRequest()
+				case ver == ofp14.VERSION:
+					h.stream.Outbound <- ofp14.NewFeaturesRequest()
+				case ver == ofp15.VERSION:
+					h.stream.Outbound <- ofp15.NewFeaturesRequest()
+				}
+				h.enter(HandshakeFeaturesRequested, FeaturesTimeout)
+			// After a valid FeaturesReply has been received we
+			// have all the information we need.
+			case *ofp10.SwitchFeatures:
+				return h.activate(m.DPID, m.Ports)
+			// OpenFlow 1.3, 1.4 and 1.5 share a features
+			// reply which doesn't include port descriptions.
+			case *ofp14.SwitchFeatures:
+				return h.activate(m.DPID, nil)
+			// An error message may indicate a version mismatch.
+			// We disconnect if an error occurs this early.
+			case *ofp10.ErrorMsg:
+				h.stream.Version = m.Header.Version
+				return nil, false, h.fail(HandshakeSwitchError, nil, errors.New(errorString(m)))
+			case *ofp14.ErrorMsg:
+				return nil, false, h.fail(HandshakeSwitchError, nil, errors.New(errorString(m)))
+			default:
+				if h.state != HandshakeFeaturesRequested {
+					continue
+				}
+				if codec := h.stream.Codec(); codec != nil && codec.Version() == h.stream.Version {
+					if dpid, ok := codec.FeaturesDPID(msg); ok {
+						return h.activate(dpid, nil)
+					}
+				}
+			}
+		case err := <-h.stream.Error:
+			return nil, false, h.fail(HandshakeConnectionLost, nil, err)
+		case <-h.timer.C:
+			// The connection is established but the switch
+			// isn't following the protocol.
+			return nil, false, h.fail(HandshakeTimeout, nil, nil)
+		}
+	}
+}
+
+// Admits and adds the switch once its features are known.
+func (h *handshake) activate(dpid net.HardwareAddr, ports []ofp10.PhyPort) (net.HardwareAddr, bool, *HandshakeError) {
+	if h.c.Admit != nil {
+		if err := h.c.Admit(dpid, h.stream.GetAddr(), h.stream.Version); err != nil {
+			return dpid, false, h.fail(HandshakeRejected, dpid, err)
+		}
+	}
+	main, err := newSwitch(h.stream, dpid, ports, h.c.DuplicateDPID)
+	if err != nil {
+		return dpid, false, h.fail(HandshakeRejected, dpid, err)
+	}
+	h.state = HandshakeActive
+	return dpid, main, nil
+}
+
+func (c *Controller) handshakeFailed(err *HandshakeError) {
+	if c.HandshakeFailed != nil {
+		c.HandshakeFailed(err)
+		return
+	}
+	log.Println(err)
+}
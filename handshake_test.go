@@ -0,0 +1,142 @@
+package ogo
+
+import (
+	"encoding"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Returns the bytes of msg.
+func marshal(t *testing.T, msg encoding.BinaryMarshaler) []byte {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHandshake(t *testing.T) {
+	defer func(hello, features time.Duration, vers []uint8) {
+		HelloTimeout, FeaturesTimeout, SupportedVersions = hello, features, vers
+	}(HelloTimeout, FeaturesTimeout, SupportedVersions)
+	HelloTimeout, FeaturesTimeout = time.Millisecond*200, time.Millisecond*200
+
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	features10 := ofp10.NewFeaturesReply()
+	features10.DPID = dpid
+	features14 := ofp14.NewFeaturesReply()
+	features14.DPID = dpid
+	errorMsg := ofp10.NewErrorMsg()
+	errorMsg.Header = ofpxx.NewOfp10Header()
+	errorMsg.Header.Type = ofp10.Type_Error
+	errorMsg.Code = ofp10.HFC_EPERM
+	errorMsg.Header.Length = errorMsg.Len()
+
+	tests := []struct {
+		name string
+		// The versions offered by the controller.
+		versions []uint8
+		// What the switch sends, in order.
+		send []encoding.BinaryMarshaler
+		// The negotiated version, and the version of the
+		// features request, if one is sent.
+		version uint8
+		state   HandshakeState
+		failure HandshakeFailure
+	}{
+		{"highest shared version", []uint8{ofp10.VERSION, ofp13.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp10.VERSION, ofp13.VERSION), features14},
+			ofp13.VERSION, HandshakeActive, 0},
+		{"older switch", []uint8{ofp10.VERSION, ofp13.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp10.VERSION), features10},
+			ofp10.VERSION, HandshakeActive, 0},
+		{"no shared version", []uint8{ofp10.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp13.VERSION)},
+			0, HandshakeHelloSent, HandshakeVersionMismatch},
+		{"hello timeout", []uint8{ofp10.VERSION},
+			nil,
+			0, HandshakeHelloSent, HandshakeTimeout},
+		{"features timeout", []uint8{ofp10.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp10.VERSION)},
+			ofp10.VERSION, HandshakeFeaturesRequested, HandshakeTimeout},
+		{"switch error", []uint8{ofp10.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp10.VERSION), errorMsg},
+			ofp10.VERSION, HandshakeFeaturesRequested, HandshakeSwitchError},
+		{"messages before hello", []uint8{ofp10.VERSION},
+			[]encoding.BinaryMarshaler{ofp10.NewEchoRequest(), ofpxx.NewHelloVersions(ofp10.VERSION), features10},
+			ofp10.VERSION, HandshakeActive, 0},
+		{"messages before features", []uint8{ofp10.VERSION, ofp13.VERSION},
+			[]encoding.BinaryMarshaler{ofpxx.NewHelloVersions(ofp13.VERSION), ofp13.NewEchoRequest(),
+				ofpxx.NewHelloVersions(ofp10.VERSION), features14},
+			ofp13.VERSION, HandshakeActive, 0},
+	}
+	for _, test := range tests {
+		network = NewNetwork()
+		SupportedVersions = test.versions
+		client, server := net.Pipe()
+		h := &handshake{c: &Controller{}, stream: NewMessageStream(client)}
+
+		sent := make(chan []byte, 16)
+		go func() {
+			defer close(sent)
+			for {
+				b, err := readRaw(server)
+				if err != nil {
+					return
+				}
+				sent <- b
+			}
+		}()
+		go func() {
+			for _, msg := range test.send {
+				server.Write(marshal(t, msg))
+			}
+		}()
+
+		_, _, err := h.run()
+		if h.state != test.state {
+			t.Errorf("%s: ended in state %s, expected %s.", test.name, h.state, test.state)
+		}
+		if test.state == HandshakeActive {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: succeeded, expected %s.", test.name, test.failure)
+		} else if err.Failure != test.failure || err.State != test.state {
+			t.Errorf("%s: %s, expected %s in state %s.", test.name, err, test.failure, test.state)
+		}
+		if test.version != 0 && h.stream.Version != test.version {
+			t.Errorf("%s: negotiated version %d, expected %d.", test.name, h.stream.Version, test.version)
+		}
+
+		// The controller's Hello comes first, then a features
+		// request once a version is negotiated.
+		if b := <-sent; b == nil || b[1] != ofp10.Type_Hello {
+			t.Errorf("%s: didn't send a Hello first.", test.name)
+		}
+		if test.version != 0 {
+			b := <-sent
+			if b == nil || b[1] != ofp10.Type_FeaturesRequest || b[0] != test.version {
+				t.Errorf("%s: didn't request features with version %d.", test.name, test.version)
+			}
+		}
+
+		if sw, ok := Switch(dpid); ok {
+			closeSession(sw)
+		} else {
+			h.stream.Close()
+			<-h.stream.Done()
+		}
+		server.Close()
+		if b := <-sent; test.version == 0 && b != nil {
+			t.Errorf("%s: sent message type %d after the Hello.", test.name, b[1])
+		}
+	}
+}
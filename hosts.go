@@ -0,0 +1,396 @@
+package ogo
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/kv"
+	"github.com/jonstout/ogo/protocol/arp"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// Events published by the host tracker. The data of host.moved
+// is a HostMove, and of host.converged a HostMove with Converged
+// set. host.stale and host.down carry the Host.
+const (
+	EventHostAdded     = "host.added"
+	EventHostMoved     = "host.moved"
+	EventHostConverged = "host.converged"
+	EventHostStale     = "host.stale"
+	EventHostDown      = "host.down"
+)
+
+// The source MAC of ARP probes sent to silent hosts.
+var HostProbeMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+// A host attached to a switch port.
+type Host struct {
+	MAC      net.HardwareAddr
+	IP       net.IP
+	DPID     net.HardwareAddr
+	Port     uint16
+	LastSeen time.Time
+	// Probes sent since the host was last seen, and whether
+	// one of them went unanswered.
+	Probes int
+	Stale  bool
+}
+
+// A host seen at a new attachment point.
+type HostMove struct {
+	Host    Host
+	OldDPID net.HardwareAddr
+	OldPort uint16
+	// Time between the last packet at the old attachment
+	// point and the first at the new one.
+	Gap time.Duration
+	// Time taken to flush the old switch and send gratuitous
+	// ARPs after the move was detected.
+	Converged time.Duration
+}
+
+// Convergence statistics of host moves.
+type HostMoveStats struct {
+	Moves   uint64
+	Total   time.Duration
+	Max     time.Duration
+	Flushes uint64
+}
+
+// Returns the average convergence time of host moves.
+func (s HostMoveStats) Average() time.Duration {
+	if s.Moves == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Moves)
+}
+
+// A HostTracker learns where hosts are attached from the source
+// of packet-ins received on ports without links to other
+// switches.
+type HostTracker struct {
+	// When a host moves, delete flows towards it on the switch
+	// it left.
+	FlushOnMove bool
+	// When a host moves, send a gratuitous ARP for it from
+	// every edge port so L2 tables learn its new location.
+	// Only hosts with a known IP can be announced.
+	GratuitousARP bool
+	// Hosts silent for ProbeAfter are sent an ARP request every
+	// ProbeInterval, and removed once ProbeAttempts went
+	// unanswered. Hosts without a known IP can't be probed and
+	// are kept. No probes are sent if ProbeInterval is 0.
+	ProbeInterval time.Duration
+	ProbeAfter    time.Duration
+	ProbeAttempts int
+
+	mu    sync.RWMutex
+	hosts map[string]*Host
+	stats HostMoveStats
+	done  chan bool
+	// Where bindings are published for other applications,
+	// set by Attach.
+	store *kv.Namespace
+}
+
+// The tracker that fills the hosts of the topology.
+var hostTracker *HostTracker
+
+func NewHostTracker() *HostTracker {
+	t := new(HostTracker)
+	t.hosts = make(map[string]*Host)
+	t.ProbeInterval = time.Minute
+	t.ProbeAfter = time.Minute * 5
+	t.ProbeAttempts = 3
+	return t
+}
+
+// Starts tracking hosts from packet-ins received by c, and
+// probing silent hosts. The hosts are included in
+// CurrentTopology, and published in the "hosts" namespace of
+// c.Store by MAC when they are added, move or go down.
+func (t *HostTracker) Attach(c *Controller) {
+	hostTracker = t
+	if c.Store != nil {
+		t.store = c.Store.Namespace("hosts")
+	}
+	c.AddPacketInHandler("hosts", 1<<28, t)
+	if t.ProbeInterval > 0 {
+		t.done = make(chan bool)
+		go func() {
+			ticker := time.NewTicker(t.ProbeInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					t.ProbeSilent()
+				case <-t.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stops probing silent hosts.
+func (t *HostTracker) Stop() {
+	if t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+}
+
+// Returns every known host.
+func (t *HostTracker) Hosts() []Host {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	a := make([]Host, 0, len(t.hosts))
+	for _, h := range t.hosts {
+		a = append(a, *h)
+	}
+	return a
+}
+
+// Returns the host with mac.
+func (t *HostTracker) Host(mac net.HardwareAddr) (Host, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if h, ok := t.hosts[mac.String()]; ok {
+		return *h, true
+	}
+	return Host{}, false
+}
+
+func (t *HostTracker) MoveStats() HostMoveStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.stats
+}
+
+// Learns the source of pkt. Never consumes it.
+func (t *HostTracker) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	src := pkt.Data.HWSrc
+	// Ignore link discovery, multicast sources and packets
+	// received from other switches.
+	if pkt.Data.Ethertype == 0xa0f1 || pkt.Data.Ethertype == BDDPEthertype || len(src) != 6 || src[0]&1 != 0 {
+		return false
+	}
+	sw, ok := Switch(dpid)
+	if !ok || !sw.isEdgePort(pkt.InPort) {
+		return false
+	}
+
+	var ip net.IP
+	switch d := pkt.Data.Data.(type) {
+	case *arp.ARP:
+		ip = d.IPSrc
+	case *ipv4.IPv4:
+		ip = d.NWSrc
+	}
+	if ip != nil && (ip.IsUnspecified() || len(ip.To4()) != 4) {
+		ip = nil
+	}
+	t.learn(src, ip, dpid, pkt.InPort)
+	return false
+}
+
+func (t *HostTracker) learn(mac net.HardwareAddr, ip net.IP, dpid net.HardwareAddr, port uint16) {
+	now := time.Now()
+	t.mu.Lock()
+	if h, ok := t.hosts[mac.String()]; ok && h.DPID.String() == dpid.String() && h.Port == port {
+		if ip != nil {
+			h.IP = append(net.IP(nil), ip.To4()...)
+		}
+		h.LastSeen = now
+		h.Probes, h.Stale = 0, false
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	// The host is new or moved, a change of the topology.
+	topology.Lock()
+	t.mu.Lock()
+	h, ok := t.hosts[mac.String()]
+	if !ok {
+		h = &Host{MAC: append(net.HardwareAddr(nil), mac...), DPID: dpid, Port: port, LastSeen: now}
+		if ip != nil {
+			h.IP = append(net.IP(nil), ip.To4()...)
+		}
+		t.hosts[mac.String()] = h
+		host := *h
+		t.mu.Unlock()
+		topology.unlock(true)
+		t.publish(host, false)
+		Publish(EventHostAdded, dpid, host)
+		return
+	}
+	if ip != nil {
+		h.IP = append(net.IP(nil), ip.To4()...)
+	}
+	h.Probes, h.Stale = 0, false
+	if h.DPID.String() == dpid.String() && h.Port == port {
+		h.LastSeen = now
+		t.mu.Unlock()
+		topology.unlock(false)
+		return
+	}
+	move := HostMove{OldDPID: h.DPID, OldPort: h.Port, Gap: now.Sub(h.LastSeen)}
+	h.DPID = dpid
+	h.Port = port
+	h.LastSeen = now
+	move.Host = *h
+	t.mu.Unlock()
+	topology.unlock(true)
+
+	log.Println("Host", mac, "moved from", SwitchLabel(move.OldDPID), move.OldPort, "to", SwitchLabel(dpid), port)
+	t.publish(move.Host, false)
+	Publish(EventHostMoved, dpid, move)
+	go t.converge(move, now)
+}
+
+// Flushes the old attachment point of a moved host and announces
+// its new location.
+func (t *HostTracker) converge(move HostMove, detected time.Time) {
+	flushed := false
+	if t.FlushOnMove {
+		if sw, ok := Switch(move.OldDPID); ok {
+			if err := sw.deleteFlows(FlowMatch{EthDst: move.Host.MAC}); err == nil {
+				// Wait for the switch to apply the delete.
+				_, err = sw.SendAndReceive(sw.newBarrierRequest(), time.Second*2)
+				flushed = err == nil
+			}
+		}
+	}
+	if t.GratuitousARP && move.Host.IP != nil {
+		garp := gratuitousARP(move.Host.MAC, move.Host.IP)
+		at := SwitchPort{move.Host.DPID, move.Host.Port}
+		FloodDomain(BroadcastDomainOf(at.DPID, at.Port), at, garp)
+	}
+
+	move.Converged = time.Since(detected)
+	t.mu.Lock()
+	t.stats.Moves += 1
+	t.stats.Total += move.Converged
+	if move.Converged > t.stats.Max {
+		t.stats.Max = move.Converged
+	}
+	if flushed {
+		t.stats.Flushes += 1
+	}
+	t.mu.Unlock()
+	Publish(EventHostConverged, move.Host.DPID, move)
+}
+
+// Writes the binding of h to the store, or deletes it.
+func (t *HostTracker) publish(h Host, down bool) {
+	if t.store == nil {
+		return
+	}
+	var err error
+	if down {
+		err = t.store.Delete(h.MAC.String())
+	} else {
+		err = t.store.PutJSON(h.MAC.String(), h)
+	}
+	if err != nil {
+		log.Println("Failed to publish host", h.MAC, err)
+	}
+}
+
+// Sends an ARP request to every host silent for ProbeAfter. A
+// host whose previous probe went unanswered is marked stale, and
+// one that didn't answer ProbeAttempts probes is removed. The
+// reply, like any packet from the host, refreshes it.
+func (t *HostTracker) ProbeSilent() {
+	stale := make([]Host, 0)
+	down := make([]Host, 0)
+	probe := make([]Host, 0)
+	topology.Lock()
+	t.mu.Lock()
+	for key, h := range t.hosts {
+		if h.IP == nil || time.Since(h.LastSeen) < t.ProbeAfter {
+			continue
+		}
+		if h.Probes >= t.ProbeAttempts {
+			delete(t.hosts, key)
+			down = append(down, *h)
+			continue
+		}
+		if h.Probes > 0 && !h.Stale {
+			h.Stale = true
+			stale = append(stale, *h)
+		}
+		h.Probes += 1
+		probe = append(probe, *h)
+	}
+	t.mu.Unlock()
+	topology.unlock(len(down) > 0)
+
+	for _, h := range stale {
+		Publish(EventHostStale, h.DPID, h)
+	}
+	for _, h := range down {
+		log.Println("Host", h.MAC, "at", SwitchLabel(h.DPID), h.Port, "is down")
+		t.publish(h, true)
+		Publish(EventHostDown, h.DPID, h)
+	}
+	for _, h := range probe {
+		sw, ok := Switch(h.DPID)
+		if !ok || sw.Version() != ofp10.VERSION {
+			continue
+		}
+		out := ofp10.NewPacketOut()
+		out.Data = arpProbe(h.MAC, h.IP)
+		out.AddAction(ofp10.NewActionOutput(h.Port))
+		sw.Send(out)
+	}
+}
+
+// Returns an ARP request for ip sent to mac, from HostProbeMAC
+// and the unspecified address so hosts don't learn it.
+func arpProbe(mac net.HardwareAddr, ip net.IP) *eth.Ethernet {
+	a, _ := arp.New(arp.Type_Request)
+	copy(a.HWSrc, HostProbeMAC)
+	copy(a.IPDst, ip.To4())
+
+	e := eth.New()
+	copy(e.HWSrc, HostProbeMAC)
+	copy(e.HWDst, mac)
+	e.Ethertype = eth.ARP_MSG
+	e.Data = a
+	return e
+}
+
+// Returns a gratuitous ARP request announcing that ip is at mac.
+func gratuitousARP(mac net.HardwareAddr, ip net.IP) *eth.Ethernet {
+	a, _ := arp.New(arp.Type_Request)
+	copy(a.HWSrc, mac)
+	copy(a.IPSrc, ip.To4())
+	copy(a.IPDst, ip.To4())
+
+	e := eth.New()
+	copy(e.HWSrc, mac)
+	copy(e.HWDst, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	e.Ethertype = eth.ARP_MSG
+	e.Data = a
+	return e
+}
+
+// Returns true if no link to another switch is known on port,
+// and it isn't a tunnel or patch port.
+func (s *OFSwitch) isEdgePort(port uint16) bool {
+	if virtualPortType(s.dpid, port) != "" {
+		return false
+	}
+	for _, l := range s.Links() {
+		if l.Port == port {
+			return false
+		}
+	}
+	return true
+}
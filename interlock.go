@@ -0,0 +1,508 @@
+package ogo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// Destructive operations guarded by the Interlock.
+const (
+	OpDeleteAllFlows   = "delete-all-flows"
+	OpDisablePort      = "disable-port"
+	OpDisconnectSwitch = "disconnect-switch"
+	OpDrainSwitch      = "drain-switch"
+	// Draining a switch and then disconnecting it.
+	OpDrainDisconnect = "drain-disconnect-switch"
+	OpDrainPort       = "drain-port"
+	// Replacing or synchronizing the flows of a switch through
+	// /flowset.
+	OpImportFlows = "import-flows"
+	OpSyncFlows   = "sync-flows"
+	OpHandoff     = "handoff"
+	OpFlushQueue  = "flush-queue"
+	OpSetConfig   = "set-switch-config"
+)
+
+// How long a confirmation token stays valid.
+var ConfirmTimeout = time.Minute
+
+// The number of operation records kept.
+var MaxOperationRecords = 1000
+
+// The largest request body a guarded endpoint accepts.
+var MaxGuardedBody int64 = 16 << 20
+
+var errOperatorDisconnect = errors.New("Disconnected by an operator.")
+
+// A destructive operation requested through the ops API. Result
+// is "confirm" when a token was handed out, "refused" for a bad
+// token, "done", or the error the operation failed with.
+type OperationRecord struct {
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	DPID        string    `json:"dpid"`
+	Port        uint16    `json:"port,omitempty"`
+	User        string    `json:"user"`
+	Maintenance bool      `json:"maintenance"`
+	Result      string    `json:"result"`
+}
+
+// The state of maintenance mode.
+type Maintenance struct {
+	On     bool      `json:"on"`
+	Reason string    `json:"reason,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+// An Interlock guards the destructive endpoints of the ops API.
+// Outside maintenance mode a request is answered with 428 and a
+// confirmation token, and only runs when repeated with the token
+// in the confirm parameter within ConfirmTimeout. In maintenance
+// mode requests run at once. Every request is recorded.
+type Interlock struct {
+	mu          sync.Mutex
+	maintenance Maintenance
+	tokens      map[string]pendingOperation
+	records     []OperationRecord
+	// The clock tokens expire by.
+	now func() time.Time
+}
+
+type pendingOperation struct {
+	op   string
+	dpid string
+	port uint16
+	// A digest of the request the token confirms, if the
+	// operation has more to it than a switch and port.
+	detail  string
+	expires time.Time
+}
+
+func NewInterlock() *Interlock {
+	i := new(Interlock)
+	i.tokens = make(map[string]pendingOperation)
+	i.records = make([]OperationRecord, 0)
+	i.now = time.Now
+	return i
+}
+
+func (i *Interlock) Maintenance() Maintenance {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.maintenance
+}
+
+// Turns maintenance mode on or off, for the record.
+func (i *Interlock) SetMaintenance(on bool, reason, user string) {
+	i.mu.Lock()
+	if on {
+		i.maintenance = Maintenance{true, reason, user, time.Now()}
+	} else {
+		i.maintenance = Maintenance{}
+	}
+	i.mu.Unlock()
+	log.Println("Maintenance mode set to", on, "by", user+":", reason)
+}
+
+// Returns the recorded operations, oldest first.
+func (i *Interlock) Records() []OperationRecord {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]OperationRecord(nil), i.records...)
+}
+
+func (i *Interlock) record(r OperationRecord) {
+	log.Printf("Operation %s on %s port %d by %s: %s", r.Op, r.DPID, r.Port, r.User, r.Result)
+	i.mu.Lock()
+	i.records = append(i.records, r)
+	if len(i.records) > MaxOperationRecords {
+		i.records = i.records[len(i.records)-MaxOperationRecords:]
+	}
+	i.mu.Unlock()
+}
+
+// Decides whether an operation may run. Returns a new token, and
+// false, if it must be confirmed first.
+func (i *Interlock) check(op, dpid string, port uint16, detail, confirm string) (token string, ok bool, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := i.now()
+	for t, p := range i.tokens {
+		if now.After(p.expires) {
+			delete(i.tokens, t)
+		}
+	}
+	if i.maintenance.On {
+		return "", true, nil
+	}
+	if confirm != "" {
+		p, found := i.tokens[confirm]
+		if !found || p.op != op || p.dpid != dpid || p.port != port || p.detail != detail {
+			return "", false, errors.New("Bad or expired confirmation token.")
+		}
+		delete(i.tokens, confirm)
+		return "", true, nil
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	token = hex.EncodeToString(b)
+	i.tokens[token] = pendingOperation{op, dpid, port, detail, now.Add(ConfirmTimeout)}
+	return token, false, nil
+}
+
+// Runs the operation op of the request r on the switch and port
+// it names, once the interlock allows it. What run returns, if
+// not nil, is the JSON reply.
+func (i *Interlock) serve(w http.ResponseWriter, r *http.Request, op string, run func(sw *OFSwitch, port uint16) (interface{}, error)) {
+	q := r.URL.Query()
+	dpid, err := net.ParseMAC(q.Get("dpid"))
+	if err != nil {
+		http.Error(w, "bad dpid", http.StatusBadRequest)
+		return
+	}
+	var port uint16
+	if op == OpDisablePort || op == OpDrainPort {
+		n, err := strconv.ParseUint(q.Get("port"), 10, 16)
+		if err != nil {
+			http.Error(w, "bad port", http.StatusBadRequest)
+			return
+		}
+		port = uint16(n)
+	}
+	sw, ok := Switch(dpid)
+	if !ok {
+		http.Error(w, "no such switch", http.StatusNotFound)
+		return
+	}
+
+	rec := OperationRecord{Time: time.Now(), Op: op, DPID: dpid.String(), Port: port,
+		User: requestUser(r), Maintenance: i.Maintenance().On}
+	if !i.allow(w, rec, "", q.Get("confirm")) {
+		return
+	}
+	reply, err := run(sw, port)
+	if err != nil {
+		rec.Result = err.Error()
+		i.record(rec)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rec.Result = "done"
+	i.record(rec)
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// Decides whether the operation of rec may run. If not, records
+// it and answers w with a confirmation token or the refusal.
+func (i *Interlock) allow(w http.ResponseWriter, rec OperationRecord, detail, confirm string) bool {
+	token, allowed, err := i.check(rec.Op, rec.DPID, rec.Port, detail, confirm)
+	if err != nil {
+		rec.Result = "refused"
+		i.record(rec)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	if !allowed {
+		rec.Result = "confirm"
+		i.record(rec)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		json.NewEncoder(w).Encode(struct {
+			Op      string    `json:"op"`
+			Token   string    `json:"token"`
+			Expires time.Time `json:"expires"`
+		}{rec.Op, token, time.Now().Add(ConfirmTimeout)})
+		return false
+	}
+	return true
+}
+
+// Guards the requests of h that change switches: a request for
+// which op names an operation goes through the interlock like
+// those of the /switch endpoints, and only reaches h once
+// allowed. Its token is bound to the method, query and body of
+// the request, so the confirmation must repeat them unchanged.
+// Requests for which op returns "" reach h at once. Without an
+// interlock the guarded requests are refused.
+func (i *Interlock) guard(op func(r *http.Request) string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := op(r)
+		if name == "" {
+			h(w, r)
+			return
+		}
+		if i == nil {
+			http.Error(w, "no interlock to guard "+name, http.StatusForbidden)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxGuardedBody+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > MaxGuardedBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		q := r.URL.Query()
+		confirm := q.Get("confirm")
+		q.Del("confirm")
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s %s\n", r.Method, q.Encode())
+		sum.Write(body)
+
+		rec := OperationRecord{Time: time.Now(), Op: name, DPID: strings.Join(q["dpid"], ","),
+			User: requestUser(r), Maintenance: i.Maintenance().On}
+		if !i.allow(w, rec, hex.EncodeToString(sum.Sum(nil)), confirm) {
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sw := &statusWriter{w, http.StatusOK}
+		h(sw, r)
+		rec.Result = "done"
+		if sw.status >= 400 {
+			rec.Result = http.StatusText(sw.status)
+		}
+		i.record(rec)
+	}
+}
+
+// Remembers the status a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Returns who made r: the principal authenticated by the
+// AccessControl, the basic auth user if there is none, otherwise
+// the remote address.
+func requestUser(r *http.Request) string {
+	principals.Lock()
+	name, ok := principals.m[r]
+	principals.Unlock()
+	if ok {
+		return name
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// Serves the maintenance mode. GET returns it, POST sets it from
+// the on and reason parameters.
+//
+//	POST /maintenance?on=true&reason=replacing+core+switch
+func (i *Interlock) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			http.Error(w, "bad on", http.StatusBadRequest)
+			return
+		}
+		i.SetMaintenance(on, r.URL.Query().Get("reason"), requestUser(r))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(i.Maintenance())
+}
+
+func (i *Interlock) serveRecords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(i.Records())
+}
+
+// Deletes every flow of a switch.
+//
+//	DELETE /switch/flows?dpid=00:00:00:00:00:00:00:01&confirm=TOKEN
+func (i *Interlock) serveDeleteFlows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	i.serve(w, r, OpDeleteAllFlows, func(sw *OFSwitch, port uint16) (interface{}, error) {
+		return nil, sw.DeleteAllFlows()
+	})
+}
+
+// Takes a port down, or brings it up with up=true, which needs no
+// confirmation.
+//
+//	POST /switch/port?dpid=00:00:00:00:00:00:00:01&port=3&confirm=TOKEN
+func (i *Interlock) servePort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if up, _ := strconv.ParseBool(r.URL.Query().Get("up")); up {
+		dpid, err := net.ParseMAC(r.URL.Query().Get("dpid"))
+		n, err2 := strconv.ParseUint(r.URL.Query().Get("port"), 10, 16)
+		sw, ok := Switch(dpid)
+		if err != nil || err2 != nil || !ok {
+			http.Error(w, "bad dpid or port", http.StatusBadRequest)
+			return
+		}
+		if err := sw.SetPortDown(uint16(n), false); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	i.serve(w, r, OpDisablePort, func(sw *OFSwitch, port uint16) (interface{}, error) {
+		return nil, sw.SetPortDown(port, true)
+	})
+}
+
+// Closes the connection to a switch.
+//
+//	POST /switch/disconnect?dpid=00:00:00:00:00:00:00:01&confirm=TOKEN
+func (i *Interlock) serveDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	i.serve(w, r, OpDisconnectSwitch, func(sw *OFSwitch, port uint16) (interface{}, error) {
+		sw.Disconnect()
+		return nil, nil
+	})
+}
+
+// Drains a switch for maintenance, or with a port parameter a
+// port and the link on it, and replies with its DrainReport once
+// nothing is pinned to it or DrainTimeout passed. A drained port
+// is safe to unplug once its report is clear. With
+// disconnect=true the connection to a drained switch is then
+// closed, but only if nothing is pinned to it, unless force=true.
+// GET lists the drained switches and ports and DELETE returns one
+// to service, which need no confirmation.
+//
+//	POST /switch/drain?dpid=00:00:00:00:00:00:00:01&disconnect=true&confirm=TOKEN
+//	POST /switch/drain?dpid=00:00:00:00:00:00:00:01&port=3&confirm=TOKEN
+//	DELETE /switch/drain?dpid=00:00:00:00:00:00:00:01&port=3
+func (i *Interlock) serveDrain(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DrainReports())
+		return
+	case "DELETE":
+		dpid, err := net.ParseMAC(q.Get("dpid"))
+		if err != nil {
+			http.Error(w, "bad dpid", http.StatusBadRequest)
+			return
+		}
+		undrained := false
+		if q.Get("port") == "" {
+			undrained = UndrainSwitch(dpid)
+		} else {
+			n, err := strconv.ParseUint(q.Get("port"), 10, 16)
+			if err != nil {
+				http.Error(w, "bad port", http.StatusBadRequest)
+				return
+			}
+			undrained = UndrainPort(dpid, uint16(n))
+		}
+		if !undrained {
+			http.Error(w, "not drained", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "POST":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if q.Get("port") != "" {
+		i.serve(w, r, OpDrainPort, func(sw *OFSwitch, port uint16) (interface{}, error) {
+			return sw.DrainLink(port, DrainTimeout), nil
+		})
+		return
+	}
+	disconnect, _ := strconv.ParseBool(q.Get("disconnect"))
+	force, _ := strconv.ParseBool(q.Get("force"))
+	op := OpDrainSwitch
+	if disconnect {
+		op = OpDrainDisconnect
+	}
+	i.serve(w, r, op, func(sw *OFSwitch, port uint16) (interface{}, error) {
+		return sw.drainAndDisconnect(disconnect, force)
+	})
+}
+
+// Deletes every flow of Switch s, in every table.
+func (s *OFSwitch) DeleteAllFlows() error {
+	return s.deleteFlows(FlowMatch{})
+}
+
+// Takes port of Switch s administratively down, or brings it
+// back up.
+func (s *OFSwitch) SetPortDown(port uint16, down bool) error {
+	p, ok := s.Port(port)
+	if !ok {
+		return fmt.Errorf("Switch %s has no port %d.", s.DPID(), port)
+	}
+	if s.Version() == ofp10.VERSION {
+		m := ofp10.NewPortMod(int(port))
+		copy(m.HWAddr, p.HWAddr)
+		m.Mask = ofp10.PC_PORT_DOWN
+		if down {
+			m.Config = ofp10.PC_PORT_DOWN
+		}
+		return s.Send(m)
+	}
+	m := ofp14.NewPortMod(ofp14Port(port))
+	m.Header.Version = s.Version()
+	copy(m.HWAddr, p.HWAddr)
+	m.Mask = ofp14.PC_PORT_DOWN
+	if down {
+		m.Config = ofp14.PC_PORT_DOWN
+	}
+	return s.Send(m)
+}
+
+// Closes the connection to Switch s. The switch may connect
+// again.
+func (s *OFSwitch) Disconnect() {
+	s.downMu.Lock()
+	if s.downErr == nil {
+		s.downErr, s.downAt = errOperatorDisconnect, time.Now()
+	}
+	s.downMu.Unlock()
+	stream, _ := s.session()
+	stream.Close()
+}
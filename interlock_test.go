@@ -0,0 +1,114 @@
+package ogo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterlockTokens(t *testing.T) {
+	const (
+		// What a step results in.
+		token   = "token"
+		allowed = "allowed"
+		refused = "refused"
+		// Confirm values of steps.
+		none  = -1
+		bogus = -2
+	)
+	type step struct {
+		at     time.Duration
+		op     string
+		port   uint16
+		detail string
+		// The step whose token confirms this one.
+		confirm int
+		result  string
+	}
+	dpid := "00:00:00:00:00:00:00:01"
+
+	tests := []struct {
+		name        string
+		maintenance bool
+		steps       []step
+	}{
+		{"confirmed", false, []step{
+			{0, OpDisablePort, 1, "", none, token},
+			{time.Second, OpDisablePort, 1, "", 0, allowed},
+		}},
+		{"used once", false, []step{
+			{0, OpDisablePort, 1, "", none, token},
+			{0, OpDisablePort, 1, "", 0, allowed},
+			{0, OpDisablePort, 1, "", 0, refused},
+		}},
+		{"unknown token", false, []step{
+			{0, OpDisablePort, 1, "", bogus, refused},
+		}},
+		// A token only confirms the operation it was handed
+		// out for, and isn't used up by others.
+		{"other operation", false, []step{
+			{0, OpDisablePort, 1, "", none, token},
+			{0, OpDrainPort, 1, "", 0, refused},
+			{0, OpDisablePort, 2, "", 0, refused},
+			{0, OpDisablePort, 1, "", 0, allowed},
+		}},
+		{"other request", false, []step{
+			{0, OpImportFlows, 0, "a", none, token},
+			{0, OpImportFlows, 0, "b", 0, refused},
+			{0, OpImportFlows, 0, "a", 0, allowed},
+		}},
+		{"pending tokens", false, []step{
+			{0, OpDeleteAllFlows, 0, "", none, token},
+			{0, OpDeleteAllFlows, 0, "", none, token},
+			{0, OpDeleteAllFlows, 0, "", 1, allowed},
+			{0, OpDeleteAllFlows, 0, "", 0, allowed},
+		}},
+		{"expired", false, []step{
+			{0, OpDisconnectSwitch, 0, "", none, token},
+			{time.Minute + time.Nanosecond, OpDisconnectSwitch, 0, "", 0, refused},
+		}},
+		{"confirmed just in time", false, []step{
+			{0, OpDisconnectSwitch, 0, "", none, token},
+			{time.Minute, OpDisconnectSwitch, 0, "", 0, allowed},
+		}},
+		{"maintenance", true, []step{
+			{0, OpDeleteAllFlows, 0, "", none, allowed},
+			{0, OpDeleteAllFlows, 0, "", bogus, allowed},
+		}},
+	}
+	defer func(timeout time.Duration) { ConfirmTimeout = timeout }(ConfirmTimeout)
+	ConfirmTimeout = time.Minute
+	for _, test := range tests {
+		base := time.Unix(1000, 0)
+		now := base
+		i := NewInterlock()
+		i.now = func() time.Time { return now }
+		i.maintenance.On = test.maintenance
+
+		tokens := make([]string, len(test.steps))
+		for n, s := range test.steps {
+			now = base.Add(s.at)
+			confirm := ""
+			switch {
+			case s.confirm == bogus:
+				confirm = "bogus"
+			case s.confirm >= 0:
+				confirm = tokens[s.confirm]
+			}
+			tok, ok, err := i.check(s.op, dpid, s.port, s.detail, confirm)
+			tokens[n] = tok
+			result := allowed
+			switch {
+			case err != nil:
+				result = refused
+			case !ok:
+				result = token
+				if tok == "" {
+					t.Errorf("%s: step %d needs confirmation without a token.", test.name, n)
+				}
+			}
+			if result != s.result {
+				t.Errorf("%s: step %d was %s, expected %s.", test.name, n, result, s.result)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package kv is a small embedded key-value store that lets
+// cooperating applications share state. Each application writes
+// to its own namespace, and any application can read or watch
+// every namespace: a host tracker can publish the bindings it
+// learns while a firewall watches them.
+//
+// A Store opened with Open is saved to a file after every change
+// and loaded from it again at startup. One made by New only
+// lives in memory.
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Returned by Get for a missing key.
+var ErrNotFound = errors.New("No such key.")
+
+// A change of a key. Revision increases by one with every change
+// of the Store, so a watcher that sees a gap knows it missed
+// events and can read the keys again.
+type Event struct {
+	Namespace string
+	Key       string
+	// The new value, nil if the key was deleted.
+	Value    []byte
+	Deleted  bool
+	Revision uint64
+}
+
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	data     map[string]map[string][]byte
+	revision uint64
+	watches  map[*Watch]bool
+}
+
+// Returns an empty Store kept in memory.
+func New() *Store {
+	s := new(Store)
+	s.data = make(map[string]map[string][]byte)
+	s.watches = make(map[*Watch]bool)
+	return s
+}
+
+// Returns the Store saved in the file at path, or an empty Store
+// if the file doesn't exist. The Store is saved there after every
+// change.
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved struct {
+		Revision uint64
+		Data     map[string]map[string][]byte
+	}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	if saved.Data != nil {
+		s.data = saved.Data
+	}
+	s.revision = saved.Revision
+	return s, nil
+}
+
+// Returns the namespace called name. Namespaces are created by
+// their first write.
+func (s *Store) Namespace(name string) *Namespace {
+	return &Namespace{s, name}
+}
+
+// Returns the names of the namespaces holding keys, sorted.
+func (s *Store) Namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a := make([]string, 0, len(s.data))
+	for name := range s.data {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Returns the revision of the last change.
+func (s *Store) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+// Sets key of namespace to value, or deletes it if value is nil,
+// and notifies the watches. Called with s.mu locked.
+func (s *Store) set(namespace, key string, value []byte) error {
+	m := s.data[namespace]
+	if value == nil {
+		if _, ok := m[key]; !ok {
+			return nil
+		}
+		delete(m, key)
+		if len(m) == 0 {
+			delete(s.data, namespace)
+		}
+	} else {
+		if m == nil {
+			m = make(map[string][]byte)
+			s.data[namespace] = m
+		}
+		m[key] = append([]byte{}, value...)
+		// Watchers get their own copy.
+		value = append([]byte{}, value...)
+	}
+	s.revision += 1
+	e := Event{namespace, key, value, value == nil, s.revision}
+	for w := range s.watches {
+		w.send(e)
+	}
+	return s.save()
+}
+
+// Writes the store to a temporary file and renames it over the
+// file at s.path, so a crash leaves either version whole.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(struct {
+		Revision uint64
+		Data     map[string]map[string][]byte
+	}{s.revision, s.data})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// The keys of one application. Values are opaque bytes; PutJSON
+// and GetJSON encode them as JSON.
+type Namespace struct {
+	store *Store
+	name  string
+}
+
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Returns a copy of the value of key.
+func (n *Namespace) Get(key string) ([]byte, error) {
+	n.store.mu.RLock()
+	defer n.store.mu.RUnlock()
+	v, ok := n.store.data[n.name][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, v...), nil
+}
+
+// Sets key to value. The change is kept in memory even if the
+// Store fails to save it.
+func (n *Namespace) Put(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+	return n.store.set(n.name, key, value)
+}
+
+// Deletes key, if it exists.
+func (n *Namespace) Delete(key string) error {
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+	return n.store.set(n.name, key, nil)
+}
+
+// Decodes the JSON value of key into v.
+func (n *Namespace) GetJSON(key string, v interface{}) error {
+	b, err := n.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Sets key to v encoded as JSON.
+func (n *Namespace) PutJSON(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return n.Put(key, b)
+}
+
+// Returns the keys starting with prefix, sorted.
+func (n *Namespace) Keys(prefix string) []string {
+	n.store.mu.RLock()
+	defer n.store.mu.RUnlock()
+	a := make([]string, 0)
+	for k := range n.store.data[n.name] {
+		if strings.HasPrefix(k, prefix) {
+			a = append(a, k)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Watches changes of the keys starting with prefix. buffer is
+// the size of the watch's channel.
+func (n *Namespace) Watch(buffer int, prefix string) *Watch {
+	w := new(Watch)
+	w.c = make(chan Event, buffer)
+	w.C = w.c
+	w.namespace = n.name
+	w.prefix = prefix
+	w.store = n.store
+	n.store.mu.Lock()
+	n.store.watches[w] = true
+	n.store.mu.Unlock()
+	return w
+}
+
+// A Watch receives changes of keys on C. Like event bus
+// subscriptions, changes are dropped, not queued, if C is full;
+// Missed counts them.
+type Watch struct {
+	C         <-chan Event
+	c         chan Event
+	namespace string
+	prefix    string
+	store     *Store
+	missed    uint64
+}
+
+// Called with the store locked.
+func (w *Watch) send(e Event) {
+	if e.Namespace != w.namespace || !strings.HasPrefix(e.Key, w.prefix) {
+		return
+	}
+	select {
+	case w.c <- e:
+	default:
+		w.missed += 1
+	}
+}
+
+// Returns the number of changes dropped because C was full.
+func (w *Watch) Missed() uint64 {
+	w.store.mu.RLock()
+	defer w.store.mu.RUnlock()
+	return w.missed
+}
+
+// Stops the watch and closes C.
+func (w *Watch) Cancel() {
+	w.store.mu.Lock()
+	if w.store.watches[w] {
+		delete(w.store.watches, w)
+		close(w.c)
+	}
+	w.store.mu.Unlock()
+}
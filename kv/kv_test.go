@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPutGetDelete(t *testing.T) {
+	s := New()
+	n := s.Namespace("hosts")
+	if _, err := n.Get("a"); err != ErrNotFound {
+		t.Fatalf("Get of a missing key returned %v", err)
+	}
+	if err := n.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := n.Get("a")
+	if err != nil || string(v) != "1" {
+		t.Fatalf("Get returned %q, %v", v, err)
+	}
+	if _, err := s.Namespace("firewall").Get("a"); err != ErrNotFound {
+		t.Error("Namespaces share keys")
+	}
+	n.Delete("a")
+	if _, err := n.Get("a"); err != ErrNotFound {
+		t.Error("Deleted key still present")
+	}
+	if len(s.Namespaces()) != 0 {
+		t.Errorf("Empty namespace kept: %v", s.Namespaces())
+	}
+}
+
+func TestKeys(t *testing.T) {
+	n := New().Namespace("hosts")
+	for _, k := range []string{"mac/2", "ip/1", "mac/1"} {
+		n.Put(k, []byte{})
+	}
+	keys := n.Keys("mac/")
+	if len(keys) != 2 || keys[0] != "mac/1" || keys[1] != "mac/2" {
+		t.Errorf("Keys returned %v", keys)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := New()
+	n := s.Namespace("hosts")
+	w := s.Namespace("hosts").Watch(2, "mac/")
+	defer w.Cancel()
+
+	n.Put("ip/1", []byte("x"))
+	s.Namespace("other").Put("mac/1", []byte("x"))
+	n.Put("mac/1", []byte("a"))
+	n.Put("mac/1", []byte{})
+	n.Delete("mac/1")
+
+	e := <-w.C
+	if e.Key != "mac/1" || string(e.Value) != "a" || e.Deleted || e.Revision != 3 {
+		t.Errorf("Unexpected first event %+v", e)
+	}
+	e = <-w.C
+	if e.Value == nil || e.Deleted || e.Revision != 4 {
+		t.Errorf("An empty value was seen as a delete: %+v", e)
+	}
+	if w.Missed() != 1 {
+		t.Errorf("Missed %d changes, expected 1", w.Missed())
+	}
+	w.Cancel()
+	if _, ok := <-w.C; ok {
+		t.Error("C not closed by Cancel")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := s.Namespace("hosts")
+	if err := n.PutJSON("mac/1", map[string]int{"port": 3}); err != nil {
+		t.Fatal(err)
+	}
+	n.Put("mac/2", []byte("x"))
+	n.Delete("mac/2")
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]int
+	if err := s.Namespace("hosts").GetJSON("mac/1", &v); err != nil || v["port"] != 3 {
+		t.Errorf("Reopened store returned %v, %v", v, err)
+	}
+	if _, err := s.Namespace("hosts").Get("mac/2"); err != ErrNotFound {
+		t.Error("Deleted key came back")
+	}
+	if s.Revision() != 3 {
+		t.Errorf("Revision %d after reopening, expected 3", s.Revision())
+	}
+}
@@ -0,0 +1,388 @@
+package ogo
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Published when a LatencyBudget is violated and when it is met
+// again, with the LatencyReport of the window as data.
+const (
+	EventLatencyViolated = "latency.violated"
+	EventLatencyMet      = "latency.met"
+)
+
+// The cookie of the coarse rules a LatencyBudget installs to shed
+// load.
+var SheddingCookie uint64 = 0x74 << 56
+
+const sheddingCookieMask = 0xff00000000000000
+
+// The budgets being enforced, which reactive latencies are
+// recorded for.
+var budgets = struct {
+	sync.RWMutex
+	m map[*LatencyBudget]bool
+}{m: make(map[*LatencyBudget]bool)}
+
+// Records that a flow mod answering pkt, a packet-in from Switch
+// dpid, was sent, and how long after pkt was received. Punter's
+// Install calls it; reactive applications installing their own
+// flows should call it too, before their handler returns.
+func ReactiveFlowModSent(dpid net.HardwareAddr, pkt util.Message) {
+	t, ok := MessageTime(pkt)
+	if !ok {
+		return
+	}
+	d := stampNow().Sub(t)
+	budgets.RLock()
+	defer budgets.RUnlock()
+	for b := range budgets.m {
+		b.record(d)
+	}
+}
+
+// A LatencyBudget enforces an objective for the latency of
+// reactive forwarding: from receiving a packet-in to sending the
+// flow mod answering it, see ReactiveFlowModSent. Every Window,
+// the Percentile of the latencies must be under Budget, for
+// example 10ms at 0.99.
+//
+// When a window violates the budget, the budget logs and
+// publishes diagnostics: the queues of the switches, garbage
+// collector pauses, and the packet-in handlers taking longest. If
+// Shed is set, it also installs the coarse rules Shed gives on
+// every switch, so fewer packets reach the controller, until
+// RecoverWindows windows in a row haven't violated the budget.
+type LatencyBudget struct {
+	Budget     time.Duration
+	Percentile float64
+	Window     time.Duration
+	// Windows with fewer latencies aren't judged.
+	MinSamples int
+	// Returns the coarse rules shedding load from Switch sw,
+	// usually low priority rules forwarding whole classes of
+	// traffic without asking the controller. Their cookie is
+	// set to SheddingCookie.
+	Shed           func(sw *OFSwitch) []*Recipe
+	RecoverWindows int
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	start    time.Time
+	violated bool
+	met      int
+	shed     map[string][]*Recipe
+	handlers map[string]PacketInStats
+	last     LatencyReport
+	stop     chan bool
+}
+
+// The latencies of one window and, if it violated the budget,
+// what the controller was doing.
+type LatencyReport struct {
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	Samples    int           `json:"samples"`
+	Percentile float64       `json:"percentile"`
+	Latency    time.Duration `json:"latency_ns"`
+	Max        time.Duration `json:"max_ns"`
+	Budget     time.Duration `json:"budget_ns"`
+	Violated   bool          `json:"violated"`
+	// Switches shedding load.
+	Shedding    []string            `json:"shedding,omitempty"`
+	Diagnostics *LatencyDiagnostics `json:"diagnostics,omitempty"`
+}
+
+type LatencyDiagnostics struct {
+	// The queues of switches with messages waiting.
+	Queues       []SwitchQueue `json:"queues"`
+	SlowSwitches []string      `json:"slow_switches"`
+	// Garbage collector pauses that ended in the window.
+	GCPauses   []time.Duration `json:"gc_pauses_ns"`
+	Goroutines int             `json:"goroutines"`
+	// Packet-in handlers by their average time in the window,
+	// slowest first.
+	Handlers []HandlerLatency `json:"handlers"`
+}
+
+type HandlerLatency struct {
+	Name    string        `json:"name"`
+	Handled uint64        `json:"handled"`
+	Average time.Duration `json:"average_ns"`
+}
+
+type handlersBySlowest []HandlerLatency
+
+func (a handlersBySlowest) Len() int           { return len(a) }
+func (a handlersBySlowest) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a handlersBySlowest) Less(i, j int) bool { return a[i].Average > a[j].Average }
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+
+// The most packet-in handlers listed in diagnostics.
+const diagnosedHandlers = 5
+
+func NewLatencyBudget(budget time.Duration, percentile float64) *LatencyBudget {
+	b := new(LatencyBudget)
+	b.Budget = budget
+	b.Percentile = percentile
+	b.Window = time.Second * 10
+	b.MinSamples = 20
+	b.RecoverWindows = 3
+	b.shed = make(map[string][]*Recipe)
+	b.handlers = make(map[string]PacketInStats)
+	b.start = time.Now()
+	b.stop = make(chan bool, 1)
+	return b
+}
+
+func (b *LatencyBudget) Start() {
+	RegisterFlowOwner("shedding", SheddingCookie, sheddingCookieMask)
+	b.mu.Lock()
+	b.start = time.Now()
+	b.mu.Unlock()
+	budgets.Lock()
+	budgets.m[b] = true
+	budgets.Unlock()
+	go func() {
+		ticker := time.NewTicker(b.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				b.Evaluate()
+			}
+		}
+	}()
+}
+
+// Stops enforcing the budget and removes the rules shedding load.
+func (b *LatencyBudget) Stop() {
+	budgets.Lock()
+	delete(budgets.m, b)
+	budgets.Unlock()
+	select {
+	case b.stop <- true:
+	default:
+	}
+	b.restore()
+}
+
+func (b *LatencyBudget) record(d time.Duration) {
+	b.mu.Lock()
+	b.samples = append(b.samples, d)
+	b.mu.Unlock()
+}
+
+// Returns the report of the last window.
+func (b *LatencyBudget) Report() LatencyReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// Returns true if the budget is violated, from the window that
+// violated it until RecoverWindows windows in a row haven't.
+func (b *LatencyBudget) Violated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.violated
+}
+
+// Judges the latencies recorded since the last call, and starts
+// a new window. Start calls it every Window.
+func (b *LatencyBudget) Evaluate() LatencyReport {
+	now := time.Now()
+	b.mu.Lock()
+	samples := b.samples
+	b.samples = nil
+	rep := LatencyReport{Start: b.start, End: now, Samples: len(samples), Percentile: b.Percentile,
+		Budget: b.Budget}
+	b.start = now
+	b.mu.Unlock()
+
+	if len(samples) > 0 {
+		sort.Sort(durations(samples))
+		i := int(b.Percentile*float64(len(samples))+0.5) - 1
+		if i < 0 {
+			i = 0
+		} else if i >= len(samples) {
+			i = len(samples) - 1
+		}
+		rep.Latency = samples[i]
+		rep.Max = samples[len(samples)-1]
+	}
+	rep.Violated = len(samples) >= b.MinSamples && rep.Latency > b.Budget
+	handlers := b.handlerLatencies()
+	if rep.Violated {
+		rep.Diagnostics = diagnose(rep.Start, handlers)
+	}
+
+	b.mu.Lock()
+	first := rep.Violated && !b.violated
+	recovered := false
+	if rep.Violated {
+		b.violated, b.met = true, 0
+	} else if b.violated {
+		// Shedding may leave too few latencies to judge.
+		b.met += 1
+		if b.met >= b.RecoverWindows {
+			b.violated, recovered = false, true
+		}
+	}
+	b.mu.Unlock()
+
+	if rep.Violated {
+		log.Printf("Reactive latency %s at p%g is over the budget of %s (%d samples)",
+			rep.Latency, b.Percentile*100, b.Budget, rep.Samples)
+		if first && b.Shed != nil {
+			b.shedLoad()
+		}
+	} else if recovered {
+		log.Printf("Reactive latency %s at p%g is within the budget of %s again",
+			rep.Latency, b.Percentile*100, b.Budget)
+		b.restore()
+	}
+	rep.Shedding = b.shedding()
+
+	b.mu.Lock()
+	b.last = rep
+	b.mu.Unlock()
+	if rep.Violated {
+		Publish(EventLatencyViolated, nil, rep)
+	} else if recovered {
+		Publish(EventLatencyMet, nil, rep)
+	}
+	return rep
+}
+
+// Returns the time each packet-in handler spent per packet-in
+// since the last call.
+func (b *LatencyBudget) handlerLatencies() []HandlerLatency {
+	stats := packetIns.stats()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := make([]HandlerLatency, 0)
+	for _, s := range stats {
+		prev := b.handlers[s.Name]
+		b.handlers[s.Name] = s
+		if s.Handled <= prev.Handled {
+			continue
+		}
+		n := s.Handled - prev.Handled
+		a = append(a, HandlerLatency{s.Name, n, (s.Total - prev.Total) / time.Duration(n)})
+	}
+	sort.Sort(handlersBySlowest(a))
+	if len(a) > diagnosedHandlers {
+		a = a[:diagnosedHandlers]
+	}
+	return a
+}
+
+// Collects what the controller was doing in a window that started
+// at start.
+func diagnose(start time.Time, handlers []HandlerLatency) *LatencyDiagnostics {
+	d := &LatencyDiagnostics{Queues: make([]SwitchQueue, 0), SlowSwitches: make([]string, 0),
+		GCPauses: make([]time.Duration, 0), Goroutines: runtime.NumGoroutine(), Handlers: handlers}
+	for _, q := range Queues() {
+		if q.Pending() > 0 {
+			d.Queues = append(d.Queues, q)
+		}
+	}
+	for _, sw := range SlowSwitches() {
+		d.SlowSwitches = append(d.SlowSwitches, sw.DPID().String())
+	}
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	for i, end := range gc.PauseEnd {
+		if end.Before(start) || i >= len(gc.Pause) {
+			break
+		}
+		d.GCPauses = append(d.GCPauses, gc.Pause[i])
+	}
+	return d
+}
+
+// Installs the coarse rules of Shed on every connected switch
+// that has none.
+func (b *LatencyBudget) shedLoad() {
+	for _, sw := range Switches() {
+		dpid := sw.DPID().String()
+		b.mu.Lock()
+		_, done := b.shed[dpid]
+		b.mu.Unlock()
+		if done || !sw.connected() {
+			continue
+		}
+		rules := b.Shed(sw)
+		installed := make([]*Recipe, 0, len(rules))
+		for _, r := range rules {
+			r.Cookie = SheddingCookie
+			if err := sw.InstallRecipe(r); err != nil {
+				log.Println("Failed to install shedding rule on", SwitchLabel(sw.DPID()), err)
+				continue
+			}
+			installed = append(installed, r)
+		}
+		log.Println("Shedding load from", SwitchLabel(sw.DPID()), "with", len(installed), "rules")
+		b.mu.Lock()
+		b.shed[dpid] = installed
+		b.mu.Unlock()
+	}
+}
+
+// Removes the rules shedding load.
+func (b *LatencyBudget) restore() {
+	b.mu.Lock()
+	shed := b.shed
+	b.shed = make(map[string][]*Recipe)
+	b.mu.Unlock()
+	for dpid, rules := range shed {
+		mac, _ := net.ParseMAC(dpid)
+		sw, ok := Switch(mac)
+		if !ok {
+			continue
+		}
+		for _, r := range rules {
+			if err := sw.RemoveRecipe(r); err != nil {
+				log.Println("Failed to remove shedding rule from", SwitchLabel(mac), err)
+				break
+			}
+		}
+	}
+}
+
+// Returns the switches shedding load, in order.
+func (b *LatencyBudget) shedding() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := make([]string, 0, len(b.shed))
+	for dpid := range b.shed {
+		a = append(a, dpid)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Serves the report of the last window as JSON.
+func (b *LatencyBudget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.Report())
+}
@@ -0,0 +1,255 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+var ErrUnknownLease = errors.New("No lease with that id.")
+
+var leaseId uint64
+
+// A Lease keeps a rule installed on a switch for as long as it
+// is renewed. Once it expires the rule is removed.
+type Lease struct {
+	Id      string
+	DPID    net.HardwareAddr
+	Expires time.Time
+	// Sent to the switch when the lease expires or is released.
+	Remove util.Message
+	// The leased rule, if installed with InstallFlowRule. Its
+	// delete is compiled for the version the switch speaks when
+	// the lease ends, instead of Remove.
+	rule *FlowRule
+}
+
+// A LeaseManager removes rules whose leases aren't renewed, so
+// rules pushed by an orchestrator that went away don't stay
+// installed forever.
+//
+// A lease that ends while its switch is disconnected stays
+// pending, since the switch usually keeps its flows, and its rule
+// is removed when the switch comes back. Pending removals are
+// also retried every interval, in case the switch.up event was
+// dropped.
+type LeaseManager struct {
+	mu      sync.Mutex
+	leases  map[string]*Lease
+	pending map[string]*Lease
+	stop    chan bool
+	sub     *Subscription
+}
+
+// Returns a LeaseManager that checks for expired leases every
+// interval.
+func NewLeaseManager(interval time.Duration) *LeaseManager {
+	m := new(LeaseManager)
+	m.leases = make(map[string]*Lease)
+	m.pending = make(map[string]*Lease)
+	m.stop = make(chan bool, 1)
+	m.sub = Subscribe(64, EventSwitchUp)
+	go m.expireLoop(interval)
+	return m
+}
+
+// Sends add to Switch dpid and leases it for ttl. remove is sent
+// to the switch when the lease ends.
+func (m *LeaseManager) Install(dpid net.HardwareAddr, add, remove util.Message, ttl time.Duration) (*Lease, error) {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return nil, fmt.Errorf("Unknown switch %s.", dpid)
+	}
+	if err := sw.Send(add); err != nil {
+		return nil, err
+	}
+	return m.add(dpid, remove, ttl), nil
+}
+
+func (m *LeaseManager) add(dpid net.HardwareAddr, remove util.Message, ttl time.Duration) *Lease {
+	l := &Lease{
+		Id:      fmt.Sprintf("%d", atomic.AddUint64(&leaseId, 1)),
+		DPID:    dpid,
+		Expires: time.Now().Add(ttl),
+		Remove:  remove,
+	}
+	m.mu.Lock()
+	m.leases[l.Id] = l
+	m.mu.Unlock()
+	return l
+}
+
+// Installs flow f on OpenFlow 1.0 Switch dpid with a lease of
+// ttl. The flow is removed with a strict delete when the lease
+// ends. Use InstallFlowRule for switches of any version.
+func (m *LeaseManager) InstallFlowMod(dpid net.HardwareAddr, f *ofp10.FlowMod, ttl time.Duration) (*Lease, error) {
+	if sw, ok := Switch(dpid); ok && sw.Version() != ofp10.VERSION {
+		return nil, fmt.Errorf("Switch %s doesn't speak OpenFlow 1.0.", dpid)
+	}
+	del := ofp10.NewFlowMod()
+	del.Match = f.Match
+	del.Priority = f.Priority
+	del.Command = ofp10.FC_DELETE_STRICT
+	return m.Install(dpid, f, del, ttl)
+}
+
+// Installs rule r on Switch dpid with a lease of ttl. The rule is
+// removed with a strict delete when the lease ends.
+func (m *LeaseManager) InstallFlowRule(dpid net.HardwareAddr, r *FlowRule, ttl time.Duration) (*Lease, error) {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return nil, fmt.Errorf("Unknown switch %s.", dpid)
+	}
+	if err := sw.InstallFlowRule(r); err != nil {
+		return nil, err
+	}
+	l := m.add(dpid, nil, ttl)
+	l.rule = r
+	return l, nil
+}
+
+// Extends lease id to ttl from now.
+func (m *LeaseManager) Renew(id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.leases[id]
+	if !ok {
+		return ErrUnknownLease
+	}
+	l.Expires = time.Now().Add(ttl)
+	return nil
+}
+
+// Ends lease id now and removes its rule. If the switch of the
+// lease is disconnected the rule is removed when it comes back,
+// and the error is returned.
+func (m *LeaseManager) Release(id string) error {
+	m.mu.Lock()
+	l, ok := m.leases[id]
+	delete(m.leases, id)
+	m.mu.Unlock()
+	if !ok {
+		return ErrUnknownLease
+	}
+	return m.end(l)
+}
+
+// Returns a copy of every ended lease whose rule is waiting for
+// its switch to come back.
+func (m *LeaseManager) Pending() []Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]Lease, 0, len(m.pending))
+	for _, l := range m.pending {
+		a = append(a, *l)
+	}
+	return a
+}
+
+// Returns a copy of every active lease.
+func (m *LeaseManager) Leases() []Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]Lease, 0, len(m.leases))
+	for _, l := range m.leases {
+		a = append(a, *l)
+	}
+	return a
+}
+
+// Stops checking for expired leases. Rules still leased, or
+// pending removal, stay installed.
+func (m *LeaseManager) Stop() {
+	m.sub.Cancel()
+	select {
+	case m.stop <- true:
+	default:
+	}
+}
+
+func (m *LeaseManager) expireLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case e, ok := <-m.sub.C:
+			if !ok {
+				return
+			}
+			m.retry(e.DPID)
+		case now := <-ticker.C:
+			expired := make([]*Lease, 0)
+			m.mu.Lock()
+			for id, l := range m.leases {
+				if now.After(l.Expires) {
+					expired = append(expired, l)
+					delete(m.leases, id)
+				}
+			}
+			m.mu.Unlock()
+			for _, l := range expired {
+				log.Println("Lease expired:", l.Id, l.DPID)
+				if err := m.end(l); err != nil {
+					log.Println("Failed to remove leased rule:", l.Id, err)
+				}
+			}
+			m.retry(nil)
+		}
+	}
+}
+
+// Removes the rule of ended lease l, or keeps l pending if its
+// switch is disconnected.
+func (m *LeaseManager) end(l *Lease) error {
+	err := l.remove()
+	if err == ErrSwitchDisconnected || err == errLeaseSwitchGone {
+		m.mu.Lock()
+		m.pending[l.Id] = l
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Removes the rules of pending leases on Switch dpid, or on every
+// connected switch if dpid is nil.
+func (m *LeaseManager) retry(dpid net.HardwareAddr) {
+	leases := make([]*Lease, 0)
+	m.mu.Lock()
+	for id, l := range m.pending {
+		if dpid == nil || l.DPID.String() == dpid.String() {
+			leases = append(leases, l)
+			delete(m.pending, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, l := range leases {
+		err := m.end(l)
+		if err == nil {
+			log.Println("Removed rule of ended lease:", l.Id, l.DPID)
+		} else if err != ErrSwitchDisconnected && err != errLeaseSwitchGone {
+			log.Println("Failed to remove leased rule:", l.Id, err)
+		}
+	}
+}
+
+var errLeaseSwitchGone = errors.New("The switch of the lease isn't connected.")
+
+func (l *Lease) remove() error {
+	sw, ok := Switch(l.DPID)
+	if !ok || !sw.connected() {
+		return errLeaseSwitchGone
+	}
+	if l.rule != nil {
+		return sw.RemoveFlowRule(l.rule)
+	}
+	return sw.Send(l.Remove)
+}
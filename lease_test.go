@@ -0,0 +1,117 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Adds a switch connected over one end of a pipe and returns the
+// other end.
+func testSwitch(dpid net.HardwareAddr) (*OFSwitch, net.Conn) {
+	client, server := net.Pipe()
+	stream := NewMessageStream(client)
+	stream.Version = ofp10.VERSION
+	sw := &OFSwitch{dpid: dpid, stream: stream, ports: make(map[uint16]ofp10.PhyPort)}
+	sw.portSnap.Store(sw.ports)
+	shard := network.shard(dpid)
+	shard.Lock()
+	shard.set(dpid.String(), sw)
+	shard.Unlock()
+	return sw, server
+}
+
+// Reads the bytes of the next message sent to the switch at the
+// other end of conn.
+func readRaw(conn net.Conn) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	b = append(b, make([]byte, int(binary.BigEndian.Uint16(b[2:]))-8)...)
+	if _, err := io.ReadFull(conn, b[8:]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reads the next message sent to the switch at the other end of
+// conn.
+func readMessage(conn net.Conn) (util.Message, error) {
+	b, err := readRaw(conn)
+	if err != nil {
+		return nil, err
+	}
+	// The parsers of later versions only know the messages switches
+	// send, so the modifications are decoded here.
+	var msg util.Message
+	switch {
+	case b[0] == ofp10.VERSION:
+		msg, err := parseMessage(nil, b)
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case b[1] == ofp14.Type_FlowMod:
+		msg = ofp14.NewFlowMod()
+	case b[1] == ofp14.Type_GroupMod:
+		msg = new(ofp14.GroupMod)
+	default:
+		codec, _ := ofp.CodecFor(b[0])
+		msg, err := parseMessage(codec, b)
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	if err := msg.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func TestLeasePendingRemoval(t *testing.T) {
+	network = NewNetwork()
+	m := NewLeaseManager(time.Hour)
+	defer m.Stop()
+
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	del := ofp10.NewFlowMod()
+	del.Command = ofp10.FC_DELETE_STRICT
+	l := m.add(dpid, del, time.Hour)
+	if err := m.Release(l.Id); err == nil {
+		t.Fatal("Released a lease on a disconnected switch.")
+	}
+	if p := m.Pending(); len(p) != 1 || p[0].Id != l.Id {
+		t.Fatalf("Got pending leases %v.", p)
+	}
+	if err := m.Release(l.Id); err != ErrUnknownLease {
+		t.Errorf("Released an ended lease: %v", err)
+	}
+
+	_, conn := testSwitch(dpid)
+	defer conn.Close()
+	Publish(EventSwitchUp, dpid, nil)
+	header := make([]byte, 8)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(header); err != nil {
+		t.Fatal(err)
+	}
+	if header[1] != ofp10.Type_FlowMod {
+		t.Errorf("Switch received message type %d, expected a flow mod.", header[1])
+	}
+	for i := 0; len(m.Pending()) > 0; i++ {
+		if i == 100 {
+			t.Fatal("The removed lease is still pending.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -11,4 +11,26 @@ type Link struct {
 	Port      uint16
 	Latency   time.Duration
 	Bandwidth int
+	// When link discovery last saw the link.
+	Updated time.Time
+	// True if the link's liveness is tracked with BFD rather
+	// than link discovery timeouts.
+	BFD bool
+	// True if the link was only discovered by BDDP, so it
+	// crosses a legacy L2 cloud rather than a cable.
+	Indirect bool
 }
+
+// How long a link can go unseen by link discovery before it is
+// considered down.
+var LinkTimeout = time.Second * 6
+
+// The ethertype of broadcast domain discovery (BDDP) probes.
+// They are sent to the broadcast address, so legacy switches
+// between two OpenFlow switches flood them instead of consuming
+// them like LLDP, and the links through those switches are
+// discovered as indirect links.
+const BDDPEthertype = 0x8999
+
+// Set to false to stop sending BDDP probes.
+var DiscoverIndirectLinks = true
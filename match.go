@@ -0,0 +1,255 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// Selects the traffic a rule applies to, independent of the
+// OpenFlow version of the switch. Zero valued fields match
+// everything. IPSrcMask and IPDstMask make IPSrc and IPDst match
+// a subnet; without them the addresses match exactly. TPSrc and
+// TPDst are TCP or UDP ports, by IPProto. VLAN is the id of the
+// outermost VLAN tag.
+type FlowMatch struct {
+	InPort    uint16
+	EthSrc    net.HardwareAddr
+	EthDst    net.HardwareAddr
+	EthType   uint16
+	VLAN      uint16
+	IPSrc     net.IP
+	IPSrcMask net.IPMask
+	IPDst     net.IP
+	IPDstMask net.IPMask
+	IPProto   uint8
+	TPSrc     uint16
+	TPDst     uint16
+
+	// Set by LaterFragments.
+	laterFragments bool
+}
+
+func (m FlowMatch) ofp10() ofp10.Match {
+	match := *ofp10.NewMatch()
+	if m.InPort != 0 {
+		match.InPort = m.InPort
+		match.Wildcards &^= ofp10.FW_IN_PORT
+	}
+	if m.EthSrc != nil {
+		copy(match.DLSrc, m.EthSrc)
+		match.Wildcards &^= ofp10.FW_DL_SRC
+	}
+	if m.EthDst != nil {
+		copy(match.DLDst, m.EthDst)
+		match.Wildcards &^= ofp10.FW_DL_DST
+	}
+	if m.VLAN != 0 {
+		match.DLVLAN = m.VLAN
+		match.Wildcards &^= ofp10.FW_DL_VLAN
+	}
+	if m.EthType != 0 {
+		match.DLType = m.EthType
+		match.Wildcards &^= ofp10.FW_DL_TYPE
+	} else if m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0 {
+		match.DLType = 0x0800
+		match.Wildcards &^= ofp10.FW_DL_TYPE
+	}
+	if m.IPSrc != nil {
+		copy(match.NWSrc, maskIP(m.IPSrc, m.IPSrcMask))
+		match.Wildcards &^= ofp10.FW_NW_SRC_MASK
+		match.Wildcards |= wildBits(m.IPSrcMask) << ofp10.FW_NW_SRC_SHIFT
+	}
+	if m.IPDst != nil {
+		copy(match.NWDst, maskIP(m.IPDst, m.IPDstMask))
+		match.Wildcards &^= ofp10.FW_NW_DST_MASK
+		match.Wildcards |= wildBits(m.IPDstMask) << ofp10.FW_NW_DST_SHIFT
+	}
+	if m.IPProto != 0 {
+		match.NWProto = m.IPProto
+		match.Wildcards &^= ofp10.FW_NW_PROTO
+	}
+	if m.TPSrc != 0 {
+		match.TPSrc = m.TPSrc
+		match.Wildcards &^= ofp10.FW_TP_SRC
+	}
+	if m.TPDst != 0 {
+		match.TPDst = m.TPDst
+		match.Wildcards &^= ofp10.FW_TP_DST
+	}
+	if m.laterFragments {
+		match.TPSrc, match.TPDst = 0, 0
+		match.Wildcards &^= ofp10.FW_TP_SRC | ofp10.FW_TP_DST
+	}
+	return match
+}
+
+func (m FlowMatch) ofp14() ofp14.Match {
+	match := *ofp14.NewMatch()
+	if m.InPort != 0 {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(m.InPort))
+		match.AddField(ofp14.XMT_OFB_IN_PORT, b)
+	}
+	if m.EthSrc != nil {
+		match.AddField(ofp14.XMT_OFB_ETH_SRC, m.EthSrc)
+	}
+	if m.EthDst != nil {
+		match.AddField(ofp14.XMT_OFB_ETH_DST, m.EthDst)
+	}
+	if m.VLAN != 0 {
+		match.AddField(ofp14.XMT_OFB_VLAN_VID, vidField(m.VLAN))
+	}
+	ethType := m.EthType
+	if ethType == 0 && (m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0) {
+		// IP fields require an ethertype prerequisite.
+		ethType = 0x0800
+	}
+	if ethType != 0 {
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, ethType)
+		match.AddField(ofp14.XMT_OFB_ETH_TYPE, b)
+	}
+	if m.IPSrc != nil {
+		addIPField(&match, ofp14.XMT_OFB_IPV4_SRC, m.IPSrc, m.IPSrcMask)
+	}
+	if m.IPDst != nil {
+		addIPField(&match, ofp14.XMT_OFB_IPV4_DST, m.IPDst, m.IPDstMask)
+	}
+	if m.IPProto != 0 {
+		match.AddField(ofp14.XMT_OFB_IP_PROTO, []byte{m.IPProto})
+	}
+	src, dst := uint8(ofp14.XMT_OFB_TCP_SRC), uint8(ofp14.XMT_OFB_TCP_DST)
+	if m.IPProto == 0x11 { // UDP
+		src, dst = ofp14.XMT_OFB_UDP_SRC, ofp14.XMT_OFB_UDP_DST
+	}
+	if m.laterFragments {
+		match.AddField(src, []byte{0, 0})
+		match.AddField(dst, []byte{0, 0})
+	} else {
+		b := make([]byte, 2)
+		if m.TPSrc != 0 {
+			binary.BigEndian.PutUint16(b, m.TPSrc)
+			match.AddField(src, b)
+		}
+		if m.TPDst != 0 {
+			binary.BigEndian.PutUint16(b, m.TPDst)
+			match.AddField(dst, b)
+		}
+	}
+	return match
+}
+
+// Returns the IPv4 address ip with the bits outside mask cleared.
+func maskIP(ip net.IP, mask net.IPMask) net.IP {
+	if mask == nil {
+		return ip.To4()
+	}
+	return ip.To4().Mask(mask)
+}
+
+// Returns the number of low bits an OpenFlow 1.0 match wildcards
+// for IPv4 mask.
+func wildBits(mask net.IPMask) uint32 {
+	if mask == nil {
+		return 0
+	}
+	ones, _ := mask.Size()
+	return uint32(32 - ones)
+}
+
+func addIPField(match *ofp14.Match, field uint8, ip net.IP, mask net.IPMask) {
+	if ones, _ := mask.Size(); mask == nil || ones == 32 {
+		match.AddField(field, ip.To4())
+	} else {
+		match.AddMaskedField(field, maskIP(ip, mask), []byte(mask[len(mask)-4:]))
+	}
+}
+
+// Installs a flow on Switch s sending traffic matching m out
+// ports, or deletes it if ports is empty. Ports use OpenFlow 1.0
+// numbering; reserved ports such as ofp10.P_CONTROLLER are
+// translated for newer switches.
+func (s *OFSwitch) installOutputs(m FlowMatch, priority uint16, ports []uint16) error {
+	if s.Version() == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Match = m.ofp10()
+		f.Priority = priority
+		if len(ports) == 0 {
+			f.Command = ofp10.FC_DELETE_STRICT
+		}
+		for _, p := range ports {
+			f.AddAction(ofp10.NewActionOutput(p))
+		}
+		return s.Send(f)
+	}
+
+	f := ofp14.NewFlowMod()
+	f.Header.Version = s.Version()
+	f.Match = m.ofp14()
+	f.Priority = priority
+	if len(ports) == 0 {
+		f.Command = ofp14.FC_DELETE_STRICT
+	} else {
+		actions := ofp14.NewInstrApplyActions()
+		for _, p := range ports {
+			actions.AddAction(ofp14.NewActionOutput(ofp14Port(p)))
+		}
+		f.AddInstruction(actions)
+	}
+	return s.Send(f)
+}
+
+// Installs a flow on Switch s dropping traffic matching m.
+func (s *OFSwitch) installDrop(m FlowMatch, priority uint16) error {
+	if s.Version() == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Match = m.ofp10()
+		f.Priority = priority
+		return s.Send(f)
+	}
+	f := ofp14.NewFlowMod()
+	f.Header.Version = s.Version()
+	f.Match = m.ofp14()
+	f.Priority = priority
+	return s.Send(f)
+}
+
+// Converts an OpenFlow 1.0 port number to OpenFlow 1.4.
+func ofp14Port(p uint16) uint32 {
+	if p > ofp10.P_MAX {
+		return 0xffff0000 | uint32(p)
+	}
+	return uint32(p)
+}
+
+// Converts an OpenFlow 1.4 port number to OpenFlow 1.0.
+// Ports beyond the range of OpenFlow 1.0 become P_NONE.
+func ofp10Port(p uint32) uint16 {
+	if p > 0xffff0000 {
+		return uint16(p)
+	}
+	if p >= ofp10.P_MAX {
+		return ofp10.P_NONE
+	}
+	return uint16(p)
+}
+
+// Deletes every flow on Switch s whose match is at least as
+// specific as m, whatever its priority.
+func (s *OFSwitch) deleteFlows(m FlowMatch) error {
+	if s.Version() == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Command = ofp10.FC_DELETE
+		f.Match = m.ofp10()
+		return s.Send(f)
+	}
+	f := ofp14.NewFlowMod()
+	f.Header.Version = s.Version()
+	f.Command = ofp14.FC_DELETE
+	f.TableId = 0xff // All tables
+	f.Match = m.ofp14()
+	return s.Send(f)
+}
@@ -2,16 +2,34 @@ package ogo
 
 import (
 	"encoding/binary"
+	"errors"
 	"net"
 	"time"
 )
 
+// The body of a link discovery probe. Probes of older controllers
+// end after Nsec; newer ones carry the instance ID of the
+// controller that sent them, the port they were sent out and, if
+// a DiscoveryKey is set, a token signing the probe.
 type LinkDiscovery struct {
 	SrcDPID net.HardwareAddr
 	Nsec    int64 /* Number of nanoseconds elapsed since Jan 1, 1970. */
+	ControllerID uint64
+	SrcPort uint16
+	// HMAC-SHA256 of the fields above, all zeros if unsigned.
+	Token   []byte
+	// True if the probe had no controller ID and token.
+	Legacy  bool
 	pad     []byte
 }
 
+// The lengths of probes without and with a controller ID and
+// token.
+const (
+	legacyDiscoveryLen = 22
+	discoveryLen       = 58
+)
+
 func NewLinkDiscovery() *LinkDiscovery {
 	d := new(LinkDiscovery)
 	d.SrcDPID = make([]byte, 8)
@@ -20,7 +38,10 @@ func NewLinkDiscovery() *LinkDiscovery {
 }
 
 func (d *LinkDiscovery) Len() uint16 {
-	return 22
+	if d.Legacy {
+		return legacyDiscoveryLen
+	}
+	return discoveryLen
 }
 
 func (d *LinkDiscovery) MarshalBinary() (data []byte, err error) {
@@ -31,14 +52,37 @@ func (d *LinkDiscovery) MarshalBinary() (data []byte, err error) {
 	next += len(d.SrcDPID)
 	binary.BigEndian.PutUint64(data[next:], uint64(d.Nsec))
 	next += 8
+	if d.Legacy {
+		return
+	}
+	binary.BigEndian.PutUint64(data[next:], d.ControllerID)
+	next += 8
+	binary.BigEndian.PutUint16(data[next:], d.SrcPort)
+	next += 2
+	copy(data[next:], d.Token)
 	return
 }
 
 func (d *LinkDiscovery) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a link discovery probe.")
+	}
 	next := 0
 	copy(d.SrcDPID, data[next:])
 	next += len(d.SrcDPID)
 	d.Nsec = int64(binary.BigEndian.Uint64(data[next:]))
 	next += 8
+	// Older probes are padded to 22 bytes.
+	d.Legacy = len(data) < discoveryLen
+	if d.Legacy {
+		d.ControllerID, d.SrcPort, d.Token = 0, 0, nil
+		return nil
+	}
+	d.ControllerID = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	d.SrcPort = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	d.Token = make([]byte, 32)
+	copy(d.Token, data[next:])
 	return nil
 }
@@ -0,0 +1,66 @@
+package mininet
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jonstout/ogo"
+)
+
+// How often Wait functions poll the controller.
+var PollInterval = time.Millisecond * 100
+
+// Blocks until at least n switches are connected to the
+// controller running in this process.
+func WaitForSwitches(n int, timeout time.Duration) error {
+	return waitFor(timeout, func() error {
+		if c := len(ogo.Switches()); c < n {
+			return fmt.Errorf("%d of %d switches connected.", c, n)
+		}
+		return nil
+	})
+}
+
+// Blocks until at least n unidirectional links have been
+// discovered.
+func WaitForLinks(n int, timeout time.Duration) error {
+	return waitFor(timeout, func() error {
+		if c := len(ogo.CurrentTopology().Links); c < n {
+			return fmt.Errorf("%d of %d links discovered.", c, n)
+		}
+		return nil
+	})
+}
+
+// Checks that the topology t has exactly the given number of
+// switches and unidirectional links.
+func AssertTopology(t *ogo.Topology, switches, links int) error {
+	if len(t.Switches) != switches {
+		return fmt.Errorf("Topology has %d switches, expected %d.", len(t.Switches), switches)
+	}
+	if len(t.Links) != links {
+		return fmt.Errorf("Topology has %d links, expected %d.", len(t.Links), links)
+	}
+	return nil
+}
+
+// Checks that the topology t has a link from src to dst.
+func AssertLink(t *ogo.Topology, src, dst string) error {
+	for _, l := range t.Links {
+		if l.Src == src && l.Dst == dst {
+			return nil
+		}
+	}
+	return fmt.Errorf("Topology has no link from %s to %s.", src, dst)
+}
+
+func waitFor(timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(PollInterval)
+	}
+}
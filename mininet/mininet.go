@@ -0,0 +1,232 @@
+// Package mininet starts Mininet topologies and drives them
+// through the Mininet CLI, so a controller can be checked end to
+// end against emulated switches and hosts. Mininet must be
+// installed and the caller must be allowed to run it, which
+// usually means running as root.
+package mininet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Printed after every command so the end of its output can be
+// found.
+const sentinel = "__ogo_mininet_done__"
+
+var ErrTimeout = errors.New("Timed out waiting for the Mininet CLI.")
+
+// Options used to start Mininet.
+type Options struct {
+	// Path to the mn binary. Defaults to "mn".
+	Command string
+	// A Mininet topology, for example "single,3", "linear,4" or
+	// "tree,depth=2,fanout=2".
+	Topo string
+	// Address of the controller as host:port.
+	Controller string
+	// Switch type, defaults to "ovsk".
+	Switch string
+	// OpenFlow protocols the switches offer, for example
+	// "OpenFlow10,OpenFlow14". Empty leaves the switch default.
+	Protocols string
+	// Extra arguments passed to mn.
+	Args []string
+	// How long to wait for a CLI command. Defaults to 30s.
+	Timeout time.Duration
+}
+
+// A running Mininet network.
+type Net struct {
+	opts  Options
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+	mu    sync.Mutex
+}
+
+// Cleans up any previous Mininet run, then starts a new one
+// with opts and waits for its CLI.
+func Start(opts Options) (*Net, error) {
+	if opts.Command == "" {
+		opts.Command = "mn"
+	}
+	if opts.Switch == "" {
+		opts.Switch = "ovsk"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second * 30
+	}
+	exec.Command(opts.Command, "-c").Run()
+
+	sw := opts.Switch
+	if opts.Protocols != "" {
+		sw += ",protocols=" + opts.Protocols
+	}
+	args := []string{"--switch", sw}
+	if opts.Topo != "" {
+		args = append(args, "--topo", opts.Topo)
+	}
+	if opts.Controller != "" {
+		host, port := opts.Controller, "6633"
+		if i := strings.LastIndex(opts.Controller, ":"); i >= 0 {
+			host, port = opts.Controller[:i], opts.Controller[i+1:]
+		}
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		args = append(args, "--controller", "remote,ip="+host+",port="+port)
+	}
+	args = append(args, opts.Args...)
+
+	n := new(Net)
+	n.opts = opts
+	n.cmd = exec.Command(opts.Command, args...)
+	n.lines = make(chan string, 256)
+
+	var err error
+	if n.stdin, err = n.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	out, err := n.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	n.cmd.Stderr = n.cmd.Stdout
+	if err = n.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go n.read(out)
+
+	// Mininet only reads commands once the network is up.
+	if _, err = n.Cmd(""); err != nil {
+		n.Stop()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Net) read(r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		n.lines <- strings.TrimPrefix(s.Text(), "mininet> ")
+	}
+	close(n.lines)
+}
+
+// Runs cmd in the Mininet CLI and returns its output.
+func (n *Net) Cmd(cmd string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, err := fmt.Fprintf(n.stdin, "%s\nsh echo %s\n", cmd, sentinel); err != nil {
+		return "", err
+	}
+	out := make([]string, 0)
+	deadline := time.After(n.opts.Timeout)
+	for {
+		select {
+		case l, ok := <-n.lines:
+			if !ok {
+				return strings.Join(out, "\n"), errors.New("Mininet exited.")
+			}
+			if strings.Contains(l, sentinel) {
+				return strings.Join(out, "\n"), nil
+			}
+			out = append(out, l)
+		case <-deadline:
+			return strings.Join(out, "\n"), ErrTimeout
+		}
+	}
+}
+
+// Runs cmd on host, for example n.Host("h1", "ifconfig").
+func (n *Net) Host(host, cmd string) (string, error) {
+	return n.Cmd(host + " " + cmd)
+}
+
+var pingRe = regexp.MustCompile(`(\d+) packets transmitted, (\d+) received`)
+
+// Sends count pings from host src to host dst and returns the
+// number of replies.
+func (n *Net) Ping(src, dst string, count int) (int, error) {
+	out, err := n.Cmd(fmt.Sprintf("%s ping -c %d -W 1 %s", src, count, dst))
+	if err != nil {
+		return 0, err
+	}
+	m := pingRe.FindStringSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("Unexpected ping output: %s", out)
+	}
+	return strconv.Atoi(m[2])
+}
+
+var pingAllRe = regexp.MustCompile(`Results: (\d+)% dropped`)
+
+// Pings between every pair of hosts and returns the percentage of
+// pings that were dropped.
+func (n *Net) PingAll() (int, error) {
+	out, err := n.Cmd("pingall")
+	if err != nil {
+		return 100, err
+	}
+	m := pingAllRe.FindStringSubmatch(out)
+	if m == nil {
+		return 100, fmt.Errorf("Unexpected pingall output: %s", out)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// Returns the names of the hosts in the network.
+func (n *Net) Hosts() ([]string, error) {
+	return n.nodes("py ' '.join(h.name for h in net.hosts)")
+}
+
+// Returns the names of the switches in the network.
+func (n *Net) Switches() ([]string, error) {
+	return n.nodes("py ' '.join(s.name for s in net.switches)")
+}
+
+func (n *Net) nodes(cmd string) ([]string, error) {
+	out, err := n.Cmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// Sets the link between nodes a and b up or down.
+func (n *Net) SetLink(a, b string, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	_, err := n.Cmd(fmt.Sprintf("link %s %s %s", a, b, state))
+	return err
+}
+
+// Exits the Mininet CLI and cleans up the network.
+func (n *Net) Stop() error {
+	fmt.Fprintln(n.stdin, "exit")
+	n.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- n.cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(n.opts.Timeout):
+		n.cmd.Process.Kill()
+		err = ErrTimeout
+	}
+	exec.Command(n.opts.Command, "-c").Run()
+	return err
+}
@@ -0,0 +1,139 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// A MirrorSession copies traffic matching Match on Switch DPID
+// to MirrorPort. The original traffic is forwarded out
+// ForwardPorts, or through the switch's normal L2 pipeline if
+// ForwardPorts is empty, which requires Open vSwitch or another
+// hybrid switch.
+type MirrorSession struct {
+	Name         string
+	DPID         net.HardwareAddr
+	Match        FlowMatch
+	Priority     uint16
+	ForwardPorts []uint16
+	MirrorPort   uint16
+	// If not zero, mirrored copies are tagged with this VLAN so
+	// they can be carried to a remote collector (RSPAN).
+	VLAN uint16
+}
+
+// Manages mirror sessions.
+type Mirrors struct {
+	mu       sync.Mutex
+	sessions map[string]*MirrorSession
+}
+
+func NewMirrors() *Mirrors {
+	m := new(Mirrors)
+	m.sessions = make(map[string]*MirrorSession)
+	return m
+}
+
+// Installs mirror session s, replacing any session with the same
+// name.
+func (m *Mirrors) Add(s MirrorSession) error {
+	sw, ok := Switch(s.DPID)
+	if !ok {
+		return fmt.Errorf("Unknown switch %s.", s.DPID)
+	}
+	m.mu.Lock()
+	old, ok := m.sessions[s.Name]
+	m.sessions[s.Name] = &s
+	m.mu.Unlock()
+	if ok {
+		removeMirror(old)
+	}
+
+	if sw.Version() == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Match = s.Match.ofp10()
+		f.Priority = s.Priority
+		if len(s.ForwardPorts) == 0 {
+			f.AddAction(ofp10.NewActionOutput(ofp10.P_NORMAL))
+		}
+		for _, p := range s.ForwardPorts {
+			f.AddAction(ofp10.NewActionOutput(p))
+		}
+		// Actions run in order, so the tag only applies to
+		// the mirrored copy.
+		if s.VLAN != 0 {
+			f.AddAction(ofp10.NewActionVLANVID(s.VLAN))
+		}
+		f.AddAction(ofp10.NewActionOutput(s.MirrorPort))
+		return sw.Send(f)
+	}
+
+	f := ofp14.NewFlowMod()
+	f.Header.Version = sw.Version()
+	f.Match = s.Match.ofp14()
+	f.Priority = s.Priority
+	actions := ofp14.NewInstrApplyActions()
+	if len(s.ForwardPorts) == 0 {
+		actions.AddAction(ofp14.NewActionOutput(ofp14.P_NORMAL))
+	}
+	for _, p := range s.ForwardPorts {
+		actions.AddAction(ofp14.NewActionOutput(uint32(p)))
+	}
+	if s.VLAN != 0 {
+		vid := make([]byte, 2)
+		binary.BigEndian.PutUint16(vid, s.VLAN|0x1000) // OFPVID_PRESENT
+		actions.AddAction(ofp14.NewActionPushVlan(0x8100))
+		actions.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_VLAN_VID, vid))
+	}
+	actions.AddAction(ofp14.NewActionOutput(uint32(s.MirrorPort)))
+	f.AddInstruction(actions)
+	return sw.Send(f)
+}
+
+// Removes the mirror session called name.
+func (m *Mirrors) Remove(name string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[name]
+	delete(m.sessions, name)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("No mirror session named %s.", name)
+	}
+	return removeMirror(s)
+}
+
+// Returns every mirror session.
+func (m *Mirrors) Sessions() []MirrorSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]MirrorSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		a = append(a, *s)
+	}
+	return a
+}
+
+func removeMirror(s *MirrorSession) error {
+	sw, ok := Switch(s.DPID)
+	if !ok {
+		return fmt.Errorf("Unknown switch %s.", s.DPID)
+	}
+	if sw.Version() == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Command = ofp10.FC_DELETE_STRICT
+		f.Match = s.Match.ofp10()
+		f.Priority = s.Priority
+		return sw.Send(f)
+	}
+	f := ofp14.NewFlowMod()
+	f.Header.Version = sw.Version()
+	f.Command = ofp14.FC_DELETE_STRICT
+	f.Match = s.Match.ofp14()
+	f.Priority = s.Priority
+	return sw.Send(f)
+}
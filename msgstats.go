@@ -0,0 +1,162 @@
+package ogo
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// How often per switch message rates are recomputed.
+var MessageRateInterval = time.Second * 10
+
+// Counts of one OpenFlow message type exchanged with a switch.
+// Rates are per second over the last MessageRateInterval.
+type MessageStat struct {
+	Type         string
+	Sent         uint64
+	Received     uint64
+	SentRate     float64
+	ReceivedRate float64
+}
+
+// Message counters of a MessageStream, indexed by the message
+// type in the OpenFlow header.
+type messageCounts struct {
+	sent     [256]uint64
+	received [256]uint64
+	// Messages waiting in Outbound.
+	queued [256]int64
+
+	mu           sync.Mutex
+	last         time.Time
+	lastSent     [256]uint64
+	lastReceived [256]uint64
+	sentRate     [256]float64
+	receivedRate [256]float64
+}
+
+func newMessageCounts() *messageCounts {
+	c := new(messageCounts)
+	c.last = time.Now()
+	return c
+}
+
+func (c *messageCounts) countSent(t uint8) {
+	atomic.AddUint64(&c.sent[t], 1)
+	// Messages queued without OFSwitch.write, such as those of
+	// the handshake, weren't counted as queued.
+	for {
+		n := atomic.LoadInt64(&c.queued[t])
+		if n <= 0 || atomic.CompareAndSwapInt64(&c.queued[t], n, n-1) {
+			break
+		}
+	}
+}
+
+func (c *messageCounts) countReceived(t uint8) {
+	atomic.AddUint64(&c.received[t], 1)
+}
+
+// Recomputes rates from the counts since the last update.
+func (c *messageCounts) updateRates(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	secs := now.Sub(c.last).Seconds()
+	if secs <= 0 {
+		return
+	}
+	for t := range c.sent {
+		sent := atomic.LoadUint64(&c.sent[t])
+		received := atomic.LoadUint64(&c.received[t])
+		c.sentRate[t] = float64(sent-c.lastSent[t]) / secs
+		c.receivedRate[t] = float64(received-c.lastReceived[t]) / secs
+		c.lastSent[t] = sent
+		c.lastReceived[t] = received
+	}
+	c.last = now
+}
+
+// Returns the counts of every message type exchanged with Switch
+// s, in order of type.
+func (s *OFSwitch) MessageStats() []MessageStat {
+	stream, _ := s.session()
+	c := stream.counts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := make([]MessageStat, 0)
+	for t := range c.sent {
+		sent := atomic.LoadUint64(&c.sent[t])
+		received := atomic.LoadUint64(&c.received[t])
+		if sent == 0 && received == 0 {
+			continue
+		}
+		a = append(a, MessageStat{messageTypeName(s.Version(), uint8(t)),
+			sent, received, c.sentRate[t], c.receivedRate[t]})
+	}
+	return a
+}
+
+var messageRatesOnce sync.Once
+
+// Periodically updates the message rates of every switch.
+func startMessageRates() {
+	messageRatesOnce.Do(func() {
+		go func() {
+			for now := range time.Tick(MessageRateInterval) {
+				for _, sw := range Switches() {
+					stream, _ := sw.session()
+					stream.counts.updateRates(now)
+				}
+			}
+		}()
+	})
+}
+
+var ofp10MessageTypes = []string{
+	"hello", "error", "echo_request", "echo_reply", "vendor",
+	"features_request", "features_reply", "get_config_request",
+	"get_config_reply", "set_config", "packet_in", "flow_removed",
+	"port_status", "packet_out", "flow_mod", "port_mod",
+	"stats_request", "stats_reply", "barrier_request",
+	"barrier_reply", "queue_get_config_request",
+	"queue_get_config_reply",
+}
+
+var ofp13MessageTypes = []string{
+	"hello", "error", "echo_request", "echo_reply", "experimenter",
+	"features_request", "features_reply", "get_config_request",
+	"get_config_reply", "set_config", "packet_in", "flow_removed",
+	"port_status", "packet_out", "flow_mod", "group_mod", "port_mod",
+	"table_mod", "multipart_request", "multipart_reply",
+	"barrier_request", "barrier_reply", "queue_get_config_request",
+	"queue_get_config_reply", "role_request", "role_reply",
+	"get_async_request", "get_async_reply", "set_async", "meter_mod",
+	"role_status", "table_status", "requestforward", "bundle_control",
+	"bundle_add_message", "controller_status",
+}
+
+// Returns the name of message type t in OpenFlow version.
+func messageTypeName(version, t uint8) string {
+	names := ofp13MessageTypes
+	if version == ofp10.VERSION {
+		names = ofp10MessageTypes
+	}
+	if int(t) < len(names) {
+		return names[t]
+	}
+	return "unknown"
+}
+
+// Returns statistics of the writes to the main connection of
+// Switch s.
+func (s *OFSwitch) WriteStats() WriteStats {
+	stream, _ := s.session()
+	w := stream.writes
+	return WriteStats{
+		atomic.LoadUint64(&w.Writes),
+		atomic.LoadUint64(&w.Messages),
+		atomic.LoadUint64(&w.Bytes),
+	}
+}
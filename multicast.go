@@ -0,0 +1,305 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Priority of the flows installed for multicast groups. IGMP is
+// sent to the controller one above it.
+var MulticastPriority uint16 = 0x9000
+
+// IGMP message types.
+const (
+	igmpV1Report = 0x12
+	igmpV2Report = 0x16
+	igmpV2Leave  = 0x17
+	igmpV3Report = 0x22
+)
+
+// Multicast snoops IGMP joins and leaves and forwards each group
+// along a tree connecting the switches with members. The tree is
+// shared by all senders: every switch on it sends group traffic
+// out each of its tree and member ports except the one it
+// arrived on. OpenFlow 1.0 switches get a flow with an output
+// action per port, newer switches an ALL group with a bucket per
+// port. Trees are rebuilt when membership or the topology
+// changes.
+//
+// IGMP is only snooped from OpenFlow 1.0 packet-ins. Members on
+// newer switches can be added with Join.
+type Multicast struct {
+	mu sync.Mutex
+	// Member ports by group, then DPID.
+	groups map[string]map[string]map[uint16]bool
+	// Switches with flows installed for each group.
+	installed map[string]map[string]bool
+	sub       *Subscription
+}
+
+func NewMulticast() *Multicast {
+	m := new(Multicast)
+	m.groups = make(map[string]map[string]map[uint16]bool)
+	m.installed = make(map[string]map[string]bool)
+	return m
+}
+
+// Starts snooping IGMP packet-ins on c and following topology
+// changes.
+func (m *Multicast) Attach(c *Controller) {
+	c.AddPacketInHandler("multicast", 1<<20, m)
+	m.sub = Subscribe(64, "switch.", "link.")
+	for _, sw := range Switches() {
+		m.punt(sw)
+	}
+	go m.loop()
+}
+
+func (m *Multicast) Stop() {
+	if m.sub != nil {
+		m.sub.Cancel()
+	}
+}
+
+// Adds port of Switch dpid as a member of group.
+func (m *Multicast) Join(group net.IP, dpid net.HardwareAddr, port uint16) {
+	m.mu.Lock()
+	members, ok := m.groups[group.String()]
+	if !ok {
+		members = make(map[string]map[uint16]bool)
+		m.groups[group.String()] = members
+	}
+	if _, ok := members[dpid.String()]; !ok {
+		members[dpid.String()] = make(map[uint16]bool)
+	}
+	changed := !members[dpid.String()][port]
+	members[dpid.String()][port] = true
+	m.mu.Unlock()
+	if changed {
+		m.update(group.String())
+	}
+}
+
+// Removes port of Switch dpid from group.
+func (m *Multicast) Leave(group net.IP, dpid net.HardwareAddr, port uint16) {
+	m.mu.Lock()
+	ports := m.groups[group.String()][dpid.String()]
+	changed := ports[port]
+	delete(ports, port)
+	if ports != nil && len(ports) == 0 {
+		delete(m.groups[group.String()], dpid.String())
+	}
+	if len(m.groups[group.String()]) == 0 {
+		delete(m.groups, group.String())
+	}
+	m.mu.Unlock()
+	if changed {
+		m.update(group.String())
+	}
+}
+
+// Returns the groups with members.
+func (m *Multicast) Groups() []net.IP {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]net.IP, 0, len(m.groups))
+	for g := range m.groups {
+		a = append(a, net.ParseIP(g))
+	}
+	return a
+}
+
+func (m *Multicast) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok || pkt.Data.Ethertype != eth.IPv4_MSG || ip.Protocol != ipv4.Type_IGMP {
+		return false
+	}
+	buf, ok := ip.Data.(*util.Buffer)
+	if !ok || buf.Len() < 8 {
+		return true
+	}
+	data := buf.Bytes()
+	switch data[0] {
+	case igmpV1Report, igmpV2Report:
+		m.Join(net.IP(data[4:8]), dpid, pkt.InPort)
+	case igmpV2Leave:
+		m.Leave(net.IP(data[4:8]), dpid, pkt.InPort)
+	case igmpV3Report:
+		m.handleV3Report(dpid, pkt.InPort, data)
+	}
+	return true
+}
+
+// IGMPv3 reports carry a record per group. A record excluding no
+// sources is a join and one including no sources is a leave.
+func (m *Multicast) handleV3Report(dpid net.HardwareAddr, port uint16, data []byte) {
+	records := int(binary.BigEndian.Uint16(data[6:]))
+	n := 8
+	for i := 0; i < records && n+8 <= len(data); i++ {
+		t := data[n]
+		aux := int(data[n+1]) * 4
+		sources := int(binary.BigEndian.Uint16(data[n+2:]))
+		group := net.IP(data[n+4 : n+8])
+		switch {
+		// MODE_IS_EXCLUDE and CHANGE_TO_EXCLUDE_MODE
+		case t == 2 || t == 4:
+			m.Join(group, dpid, port)
+		// MODE_IS_INCLUDE and CHANGE_TO_INCLUDE_MODE
+		case (t == 1 || t == 3) && sources == 0:
+			m.Leave(group, dpid, port)
+		}
+		n += 8 + sources*4 + aux
+	}
+}
+
+func (m *Multicast) loop() {
+	for e := range m.sub.C {
+		if e.Type == EventSwitchUp {
+			if sw, ok := Switch(e.DPID); ok {
+				m.punt(sw)
+			}
+		}
+		m.mu.Lock()
+		groups := make([]string, 0, len(m.groups))
+		for g := range m.groups {
+			groups = append(groups, g)
+		}
+		m.mu.Unlock()
+		for _, g := range groups {
+			m.update(g)
+		}
+	}
+}
+
+// Sends IGMP from Switch sw to the controller.
+func (m *Multicast) punt(sw *OFSwitch) {
+	match := FlowMatch{IPProto: ipv4.Type_IGMP}
+	if err := sw.installOutputs(match, MulticastPriority+1, []uint16{ofp10.P_CONTROLLER}); err != nil {
+		log.Println("Failed to send IGMP to controller:", err)
+	}
+}
+
+// Rebuilds the tree of group and updates the flows of every
+// switch that joins or leaves it.
+func (m *Multicast) update(group string) {
+	m.mu.Lock()
+	members := make(map[string][]uint16)
+	for dpid, ports := range m.groups[group] {
+		for p := range ports {
+			members[dpid] = append(members[dpid], p)
+		}
+	}
+	installed := m.installed[group]
+	m.mu.Unlock()
+
+	ports := multicastPorts(CurrentTopology(), members)
+	now := make(map[string]bool)
+	for dpid, out := range ports {
+		mac, _ := net.ParseMAC(dpid)
+		sw, ok := Switch(mac)
+		if !ok {
+			continue
+		}
+		if err := m.install(sw, group, out, installed[dpid]); err != nil {
+			log.Println("Failed to install multicast group", group, "on", SwitchLabel(mac), err)
+			continue
+		}
+		now[dpid] = true
+	}
+	for dpid := range installed {
+		if now[dpid] {
+			continue
+		}
+		mac, _ := net.ParseMAC(dpid)
+		if sw, ok := Switch(mac); ok {
+			m.install(sw, group, nil, true)
+		}
+	}
+
+	m.mu.Lock()
+	if len(now) == 0 {
+		delete(m.installed, group)
+	} else {
+		m.installed[group] = now
+	}
+	m.mu.Unlock()
+}
+
+// Returns the ports each switch should send group traffic out
+// of, given the member ports of each switch. The tree is rooted
+// at the lowest member DPID so it's stable as members come and
+// go.
+func multicastPorts(t *Topology, members map[string][]uint16) map[string][]uint16 {
+	ports := make(map[string][]uint16)
+	dpids := make([]string, 0, len(members))
+	for dpid, p := range members {
+		ports[dpid] = append(ports[dpid], p...)
+		dpids = append(dpids, dpid)
+	}
+	if len(dpids) == 0 {
+		return ports
+	}
+	sort.Strings(dpids)
+	for _, l := range t.Tree(dpids[0], dpids[1:]) {
+		r, ok := t.link(l.Dst, l.Src)
+		if !ok {
+			continue
+		}
+		ports[l.Src] = append(ports[l.Src], l.SrcPort)
+		ports[r.Src] = append(ports[r.Src], r.SrcPort)
+	}
+	return ports
+}
+
+// Installs the flow forwarding group out ports on Switch sw, or
+// removes it if ports is empty. existed is true if sw already
+// has a group entry for group.
+func (m *Multicast) install(sw *OFSwitch, group string, ports []uint16, existed bool) error {
+	match := FlowMatch{IPDst: net.ParseIP(group)}
+	if sw.Version() == ofp10.VERSION {
+		return sw.installOutputs(match, MulticastPriority, ports)
+	}
+
+	// Multicast addresses are unique within 224.0.0.0/4, so
+	// the low 28 bits make a group id.
+	id := binary.BigEndian.Uint32(net.ParseIP(group).To4()) & 0x0fffffff
+	if len(ports) == 0 {
+		sw.installOutputs(match, MulticastPriority, nil)
+		g := ofp14.NewGroupMod(ofp14.GC_DELETE, ofp14.GT_ALL, id)
+		g.Header.Version = sw.Version()
+		return sw.Send(g)
+	}
+
+	cmd := uint16(ofp14.GC_ADD)
+	if existed {
+		cmd = ofp14.GC_MODIFY
+	}
+	g := ofp14.NewGroupMod(cmd, ofp14.GT_ALL, id)
+	g.Header.Version = sw.Version()
+	for _, p := range ports {
+		b := ofp14.NewBucket()
+		b.AddAction(ofp14.NewActionOutput(ofp14Port(p)))
+		g.AddBucket(b)
+	}
+	if err := sw.Send(g); err != nil {
+		return err
+	}
+
+	f := ofp14.NewFlowMod()
+	f.Header.Version = sw.Version()
+	f.Match = match.ofp14()
+	f.Priority = MulticastPriority
+	actions := ofp14.NewInstrApplyActions()
+	actions.AddAction(ofp14.NewActionGroup(id))
+	f.AddInstruction(actions)
+	return sw.Send(f)
+}
@@ -0,0 +1,160 @@
+package ogo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Friendly names of switches, like "tor-1" or "spine-2", by DPID.
+// Names are shown next to DPIDs in logs, metrics labels, the
+// debug endpoints and the topology, so operators don't have to
+// match DPIDs by eye. They can be set by SetSwitchName, read from
+// a file by ReadSwitchNames, or set through the /names endpoint.
+var switchNames = struct {
+	sync.RWMutex
+	byDPID map[string]string
+}{byDPID: make(map[string]string)}
+
+// Names switch dpid, or removes its name if name is empty. Names
+// must be unique.
+func SetSwitchName(dpid net.HardwareAddr, name string) error {
+	switchNames.Lock()
+	defer switchNames.Unlock()
+	key := dpid.String()
+	if name == "" {
+		delete(switchNames.byDPID, key)
+		return nil
+	}
+	if strings.ContainsAny(name, " \t\n\"") {
+		return fmt.Errorf("Switch name %q contains spaces or quotes.", name)
+	}
+	for d, n := range switchNames.byDPID {
+		if n == name && d != key {
+			return fmt.Errorf("Switch name %q is already used by %s.", name, d)
+		}
+	}
+	switchNames.byDPID[key] = name
+	return nil
+}
+
+// Returns the name of switch dpid, or an empty string if it has
+// none.
+func SwitchName(dpid net.HardwareAddr) string {
+	switchNames.RLock()
+	defer switchNames.RUnlock()
+	return switchNames.byDPID[dpid.String()]
+}
+
+// Returns the DPID of the switch called name.
+func SwitchByName(name string) (net.HardwareAddr, bool) {
+	switchNames.RLock()
+	defer switchNames.RUnlock()
+	for d, n := range switchNames.byDPID {
+		if n == name {
+			dpid, err := net.ParseMAC(d)
+			return dpid, err == nil
+		}
+	}
+	return nil, false
+}
+
+// Returns every name by DPID.
+func SwitchNames() map[string]string {
+	switchNames.RLock()
+	defer switchNames.RUnlock()
+	m := make(map[string]string, len(switchNames.byDPID))
+	for d, n := range switchNames.byDPID {
+		m[d] = n
+	}
+	return m
+}
+
+// Returns switch dpid as it is shown in logs: its name followed
+// by its DPID, or only its DPID if it has no name.
+func SwitchLabel(dpid net.HardwareAddr) string {
+	if name := SwitchName(dpid); name != "" {
+		return name + " (" + dpid.String() + ")"
+	}
+	return dpid.String()
+}
+
+// Reads switch names, adding them to the names already set. The
+// input is either a JSON object of names by DPID,
+//
+//	{"00:00:00:00:00:00:00:01": "tor-1", "00:00:00:00:00:00:00:02": "spine-1"}
+//
+// or one DPID and name per line, skipping blank lines and lines
+// starting with #:
+//
+//	00:00:00:00:00:00:00:01 tor-1
+func ReadSwitchNames(r io.Reader) error {
+	br := bufio.NewReader(r)
+	names := make(map[string]string)
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		if err := json.NewDecoder(br).Decode(&names); err != nil {
+			return err
+		}
+	} else {
+		s := bufio.NewScanner(br)
+		for line := 1; s.Scan(); line++ {
+			text := strings.TrimSpace(s.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			f := strings.Fields(text)
+			if len(f) != 2 {
+				return fmt.Errorf("line %d: expected a DPID and a name", line)
+			}
+			names[f[0]] = f[1]
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	return setSwitchNames(names)
+}
+
+// Sets names, in order of DPID so errors are reproducible.
+func setSwitchNames(names map[string]string) error {
+	dpids := make([]string, 0, len(names))
+	for d := range names {
+		dpids = append(dpids, d)
+	}
+	sort.Strings(dpids)
+	for _, d := range dpids {
+		dpid, err := net.ParseMAC(d)
+		if err != nil {
+			return fmt.Errorf("Bad DPID %q.", d)
+		}
+		if err := SetSwitchName(dpid, names[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serves the switch names as a JSON object of names by DPID. PUT
+// adds the names in the body, in the JSON or text format of
+// ReadSwitchNames; an empty name removes one.
+func serveSwitchNames(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		if err := ReadSwitchNames(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SwitchNames())
+}
@@ -0,0 +1,466 @@
+// Package nat translates the source addresses of a private
+// subnet to a public address on a gateway switch. The first
+// packet of each TCP or UDP connection leaving the subnet is
+// sent to the controller, which allocates a public port for it
+// and installs a flow rewriting the connection's packets on the
+// way out and another rewriting the replies on the way in. ICMP
+// echo requests are translated by address only: one inside host
+// at a time can ping each remote host.
+//
+// Mappings expire when either of their flows idles out. Only
+// OpenFlow 1.0 switches are supported, as packet-ins and flow
+// removed messages are only parsed for OpenFlow 1.0.
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/icmp"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// The high bits of the cookies of translation flows. The low bits
+// are the id of their mapping.
+const cookiePrefix = 0x4e41540000000000 // "NAT"
+
+const cookieMask = 0xffffff0000000000
+
+const icmpEchoRequest = 8
+
+// The configuration of a NAT.
+type Config struct {
+	// The switch translating.
+	DPID net.HardwareAddr
+	// The subnet translated.
+	Inside *net.IPNet
+	// The port of DPID towards the outside, the address inside
+	// hosts are translated to and its MAC, and the MAC of the
+	// next hop outside.
+	OutsidePort uint16
+	PublicIP    net.IP
+	PublicMAC   net.HardwareAddr
+	RouterMAC   net.HardwareAddr
+	// The public ports allocated to TCP and UDP connections,
+	// PortMin to PortMax. If zero, 20000 to 59999.
+	PortMin uint16
+	PortMax uint16
+	// Seconds a mapping lasts without traffic. If zero, 60.
+	IdleTimeout uint16
+	// If zero, 0x8800.
+	Priority uint16
+}
+
+// A translation between an inside host and a remote host.
+type Mapping struct {
+	Proto      uint8
+	Inside     net.IP
+	InsidePort uint16
+	Remote     net.IP
+	RemotePort uint16
+	// The public port of TCP and UDP mappings.
+	PublicPort uint16
+	// Where the inside host is: the port of the switch, its MAC
+	// and the MAC it sent to.
+	HostPort   uint16
+	HostMAC    net.HardwareAddr
+	GatewayMAC net.HardwareAddr
+	Created    time.Time
+
+	id uint64
+}
+
+// Returns the key of the inside side of a connection.
+func insideKey(proto uint8, ip net.IP, port uint16, remote net.IP, remotePort uint16) string {
+	return fmt.Sprint(proto, ip, port, remote, remotePort)
+}
+
+// Returns the key of the public side of a connection. ICMP has
+// no ports, so its public side is the remote host.
+func publicKey(proto uint8, port uint16, remote net.IP) string {
+	if proto == ipv4.Type_ICMP {
+		return fmt.Sprint(proto, remote)
+	}
+	return fmt.Sprint(proto, port)
+}
+
+func (m *Mapping) insideKey() string {
+	return insideKey(m.Proto, m.Inside, m.InsidePort, m.Remote, m.RemotePort)
+}
+
+func (m *Mapping) publicKey() string {
+	return publicKey(m.Proto, m.PublicPort, m.Remote)
+}
+
+var (
+	ErrPortsExhausted = errors.New("No public ports are free.")
+	ErrICMPInUse      = errors.New("Another inside host is pinging the remote host.")
+)
+
+// A NAT translates the inside subnet of its configuration.
+type NAT struct {
+	cfg Config
+	mu  sync.Mutex
+	// Mappings by their inside key, public key and id.
+	inside map[string]*Mapping
+	public map[string]*Mapping
+	byId   map[uint64]*Mapping
+	lastId uint64
+	// The port allocated last, by protocol.
+	next map[uint8]uint16
+}
+
+func New(cfg Config) (*NAT, error) {
+	if cfg.Inside == nil || cfg.PublicIP.To4() == nil {
+		return nil, errors.New("A NAT needs an inside subnet and a public IPv4 address.")
+	}
+	if cfg.PublicMAC == nil || cfg.RouterMAC == nil {
+		return nil, errors.New("A NAT needs a public MAC and the MAC of the outside router.")
+	}
+	if cfg.PortMin == 0 && cfg.PortMax == 0 {
+		cfg.PortMin, cfg.PortMax = 20000, 59999
+	}
+	if cfg.PortMin == 0 || cfg.PortMax < cfg.PortMin {
+		return nil, fmt.Errorf("Bad public port range %d-%d.", cfg.PortMin, cfg.PortMax)
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = 60
+	}
+	if cfg.Priority == 0 {
+		cfg.Priority = 0x8800
+	}
+	n := new(NAT)
+	n.cfg = cfg
+	n.inside = make(map[string]*Mapping)
+	n.public = make(map[string]*Mapping)
+	n.byId = make(map[uint64]*Mapping)
+	n.next = make(map[uint8]uint16)
+	return n, nil
+}
+
+// Starts translating packet-ins from the switch of the
+// configuration and following the expiry of its flows. Must be
+// called before c starts listening.
+func (n *NAT) Attach(c *ogo.Controller) {
+	c.AddPacketInHandler("nat", 1<<22, n)
+	c.RegisterApplication(func() interface{} {
+		return &expiry{n}
+	})
+}
+
+// Returns the mappings in effect, oldest first.
+func (n *NAT) Mappings() []Mapping {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ids := make([]uint64, 0, len(n.byId))
+	for id := range n.byId {
+		ids = append(ids, id)
+	}
+	sort.Sort(idSlice(ids))
+	a := make([]Mapping, len(ids))
+	for i, id := range ids {
+		a[i] = *n.byId[id]
+	}
+	return a
+}
+
+type idSlice []uint64
+
+func (s idSlice) Len() int           { return len(s) }
+func (s idSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s idSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Translates packets leaving the inside subnet and consumes
+// packets to the public address.
+func (n *NAT) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	if dpid.String() != n.cfg.DPID.String() || pkt.Data.Ethertype != eth.IPv4_MSG {
+		return false
+	}
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok {
+		return false
+	}
+	switch {
+	case pkt.InPort == n.cfg.OutsidePort && ip.NWDst.Equal(n.cfg.PublicIP):
+		n.inbound(pkt, ip)
+		return true
+	case pkt.InPort != n.cfg.OutsidePort && n.cfg.Inside.Contains(ip.NWSrc) &&
+		!n.cfg.Inside.Contains(ip.NWDst):
+		if err := n.outbound(pkt, ip); err != nil {
+			log.Printf("NAT dropped a packet from %s to %s: %v", ip.NWSrc, ip.NWDst, err)
+		}
+		return true
+	}
+	return false
+}
+
+// Returns the source and destination ports of the TCP or UDP
+// segment in ip.
+func ports(ip *ipv4.IPv4) (src, dst uint16, ok bool) {
+	if ip.Protocol != ipv4.Type_TCP && ip.Protocol != ipv4.Type_UDP {
+		return 0, 0, false
+	}
+	b, err := ip.Data.MarshalBinary()
+	if err != nil || len(b) < 4 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]), true
+}
+
+func (n *NAT) outbound(pkt *ofp10.PacketIn, ip *ipv4.IPv4) error {
+	src, dst, ok := ports(ip)
+	if !ok {
+		echo, isICMP := ip.Data.(*icmp.ICMP)
+		if !isICMP || echo.Type != icmpEchoRequest {
+			return errors.New("Only TCP, UDP and ICMP echo are translated.")
+		}
+	}
+	n.mu.Lock()
+	m, ok := n.inside[insideKey(ip.Protocol, ip.NWSrc, src, ip.NWDst, dst)]
+	if !ok {
+		var err error
+		if m, err = n.allocate(pkt, ip, src, dst); err != nil {
+			n.mu.Unlock()
+			return err
+		}
+	}
+	n.mu.Unlock()
+	if err := n.install(m); err != nil {
+		return err
+	}
+	return n.packetOut(pkt, n.outActions(m))
+}
+
+// Creates a mapping for a new connection. n.mu must be held.
+func (n *NAT) allocate(pkt *ofp10.PacketIn, ip *ipv4.IPv4, src, dst uint16) (*Mapping, error) {
+	m := &Mapping{
+		Proto:      ip.Protocol,
+		Inside:     append(net.IP(nil), ip.NWSrc.To4()...),
+		InsidePort: src,
+		Remote:     append(net.IP(nil), ip.NWDst.To4()...),
+		RemotePort: dst,
+		HostPort:   pkt.InPort,
+		HostMAC:    append(net.HardwareAddr(nil), pkt.Data.HWSrc...),
+		GatewayMAC: append(net.HardwareAddr(nil), pkt.Data.HWDst...),
+		Created:    time.Now(),
+	}
+	if m.Proto == ipv4.Type_ICMP {
+		if _, ok := n.public[m.publicKey()]; ok {
+			return nil, ErrICMPInUse
+		}
+	} else {
+		port, err := n.freePort(m.Proto)
+		if err != nil {
+			return nil, err
+		}
+		m.PublicPort = port
+	}
+	n.lastId += 1
+	m.id = n.lastId
+	n.inside[m.insideKey()] = m
+	n.public[m.publicKey()] = m
+	n.byId[m.id] = m
+	return m, nil
+}
+
+// Returns the next public port of proto not in use. n.mu must be
+// held.
+func (n *NAT) freePort(proto uint8) (uint16, error) {
+	size := int(n.cfg.PortMax-n.cfg.PortMin) + 1
+	start := 0
+	if last, ok := n.next[proto]; ok {
+		start = int(last-n.cfg.PortMin) + 1
+	}
+	for i := 0; i < size; i++ {
+		port := n.cfg.PortMin + uint16((start+i)%size)
+		if _, ok := n.public[publicKey(proto, port, nil)]; !ok {
+			n.next[proto] = port
+			return port, nil
+		}
+	}
+	return 0, ErrPortsExhausted
+}
+
+// Reinstalls the flows of mapped connections whose packets came
+// back to the controller, and drops the rest.
+func (n *NAT) inbound(pkt *ofp10.PacketIn, ip *ipv4.IPv4) {
+	src, dst, _ := ports(ip)
+	m, ok := n.lookupInbound(ip.Protocol, ip.NWSrc, src, dst)
+	if !ok {
+		return
+	}
+	if err := n.install(m); err != nil {
+		log.Printf("NAT failed to install flows for %s to %s: %v", m.Inside, m.Remote, err)
+		return
+	}
+	n.packetOut(pkt, n.inActions(m))
+}
+
+// Returns the mapping a packet of proto from remote port src to
+// public port dst is a reply to. TCP and UDP mappings only accept
+// packets from the remote host and port the inside host
+// connected to, so a public port can't be used by other hosts to
+// reach the inside host.
+func (n *NAT) lookupInbound(proto uint8, remote net.IP, src, dst uint16) (*Mapping, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	m, ok := n.public[publicKey(proto, dst, remote)]
+	if !ok {
+		return nil, false
+	}
+	if proto != ipv4.Type_ICMP && (!m.Remote.Equal(remote) || m.RemotePort != src) {
+		return nil, false
+	}
+	return m, true
+}
+
+// The match of packets of m leaving the inside subnet.
+func (n *NAT) outMatch(m *Mapping) ofp10.Match {
+	match := *ofp10.NewMatch()
+	match.InPort = m.HostPort
+	match.DLType = eth.IPv4_MSG
+	match.NWProto = m.Proto
+	copy(match.NWSrc, m.Inside)
+	copy(match.NWDst, m.Remote)
+	match.Wildcards &^= ofp10.FW_IN_PORT | ofp10.FW_DL_TYPE | ofp10.FW_NW_PROTO |
+		ofp10.FW_NW_SRC_MASK | ofp10.FW_NW_DST_MASK
+	if m.Proto != ipv4.Type_ICMP {
+		match.TPSrc, match.TPDst = m.InsidePort, m.RemotePort
+		match.Wildcards &^= ofp10.FW_TP_SRC | ofp10.FW_TP_DST
+	}
+	return match
+}
+
+// The match of replies to m.
+func (n *NAT) inMatch(m *Mapping) ofp10.Match {
+	match := *ofp10.NewMatch()
+	match.InPort = n.cfg.OutsidePort
+	match.DLType = eth.IPv4_MSG
+	match.NWProto = m.Proto
+	copy(match.NWSrc, m.Remote)
+	copy(match.NWDst, n.cfg.PublicIP.To4())
+	match.Wildcards &^= ofp10.FW_IN_PORT | ofp10.FW_DL_TYPE | ofp10.FW_NW_PROTO |
+		ofp10.FW_NW_SRC_MASK | ofp10.FW_NW_DST_MASK
+	if m.Proto != ipv4.Type_ICMP {
+		match.TPSrc, match.TPDst = m.RemotePort, m.PublicPort
+		match.Wildcards &^= ofp10.FW_TP_SRC | ofp10.FW_TP_DST
+	}
+	return match
+}
+
+func (n *NAT) outActions(m *Mapping) []ofp10.Action {
+	a := []ofp10.Action{
+		ofp10.NewActionDLSrc(n.cfg.PublicMAC),
+		ofp10.NewActionDLDst(n.cfg.RouterMAC),
+		ofp10.NewActionNWSrc(n.cfg.PublicIP.To4()),
+	}
+	if m.Proto != ipv4.Type_ICMP {
+		a = append(a, ofp10.NewActionTPSrc(m.PublicPort))
+	}
+	return append(a, ofp10.NewActionOutput(n.cfg.OutsidePort))
+}
+
+func (n *NAT) inActions(m *Mapping) []ofp10.Action {
+	a := []ofp10.Action{
+		ofp10.NewActionDLSrc(m.GatewayMAC),
+		ofp10.NewActionDLDst(m.HostMAC),
+		ofp10.NewActionNWDst(m.Inside),
+	}
+	if m.Proto != ipv4.Type_ICMP {
+		a = append(a, ofp10.NewActionTPDst(m.InsidePort))
+	}
+	return append(a, ofp10.NewActionOutput(m.HostPort))
+}
+
+// Installs the flows translating m in both directions. The
+// switch reports their removal so the mapping expires with them.
+func (n *NAT) install(m *Mapping) error {
+	sw, ok := ogo.Switch(n.cfg.DPID)
+	if !ok {
+		return ogo.ErrSwitchDisconnected
+	}
+	for _, f := range []*ofp10.FlowMod{n.flowMod(n.outMatch(m), n.outActions(m), m), n.flowMod(n.inMatch(m), n.inActions(m), m)} {
+		if err := sw.Send(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *NAT) flowMod(match ofp10.Match, actions []ofp10.Action, m *Mapping) *ofp10.FlowMod {
+	f := ofp10.NewFlowMod()
+	f.Match = match
+	f.Cookie = cookiePrefix | m.id
+	f.Priority = n.cfg.Priority
+	f.IdleTimeout = n.cfg.IdleTimeout
+	f.Flags = ofp10.FF_SEND_FLOW_REM
+	for _, a := range actions {
+		f.AddAction(a)
+	}
+	return f
+}
+
+// Sends the packet of pkt on with actions.
+func (n *NAT) packetOut(pkt *ofp10.PacketIn, actions []ofp10.Action) error {
+	sw, ok := ogo.Switch(n.cfg.DPID)
+	if !ok {
+		return ogo.ErrSwitchDisconnected
+	}
+	out := ofp10.NewPacketOut()
+	out.InPort = pkt.InPort
+	if pkt.BufferId != 0xffffffff {
+		out.BufferId = pkt.BufferId
+	} else {
+		out.Data = &pkt.Data
+	}
+	for _, a := range actions {
+		out.AddAction(a)
+	}
+	return sw.Send(out)
+}
+
+// Removes mapping id and deletes the flow of its other direction.
+func (n *NAT) expire(id uint64) {
+	n.mu.Lock()
+	m, ok := n.byId[id]
+	if ok {
+		delete(n.byId, id)
+		delete(n.inside, m.insideKey())
+		delete(n.public, m.publicKey())
+	}
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	if sw, ok := ogo.Switch(n.cfg.DPID); ok {
+		for _, match := range []ofp10.Match{n.outMatch(m), n.inMatch(m)} {
+			f := ofp10.NewFlowMod()
+			f.Command = ofp10.FC_DELETE_STRICT
+			f.Match = match
+			f.Priority = n.cfg.Priority
+			sw.Send(f)
+		}
+	}
+}
+
+// The application instance of a NAT on each switch, receiving
+// the removal of translation flows.
+type expiry struct {
+	nat *NAT
+}
+
+func (e *expiry) FlowRemoved(dpid net.HardwareAddr, flow *ofp10.FlowRemoved) {
+	if dpid.String() != e.nat.cfg.DPID.String() || flow.Cookie&cookieMask != cookiePrefix {
+		return
+	}
+	e.nat.expire(flow.Cookie &^ cookieMask)
+}
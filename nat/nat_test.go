@@ -0,0 +1,170 @@
+package nat
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+func testNAT(t *testing.T, min, max uint16) *NAT {
+	// Expiry looks up the switch of the NAT.
+	ogo.NewController()
+	_, inside, _ := net.ParseCIDR("10.0.0.0/24")
+	n, err := New(Config{
+		DPID:        net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1},
+		Inside:      inside,
+		OutsidePort: 1,
+		PublicIP:    net.IPv4(192, 0, 2, 1),
+		PublicMAC:   net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		RouterMAC:   net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		PortMin:     min,
+		PortMax:     max,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Maps a connection from inside:src to remote:dst.
+func testMap(t *testing.T, n *NAT, proto uint8, inside, remote string, src, dst uint16) (*Mapping, error) {
+	pkt := ofp10.NewPacketIn()
+	pkt.InPort = 2
+	pkt.Data.HWSrc = net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	pkt.Data.HWDst = net.HardwareAddr{2, 0, 0, 0, 0, 4}
+	ip := ipv4.New()
+	ip.Protocol = proto
+	ip.NWSrc = net.ParseIP(inside).To4()
+	ip.NWDst = net.ParseIP(remote).To4()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.allocate(pkt, ip, src, dst)
+}
+
+func TestLookupInbound(t *testing.T) {
+	n := testNAT(t, 0, 0)
+	m, err := testMap(t, n, ipv4.Type_TCP, "10.0.0.5", "198.51.100.7", 40000, 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testMap(t, n, ipv4.Type_ICMP, "10.0.0.5", "198.51.100.7", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		proto  uint8
+		remote string
+		src    uint16
+		dst    uint16
+		ok     bool
+	}{
+		{"reply", ipv4.Type_TCP, "198.51.100.7", 443, m.PublicPort, true},
+		{"other remote host", ipv4.Type_TCP, "198.51.100.8", 443, m.PublicPort, false},
+		{"other remote port", ipv4.Type_TCP, "198.51.100.7", 80, m.PublicPort, false},
+		{"other public port", ipv4.Type_TCP, "198.51.100.7", 443, m.PublicPort + 1, false},
+		{"other protocol", ipv4.Type_UDP, "198.51.100.7", 443, m.PublicPort, false},
+		{"echo reply", ipv4.Type_ICMP, "198.51.100.7", 0, 0, true},
+		{"echo reply from another host", ipv4.Type_ICMP, "198.51.100.8", 0, 0, false},
+	}
+	for _, test := range tests {
+		_, ok := n.lookupInbound(test.proto, net.ParseIP(test.remote).To4(), test.src, test.dst)
+		if ok != test.ok {
+			t.Errorf("%s: got %t, expected %t.", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestPortAllocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		min   uint16
+		max   uint16
+		conns int
+		// The public port of each connection, 0 if it got
+		// ErrPortsExhausted.
+		ports []uint16
+	}{
+		{"in order", 100, 199, 3, []uint16{100, 101, 102}},
+		{"single port", 100, 100, 2, []uint16{100, 0}},
+		{"exhausted", 100, 102, 5, []uint16{100, 101, 102, 0, 0}},
+		{"top of range", 65534, 65535, 3, []uint16{65534, 65535, 0}},
+	}
+	for _, test := range tests {
+		n := testNAT(t, test.min, test.max)
+		for i := 0; i < test.conns; i++ {
+			m, err := testMap(t, n, ipv4.Type_TCP, "10.0.0.5", "198.51.100.7", uint16(40000+i), 443)
+			switch {
+			case test.ports[i] == 0 && err != ErrPortsExhausted:
+				t.Errorf("%s: connection %d got error %v.", test.name, i, err)
+			case test.ports[i] != 0 && err != nil:
+				t.Errorf("%s: connection %d: %v", test.name, i, err)
+			case test.ports[i] != 0 && m.PublicPort != test.ports[i]:
+				t.Errorf("%s: connection %d got port %d, expected %d.", test.name, i, m.PublicPort, test.ports[i])
+			}
+		}
+	}
+
+	// Ports are per protocol, and a released port is only
+	// reused once the rest of the range has been handed out.
+	n := testNAT(t, 100, 102)
+	a, _ := testMap(t, n, ipv4.Type_TCP, "10.0.0.5", "198.51.100.7", 40000, 443)
+	if m, err := testMap(t, n, ipv4.Type_UDP, "10.0.0.5", "198.51.100.7", 40000, 53); err != nil || m.PublicPort != 100 {
+		t.Errorf("UDP connection got port %v, error %v.", m, err)
+	}
+	n.expire(a.id)
+	for _, port := range []uint16{101, 102, 100} {
+		m, err := testMap(t, n, ipv4.Type_TCP, "10.0.0.6", "198.51.100.7", port, 443)
+		if err != nil || m.PublicPort != port {
+			t.Errorf("Got port %v, error %v, expected %d.", m, err, port)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	n := testNAT(t, 0, 0)
+	a, _ := testMap(t, n, ipv4.Type_TCP, "10.0.0.5", "198.51.100.7", 40000, 443)
+	b, _ := testMap(t, n, ipv4.Type_ICMP, "10.0.0.5", "198.51.100.7", 0, 0)
+	e := &expiry{n}
+
+	tests := []struct {
+		name   string
+		dpid   net.HardwareAddr
+		cookie uint64
+		// The ids of the mappings left afterwards.
+		left []uint64
+	}{
+		{"other switch", net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 2}, cookiePrefix | a.id, []uint64{a.id, b.id}},
+		{"other cookie", n.cfg.DPID, a.id, []uint64{a.id, b.id}},
+		{"unknown mapping", n.cfg.DPID, cookiePrefix | 99, []uint64{a.id, b.id}},
+		{"expired", n.cfg.DPID, cookiePrefix | a.id, []uint64{b.id}},
+		// The flow of the other direction is removed too.
+		{"other direction", n.cfg.DPID, cookiePrefix | a.id, []uint64{b.id}},
+		{"ping", n.cfg.DPID, cookiePrefix | b.id, []uint64{}},
+	}
+	for _, test := range tests {
+		flow := ofp10.NewFlowRemoved()
+		flow.Cookie = test.cookie
+		e.FlowRemoved(test.dpid, flow)
+		left := n.Mappings()
+		if len(left) != len(test.left) {
+			t.Errorf("%s: %d mappings left, expected %d.", test.name, len(left), len(test.left))
+			continue
+		}
+		for i, m := range left {
+			if m.id != test.left[i] {
+				t.Errorf("%s: mapping %d left, expected %d.", test.name, m.id, test.left[i])
+			}
+		}
+	}
+
+	// The ports of expired mappings are free again.
+	if _, ok := n.lookupInbound(ipv4.Type_TCP, a.Remote, a.RemotePort, a.PublicPort); ok {
+		t.Error("Found an expired mapping.")
+	}
+	if _, err := testMap(t, n, ipv4.Type_ICMP, "10.0.0.6", "198.51.100.7", 0, 0); err != nil {
+		t.Errorf("Couldn't ping after the last ping expired: %v", err)
+	}
+}
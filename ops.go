@@ -0,0 +1,323 @@
+package ogo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Set while the controller is accepting switch connections.
+var listening int32
+
+// Serves operational endpoints on addr:
+//
+//	/healthz         the process is up
+//	/readyz          the controller is listening and at least
+//	                 ReadySwitches switches are connected
+//	/debug/pprof/    runtime profiles
+//	/debug/switches  the connection state of every switch
+//	/debug/messages  OpenFlow messages exchanged with each switch
+//	/debug/queues    messages waiting to be sent to each switch,
+//	                 and flushes them, see serveQueues
+//	                 (flushing is guarded by c.Interlock)
+//	/debug/discovery link discovery probes received and the last
+//	                 ones rejected as forged
+//	/debug/traces    recent spans, if a RecordingTracer is
+//	                 installed
+//	/metrics         message, panic, webhook, shard and discovery
+//	                 counters in the Prometheus text format
+//	/topology        the topology, in the format given by the
+//	                 "format" parameter, JSON by default
+//	/flows           a page of the flow shadows as JSON, see
+//	                 serveFlows
+//	/flowset         exports and imports the flows of a switch,
+//	                 see ServeFlowSet (importing and synchronizing
+//	                 are guarded by c.Interlock)
+//	/names           the friendly names of switches, see
+//	                 serveSwitchNames
+//	/handoff         hands switches to a peer controller, see
+//	                 serveHandoff (guarded by c.Interlock)
+//	/capacity        the table capacity report of c.Capacity, if
+//	                 set, see CapacityPlanner.ServeHTTP
+//	/accounting      the usage records of c.Accounting, if set,
+//	                 see Accounting.ServeHTTP
+//	/latency         the last window of the reactive latency budget
+//	                 c.Latency, if set
+//	/maintenance     maintenance mode of c.Interlock
+//	/audit           destructive operations requested, see
+//	                 Interlock
+//	/switch/flows    deletes every flow of a switch
+//	/switch/port     takes a port of a switch down or up
+//	/switch/disconnect
+//	                 closes the connection to a switch
+//	/switch/drain    drains a switch or link for maintenance, see
+//	                 Interlock.serveDrain
+//	/config          the configuration of a switch, including how
+//	                 it handles IP fragments, see SwitchConfig
+//	                 (changing it is guarded by c.Interlock)
+//
+// If c.Access is set, every request but /healthz and /readyz needs
+// an API key with a role allowing it, see AccessControl. Without
+// it every request is served, so addr must be a loopback address;
+// a port alone, such as ":8080", listens on 127.0.0.1.
+func (c *Controller) ServeOps(addr string) error {
+	addr, err := opsAddr(addr, c.Access == nil)
+	if err != nil {
+		return err
+	}
+	return http.ListenAndServe(addr, c.opsHandler())
+}
+
+// Returns the handler of the endpoints served by ServeOps.
+func (c *Controller) opsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", c.serveReady)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/switches", serveSwitches)
+	mux.HandleFunc("/debug/messages", serveMessages)
+	mux.HandleFunc("/debug/queues", c.Interlock.guard(queuesOp, serveQueues))
+	mux.HandleFunc("/debug/discovery", serveDiscovery)
+	mux.HandleFunc("/debug/traces", serveTraces)
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/topology", serveTopology)
+	mux.HandleFunc("/flows", serveFlows)
+	mux.HandleFunc("/flowset", c.Interlock.guard(flowSetOp, ServeFlowSet))
+	mux.HandleFunc("/config", c.Interlock.guard(switchConfigOp, serveSwitchConfig))
+	mux.HandleFunc("/names", serveSwitchNames)
+	mux.HandleFunc("/handoff", c.Interlock.guard(handoffOp, serveHandoff))
+	if c.Capacity != nil {
+		mux.Handle("/capacity", c.Capacity)
+	}
+	if c.Accounting != nil {
+		mux.Handle("/accounting", c.Accounting)
+	}
+	if c.Latency != nil {
+		mux.Handle("/latency", c.Latency)
+	}
+	if c.Interlock != nil {
+		mux.HandleFunc("/maintenance", c.Interlock.serveMaintenance)
+		mux.HandleFunc("/audit", c.Interlock.serveRecords)
+		mux.HandleFunc("/switch/flows", c.Interlock.serveDeleteFlows)
+		mux.HandleFunc("/switch/port", c.Interlock.servePort)
+		mux.HandleFunc("/switch/disconnect", c.Interlock.serveDisconnect)
+		mux.HandleFunc("/switch/drain", c.Interlock.serveDrain)
+	}
+	if c.Access != nil {
+		return c.Access.wrap(mux)
+	}
+	return mux
+}
+
+func (c *Controller) serveReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&listening) == 0 {
+		http.Error(w, "not listening for switches", http.StatusServiceUnavailable)
+		return
+	}
+	if n := len(Switches()); n < c.ReadySwitches {
+		http.Error(w, fmt.Sprintf("%d of %d switches connected", n, c.ReadySwitches),
+			http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func serveSwitches(w http.ResponseWriter, r *http.Request) {
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+
+	fmt.Fprintf(w, "goroutines: %d\n\n", runtime.NumGoroutine())
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DPID\tNAME\tVERSION\tADDRESS\tINBOUND\tOUTBOUND\tMSGS/WRITE\tPENDING\tLINKS\tFLOWS\tAPPS\tUPTIME\tSTATE")
+	for _, sw := range sws {
+		sw.reqsMu.RLock()
+		pending := len(sw.reqs)
+		sw.reqsMu.RUnlock()
+		stream, _ := sw.session()
+		state := "connected"
+		select {
+		case <-stream.Done():
+			state = "disconnected"
+		default:
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d/%d\t%d/%d\t%.1f\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			sw.DPID(), SwitchName(sw.DPID()), sw.Version(), stream.GetAddr(),
+			len(stream.Inbound), cap(stream.Inbound),
+			len(stream.Outbound), cap(stream.Outbound),
+			sw.WriteStats().MessagesPerWrite(), pending, len(sw.Links()), len(sw.Flows()), len(sw.instances()),
+			sw.Uptime()/time.Second*time.Second, state)
+	}
+	tw.Flush()
+}
+
+func serveMessages(w http.ResponseWriter, r *http.Request) {
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DPID\tNAME\tTYPE\tSENT\tRECEIVED\tSENT/S\tRECEIVED/S")
+	for _, sw := range sws {
+		for _, m := range sw.MessageStats() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.1f\t%.1f\n",
+				sw.DPID(), SwitchName(sw.DPID()), m.Type, m.Sent, m.Received, m.SentRate, m.ReceivedRate)
+		}
+	}
+	tw.Flush()
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# TYPE ogo_switches gauge")
+	fmt.Fprintf(w, "ogo_switches %d\n", len(sws))
+	fmt.Fprintln(w, "# TYPE ogo_messages_sent_total counter")
+	for _, sw := range sws {
+		for _, m := range sw.MessageStats() {
+			fmt.Fprintf(w, "ogo_messages_sent_total{dpid=%q,name=%q,type=%q} %d\n", sw.DPID().String(), SwitchName(sw.DPID()), m.Type, m.Sent)
+		}
+	}
+	fmt.Fprintln(w, "# TYPE ogo_messages_received_total counter")
+	for _, sw := range sws {
+		for _, m := range sw.MessageStats() {
+			fmt.Fprintf(w, "ogo_messages_received_total{dpid=%q,name=%q,type=%q} %d\n", sw.DPID().String(), SwitchName(sw.DPID()), m.Type, m.Received)
+		}
+	}
+	fmt.Fprintln(w, "# TYPE ogo_writes_total counter")
+	for _, sw := range sws {
+		fmt.Fprintf(w, "ogo_writes_total{dpid=%q,name=%q} %d\n", sw.DPID().String(), SwitchName(sw.DPID()), sw.WriteStats().Writes)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_written_bytes_total counter")
+	for _, sw := range sws {
+		fmt.Fprintf(w, "ogo_written_bytes_total{dpid=%q,name=%q} %d\n", sw.DPID().String(), SwitchName(sw.DPID()), sw.WriteStats().Bytes)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_switch_rtt_seconds gauge")
+	for _, sw := range sws {
+		if c := sw.Clock(); c.Samples > 0 {
+			fmt.Fprintf(w, "ogo_switch_rtt_seconds{dpid=%q,name=%q} %g\n", sw.DPID().String(),
+				SwitchName(sw.DPID()), c.RTT.Seconds())
+		}
+	}
+	fmt.Fprintln(w, "# TYPE ogo_switch_send_latency_seconds gauge")
+	for _, sw := range sws {
+		fmt.Fprintf(w, "ogo_switch_send_latency_seconds{dpid=%q,name=%q} %g\n", sw.DPID().String(),
+			SwitchName(sw.DPID()), sw.SendLatency().Mean.Seconds())
+	}
+	fmt.Fprintln(w, "# TYPE ogo_switch_slow gauge")
+	for _, sw := range sws {
+		slow := 0
+		if sw.Slow() {
+			slow = 1
+		}
+		fmt.Fprintf(w, "ogo_switch_slow{dpid=%q,name=%q} %d\n", sw.DPID().String(), SwitchName(sw.DPID()), slow)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_app_panics_total counter")
+	panics.Lock()
+	apps := make([]string, 0, len(panics.counts))
+	for app := range panics.counts {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		fmt.Fprintf(w, "ogo_app_panics_total{app=%q} %d\n", app, panics.counts[app])
+	}
+	panics.Unlock()
+	writeWebhookMetrics(w)
+	writeAuditMetrics(w)
+	writeStreamMetrics(w)
+	writeShardMetrics(w)
+	writeDiscoveryMetrics(w)
+}
+
+func serveTopology(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if err := CurrentTopology().Write(w, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// Serves QueryFlows. The parameters are dpid, table, owner,
+// sort, limit and cursor, cookie as value/mask, priority as
+// min-max, and match, repeatable, as name or name=value:
+//
+//	/flows?priority=100-200&match=eth_type=0x0800&sort=-priority&limit=50
+func serveFlows(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q := FlowQuery{DPID: v.Get("dpid"), Table: -1, Owner: v.Get("owner"),
+		Sort: v.Get("sort"), Cursor: v.Get("cursor"), Match: make(map[string]string)}
+	var err error
+	if t := v.Get("table"); t != "" {
+		if q.Table, err = strconv.Atoi(t); err != nil {
+			http.Error(w, "bad table", http.StatusBadRequest)
+			return
+		}
+	}
+	if c := v.Get("cookie"); c != "" {
+		a := strings.SplitN(c, "/", 2)
+		q.CookieMask = ^uint64(0)
+		q.Cookie, err = strconv.ParseUint(a[0], 0, 64)
+		if err == nil && len(a) == 2 {
+			q.CookieMask, err = strconv.ParseUint(a[1], 0, 64)
+		}
+		if err != nil {
+			http.Error(w, "bad cookie", http.StatusBadRequest)
+			return
+		}
+	}
+	if p := v.Get("priority"); p != "" {
+		a := strings.SplitN(p, "-", 2)
+		min, err := strconv.ParseUint(a[0], 0, 16)
+		max := min
+		if err == nil && len(a) == 2 {
+			max, err = strconv.ParseUint(a[1], 0, 16)
+		}
+		if err != nil {
+			http.Error(w, "bad priority", http.StatusBadRequest)
+			return
+		}
+		q.MinPriority, q.MaxPriority = uint16(min), uint16(max)
+	}
+	for _, m := range v["match"] {
+		a := strings.SplitN(m, "=", 2)
+		if len(a) == 2 {
+			q.Match[a[0]] = a[1]
+		} else {
+			q.Match[a[0]] = ""
+		}
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := QueryFlows(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+type switchesByDPID []*OFSwitch
+
+func (a switchesByDPID) Len() int           { return len(a) }
+func (a switchesByDPID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a switchesByDPID) Less(i, j int) bool { return a[i].DPID().String() < a[j].DPID().String() }
@@ -0,0 +1,229 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/ovsdb"
+)
+
+// Priority of the flows installed for tenant traffic.
+var OverlayPriority uint16 = 0x8000
+
+// An Open vSwitch bridge taking part in an overlay. DB is a
+// connection to the OVSDB server of the host running Bridge, and
+// IP is the address tunnels to it are sent to.
+type OverlayEndpoint struct {
+	DPID   net.HardwareAddr
+	DB     *ovsdb.Client
+	Bridge string
+	IP     net.IP
+}
+
+// A tunnel port created by the overlay.
+type Tunnel struct {
+	DPID   net.HardwareAddr
+	Peer   net.HardwareAddr
+	Kind   string // "vxlan" or "gre"
+	VNI    uint32
+	Name   string
+	OFPort uint16
+}
+
+// An Overlay creates VXLAN or GRE tunnels between Open vSwitch
+// bridges and floods each tenant's traffic between the tenant's
+// local ports and its tunnels. Tunnels are added to the topology
+// as links.
+type Overlay struct {
+	mu        sync.Mutex
+	endpoints map[string]*OverlayEndpoint
+	tunnels   []*Tunnel
+	// Local ports of each tenant, by VNI then DPID.
+	ports map[uint32]map[string][]uint16
+}
+
+func NewOverlay() *Overlay {
+	o := new(Overlay)
+	o.endpoints = make(map[string]*OverlayEndpoint)
+	o.tunnels = make([]*Tunnel, 0)
+	o.ports = make(map[uint32]map[string][]uint16)
+	return o
+}
+
+func (o *Overlay) AddEndpoint(ep OverlayEndpoint) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.endpoints[ep.DPID.String()] = &ep
+}
+
+// Creates a tunnel of kind, "vxlan" or "gre", for tenant vni in
+// both directions between Switches a and b.
+func (o *Overlay) Connect(a, b net.HardwareAddr, kind string, vni uint32) error {
+	if kind != "vxlan" && kind != "gre" {
+		return fmt.Errorf("Unknown tunnel type %s.", kind)
+	}
+	o.mu.Lock()
+	epA, okA := o.endpoints[a.String()]
+	epB, okB := o.endpoints[b.String()]
+	o.mu.Unlock()
+	if !okA || !okB {
+		return errors.New("Both switches must be overlay endpoints.")
+	}
+
+	ta, err := createTunnel(epA, epB, kind, vni)
+	if err != nil {
+		return err
+	}
+	tb, err := createTunnel(epB, epA, kind, vni)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.tunnels = append(o.tunnels, ta, tb)
+	o.mu.Unlock()
+	for _, t := range []*Tunnel{ta, tb} {
+		if sw, ok := Switch(t.DPID); ok {
+			sw.setLink(t.DPID, &Link{DPID: t.Peer, Port: t.OFPort, Bandwidth: -1, Updated: time.Now()})
+		}
+	}
+	return o.install(vni)
+}
+
+// Adds port of Switch dpid to tenant vni.
+func (o *Overlay) AddTenantPort(vni uint32, dpid net.HardwareAddr, port uint16) error {
+	o.mu.Lock()
+	if _, ok := o.ports[vni]; !ok {
+		o.ports[vni] = make(map[string][]uint16)
+	}
+	o.ports[vni][dpid.String()] = append(o.ports[vni][dpid.String()], port)
+	o.mu.Unlock()
+	return o.install(vni)
+}
+
+// Removes port of Switch dpid from tenant vni.
+func (o *Overlay) RemoveTenantPort(vni uint32, dpid net.HardwareAddr, port uint16) error {
+	o.mu.Lock()
+	ports := o.ports[vni][dpid.String()]
+	for i, p := range ports {
+		if p == port {
+			o.ports[vni][dpid.String()] = append(ports[:i:i], ports[i+1:]...)
+			break
+		}
+	}
+	o.mu.Unlock()
+	if sw, ok := Switch(dpid); ok {
+		sw.installOutputs(FlowMatch{InPort: port}, OverlayPriority, nil)
+	}
+	return o.install(vni)
+}
+
+// Returns every tunnel created by the overlay.
+func (o *Overlay) Tunnels() []Tunnel {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	a := make([]Tunnel, len(o.tunnels))
+	for i, t := range o.tunnels {
+		a[i] = *t
+	}
+	return a
+}
+
+// Installs the flows of tenant vni on every switch. Traffic from
+// a local port is flooded to the tenant's other local ports and
+// tunnels. Traffic from a tunnel only goes to local ports, which
+// avoids loops as long as tunnels form a full mesh.
+func (o *Overlay) install(vni uint32) error {
+	o.mu.Lock()
+	local := make(map[string][]uint16)
+	for dpid, ports := range o.ports[vni] {
+		local[dpid] = append([]uint16(nil), ports...)
+	}
+	tunnels := make(map[string][]uint16)
+	for _, t := range o.tunnels {
+		if t.VNI == vni {
+			tunnels[t.DPID.String()] = append(tunnels[t.DPID.String()], t.OFPort)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, sw := range Switches() {
+		key := sw.DPID().String()
+		for _, p := range local[key] {
+			out := make([]uint16, 0)
+			for _, q := range local[key] {
+				if q != p {
+					out = append(out, q)
+				}
+			}
+			out = append(out, tunnels[key]...)
+			if err := sw.installOutputs(FlowMatch{InPort: p}, OverlayPriority, out); err != nil {
+				return err
+			}
+		}
+		for _, t := range tunnels[key] {
+			if err := sw.installOutputs(FlowMatch{InPort: t}, OverlayPriority, local[key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Creates a tunnel port on ep's bridge towards peer and returns
+// it once Open vSwitch has assigned it an OpenFlow port.
+func createTunnel(ep, peer *OverlayEndpoint, kind string, vni uint32) (*Tunnel, error) {
+	d := peer.DPID
+	name := fmt.Sprintf("%s%d-%02x%02x", kind[:2], vni, d[len(d)-2], d[len(d)-1])
+	iface := map[string]interface{}{
+		"name": name,
+		"type": kind,
+		"options": ovsdb.Map(map[string]string{
+			"remote_ip": peer.IP.String(),
+			"local_ip":  ep.IP.String(),
+			"key":       fmt.Sprint(vni),
+		}),
+	}
+	port := map[string]interface{}{
+		"name":       name,
+		"interfaces": ovsdb.NamedUUID("iface"),
+	}
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", ep.Bridge)}
+	_, err := ep.DB.Transact("Open_vSwitch",
+		ovsdb.Insert("Interface", iface, "iface"),
+		ovsdb.Insert("Port", port, "port"),
+		ovsdb.Mutate("Bridge", where, ovsdb.NewMutation("ports", "insert", ovsdb.Set(ovsdb.NamedUUID("port")))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ofport, err := interfaceOFPort(ep.DB, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{ep.DPID, peer.DPID, kind, vni, name, ofport}, nil
+}
+
+// Waits for Open vSwitch to assign an OpenFlow port number to
+// interface name.
+func interfaceOFPort(db *ovsdb.Client, name string) (uint16, error) {
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", name)}
+	for i := 0; i < 20; i++ {
+		res, err := db.Transact("Open_vSwitch", ovsdb.Select("Interface", where, "ofport"))
+		if err != nil {
+			return 0, err
+		}
+		if len(res) == 1 && len(res[0].Rows) == 1 {
+			// An unassigned ofport is an empty set.
+			if n, ok := res[0].Rows[0]["ofport"].(float64); ok && n > 0 {
+				return uint16(n), nil
+			}
+		}
+		time.Sleep(time.Millisecond * 250)
+	}
+	return 0, fmt.Errorf("Interface %s has no OpenFlow port.", name)
+}
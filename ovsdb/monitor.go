@@ -0,0 +1,63 @@
+package ovsdb
+
+import (
+	"encoding/json"
+)
+
+// Changes to a table, keyed by row UUID.
+type TableUpdate map[string]RowUpdate
+
+// Changes to a database, keyed by table name.
+type TableUpdates map[string]TableUpdate
+
+// The old and new contents of a row. Old is nil for inserted
+// rows and New is nil for deleted rows.
+type RowUpdate struct {
+	Old map[string]interface{} `json:"old,omitempty"`
+	New map[string]interface{} `json:"new,omitempty"`
+}
+
+// Monitors columns of tables in db, keyed by table name. An empty
+// list of columns monitors every column. Returns the current
+// contents of the tables, after which fn is called with every
+// change. A client supports a single update callback.
+func (c *Client) Monitor(db, name string, tables map[string][]string, fn func(monitor string, u TableUpdates)) (TableUpdates, error) {
+	c.mu.Lock()
+	c.updates = fn
+	c.mu.Unlock()
+
+	reqs := make(map[string]interface{})
+	for t, cols := range tables {
+		req := make(map[string]interface{})
+		if len(cols) > 0 {
+			req["columns"] = cols
+		}
+		reqs[t] = req
+	}
+	initial := make(TableUpdates)
+	err := c.Call("monitor", &initial, db, name, reqs)
+	return initial, err
+}
+
+// Cancels the monitor called name.
+func (c *Client) MonitorCancel(name string) error {
+	return c.Call("monitor_cancel", nil, name)
+}
+
+func (c *Client) notify(r *response) {
+	if len(r.Params) != 2 {
+		return
+	}
+	var name string
+	json.Unmarshal(r.Params[0], &name)
+	u := make(TableUpdates)
+	if err := json.Unmarshal(r.Params[1], &u); err != nil {
+		return
+	}
+	c.mu.Lock()
+	fn := c.updates
+	c.mu.Unlock()
+	if fn != nil {
+		fn(name, u)
+	}
+}
@@ -0,0 +1,362 @@
+// Package ovsdb is a small client for the Open vSwitch Database
+// Management Protocol (RFC 7047). It is used to configure what
+// OpenFlow can't, such as queues, tunnels and BFD, on Open
+// vSwitch targets.
+package ovsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long to wait for a reply from the database server.
+var Timeout = time.Second * 10
+
+var ErrClosed = errors.New("The OVSDB connection is closed.")
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     interface{}   `json:"id"`
+}
+
+type response struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  interface{}       `json:"error"`
+	Id     interface{}       `json:"id"`
+}
+
+// A Client is a connection to an OVSDB server.
+type Client struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	encMu   sync.Mutex
+	id      uint64
+	pending map[uint64]chan *response
+	mu      sync.Mutex
+	closed  chan struct{}
+	// Called with the table updates of each monitor
+	// notification.
+	updates func(monitor string, u TableUpdates)
+}
+
+// Connects to the OVSDB server at addr. network is "tcp" or
+// "unix", for example Dial("unix", "/var/run/openvswitch/db.sock").
+func Dial(network, addr string) (*Client, error) {
+	conn, err := net.DialTimeout(network, addr, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Returns a client using an established connection.
+func NewClient(conn net.Conn) *Client {
+	c := new(Client)
+	c.conn = conn
+	c.enc = json.NewEncoder(conn)
+	c.pending = make(map[uint64]chan *response)
+	c.closed = make(chan struct{})
+	go c.read()
+	return c
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) read() {
+	defer close(c.closed)
+	dec := json.NewDecoder(c.conn)
+	for {
+		r := new(response)
+		if err := dec.Decode(r); err != nil {
+			if err != io.EOF {
+				c.conn.Close()
+			}
+			return
+		}
+		switch r.Method {
+		case "echo":
+			// Keepalives from the server must be answered.
+			go c.send(map[string]interface{}{"result": r.Params, "error": nil, "id": r.Id})
+			continue
+		case "update":
+			c.notify(r)
+			continue
+		}
+		id, ok := r.Id.(float64)
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[uint64(id)]
+		delete(c.pending, uint64(id))
+		c.mu.Unlock()
+		if ok {
+			ch <- r
+		}
+	}
+}
+
+func (c *Client) send(v interface{}) error {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	return c.enc.Encode(v)
+}
+
+// Calls method with params and decodes the result into result.
+func (c *Client) Call(method string, result interface{}, params ...interface{}) error {
+	c.mu.Lock()
+	c.id += 1
+	id := c.id
+	ch := make(chan *response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if params == nil {
+		params = make([]interface{}, 0)
+	}
+	if err := c.send(request{method, params, id}); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return err
+	}
+
+	select {
+	case r := <-ch:
+		if r.Error != nil {
+			return fmt.Errorf("OVSDB %s failed: %v", method, r.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(r.Result, result)
+	case <-c.closed:
+		return ErrClosed
+	case <-time.After(Timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("OVSDB %s timed out.", method)
+	}
+}
+
+// Returns the names of the databases on the server.
+func (c *Client) ListDbs() ([]string, error) {
+	dbs := make([]string, 0)
+	err := c.Call("list_dbs", &dbs)
+	return dbs, err
+}
+
+// Runs ops as a single transaction on db. Returns an error if
+// any operation failed, in which case none of them are applied.
+func (c *Client) Transact(db string, ops ...Operation) ([]OperationResult, error) {
+	params := []interface{}{db}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+	res := make([]OperationResult, 0)
+	if err := c.Call("transact", &res, params...); err != nil {
+		return res, err
+	}
+	for i, r := range res {
+		if r.Error != "" {
+			return res, fmt.Errorf("OVSDB operation %d failed: %s: %s", i, r.Error, r.Details)
+		}
+	}
+	return res, nil
+}
+
+// An operation in a transaction, see RFC 7047 section 5.2.
+type Operation struct {
+	Op        string                 `json:"op"`
+	Table     string                 `json:"table,omitempty"`
+	Row       map[string]interface{} `json:"row,omitempty"`
+	Where     []Condition            `json:"where,omitempty"`
+	Columns   []string               `json:"columns,omitempty"`
+	Mutations []Mutation             `json:"mutations,omitempty"`
+	UUIDName  string                 `json:"uuid-name,omitempty"`
+}
+
+func (op Operation) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{"op": op.Op}
+	if op.Table != "" {
+		m["table"] = op.Table
+	}
+	// Inserts always need a row, even an empty one.
+	if op.Row != nil || op.Op == "insert" {
+		row := op.Row
+		if row == nil {
+			row = make(map[string]interface{})
+		}
+		m["row"] = row
+	}
+	if op.Where != nil {
+		m["where"] = op.Where
+	}
+	if op.Columns != nil {
+		m["columns"] = op.Columns
+	}
+	if op.Mutations != nil {
+		m["mutations"] = op.Mutations
+	}
+	if op.UUIDName != "" {
+		m["uuid-name"] = op.UUIDName
+	}
+	return json.Marshal(m)
+}
+
+func Insert(table string, row map[string]interface{}, uuidName string) Operation {
+	return Operation{Op: "insert", Table: table, Row: row, UUIDName: uuidName}
+}
+
+func Select(table string, where []Condition, columns ...string) Operation {
+	return Operation{Op: "select", Table: table, Where: nonNil(where), Columns: columns}
+}
+
+func Update(table string, where []Condition, row map[string]interface{}) Operation {
+	return Operation{Op: "update", Table: table, Where: nonNil(where), Row: row}
+}
+
+func Mutate(table string, where []Condition, mutations ...Mutation) Operation {
+	return Operation{Op: "mutate", Table: table, Where: nonNil(where), Mutations: mutations}
+}
+
+func Delete(table string, where []Condition) Operation {
+	return Operation{Op: "delete", Table: table, Where: nonNil(where)}
+}
+
+// "where" is required by select, update, mutate and delete even
+// when it is empty.
+func nonNil(where []Condition) []Condition {
+	if where == nil {
+		return make([]Condition, 0)
+	}
+	return where
+}
+
+// A condition of the form [column, function, value].
+type Condition []interface{}
+
+func Where(column, function string, value interface{}) Condition {
+	return Condition{column, function, value}
+}
+
+// A mutation of the form [column, mutator, value].
+type Mutation []interface{}
+
+func NewMutation(column, mutator string, value interface{}) Mutation {
+	return Mutation{column, mutator, value}
+}
+
+// The result of one operation in a transaction.
+type OperationResult struct {
+	Count   int                      `json:"count,omitempty"`
+	UUID    []interface{}            `json:"uuid,omitempty"`
+	Rows    []map[string]interface{} `json:"rows,omitempty"`
+	Error   string                   `json:"error,omitempty"`
+	Details string                   `json:"details,omitempty"`
+}
+
+// Returns the UUID of a row created by an insert operation.
+func (r OperationResult) RowUUID() string {
+	if len(r.UUID) == 2 {
+		if s, ok := r.UUID[1].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// Returns an OVSDB <uuid>.
+func UUID(id string) []interface{} {
+	return []interface{}{"uuid", id}
+}
+
+// Returns an OVSDB <named-uuid>, referring to a row inserted in
+// the same transaction.
+func NamedUUID(name string) []interface{} {
+	return []interface{}{"named-uuid", name}
+}
+
+// Returns an OVSDB <set>.
+func Set(values ...interface{}) []interface{} {
+	if values == nil {
+		values = make([]interface{}, 0)
+	}
+	return []interface{}{"set", values}
+}
+
+// Returns an OVSDB <map> with string keys and values.
+func Map(pairs map[string]string) []interface{} {
+	a := make([]interface{}, 0, len(pairs))
+	for k, v := range pairs {
+		a = append(a, []interface{}{k, v})
+	}
+	return []interface{}{"map", a}
+}
+
+// Returns an OVSDB <map> with integer keys.
+func IntMap(pairs map[int]interface{}) []interface{} {
+	a := make([]interface{}, 0, len(pairs))
+	for k, v := range pairs {
+		a = append(a, []interface{}{k, v})
+	}
+	return []interface{}{"map", a}
+}
+
+// Returns the string keys and values of an OVSDB <map> decoded
+// from JSON. Values that aren't strings are skipped.
+func ParseMap(v interface{}) map[string]string {
+	m := make(map[string]string)
+	a, ok := v.([]interface{})
+	if !ok || len(a) != 2 || a[0] != "map" {
+		return m
+	}
+	pairs, _ := a[1].([]interface{})
+	for _, p := range pairs {
+		kv, ok := p.([]interface{})
+		if !ok || len(kv) != 2 {
+			continue
+		}
+		k, ok1 := kv[0].(string)
+		v, ok2 := kv[1].(string)
+		if ok1 && ok2 {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// Returns the ids of an OVSDB <uuid>, or of a <set> of them,
+// decoded from JSON.
+func ParseUUIDs(v interface{}) []string {
+	ids := make([]string, 0)
+	a, ok := v.([]interface{})
+	if !ok || len(a) != 2 {
+		return ids
+	}
+	if a[0] == "uuid" {
+		if id, ok := a[1].(string); ok {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	if a[0] != "set" {
+		return ids
+	}
+	values, _ := a[1].([]interface{})
+	for _, e := range values {
+		ids = append(ids, ParseUUIDs(e)...)
+	}
+	return ids
+}
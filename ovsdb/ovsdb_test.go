@@ -0,0 +1,113 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestOperationMarshal(t *testing.T) {
+	op := Update("Port", []Condition{Where("name", "==", "eth0")},
+		map[string]interface{}{"qos": NamedUUID("q")})
+	data, _ := json.Marshal(op)
+	exp := `{"op":"update","row":{"qos":["named-uuid","q"]},"table":"Port",` +
+		`"where":[["name","==","eth0"]]}`
+	if string(data) != exp {
+		t.Log("Exp:", exp)
+		t.Log("Rec:", string(data))
+		t.Error("Operation encoded incorrectly.")
+	}
+}
+
+func TestTransact(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewClient(client)
+	defer c.Close()
+
+	go func() {
+		dec := json.NewDecoder(server)
+		enc := json.NewEncoder(server)
+		// The server sends an echo first, which the client
+		// must answer before its request is handled.
+		enc.Encode(map[string]interface{}{"method": "echo", "params": []interface{}{}, "id": "echo"})
+		for i := 0; i < 2; i++ {
+			var req map[string]interface{}
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			if req["method"] == "transact" {
+				enc.Encode(map[string]interface{}{
+					"result": []interface{}{map[string]interface{}{"uuid": []interface{}{"uuid", "1234"}}},
+					"error":  nil,
+					"id":     req["id"],
+				})
+			}
+		}
+	}()
+
+	res, err := c.Transact("Open_vSwitch", Insert("Queue", map[string]interface{}{}, "q"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].RowUUID() != "1234" {
+		t.Errorf("Got result %+v.", res)
+	}
+}
+
+func TestTransactError(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewClient(client)
+	defer c.Close()
+
+	go func() {
+		dec := json.NewDecoder(server)
+		enc := json.NewEncoder(server)
+		var req map[string]interface{}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		// The second operation fails, so the server aborts
+		// the whole transaction.
+		enc.Encode(map[string]interface{}{
+			"result": []interface{}{
+				map[string]interface{}{"uuid": []interface{}{"uuid", "1234"}},
+				map[string]interface{}{"error": "constraint violation", "details": "no row"},
+			},
+			"error": nil,
+			"id":    req["id"],
+		})
+	}()
+
+	ops := []Operation{
+		Insert("Queue", map[string]interface{}{}, "q"),
+		Update("Port", []Condition{Where("name", "==", "eth9")}, map[string]interface{}{"qos": NamedUUID("q")}),
+	}
+	res, err := c.Transact("Open_vSwitch", ops...)
+	if err == nil {
+		t.Fatal("A failed operation didn't fail the transaction.")
+	}
+	if len(res) != 2 || res[1].Error != "constraint violation" {
+		t.Errorf("Got result %+v.", res)
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	var v interface{}
+	json.Unmarshal([]byte(`["map",[["state","up"],["forwarding","true"]]]`), &v)
+	m := ParseMap(v)
+	if len(m) != 2 || m["state"] != "up" || m["forwarding"] != "true" {
+		t.Errorf("Got map %v.", m)
+	}
+}
+
+func TestParseUUIDs(t *testing.T) {
+	var v interface{}
+	json.Unmarshal([]byte(`["set",[["uuid","a1"],["uuid","b2"]]]`), &v)
+	if ids := ParseUUIDs(v); len(ids) != 2 || ids[0] != "a1" || ids[1] != "b2" {
+		t.Errorf("Got ids %v.", ids)
+	}
+	json.Unmarshal([]byte(`["uuid","c3"]`), &v)
+	if ids := ParseUUIDs(v); len(ids) != 1 || ids[0] != "c3" {
+		t.Errorf("Got ids %v.", ids)
+	}
+}
@@ -0,0 +1,305 @@
+package ogo
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jonstout/ogo/ovsdb"
+)
+
+// Published when an OpenFlow port of an Open vSwitch bridge is
+// matched with its OVSDB interface, or the interface changes,
+// with the OVSPort as data.
+const EventOVSPort = "port.ovsdb"
+
+// The interface types of Open vSwitch tunnel ports.
+var TunnelTypes = map[string]bool{"vxlan": true, "gre": true, "geneve": true, "stt": true, "lisp": true}
+
+// An OpenFlow port of an Open vSwitch bridge and what OVSDB says
+// about its interface. Port is 0 until the port number is known.
+// Type is the interface type, empty for system interfaces.
+type OVSPort struct {
+	DPID string `json:"dpid"`
+	Port uint16 `json:"port"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	// The options of tunnels.
+	RemoteIP string `json:"remote_ip,omitempty"`
+	LocalIP  string `json:"local_ip,omitempty"`
+	Key      string `json:"key,omitempty"`
+	// The interface at the other end of a patch port and, if its
+	// bridge is tracked too, its switch and port.
+	Peer     string `json:"peer,omitempty"`
+	PeerDPID string `json:"peer_dpid,omitempty"`
+	PeerPort uint16 `json:"peer_port,omitempty"`
+}
+
+func (p OVSPort) Tunnel() bool {
+	return TunnelTypes[p.Type]
+}
+
+func (p OVSPort) Patch() bool {
+	return p.Type == "patch"
+}
+
+// An OVSPortTracker correlates the interfaces of Open vSwitch
+// bridges in OVSDB with the OpenFlow ports the bridges report, as
+// ports are added at runtime, so the controller knows which ports
+// are tunnels, and to where, and which are patch ports, and to
+// which bridge. Those ports aren't edge ports: hosts aren't
+// learned or broadcasts flooded out them, and links out them are
+// labelled with the interface type in the topology.
+//
+// OVSDB and PortStatus messages report a new port in either order;
+// the port is described, and EventOVSPort published, once both
+// have. Ports are matched by interface name, or by the ofport
+// column once Open vSwitch has set it.
+type OVSPortTracker struct {
+	mu      sync.Mutex
+	bridges map[string]*ovsBridge
+	sub     *Subscription
+}
+
+type ovsBridge struct {
+	dpid net.HardwareAddr
+	name string
+	db   *ovsdb.Client
+	// The rows of the Bridge, Port and Interface tables, by table
+	// then UUID.
+	rows map[string]map[string]map[string]interface{}
+	// The interfaces of the bridge, by name.
+	ports map[string]OVSPort
+}
+
+// The tracker started last, consulted by isEdgePort and the
+// topology.
+var portTracker *OVSPortTracker
+
+func NewOVSPortTracker() *OVSPortTracker {
+	t := new(OVSPortTracker)
+	t.bridges = make(map[string]*ovsBridge)
+	return t
+}
+
+// Starts matching ports as switches report them.
+func (t *OVSPortTracker) Start() {
+	portTracker = t
+	t.sub = Subscribe(256, EventPortAdded, EventPortModified, EventPortDeleted, EventSwitchUp)
+	go func() {
+		for e := range t.sub.C {
+			t.refresh(e.DPID)
+		}
+	}()
+}
+
+func (t *OVSPortTracker) Stop() {
+	if t.sub != nil {
+		t.sub.Cancel()
+	}
+	if portTracker == t {
+		portTracker = nil
+	}
+}
+
+// Adds bridge, the Open vSwitch bridge of Switch dpid, whose OVSDB
+// server db is connected to. db must not be used for other
+// monitors.
+func (t *OVSPortTracker) AddBridge(dpid net.HardwareAddr, db *ovsdb.Client, bridge string) error {
+	b := &ovsBridge{dpid: dpid, name: bridge, db: db, ports: make(map[string]OVSPort),
+		rows: make(map[string]map[string]map[string]interface{})}
+	t.mu.Lock()
+	t.bridges[dpid.String()] = b
+	t.mu.Unlock()
+
+	tables := map[string][]string{
+		"Bridge":    {"name", "ports"},
+		"Port":      {"name", "interfaces"},
+		"Interface": {"name", "type", "options", "ofport"},
+	}
+	initial, err := db.Monitor("Open_vSwitch", "ogo-ports", tables, func(_ string, u ovsdb.TableUpdates) {
+		t.update(b, u)
+	})
+	if err != nil {
+		return err
+	}
+	t.update(b, initial)
+	return nil
+}
+
+func (t *OVSPortTracker) update(b *ovsBridge, u ovsdb.TableUpdates) {
+	t.mu.Lock()
+	for table, rows := range u {
+		if b.rows[table] == nil {
+			b.rows[table] = make(map[string]map[string]interface{})
+		}
+		for uuid, row := range rows {
+			if row.New == nil {
+				delete(b.rows[table], uuid)
+			} else {
+				b.rows[table][uuid] = row.New
+			}
+		}
+	}
+	t.mu.Unlock()
+	t.refresh(b.dpid)
+}
+
+// Describes the interfaces of the bridge of Switch dpid again, and
+// publishes the ports that changed.
+func (t *OVSPortTracker) refresh(dpid net.HardwareAddr) {
+	t.mu.Lock()
+	b, ok := t.bridges[dpid.String()]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	sw, _ := Switch(dpid)
+	ports := make(map[string]OVSPort)
+	for _, iface := range b.interfaces() {
+		name, _ := iface["name"].(string)
+		p := OVSPort{DPID: dpid.String(), Name: name}
+		p.Type, _ = iface["type"].(string)
+		if n, ok := iface["ofport"].(float64); ok && n > 0 && n <= 0xffff {
+			p.Port = uint16(n)
+		} else if sw != nil {
+			p.Port = portByName(sw, name)
+		}
+		options := ovsdb.ParseMap(iface["options"])
+		if p.Tunnel() {
+			p.RemoteIP, p.LocalIP, p.Key = options["remote_ip"], options["local_ip"], options["key"]
+		} else if p.Patch() {
+			p.Peer = options["peer"]
+		}
+		ports[name] = p
+	}
+	old := b.ports
+	b.ports = ports
+	changed := make([]OVSPort, 0)
+	for name, p := range ports {
+		if p.Port != 0 && p != old[name] {
+			changed = append(changed, t.resolve(p))
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Sort(ovsPortsByNumber(changed))
+	for _, p := range changed {
+		switch {
+		case p.Tunnel():
+			log.Println("Port", SwitchLabel(dpid), p.Port, "is a", p.Type, "tunnel to", p.RemoteIP)
+		case p.Patch():
+			log.Println("Port", SwitchLabel(dpid), p.Port, "is patched to", p.Peer)
+		}
+		Publish(EventOVSPort, dpid, p)
+	}
+}
+
+// Returns the Interface rows of the ports of b.
+func (b *ovsBridge) interfaces() []map[string]interface{} {
+	a := make([]map[string]interface{}, 0)
+	for _, br := range b.rows["Bridge"] {
+		if br["name"] != b.name {
+			continue
+		}
+		for _, port := range ovsdb.ParseUUIDs(br["ports"]) {
+			row, ok := b.rows["Port"][port]
+			if !ok {
+				continue
+			}
+			for _, iface := range ovsdb.ParseUUIDs(row["interfaces"]) {
+				if r, ok := b.rows["Interface"][iface]; ok {
+					a = append(a, r)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// Returns the number of the port of sw called name, or 0.
+func portByName(sw *OFSwitch, name string) uint16 {
+	for _, p := range sw.Ports() {
+		if strings.TrimRight(string(p.Name), "\x00") == name {
+			return p.PortNo
+		}
+	}
+	return 0
+}
+
+// Fills in the switch and port at the other end of patch port p.
+// The lock of t must be held.
+func (t *OVSPortTracker) resolve(p OVSPort) OVSPort {
+	if !p.Patch() || p.Peer == "" {
+		return p
+	}
+	for _, b := range t.bridges {
+		if peer, ok := b.ports[p.Peer]; ok && peer.Peer == p.Name {
+			p.PeerDPID, p.PeerPort = peer.DPID, peer.Port
+			break
+		}
+	}
+	return p
+}
+
+type ovsPortsByNumber []OVSPort
+
+func (a ovsPortsByNumber) Len() int      { return len(a) }
+func (a ovsPortsByNumber) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ovsPortsByNumber) Less(i, j int) bool {
+	if a[i].DPID != a[j].DPID {
+		return a[i].DPID < a[j].DPID
+	}
+	return a[i].Port < a[j].Port
+}
+
+// Returns the ports of every bridge, by switch then port number.
+// Ports without a number yet are listed first.
+func (t *OVSPortTracker) Ports() []OVSPort {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := make([]OVSPort, 0)
+	for _, b := range t.bridges {
+		for _, p := range b.ports {
+			a = append(a, t.resolve(p))
+		}
+	}
+	sort.Sort(ovsPortsByNumber(a))
+	return a
+}
+
+// Returns port of Switch dpid.
+func (t *OVSPortTracker) Port(dpid net.HardwareAddr, port uint16) (OVSPort, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.bridges[dpid.String()]; ok && port != 0 {
+		for _, p := range b.ports {
+			if p.Port == port {
+				return t.resolve(p), true
+			}
+		}
+	}
+	return OVSPort{}, false
+}
+
+// Returns the interface type of port of Switch dpid if it is a
+// tunnel or patch port known to the running tracker, or "".
+func virtualPortType(dpid net.HardwareAddr, port uint16) string {
+	t := portTracker
+	if t == nil {
+		return ""
+	}
+	if p, ok := t.Port(dpid, port); ok && (p.Tunnel() || p.Patch()) {
+		return p.Type
+	}
+	return ""
+}
+
+func (t *OVSPortTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Ports())
+}
@@ -0,0 +1,292 @@
+package ogo
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Priorities of paced flow mods. Higher priority flow mods are
+// sent first.
+const (
+	PriorityLow    = -100
+	PriorityNormal = 0
+	PriorityHigh   = 100
+)
+
+var ErrFlowModQueueFull = errors.New("The flow mod queue of the switch is full.")
+
+// Limits how fast flow mods are sent to a switch, for hardware
+// that can't keep up with bursts.
+//
+// Queued flow mods are sent in order of priority, except that
+// flow mods with the same match are sent in the order they were
+// queued, so a delete never overtakes the add it undoes. Barriers
+// and bundle messages are queued too, as fences: they are sent,
+// without waiting for the rate, after every flow mod queued
+// before them and before any queued after them, so a barrier is
+// only answered once the flow mods before it were processed.
+type Pacing struct {
+	// Flow mods per second.
+	Rate int
+	// Flow mods that can be sent at once after the switch
+	// has been idle.
+	Burst int
+	// Flow mods that can be queued before senders block.
+	QueueSize int
+}
+
+// Paces flow mods sent to Switch s. A zero Rate disables
+// pacing; flow mods already queued are then sent at once.
+func (s *OFSwitch) SetPacing(p Pacing) {
+	s.pacerMu.Lock()
+	defer s.pacerMu.Unlock()
+	if s.pacer != nil {
+		s.pacer.stop()
+		s.pacer = nil
+	}
+	if p.Rate <= 0 {
+		return
+	}
+	if p.Burst < 1 {
+		p.Burst = 1
+	}
+	if p.QueueSize < 1 {
+		p.QueueSize = 1
+	}
+	s.pacer = newPacer(s, p)
+	go s.pacer.run()
+}
+
+func (s *OFSwitch) pacing() *pacer {
+	s.pacerMu.Lock()
+	defer s.pacerMu.Unlock()
+	return s.pacer
+}
+
+// Queues flow mod msg to be sent to Switch s with priority. If
+// block is false ErrFlowModQueueFull is returned instead of
+// waiting for room in the queue. Without pacing msg is sent
+// immediately.
+func (s *OFSwitch) SendFlowMod(msg util.Message, priority int, block bool) error {
+	if p := s.pacing(); p != nil {
+		return p.enqueue(msg, priority, block)
+	}
+	return s.send(msg)
+}
+
+// Returns the number of flow mods waiting to be sent to Switch s.
+func (s *OFSwitch) QueuedFlowMods() int {
+	if p := s.pacing(); p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.queue)
+	}
+	return 0
+}
+
+func isFlowMod(msg util.Message) bool {
+	switch msg.(type) {
+	case *ofp10.FlowMod, *ofp14.FlowMod:
+		return true
+	}
+	return false
+}
+
+// Returns true if msg must not overtake the flow mods queued
+// before it, or be overtaken by those queued after it: barrier
+// requests and bundle messages.
+func isFence(msg util.Message) bool {
+	switch m := msg.(type) {
+	case *ofp14.BundleCtrl, *ofp14.BundleAdd:
+		return true
+	case *ofpxx.Header:
+		if m.Version == ofp10.VERSION {
+			return m.Type == ofp10.Type_BarrierRequest
+		}
+		return m.Type == ofp14.Type_BarrierRequest
+	}
+	return false
+}
+
+// Returns the table and match of flow mod msg.
+func flowModKey(msg util.Message) string {
+	switch f := msg.(type) {
+	case *ofp10.FlowMod:
+		data, _ := f.Match.MarshalBinary()
+		return string(data)
+	case *ofp14.FlowMod:
+		return string(append([]byte{f.TableId}, f.Match.Fields...))
+	}
+	return ""
+}
+
+type pacedFlowMod struct {
+	msg      util.Message
+	priority int
+	seq      uint64
+	// The number of fences queued before the flow mod, and
+	// whether it is a fence itself.
+	epoch uint64
+	fence bool
+	key   string
+}
+
+// A priority queue of flow mods, in order of the fences before
+// them, then of priority and then of arrival. A fence comes after
+// the flow mods of its epoch.
+type flowModQueue []pacedFlowMod
+
+func (q flowModQueue) Len() int      { return len(q) }
+func (q flowModQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q flowModQueue) Less(i, j int) bool {
+	if q[i].epoch != q[j].epoch {
+		return q[i].epoch < q[j].epoch
+	}
+	if q[i].fence != q[j].fence {
+		return !q[i].fence
+	}
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q *flowModQueue) Push(x interface{}) { *q = append(*q, x.(pacedFlowMod)) }
+
+func (q *flowModQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// A token bucket sending queued flow mods to a switch.
+type pacer struct {
+	sw    *OFSwitch
+	cfg   Pacing
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue flowModQueue
+	seq   uint64
+	epoch uint64
+	// The priority of the last flow mod queued for each match
+	// still in the queue, and how many are.
+	keys    map[string]pacedKey
+	tokens  float64
+	last    time.Time
+	stopped bool
+}
+
+func newPacer(sw *OFSwitch, cfg Pacing) *pacer {
+	p := &pacer{sw: sw, cfg: cfg}
+	p.cond = sync.NewCond(&p.mu)
+	p.queue = make(flowModQueue, 0)
+	p.keys = make(map[string]pacedKey)
+	p.tokens = float64(cfg.Burst)
+	p.last = time.Now()
+	return p
+}
+
+func (p *pacer) enqueue(msg util.Message, priority int, block bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.queue) >= p.cfg.QueueSize && !p.stopped {
+		if !block {
+			return ErrFlowModQueueFull
+		}
+		if !p.sw.connected() {
+			return ErrSwitchDisconnected
+		}
+		p.cond.Wait()
+	}
+	if p.stopped {
+		return p.sw.send(msg)
+	}
+	p.seq += 1
+	f := pacedFlowMod{msg: msg, priority: priority, seq: p.seq, epoch: p.epoch, key: flowModKey(msg)}
+	// A flow mod can't go ahead of an earlier one with the same
+	// match.
+	k, ok := p.keys[f.key]
+	if ok && k.priority < f.priority {
+		f.priority = k.priority
+	}
+	p.keys[f.key] = pacedKey{f.priority, k.queued + 1}
+	heap.Push(&p.queue, f)
+	p.cond.Broadcast()
+	return nil
+}
+
+type pacedKey struct {
+	priority int
+	queued   int
+}
+
+// Queues fence msg after the flow mods queued so far. Fences
+// don't count against the size of the queue.
+func (p *pacer) enqueueFence(msg util.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return p.sw.send(msg)
+	}
+	p.seq += 1
+	heap.Push(&p.queue, pacedFlowMod{msg: msg, seq: p.seq, epoch: p.epoch, fence: true})
+	p.epoch += 1
+	p.cond.Broadcast()
+	return nil
+}
+
+func (p *pacer) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *pacer) run() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.stopped {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		if !p.stopped && !p.queue[0].fence {
+			now := time.Now()
+			p.tokens += now.Sub(p.last).Seconds() * float64(p.cfg.Rate)
+			if p.tokens > float64(p.cfg.Burst) {
+				p.tokens = float64(p.cfg.Burst)
+			}
+			p.last = now
+			if p.tokens < 1 {
+				wait := time.Duration((1 - p.tokens) / float64(p.cfg.Rate) * float64(time.Second))
+				p.mu.Unlock()
+				time.Sleep(wait)
+				continue
+			}
+			p.tokens -= 1
+		}
+		f := heap.Pop(&p.queue).(pacedFlowMod)
+		if !f.fence {
+			if k := p.keys[f.key]; k.queued > 1 {
+				p.keys[f.key] = pacedKey{k.priority, k.queued - 1}
+			} else {
+				delete(p.keys, f.key)
+			}
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+
+		// Messages for a disconnected switch are dropped.
+		p.sw.send(f.msg)
+	}
+}
@@ -0,0 +1,56 @@
+package ogo
+
+import (
+	"container/heap"
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+func pacedFlow(dst string, command uint16) *ofp10.FlowMod {
+	f := ofp10.NewFlowMod()
+	f.Command = command
+	f.Match = FlowMatch{IPDst: net.ParseIP(dst)}.ofp10()
+	return f
+}
+
+func TestPacerOrder(t *testing.T) {
+	p := newPacer(nil, Pacing{Rate: 1, Burst: 1, QueueSize: 10})
+	add := pacedFlow("10.0.0.1", ofp10.FC_ADD)
+	del := pacedFlow("10.0.0.1", ofp10.FC_DELETE)
+	other := pacedFlow("10.0.0.2", ofp10.FC_ADD)
+	barrier := ofp10.NewBarrierRequest()
+	late := pacedFlow("10.0.0.3", ofp10.FC_ADD)
+
+	p.enqueue(add, PriorityLow, false)
+	p.enqueue(del, PriorityHigh, false)
+	p.enqueue(other, PriorityNormal, false)
+	p.enqueueFence(barrier)
+	p.enqueue(late, PriorityHigh, false)
+
+	want := []util.Message{other, add, del, barrier, late}
+	for i, w := range want {
+		got := heap.Pop(&p.queue).(pacedFlowMod).msg
+		if got != w {
+			t.Fatalf("Message %d sent out of order.", i)
+		}
+	}
+}
+
+func TestIsFence(t *testing.T) {
+	tests := []struct {
+		msg  util.Message
+		want bool
+	}{
+		{ofp10.NewBarrierRequest(), true},
+		{ofp10.NewEchoRequest(), false},
+		{ofp10.NewFlowMod(), false},
+	}
+	for i, test := range tests {
+		if got := isFence(test.msg); got != test.want {
+			t.Errorf("%d: isFence = %v, expected %v.", i, got, test.want)
+		}
+	}
+}
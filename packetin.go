@@ -0,0 +1,272 @@
+package ogo
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// A PacketInHandler takes part in the ordered packet-in chain.
+// It returns true if it consumed pkt, which stops the packet-in
+// from reaching handlers further down the chain and
+// applications implementing ofp10.PacketInReactor.
+type PacketInHandler interface {
+	HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool
+}
+
+// Adapts an ordinary function to a PacketInHandler.
+type PacketInHandlerFunc func(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool
+
+func (f PacketInHandlerFunc) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	return f(dpid, pkt)
+}
+
+// Why a switch sent a packet-in. OpenFlow 1.0 only has the first
+// two; OpenFlow 1.4 adds more, see ofp14.R_ACTION_SET.
+const (
+	ReasonNoMatch    = 0
+	ReasonAction     = 1
+	ReasonInvalidTTL = 2
+)
+
+// Selects the packet-ins a handler sees. Empty fields select
+// every packet-in.
+//
+// OpenFlow 1.0 packet-ins carry neither a table nor a cookie, so
+// they only reach handlers whose filter has no Tables and no
+// CookieMask.
+type PacketInFilter struct {
+	Reasons []uint8
+	Tables  []uint8
+	// Selects packet-ins sent by flows whose cookie, masked
+	// with CookieMask, is Cookie, such as the flows of one
+	// application, see RegisterFlowOwner.
+	Cookie     uint64
+	CookieMask uint64
+}
+
+// What a switch said about a packet-in besides the packet.
+type packetInMeta struct {
+	reason uint8
+	// False for OpenFlow 1.0 packet-ins.
+	tables bool
+	table  uint8
+	cookie uint64
+}
+
+// Reports whether f selects a packet-in described by m.
+func (f *PacketInFilter) selects(m packetInMeta) bool {
+	if len(f.Reasons) > 0 && !hasByte(f.Reasons, m.reason) {
+		return false
+	}
+	if len(f.Tables) == 0 && f.CookieMask == 0 {
+		return true
+	}
+	if !m.tables {
+		return false
+	}
+	if len(f.Tables) > 0 && !hasByte(f.Tables, m.table) {
+		return false
+	}
+	return m.cookie&f.CookieMask == f.Cookie&f.CookieMask
+}
+
+func hasByte(a []uint8, b uint8) bool {
+	for _, x := range a {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Per handler packet-in statistics.
+type PacketInStats struct {
+	Name     string
+	Priority int
+	Handled  uint64
+	Consumed uint64
+	// Total and largest time spent in the handler.
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Returns the average time spent in the handler.
+func (s PacketInStats) Average() time.Duration {
+	if s.Handled == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Handled)
+}
+
+type packetInEntry struct {
+	// Updated atomically, first so they are aligned.
+	handled  uint64
+	consumed uint64
+	total    int64
+	max      int64
+	handler  PacketInHandler
+	// Nil for handlers added without a filter.
+	filter   *PacketInFilter
+	name     string
+	priority int
+}
+
+// Returns the statistics of e.
+func (e *packetInEntry) stats() PacketInStats {
+	return PacketInStats{e.name, e.priority, atomic.LoadUint64(&e.handled), atomic.LoadUint64(&e.consumed),
+		time.Duration(atomic.LoadInt64(&e.total)), time.Duration(atomic.LoadInt64(&e.max))}
+}
+
+// Records that the handler of e took d for a packet-in.
+func (e *packetInEntry) record(d time.Duration, consumed bool) {
+	atomic.AddUint64(&e.handled, 1)
+	atomic.AddInt64(&e.total, int64(d))
+	for {
+		max := atomic.LoadInt64(&e.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&e.max, max, int64(d)) {
+			break
+		}
+	}
+	if consumed {
+		atomic.AddUint64(&e.consumed, 1)
+	}
+}
+
+// The handlers run for every packet-in, read from snapshot without
+// locking. Writers hold the lock and store a new slice.
+type packetInChain struct {
+	sync.Mutex
+	snapshot atomic.Value
+}
+
+var packetIns = newPacketInChain()
+
+func newPacketInChain() *packetInChain {
+	c := new(packetInChain)
+	c.snapshot.Store([]*packetInEntry{})
+	return c
+}
+
+func (p *packetInChain) entries() []*packetInEntry {
+	return p.snapshot.Load().([]*packetInEntry)
+}
+
+// Adds h to the packet-in chain. Handlers run from highest to
+// lowest priority, handlers with equal priority run in the order
+// they were added. Applications implementing
+// ofp10.PacketInReactor run after the whole chain.
+func (c *Controller) AddPacketInHandler(name string, priority int, h PacketInHandler) {
+	c.addPacketInEntry(&packetInEntry{handler: h, name: name, priority: priority})
+}
+
+// Like AddPacketInHandler, but h only sees the packet-ins f
+// selects. Handlers added without a filter only see OpenFlow 1.0
+// packet-ins; h also sees those of newer switches, converted to
+// OpenFlow 1.0 with the header of the original, so it must check
+// the header's version before replying.
+//
+//	c.AddFilteredPacketInHandler("lldp", 100, ogo.PacketInFilter{
+//		Reasons: []uint8{ogo.ReasonAction}, Cookie: cookie, CookieMask: mask}, h)
+func (c *Controller) AddFilteredPacketInHandler(name string, priority int, f PacketInFilter, h PacketInHandler) {
+	c.addPacketInEntry(&packetInEntry{handler: h, filter: &f, name: name, priority: priority})
+}
+
+func (c *Controller) addPacketInEntry(e *packetInEntry) {
+	packetIns.Lock()
+	defer packetIns.Unlock()
+	// The chain is copied so packet-ins being handled keep
+	// using the old one.
+	old := packetIns.entries()
+	entries := make([]*packetInEntry, len(old), len(old)+1)
+	copy(entries, old)
+	entries = append(entries, e)
+	sort.Stable(byPriority(entries))
+	packetIns.snapshot.Store(entries)
+}
+
+// Removes the handler called name from the packet-in chain.
+func (c *Controller) RemovePacketInHandler(name string) {
+	packetIns.Lock()
+	defer packetIns.Unlock()
+	old := packetIns.entries()
+	for i, e := range old {
+		if e.name == name {
+			entries := make([]*packetInEntry, 0, len(old)-1)
+			entries = append(entries, old[:i]...)
+			packetIns.snapshot.Store(append(entries, old[i+1:]...))
+			return
+		}
+	}
+}
+
+// Returns the statistics of every handler in the packet-in
+// chain, in chain order.
+func (c *Controller) PacketInStats() []PacketInStats {
+	return packetIns.stats()
+}
+
+func (p *packetInChain) stats() []PacketInStats {
+	entries := p.entries()
+	a := make([]PacketInStats, len(entries))
+	for i, e := range entries {
+		a[i] = e.stats()
+	}
+	return a
+}
+
+// Runs pkt, described by m, through the handlers selecting it.
+// Returns true if a handler consumed it.
+func (p *packetInChain) handle(dpid net.HardwareAddr, pkt *ofp10.PacketIn, m packetInMeta) bool {
+	for _, e := range p.entries() {
+		if e.filter == nil && pkt.Header.Version != ofp10.VERSION {
+			continue
+		}
+		if e.filter != nil && !e.filter.selects(m) {
+			continue
+		}
+		start := time.Now()
+		consumed := e.call(dpid, pkt)
+		e.record(time.Since(start), consumed)
+		if consumed {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the handler of e, treating a panic as not consuming pkt.
+func (e *packetInEntry) call(dpid net.HardwareAddr, pkt *ofp10.PacketIn) (consumed bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			recordPanic(e.name, dpid, pkt, p)
+			consumed = false
+		}
+	}()
+	return e.handler.HandlePacketIn(dpid, pkt)
+}
+
+type byPriority []*packetInEntry
+
+func (a byPriority) Len() int           { return len(a) }
+func (a byPriority) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPriority) Less(i, j int) bool { return a[i].priority > a[j].priority }
+
+// Converts an OpenFlow 1.4 packet-in for the packet-in chain.
+func packetIn10(p *ofp14.PacketIn) (*ofp10.PacketIn, packetInMeta) {
+	pkt := ofp10.NewPacketIn()
+	pkt.Header = p.Header
+	pkt.BufferId = p.BufferId
+	pkt.TotalLen = p.TotalLen
+	pkt.Reason = p.Reason
+	pkt.Data = p.Data
+	if port, ok := p.InPort(); ok {
+		pkt.InPort = ofp10Port(port)
+	}
+	return pkt, packetInMeta{p.Reason, true, p.TableId, p.Cookie}
+}
@@ -0,0 +1,142 @@
+package ogo
+
+import "sort"
+
+// Returns the links of t leaving each switch, keyed by the
+// source DPID. Drained links are left out.
+func (t *Topology) adjacency() map[string][]TopologyLink {
+	adj := make(map[string][]TopologyLink)
+	for _, l := range t.Links {
+		if !l.Drained {
+			adj[l.Src] = append(adj[l.Src], l)
+		}
+	}
+	return adj
+}
+
+// Returns the links on a shortest path, by hop count, from
+// switch src to switch dst. Returns nil if dst can't be reached
+// and an empty path if src and dst are the same.
+func (t *Topology) ShortestPath(src, dst string) []TopologyLink {
+	prev := t.bfs(src)
+	if _, ok := prev[dst]; !ok {
+		return nil
+	}
+	path := make([]TopologyLink, 0)
+	for d := dst; d != src; d = prev[d].Src {
+		path = append([]TopologyLink{prev[d]}, path...)
+	}
+	return path
+}
+
+// Returns the links of a tree rooted at switch root that reaches
+// every switch in dsts along shortest paths. Links point away
+// from root. Switches that can't be reached are left out.
+func (t *Topology) Tree(root string, dsts []string) []TopologyLink {
+	prev := t.bfs(root)
+	seen := make(map[string]bool)
+	tree := make([]TopologyLink, 0)
+	for _, dst := range dsts {
+		if _, ok := prev[dst]; !ok {
+			continue
+		}
+		for d := dst; d != root && !seen[d]; d = prev[d].Src {
+			seen[d] = true
+			tree = append(tree, prev[d])
+		}
+	}
+	sort.Sort(topologyLinks(tree))
+	return tree
+}
+
+// Returns the link used to reach each switch from src in a
+// breadth first search. src maps to an empty link.
+func (t *Topology) bfs(src string) map[string]TopologyLink {
+	adj := t.adjacency()
+	prev := map[string]TopologyLink{src: {}}
+	queue := []string{src}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, l := range adj[s] {
+			if _, ok := prev[l.Dst]; !ok {
+				prev[l.Dst] = l
+				queue = append(queue, l.Dst)
+			}
+		}
+	}
+	return prev
+}
+
+// Returns the link of t from src to dst, if there is one.
+func (t *Topology) link(src, dst string) (TopologyLink, bool) {
+	for _, l := range t.Links {
+		if l.Src == src && l.Dst == dst {
+			return l, true
+		}
+	}
+	return TopologyLink{}, false
+}
+
+// Returns up to max shortest paths, by hop count, from switch src
+// to switch dst, in the order of t's links. Parallel links make
+// distinct paths. Returns nil if dst can't be reached.
+func (t *Topology) EqualCostPaths(src, dst string, max int) [][]TopologyLink {
+	// Hops from each switch to dst, by a search backwards
+	// along the links.
+	into := make(map[string][]TopologyLink)
+	for _, l := range t.Links {
+		if !l.Drained {
+			into[l.Dst] = append(into[l.Dst], l)
+		}
+	}
+	hops := map[string]int{dst: 0}
+	queue := []string{dst}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, l := range into[s] {
+			if _, ok := hops[l.Src]; !ok {
+				hops[l.Src] = hops[s] + 1
+				queue = append(queue, l.Src)
+			}
+		}
+	}
+	if _, ok := hops[src]; !ok {
+		return nil
+	}
+
+	adj := t.adjacency()
+	paths := make([][]TopologyLink, 0)
+	var walk func(s string, path []TopologyLink)
+	walk = func(s string, path []TopologyLink) {
+		if len(paths) == max {
+			return
+		}
+		if s == dst {
+			paths = append(paths, append([]TopologyLink(nil), path...))
+			return
+		}
+		for _, l := range adj[s] {
+			if h, ok := hops[l.Dst]; ok && h == hops[s]-1 {
+				walk(l.Dst, append(path, l))
+			}
+		}
+	}
+	walk(src, make([]TopologyLink, 0))
+	return paths
+}
+
+// Returns the links on a shortest path, by hop count, from
+// switch src to switch dst using only links with at least bps
+// bits per second available. Links of unknown capacity aren't
+// used. Returns nil if there is no such path.
+func (t *Topology) PathWithBandwidth(src, dst string, bps uint64) []TopologyLink {
+	fits := &Topology{Links: make([]TopologyLink, 0, len(t.Links))}
+	for _, l := range t.Links {
+		if l.Capacity != 0 && l.Available() >= bps {
+			fits.Links = append(fits.Links, l)
+		}
+	}
+	return fits.ShortestPath(src, dst)
+}
@@ -0,0 +1,153 @@
+package ogo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// The configuration of the applications to load, usually read
+// from a JSON file with LoadApps:
+//
+//	{"apps": [
+//		{"name": "multicast"},
+//		{"name": "acme", "path": "/usr/lib/ogo/acme.so", "domain": "pod1"},
+//		{"name": "hosts", "disabled": true}
+//	]}
+type AppConfig struct {
+	Apps []AppEntry `json:"apps"`
+}
+
+// An application to load. Entries without a path name a bundled
+// application, entries with one a Go plugin built with
+// -buildmode=plugin that exports either or both of
+//
+//	func NewInstance() interface{}
+//	func Attach(c *ogo.Controller)
+//
+// NewInstance is registered like an application passed to
+// RegisterApplication, for every switch or only those of Domain.
+// Attach is called once when the plugin is loaded.
+type AppEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// Bundled applications by name.
+var bundledApps = struct {
+	sync.RWMutex
+	m map[string]func(c *Controller)
+}{m: map[string]func(c *Controller){
+	"multicast": func(c *Controller) { NewMulticast().Attach(c) },
+	"hosts":     func(c *Controller) { NewHostTracker().Attach(c) },
+}}
+
+// Makes an application available to configurations as name.
+// attach starts the application on c.
+func RegisterBundledApp(name string, attach func(c *Controller)) error {
+	bundledApps.Lock()
+	defer bundledApps.Unlock()
+	if _, ok := bundledApps.m[name]; ok {
+		return fmt.Errorf("An application called %s is already registered.", name)
+	}
+	bundledApps.m[name] = attach
+	return nil
+}
+
+// Returns the names of the bundled applications.
+func BundledApps() []string {
+	bundledApps.RLock()
+	defer bundledApps.RUnlock()
+	a := make([]string, 0, len(bundledApps.m))
+	for name := range bundledApps.m {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Reads the AppConfig in the file at path and loads every
+// enabled application in it.
+func (c *Controller) LoadApps(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var cfg AppConfig
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return err
+	}
+	return c.LoadAppConfig(cfg)
+}
+
+// Loads every enabled application in cfg. Stops at the first
+// one that fails to load.
+func (c *Controller) LoadAppConfig(cfg AppConfig) error {
+	for _, e := range cfg.Apps {
+		if e.Disabled {
+			continue
+		}
+		if err := c.loadApp(e); err != nil {
+			return fmt.Errorf("Failed to load application %s: %v", e.Name, err)
+		}
+		log.Println("Loaded application", e.Name)
+	}
+	return nil
+}
+
+func (c *Controller) loadApp(e AppEntry) error {
+	if e.Path == "" {
+		bundledApps.RLock()
+		attach, ok := bundledApps.m[e.Name]
+		bundledApps.RUnlock()
+		if !ok {
+			return errors.New("No bundled application with this name.")
+		}
+		attach(c)
+		return nil
+	}
+	return c.LoadPlugin(e.Path, e.Domain)
+}
+
+// Opens the Go plugin at path and registers the application it
+// exports, for the switches of domain or every switch if domain
+// is empty.
+func (c *Controller) LoadPlugin(path, domain string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	if sym, err := p.Lookup("NewInstance"); err == nil {
+		fn, ok := sym.(func() interface{})
+		if !ok {
+			return errors.New("NewInstance must be a func() interface{}.")
+		}
+		if domain != "" {
+			c.AddDomain(domain).RegisterApplication(fn)
+		} else {
+			c.RegisterApplication(fn)
+		}
+		found = true
+	}
+	if sym, err := p.Lookup("Attach"); err == nil {
+		fn, ok := sym.(func(*Controller))
+		if !ok {
+			return errors.New("Attach must be a func(*ogo.Controller).")
+		}
+		fn(c)
+		found = true
+	}
+	if !found {
+		return errors.New("The plugin exports neither NewInstance nor Attach.")
+	}
+	return nil
+}
@@ -0,0 +1,595 @@
+package ogo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// A Policy is a prioritized list of match/action rules, with
+// named sets of hosts and ports the rules can refer to as $name.
+// Policies are written as text, one statement per line:
+//
+//	# Comments start with #.
+//	hosts web = 10.0.0.1 10.0.0.2
+//	ports uplinks = 1 2
+//	rule 200 match ip_dst=$web ip_proto=6 action output $uplinks
+//	rule 100 on 00:00:00:00:00:01 match eth_type=0x0806 action controller
+//	rule 10 match ip_src=10.0.0.9 action drop
+//	template access-port port rule 100 match in_port={port} action output 1
+//	on host join in group web apply template access-port
+//
+// or as the JSON encoding of Policy. Templates aren't installed
+// with the policy but applied on demand, see FlowTemplate, or
+// when an event happens, see PolicyTrigger. Match fields are in_port,
+// eth_src, eth_dst, eth_type, ip_src, ip_dst and ip_proto. The
+// actions are drop, controller, and output followed by port
+// numbers, port sets, flood, all or controller. A field or
+// output given a set expands into one flow per member.
+type Policy struct {
+	Hosts     map[string][]string      `json:"hosts,omitempty"`
+	Ports     map[string][]string      `json:"ports,omitempty"`
+	Rules     []PolicyRule             `json:"rules"`
+	Templates map[string]*FlowTemplate `json:"templates,omitempty"`
+	Triggers  []PolicyTrigger          `json:"triggers,omitempty"`
+}
+
+type PolicyRule struct {
+	Priority uint16 `json:"priority"`
+	// The DPID of the switch the rule applies to, every
+	// switch if empty.
+	Switch string            `json:"switch,omitempty"`
+	Match  map[string]string `json:"match,omitempty"`
+	Action string            `json:"action"`
+	// The line the rule was parsed from, 0 for JSON.
+	Line int `json:"-"`
+}
+
+// A flow compiled from a PolicyRule.
+type PolicyFlow struct {
+	// nil for every switch
+	DPID     net.HardwareAddr
+	Priority uint16
+	Match    FlowMatch
+	// Empty to drop.
+	Ports []uint16
+}
+
+// An error in a policy, at a line of a text policy or a rule of
+// a JSON one.
+type PolicyError struct {
+	Line int
+	Rule int
+	Msg  string
+}
+
+func (e *PolicyError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("rule %d: %s", e.Rule, e.Msg)
+}
+
+// Every error found in a policy.
+type PolicyErrors []*PolicyError
+
+func (e PolicyErrors) Error() string {
+	a := make([]string, len(e))
+	for i, err := range e {
+		a[i] = err.Error()
+	}
+	return strings.Join(a, "\n")
+}
+
+// Parses a text policy.
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	p := &Policy{Hosts: make(map[string][]string), Ports: make(map[string][]string),
+		Templates: make(map[string]*FlowTemplate)}
+	errs := make(PolicyErrors, 0)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		f := strings.Fields(text)
+		if len(f) == 0 {
+			continue
+		}
+		fail := func(format string, a ...interface{}) {
+			errs = append(errs, &PolicyError{Line: line, Msg: fmt.Sprintf(format, a...)})
+		}
+		switch f[0] {
+		case "hosts", "ports":
+			if len(f) < 3 || f[2] != "=" {
+				fail("expected %s <name> = <values>", f[0])
+				continue
+			}
+			if f[0] == "hosts" {
+				p.Hosts[f[1]] = f[3:]
+			} else {
+				p.Ports[f[1]] = f[3:]
+			}
+		case "rule":
+			rule, err := parseRule(f)
+			if err != nil {
+				fail("%s", err)
+				continue
+			}
+			rule.Line = line
+			p.Rules = append(p.Rules, rule)
+		case "template":
+			if err := p.parseTemplate(f, line); err != nil {
+				fail("%s", err)
+			}
+		case "on":
+			trigger, err := parseTrigger(f)
+			if err != nil {
+				fail("%s", err)
+				continue
+			}
+			trigger.Line = line
+			p.Triggers = append(p.Triggers, trigger)
+		default:
+			fail("unknown statement %q", f[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, t := range p.Templates {
+		if err := t.check(); err != nil {
+			errs = append(errs, &PolicyError{Line: t.Rules[0].Line, Msg: err.Error()})
+		}
+	}
+	// Sets may be defined after the triggers using them.
+	for _, t := range p.Triggers {
+		if err := p.checkTrigger(t); err != nil {
+			errs = append(errs, &PolicyError{Line: t.Line, Msg: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return p, nil
+}
+
+// Parses the fields of a line starting with "template", adding
+// its rule to the template.
+func (p *Policy) parseTemplate(f []string, line int) error {
+	if len(f) < 2 {
+		return fmt.Errorf("missing template name")
+	}
+	n := 2
+	for n < len(f) && f[n] != "rule" {
+		n++
+	}
+	rule, err := parseRule(f[n:])
+	if err != nil {
+		return err
+	}
+	rule.Line = line
+	t, ok := p.Templates[f[1]]
+	if !ok {
+		t = &FlowTemplate{Name: f[1], Params: f[2:n], policy: p}
+		p.Templates[f[1]] = t
+	} else if strings.Join(t.Params, " ") != strings.Join(f[2:n], " ") {
+		return fmt.Errorf("template %s has parameters %s", t.Name, strings.Join(t.Params, " "))
+	}
+	t.Rules = append(t.Rules, rule)
+	return nil
+}
+
+// Parses the fields of a line starting with "rule".
+func parseRule(f []string) (PolicyRule, error) {
+	var rule PolicyRule
+	if len(f) < 2 {
+		return rule, fmt.Errorf("missing priority")
+	}
+	prio, err := strconv.ParseUint(f[1], 0, 16)
+	if err != nil {
+		return rule, fmt.Errorf("bad priority %q", f[1])
+	}
+	rule.Priority = uint16(prio)
+	n := 2
+	if n < len(f) && f[n] == "on" {
+		if n+1 >= len(f) {
+			return rule, fmt.Errorf("missing switch after on")
+		}
+		rule.Switch = f[n+1]
+		n += 2
+	}
+	if n < len(f) && f[n] == "match" {
+		rule.Match = make(map[string]string)
+		for n += 1; n < len(f) && f[n] != "action"; n++ {
+			kv := strings.SplitN(f[n], "=", 2)
+			if len(kv) != 2 {
+				return rule, fmt.Errorf("expected field=value, got %q", f[n])
+			}
+			rule.Match[kv[0]] = kv[1]
+		}
+	}
+	if n >= len(f) || f[n] != "action" {
+		return rule, fmt.Errorf("missing action")
+	}
+	rule.Action = strings.Join(f[n+1:], " ")
+	return rule, nil
+}
+
+// Parses a JSON policy.
+func ParsePolicyJSON(r io.Reader) (*Policy, error) {
+	p := new(Policy)
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, err
+	}
+	for name, t := range p.Templates {
+		t.Name, t.policy = name, p
+		if err := t.check(); err != nil {
+			return nil, err
+		}
+	}
+	for i, t := range p.Triggers {
+		if err := p.checkTrigger(t); err != nil {
+			return nil, fmt.Errorf("trigger %d: %v", i+1, err)
+		}
+	}
+	return p, nil
+}
+
+// Validates p and compiles it into flows. Returns PolicyErrors
+// listing every invalid rule.
+func (p *Policy) Compile() ([]PolicyFlow, error) {
+	flows := make([]PolicyFlow, 0)
+	errs := make(PolicyErrors, 0)
+	for i, r := range p.Rules {
+		fs, err := p.compileRule(r)
+		if err != nil {
+			errs = append(errs, &PolicyError{Line: r.Line, Rule: i + 1, Msg: err.Error()})
+			continue
+		}
+		flows = append(flows, fs...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return flows, nil
+}
+
+func (p *Policy) compileRule(r PolicyRule) ([]PolicyFlow, error) {
+	var dpid net.HardwareAddr
+	if r.Switch != "" {
+		var err error
+		if dpid, err = net.ParseMAC(r.Switch); err != nil {
+			return nil, fmt.Errorf("bad switch %q", r.Switch)
+		}
+	}
+
+	matches := []FlowMatch{{}}
+	fields := make([]string, 0, len(r.Match))
+	for k := range r.Match {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for _, k := range fields {
+		values, err := p.expand(k, r.Match[k])
+		if err != nil {
+			return nil, err
+		}
+		next := make([]FlowMatch, 0, len(matches)*len(values))
+		for _, m := range matches {
+			for _, v := range values {
+				if err := setField(&m, k, v); err != nil {
+					return nil, err
+				}
+				next = append(next, m)
+			}
+		}
+		matches = next
+	}
+
+	ports, err := p.actionPorts(r.Action)
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]PolicyFlow, len(matches))
+	for i, m := range matches {
+		flows[i] = PolicyFlow{dpid, r.Priority, m, ports}
+	}
+	return flows, nil
+}
+
+// Returns the values of v, the members of the set it names if
+// it starts with $.
+func (p *Policy) expand(field, v string) ([]string, error) {
+	if !strings.HasPrefix(v, "$") {
+		return []string{v}, nil
+	}
+	name := v[1:]
+	var values []string
+	var ok bool
+	switch field {
+	case "in_port":
+		values, ok = p.Ports[name]
+	case "ip_src", "ip_dst":
+		values, ok = p.Hosts[name]
+	default:
+		return nil, fmt.Errorf("%s can't be a set", field)
+	}
+	if !ok {
+		return nil, fmt.Errorf("undefined set %s", v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("set %s is empty", v)
+	}
+	return values, nil
+}
+
+func setField(m *FlowMatch, k, v string) error {
+	switch k {
+	case "in_port":
+		port, err := policyPort(v)
+		if err != nil {
+			return err
+		}
+		m.InPort = port
+	case "eth_src", "eth_dst":
+		mac, err := net.ParseMAC(v)
+		if err != nil {
+			return fmt.Errorf("bad MAC address %q", v)
+		}
+		if k == "eth_src" {
+			m.EthSrc = mac
+		} else {
+			m.EthDst = mac
+		}
+	case "eth_type":
+		t, err := strconv.ParseUint(v, 0, 16)
+		if err != nil {
+			return fmt.Errorf("bad ethertype %q", v)
+		}
+		m.EthType = uint16(t)
+	case "ip_src", "ip_dst":
+		ip := net.ParseIP(v)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("bad IPv4 address %q", v)
+		}
+		if k == "ip_src" {
+			m.IPSrc = ip
+		} else {
+			m.IPDst = ip
+		}
+	case "ip_proto":
+		proto, err := strconv.ParseUint(v, 0, 8)
+		if err != nil {
+			return fmt.Errorf("bad IP protocol %q", v)
+		}
+		m.IPProto = uint8(proto)
+	default:
+		return fmt.Errorf("unknown match field %q", k)
+	}
+	return nil
+}
+
+// Returns the output ports of action, none for drop.
+func (p *Policy) actionPorts(action string) ([]uint16, error) {
+	f := strings.Fields(action)
+	if len(f) == 0 {
+		return nil, fmt.Errorf("missing action")
+	}
+	switch f[0] {
+	case "drop":
+		if len(f) > 1 {
+			return nil, fmt.Errorf("drop takes no arguments")
+		}
+		return nil, nil
+	case "controller":
+		return []uint16{ofp10.P_CONTROLLER}, nil
+	case "output":
+	default:
+		return nil, fmt.Errorf("unknown action %q", f[0])
+	}
+	if len(f) == 1 {
+		return nil, fmt.Errorf("output needs at least one port")
+	}
+	ports := make([]uint16, 0, len(f)-1)
+	for _, v := range f[1:] {
+		values := []string{v}
+		if strings.HasPrefix(v, "$") {
+			var ok bool
+			if values, ok = p.Ports[v[1:]]; !ok {
+				return nil, fmt.Errorf("undefined set %s", v)
+			}
+		}
+		for _, v := range values {
+			port, err := policyPort(v)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+func policyPort(v string) (uint16, error) {
+	switch v {
+	case "controller":
+		return ofp10.P_CONTROLLER, nil
+	case "flood":
+		return ofp10.P_FLOOD, nil
+	case "all":
+		return ofp10.P_ALL, nil
+	}
+	port, err := strconv.ParseUint(v, 0, 16)
+	if err != nil || port == 0 || port > uint64(ofp10.P_MAX) {
+		return 0, fmt.Errorf("bad port %q", v)
+	}
+	return uint16(port), nil
+}
+
+// A PolicyManager installs the flows of a policy on every
+// switch, including switches that connect later, and replaces
+// them when a new policy is applied. It serves the policy over
+// HTTP: GET returns it as JSON, PUT replaces it with a text or,
+// given Content-Type application/json, JSON policy.
+type PolicyManager struct {
+	// If set, the triggers of every policy applied are loaded
+	// into it.
+	Triggers *TriggerEngine
+
+	mu     sync.Mutex
+	policy *Policy
+	flows  []PolicyFlow
+	sub    *Subscription
+}
+
+func NewPolicyManager() *PolicyManager {
+	return &PolicyManager{policy: new(Policy)}
+}
+
+// Starts installing the policy on switches as they connect.
+func (m *PolicyManager) Start() {
+	m.sub = Subscribe(64, EventSwitchUp)
+	go func() {
+		for e := range m.sub.C {
+			if sw, ok := Switch(e.DPID); ok {
+				m.mu.Lock()
+				flows := m.flows
+				m.mu.Unlock()
+				installPolicy(sw, flows)
+			}
+		}
+	}()
+}
+
+func (m *PolicyManager) Stop() {
+	if m.sub != nil {
+		m.sub.Cancel()
+	}
+}
+
+// Returns the applied policy.
+func (m *PolicyManager) Policy() *Policy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.policy
+}
+
+// Reads the policy in the file at path, JSON if its name ends in
+// .json, and applies it.
+func (m *PolicyManager) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var p *Policy
+	if strings.HasSuffix(path, ".json") {
+		p, err = ParsePolicyJSON(f)
+	} else {
+		p, err = ParsePolicy(f)
+	}
+	if err != nil {
+		return err
+	}
+	return m.Apply(p)
+}
+
+// Compiles p and installs it in place of the applied policy.
+// Nothing changes if p is invalid.
+func (m *PolicyManager) Apply(p *Policy) error {
+	flows, err := p.Compile()
+	if err != nil {
+		return err
+	}
+	if m.Triggers != nil {
+		if err := m.Triggers.Load(p); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	old := m.flows
+	m.policy = p
+	m.flows = flows
+	m.mu.Unlock()
+
+	keep := make(map[string]bool)
+	for _, f := range flows {
+		keep[f.key()] = true
+	}
+	for _, sw := range Switches() {
+		installPolicy(sw, flows)
+		for _, f := range old {
+			if !keep[f.key()] && f.appliesTo(sw) {
+				sw.installOutputs(f.Match, f.Priority, nil)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *PolicyManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Policy())
+	case "PUT":
+		var p *Policy
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			p, err = ParsePolicyJSON(r.Body)
+		} else {
+			p, err = ParsePolicy(r.Body)
+		}
+		if err == nil {
+			err = m.Apply(p)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func installPolicy(sw *OFSwitch, flows []PolicyFlow) {
+	for _, f := range flows {
+		if !f.appliesTo(sw) {
+			continue
+		}
+		var err error
+		if len(f.Ports) == 0 {
+			err = sw.installDrop(f.Match, f.Priority)
+		} else {
+			err = sw.installOutputs(f.Match, f.Priority, f.Ports)
+		}
+		if err != nil {
+			log.Println("Failed to install policy on", SwitchLabel(sw.DPID()), err)
+			return
+		}
+	}
+}
+
+func (f PolicyFlow) appliesTo(sw *OFSwitch) bool {
+	return f.DPID == nil || f.DPID.String() == sw.DPID().String()
+}
+
+// Identifies the flow on a switch.
+func (f PolicyFlow) key() string {
+	return fmt.Sprintf("%s/%d/%v", f.DPID, f.Priority, f.Match)
+}
@@ -0,0 +1,97 @@
+package ogo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		// The line of each error, in order.
+		lines []int
+	}{
+		{"valid", "# web servers\nhosts web = 10.0.0.1 10.0.0.2\n\nrule 200 match ip_dst=$web action output 1\n", nil},
+		{"bad set", "hosts web 10.0.0.1\n", []int{1}},
+		{"missing action", "ports up = 1\nrule 10 match in_port=$up\n", []int{2}},
+		{"bad priority", "rule high action drop\n", []int{1}},
+		{"bad field", "\n\nrule 10 match in_port action drop\n", []int{3}},
+		{"unknown statement", "rule 10 action drop\nroute 10.0.0.0/8\n", []int{2}},
+		{"every error", "rule x action drop\nrule 10 action drop\nfoo\nrule 10 on action drop\n", []int{1, 3, 4}},
+		{"comment", "rule 10 action drop # foo\nfoo # rule 10 action drop\n", []int{2}},
+	}
+	for _, test := range tests {
+		_, err := ParsePolicy(strings.NewReader(test.policy))
+		if len(test.lines) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			continue
+		}
+		errs, ok := err.(PolicyErrors)
+		if !ok {
+			t.Errorf("%s: got error %v, expected PolicyErrors.", test.name, err)
+			continue
+		}
+		for i, e := range errs {
+			if i >= len(test.lines) || e.Line != test.lines[i] {
+				t.Errorf("%s: got errors\n%v\nexpected them on lines %v.", test.name, errs, test.lines)
+				break
+			}
+		}
+		if len(errs) != len(test.lines) {
+			t.Errorf("%s: got %d errors, expected %d.", test.name, len(errs), len(test.lines))
+		}
+		if !strings.HasPrefix(errs[0].Error(), "line ") {
+			t.Errorf("%s: error %q doesn't give its line.", test.name, errs[0])
+		}
+	}
+}
+
+func TestPolicyCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		flows  int
+		// The lines of the rules that fail to compile.
+		lines []int
+	}{
+		{"single", "rule 10 match in_port=1 action output 2\n", 1, nil},
+		{"sets", "hosts web = 10.0.0.1 10.0.0.2\nports up = 1 2 3\nrule 10 match in_port=$up ip_dst=$web action output 4\n", 6, nil},
+		{"output set", "ports up = 1 2\nrule 10 action output $up\n", 1, nil},
+		{"unknown field", "rule 10 match vlan=5 action drop\n", 0, []int{1}},
+		{"bad MAC address", "rule 10 match eth_src=02:00 action drop\n", 0, []int{1}},
+		{"bad address", "rule 10 match ip_dst=::1 action drop\n", 0, []int{1}},
+		{"undefined set", "rule 10 match ip_src=$db action drop\n", 0, []int{1}},
+		{"empty set", "hosts db =\nrule 10 match ip_src=$db action drop\n", 0, []int{2}},
+		{"bad action", "rule 10 action drop 1\nrule 20 action output 1\nrule 30 action mirror 2\n", 0, []int{1, 3}},
+		{"bad switch", "rule 10 on switch1 action drop\n", 0, []int{1}},
+	}
+	for _, test := range tests {
+		p, err := ParsePolicy(strings.NewReader(test.policy))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		flows, err := p.Compile()
+		if len(test.lines) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if len(flows) != test.flows {
+				t.Errorf("%s: got %d flows, expected %d.", test.name, len(flows), test.flows)
+			}
+			continue
+		}
+		errs, ok := err.(PolicyErrors)
+		if !ok || len(errs) != len(test.lines) {
+			t.Errorf("%s: got error %v, expected errors on lines %v.", test.name, err, test.lines)
+			continue
+		}
+		for i, e := range errs {
+			if e.Line != test.lines[i] {
+				t.Errorf("%s: got an error on line %d, expected %d.", test.name, e.Line, test.lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ogo
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// How long to wait for the counters of each port.
+var PortLoadRequestTimeout = time.Second * 2
+
+// PortLoads polls the transmit counters of every port of every
+// OpenFlow 1.0 switch each Interval. Once started, the links of
+// CurrentTopology carry the load of their source port, so paths
+// can be computed around busy links.
+type PortLoads struct {
+	Interval time.Duration
+	mu       sync.Mutex
+	// Transmitted bits per second, and the counters they were
+	// computed from, by switch and port.
+	rates  map[string]uint64
+	bytes  map[string]uint64
+	polled map[string]time.Time
+	stop   chan bool
+}
+
+// The PortLoads of CurrentTopology, if started.
+var portLoads *PortLoads
+var portLoadsMu sync.RWMutex
+
+func NewPortLoads(interval time.Duration) *PortLoads {
+	p := new(PortLoads)
+	p.Interval = interval
+	p.rates = make(map[string]uint64)
+	p.bytes = make(map[string]uint64)
+	p.polled = make(map[string]time.Time)
+	p.stop = make(chan bool, 1)
+	return p
+}
+
+func (p *PortLoads) Start() {
+	portLoadsMu.Lock()
+	portLoads = p
+	portLoadsMu.Unlock()
+	go func() {
+		p.Sample()
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Sample()
+			}
+		}
+	}()
+}
+
+func (p *PortLoads) Stop() {
+	portLoadsMu.Lock()
+	if portLoads == p {
+		portLoads = nil
+	}
+	portLoadsMu.Unlock()
+	select {
+	case p.stop <- true:
+	default:
+	}
+}
+
+// Polls the counters of every port once.
+func (p *PortLoads) Sample() {
+	for _, sw := range Switches() {
+		if sw.Version() != ofp10.VERSION {
+			continue
+		}
+		for _, port := range sw.Ports() {
+			if port.PortNo >= ofp10.P_MAX {
+				continue
+			}
+			req := ofp10.NewPortStatsRequest()
+			req.PortNo = port.PortNo
+			reps, err := sw.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Port, req), PortLoadRequestTimeout)
+			if err != nil {
+				log.Println("Failed to read the counters of", SwitchLabel(sw.DPID()), "port", port.PortNo, err)
+				break
+			}
+			for _, rep := range reps {
+				if s, ok := rep.Body.(*ofp10.PortStats); ok {
+					p.add(sw.DPID(), port.PortNo, s.TxBytes, time.Now())
+				}
+			}
+		}
+	}
+}
+
+func (p *PortLoads) add(dpid net.HardwareAddr, port uint16, bytes uint64, now time.Time) {
+	key := portKey(dpid.String(), port)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prev, ok := p.bytes[key]
+	last := p.polled[key]
+	p.bytes[key], p.polled[key] = bytes, now
+	if secs := now.Sub(last).Seconds(); ok && bytes >= prev && secs > 0 {
+		p.rates[key] = uint64(float64(bytes-prev) * 8 / secs)
+	}
+}
+
+// Returns the bits per second sent out port of Switch dpid at the
+// last two samples.
+func (p *PortLoads) Rate(dpid net.HardwareAddr, port uint16) (uint64, bool) {
+	return p.rate(portKey(dpid.String(), port))
+}
+
+func (p *PortLoads) rate(key string) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.rates[key]
+	return r, ok
+}
+
+func portKey(dpid string, port uint16) string {
+	return fmt.Sprintf("%s/%d", dpid, port)
+}
+
+// Sets the capacity of each link of t to the speed of the port at
+// either end, the slower if both are known, and its load to that
+// of the started PortLoads. speed returns the speed of a port in
+// bits per second, 0 if unknown.
+func (t *Topology) setCapacities(speed func(dpid string, port uint16) uint64) {
+	portLoadsMu.RLock()
+	loads := portLoads
+	portLoadsMu.RUnlock()
+	for i := range t.Links {
+		l := &t.Links[i]
+		l.Capacity = speed(l.Src, l.SrcPort)
+		if r, ok := t.link(l.Dst, l.Src); ok {
+			if s := speed(r.Src, r.SrcPort); s != 0 && (l.Capacity == 0 || s < l.Capacity) {
+				l.Capacity = s
+			}
+		}
+		if loads != nil {
+			l.Load, _ = loads.rate(portKey(l.Src, l.SrcPort))
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package ogo
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// Published when a secured port receives a frame from a MAC
+// address that isn't registered for it.
+const EventPortViolation = "security.violation"
+
+// Priority of the flows dropping the frames of offenders.
+var PortSecurityPriority uint16 = 0xa000
+
+// The number of violations kept for Violations.
+var MaxViolations = 1000
+
+type PortViolation struct {
+	DPID net.HardwareAddr
+	Port uint16
+	MAC  net.HardwareAddr
+	Time time.Time
+}
+
+// PortSecurity only lets registered MAC addresses send from
+// secured ports. Frames from any other address reaching the
+// controller are dropped, along with the rest of the offender's
+// traffic on that port through a drop flow, and reported as an
+// EventPortViolation. Allowing the address again removes the
+// flow.
+//
+// Offenders are found from packet-ins, so a secured port must
+// send frames from unknown sources to the controller, as it does
+// on table misses.
+//
+// It serves an HTTP API: GET returns the recent violations,
+// POST allows and DELETE disallows the address in a body like
+// {"dpid": "00:00:00:00:00:01", "port": 1, "mac": "00:00:00:00:00:0a"}.
+type PortSecurity struct {
+	mu sync.Mutex
+	// Allowed addresses by switch, then port.
+	ports      map[string]map[uint16]map[string]bool
+	blocked    map[portMAC]bool
+	violations []PortViolation
+}
+
+func NewPortSecurity() *PortSecurity {
+	p := new(PortSecurity)
+	p.ports = make(map[string]map[uint16]map[string]bool)
+	p.blocked = make(map[portMAC]bool)
+	p.violations = make([]PortViolation, 0)
+	return p
+}
+
+// Starts checking packet-ins on c, ahead of host learning.
+func (p *PortSecurity) Attach(c *Controller) {
+	c.AddPacketInHandler("portsecurity", 1<<29, p)
+}
+
+// Secures port of Switch dpid, allowing only macs to send from
+// it.
+func (p *PortSecurity) Secure(dpid net.HardwareAddr, port uint16, macs ...net.HardwareAddr) {
+	p.mu.Lock()
+	if _, ok := p.ports[dpid.String()]; !ok {
+		p.ports[dpid.String()] = make(map[uint16]map[string]bool)
+	}
+	if _, ok := p.ports[dpid.String()][port]; !ok {
+		p.ports[dpid.String()][port] = make(map[string]bool)
+	}
+	p.mu.Unlock()
+	for _, mac := range macs {
+		p.Allow(dpid, port, mac)
+	}
+}
+
+// Stops securing port of Switch dpid, removing its drop flows.
+func (p *PortSecurity) Unsecure(dpid net.HardwareAddr, port uint16) {
+	p.mu.Lock()
+	delete(p.ports[dpid.String()], port)
+	unblock := make([]net.HardwareAddr, 0)
+	for key := range p.blocked {
+		if key.dpid == dpid.String() && key.port == port {
+			mac, _ := net.ParseMAC(key.mac)
+			unblock = append(unblock, mac)
+		}
+	}
+	p.mu.Unlock()
+	for _, mac := range unblock {
+		p.unblock(dpid, port, mac)
+	}
+}
+
+// Allows mac to send from secured port of Switch dpid.
+func (p *PortSecurity) Allow(dpid net.HardwareAddr, port uint16, mac net.HardwareAddr) {
+	p.mu.Lock()
+	allowed, ok := p.ports[dpid.String()][port]
+	if ok {
+		allowed[mac.String()] = true
+	}
+	p.mu.Unlock()
+	if ok {
+		p.unblock(dpid, port, mac)
+	}
+}
+
+// Stops allowing mac to send from port of Switch dpid. Its
+// frames are dropped once they next reach the controller.
+func (p *PortSecurity) Disallow(dpid net.HardwareAddr, port uint16, mac net.HardwareAddr) {
+	p.mu.Lock()
+	delete(p.ports[dpid.String()][port], mac.String())
+	p.mu.Unlock()
+	// Flows forwarding its traffic would keep it flowing.
+	if sw, ok := Switch(dpid); ok {
+		sw.deleteFlows(FlowMatch{InPort: port, EthSrc: mac})
+	}
+}
+
+// Returns the most recent violations, oldest first.
+func (p *PortSecurity) Violations() []PortViolation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a := make([]PortViolation, len(p.violations))
+	copy(a, p.violations)
+	return a
+}
+
+func (p *PortSecurity) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	mac := pkt.Data.HWSrc
+	p.mu.Lock()
+	allowed, secured := p.ports[dpid.String()][pkt.InPort]
+	if !secured || allowed[mac.String()] {
+		p.mu.Unlock()
+		return false
+	}
+	key := portMAC{dpid.String(), pkt.InPort, mac.String()}
+	blocked := p.blocked[key]
+	p.blocked[key] = true
+	v := PortViolation{dpid, pkt.InPort, append(net.HardwareAddr(nil), mac...), time.Now()}
+	p.violations = append(p.violations, v)
+	if len(p.violations) > MaxViolations {
+		p.violations = p.violations[len(p.violations)-MaxViolations:]
+	}
+	p.mu.Unlock()
+
+	if !blocked {
+		if sw, ok := Switch(dpid); ok {
+			if err := sw.installDrop(FlowMatch{InPort: pkt.InPort, EthSrc: v.MAC}, PortSecurityPriority); err != nil {
+				log.Println("Failed to block", v.MAC, "on", SwitchLabel(dpid), err)
+			}
+		}
+	}
+	Publish(EventPortViolation, dpid, v)
+	return true
+}
+
+func (p *PortSecurity) unblock(dpid net.HardwareAddr, port uint16, mac net.HardwareAddr) {
+	key := portMAC{dpid.String(), port, mac.String()}
+	p.mu.Lock()
+	blocked := p.blocked[key]
+	delete(p.blocked, key)
+	p.mu.Unlock()
+	if !blocked {
+		return
+	}
+	if sw, ok := Switch(dpid); ok {
+		sw.installOutputs(FlowMatch{InPort: port, EthSrc: mac}, PortSecurityPriority, nil)
+	}
+}
+
+type portSecurityRequest struct {
+	DPID string `json:"dpid"`
+	Port uint16 `json:"port"`
+	MAC  string `json:"mac"`
+}
+
+func (p *PortSecurity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Violations())
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req portSecurityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dpid, err := net.ParseMAC(req.DPID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mac, err := net.ParseMAC(req.MAC)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == "POST" {
+		p.Secure(dpid, req.Port, mac)
+	} else {
+		p.Disallow(dpid, req.Port, mac)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// A MAC address on a port of a switch.
+type portMAC struct {
+	dpid string
+	port uint16
+	mac  string
+}
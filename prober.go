@@ -0,0 +1,227 @@
+package ogo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The IP protocol of probe packets, reserved for
+// experimentation by RFC 3692.
+var ProbeProto uint8 = 253
+
+// Priority of the flows sending probes to the controller at
+// their destination switch, above any forwarding flow.
+var ProbePriority uint16 = 0xfff0
+
+var probeMagic = []byte("ogop")
+
+var ErrProbeLost = errors.New("The probe did not arrive before the timeout.")
+
+// The headers of a probe packet. They should match the flows of
+// the path under test.
+type ProbeHeaders struct {
+	EthSrc net.HardwareAddr
+	EthDst net.HardwareAddr
+	IPSrc  net.IP
+	IPDst  net.IP
+}
+
+// The outcome of a probe.
+type ProbeResult struct {
+	ID   uint32
+	Sent time.Time
+	// Where and when the probe arrived.
+	DPID    net.HardwareAddr
+	InPort  uint16
+	Arrived time.Time
+	// Includes the time for the packet-out to reach the source
+	// switch and the packet-in to reach the controller.
+	Latency time.Duration
+}
+
+// A Prober checks installed paths by injecting probe packets
+// into the flow table of a source switch, as if received on a
+// port, and catching them at the destination switch. Probes
+// are IP packets with protocol ProbeProto, sent to the
+// controller by a flow matching their IP destination installed
+// on the destination switch.
+//
+// Probes are injected with OpenFlow 1.0 packet-outs and caught
+// from OpenFlow 1.0 packet-ins, so both switches must use
+// OpenFlow 1.0.
+type Prober struct {
+	mu      sync.Mutex
+	pending map[uint32]chan ProbeResult
+	// Destination switch and IP pairs with a punt flow.
+	punted map[string]bool
+	// Temporary flows catching probes for VerifyRecipe, by
+	// switch and port, with the number of verifications using
+	// them.
+	catching map[string]int
+	nextID   uint32
+}
+
+func NewProber() *Prober {
+	p := new(Prober)
+	p.pending = make(map[uint32]chan ProbeResult)
+	p.punted = make(map[string]bool)
+	p.catching = make(map[string]int)
+	return p
+}
+
+// Starts catching probes on c.
+func (p *Prober) Attach(c *Controller) {
+	c.AddPacketInHandler("prober", 1<<26, p)
+}
+
+// Sends a probe with headers h into Switch src as if received on
+// srcPort and waits up to timeout for it to arrive at Switch dst.
+func (p *Prober) Ping(src net.HardwareAddr, srcPort uint16, dst net.HardwareAddr, h ProbeHeaders, timeout time.Duration) (ProbeResult, error) {
+	in, ok := Switch(src)
+	if !ok {
+		return ProbeResult{}, ErrSwitchDisconnected
+	}
+	out, ok := Switch(dst)
+	if !ok {
+		return ProbeResult{}, ErrSwitchDisconnected
+	}
+	if in.Version() != ofp10.VERSION || out.Version() != ofp10.VERSION {
+		return ProbeResult{}, errors.New("Probes need OpenFlow 1.0 switches.")
+	}
+	if err := p.punt(out, h.IPDst, timeout); err != nil {
+		return ProbeResult{}, err
+	}
+
+	id, ch := p.register()
+	defer p.unregister(id)
+	return p.inject(in, srcPort, h, id, ch, timeout)
+}
+
+// Allocates a probe id and the channel its arrivals are
+// delivered on.
+func (p *Prober) register() (uint32, chan ProbeResult) {
+	return p.registerN(1)
+}
+
+// Like register, for a probe expected to arrive at n places.
+func (p *Prober) registerN(n int) (uint32, chan ProbeResult) {
+	id := atomic.AddUint32(&p.nextID, 1)
+	ch := make(chan ProbeResult, n)
+	p.mu.Lock()
+	p.pending[id] = ch
+	p.mu.Unlock()
+	return id, ch
+}
+
+func (p *Prober) unregister(id uint32) {
+	p.mu.Lock()
+	delete(p.pending, id)
+	p.mu.Unlock()
+}
+
+// Sends probe id into the flow table of Switch sw as if received
+// on port and waits up to timeout for it to arrive on ch.
+func (p *Prober) inject(sw *OFSwitch, port uint16, h ProbeHeaders, id uint32, ch chan ProbeResult, timeout time.Duration) (ProbeResult, error) {
+	sent := time.Now()
+	pkt := ofp10.NewPacketOut()
+	pkt.InPort = port
+	pkt.Data = probePacket(h, id, sent)
+	pkt.AddAction(ofp10.NewActionOutput(ofp10.P_TABLE))
+	if err := sw.Send(pkt); err != nil {
+		return ProbeResult{}, err
+	}
+
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-time.After(timeout):
+		return ProbeResult{ID: id, Sent: sent}, ErrProbeLost
+	}
+}
+
+// Installs the flow sending probes to ip to the controller on
+// Switch sw.
+func (p *Prober) punt(sw *OFSwitch, ip net.IP, timeout time.Duration) error {
+	key := sw.DPID().String() + "/" + ip.String()
+	p.mu.Lock()
+	done := p.punted[key]
+	p.mu.Unlock()
+	if done {
+		return nil
+	}
+	m := FlowMatch{IPDst: ip, IPProto: ProbeProto}
+	if err := sw.installOutputs(m, ProbePriority, []uint16{ofp10.P_CONTROLLER}); err != nil {
+		return err
+	}
+	// Make sure the flow is in place before the probe is sent.
+	if _, err := sw.SendAndReceive(sw.newBarrierRequest(), timeout); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.punted[key] = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Prober) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	arrived := time.Now()
+	ip, ok := pkt.Data.Data.(*ipv4.IPv4)
+	if !ok || pkt.Data.Ethertype != eth.IPv4_MSG || ip.Protocol != ProbeProto {
+		return false
+	}
+	buf, ok := ip.Data.(*util.Buffer)
+	if !ok || buf.Len() < 16 || !bytes.Equal(buf.Bytes()[:4], probeMagic) {
+		return false
+	}
+	data := buf.Bytes()
+	id := binary.BigEndian.Uint32(data[4:])
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(data[8:])))
+
+	p.mu.Lock()
+	ch, ok := p.pending[id]
+	p.mu.Unlock()
+	if ok {
+		select {
+		case ch <- ProbeResult{id, sent, dpid, pkt.InPort, arrived, arrived.Sub(sent)}:
+		default:
+		}
+	}
+	return true
+}
+
+// Builds a probe packet. The payload holds a magic number, the
+// probe id and the time it was sent.
+func probePacket(h ProbeHeaders, id uint32, sent time.Time) *eth.Ethernet {
+	payload := make([]byte, 16)
+	copy(payload, probeMagic)
+	binary.BigEndian.PutUint32(payload[4:], id)
+	binary.BigEndian.PutUint64(payload[8:], uint64(sent.UnixNano()))
+
+	ip := ipv4.New()
+	ip.Version = 4
+	ip.TTL = 64
+	ip.Protocol = ProbeProto
+	copy(ip.NWSrc, h.IPSrc.To4())
+	copy(ip.NWDst, h.IPDst.To4())
+	ip.Data = util.NewBuffer(payload)
+	ip.Length = ip.Len()
+	data, _ := ip.MarshalBinary()
+	ip.Checksum = util.Checksum(data[:20])
+
+	e := eth.New()
+	copy(e.HWSrc, h.EthSrc)
+	copy(e.HWDst, h.EthDst)
+	e.Ethertype = eth.IPv4_MSG
+	e.Data = ip
+	return e
+}
@@ -0,0 +1,276 @@
+// Package dns reads and writes DNS messages, RFC 1035, as
+// carried in UDP.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Record types.
+const (
+	Type_A     = 1
+	Type_NS    = 2
+	Type_CNAME = 5
+	Type_PTR   = 12
+	Type_AAAA  = 28
+)
+
+const Class_IN = 1
+
+// Flags
+const (
+	Flag_QR        = 1 << 15 // Response
+	Flag_AA        = 1 << 10 // Authoritative answer
+	Flag_TC        = 1 << 9  // Truncated
+	Flag_RD        = 1 << 8  // Recursion desired
+	Flag_RA        = 1 << 7  // Recursion available
+	RcodeMask      = 0x000f
+	Rcode_OK       = 0
+	Rcode_NXDOMAIN = 3
+)
+
+var errShort = errors.New("The []byte is too short to unmarshal a full DNS message.")
+
+type DNS struct {
+	Id          uint16
+	Flags       uint16
+	Questions   []Question
+	Answers     []Record
+	Authorities []Record
+	Additionals []Record
+}
+
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// A resource record. Target is the domain name of NS, CNAME and
+// PTR records, whose Data is left empty; the Data of other types
+// is their raw RDATA.
+type Record struct {
+	Name   string
+	Type   uint16
+	Class  uint16
+	TTL    uint32
+	Data   []byte
+	Target string
+}
+
+func New() *DNS {
+	d := new(DNS)
+	d.Questions = make([]Question, 0)
+	d.Answers = make([]Record, 0)
+	d.Authorities = make([]Record, 0)
+	d.Additionals = make([]Record, 0)
+	return d
+}
+
+func (d *DNS) IsResponse() bool {
+	return d.Flags&Flag_QR != 0
+}
+
+func (d *DNS) Rcode() uint16 {
+	return d.Flags & RcodeMask
+}
+
+// Returns the address of an A or AAAA record, or nil.
+func (r *Record) IP() net.IP {
+	if r.Type == Type_A && len(r.Data) == 4 || r.Type == Type_AAAA && len(r.Data) == 16 {
+		return net.IP(r.Data)
+	}
+	return nil
+}
+
+func hasTarget(t uint16) bool {
+	return t == Type_NS || t == Type_CNAME || t == Type_PTR
+}
+
+// Returns the length of name written uncompressed.
+func nameLen(name string) int {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 1
+	}
+	return len(name) + 2
+}
+
+func (r *Record) dataLen() int {
+	if hasTarget(r.Type) {
+		return nameLen(r.Target)
+	}
+	return len(r.Data)
+}
+
+// Names are written uncompressed.
+func (d *DNS) Len() (n uint16) {
+	l := 12
+	for _, q := range d.Questions {
+		l += nameLen(q.Name) + 4
+	}
+	for _, s := range [][]Record{d.Answers, d.Authorities, d.Additionals} {
+		for i := range s {
+			l += nameLen(s[i].Name) + 10 + s[i].dataLen()
+		}
+	}
+	return uint16(l)
+}
+
+func (d *DNS) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(d.Len()))
+	binary.BigEndian.PutUint16(data[0:], d.Id)
+	binary.BigEndian.PutUint16(data[2:], d.Flags)
+	binary.BigEndian.PutUint16(data[4:], uint16(len(d.Questions)))
+	binary.BigEndian.PutUint16(data[6:], uint16(len(d.Answers)))
+	binary.BigEndian.PutUint16(data[8:], uint16(len(d.Authorities)))
+	binary.BigEndian.PutUint16(data[10:], uint16(len(d.Additionals)))
+	n := 12
+	for _, q := range d.Questions {
+		if n, err = putName(data, n, q.Name); err != nil {
+			return
+		}
+		binary.BigEndian.PutUint16(data[n:], q.Type)
+		binary.BigEndian.PutUint16(data[n+2:], q.Class)
+		n += 4
+	}
+	for _, s := range [][]Record{d.Answers, d.Authorities, d.Additionals} {
+		for _, r := range s {
+			if n, err = putName(data, n, r.Name); err != nil {
+				return
+			}
+			binary.BigEndian.PutUint16(data[n:], r.Type)
+			binary.BigEndian.PutUint16(data[n+2:], r.Class)
+			binary.BigEndian.PutUint32(data[n+4:], r.TTL)
+			binary.BigEndian.PutUint16(data[n+8:], uint16(r.dataLen()))
+			n += 10
+			if hasTarget(r.Type) {
+				if n, err = putName(data, n, r.Target); err != nil {
+					return
+				}
+			} else {
+				n += copy(data[n:], r.Data)
+			}
+		}
+	}
+	return
+}
+
+// Writes name at data[n:] and returns the offset after it.
+func putName(data []byte, n int, name string) (int, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return n, errors.New("DNS name " + name + " has a bad label.")
+			}
+			data[n] = uint8(len(label))
+			n += 1 + copy(data[n+1:], label)
+		}
+	}
+	data[n] = 0
+	return n + 1, nil
+}
+
+func (d *DNS) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 {
+		return errShort
+	}
+	d.Id = binary.BigEndian.Uint16(data[0:])
+	d.Flags = binary.BigEndian.Uint16(data[2:])
+	counts := make([]int, 4)
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(data[4+2*i:]))
+	}
+	n := 12
+	var err error
+	d.Questions = make([]Question, 0, counts[0])
+	for i := 0; i < counts[0]; i++ {
+		q := Question{}
+		if q.Name, n, err = readName(data, n); err != nil {
+			return err
+		}
+		if n+4 > len(data) {
+			return errShort
+		}
+		q.Type = binary.BigEndian.Uint16(data[n:])
+		q.Class = binary.BigEndian.Uint16(data[n+2:])
+		n += 4
+		d.Questions = append(d.Questions, q)
+	}
+	sections := []*[]Record{&d.Answers, &d.Authorities, &d.Additionals}
+	for s, section := range sections {
+		*section = make([]Record, 0, counts[s+1])
+		for i := 0; i < counts[s+1]; i++ {
+			r := Record{}
+			if r.Name, n, err = readName(data, n); err != nil {
+				return err
+			}
+			if n+10 > len(data) {
+				return errShort
+			}
+			r.Type = binary.BigEndian.Uint16(data[n:])
+			r.Class = binary.BigEndian.Uint16(data[n+2:])
+			r.TTL = binary.BigEndian.Uint32(data[n+4:])
+			length := int(binary.BigEndian.Uint16(data[n+8:]))
+			n += 10
+			if n+length > len(data) {
+				return errShort
+			}
+			if hasTarget(r.Type) {
+				if r.Target, _, err = readName(data, n); err != nil {
+					return err
+				}
+			} else {
+				r.Data = make([]byte, length)
+				copy(r.Data, data[n:])
+			}
+			n += length
+			*section = append(*section, r)
+		}
+	}
+	return nil
+}
+
+// Reads the possibly compressed name at data[n:] and returns it
+// and the offset after it.
+func readName(data []byte, n int) (string, int, error) {
+	labels := make([]string, 0)
+	end := -1
+	// Limiting the pointers followed stops loops.
+	for jumps := 0; ; {
+		if n >= len(data) {
+			return "", 0, errShort
+		}
+		l := int(data[n])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = n + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if n+1 >= len(data) {
+				return "", 0, errShort
+			}
+			if jumps += 1; jumps > 32 {
+				return "", 0, errors.New("DNS name has too many compression pointers.")
+			}
+			if end < 0 {
+				end = n + 2
+			}
+			n = int(binary.BigEndian.Uint16(data[n:]) & 0x3fff)
+		case l&0xc0 != 0:
+			return "", 0, errors.New("DNS name has a bad label type.")
+		default:
+			if n+1+l > len(data) {
+				return "", 0, errShort
+			}
+			labels = append(labels, string(data[n+1:n+1+l]))
+			n += 1 + l
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDNSUnmarshalBinary(t *testing.T) {
+	// A response for www.example.com: a CNAME to example.com
+	// and an A record for it, both using compressed names.
+	b := "   12 34 81 80 00 01 00 02 00 00 00 00" + // Header
+		"03 77 77 77 07 65 78 61 6d 70 6c 65 03 63 6f 6d 00 00 01 00 01" + // Question
+		"c0 0c 00 05 00 01 00 00 00 3c 00 02 c0 10" + // CNAME
+		"c0 10 00 01 00 01 00 00 01 2c 00 04 5d b8 d8 22" // A
+	bytes, _ := hex.DecodeString(strings.Replace(b, " ", "", -1))
+
+	d := New()
+	if err := d.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if d.Id != 0x1234 || !d.IsResponse() || d.Rcode() != Rcode_OK {
+		t.Errorf("Got id %x flags %x.", d.Id, d.Flags)
+	}
+	if len(d.Questions) != 1 || d.Questions[0].Name != "www.example.com" {
+		t.Fatalf("Got questions %+v.", d.Questions)
+	}
+	if len(d.Answers) != 2 {
+		t.Fatalf("Got %d answers, expected 2.", len(d.Answers))
+	}
+	c := d.Answers[0]
+	if c.Type != Type_CNAME || c.Name != "www.example.com" || c.Target != "example.com" || c.TTL != 60 {
+		t.Errorf("Got CNAME %+v.", c)
+	}
+	a := d.Answers[1]
+	if a.Name != "example.com" || !a.IP().Equal(net.IPv4(93, 184, 216, 34)) || a.TTL != 300 {
+		t.Errorf("Got A %+v.", a)
+	}
+}
+
+func TestDNSMarshalBinary(t *testing.T) {
+	d := New()
+	d.Id = 7
+	d.Flags = Flag_QR | Flag_RD
+	d.Questions = append(d.Questions, Question{"example.com", Type_A, Class_IN})
+	d.Answers = append(d.Answers, Record{Name: "example.com", Type: Type_A, Class: Class_IN,
+		TTL: 30, Data: []byte{10, 0, 0, 1}})
+	data, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Answers) != 1 || !r.Answers[0].IP().Equal(net.IPv4(10, 0, 0, 1)) || r.Answers[0].TTL != 30 {
+		t.Errorf("Got answers %+v.", r.Answers)
+	}
+}
+
+func TestDNSPointerLoop(t *testing.T) {
+	b := "00 00 81 80 00 01 00 00 00 00 00 00 c0 0c"
+	bytes, _ := hex.DecodeString(strings.Replace(b, " ", "", -1))
+	if err := New().UnmarshalBinary(bytes); err == nil {
+		t.Error("Expected an error for a compression pointer loop.")
+	}
+}
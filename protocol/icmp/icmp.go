@@ -39,8 +39,6 @@ func (i *ICMP) UnmarshalBinary(data []byte) error {
 	i.Code = data[1]
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 
-	for n, _ := range data[4:] {
-		i.Data = append(i.Data, data[n])
-	}
+	i.Data = append(i.Data[:0], data[4:]...)
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 
 const (
 	Type_ICMP     = 0x01
+	Type_IGMP     = 0x02
 	Type_TCP      = 0x06
 	Type_UDP      = 0x11
 	Type_IPv6     = 0x29
@@ -150,3 +151,14 @@ func (i *IPv4) UnmarshalBinary(data []byte) error {
 	}
 	return i.Data.UnmarshalBinary(data[n:])
 }
+
+// Flags
+const (
+	Flag_MF = 1 // More fragments
+	Flag_DF = 2 // Don't fragment
+)
+
+// Returns true if i is a fragment of a larger packet.
+func (i *IPv4) IsFragment() bool {
+	return i.Flags&Flag_MF != 0 || i.FragmentOffset != 0
+}
@@ -74,3 +74,23 @@ func TestIPv4UnmarshalBinary(t *testing.T) {
 		t.Errorf("Got nw-dst %d, expected %d.", ip.NWDst, dst)
 	}
 }
+
+func TestIPv4IsFragment(t *testing.T) {
+	ip := New()
+	if ip.IsFragment() {
+		t.Error("Unfragmented packet is a fragment.")
+	}
+	ip.Flags = Flag_DF
+	if ip.IsFragment() {
+		t.Error("Packet that mustn't be fragmented is a fragment.")
+	}
+	ip.Flags = Flag_MF
+	if !ip.IsFragment() {
+		t.Error("First fragment isn't a fragment.")
+	}
+	ip.Flags = 0
+	ip.FragmentOffset = 185
+	if !ip.IsFragment() {
+		t.Error("Last fragment isn't a fragment.")
+	}
+}
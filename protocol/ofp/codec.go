@@ -0,0 +1,78 @@
+package ofp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A Codec reads and builds the messages of one OpenFlow version,
+// so versions this package doesn't know, like a prototype of a
+// future version, can be added without changing it. Hello
+// messages are always parsed by this package, since they carry
+// the versions a connection may use.
+type Codec interface {
+	// The version in the header of the codec's messages.
+	Version() uint8
+	// Parses the message in b, whose header has the codec's
+	// version.
+	Parse(b []byte) (util.Message, error)
+	// Returns a features request, sent once the version has
+	// been negotiated, and the DPID of the switch if msg is the
+	// features reply.
+	NewFeaturesRequest() util.Message
+	FeaturesDPID(msg util.Message) (net.HardwareAddr, bool)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[uint8]Codec
+}{m: make(map[uint8]Codec)}
+
+// Registers c for its version. A registered codec takes the place
+// of the parser of this package for that version, so a built-in
+// version can be swapped for an experimental codec too.
+func RegisterCodec(c Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.m[c.Version()]; ok {
+		return fmt.Errorf("A codec for version %d is already registered.", c.Version())
+	}
+	codecs.m[c.Version()] = c
+	return nil
+}
+
+// Removes the codec registered for version, if any.
+func UnregisterCodec(version uint8) {
+	codecs.Lock()
+	delete(codecs.m, version)
+	codecs.Unlock()
+}
+
+// Returns the codec registered for version.
+func CodecFor(version uint8) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[version]
+	return c, ok
+}
+
+// Returns the versions with a registered codec, in increasing
+// order.
+func CodecVersions() []uint8 {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	a := make([]int, 0, len(codecs.m))
+	for v := range codecs.m {
+		a = append(a, int(v))
+	}
+	sort.Ints(a)
+	versions := make([]uint8, len(a))
+	for i, v := range a {
+		versions[i] = uint8(v)
+	}
+	return versions
+}
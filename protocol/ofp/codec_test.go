@@ -0,0 +1,76 @@
+package ofp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A codec for a made up version that parses every message as raw
+// bytes.
+type rawCodec struct {
+	version uint8
+}
+
+func (c rawCodec) Version() uint8 { return c.version }
+
+func (c rawCodec) Parse(b []byte) (util.Message, error) {
+	buf := new(util.Buffer)
+	err := buf.UnmarshalBinary(b)
+	return buf, err
+}
+
+func (c rawCodec) NewFeaturesRequest() util.Message {
+	return util.NewBuffer([]byte{c.version, 5, 0, 8, 0, 0, 0, 0})
+}
+
+func (c rawCodec) FeaturesDPID(msg util.Message) (net.HardwareAddr, bool) {
+	return nil, false
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if _, err := Parse([]byte{7, 2, 0, 8, 0, 0, 0, 1}); err != ErrUnknownVersion {
+		t.Fatalf("Unregistered version parsed with %v", err)
+	}
+	if err := RegisterCodec(rawCodec{7}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterCodec(7)
+	if err := RegisterCodec(rawCodec{7}); err == nil {
+		t.Error("A second codec was registered for version 7")
+	}
+
+	msg, err := Parse([]byte{7, 2, 0, 8, 0, 0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*util.Buffer); !ok {
+		t.Errorf("Message parsed as %T, not by the codec", msg)
+	}
+	// Hellos are always parsed here.
+	msg, err = Parse([]byte{7, 0, 0, 8, 0, 0, 0, 1})
+	if _, ok := msg.(*ofpxx.Hello); !ok || err != nil {
+		t.Errorf("Hello parsed as %T, %v", msg, err)
+	}
+	if v := CodecVersions(); len(v) != 1 || v[0] != 7 {
+		t.Errorf("CodecVersions returned %v", v)
+	}
+}
+
+func TestCodecReplacesBuiltin(t *testing.T) {
+	echo := []byte{ofp10.VERSION, 2, 0, 8, 0, 0, 0, 1}
+	if msg, _ := Parse(echo); msg == nil {
+		t.Fatal("Built-in parser failed")
+	} else if _, ok := msg.(*util.Buffer); ok {
+		t.Fatal("Built-in parser returned a raw buffer")
+	}
+	RegisterCodec(rawCodec{ofp10.VERSION})
+	msg, _ := Parse(echo)
+	UnregisterCodec(ofp10.VERSION)
+	if _, ok := msg.(*util.Buffer); !ok {
+		t.Errorf("Message parsed as %T, not by the codec", msg)
+	}
+}
@@ -0,0 +1,43 @@
+package ofp
+
+import (
+	"encoding/json"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Encodes msg in JSON, by the encoding of its version. Only
+// OpenFlow 1.0 and 1.3 messages have one.
+func EncodeJSON(msg util.Message) ([]byte, error) {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 && b[0] == 4 {
+		return ofp13.EncodeJSON(msg)
+	}
+	if len(b) > 0 && b[0] == 1 {
+		return ofp10.EncodeJSON(msg)
+	}
+	return nil, ErrUnknownVersion
+}
+
+// Decodes a message encoded in JSON by EncodeJSON, by its
+// version.
+func DecodeJSON(data []byte) (util.Message, error) {
+	var h struct {
+		Version uint8 `json:"version"`
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	switch h.Version {
+	case 1:
+		return ofp10.DecodeJSON(data)
+	case 4:
+		return ofp13.DecodeJSON(data)
+	}
+	return nil, ErrUnknownVersion
+}
@@ -1,17 +1,44 @@
 package ofp
 
 import (
+	"errors"
+
 	"github.com/jonstout/ogo/protocol/ofp10"
 	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofp15"
+	"github.com/jonstout/ogo/protocol/ofpxx"
 	"github.com/jonstout/ogo/protocol/util"
 )
 
+// Returned by Parse for messages of an OpenFlow version it
+// doesn't know.
+var ErrUnknownVersion = errors.New("Unknown OpenFlow version.")
+
 func Parse(b []byte) (message util.Message, err error) {
+	// Hello messages are version independent and must be
+	// understood before a version has been negotiated.
+	if b[1] == 0 {
+		message = new(ofpxx.Hello)
+		err = message.UnmarshalBinary(b)
+		return
+	}
+
+	if c, ok := CodecFor(b[0]); ok {
+		return c.Parse(b)
+	}
+
 	switch b[0] {
 	case 1:
 		message, err = ofp10.Parse(b)
 	case 4:
 		message, err = ofp13.Parse(b)
+	case 5:
+		message, err = ofp14.Parse(b)
+	case 6:
+		message, err = ofp15.Parse(b)
+	default:
+		err = ErrUnknownVersion
 	}
 	return
 }
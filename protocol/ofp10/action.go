@@ -51,7 +51,7 @@ func (a *ActionHeader) MarshalBinary() (data []byte, err error) {
 }
 
 func (a *ActionHeader) UnmarshalBinary(data []byte) error {
-	if len(data) != 4 {
+	if len(data) < 4 {
 		return errors.New("The []byte the wrong size to unmarshal an " +
 			"ActionHeader message.")
 	}
@@ -60,13 +60,41 @@ func (a *ActionHeader) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
-// TODO: Decode other Action types.
+// Decodes the action at the start of data. Actions of types
+// that aren't known are decoded as an ActionUnknown.
 func DecodeAction(data []byte) Action {
 	t := binary.BigEndian.Uint16(data[:2])
 	var a Action
 	switch t {
 	case ActionType_Output:
 		a = new(ActionOutput)
+	case ActionType_SetVLAN_VID:
+		a = NewActionVLANVID(0)
+	case ActionType_SetVLAN_PCP:
+		a = NewActionVLANPCP(0)
+	case ActionType_StripVLAN:
+		a = NewActionStripVLAN()
+	case ActionType_SetDLSrc, ActionType_SetDLDst:
+		a = NewActionDLSrc(make(net.HardwareAddr, ETH_ALEN))
+	case ActionType_SetNWSrc, ActionType_SetNWDst:
+		a = NewActionNWSrc(make(net.IP, 4))
+	case ActionType_SetNWTOS:
+		a = NewActionNWTOS(0)
+	case ActionType_SetTPSrc, ActionType_SetTPDst:
+		a = NewActionTPSrc(0)
+	case ActionType_Enqueue:
+		a = NewActionEnqueue(0, 0)
+	case ActionType_Vendor:
+		if len(data) >= 10 && binary.BigEndian.Uint32(data[4:]) == NX_VENDOR_ID &&
+			binary.BigEndian.Uint16(data[8:]) == NXAST_LEARN {
+			a = new(NXActionLearn)
+		}
+	}
+	if a == nil {
+		a = new(ActionUnknown)
+	}
+	if n := int(binary.BigEndian.Uint16(data[2:4])); n >= 4 && n <= len(data) {
+		data = data[:n]
 	}
 	a.UnmarshalBinary(data)
 	return a
@@ -160,9 +188,9 @@ func (a *ActionEnqueue) MarshalBinary() (data []byte, err error) {
 	data, err = a.ActionHeader.MarshalBinary()
 
 	bytes := make([]byte, 12)
-	binary.BigEndian.PutUint16(data[:2], a.Port)
+	binary.BigEndian.PutUint16(bytes[:2], a.Port)
 	copy(bytes[2:8], a.pad)
-	binary.BigEndian.PutUint32(data[8:12], a.QueueId)
+	binary.BigEndian.PutUint32(bytes[8:12], a.QueueId)
 
 	data = append(data, bytes...)
 	return
@@ -545,3 +573,28 @@ func (a *ActionVendor) UnmarshalBinary(data []byte) error {
 	a.Vendor = binary.BigEndian.Uint32(data[4:8])
 	return nil
 }
+
+// An action of a type DecodeAction doesn't know, kept as the
+// bytes that follow its header.
+type ActionUnknown struct {
+	ActionHeader
+	Data []byte
+}
+
+func (a *ActionUnknown) Len() (n uint16) {
+	return a.ActionHeader.Len() + uint16(len(a.Data))
+}
+
+func (a *ActionUnknown) MarshalBinary() (data []byte, err error) {
+	data, err = a.ActionHeader.MarshalBinary()
+	data = append(data, a.Data...)
+	return
+}
+
+func (a *ActionUnknown) UnmarshalBinary(data []byte) error {
+	if err := a.ActionHeader.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	a.Data = append([]byte(nil), data[4:]...)
+	return nil
+}
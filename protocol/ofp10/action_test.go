@@ -0,0 +1,20 @@
+package ofp10
+
+import "testing"
+
+func TestActionEnqueue(t *testing.T) {
+	data, err := NewActionEnqueue(3, 7).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 16 || data[3] != 16 {
+		t.Fatalf("Got %d bytes % x, expected 16.", len(data), data)
+	}
+	a := new(ActionEnqueue)
+	if err := a.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if a.Type != ActionType_Enqueue || a.Port != 3 || a.QueueId != 7 {
+		t.Errorf("Got %+v, expected port 3 queue 7.", a)
+	}
+}
@@ -56,6 +56,8 @@ func (s *SwitchFeatures) MarshalBinary() (data []byte, err error) {
 	bytes, err = s.Header.MarshalBinary()
 	copy(data[next:], bytes)
 	next += len(bytes)
+	copy(data[next:], s.DPID)
+	next += len(s.DPID)
 	binary.BigEndian.PutUint32(data[next:], s.Buffers)
 	next += 4
 	data[next] = s.Tables
@@ -100,6 +102,7 @@ func (s *SwitchFeatures) UnmarshalBinary(data []byte) error {
 	for next < len(data) {
 		p := NewPhyPort()
 		err = p.UnmarshalBinary(data[next:])
+		s.Ports = append(s.Ports, *p)
 		next += int(p.Len())
 	}
 	return err
@@ -54,3 +54,16 @@ func TestFeaturesReplyUnmarshalBinary(t *testing.T) {
 		}
 	}
 }
+
+func TestFeaturesReplyDPID(t *testing.T) {
+	f := NewFeaturesReply()
+	f.DPID, _ = net.ParseMAC("01:02:03:04:05:06:07:08")
+	f.Buffers = 256
+	data, _ := f.MarshalBinary()
+
+	r := NewFeaturesReply()
+	r.UnmarshalBinary(data)
+	if r.DPID.String() != f.DPID.String() || r.Buffers != 256 {
+		t.Errorf("Got dpid %s and %d buffers.", r.DPID, r.Buffers)
+	}
+}
@@ -76,7 +76,7 @@ func (f *FlowMod) MarshalBinary() (data []byte, err error) {
 	binary.BigEndian.PutUint16(bytes[n:], f.Priority)
 	n += 2
 	binary.BigEndian.PutUint32(bytes[n:], f.BufferId)
-	n += 2
+	n += 4
 	binary.BigEndian.PutUint16(bytes[n:], f.OutPort)
 	n += 2
 	binary.BigEndian.PutUint16(bytes[n:], f.Flags)
@@ -0,0 +1,40 @@
+package ofp10
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestFlowModMarshalBinary(t *testing.T) {
+	b := "   01 0e 00 48 00 00 00 00" + // Header
+		"00 3f ff ff 00 00 00 00 00 00 00 00" + // Wildcards, in port, eth src
+		"00 00 00 00 00 00 00 00 00 00" + // Eth dst, VLAN, PCP, pad
+		"00 00 00 00 00 00 00 00 00 00" + // Eth type, ToS, proto, pad, IP src
+		"00 00 00 00 00 00 00 00" + // IP dst, transport ports
+		"00 00 00 00 00 00 00 07" + // Cookie
+		"00 04 00 1e 00 00 80 00" + // Command, timeouts, priority
+		"ff ff ff ff 00 02 00 01" // Buffer, out port, flags
+	b = strings.Replace(b, " ", "", -1)
+
+	f := NewFlowMod()
+	f.Header.Xid = 0
+	f.Cookie = 7
+	f.Command = FC_DELETE_STRICT
+	f.IdleTimeout = 30
+	f.Priority = 0x8000
+	f.OutPort = 2
+	f.Flags = FF_SEND_FLOW_REM
+	data, _ := f.MarshalBinary()
+	if d := hex.EncodeToString(data); d != b {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Error("Flow mod marshaled wrong.")
+	}
+
+	g := NewFlowMod()
+	g.UnmarshalBinary(data)
+	if g.BufferId != f.BufferId || g.OutPort != f.OutPort || g.Flags != f.Flags {
+		t.Errorf("Got buffer %x, out port %d and flags %d back.", g.BufferId, g.OutPort, g.Flags)
+	}
+}
@@ -0,0 +1,1343 @@
+package ofp10
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The JSON encoding of OpenFlow 1.0 messages is meant to be read
+// and written by people. Every message is an object with its
+// version, type and xid, like
+//
+//	{"version": 1, "type": "flow_mod", "xid": 7, "command": "add",
+//		"match": {"in_port": 1, "dl_type": "0x0800", "nw_dst": "10.0.0.0/24"},
+//		"priority": 100, "out_port": "none", "actions": ["output:2"]}
+//
+// Matches list the fields that aren't wildcarded, in the syntax
+// of ovs-ofctl. Actions are strings in the syntax of Dump, or
+// raw:<hex> for those that have none. Ports are numbers, or the
+// names of reserved ports. Enums and flags are lower case names.
+// Frames and other opaque data are hex strings. Buffer ids are
+// left out if there is no buffer. Lengths aren't encoded; they
+// are computed when a message is decoded.
+
+type jsonHeader struct {
+	Version uint8  `json:"version"`
+	Type    string `json:"type"`
+	Xid     uint32 `json:"xid"`
+}
+
+func newJSONHeader(h ofpxx.Header) jsonHeader {
+	return jsonHeader{h.Version, typeJSONName(h.Type), h.Xid}
+}
+
+// Returns the header of a message of type t from j.
+func (j jsonHeader) header(t uint8) ofpxx.Header {
+	return ofpxx.Header{Version: VERSION, Type: t, Xid: j.Xid}
+}
+
+func typeJSONName(t uint8) string {
+	if int(t) < len(typeNames) {
+		return strings.ToLower(typeNames[t])
+	}
+	return strconv.Itoa(int(t))
+}
+
+// Encodes msg, a message of this package or a header-only message
+// of OpenFlow 1.0, in JSON.
+func EncodeJSON(msg util.Message) ([]byte, error) {
+	switch m := msg.(type) {
+	case *ofpxx.Header:
+		return json.Marshal(newJSONHeader(*m))
+	case *ofpxx.Hello:
+		return json.Marshal(newJSONHeader(m.Header))
+	}
+	return json.Marshal(msg)
+}
+
+// Decodes a message encoded in JSON, of the type it names.
+// Messages of types without a body are decoded as an
+// ofpxx.Header.
+func DecodeJSON(data []byte) (util.Message, error) {
+	var h jsonHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	t := -1
+	for i, name := range typeNames {
+		if strings.ToLower(name) == h.Type {
+			t = i
+		}
+	}
+	var msg util.Message
+	switch t {
+	case Type_Hello, Type_EchoRequest, Type_EchoReply, Type_FeaturesRequest,
+		Type_GetConfigRequest, Type_BarrierRequest, Type_BarrierReply:
+		hdr := h.header(uint8(t))
+		hdr.Length = hdr.Len()
+		return &hdr, nil
+	case Type_Error:
+		msg = new(ErrorMsg)
+	case Type_Vendor:
+		msg = new(VendorHeader)
+	case Type_FeaturesReply:
+		msg = new(SwitchFeatures)
+	case Type_GetConfigReply, Type_SetConfig:
+		msg = new(SwitchConfig)
+	case Type_PacketIn:
+		msg = new(PacketIn)
+	case Type_FlowRemoved:
+		msg = new(FlowRemoved)
+	case Type_PortStatus:
+		msg = new(PortStatus)
+	case Type_PacketOut:
+		msg = new(PacketOut)
+	case Type_FlowMod:
+		msg = new(FlowMod)
+	case Type_PortMod:
+		msg = new(PortMod)
+	case Type_StatsRequest:
+		msg = new(StatsRequest)
+	case Type_StatsReply:
+		msg = new(StatsReply)
+	default:
+		return nil, fmt.Errorf("Unknown message type %q.", h.Type)
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	if t == Type_GetConfigReply {
+		msg.(*SwitchConfig).Header.Type = Type_GetConfigReply
+	}
+	return msg, nil
+}
+
+// A port, encoded as its number or the name of a reserved port.
+type jsonPort uint16
+
+func (p jsonPort) MarshalJSON() ([]byte, error) {
+	if name, ok := portNames[uint16(p)]; ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(uint16(p))
+}
+
+func (p *jsonPort) UnmarshalJSON(data []byte) error {
+	var n uint16
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = jsonPort(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, err := parsePort(s)
+	*p = jsonPort(n)
+	return err
+}
+
+// Parses a port number or the name of a reserved port.
+func parsePort(s string) (uint16, error) {
+	for p, name := range portNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Bad port %q.", s)
+	}
+	return uint16(n), nil
+}
+
+// Parses the name of an enum, or its number.
+func parseEnum(s string, names []string) (int, error) {
+	for i, name := range names {
+		if name == s {
+			return i, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown value %q.", s)
+	}
+	return int(n), nil
+}
+
+// Returns the names of the flags set in v, and any unnamed bits
+// in hex.
+func flagsJSON(v uint32, names []flagName) []string {
+	a := make([]string, 0)
+	for _, f := range names {
+		if v&f.bit != 0 {
+			a = append(a, f.name)
+			v &^= f.bit
+		}
+	}
+	if v != 0 {
+		a = append(a, fmt.Sprintf("0x%x", v))
+	}
+	return a
+}
+
+func parseFlags(a []string, names []flagName) (uint32, error) {
+	var v uint32
+next:
+	for _, s := range a {
+		for _, f := range names {
+			if f.name == s {
+				v |= f.bit
+				continue next
+			}
+		}
+		n, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Unknown flag %q.", s)
+		}
+		v |= uint32(n)
+	}
+	return v, nil
+}
+
+func bufferJSON(id uint32) *uint32 {
+	if id == 0xffffffff {
+		return nil
+	}
+	return &id
+}
+
+func parseBuffer(id *uint32) uint32 {
+	if id == nil {
+		return 0xffffffff
+	}
+	return *id
+}
+
+func hexJSON(m util.Message) string {
+	if m == nil {
+		return ""
+	}
+	b, _ := m.MarshalBinary()
+	return hex.EncodeToString(b)
+}
+
+// Decodes the Ethernet frame in hex string s.
+func parseFrame(s string) (*eth.Ethernet, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	frame := eth.New()
+	// The frames of packet-ins follow a pad byte, which
+	// UnmarshalBinary skips.
+	err = frame.UnmarshalBinary(append([]byte{0}, b...))
+	return frame, err
+}
+
+// Returns the fields of m that aren't wildcarded, by their names
+// in ovs-ofctl.
+func (m *Match) fields() [][2]string {
+	a := make([][2]string, 0)
+	w := m.Wildcards
+	if w&FW_IN_PORT == 0 {
+		a = append(a, [2]string{"in_port", PortName(m.InPort)})
+	}
+	if w&FW_DL_VLAN == 0 {
+		a = append(a, [2]string{"dl_vlan", strconv.Itoa(int(m.DLVLAN))})
+	}
+	if w&FW_DL_VLAN_PCP == 0 {
+		a = append(a, [2]string{"dl_vlan_pcp", strconv.Itoa(int(m.DLVLANPcp))})
+	}
+	if w&FW_DL_SRC == 0 {
+		a = append(a, [2]string{"dl_src", m.DLSrc.String()})
+	}
+	if w&FW_DL_DST == 0 {
+		a = append(a, [2]string{"dl_dst", m.DLDst.String()})
+	}
+	if w&FW_DL_TYPE == 0 {
+		a = append(a, [2]string{"dl_type", fmt.Sprintf("0x%04x", m.DLType)})
+	}
+	if w&FW_NW_TOS == 0 {
+		a = append(a, [2]string{"nw_tos", strconv.Itoa(int(m.NWTos))})
+	}
+	if w&FW_NW_PROTO == 0 {
+		a = append(a, [2]string{"nw_proto", strconv.Itoa(int(m.NWProto))})
+	}
+	if s := prefixString(m.NWSrc, (w&FW_NW_SRC_MASK)>>FW_NW_SRC_SHIFT); s != "" {
+		a = append(a, [2]string{"nw_src", s})
+	}
+	if s := prefixString(m.NWDst, (w&FW_NW_DST_MASK)>>FW_NW_DST_SHIFT); s != "" {
+		a = append(a, [2]string{"nw_dst", s})
+	}
+	if w&FW_TP_SRC == 0 {
+		a = append(a, [2]string{"tp_src", strconv.Itoa(int(m.TPSrc))})
+	}
+	if w&FW_TP_DST == 0 {
+		a = append(a, [2]string{"tp_dst", strconv.Itoa(int(m.TPDst))})
+	}
+	return a
+}
+
+// Sets field name of m to value, given in the syntax of
+// ovs-ofctl, and clears its wildcard.
+func (m *Match) setField(name, value string) error {
+	uint := func(bits int) (uint64, error) {
+		n, err := strconv.ParseUint(value, 0, bits)
+		if err != nil {
+			return 0, fmt.Errorf("Bad %s %q.", name, value)
+		}
+		return n, nil
+	}
+	var err error
+	var n uint64
+	switch name {
+	case "in_port":
+		m.InPort, err = parsePort(value)
+		m.Wildcards &^= FW_IN_PORT
+	case "dl_vlan":
+		n, err = uint(16)
+		m.DLVLAN = uint16(n)
+		m.Wildcards &^= FW_DL_VLAN
+	case "dl_vlan_pcp":
+		n, err = uint(8)
+		m.DLVLANPcp = uint8(n)
+		m.Wildcards &^= FW_DL_VLAN_PCP
+	case "dl_src", "dl_dst":
+		mac, e := net.ParseMAC(value)
+		if e != nil {
+			return fmt.Errorf("Bad %s %q.", name, value)
+		}
+		if name == "dl_src" {
+			m.DLSrc = mac
+			m.Wildcards &^= FW_DL_SRC
+		} else {
+			m.DLDst = mac
+			m.Wildcards &^= FW_DL_DST
+		}
+	case "dl_type":
+		n, err = uint(16)
+		m.DLType = uint16(n)
+		m.Wildcards &^= FW_DL_TYPE
+	case "nw_tos":
+		n, err = uint(8)
+		m.NWTos = uint8(n)
+		m.Wildcards &^= FW_NW_TOS
+	case "nw_proto":
+		n, err = uint(8)
+		m.NWProto = uint8(n)
+		m.Wildcards &^= FW_NW_PROTO
+	case "nw_src", "nw_dst":
+		ip, wild, e := parsePrefix(value)
+		if e != nil {
+			return fmt.Errorf("Bad %s %q.", name, value)
+		}
+		if name == "nw_src" {
+			m.NWSrc = ip
+			m.Wildcards = m.Wildcards&^FW_NW_SRC_MASK | wild<<FW_NW_SRC_SHIFT
+		} else {
+			m.NWDst = ip
+			m.Wildcards = m.Wildcards&^FW_NW_DST_MASK | wild<<FW_NW_DST_SHIFT
+		}
+	case "tp_src":
+		n, err = uint(16)
+		m.TPSrc = uint16(n)
+		m.Wildcards &^= FW_TP_SRC
+	case "tp_dst":
+		n, err = uint(16)
+		m.TPDst = uint16(n)
+		m.Wildcards &^= FW_TP_DST
+	default:
+		return fmt.Errorf("Unknown match field %q.", name)
+	}
+	return err
+}
+
+// Parses an IPv4 address with an optional prefix length. Returns
+// the number of wildcarded low bits.
+func parsePrefix(s string) (net.IP, uint32, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, 0, errors.New("Not an IPv4 address.")
+		}
+		return ip, 0, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil || n.IP.To4() == nil {
+		return nil, 0, errors.New("Not an IPv4 prefix.")
+	}
+	ones, _ := n.Mask.Size()
+	return n.IP.To4(), uint32(32 - ones), nil
+}
+
+func (m Match) MarshalJSON() ([]byte, error) {
+	// Numbers are encoded as numbers, the rest as strings.
+	o := make(map[string]interface{})
+	for _, f := range m.fields() {
+		switch f[0] {
+		case "in_port":
+			o[f[0]] = jsonPort(m.InPort)
+		case "dl_vlan", "dl_vlan_pcp", "nw_tos", "nw_proto", "tp_src", "tp_dst":
+			n, _ := strconv.Atoi(f[1])
+			o[f[0]] = n
+		default:
+			o[f[0]] = f[1]
+		}
+	}
+	return json.Marshal(o)
+}
+
+func (m *Match) UnmarshalJSON(data []byte) error {
+	var o map[string]json.RawMessage
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	*m = *NewMatch()
+	for name, raw := range o {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if err := m.setField(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the JSON encoding of a, the syntax of Dump for actions
+// that can be parsed back.
+func actionJSON(a Action) string {
+	switch a.(type) {
+	case *ActionUnknown, *NXActionLearn:
+		b, _ := a.MarshalBinary()
+		return "raw:" + hex.EncodeToString(b)
+	}
+	if s, ok := a.(fmt.Stringer); ok {
+		return s.String()
+	}
+	b, _ := a.MarshalBinary()
+	return "raw:" + hex.EncodeToString(b)
+}
+
+func actionsJSON(actions []Action) []string {
+	a := make([]string, len(actions))
+	for i, act := range actions {
+		a[i] = actionJSON(act)
+	}
+	return a
+}
+
+// Parses an action in the syntax of Dump, or raw:<hex>.
+func ParseAction(s string) (Action, error) {
+	name, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+	bad := fmt.Errorf("Bad action %q.", s)
+	num := func(bits int) (uint64, error) {
+		n, err := strconv.ParseUint(arg, 0, bits)
+		if err != nil {
+			return 0, bad
+		}
+		return n, nil
+	}
+	switch name {
+	case "output":
+		p, err := parsePort(arg)
+		if err != nil {
+			return nil, bad
+		}
+		return NewActionOutput(p), nil
+	case "controller":
+		n, err := num(16)
+		a := NewActionOutput(P_CONTROLLER)
+		a.MaxLen = uint16(n)
+		return a, err
+	case "enqueue":
+		i := strings.Index(arg, ":")
+		if i < 0 {
+			return nil, bad
+		}
+		p, err := parsePort(arg[:i])
+		q, qerr := strconv.ParseUint(arg[i+1:], 0, 32)
+		if err != nil || qerr != nil {
+			return nil, bad
+		}
+		return NewActionEnqueue(p, uint32(q)), nil
+	case "mod_vlan_vid":
+		n, err := num(12)
+		return NewActionVLANVID(uint16(n)), err
+	case "mod_vlan_pcp":
+		n, err := num(3)
+		return NewActionVLANPCP(uint8(n)), err
+	case "strip_vlan":
+		return NewActionStripVLAN(), nil
+	case "mod_dl_src", "mod_dl_dst":
+		mac, err := net.ParseMAC(arg)
+		if err != nil {
+			return nil, bad
+		}
+		if name == "mod_dl_src" {
+			return NewActionDLSrc(mac), nil
+		}
+		return NewActionDLDst(mac), nil
+	case "mod_nw_src", "mod_nw_dst":
+		ip := net.ParseIP(arg).To4()
+		if ip == nil {
+			return nil, bad
+		}
+		if name == "mod_nw_src" {
+			return NewActionNWSrc(ip), nil
+		}
+		return NewActionNWDst(ip), nil
+	case "mod_nw_tos":
+		n, err := num(8)
+		return NewActionNWTOS(uint8(n)), err
+	case "mod_tp_src", "mod_tp_dst":
+		n, err := num(16)
+		if name == "mod_tp_src" {
+			return NewActionTPSrc(uint16(n)), err
+		}
+		return NewActionTPDst(uint16(n)), err
+	case "vendor":
+		n, err := num(32)
+		return NewActionVendor(uint32(n)), err
+	case "raw":
+		b, err := hex.DecodeString(arg)
+		if err != nil || len(b) < 4 {
+			return nil, bad
+		}
+		return DecodeAction(b), nil
+	}
+	return nil, fmt.Errorf("Unknown action %q.", s)
+}
+
+func parseActions(a []string) ([]Action, error) {
+	actions := make([]Action, 0, len(a))
+	for _, s := range a {
+		act, err := ParseAction(s)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+// Returns b, which holds a string padded with NUL bytes, as s.
+func setCString(b []byte, s string) []byte {
+	b = make([]byte, len(b))
+	copy(b, s)
+	return b
+}
+
+type jsonFlowMod struct {
+	jsonHeader
+	Command     string   `json:"command"`
+	Match       Match    `json:"match"`
+	Cookie      uint64   `json:"cookie"`
+	IdleTimeout uint16   `json:"idle_timeout"`
+	HardTimeout uint16   `json:"hard_timeout"`
+	Priority    uint16   `json:"priority"`
+	BufferId    *uint32  `json:"buffer_id,omitempty"`
+	OutPort     jsonPort `json:"out_port"`
+	Flags       []string `json:"flags"`
+	Actions     []string `json:"actions"`
+}
+
+func (f FlowMod) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFlowMod{newJSONHeader(f.Header),
+		enumString(int(f.Command), flowModCommands), f.Match, f.Cookie,
+		f.IdleTimeout, f.HardTimeout, f.Priority, bufferJSON(f.BufferId),
+		jsonPort(f.OutPort), flagsJSON(uint32(f.Flags), flowModFlagNames),
+		actionsJSON(f.Actions)})
+}
+
+func (f *FlowMod) UnmarshalJSON(data []byte) error {
+	j := jsonFlowMod{Command: "add", OutPort: P_NONE, Match: *NewMatch()}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*f = *NewFlowMod()
+	f.Header = j.header(Type_FlowMod)
+	cmd, err := parseEnum(j.Command, flowModCommands)
+	if err != nil {
+		return err
+	}
+	flags, err := parseFlags(j.Flags, flowModFlagNames)
+	if err != nil {
+		return err
+	}
+	if f.Actions, err = parseActions(j.Actions); err != nil {
+		return err
+	}
+	f.Command, f.Match, f.Cookie = uint16(cmd), j.Match, j.Cookie
+	f.IdleTimeout, f.HardTimeout, f.Priority = j.IdleTimeout, j.HardTimeout, j.Priority
+	f.BufferId, f.OutPort, f.Flags = parseBuffer(j.BufferId), uint16(j.OutPort), uint16(flags)
+	f.Header.Length = f.Len()
+	return nil
+}
+
+type jsonFlowRemoved struct {
+	jsonHeader
+	Match        Match  `json:"match"`
+	Cookie       uint64 `json:"cookie"`
+	Priority     uint16 `json:"priority"`
+	Reason       string `json:"reason"`
+	DurationSec  uint32 `json:"duration_sec"`
+	DurationNSec uint32 `json:"duration_nsec"`
+	IdleTimeout  uint16 `json:"idle_timeout"`
+	PacketCount  uint64 `json:"packet_count"`
+	ByteCount    uint64 `json:"byte_count"`
+}
+
+func (f FlowRemoved) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFlowRemoved{newJSONHeader(f.Header), f.Match,
+		f.Cookie, f.Priority, enumString(int(f.Reason), flowRemovedReasons),
+		f.DurationSec, f.DurationNSec, f.IdleTimeout, f.PacketCount, f.ByteCount})
+}
+
+func (f *FlowRemoved) UnmarshalJSON(data []byte) error {
+	j := jsonFlowRemoved{Reason: "idle_timeout", Match: *NewMatch()}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	reason, err := parseEnum(j.Reason, flowRemovedReasons)
+	if err != nil {
+		return err
+	}
+	*f = *NewFlowRemoved()
+	f.Header = j.header(Type_FlowRemoved)
+	f.Match, f.Cookie, f.Priority, f.Reason = j.Match, j.Cookie, j.Priority, uint8(reason)
+	f.DurationSec, f.DurationNSec, f.IdleTimeout = j.DurationSec, j.DurationNSec, j.IdleTimeout
+	f.PacketCount, f.ByteCount = j.PacketCount, j.ByteCount
+	f.Header.Length = f.Len()
+	return nil
+}
+
+type jsonPacketIn struct {
+	jsonHeader
+	BufferId *uint32  `json:"buffer_id,omitempty"`
+	TotalLen uint16   `json:"total_len"`
+	InPort   jsonPort `json:"in_port"`
+	Reason   string   `json:"reason"`
+	Data     string   `json:"data"`
+}
+
+func (p PacketIn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPacketIn{newJSONHeader(p.Header), bufferJSON(p.BufferId),
+		p.TotalLen, jsonPort(p.InPort), enumString(int(p.Reason), packetInReasons),
+		hexJSON(&p.Data)})
+}
+
+func (p *PacketIn) UnmarshalJSON(data []byte) error {
+	j := jsonPacketIn{Reason: "no_match", InPort: P_NONE}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	reason, err := parseEnum(j.Reason, packetInReasons)
+	if err != nil {
+		return err
+	}
+	frame, err := parseFrame(j.Data)
+	if err != nil {
+		return err
+	}
+	*p = *NewPacketIn()
+	p.Header = j.header(Type_PacketIn)
+	p.BufferId, p.TotalLen, p.InPort = parseBuffer(j.BufferId), j.TotalLen, uint16(j.InPort)
+	p.Reason, p.Data = uint8(reason), *frame
+	p.Header.Length = p.Len()
+	return nil
+}
+
+type jsonPacketOut struct {
+	jsonHeader
+	BufferId *uint32  `json:"buffer_id,omitempty"`
+	InPort   jsonPort `json:"in_port"`
+	Actions  []string `json:"actions"`
+	Data     string   `json:"data,omitempty"`
+}
+
+func (p PacketOut) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPacketOut{newJSONHeader(p.Header), bufferJSON(p.BufferId),
+		jsonPort(p.InPort), actionsJSON(p.Actions), hexJSON(p.Data)})
+}
+
+func (p *PacketOut) UnmarshalJSON(data []byte) error {
+	j := jsonPacketOut{InPort: P_NONE}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	actions, err := parseActions(j.Actions)
+	if err != nil {
+		return err
+	}
+	*p = *NewPacketOut()
+	p.Header = j.header(Type_PacketOut)
+	p.BufferId, p.InPort = parseBuffer(j.BufferId), uint16(j.InPort)
+	for _, a := range actions {
+		p.AddAction(a)
+	}
+	if j.Data != "" {
+		frame, err := parseFrame(j.Data)
+		if err != nil {
+			return err
+		}
+		p.Data = frame
+	}
+	p.Header.Length = p.Len()
+	return nil
+}
+
+type jsonPhyPort struct {
+	PortNo     jsonPort `json:"port_no"`
+	HWAddr     string   `json:"hw_addr"`
+	Name       string   `json:"name"`
+	Config     []string `json:"config"`
+	State      []string `json:"state"`
+	Curr       []string `json:"curr"`
+	Advertised []string `json:"advertised"`
+	Supported  []string `json:"supported"`
+	Peer       []string `json:"peer"`
+}
+
+var portStateNames = []flagName{{PS_LINK_DOWN, "link_down"},
+	{PS_STP_LEARN, "stp_learn"}, {PS_STP_FORWARD, "stp_forward"}}
+
+func (p PhyPort) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPhyPort{jsonPort(p.PortNo), p.HWAddr.String(),
+		cString(p.Name), flagsJSON(p.Config, portConfigNames),
+		flagsJSON(p.State, portStateNames), flagsJSON(p.Curr, portFeatureNames),
+		flagsJSON(p.Advertised, portFeatureNames), flagsJSON(p.Supported, portFeatureNames),
+		flagsJSON(p.Peer, portFeatureNames)})
+}
+
+func (p *PhyPort) UnmarshalJSON(data []byte) error {
+	var j jsonPhyPort
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*p = *NewPhyPort()
+	p.PortNo = uint16(j.PortNo)
+	if j.HWAddr != "" {
+		mac, err := net.ParseMAC(j.HWAddr)
+		if err != nil {
+			return err
+		}
+		p.HWAddr = mac
+	}
+	p.Name = setCString(p.Name, j.Name)
+	var err error
+	for _, f := range []struct {
+		v     *uint32
+		a     []string
+		names []flagName
+	}{{&p.Config, j.Config, portConfigNames}, {&p.State, j.State, portStateNames},
+		{&p.Curr, j.Curr, portFeatureNames}, {&p.Advertised, j.Advertised, portFeatureNames},
+		{&p.Supported, j.Supported, portFeatureNames}, {&p.Peer, j.Peer, portFeatureNames}} {
+		if *f.v, err = parseFlags(f.a, f.names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonPortStatus struct {
+	jsonHeader
+	Reason string  `json:"reason"`
+	Desc   PhyPort `json:"desc"`
+}
+
+func (p PortStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPortStatus{newJSONHeader(p.Header),
+		enumString(int(p.Reason), portReasons), p.Desc})
+}
+
+func (p *PortStatus) UnmarshalJSON(data []byte) error {
+	j := jsonPortStatus{Reason: "add"}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	reason, err := parseEnum(j.Reason, portReasons)
+	if err != nil {
+		return err
+	}
+	*p = *NewPortStatus()
+	p.Header = j.header(Type_PortStatus)
+	p.Reason, p.Desc = uint8(reason), j.Desc
+	p.Header.Length = p.Len()
+	return nil
+}
+
+type jsonPortMod struct {
+	jsonHeader
+	PortNo    jsonPort `json:"port_no"`
+	HWAddr    string   `json:"hw_addr"`
+	Config    []string `json:"config"`
+	Mask      []string `json:"mask"`
+	Advertise []string `json:"advertise"`
+}
+
+func (p PortMod) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPortMod{newJSONHeader(p.Header), jsonPort(p.PortNo),
+		net.HardwareAddr(p.HWAddr).String(), flagsJSON(p.Config, portConfigNames),
+		flagsJSON(p.Mask, portConfigNames), flagsJSON(p.Advertise, portFeatureNames)})
+}
+
+func (p *PortMod) UnmarshalJSON(data []byte) error {
+	var j jsonPortMod
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*p = *NewPortMod(int(j.PortNo))
+	p.Header = j.header(Type_PortMod)
+	if j.HWAddr != "" {
+		mac, err := net.ParseMAC(j.HWAddr)
+		if err != nil {
+			return err
+		}
+		p.HWAddr = mac
+	}
+	var err error
+	if p.Config, err = parseFlags(j.Config, portConfigNames); err != nil {
+		return err
+	}
+	if p.Mask, err = parseFlags(j.Mask, portConfigNames); err != nil {
+		return err
+	}
+	if p.Advertise, err = parseFlags(j.Advertise, portFeatureNames); err != nil {
+		return err
+	}
+	p.Header.Length = p.Len()
+	return nil
+}
+
+var actionTypeNames = []string{"output", "set_vlan_vid", "set_vlan_pcp",
+	"strip_vlan", "set_dl_src", "set_dl_dst", "set_nw_src", "set_nw_dst",
+	"set_nw_tos", "set_tp_src", "set_tp_dst", "enqueue"}
+
+type jsonSwitchFeatures struct {
+	jsonHeader
+	DPID         string    `json:"dpid"`
+	Buffers      uint32    `json:"n_buffers"`
+	Tables       uint8     `json:"n_tables"`
+	Capabilities []string  `json:"capabilities"`
+	Actions      []string  `json:"actions"`
+	Ports        []PhyPort `json:"ports"`
+}
+
+func (s SwitchFeatures) MarshalJSON() ([]byte, error) {
+	actions := make([]flagName, len(actionTypeNames))
+	for i, name := range actionTypeNames {
+		actions[i] = flagName{1 << uint(i), name}
+	}
+	ports := s.Ports
+	if ports == nil {
+		ports = make([]PhyPort, 0)
+	}
+	return json.Marshal(jsonSwitchFeatures{newJSONHeader(s.Header), s.DPID.String(),
+		s.Buffers, s.Tables, flagsJSON(s.Capabilities, capabilityNames),
+		flagsJSON(s.Actions, actions), ports})
+}
+
+func (s *SwitchFeatures) UnmarshalJSON(data []byte) error {
+	var j jsonSwitchFeatures
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewFeaturesReply()
+	s.Header = j.header(Type_FeaturesReply)
+	if j.DPID != "" {
+		dpid, err := net.ParseMAC(j.DPID)
+		if err != nil || len(dpid) != 8 {
+			return fmt.Errorf("Bad dpid %q.", j.DPID)
+		}
+		s.DPID = dpid
+	}
+	actions := make([]flagName, len(actionTypeNames))
+	for i, name := range actionTypeNames {
+		actions[i] = flagName{1 << uint(i), name}
+	}
+	var err error
+	if s.Capabilities, err = parseFlags(j.Capabilities, capabilityNames); err != nil {
+		return err
+	}
+	if s.Actions, err = parseFlags(j.Actions, actions); err != nil {
+		return err
+	}
+	s.Buffers, s.Tables, s.Ports = j.Buffers, j.Tables, j.Ports
+	s.Header.Length = s.Len()
+	return nil
+}
+
+type jsonSwitchConfig struct {
+	jsonHeader
+	Frag        string `json:"frag"`
+	MissSendLen uint16 `json:"miss_send_len"`
+}
+
+func (c SwitchConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSwitchConfig{newJSONHeader(c.Header),
+		enumString(int(c.Flags&C_FRAG_MASK), fragModes), c.MissSendLen})
+}
+
+func (c *SwitchConfig) UnmarshalJSON(data []byte) error {
+	j := jsonSwitchConfig{Frag: "normal"}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	frag, err := parseEnum(j.Frag, fragModes)
+	if err != nil {
+		return err
+	}
+	*c = *NewSetConfig()
+	c.Header = j.header(Type_SetConfig)
+	c.Flags, c.MissSendLen = uint16(frag), j.MissSendLen
+	c.Header.Length = c.Len()
+	return nil
+}
+
+type jsonErrorMsg struct {
+	jsonHeader
+	ErrorType string `json:"error_type"`
+	Code      uint16 `json:"code"`
+	Data      string `json:"data"`
+}
+
+func (e ErrorMsg) MarshalJSON() ([]byte, error) {
+	j := jsonErrorMsg{jsonHeader: newJSONHeader(e.Header),
+		ErrorType: enumString(int(e.Code), errorTypes)}
+	b := e.Data.Bytes()
+	if len(b) >= 2 {
+		j.Code = binary.BigEndian.Uint16(b)
+		b = b[2:]
+	}
+	j.Data = hex.EncodeToString(b)
+	return json.Marshal(j)
+}
+
+func (e *ErrorMsg) UnmarshalJSON(data []byte) error {
+	var j jsonErrorMsg
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	t, err := parseEnum(j.ErrorType, errorTypes)
+	if err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(j.Data)
+	if err != nil {
+		return err
+	}
+	*e = *NewErrorMsg()
+	e.Header = j.header(Type_Error)
+	e.Code = uint16(t)
+	code := make([]byte, 2)
+	binary.BigEndian.PutUint16(code, j.Code)
+	e.Data = *util.NewBuffer(append(code, b...))
+	e.Header.Length = e.Len()
+	return nil
+}
+
+type jsonVendorHeader struct {
+	jsonHeader
+	Vendor uint32 `json:"vendor"`
+}
+
+func (v VendorHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonVendorHeader{newJSONHeader(v.Header), v.Vendor})
+}
+
+func (v *VendorHeader) UnmarshalJSON(data []byte) error {
+	var j jsonVendorHeader
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	v.Header = j.header(Type_Vendor)
+	v.Vendor = j.Vendor
+	v.Header.Length = v.Len()
+	return nil
+}
+
+type jsonStats struct {
+	jsonHeader
+	StatsType string          `json:"stats_type"`
+	Flags     uint16          `json:"flags"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+func statsJSON(h ofpxx.Header, t, flags uint16, body util.Message) ([]byte, error) {
+	j := jsonStats{jsonHeader: newJSONHeader(h), StatsType: statsTypeString(t), Flags: flags}
+	if buf, ok := body.(*util.Buffer); ok {
+		if buf.Buffer.Len() > 0 {
+			j.Body, _ = json.Marshal(hexJSON(buf))
+		}
+	} else if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		j.Body = b
+	}
+	return json.Marshal(j)
+}
+
+// Decodes the JSON of a stats message. Returns its type, flags
+// and body, given the body types of requests or replies by
+// stats type.
+func parseStatsJSON(data []byte, bodies func(t uint16) util.Message) (h ofpxx.Header, t, flags uint16, body util.Message, err error) {
+	var j jsonStats
+	if err = json.Unmarshal(data, &j); err != nil {
+		return
+	}
+	h = j.header(0)
+	if j.StatsType == "vendor" {
+		t = StatsType_Vendor
+	} else {
+		var n int
+		if n, err = parseEnum(j.StatsType, statsTypes); err != nil {
+			return
+		}
+		t = uint16(n)
+	}
+	flags = j.Flags
+	body = bodies(t)
+	if body == nil || len(j.Body) == 0 {
+		buf := new(util.Buffer)
+		if len(j.Body) > 0 {
+			var s string
+			if err = json.Unmarshal(j.Body, &s); err != nil {
+				return
+			}
+			var b []byte
+			if b, err = hex.DecodeString(s); err != nil {
+				return
+			}
+			buf.UnmarshalBinary(b)
+		}
+		body = buf
+		return
+	}
+	err = json.Unmarshal(j.Body, body)
+	return
+}
+
+func (s StatsRequest) MarshalJSON() ([]byte, error) {
+	return statsJSON(s.Header, s.Type, s.Flags, s.Body)
+}
+
+func (s *StatsRequest) UnmarshalJSON(data []byte) error {
+	h, t, flags, body, err := parseStatsJSON(data, func(t uint16) util.Message {
+		switch t {
+		case StatsType_Flow:
+			return NewFlowStatsRequest()
+		case StatsType_Aggregate:
+			return NewAggregateStatsRequest()
+		case StatsType_Port:
+			return NewPortStatsRequest()
+		case StatsType_Queue:
+			return NewQueueStatsRequest()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*s = *NewStatsRequest(t, body)
+	s.Header = h
+	s.Header.Type = Type_StatsRequest
+	s.Flags = flags
+	s.Header.Length = s.Len()
+	return nil
+}
+
+func (s StatsReply) MarshalJSON() ([]byte, error) {
+	return statsJSON(s.Header, s.Type, s.Flags, s.Body)
+}
+
+func (s *StatsReply) UnmarshalJSON(data []byte) error {
+	h, t, flags, body, err := parseStatsJSON(data, func(t uint16) util.Message {
+		switch t {
+		case StatsType_Desc:
+			return NewDescStats()
+		case StatsType_Flow:
+			return new(FlowStatsReply)
+		case StatsType_Aggregate:
+			return NewAggregateStats()
+		case StatsType_Table:
+			return NewTableStats()
+		case StatsType_Port:
+			return NewPortStats()
+		case StatsType_Queue:
+			return NewQueueStats()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.Header = h
+	s.Header.Type = Type_StatsReply
+	s.Type, s.Flags, s.Body = t, flags, body
+	s.Header.Length = s.Len()
+	return nil
+}
+
+type jsonFlowStatsRequest struct {
+	Match   Match    `json:"match"`
+	TableId uint8    `json:"table_id"`
+	OutPort jsonPort `json:"out_port"`
+}
+
+func (s FlowStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFlowStatsRequest{s.Match, s.TableId, jsonPort(s.OutPort)})
+}
+
+func (s *FlowStatsRequest) UnmarshalJSON(data []byte) error {
+	j := jsonFlowStatsRequest{Match: *NewMatch(), TableId: 0xff, OutPort: P_NONE}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	s.Match, s.TableId, s.OutPort = j.Match, j.TableId, uint16(j.OutPort)
+	return nil
+}
+
+func (s AggregateStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFlowStatsRequest{s.Match, s.TableId, jsonPort(s.OutPort)})
+}
+
+func (s *AggregateStatsRequest) UnmarshalJSON(data []byte) error {
+	j := jsonFlowStatsRequest{Match: *NewMatch(), TableId: 0xff, OutPort: P_NONE}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	s.Match, s.TableId, s.OutPort = j.Match, j.TableId, uint16(j.OutPort)
+	return nil
+}
+
+type jsonFlowStats struct {
+	TableId      uint8    `json:"table_id"`
+	Match        Match    `json:"match"`
+	DurationSec  uint32   `json:"duration_sec"`
+	DurationNSec uint32   `json:"duration_nsec"`
+	Priority     uint16   `json:"priority"`
+	IdleTimeout  uint16   `json:"idle_timeout"`
+	HardTimeout  uint16   `json:"hard_timeout"`
+	Cookie       uint64   `json:"cookie"`
+	PacketCount  uint64   `json:"packet_count"`
+	ByteCount    uint64   `json:"byte_count"`
+	Actions      []string `json:"actions"`
+}
+
+func (s FlowStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFlowStats{s.TableId, s.Match, s.DurationSec,
+		s.DurationNSec, s.Priority, s.IdleTimeout, s.HardTimeout, s.Cookie,
+		s.PacketCount, s.ByteCount, actionsJSON(s.Actions)})
+}
+
+func (s *FlowStats) UnmarshalJSON(data []byte) error {
+	j := jsonFlowStats{Match: *NewMatch()}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	actions, err := parseActions(j.Actions)
+	if err != nil {
+		return err
+	}
+	*s = *NewFlowStats()
+	s.TableId, s.Match, s.DurationSec, s.DurationNSec = j.TableId, j.Match, j.DurationSec, j.DurationNSec
+	s.Priority, s.IdleTimeout, s.HardTimeout = j.Priority, j.IdleTimeout, j.HardTimeout
+	s.Cookie, s.PacketCount, s.ByteCount, s.Actions = j.Cookie, j.PacketCount, j.ByteCount, actions
+	s.Length = s.Len()
+	return nil
+}
+
+func (r FlowStatsReply) MarshalJSON() ([]byte, error) {
+	flows := r.Flows
+	if flows == nil {
+		flows = make([]FlowStats, 0)
+	}
+	return json.Marshal(flows)
+}
+
+func (r *FlowStatsReply) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &r.Flows)
+}
+
+type jsonDescStats struct {
+	MfrDesc   string `json:"mfr_desc"`
+	HWDesc    string `json:"hw_desc"`
+	SWDesc    string `json:"sw_desc"`
+	SerialNum string `json:"serial_num"`
+	DPDesc    string `json:"dp_desc"`
+}
+
+func (s DescStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonDescStats{cString(s.MfrDesc), cString(s.HWDesc),
+		cString(s.SWDesc), cString(s.SerialNum), cString(s.DPDesc)})
+}
+
+func (s *DescStats) UnmarshalJSON(data []byte) error {
+	var j jsonDescStats
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewDescStats()
+	s.MfrDesc = setCString(s.MfrDesc, j.MfrDesc)
+	s.HWDesc = setCString(s.HWDesc, j.HWDesc)
+	s.SWDesc = setCString(s.SWDesc, j.SWDesc)
+	s.SerialNum = setCString(s.SerialNum, j.SerialNum)
+	s.DPDesc = setCString(s.DPDesc, j.DPDesc)
+	return nil
+}
+
+type jsonAggregateStats struct {
+	PacketCount uint64 `json:"packet_count"`
+	ByteCount   uint64 `json:"byte_count"`
+	FlowCount   uint32 `json:"flow_count"`
+}
+
+func (s AggregateStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonAggregateStats{s.PacketCount, s.ByteCount, s.FlowCount})
+}
+
+func (s *AggregateStats) UnmarshalJSON(data []byte) error {
+	var j jsonAggregateStats
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewAggregateStats()
+	s.PacketCount, s.ByteCount, s.FlowCount = j.PacketCount, j.ByteCount, j.FlowCount
+	return nil
+}
+
+type jsonTableStats struct {
+	TableId      uint8  `json:"table_id"`
+	Name         string `json:"name"`
+	Wildcards    uint32 `json:"wildcards"`
+	MaxEntries   uint32 `json:"max_entries"`
+	ActiveCount  uint32 `json:"active_count"`
+	LookupCount  uint64 `json:"lookup_count"`
+	MatchedCount uint64 `json:"matched_count"`
+}
+
+func (s TableStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonTableStats{s.TableId, cString(s.Name), s.Wildcards,
+		s.MaxEntries, s.ActiveCount, s.LookupCount, s.MatchedCount})
+}
+
+func (s *TableStats) UnmarshalJSON(data []byte) error {
+	var j jsonTableStats
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewTableStats()
+	s.TableId, s.Name, s.Wildcards = j.TableId, setCString(s.Name, j.Name), j.Wildcards
+	s.MaxEntries, s.ActiveCount = j.MaxEntries, j.ActiveCount
+	s.LookupCount, s.MatchedCount = j.LookupCount, j.MatchedCount
+	return nil
+}
+
+type jsonPortStatsRequest struct {
+	PortNo jsonPort `json:"port_no"`
+}
+
+func (s PortStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPortStatsRequest{jsonPort(s.PortNo)})
+}
+
+func (s *PortStatsRequest) UnmarshalJSON(data []byte) error {
+	j := jsonPortStatsRequest{P_NONE}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewPortStatsRequest()
+	s.PortNo = uint16(j.PortNo)
+	return nil
+}
+
+type jsonPortStats struct {
+	PortNo     jsonPort `json:"port_no"`
+	RxPackets  uint64   `json:"rx_packets"`
+	TxPackets  uint64   `json:"tx_packets"`
+	RxBytes    uint64   `json:"rx_bytes"`
+	TxBytes    uint64   `json:"tx_bytes"`
+	RxDropped  uint64   `json:"rx_dropped"`
+	TxDropped  uint64   `json:"tx_dropped"`
+	RxErrors   uint64   `json:"rx_errors"`
+	TxErrors   uint64   `json:"tx_errors"`
+	RxFrameErr uint64   `json:"rx_frame_err"`
+	RxOverErr  uint64   `json:"rx_over_err"`
+	RxCRCErr   uint64   `json:"rx_crc_err"`
+	Collisions uint64   `json:"collisions"`
+}
+
+func (s PortStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPortStats{jsonPort(s.PortNo), s.RxPackets, s.TxPackets,
+		s.RxBytes, s.TxBytes, s.RxDropped, s.TxDropped, s.RxErrors, s.TxErrors,
+		s.RxFrameErr, s.RxOverErr, s.RxCRCErr, s.Collisions})
+}
+
+func (s *PortStats) UnmarshalJSON(data []byte) error {
+	var j jsonPortStats
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewPortStats()
+	s.PortNo, s.RxPackets, s.TxPackets = uint16(j.PortNo), j.RxPackets, j.TxPackets
+	s.RxBytes, s.TxBytes, s.RxDropped, s.TxDropped = j.RxBytes, j.TxBytes, j.RxDropped, j.TxDropped
+	s.RxErrors, s.TxErrors, s.RxFrameErr = j.RxErrors, j.TxErrors, j.RxFrameErr
+	s.RxOverErr, s.RxCRCErr, s.Collisions = j.RxOverErr, j.RxCRCErr, j.Collisions
+	return nil
+}
+
+type jsonQueueStats struct {
+	PortNo    jsonPort `json:"port_no"`
+	QueueId   uint32   `json:"queue_id"`
+	TxBytes   uint64   `json:"tx_bytes,omitempty"`
+	TxPackets uint64   `json:"tx_packets,omitempty"`
+	TxErrors  uint64   `json:"tx_errors,omitempty"`
+}
+
+func (s QueueStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQueueStats{PortNo: jsonPort(s.PortNo), QueueId: s.QueueId})
+}
+
+func (s *QueueStatsRequest) UnmarshalJSON(data []byte) error {
+	j := jsonQueueStats{PortNo: P_ALL, QueueId: 0xffffffff}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewQueueStatsRequest()
+	s.PortNo, s.QueueId = uint16(j.PortNo), j.QueueId
+	return nil
+}
+
+func (s QueueStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQueueStats{jsonPort(s.PortNo), s.QueueId, s.TxBytes,
+		s.TxPackets, s.TxErrors})
+}
+
+func (s *QueueStats) UnmarshalJSON(data []byte) error {
+	var j jsonQueueStats
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = *NewQueueStats()
+	s.PortNo, s.QueueId = uint16(j.PortNo), j.QueueId
+	s.TxBytes, s.TxPackets, s.TxErrors = j.TxBytes, j.TxPackets, j.TxErrors
+	return nil
+}
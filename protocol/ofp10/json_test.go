@@ -0,0 +1,111 @@
+package ofp10
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Encodes msg, decodes it and encodes it again, expecting the
+// same JSON.
+func jsonRoundTrip(t *testing.T, msg interface{}) []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeJSON(b)
+	if err != nil {
+		t.Fatalf("Couldn't decode %s: %v", b, err)
+	}
+	b2, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Errorf("Round trip changed\n%s\nto\n%s", b, b2)
+	}
+	return b
+}
+
+func TestFlowModJSON(t *testing.T) {
+	f := NewFlowMod()
+	f.Priority = 100
+	f.Flags = FF_SEND_FLOW_REM
+	f.Match.Wildcards = FW_ALL&^(FW_IN_PORT|FW_DL_TYPE|FW_NW_DST_MASK) |
+		8<<FW_NW_DST_SHIFT
+	f.Match.InPort = 1
+	f.Match.DLType = 0x0800
+	f.Match.NWDst = net.IPv4(10, 0, 0, 0).To4()
+	f.AddAction(NewActionNWDst(net.IPv4(10, 0, 0, 2).To4()))
+	f.AddAction(NewActionOutput(P_CONTROLLER))
+	b := jsonRoundTrip(t, f)
+	for _, s := range []string{`"type":"flow_mod"`, `"command":"add"`,
+		`"match":{"dl_type":"0x0800","in_port":1,"nw_dst":"10.0.0.0/24"}`,
+		`"flags":["send_flow_rem"]`, `"actions":["mod_nw_dst:10.0.0.2","controller:256"]`} {
+		if !bytes.Contains(b, []byte(s)) {
+			t.Errorf("%s lacks %s.", b, s)
+		}
+	}
+}
+
+func TestDecodeJSONFixture(t *testing.T) {
+	msg, err := DecodeJSON([]byte(`{"version": 1, "type": "flow_mod", "xid": 9,
+		"command": "delete", "match": {"in_port": "local", "dl_src": "00:00:00:00:00:01"},
+		"out_port": 2, "actions": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := msg.(*FlowMod)
+	if !ok {
+		t.Fatalf("Got %T.", msg)
+	}
+	if f.Header.Xid != 9 || f.Command != FC_DELETE || f.OutPort != 2 ||
+		f.BufferId != 0xffffffff || f.Header.Length != f.Len() {
+		t.Errorf("Got %+v.", f)
+	}
+	if f.Match.InPort != P_LOCAL || f.Match.Wildcards != FW_ALL&^(FW_IN_PORT|FW_DL_SRC) {
+		t.Errorf("Got match %v, wildcards 0x%x.", &f.Match, f.Match.Wildcards)
+	}
+}
+
+func TestPacketOutJSON(t *testing.T) {
+	p := NewPacketOut()
+	p.InPort = 3
+	p.AddAction(NewActionOutput(P_FLOOD))
+	frame := eth.New()
+	frame.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	frame.HWDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	frame.Ethertype = 0x88cc
+	p.Data = frame
+	jsonRoundTrip(t, p)
+}
+
+func TestStatsReplyJSON(t *testing.T) {
+	s := NewFlowStats()
+	s.Priority = 10
+	s.PacketCount = 42
+	s.Actions = append(s.Actions, NewActionOutput(2))
+	r := new(StatsReply)
+	r.Header = ofpxx.NewOfp10Header()
+	r.Header.Type = Type_StatsReply
+	r.Type = StatsType_Flow
+	r.Body = &FlowStatsReply{Flows: []FlowStats{*s}}
+	jsonRoundTrip(t, r)
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"version": 1, "type": "frob"}`,
+		`{"version": 1, "type": "flow_mod", "match": {"nw_dst": "10.0.0.0/33"}}`,
+		`{"version": 1, "type": "flow_mod", "actions": ["teleport:1"]}`,
+		`{"version": 1, "type": "packet_out", "in_port": "nowhere"}`,
+	} {
+		if _, err := DecodeJSON([]byte(s)); err == nil {
+			t.Errorf("Decoded %s.", s)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package ofp10
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Nicira extensions, supported by Open vSwitch.
+const (
+	NX_VENDOR_ID = 0x00002320
+)
+
+// nx_action_subtype
+const (
+	NXAST_LEARN = 16
+)
+
+// NXM field headers used by learn specs.
+const (
+	NXM_OF_IN_PORT  = 0x00000002
+	NXM_OF_ETH_DST  = 0x00000206
+	NXM_OF_ETH_SRC  = 0x00000406
+	NXM_OF_ETH_TYPE = 0x00000602
+	NXM_OF_VLAN_TCI = 0x00000802
+)
+
+// Returns the width of an NXM field in bits.
+func NXMBits(field uint32) uint16 {
+	return uint16(field&0xff) * 8
+}
+
+// nx_flow_mod_spec header bits
+const (
+	NX_LEARN_SRC_FIELD     = 0 << 13
+	NX_LEARN_SRC_IMMEDIATE = 1 << 13
+	NX_LEARN_DST_MATCH     = 0 << 11
+	NX_LEARN_DST_LOAD      = 1 << 11
+	NX_LEARN_DST_OUTPUT    = 2 << 11
+)
+
+// nx_action_learn flags
+const (
+	NX_LEARN_F_SEND_FLOW_REM  = 1 << 0
+	NX_LEARN_F_DELETE_LEARNED = 1 << 1
+)
+
+// A flow_mod_spec of a learn action. Src is either a field
+// (SrcField, SrcOfs) or an immediate value, and Dst is a field
+// to match or load (DstField, DstOfs), or an output port.
+type LearnSpec struct {
+	Src      uint16
+	Dst      uint16
+	NBits    uint16
+	SrcField uint32
+	SrcOfs   uint16
+	SrcValue []byte
+	DstField uint32
+	DstOfs   uint16
+}
+
+// Returns a spec that matches field dst in the learned flow
+// against the value of field src in the current packet.
+func NewLearnMatch(dst, src uint32) LearnSpec {
+	return LearnSpec{
+		Src:      NX_LEARN_SRC_FIELD,
+		Dst:      NX_LEARN_DST_MATCH,
+		NBits:    NXMBits(src),
+		SrcField: src,
+		DstField: dst,
+	}
+}
+
+// Returns a spec that makes the learned flow output to the port
+// held in field src of the current packet.
+func NewLearnOutput(src uint32) LearnSpec {
+	return LearnSpec{
+		Src:      NX_LEARN_SRC_FIELD,
+		Dst:      NX_LEARN_DST_OUTPUT,
+		NBits:    NXMBits(src),
+		SrcField: src,
+	}
+}
+
+func (s *LearnSpec) Len() (n uint16) {
+	n = 2
+	if s.Src == NX_LEARN_SRC_FIELD {
+		n += 6
+	} else {
+		n += (s.NBits + 15) / 16 * 2
+	}
+	if s.Dst != NX_LEARN_DST_OUTPUT {
+		n += 6
+	}
+	return
+}
+
+func (s *LearnSpec) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(s.Len()))
+	n := 0
+	binary.BigEndian.PutUint16(data[n:], s.Src|s.Dst|(s.NBits&0x3ff))
+	n += 2
+	if s.Src == NX_LEARN_SRC_FIELD {
+		binary.BigEndian.PutUint32(data[n:], s.SrcField)
+		n += 4
+		binary.BigEndian.PutUint16(data[n:], s.SrcOfs)
+		n += 2
+	} else {
+		// Immediate values are right aligned.
+		size := int((s.NBits + 15) / 16 * 2)
+		if len(s.SrcValue) > size {
+			return nil, errors.New("LearnSpec immediate value is wider than NBits.")
+		}
+		copy(data[n+size-len(s.SrcValue):], s.SrcValue)
+		n += size
+	}
+	if s.Dst != NX_LEARN_DST_OUTPUT {
+		binary.BigEndian.PutUint32(data[n:], s.DstField)
+		n += 4
+		binary.BigEndian.PutUint16(data[n:], s.DstOfs)
+		n += 2
+	}
+	return
+}
+
+func (s *LearnSpec) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("The []byte is too short to unmarshal a full LearnSpec.")
+	}
+	h := binary.BigEndian.Uint16(data)
+	s.Src = h & (1 << 13)
+	s.Dst = h & (3 << 11)
+	s.NBits = h & 0x3ff
+	if len(data) < int(s.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full LearnSpec.")
+	}
+	n := 2
+	if s.Src == NX_LEARN_SRC_FIELD {
+		s.SrcField = binary.BigEndian.Uint32(data[n:])
+		n += 4
+		s.SrcOfs = binary.BigEndian.Uint16(data[n:])
+		n += 2
+	} else {
+		size := int((s.NBits + 15) / 16 * 2)
+		s.SrcValue = make([]byte, size)
+		copy(s.SrcValue, data[n:n+size])
+		n += size
+	}
+	if s.Dst != NX_LEARN_DST_OUTPUT {
+		s.DstField = binary.BigEndian.Uint32(data[n:])
+		n += 4
+		s.DstOfs = binary.BigEndian.Uint16(data[n:])
+		n += 2
+	}
+	return nil
+}
+
+// nx_action_learn. Adds or modifies a flow in TableId each time
+// it is executed, built from Specs and the current packet.
+type NXActionLearn struct {
+	ActionHeader
+	Vendor         uint32
+	Subtype        uint16
+	IdleTimeout    uint16
+	HardTimeout    uint16
+	Priority       uint16
+	Cookie         uint64
+	Flags          uint16
+	TableId        uint8
+	FinIdleTimeout uint16
+	FinHardTimeout uint16
+	Specs          []LearnSpec
+}
+
+func NewNXActionLearn() *NXActionLearn {
+	a := new(NXActionLearn)
+	a.Type = ActionType_Vendor
+	a.Vendor = NX_VENDOR_ID
+	a.Subtype = NXAST_LEARN
+	a.Priority = 1000
+	a.Specs = make([]LearnSpec, 0)
+	a.Length = a.Len()
+	return a
+}
+
+func (a *NXActionLearn) AddSpec(s LearnSpec) {
+	a.Specs = append(a.Specs, s)
+	a.Length = a.Len()
+}
+
+func (a *NXActionLearn) Len() (n uint16) {
+	n = 32
+	for _, s := range a.Specs {
+		n += s.Len()
+	}
+	// Specs are terminated by zeros, padded to 8 bytes.
+	return (n + 7) / 8 * 8
+}
+
+func (a *NXActionLearn) MarshalBinary() (data []byte, err error) {
+	a.Length = a.Len()
+	data = make([]byte, int(a.Len()))
+	b := make([]byte, 0)
+	n := 0
+
+	b, err = a.ActionHeader.MarshalBinary()
+	copy(data[n:], b)
+	n += len(b)
+	binary.BigEndian.PutUint32(data[n:], a.Vendor)
+	n += 4
+	binary.BigEndian.PutUint16(data[n:], a.Subtype)
+	n += 2
+	binary.BigEndian.PutUint16(data[n:], a.IdleTimeout)
+	n += 2
+	binary.BigEndian.PutUint16(data[n:], a.HardTimeout)
+	n += 2
+	binary.BigEndian.PutUint16(data[n:], a.Priority)
+	n += 2
+	binary.BigEndian.PutUint64(data[n:], a.Cookie)
+	n += 8
+	binary.BigEndian.PutUint16(data[n:], a.Flags)
+	n += 2
+	data[n] = a.TableId
+	n += 2 // pad
+	binary.BigEndian.PutUint16(data[n:], a.FinIdleTimeout)
+	n += 2
+	binary.BigEndian.PutUint16(data[n:], a.FinHardTimeout)
+	n += 2
+
+	for _, s := range a.Specs {
+		b, err = s.MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(data[n:], b)
+		n += len(b)
+	}
+	return
+}
+
+func (a *NXActionLearn) UnmarshalBinary(data []byte) error {
+	if len(data) < 32 {
+		return errors.New("The []byte is too short to unmarshal a full NXActionLearn message.")
+	}
+	n := 0
+	a.ActionHeader.UnmarshalBinary(data[n:4])
+	n += 4
+	if int(a.Length) > len(data) || a.Length < 32 {
+		return errors.New("The []byte is too short to unmarshal a full NXActionLearn message.")
+	}
+	a.Vendor = binary.BigEndian.Uint32(data[n:])
+	n += 4
+	a.Subtype = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.IdleTimeout = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.HardTimeout = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.Priority = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.Cookie = binary.BigEndian.Uint64(data[n:])
+	n += 8
+	a.Flags = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.TableId = data[n]
+	n += 2 // pad
+	a.FinIdleTimeout = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	a.FinHardTimeout = binary.BigEndian.Uint16(data[n:])
+	n += 2
+
+	a.Specs = make([]LearnSpec, 0)
+	for n+2 <= int(a.Length) {
+		// A zero header ends the list of specs.
+		if binary.BigEndian.Uint16(data[n:]) == 0 {
+			break
+		}
+		s := LearnSpec{}
+		if err := s.UnmarshalBinary(data[n:a.Length]); err != nil {
+			return err
+		}
+		a.Specs = append(a.Specs, s)
+		n += int(s.Len())
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package ofp10
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var learnHex = "   ff ff 00 38 00 00 23 20" + // Vendor action header
+	"00 10 00 0a 00 00 03 e8" + // Subtype, idle, hard, priority
+	"00 00 00 00 00 00 00 00" + // Cookie
+	"00 00 00 00 00 00 00 00" + // Flags, table, pad, fin timeouts
+	"00 30 00 00 04 06 00 00" + // Match eth_dst = eth_src
+	"00 00 02 06 00 00" +
+	"10 10 00 00 00 02 00 00" + // Output in_port
+	"00 00" // Terminator and pad
+
+func TestNXActionLearnMarshalBinary(t *testing.T) {
+	b := strings.Replace(learnHex, " ", "", -1)
+
+	a := NewNXActionLearn()
+	a.IdleTimeout = 10
+	a.AddSpec(NewLearnMatch(NXM_OF_ETH_DST, NXM_OF_ETH_SRC))
+	a.AddSpec(NewLearnOutput(NXM_OF_IN_PORT))
+	data, _ := a.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestNXActionLearnUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(learnHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	a := new(NXActionLearn)
+	if err := a.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if a.IdleTimeout != 10 || a.Priority != 1000 {
+		t.Errorf("Got idle timeout %d priority %d, expected 10 and 1000.", a.IdleTimeout, a.Priority)
+	}
+	if len(a.Specs) != 2 {
+		t.Fatalf("Got %d specs, expected 2.", len(a.Specs))
+	}
+	if a.Specs[0].DstField != NXM_OF_ETH_DST || a.Specs[0].NBits != 48 {
+		t.Errorf("Got match spec %+v.", a.Specs[0])
+	}
+	if a.Specs[1].Dst != NX_LEARN_DST_OUTPUT || a.Specs[1].SrcField != NXM_OF_IN_PORT {
+		t.Errorf("Got output spec %+v.", a.Specs[1])
+	}
+}
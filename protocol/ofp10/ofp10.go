@@ -40,6 +40,16 @@ func NewEchoReply() *ofpxx.Header {
 	return &h
 }
 
+// The barrier request is used to ensure message dependencies
+// have been met or to receive notifications for completed
+// operations. The switch must finish processing all messages
+// received before the barrier before replying.
+func NewBarrierRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp10Header()
+	h.Type = Type_BarrierRequest
+	return &h
+}
+
 // ofp_type 1.0
 const (
 	/* Immutable messages. */
@@ -163,12 +173,19 @@ func (p *PacketOut) UnmarshalBinary(data []byte) error {
 	p.ActionsLen = binary.BigEndian.Uint16(data[n:])
 	n += 2
 
-	for n < (n + p.ActionsLen) {
+	end := n + p.ActionsLen
+	for n < end {
 		a := DecodeAction(data[n:])
 		p.Actions = append(p.Actions, a)
 		n += a.Len()
 	}
 
+	if int(n) >= len(data) {
+		return err
+	}
+	if p.Data == nil {
+		p.Data = eth.New()
+	}
 	err = p.Data.UnmarshalBinary(data[n:])
 	return err
 }
@@ -233,6 +250,9 @@ func (p *PacketIn) UnmarshalBinary(data []byte) error {
 	p.Reason = data[n]
 	n += 1
 
+	if p.Data.HWDst == nil {
+		p.Data = *eth.New()
+	}
 	err = p.Data.UnmarshalBinary(data[n:])
 	return err
 }
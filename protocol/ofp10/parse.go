@@ -1,8 +1,6 @@
 package ofp10
 
 import (
-	"errors"
-	
 	"github.com/jonstout/ogo/protocol/ofpxx"
 	"github.com/jonstout/ogo/protocol/util"
 )
@@ -72,7 +70,7 @@ func Parse(b []byte) (message util.Message, err error) {
 	case Type_QueueGetConfigReply:
 		break
 	default:
-		err = errors.New("An unknown v1.0 packet type was received. Parse function will discard data.")
+		err = &ofpxx.UnknownTypeError{Version: b[0], Type: b[1]}
 	}
 	return
 }
@@ -65,6 +65,8 @@ func (p *PhyPort) UnmarshalBinary(data []byte) error {
 	p.PortNo = binary.BigEndian.Uint16(data)
 	n := 2
 
+	p.HWAddr = make([]byte, ETH_ALEN)
+	p.Name = make([]byte, 16)
 	copy(p.HWAddr, data[n:n+6])
 	n += 6
 	copy(p.Name, data[n:n+16])
@@ -214,4 +216,62 @@ const (
 	PF_PAUSE_ASYM = 1 << 11
 )
 
+// Transmission media of a port.
+const (
+	MediumCopper = "copper"
+	MediumFiber  = "fiber"
+)
+
+// The link modes described by a set of port feature bits, such
+// as the Curr, Advertised or Supported fields of a PhyPort.
+type PortFeatures struct {
+	// The fastest mode, in Mbit/s, or 0 if no speed is set.
+	Speed      uint32
+	FullDuplex bool
+	// MediumCopper, MediumFiber or empty if not given.
+	Medium    string
+	Autoneg   bool
+	Pause     bool
+	PauseAsym bool
+}
+
+// The speed, in Mbit/s, and duplex of each rate feature.
+var portSpeeds = []struct {
+	bit        uint32
+	speed      uint32
+	fullDuplex bool
+}{
+	{PF_10GB_FD, 10000, true},
+	{PF_1GB_FD, 1000, true},
+	{PF_1GB_HD, 1000, false},
+	{PF_100MB_FD, 100, true},
+	{PF_100MB_HD, 100, false},
+	{PF_10MB_FD, 10, true},
+	{PF_10MB_HD, 10, false},
+}
+
+// Decodes the port feature bits f.
+func ParsePortFeatures(f uint32) PortFeatures {
+	p := PortFeatures{Autoneg: f&PF_AUTONEG != 0, Pause: f&PF_PAUSE != 0,
+		PauseAsym: f&PF_PAUSE_ASYM != 0}
+	for _, s := range portSpeeds {
+		if f&s.bit != 0 {
+			p.Speed, p.FullDuplex = s.speed, s.fullDuplex
+			break
+		}
+	}
+	if f&PF_FIBER != 0 {
+		p.Medium = MediumFiber
+	} else if f&PF_COPPER != 0 {
+		p.Medium = MediumCopper
+	}
+	return p
+}
+
+// Returns the speed of the current mode of the port in bits per
+// second, 0 if unknown.
+func (p *PhyPort) CurrentSpeed() uint64 {
+	return uint64(ParsePortFeatures(p.Curr).Speed) * 1000000
+}
+
 // END: 10 - 5.2.1
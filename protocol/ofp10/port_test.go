@@ -0,0 +1,23 @@
+package ofp10
+
+import "testing"
+
+func TestParsePortFeatures(t *testing.T) {
+	f := ParsePortFeatures(PF_100MB_FD | PF_1GB_HD | PF_COPPER | PF_AUTONEG)
+	if f.Speed != 1000 || f.FullDuplex || f.Medium != MediumCopper || !f.Autoneg || f.Pause {
+		t.Errorf("Got %+v, expected 1000 Mbit/s half duplex copper with autonegotiation.", f)
+	}
+	f = ParsePortFeatures(PF_10GB_FD | PF_FIBER)
+	if f.Speed != 10000 || !f.FullDuplex || f.Medium != MediumFiber {
+		t.Errorf("Got %+v, expected 10 Gbit/s full duplex fiber.", f)
+	}
+	if f := ParsePortFeatures(0); f.Speed != 0 || f.Medium != "" {
+		t.Errorf("Got %+v for no features.", f)
+	}
+
+	p := NewPhyPort()
+	p.Curr = PF_1GB_FD
+	if s := p.CurrentSpeed(); s != 1000000000 {
+		t.Errorf("Got speed %d, expected 1000000000.", s)
+	}
+}
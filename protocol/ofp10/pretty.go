@@ -0,0 +1,489 @@
+package ofp10
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jonstout/ogo/protocol/arp"
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+var typeNames = []string{"HELLO", "ERROR", "ECHO_REQUEST", "ECHO_REPLY",
+	"VENDOR", "FEATURES_REQUEST", "FEATURES_REPLY", "GET_CONFIG_REQUEST",
+	"GET_CONFIG_REPLY", "SET_CONFIG", "PACKET_IN", "FLOW_REMOVED",
+	"PORT_STATUS", "PACKET_OUT", "FLOW_MOD", "PORT_MOD", "STATS_REQUEST",
+	"STATS_REPLY", "BARRIER_REQUEST", "BARRIER_REPLY",
+	"QUEUE_GET_CONFIG_REQUEST", "QUEUE_GET_CONFIG_REPLY"}
+
+// Returns the name of message type t, like OFPT_FLOW_MOD.
+func TypeName(t uint8) string {
+	if int(t) < len(typeNames) {
+		return "OFPT_" + typeNames[t]
+	}
+	return fmt.Sprintf("OFPT_UNKNOWN(%d)", t)
+}
+
+var portNames = map[uint16]string{
+	P_IN_PORT:    "in_port",
+	P_TABLE:      "table",
+	P_NORMAL:     "normal",
+	P_FLOOD:      "flood",
+	P_ALL:        "all",
+	P_CONTROLLER: "controller",
+	P_LOCAL:      "local",
+	P_NONE:       "none",
+}
+
+// Returns the number of port p, or the name of a reserved port.
+func PortName(p uint16) string {
+	if name, ok := portNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+type flagName struct {
+	bit  uint32
+	name string
+}
+
+// Returns the names of the flags set in v, separated by |, and
+// any unnamed bits in hex.
+func flagString(v uint32, names []flagName) string {
+	a := make([]string, 0)
+	for _, f := range names {
+		if v&f.bit != 0 {
+			a = append(a, f.name)
+			v &^= f.bit
+		}
+	}
+	if v != 0 {
+		a = append(a, fmt.Sprintf("0x%x", v))
+	}
+	if len(a) == 0 {
+		return "0"
+	}
+	return strings.Join(a, "|")
+}
+
+var capabilityNames = []flagName{{C_FLOW_STATS, "flow_stats"},
+	{C_TABLE_STATS, "table_stats"}, {C_PORT_STATS, "port_stats"},
+	{C_STP, "stp"}, {C_RESERVED, "reserved"}, {C_IP_REASM, "ip_reasm"},
+	{C_QUEUE_STATS, "queue_stats"}, {C_ARP_MATCH_IP, "arp_match_ip"}}
+
+var portConfigNames = []flagName{{PC_PORT_DOWN, "port_down"},
+	{PC_NO_STP, "no_stp"}, {PC_NO_RECV, "no_recv"},
+	{PC_NO_STP_RECV, "no_stp_recv"}, {PC_NO_FLOOD, "no_flood"},
+	{PC_NO_FWD, "no_fwd"}, {PC_NO_PACKET_IN, "no_packet_in"}}
+
+var portFeatureNames = []flagName{{PF_10MB_HD, "10mb_hd"},
+	{PF_10MB_FD, "10mb_fd"}, {PF_100MB_HD, "100mb_hd"},
+	{PF_100MB_FD, "100mb_fd"}, {PF_1GB_HD, "1gb_hd"}, {PF_1GB_FD, "1gb_fd"},
+	{PF_10GB_FD, "10gb_fd"}, {PF_COPPER, "copper"}, {PF_FIBER, "fiber"},
+	{PF_AUTONEG, "autoneg"}, {PF_PAUSE, "pause"},
+	{PF_PAUSE_ASYM, "pause_asym"}}
+
+var flowModFlagNames = []flagName{{FF_SEND_FLOW_REM, "send_flow_rem"},
+	{FF_CHECK_OVERLAP, "check_overlap"}, {FF_EMERG, "emerg"}}
+
+var flowModCommands = []string{"add", "modify", "modify_strict", "delete",
+	"delete_strict"}
+
+var flowRemovedReasons = []string{"idle_timeout", "hard_timeout", "delete"}
+
+var portReasons = []string{"add", "delete", "modify"}
+
+var packetInReasons = []string{"no_match", "action"}
+
+var errorTypes = []string{"hello_failed", "bad_request", "bad_action",
+	"flow_mod_failed", "port_mod_failed", "queue_op_failed"}
+
+var statsTypes = []string{"desc", "flow", "aggregate", "table", "port",
+	"queue"}
+
+var fragModes = []string{"normal", "drop", "reasm"}
+
+// Returns names[i], or i if there is no such name.
+func enumString(i int, names []string) string {
+	if i >= 0 && i < len(names) {
+		return names[i]
+	}
+	return strconv.Itoa(i)
+}
+
+func statsTypeString(t uint16) string {
+	if t == StatsType_Vendor {
+		return "vendor"
+	}
+	return enumString(int(t), statsTypes)
+}
+
+func bufferString(id uint32) string {
+	if id == 0xffffffff {
+		return "none"
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Returns the fields of m matched exactly, in the syntax of
+// ovs-ofctl, like in_port=1,dl_type=0x0800,nw_dst=10.0.0.0/24.
+// A match of every packet is "any".
+func (m *Match) String() string {
+	fields := m.fields()
+	if len(fields) == 0 {
+		return "any"
+	}
+	a := make([]string, len(fields))
+	for i, f := range fields {
+		a[i] = f[0] + "=" + f[1]
+	}
+	return strings.Join(a, ",")
+}
+
+// Returns ip with its prefix length, given the number of its
+// wildcarded low bits, or nothing if all of them are.
+func prefixString(ip net.IP, wild uint32) string {
+	if wild >= 32 {
+		return ""
+	}
+	if wild == 0 {
+		return ip.String()
+	}
+	return fmt.Sprintf("%s/%d", ip, 32-wild)
+}
+
+func (a *ActionOutput) String() string {
+	if a.Port == P_CONTROLLER {
+		return fmt.Sprintf("controller:%d", a.MaxLen)
+	}
+	return "output:" + PortName(a.Port)
+}
+
+func (a *ActionEnqueue) String() string {
+	return fmt.Sprintf("enqueue:%s:%d", PortName(a.Port), a.QueueId)
+}
+
+func (a *ActionVLANVID) String() string {
+	return fmt.Sprintf("mod_vlan_vid:%d", a.VLANVID)
+}
+
+func (a *ActionVLANPCP) String() string {
+	return fmt.Sprintf("mod_vlan_pcp:%d", a.VLANPCP)
+}
+
+func (a *ActionStripVLAN) String() string {
+	return "strip_vlan"
+}
+
+func (a *ActionDLAddr) String() string {
+	if a.Type == ActionType_SetDLDst {
+		return "mod_dl_dst:" + a.DLAddr.String()
+	}
+	return "mod_dl_src:" + a.DLAddr.String()
+}
+
+func (a *ActionNWAddr) String() string {
+	if a.Type == ActionType_SetNWDst {
+		return "mod_nw_dst:" + a.NWAddr.String()
+	}
+	return "mod_nw_src:" + a.NWAddr.String()
+}
+
+func (a *ActionNWTOS) String() string {
+	return fmt.Sprintf("mod_nw_tos:%d", a.NWTOS)
+}
+
+func (a *ActionTPPort) String() string {
+	if a.Type == ActionType_SetTPDst {
+		return fmt.Sprintf("mod_tp_dst:%d", a.TPPort)
+	}
+	return fmt.Sprintf("mod_tp_src:%d", a.TPPort)
+}
+
+func (a *ActionVendor) String() string {
+	return fmt.Sprintf("vendor:0x%08x", a.Vendor)
+}
+
+func (a *ActionUnknown) String() string {
+	return fmt.Sprintf("type%d(%d bytes)", a.Type, len(a.Data))
+}
+
+func (a *NXActionLearn) String() string {
+	return fmt.Sprintf("learn(table=%d,priority=%d,idle_timeout=%d,hard_timeout=%d,cookie=0x%x,specs=%d)",
+		a.TableId, a.Priority, a.IdleTimeout, a.HardTimeout, a.Cookie, len(a.Specs))
+}
+
+// Returns actions in the syntax of ovs-ofctl, separated by
+// commas. No actions is "drop".
+func ActionsString(actions []Action) string {
+	if len(actions) == 0 {
+		return "drop"
+	}
+	a := make([]string, len(actions))
+	for i, act := range actions {
+		if s, ok := act.(fmt.Stringer); ok {
+			a[i] = s.String()
+		} else {
+			a[i] = fmt.Sprintf("type%d", act.Header().Type)
+		}
+	}
+	return strings.Join(a, ",")
+}
+
+func (s *FlowStats) String() string {
+	return fmt.Sprintf("table=%d priority=%d cookie=0x%x duration=%d.%03ds "+
+		"idle_timeout=%d hard_timeout=%d n_packets=%d n_bytes=%d %s actions=%s",
+		s.TableId, s.Priority, s.Cookie, s.DurationSec, s.DurationNSec/1000000,
+		s.IdleTimeout, s.HardTimeout, s.PacketCount, s.ByteCount,
+		s.Match.String(), ActionsString(s.Actions))
+}
+
+func (s *FlowStatsRequest) String() string {
+	return fmt.Sprintf("table=%s out_port=%s %s", tableString(s.TableId),
+		PortName(s.OutPort), s.Match.String())
+}
+
+func (s *AggregateStatsRequest) String() string {
+	return fmt.Sprintf("table=%s out_port=%s %s", tableString(s.TableId),
+		PortName(s.OutPort), s.Match.String())
+}
+
+func tableString(id uint8) string {
+	if id == 0xff {
+		return "all"
+	}
+	return strconv.Itoa(int(id))
+}
+
+// Returns b up to its first NUL byte.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (p *PhyPort) String() string {
+	return fmt.Sprintf("%s (%s) addr %s config=%s state=%s curr=%s",
+		PortName(p.PortNo), cString(p.Name), p.HWAddr,
+		flagString(p.Config, portConfigNames), portStateString(p.State),
+		flagString(p.Curr, portFeatureNames))
+}
+
+func portStateString(s uint32) string {
+	a := make([]string, 0, 2)
+	if s&PS_LINK_DOWN != 0 {
+		a = append(a, "link_down")
+	}
+	switch s & PS_STP_MASK {
+	case PS_STP_LEARN:
+		a = append(a, "stp_learn")
+	case PS_STP_FORWARD:
+		a = append(a, "stp_forward")
+	case PS_STP_BLOCK:
+		a = append(a, "stp_block")
+	}
+	if len(a) == 0 {
+		return "0"
+	}
+	return strings.Join(a, "|")
+}
+
+// Returns a summary of frame on one line, with its IPv4 or ARP
+// header.
+func frameString(frame *eth.Ethernet) string {
+	s := fmt.Sprintf("%s > %s type 0x%04x", frame.HWSrc, frame.HWDst, frame.Ethertype)
+	if frame.VLANID.VID != 0 {
+		s += fmt.Sprintf(" vlan %d", frame.VLANID.VID)
+	}
+	switch d := frame.Data.(type) {
+	case *ipv4.IPv4:
+		s += fmt.Sprintf(", ipv4 %s > %s proto %d ttl %d length %d",
+			d.NWSrc, d.NWDst, d.Protocol, d.TTL, d.Length)
+	case *arp.ARP:
+		op := "request"
+		if d.Operation == arp.Type_Reply {
+			op = "reply"
+		}
+		s += fmt.Sprintf(", arp %s %s (%s) > %s (%s)", op, d.IPSrc, d.HWSrc, d.IPDst, d.HWDst)
+	case nil:
+	default:
+		s += fmt.Sprintf(", %d bytes", d.Len())
+	}
+	return s
+}
+
+// Returns a readable rendering of msg over several lines: a line
+// for its header, and one for each of its fields, with matches
+// and actions in the syntax of ovs-ofctl. Messages of other
+// versions only have their type named.
+func Dump(msg util.Message) string {
+	d := new(dumper)
+	d.message(msg)
+	return d.String()
+}
+
+type dumper struct {
+	bytes.Buffer
+}
+
+func (d *dumper) field(name string, format string, args ...interface{}) {
+	fmt.Fprintf(d, "  %s: ", name)
+	fmt.Fprintf(d, format, args...)
+	d.WriteByte('\n')
+}
+
+func (d *dumper) header(h *ofpxx.Header) {
+	fmt.Fprintf(d, "%s v%d xid=%d length=%d\n", TypeName(h.Type), h.Version, h.Xid, h.Length)
+}
+
+func (d *dumper) message(msg util.Message) {
+	switch m := msg.(type) {
+	case *ofpxx.Header:
+		d.header(m)
+	case *ofpxx.Hello:
+		d.header(&m.Header)
+	case *ErrorMsg:
+		d.header(&m.Header)
+		d.field("type", "%s", enumString(int(m.Code), errorTypes))
+		b := m.Data.Bytes()
+		if len(b) >= 2 {
+			d.field("code", "%d", binary.BigEndian.Uint16(b))
+			b = b[2:]
+		}
+		d.field("data", "%d bytes", len(b))
+	case *VendorHeader:
+		d.header(&m.Header)
+		d.field("vendor", "0x%08x", m.Vendor)
+	case *SwitchFeatures:
+		d.header(&m.Header)
+		d.field("dpid", "%s", m.DPID)
+		d.field("buffers", "%d", m.Buffers)
+		d.field("tables", "%d", m.Tables)
+		d.field("capabilities", "%s", flagString(m.Capabilities, capabilityNames))
+		d.field("actions", "0x%08x", m.Actions)
+		for i := range m.Ports {
+			d.field("port", "%s", m.Ports[i].String())
+		}
+	case *SwitchConfig:
+		d.header(&m.Header)
+		d.field("frag", "%s", enumString(int(m.Flags&C_FRAG_MASK), fragModes))
+		d.field("miss_send_len", "%d", m.MissSendLen)
+	case *PacketIn:
+		d.header(&m.Header)
+		d.field("buffer_id", "%s", bufferString(m.BufferId))
+		d.field("total_len", "%d", m.TotalLen)
+		d.field("in_port", "%s", PortName(m.InPort))
+		d.field("reason", "%s", enumString(int(m.Reason), packetInReasons))
+		d.field("frame", "%s", frameString(&m.Data))
+	case *PacketOut:
+		d.header(&m.Header)
+		d.field("buffer_id", "%s", bufferString(m.BufferId))
+		d.field("in_port", "%s", PortName(m.InPort))
+		d.field("actions", "%s", ActionsString(m.Actions))
+		switch data := m.Data.(type) {
+		case *eth.Ethernet:
+			d.field("frame", "%s", frameString(data))
+		case nil:
+		default:
+			d.field("data", "%d bytes", data.Len())
+		}
+	case *FlowMod:
+		d.header(&m.Header)
+		d.field("command", "%s", enumString(int(m.Command), flowModCommands))
+		d.field("match", "%s", m.Match.String())
+		d.field("cookie", "0x%x", m.Cookie)
+		d.field("priority", "%d", m.Priority)
+		d.field("idle_timeout", "%d", m.IdleTimeout)
+		d.field("hard_timeout", "%d", m.HardTimeout)
+		d.field("buffer_id", "%s", bufferString(m.BufferId))
+		d.field("out_port", "%s", PortName(m.OutPort))
+		d.field("flags", "%s", flagString(uint32(m.Flags), flowModFlagNames))
+		d.field("actions", "%s", ActionsString(m.Actions))
+	case *FlowRemoved:
+		d.header(&m.Header)
+		d.field("match", "%s", m.Match.String())
+		d.field("cookie", "0x%x", m.Cookie)
+		d.field("priority", "%d", m.Priority)
+		d.field("reason", "%s", enumString(int(m.Reason), flowRemovedReasons))
+		d.field("duration", "%d.%03ds", m.DurationSec, m.DurationNSec/1000000)
+		d.field("idle_timeout", "%d", m.IdleTimeout)
+		d.field("n_packets", "%d", m.PacketCount)
+		d.field("n_bytes", "%d", m.ByteCount)
+	case *PortStatus:
+		d.header(&m.Header)
+		d.field("reason", "%s", enumString(int(m.Reason), portReasons))
+		d.field("port", "%s", m.Desc.String())
+	case *PortMod:
+		d.header(&m.Header)
+		d.field("port", "%s", PortName(m.PortNo))
+		d.field("hw_addr", "%s", net.HardwareAddr(m.HWAddr))
+		d.field("config", "%s", flagString(m.Config, portConfigNames))
+		d.field("mask", "%s", flagString(m.Mask, portConfigNames))
+		d.field("advertise", "%s", flagString(m.Advertise, portFeatureNames))
+	case *StatsRequest:
+		d.header(&m.Header)
+		d.field("type", "%s", statsTypeString(m.Type))
+		d.field("flags", "0x%x", m.Flags)
+		d.statsBody(m.Body)
+	case *StatsReply:
+		d.header(&m.Header)
+		d.field("type", "%s", statsTypeString(m.Type))
+		d.field("flags", "0x%x", m.Flags)
+		d.statsBody(m.Body)
+	case nil:
+		d.WriteString("nil\n")
+	default:
+		fmt.Fprintf(d, "%T\n", msg)
+	}
+}
+
+func (d *dumper) statsBody(body util.Message) {
+	switch b := body.(type) {
+	case *FlowStatsRequest:
+		d.field("request", "%s", b.String())
+	case *AggregateStatsRequest:
+		d.field("request", "%s", b.String())
+	case *PortStatsRequest:
+		d.field("port", "%s", PortName(b.PortNo))
+	case *QueueStatsRequest:
+		d.field("port", "%s", PortName(b.PortNo))
+		d.field("queue", "%d", b.QueueId)
+	case *DescStats:
+		d.field("mfr_desc", "%s", cString(b.MfrDesc))
+		d.field("hw_desc", "%s", cString(b.HWDesc))
+		d.field("sw_desc", "%s", cString(b.SWDesc))
+		d.field("serial_num", "%s", cString(b.SerialNum))
+		d.field("dp_desc", "%s", cString(b.DPDesc))
+	case *FlowStatsReply:
+		for i := range b.Flows {
+			d.field("flow", "%s", b.Flows[i].String())
+		}
+	case *AggregateStats:
+		d.field("n_packets", "%d", b.PacketCount)
+		d.field("n_bytes", "%d", b.ByteCount)
+		d.field("n_flows", "%d", b.FlowCount)
+	case *TableStats:
+		d.field("table", "%d (%s) wildcards=0x%x max_entries=%d active=%d lookups=%d matched=%d",
+			b.TableId, cString(b.Name), b.Wildcards, b.MaxEntries, b.ActiveCount,
+			b.LookupCount, b.MatchedCount)
+	case *PortStats:
+		d.field("port", "%s rx_packets=%d tx_packets=%d rx_bytes=%d tx_bytes=%d "+
+			"rx_dropped=%d tx_dropped=%d rx_errors=%d tx_errors=%d",
+			PortName(b.PortNo), b.RxPackets, b.TxPackets, b.RxBytes, b.TxBytes,
+			b.RxDropped, b.TxDropped, b.RxErrors, b.TxErrors)
+	case *QueueStats:
+		d.field("queue", "port %s queue %d tx_packets=%d tx_bytes=%d tx_errors=%d",
+			PortName(b.PortNo), b.QueueId, b.TxPackets, b.TxBytes, b.TxErrors)
+	case nil:
+	default:
+		d.field("body", "%d bytes", b.Len())
+	}
+}
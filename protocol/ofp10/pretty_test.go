@@ -0,0 +1,64 @@
+package ofp10
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMatchString(t *testing.T) {
+	m := NewMatch()
+	if s := m.String(); s != "any" {
+		t.Errorf("Got %q for a match of every packet.", s)
+	}
+	m.InPort = P_LOCAL
+	m.DLType = 0x0800
+	m.NWSrc = net.IPv4(10, 0, 0, 1).To4()
+	m.NWDst = net.IPv4(10, 1, 0, 0).To4()
+	m.Wildcards = FW_ALL&^(FW_IN_PORT|FW_DL_TYPE|FW_NW_SRC_MASK|FW_NW_DST_MASK) |
+		16<<FW_NW_DST_SHIFT
+	exp := "in_port=local,dl_type=0x0800,nw_src=10.0.0.1,nw_dst=10.1.0.0/16"
+	if s := m.String(); s != exp {
+		t.Errorf("Got %q, expected %q.", s, exp)
+	}
+}
+
+func TestDumpFlowMod(t *testing.T) {
+	f := NewFlowMod()
+	f.Command = FC_MODIFY_STRICT
+	f.Priority = 100
+	f.Match.Wildcards = FW_ALL &^ FW_IN_PORT
+	f.Match.InPort = 3
+	f.AddAction(NewActionDLDst(net.HardwareAddr{0, 0, 0, 0, 0, 1}))
+	f.AddAction(NewActionOutput(P_FLOOD))
+	f.Header.Length = f.Len()
+	data, _ := f.MarshalBinary()
+
+	msg, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Dump(msg)
+	for _, line := range []string{
+		"OFPT_FLOW_MOD v1 ",
+		"  command: modify_strict\n",
+		"  match: in_port=3\n",
+		"  actions: mod_dl_dst:00:00:00:00:00:01,output:flood\n",
+	} {
+		if !strings.Contains(d, line) {
+			t.Errorf("Dump lacks %q:\n%s", line, d)
+		}
+	}
+}
+
+func TestDecodeUnknownAction(t *testing.T) {
+	data := []byte{0, 0xfe, 0, 8, 1, 2, 3, 4, 0, 0, 0, 8, 0, 1, 0, 0}
+	a := DecodeAction(data)
+	u, ok := a.(*ActionUnknown)
+	if !ok || u.Type != 0xfe || u.Len() != 8 {
+		t.Fatalf("Got action %+v.", a)
+	}
+	if o, ok := DecodeAction(data[u.Len():]).(*ActionOutput); !ok || o.Port != 1 {
+		t.Errorf("Couldn't decode the action after an unknown one.")
+	}
+}
@@ -2,6 +2,7 @@ package ofp10
 
 import (
 	"encoding/binary"
+	"errors"
 
 	"github.com/jonstout/ogo/protocol/ofpxx"
 	"github.com/jonstout/ogo/protocol/util"
@@ -15,6 +16,21 @@ type StatsRequest struct {
 	Body   util.Message
 }
 
+// Returns a stats request of type t. body may be nil for types
+// without a request body.
+func NewStatsRequest(t uint16, body util.Message) *StatsRequest {
+	s := new(StatsRequest)
+	s.Header = ofpxx.NewOfp10Header()
+	s.Header.Type = Type_StatsRequest
+	s.Type = t
+	s.Body = body
+	if body == nil {
+		s.Body = new(util.Buffer)
+	}
+	s.Header.Length = s.Len()
+	return s
+}
+
 func (s *StatsRequest) Len() (n uint16) {
 	return s.Header.Len() + 4 + s.Body.Len()
 }
@@ -108,28 +124,33 @@ func (s *StatsReply) UnmarshalBinary(data []byte) error {
 	s.Flags = binary.BigEndian.Uint16(data[n:])
 	n += 2
 
+	// Bodies that are arrays, except flow stats, only have
+	// their first element decoded.
 	var req util.Message
 	switch s.Type {
 	case StatsType_Aggregate:
-		req = s.Body.(*AggregateStats)
+		req = NewAggregateStats()
 	case StatsType_Desc:
-		req = s.Body.(*DescStats)
+		req = NewDescStats()
 	case StatsType_Flow:
-		// Array
-		req = s.Body.(*FlowStats)
+		req = new(FlowStatsReply)
 	case StatsType_Port:
-		req = s.Body.(*PortStats)
+		req = NewPortStats()
 	case StatsType_Table:
-		// Array
-		req = s.Body.(*TableStats)
+		req = NewTableStats()
 	case StatsType_Queue:
-		// Array
-		req = s.Body.(*QueueStats)
-	case StatsType_Vendor:
-		// Array of Group Stats
-		break
+		req = NewQueueStats()
+	default:
+		req = new(util.Buffer)
+	}
+	if int(n) >= len(data) {
+		s.Body = new(util.Buffer)
+		return err
+	}
+	s.Body = req
+	if e := req.UnmarshalBinary(data[n:]); e != nil {
+		err = e
 	}
-	err = req.UnmarshalBinary(data[n:])
 	return err
 }
 
@@ -251,7 +272,7 @@ func (s *FlowStatsRequest) MarshalBinary() (data []byte, err error) {
 	n += 1
 	b[n] = s.pad
 	n += 1
-	binary.BigEndian.PutUint16(data[n:], s.OutPort)
+	binary.BigEndian.PutUint16(b[n:], s.OutPort)
 	n += 2
 	data = append(data, b...)
 	return
@@ -314,7 +335,7 @@ func (s *FlowStats) MarshalBinary() (data []byte, err error) {
 	data[n] = s.pad
 	n += 1
 	b, err := s.Match.MarshalBinary()
-	data = append(data, b...)
+	copy(data[n:], b)
 	n += len(b)
 	binary.BigEndian.PutUint32(data[n:], s.DurationSec)
 	n += 4
@@ -337,7 +358,7 @@ func (s *FlowStats) MarshalBinary() (data []byte, err error) {
 
 	for _, a := range s.Actions {
 		b, err = a.MarshalBinary()
-		data = append(data, b...)
+		copy(data[n:], b)
 		n += len(b)
 	}
 	return
@@ -401,6 +422,11 @@ func (s *FlowStats) UnmarshalBinary(data []byte) error {
 			a = NewActionEnqueue(0, 0)
 		case ActionType_Vendor:
 			a = NewActionVendor(0)
+		default:
+			return errors.New("Unknown action type in FlowStats.")
+		}
+		if e := a.UnmarshalBinary(data[n:]); e != nil {
+			return e
 		}
 		s.Actions = append(s.Actions, a)
 		n += int(a.Len())
@@ -408,6 +434,47 @@ func (s *FlowStats) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// The body of a flow stats reply, an array of ofp_flow_stats
+// 1.0.
+type FlowStatsReply struct {
+	Flows []FlowStats
+}
+
+func (r *FlowStatsReply) Len() (n uint16) {
+	for i := range r.Flows {
+		n += r.Flows[i].Len()
+	}
+	return
+}
+
+func (r *FlowStatsReply) MarshalBinary() (data []byte, err error) {
+	for i := range r.Flows {
+		b, err := r.Flows[i].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (r *FlowStatsReply) UnmarshalBinary(data []byte) error {
+	r.Flows = make([]FlowStats, 0)
+	for n := 0; n+2 <= len(data); {
+		length := int(binary.BigEndian.Uint16(data[n:]))
+		if length < 88 || n+length > len(data) {
+			return errors.New("The []byte is too short to unmarshal a full FlowStats.")
+		}
+		f := NewFlowStats()
+		if err := f.UnmarshalBinary(data[n : n+length]); err != nil {
+			return err
+		}
+		r.Flows = append(r.Flows, *f)
+		n += length
+	}
+	return nil
+}
+
 // ofp_aggregate_stats_request 1.0
 type AggregateStatsRequest struct {
 	Match
@@ -417,7 +484,9 @@ type AggregateStatsRequest struct {
 }
 
 func NewAggregateStatsRequest() *AggregateStatsRequest {
-	return new(AggregateStatsRequest)
+	s := new(AggregateStatsRequest)
+	s.Match = *NewMatch()
+	return s
 }
 
 func (s *AggregateStatsRequest) Len() (n uint16) {
@@ -433,7 +502,7 @@ func (s *AggregateStatsRequest) MarshalBinary() (data []byte, err error) {
 	n += 1
 	b[n] = s.pad
 	n += 1
-	binary.BigEndian.PutUint16(data[n:], s.OutPort)
+	binary.BigEndian.PutUint16(b[n:], s.OutPort)
 	n += 2
 	data = append(data, b...)
 	return
@@ -745,6 +814,12 @@ type QueueStats struct {
 	TxErrors  uint64
 }
 
+func NewQueueStats() *QueueStats {
+	q := new(QueueStats)
+	q.pad = make([]byte, 2)
+	return q
+}
+
 func (s *QueueStats) Len() (n uint16) {
 	return 32
 }
@@ -796,6 +871,7 @@ type PortStatus struct {
 func NewPortStatus() *PortStatus {
 	p := new(PortStatus)
 	p.Header = ofpxx.NewOfp10Header()
+	p.Header.Type = Type_PortStatus
 	p.pad = make([]byte, 7)
 	return p
 }
@@ -829,6 +905,7 @@ func (s *PortStatus) UnmarshalBinary(data []byte) error {
 	
 	s.Reason = data[n]
 	n += 1
+	s.pad = make([]byte, 7)
 	copy(s.pad, data[n:])
 	n += len(s.pad)
 
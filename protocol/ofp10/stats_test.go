@@ -0,0 +1,40 @@
+package ofp10
+
+import (
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func TestFlowStatsReplyUnmarshalBinary(t *testing.T) {
+	f := NewFlowStats()
+	f.Priority = 100
+	f.Cookie = 7
+	f.Actions = []Action{NewActionOutput(2)}
+	f.Length = f.Len()
+
+	rep := &StatsReply{ofpxx.NewOfp10Header(), StatsType_Flow, 0, &FlowStatsReply{[]FlowStats{*f, *f}}}
+	rep.Header.Type = Type_StatsReply
+	rep.Header.Length = rep.Len()
+	data, _ := rep.MarshalBinary()
+	if len(data) != 12+2*96 {
+		t.Fatalf("Got %d bytes, expected %d.", len(data), 12+2*96)
+	}
+
+	r := new(StatsReply)
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	body, ok := r.Body.(*FlowStatsReply)
+	if !ok || len(body.Flows) != 2 {
+		t.Fatalf("Got body %+v.", r.Body)
+	}
+	for _, g := range body.Flows {
+		if g.Priority != 100 || g.Cookie != 7 || len(g.Actions) != 1 {
+			t.Errorf("Got flow %+v.", g)
+		}
+		if o, ok := g.Actions[0].(*ActionOutput); !ok || o.Port != 2 {
+			t.Errorf("Got action %+v.", g.Actions[0])
+		}
+	}
+}
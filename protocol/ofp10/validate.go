@@ -0,0 +1,181 @@
+package ofp10
+
+import (
+	"fmt"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Checks msg, a message about to be sent to a switch, against the
+// OpenFlow 1.0 specification. Returns a *ofpxx.ValidationError
+// describing the first problem found, so applications learn of
+// their mistakes before the switch answers with an error message.
+// Flow mods and packet-outs are checked field by field, other
+// messages only for their header.
+func Validate(msg util.Message) error {
+	name := "message"
+	switch m := msg.(type) {
+	case *FlowMod:
+		name = "flow mod"
+		if err := validateFlowMod(m); err != nil {
+			return err
+		}
+	case *PacketOut:
+		name = "packet-out"
+		if err := validatePacketOut(m); err != nil {
+			return err
+		}
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := ofpxx.ValidateHeader(name, data, VERSION); err != nil {
+		return err
+	}
+	if data[1] > Type_QueueGetConfigReply {
+		return ofpxx.Invalid(name, "unknown message type %d", data[1])
+	}
+	return nil
+}
+
+func validateFlowMod(f *FlowMod) error {
+	if f.Command > FC_DELETE_STRICT {
+		return ofpxx.Invalid("flow mod", "unknown command %d", f.Command)
+	}
+	if f.Flags&^(FF_SEND_FLOW_REM|FF_CHECK_OVERLAP|FF_EMERG) != 0 {
+		return ofpxx.Invalid("flow mod", "unknown flags %#x", f.Flags)
+	}
+	if f.Flags&FF_EMERG != 0 && (f.IdleTimeout != 0 || f.HardTimeout != 0) {
+		return ofpxx.Invalid("flow mod", "emergency flows can't time out")
+	}
+	if reason := validateMatch(&f.Match); reason != "" {
+		return ofpxx.Invalid("flow mod", "%s", reason)
+	}
+	if f.Command == FC_DELETE || f.Command == FC_DELETE_STRICT {
+		return nil
+	}
+	return validateActions("flow mod", f.Actions, &f.Match)
+}
+
+func validatePacketOut(p *PacketOut) error {
+	// Len and MarshalBinary need Data, so buffered packets are
+	// sent with an empty one.
+	if p.Data == nil {
+		return ofpxx.Invalid("packet-out", "Data is nil")
+	}
+	if p.BufferId == 0xffffffff && p.Data.Len() == 0 {
+		return ofpxx.Invalid("packet-out", "no buffer and no packet")
+	}
+	n := 0
+	for _, a := range p.Actions {
+		n += int(a.Len())
+	}
+	if n != int(p.ActionsLen) {
+		return ofpxx.Invalid("packet-out", "actions_len is %d but the actions take %d bytes", p.ActionsLen, n)
+	}
+	if p.InPort >= P_MAX && p.InPort != P_CONTROLLER && p.InPort != P_LOCAL && p.InPort != P_NONE {
+		return ofpxx.Invalid("packet-out", "in_port %#x", p.InPort)
+	}
+	return validateActions("packet-out", p.Actions, nil)
+}
+
+// Returns why m is inconsistent, or "". Fields that are matched
+// must be valid and the fields they depend on matched too: network
+// fields need an IPv4 or ARP ethertype and transport ports need
+// TCP, UDP or ICMP.
+func validateMatch(m *Match) string {
+	w := m.Wildcards
+	if w&FW_DL_VLAN == 0 && m.DLVLAN > 0xfff && m.DLVLAN != 0xffff {
+		return fmt.Sprintf("the match has VLAN %d", m.DLVLAN)
+	}
+	if w&FW_DL_VLAN_PCP == 0 && m.DLVLANPcp > 7 {
+		return fmt.Sprintf("the match has VLAN priority %d", m.DLVLANPcp)
+	}
+	if w&FW_NW_TOS == 0 && m.NWTos&3 != 0 {
+		return "the match has ToS bits outside the DSCP field"
+	}
+	ip := w&FW_DL_TYPE == 0 && m.DLType == 0x0800
+	arp := w&FW_DL_TYPE == 0 && m.DLType == 0x0806
+	network := w&(FW_NW_TOS|FW_NW_PROTO) != FW_NW_TOS|FW_NW_PROTO ||
+		(w&FW_NW_SRC_MASK)>>FW_NW_SRC_SHIFT < 32 || (w&FW_NW_DST_MASK)>>FW_NW_DST_SHIFT < 32
+	if network && !ip && !arp {
+		return "the match has network fields but not an IPv4 or ARP ethertype"
+	}
+	if w&(FW_TP_SRC|FW_TP_DST) != FW_TP_SRC|FW_TP_DST {
+		transport := w&FW_NW_PROTO == 0 && (m.NWProto == 1 || m.NWProto == 6 || m.NWProto == 17)
+		if !ip || !transport {
+			return "the match has transport ports but not TCP, UDP or ICMP"
+		}
+	}
+	return ""
+}
+
+// Checks actions, those of a message named message. match is the
+// match of the flow they belong to, nil for a packet-out.
+func validateActions(message string, actions []Action, match *Match) error {
+	for i, a := range actions {
+		h := a.Header()
+		if h.Length != a.Len() {
+			return ofpxx.Invalid(message, "action %d has length %d but takes %d bytes", i, h.Length, a.Len())
+		}
+		if a.Len()%8 != 0 {
+			return ofpxx.Invalid(message, "action %d is %d bytes long, not a multiple of 8", i, a.Len())
+		}
+		if reason := validateAction(a, match); reason != "" {
+			return ofpxx.Invalid(message, "action %d %s", i, reason)
+		}
+	}
+	return nil
+}
+
+// Returns why action a is invalid, or "".
+func validateAction(a Action, match *Match) string {
+	switch t := a.(type) {
+	case *ActionOutput:
+		return validateOutput(t.Port, match)
+	case *ActionEnqueue:
+		return validateOutput(t.Port, match)
+	case *ActionVLANVID:
+		if t.VLANVID > 0xfff {
+			return fmt.Sprintf("sets VLAN %d", t.VLANVID)
+		}
+	case *ActionVLANPCP:
+		if t.VLANPCP > 7 {
+			return fmt.Sprintf("sets VLAN priority %d", t.VLANPCP)
+		}
+	case *ActionNWTOS:
+		if t.NWTOS&3 != 0 {
+			return "sets ToS bits outside the DSCP field"
+		}
+	case *ActionNWAddr:
+		if match != nil && (match.Wildcards&FW_DL_TYPE != 0 || match.DLType != 0x0800) {
+			return "sets an IP address but the match isn't for IPv4"
+		}
+	case *ActionTPPort:
+		if match != nil && (match.Wildcards&FW_NW_PROTO != 0 || (match.NWProto != 6 && match.NWProto != 17)) {
+			return "sets a transport port but the match isn't for TCP or UDP"
+		}
+	case *ActionUnknown:
+		return fmt.Sprintf("has unknown type %d", t.Type)
+	}
+	return ""
+}
+
+// Returns why sending packets out port is invalid, or "". match is
+// that of the flow sending them, nil for a packet-out.
+func validateOutput(port uint16, match *Match) string {
+	switch {
+	case port == 0 || port == P_NONE:
+		return fmt.Sprintf("outputs to port %d", port)
+	case port > P_MAX && port < P_IN_PORT:
+		return fmt.Sprintf("outputs to reserved port %d", port)
+	case port == P_TABLE && match != nil:
+		return "outputs to the flow table outside a packet-out"
+	case match != nil && match.Wildcards&FW_IN_PORT == 0 && port == match.InPort:
+		// Switches drop such packets.
+		return "outputs to the input port, which needs P_IN_PORT"
+	}
+	return ""
+}
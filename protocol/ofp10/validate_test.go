@@ -0,0 +1,61 @@
+package ofp10
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+func TestValidateFlowMod(t *testing.T) {
+	f := NewFlowMod()
+	f.Match.Wildcards = FW_ALL &^ (FW_IN_PORT | FW_DL_TYPE | FW_NW_PROTO | FW_TP_DST)
+	f.Match.InPort = 1
+	f.Match.DLType = 0x0800
+	f.Match.NWProto = 6
+	f.Match.TPDst = 80
+	f.AddAction(NewActionTPDst(8080))
+	f.AddAction(NewActionOutput(2))
+	if err := Validate(f); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		change func(f *FlowMod)
+		reason string
+	}{
+		{func(f *FlowMod) { f.Command = 7 }, "unknown command"},
+		{func(f *FlowMod) { f.Match.Wildcards |= FW_NW_PROTO }, "transport ports"},
+		{func(f *FlowMod) { f.Match.Wildcards |= FW_DL_TYPE }, "network fields"},
+		{func(f *FlowMod) { f.Actions[1] = NewActionOutput(1) }, "input port"},
+		{func(f *FlowMod) { f.Actions[1] = NewActionOutput(P_TABLE) }, "flow table"},
+		{func(f *FlowMod) { f.Actions[0].Header().Length = 4 }, "action 0 has length 4"},
+		{func(f *FlowMod) { f.Flags = FF_EMERG; f.IdleTimeout = 5 }, "time out"},
+	}
+	for _, test := range tests {
+		g := *f
+		g.Actions = []Action{NewActionTPDst(8080), NewActionOutput(2)}
+		test.change(&g)
+		err := Validate(&g)
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("Got %v, expected an error about %q.", err, test.reason)
+		}
+	}
+}
+
+func TestValidatePacketOut(t *testing.T) {
+	p := NewPacketOut()
+	p.Data = new(util.Buffer)
+	p.AddAction(NewActionOutput(P_TABLE))
+	if err := Validate(p); err == nil || !strings.Contains(err.Error(), "no buffer and no packet") {
+		t.Errorf("Got %v for a packet-out without packet.", err)
+	}
+	p.BufferId = 1
+	if err := Validate(p); err != nil {
+		t.Error(err)
+	}
+	p.ActionsLen = 0
+	if err := Validate(p); err == nil {
+		t.Error("A packet-out with a bad actions_len was valid.")
+	}
+}
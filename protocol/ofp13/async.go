@@ -0,0 +1,71 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Asynchronous message configuration. Each mask is a bitmap of
+// reasons, e.g. 1 << R_NO_MATCH. Index 0 of each mask applies
+// when the controller has the master or equal role, index 1
+// when it has the slave role.
+// ofp_async_config 1.3
+type AsyncConfig struct {
+	ofpxx.Header
+	PacketInMask    [2]uint32
+	PortStatusMask  [2]uint32
+	FlowRemovedMask [2]uint32
+}
+
+func NewSetAsync() *AsyncConfig {
+	a := new(AsyncConfig)
+	a.Header = ofpxx.NewOfp13Header()
+	a.Header.Type = Type_SetAsync
+	return a
+}
+
+func NewGetAsyncRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp13Header()
+	h.Type = Type_GetAsyncRequest
+	return &h
+}
+
+func (a *AsyncConfig) Len() (n uint16) {
+	return a.Header.Len() + 24
+}
+
+func (a *AsyncConfig) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(a.Len()))
+	next := 0
+
+	a.Header.Length = a.Len()
+	bytes, err := a.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	for _, mask := range [][2]uint32{a.PacketInMask, a.PortStatusMask, a.FlowRemovedMask} {
+		binary.BigEndian.PutUint32(data[next:], mask[0])
+		next += 4
+		binary.BigEndian.PutUint32(data[next:], mask[1])
+		next += 4
+	}
+	return
+}
+
+func (a *AsyncConfig) UnmarshalBinary(data []byte) error {
+	if len(data) < int(a.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"AsyncConfig message.")
+	}
+	next := 0
+	err := a.Header.UnmarshalBinary(data[next:])
+	next += int(a.Header.Len())
+	for _, mask := range []*[2]uint32{&a.PacketInMask, &a.PortStatusMask, &a.FlowRemovedMask} {
+		mask[0] = binary.BigEndian.Uint32(data[next:])
+		next += 4
+		mask[1] = binary.BigEndian.Uint32(data[next:])
+		next += 4
+	}
+	return err
+}
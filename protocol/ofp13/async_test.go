@@ -0,0 +1,49 @@
+package ofp13
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAsyncConfigMarshalBinary(t *testing.T) {
+	b := "   04 1c 00 20 00 00 00 00" + // Header
+		"00 00 00 03 00 00 00 00" + // Packet-in master, slave
+		"00 00 00 07 00 00 00 07" + // Port status master, slave
+		"00 00 00 0f 00 00 00 00" // Flow removed master, slave
+	b = strings.Replace(b, " ", "", -1)
+
+	a := NewSetAsync()
+	a.Header.Xid = 0
+	a.PacketInMask = [2]uint32{1<<R_NO_MATCH | 1<<R_ACTION, 0}
+	a.PortStatusMask = [2]uint32{7, 7}
+	a.FlowRemovedMask = [2]uint32{0xf, 0}
+	data, _ := a.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestParseGetAsyncReply(t *testing.T) {
+	b := "   04 1b 00 20 00 00 00 09" + // Header
+		"00 00 00 01 00 00 00 00" + // Packet-in master, slave
+		"00 00 00 07 00 00 00 07" + // Port status master, slave
+		"00 00 00 03 00 00 00 00" // Flow removed master, slave
+	b = strings.Replace(b, " ", "", -1)
+
+	bytes, _ := hex.DecodeString(b)
+	msg, err := Parse(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := msg.(*AsyncConfig)
+	if !ok {
+		t.Fatalf("Parsed a get async reply as %T.", msg)
+	}
+	if a.PacketInMask != [2]uint32{1, 0} || a.PortStatusMask != [2]uint32{7, 7} || a.FlowRemovedMask != [2]uint32{3, 0} {
+		t.Errorf("Got masks %v %v %v.", a.PacketInMask, a.PortStatusMask, a.FlowRemovedMask)
+	}
+}
@@ -0,0 +1,1364 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Messages are encoded in JSON like those of ofp10: an object
+// with their version, type and xid, like
+//
+//	{"version": 4, "type": "flow_mod", "xid": 7, "command": "add",
+//		"table_id": 0, "priority": 100,
+//		"match": {"eth_type": "0x0800", "in_port": "1", "ipv4_dst": "10.0.0.0/24"},
+//		"out_port": "any", "flags": ["send_flow_rem"],
+//		"instructions": [{"apply_actions": ["set_field:eth_dst=02:00:00:00:00:01", "output:2"]},
+//			{"goto_table": 1}]}
+//
+// Matches map the names of OXM basic fields to their values:
+// addresses as such, ports as numbers or the names of reserved
+// ports, ethertypes, VLAN ids, metadata and other bit fields in
+// hex, and the rest in decimal. Masked values are value/mask, or
+// address/prefix length. Fields of other OXM classes are keyed by
+// their OXM header in hex and given as hex. Actions are strings
+// like output:<port>, controller:<max_len>, group:<id>,
+// set_queue:<id>, push_vlan:<ethertype>, pop_vlan,
+// set_field:<field>=<value>, or raw:<hex> for the rest. Each
+// instruction is an object with a single key: goto_table,
+// write_metadata (value/mask), apply_actions, write_actions,
+// clear_actions, meter, or raw. Masks of async configurations
+// are the names of the reasons they let through,
+//
+//	{"version": 4, "type": "set_async", "xid": 3,
+//		"packet_in_mask": {"master": ["no_match", "action"], "slave": []}, ...}
+//
+// The messages of package ofp14 that OpenFlow 1.3 shares (error,
+// features reply, switch config, packet-in, flow, group, port
+// and meter mods) are encoded by EncodeJSON and decoded with the
+// version set to 1.3, as are role and async configuration
+// messages. Multipart messages, and the OpenFlow 1.3 messages
+// this package has no type for (flow removed, port status,
+// packet out, table mod and queue config), have no encoding yet.
+
+var typeNames = map[uint8]string{
+	Type_Hello:                 "hello",
+	Type_Error:                 "error",
+	Type_EchoRequest:           "echo_request",
+	Type_EchoReply:             "echo_reply",
+	Type_Experimenter:          "experimenter",
+	Type_FeaturesRequest:       "features_request",
+	Type_FeaturesReply:         "features_reply",
+	Type_GetConfigRequest:      "get_config_request",
+	Type_GetConfigReply:        "get_config_reply",
+	Type_SetConfig:             "set_config",
+	Type_PacketIn:              "packet_in",
+	Type_FlowRemoved:           "flow_removed",
+	Type_PortStatus:            "port_status",
+	Type_PacketOut:             "packet_out",
+	Type_FlowMod:               "flow_mod",
+	Type_GroupMod:              "group_mod",
+	Type_PortMod:               "port_mod",
+	Type_TableMod:              "table_mod",
+	Type_MultipartRequest:      "multipart_request",
+	Type_MultipartReply:        "multipart_reply",
+	Type_BarrierRequest:        "barrier_request",
+	Type_BarrierReply:          "barrier_reply",
+	Type_QueueGetConfigRequest: "queue_get_config_request",
+	Type_QueueGetConfigReply:   "queue_get_config_reply",
+	Type_RoleRequest:           "role_request",
+	Type_RoleReply:             "role_reply",
+	Type_GetAsyncRequest:       "get_async_request",
+	Type_GetAsyncReply:         "get_async_reply",
+	Type_SetAsync:              "set_async",
+	Type_MeterMod:              "meter_mod",
+}
+
+var packetInReasons = []string{"no_match", "action", "invalid_ttl"}
+var portReasons = []string{"add", "delete", "modify"}
+var flowRemovedReasons = []string{"idle_timeout", "hard_timeout", "delete", "group_delete"}
+
+var errorTypes = []string{"hello_failed", "bad_request", "bad_action",
+	"bad_instruction", "bad_match", "flow_mod_failed", "group_mod_failed",
+	"port_mod_failed", "table_mod_failed", "queue_op_failed",
+	"switch_config_failed", "role_request_failed", "meter_mod_failed",
+	"table_features_failed"}
+var fragModes = []string{"normal", "drop", "reasm"}
+var flowModCommands = []string{"add", "modify", "modify_strict", "delete", "delete_strict"}
+var groupModCommands = []string{"add", "modify", "delete"}
+var groupTypes = []string{"all", "select", "indirect", "ff"}
+var meterModCommands = []string{"add", "modify", "delete"}
+var meterBandTypes = []string{"", "drop", "dscp_remark"}
+var roles = []string{"nochange", "equal", "master", "slave"}
+
+type flagName struct {
+	bit  uint32
+	name string
+}
+
+var capabilityNames = []flagName{{ofp14.C_FLOW_STATS, "flow_stats"},
+	{ofp14.C_TABLE_STATS, "table_stats"}, {ofp14.C_PORT_STATS, "port_stats"},
+	{ofp14.C_GROUP_STATS, "group_stats"}, {ofp14.C_IP_REASM, "ip_reasm"},
+	{ofp14.C_QUEUE_STATS, "queue_stats"}, {ofp14.C_PORT_BLOCKED, "port_blocked"}}
+var flowModFlagNames = []flagName{{ofp14.FF_SEND_FLOW_REM, "send_flow_rem"},
+	{ofp14.FF_CHECK_OVERLAP, "check_overlap"}, {ofp14.FF_RESET_COUNTS, "reset_counts"},
+	{ofp14.FF_NO_PKT_COUNTS, "no_pkt_counts"}, {ofp14.FF_NO_BYT_COUNTS, "no_byt_counts"}}
+var portConfigNames = []flagName{{ofp14.PC_PORT_DOWN, "port_down"},
+	{ofp14.PC_NO_RECV, "no_recv"}, {ofp14.PC_NO_FWD, "no_fwd"},
+	{ofp14.PC_NO_PACKET_IN, "no_packet_in"}}
+var meterFlagNames = []flagName{{ofp14.MF_KBPS, "kbps"}, {ofp14.MF_PKTPS, "pktps"},
+	{ofp14.MF_BURST, "burst"}, {ofp14.MF_STATS, "stats"}}
+
+var portNames = map[uint32]string{
+	ofp14.P_IN_PORT:    "in_port",
+	ofp14.P_TABLE:      "table",
+	ofp14.P_NORMAL:     "normal",
+	ofp14.P_FLOOD:      "flood",
+	ofp14.P_ALL:        "all",
+	ofp14.P_CONTROLLER: "controller",
+	ofp14.P_LOCAL:      "local",
+	ofp14.P_ANY:        "any",
+}
+
+type jsonHeader struct {
+	Version uint8  `json:"version"`
+	Type    string `json:"type"`
+	Xid     uint32 `json:"xid"`
+}
+
+func newJSONHeader(h ofpxx.Header) jsonHeader {
+	name, ok := typeNames[h.Type]
+	if !ok {
+		name = strconv.Itoa(int(h.Type))
+	}
+	return jsonHeader{h.Version, name, h.Xid}
+}
+
+// Sets the version and xid of h, the header of a message of
+// package ofp14, from j.
+func (j jsonHeader) set(h *ofpxx.Header) {
+	h.Version, h.Xid = VERSION, j.Xid
+}
+
+func enumString(i int, names []string) string {
+	if i >= 0 && i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return strconv.Itoa(i)
+}
+
+// Parses the name of an enum, or its number.
+func parseEnum(s string, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && name == s {
+			return i, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown value %q.", s)
+	}
+	return int(n), nil
+}
+
+// Returns the names of the flags set in v, and any unnamed bits
+// in hex.
+func flagsJSON(v uint32, names []flagName) []string {
+	a := make([]string, 0)
+	for _, f := range names {
+		if v&f.bit != 0 {
+			a = append(a, f.name)
+			v &^= f.bit
+		}
+	}
+	if v != 0 {
+		a = append(a, fmt.Sprintf("0x%x", v))
+	}
+	return a
+}
+
+func parseFlags(a []string, names []flagName) (uint32, error) {
+	var v uint32
+next:
+	for _, s := range a {
+		for _, f := range names {
+			if f.name == s {
+				v |= f.bit
+				continue next
+			}
+		}
+		n, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Unknown flag %q.", s)
+		}
+		v |= uint32(n)
+	}
+	return v, nil
+}
+
+func bufferJSON(id uint32) *uint32 {
+	if id == 0xffffffff {
+		return nil
+	}
+	return &id
+}
+
+func parseBuffer(id *uint32) uint32 {
+	if id == nil {
+		return 0xffffffff
+	}
+	return *id
+}
+
+// Returns group id, or nil if it is any.
+func groupJSON(id uint32) *uint32 {
+	if id == ofp14.G_ANY {
+		return nil
+	}
+	return &id
+}
+
+func portString(p uint32) string {
+	if name, ok := portNames[p]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(p), 10)
+}
+
+// Parses a port number or the name of a reserved port.
+func parsePort(s string) (uint32, error) {
+	for p, name := range portNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Bad port %q.", s)
+	}
+	return uint32(n), nil
+}
+
+// A port, encoded as its number or the name of a reserved port.
+type jsonPort uint32
+
+func (p jsonPort) MarshalJSON() ([]byte, error) {
+	if name, ok := portNames[uint32(p)]; ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(uint32(p))
+}
+
+func (p *jsonPort) UnmarshalJSON(data []byte) error {
+	var n uint32
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = jsonPort(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, err := parsePort(s)
+	*p = jsonPort(n)
+	return err
+}
+
+// Decodes the Ethernet frame in hex string s.
+func parseFrame(s string) (*eth.Ethernet, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	frame := eth.New()
+	if len(b) == 0 {
+		return frame, nil
+	}
+	// Ethernet unmarshals from the byte before the frame.
+	err = frame.UnmarshalBinary(append([]byte{0}, b...))
+	return frame, err
+}
+
+// How the value of an OXM field is written.
+const (
+	oxmInt = iota
+	oxmHex
+	oxmPort
+	oxmMAC
+	oxmIP
+)
+
+type oxmField struct {
+	name string
+	size int
+	kind int
+}
+
+var oxmFields = map[uint8]oxmField{
+	ofp14.XMT_OFB_IN_PORT:        {"in_port", 4, oxmPort},
+	ofp14.XMT_OFB_IN_PHY_PORT:    {"in_phy_port", 4, oxmPort},
+	ofp14.XMT_OFB_METADATA:       {"metadata", 8, oxmHex},
+	ofp14.XMT_OFB_ETH_DST:        {"eth_dst", 6, oxmMAC},
+	ofp14.XMT_OFB_ETH_SRC:        {"eth_src", 6, oxmMAC},
+	ofp14.XMT_OFB_ETH_TYPE:       {"eth_type", 2, oxmHex},
+	ofp14.XMT_OFB_VLAN_VID:       {"vlan_vid", 2, oxmHex},
+	ofp14.XMT_OFB_VLAN_PCP:       {"vlan_pcp", 1, oxmInt},
+	ofp14.XMT_OFB_IP_DSCP:        {"ip_dscp", 1, oxmInt},
+	ofp14.XMT_OFB_IP_ECN:         {"ip_ecn", 1, oxmInt},
+	ofp14.XMT_OFB_IP_PROTO:       {"ip_proto", 1, oxmInt},
+	ofp14.XMT_OFB_IPV4_SRC:       {"ipv4_src", 4, oxmIP},
+	ofp14.XMT_OFB_IPV4_DST:       {"ipv4_dst", 4, oxmIP},
+	ofp14.XMT_OFB_TCP_SRC:        {"tcp_src", 2, oxmInt},
+	ofp14.XMT_OFB_TCP_DST:        {"tcp_dst", 2, oxmInt},
+	ofp14.XMT_OFB_UDP_SRC:        {"udp_src", 2, oxmInt},
+	ofp14.XMT_OFB_UDP_DST:        {"udp_dst", 2, oxmInt},
+	ofp14.XMT_OFB_SCTP_SRC:       {"sctp_src", 2, oxmInt},
+	ofp14.XMT_OFB_SCTP_DST:       {"sctp_dst", 2, oxmInt},
+	ofp14.XMT_OFB_ICMPV4_TYPE:    {"icmpv4_type", 1, oxmInt},
+	ofp14.XMT_OFB_ICMPV4_CODE:    {"icmpv4_code", 1, oxmInt},
+	ofp14.XMT_OFB_ARP_OP:         {"arp_op", 2, oxmInt},
+	ofp14.XMT_OFB_ARP_SPA:        {"arp_spa", 4, oxmIP},
+	ofp14.XMT_OFB_ARP_TPA:        {"arp_tpa", 4, oxmIP},
+	ofp14.XMT_OFB_ARP_SHA:        {"arp_sha", 6, oxmMAC},
+	ofp14.XMT_OFB_ARP_THA:        {"arp_tha", 6, oxmMAC},
+	ofp14.XMT_OFB_IPV6_SRC:       {"ipv6_src", 16, oxmIP},
+	ofp14.XMT_OFB_IPV6_DST:       {"ipv6_dst", 16, oxmIP},
+	ofp14.XMT_OFB_IPV6_FLABEL:    {"ipv6_flabel", 4, oxmHex},
+	ofp14.XMT_OFB_ICMPV6_TYPE:    {"icmpv6_type", 1, oxmInt},
+	ofp14.XMT_OFB_ICMPV6_CODE:    {"icmpv6_code", 1, oxmInt},
+	ofp14.XMT_OFB_IPV6_ND_TARGET: {"ipv6_nd_target", 16, oxmIP},
+	ofp14.XMT_OFB_IPV6_ND_SLL:    {"ipv6_nd_sll", 6, oxmMAC},
+	ofp14.XMT_OFB_IPV6_ND_TLL:    {"ipv6_nd_tll", 6, oxmMAC},
+	ofp14.XMT_OFB_MPLS_LABEL:     {"mpls_label", 4, oxmInt},
+	ofp14.XMT_OFB_MPLS_TC:        {"mpls_tc", 1, oxmInt},
+	ofp14.XMT_OFB_MPLS_BOS:       {"mpls_bos", 1, oxmInt},
+	ofp14.XMT_OFB_PBB_ISID:       {"pbb_isid", 3, oxmInt},
+	ofp14.XMT_OFB_TUNNEL_ID:      {"tunnel_id", 8, oxmHex},
+	ofp14.XMT_OFB_IPV6_EXTHDR:    {"ipv6_exthdr", 2, oxmHex},
+	ofp14.XMT_OFB_PBB_UCA:        {"pbb_uca", 1, oxmInt},
+}
+
+// Returns the OXM basic field named name.
+func oxmFieldNamed(name string) (uint8, oxmField, bool) {
+	for id, f := range oxmFields {
+		if f.name == name {
+			return id, f, true
+		}
+	}
+	return 0, oxmField{}, false
+}
+
+func (f oxmField) format(b []byte) string {
+	switch f.kind {
+	case oxmMAC:
+		return net.HardwareAddr(b).String()
+	case oxmIP:
+		return net.IP(b).String()
+	case oxmPort:
+		return portString(binary.BigEndian.Uint32(b))
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	if f.kind == oxmHex {
+		return fmt.Sprintf("0x%0*x", 2*len(b), n)
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// Returns the value of f and its mask, if it has one.
+func (f oxmField) formatMasked(value, mask []byte) string {
+	if mask == nil {
+		return f.format(value)
+	}
+	if f.kind == oxmIP {
+		if ones, bits := net.IPMask(mask).Size(); bits != 0 {
+			return fmt.Sprintf("%s/%d", f.format(value), ones)
+		}
+	}
+	return f.format(value) + "/" + f.format(mask)
+}
+
+func (f oxmField) parse(s string) ([]byte, error) {
+	switch f.kind {
+	case oxmMAC:
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != f.size {
+			return nil, fmt.Errorf("Bad %s %q.", f.name, s)
+		}
+		return mac, nil
+	case oxmIP:
+		ip := net.ParseIP(s)
+		if f.size == 4 {
+			ip = ip.To4()
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("Bad %s %q.", f.name, s)
+		}
+		return ip, nil
+	case oxmPort:
+		p, err := parsePort(s)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, p)
+		return b, nil
+	}
+	n, err := strconv.ParseUint(s, 0, 8*f.size)
+	if err != nil {
+		return nil, fmt.Errorf("Bad %s %q.", f.name, s)
+	}
+	b := make([]byte, f.size)
+	for i := f.size - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	return b, nil
+}
+
+// Parses value[/mask], where the mask of an address can be a
+// prefix length. Returns a nil mask if there is none.
+func (f oxmField) parseMasked(s string) ([]byte, []byte, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		value, err := f.parse(s)
+		return value, nil, err
+	}
+	value, err := f.parse(s[:i])
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.kind == oxmIP {
+		if n, err := strconv.Atoi(s[i+1:]); err == nil {
+			if n < 0 || n > 8*f.size {
+				return nil, nil, fmt.Errorf("Bad prefix length in %q.", s)
+			}
+			return value, net.CIDRMask(n, 8*f.size), nil
+		}
+	}
+	mask, err := f.parse(s[i+1:])
+	return value, mask, err
+}
+
+// A match, encoded as its fields by name.
+type jsonMatch struct {
+	ofp14.Match
+}
+
+func (m jsonMatch) MarshalJSON() ([]byte, error) {
+	j := make(map[string]string)
+	b := m.Fields
+	for n := 0; n+4 <= len(b); {
+		h := binary.BigEndian.Uint32(b[n:])
+		length := int(h & 0xff)
+		if n+4+length > len(b) {
+			break
+		}
+		body := b[n+4 : n+4+length]
+		n += 4 + length
+		f, ok := oxmFields[uint8(h>>9&0x7f)]
+		masked := h&(1<<8) != 0
+		if h>>16 == ofp14.OXM_CLASS_OPENFLOW_BASIC && ok {
+			if !masked && length == f.size {
+				j[f.name] = f.format(body)
+				continue
+			}
+			if masked && length == 2*f.size {
+				j[f.name] = f.formatMasked(body[:f.size], body[f.size:])
+				continue
+			}
+		}
+		j[fmt.Sprintf("0x%08x", h)] = hex.EncodeToString(body)
+	}
+	return json.Marshal(j)
+}
+
+// An OXM TLV of a match being decoded.
+type oxmTLV struct {
+	header uint32
+	body   []byte
+}
+
+type oxmTLVs []oxmTLV
+
+func (a oxmTLVs) Len() int           { return len(a) }
+func (a oxmTLVs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a oxmTLVs) Less(i, j int) bool { return a[i].header < a[j].header }
+
+func (m *jsonMatch) UnmarshalJSON(data []byte) error {
+	var j map[string]string
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	tlvs := make(oxmTLVs, 0, len(j))
+	for k, v := range j {
+		if strings.HasPrefix(k, "0x") {
+			h, err := strconv.ParseUint(k, 0, 32)
+			if err != nil {
+				return fmt.Errorf("Bad OXM header %q.", k)
+			}
+			body, err := hex.DecodeString(v)
+			if err != nil || len(body) != int(h&0xff) {
+				return fmt.Errorf("Bad value of OXM field %s.", k)
+			}
+			tlvs = append(tlvs, oxmTLV{uint32(h), body})
+			continue
+		}
+		id, f, ok := oxmFieldNamed(k)
+		if !ok {
+			return fmt.Errorf("Unknown match field %q.", k)
+		}
+		value, mask, err := f.parseMasked(v)
+		if err != nil {
+			return err
+		}
+		h := ofp14.OxmId(id) | uint32(len(value)+len(mask))
+		if mask != nil {
+			h |= 1 << 8
+		}
+		tlvs = append(tlvs, oxmTLV{h, append(value, mask...)})
+	}
+	// Basic fields go in the order of their ids, which puts
+	// the prerequisites of each field before it.
+	sort.Sort(tlvs)
+	m.Match = *ofp14.NewMatch()
+	for _, t := range tlvs {
+		h := make([]byte, 4)
+		binary.BigEndian.PutUint32(h, t.header)
+		m.Fields = append(m.Fields, h...)
+		m.Fields = append(m.Fields, t.body...)
+	}
+	m.Length = uint16(4 + len(m.Fields))
+	return nil
+}
+
+var ethertypeActions = map[uint16]string{
+	ofp14.AT_PUSH_VLAN: "push_vlan",
+	ofp14.AT_PUSH_MPLS: "push_mpls",
+	ofp14.AT_PUSH_PBB:  "push_pbb",
+	ofp14.AT_POP_MPLS:  "pop_mpls",
+}
+
+// Actions without a body.
+var bareActions = map[uint16]string{
+	ofp14.AT_COPY_TTL_OUT: "copy_ttl_out",
+	ofp14.AT_COPY_TTL_IN:  "copy_ttl_in",
+	ofp14.AT_DEC_MPLS_TTL: "dec_mpls_ttl",
+	ofp14.AT_POP_VLAN:     "pop_vlan",
+	ofp14.AT_DEC_NW_TTL:   "dec_nw_ttl",
+	ofp14.AT_POP_PBB:      "pop_pbb",
+}
+
+// Actions setting a TTL.
+var ttlActions = map[uint16]string{
+	ofp14.AT_SET_MPLS_TTL: "set_mpls_ttl",
+	ofp14.AT_SET_NW_TTL:   "set_nw_ttl",
+}
+
+func actionNamed(names map[uint16]string, name string) (uint16, bool) {
+	for t, n := range names {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the JSON encoding of a.
+func actionJSON(a ofp14.Action) string {
+	switch a := a.(type) {
+	case *ofp14.ActionOutput:
+		if a.Port == ofp14.P_CONTROLLER {
+			return fmt.Sprintf("controller:%d", a.MaxLen)
+		}
+		return "output:" + portString(a.Port)
+	case *ofp14.ActionId:
+		if a.Type == ofp14.AT_GROUP {
+			return fmt.Sprintf("group:%d", a.Id)
+		}
+		return fmt.Sprintf("set_queue:%d", a.Id)
+	case *ofp14.ActionEthertype:
+		if name, ok := ethertypeActions[a.Type]; ok {
+			return fmt.Sprintf("%s:0x%04x", name, a.Ethertype)
+		}
+	case *ofp14.ActionSetField:
+		b, err := json.Marshal(jsonMatch{ofp14.Match{Fields: a.Field}})
+		var j map[string]string
+		if err == nil && json.Unmarshal(b, &j) == nil && len(j) == 1 {
+			for k, v := range j {
+				if !strings.HasPrefix(k, "0x") {
+					return "set_field:" + k + "=" + v
+				}
+			}
+		}
+	case *ofp14.ActionRaw:
+		b := a.Bytes()
+		if len(b) == 8 && binary.BigEndian.Uint32(b[4:]) == 0 {
+			if name, ok := bareActions[binary.BigEndian.Uint16(b)]; ok {
+				return name
+			}
+		}
+		if len(b) == 8 && b[5] == 0 && b[6] == 0 && b[7] == 0 {
+			if name, ok := ttlActions[binary.BigEndian.Uint16(b)]; ok {
+				return fmt.Sprintf("%s:%d", name, b[4])
+			}
+		}
+	}
+	b, _ := a.MarshalBinary()
+	return "raw:" + hex.EncodeToString(b)
+}
+
+func actionsJSON(actions []ofp14.Action) []string {
+	a := make([]string, len(actions))
+	for i, act := range actions {
+		a[i] = actionJSON(act)
+	}
+	return a
+}
+
+// Decodes a single action from its wire format.
+func decodeAction(b []byte) (ofp14.Action, error) {
+	a, err := ofp14.DecodeActions(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) != 1 {
+		return nil, fmt.Errorf("Expected one action, got %d.", len(a))
+	}
+	return a[0], nil
+}
+
+// Parses an action in the syntax of actionJSON.
+func ParseAction(s string) (ofp14.Action, error) {
+	name, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+	switch name {
+	case "output":
+		p, err := parsePort(arg)
+		if err != nil {
+			return nil, err
+		}
+		return ofp14.NewActionOutput(p), nil
+	case "controller":
+		a := ofp14.NewActionOutput(ofp14.P_CONTROLLER)
+		if arg != "" {
+			n, err := strconv.ParseUint(arg, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Bad max_len in %q.", s)
+			}
+			a.MaxLen = uint16(n)
+		}
+		return a, nil
+	case "group", "set_queue":
+		n, err := strconv.ParseUint(arg, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Bad id in %q.", s)
+		}
+		if name == "group" {
+			return ofp14.NewActionGroup(uint32(n)), nil
+		}
+		return ofp14.NewActionSetQueue(uint32(n)), nil
+	case "set_field":
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || strings.Contains(kv[1], "/") {
+			return nil, fmt.Errorf("Expected set_field:<field>=<value>, got %q.", s)
+		}
+		id, f, ok := oxmFieldNamed(kv[0])
+		if !ok {
+			return nil, fmt.Errorf("Unknown field %q.", kv[0])
+		}
+		value, err := f.parse(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		return ofp14.NewActionSetField(id, value), nil
+	case "raw":
+		b, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Bad raw action %q.", s)
+		}
+		return decodeAction(b)
+	}
+	if t, ok := actionNamed(ethertypeActions, name); ok {
+		n, err := strconv.ParseUint(arg, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Bad ethertype in %q.", s)
+		}
+		return &ofp14.ActionEthertype{Type: t, Ethertype: uint16(n)}, nil
+	}
+	if t, ok := actionNamed(bareActions, name); ok && arg == "" {
+		return decodeAction([]byte{byte(t >> 8), byte(t), 0, 8, 0, 0, 0, 0})
+	}
+	if t, ok := actionNamed(ttlActions, name); ok {
+		n, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Bad TTL in %q.", s)
+		}
+		return decodeAction([]byte{byte(t >> 8), byte(t), 0, 8, byte(n), 0, 0, 0})
+	}
+	return nil, fmt.Errorf("Unknown action %q.", s)
+}
+
+func parseActions(a []string) ([]ofp14.Action, error) {
+	actions := make([]ofp14.Action, 0, len(a))
+	for _, s := range a {
+		act, err := ParseAction(s)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+var actionInstructions = map[uint16]string{
+	ofp14.IT_APPLY_ACTIONS: "apply_actions",
+	ofp14.IT_WRITE_ACTIONS: "write_actions",
+	ofp14.IT_CLEAR_ACTIONS: "clear_actions",
+}
+
+// An instruction, encoded as an object with a single key.
+type jsonInstruction struct {
+	ofp14.Instruction
+}
+
+func (i jsonInstruction) MarshalJSON() ([]byte, error) {
+	var k string
+	var v interface{}
+	switch in := i.Instruction.(type) {
+	case *ofp14.InstrGotoTable:
+		k, v = "goto_table", in.TableId
+	case *ofp14.InstrWriteMetadata:
+		k, v = "write_metadata", fmt.Sprintf("0x%x/0x%x", in.Metadata, in.MetadataMask)
+	case *ofp14.InstrActions:
+		k, v = actionInstructions[in.Type], actionsJSON(in.Actions)
+	case *ofp14.InstrMeter:
+		k, v = "meter", in.MeterId
+	}
+	if k == "" {
+		b, _ := i.Instruction.MarshalBinary()
+		k, v = "raw", hex.EncodeToString(b)
+	}
+	return json.Marshal(map[string]interface{}{k: v})
+}
+
+func (i *jsonInstruction) UnmarshalJSON(data []byte) error {
+	var j map[string]json.RawMessage
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if len(j) != 1 {
+		return fmt.Errorf("Expected an instruction, got %s.", data)
+	}
+	for k, v := range j {
+		var err error
+		switch k {
+		case "goto_table":
+			var t uint8
+			err = json.Unmarshal(v, &t)
+			i.Instruction = ofp14.NewInstrGotoTable(t)
+		case "write_metadata":
+			var s string
+			if err = json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			in := &ofp14.InstrWriteMetadata{MetadataMask: 0xffffffffffffffff}
+			a := strings.SplitN(s, "/", 2)
+			if in.Metadata, err = strconv.ParseUint(a[0], 0, 64); err == nil && len(a) == 2 {
+				in.MetadataMask, err = strconv.ParseUint(a[1], 0, 64)
+			}
+			if err != nil {
+				return fmt.Errorf("Bad metadata %q.", s)
+			}
+			i.Instruction = in
+		case "apply_actions", "write_actions", "clear_actions":
+			var a []string
+			if err = json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if k == "clear_actions" && len(a) > 0 {
+				return fmt.Errorf("clear_actions takes no actions.")
+			}
+			t, _ := actionNamed(actionInstructions, k)
+			in := &ofp14.InstrActions{Type: t}
+			in.Actions, err = parseActions(a)
+			i.Instruction = in
+		case "meter":
+			var id uint32
+			err = json.Unmarshal(v, &id)
+			i.Instruction = ofp14.NewInstrMeter(id)
+		case "raw":
+			var s string
+			if err = json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				return fmt.Errorf("Bad raw instruction %q.", s)
+			}
+			a, err := ofp14.DecodeInstructions(b)
+			if err != nil {
+				return err
+			}
+			if len(a) != 1 {
+				return fmt.Errorf("Expected one instruction, got %d.", len(a))
+			}
+			i.Instruction = a[0]
+		default:
+			return fmt.Errorf("Unknown instruction %q.", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A mask of reasons, by role.
+type jsonMask struct {
+	Master []string `json:"master"`
+	Slave  []string `json:"slave"`
+}
+
+func reasonNames(v uint32, names []string) []string {
+	a := make([]string, 0)
+	for i := uint(0); i < 32; i++ {
+		if v&(1<<i) == 0 {
+			continue
+		}
+		if int(i) < len(names) {
+			a = append(a, names[i])
+		} else {
+			a = append(a, strconv.Itoa(int(i)))
+		}
+	}
+	return a
+}
+
+func parseReasons(a []string, names []string) (uint32, error) {
+	var v uint32
+next:
+	for _, s := range a {
+		for i, name := range names {
+			if name == s {
+				v |= 1 << uint(i)
+				continue next
+			}
+		}
+		n, err := strconv.ParseUint(s, 10, 5)
+		if err != nil {
+			return 0, fmt.Errorf("Unknown reason %q.", s)
+		}
+		v |= 1 << uint(n)
+	}
+	return v, nil
+}
+
+func newJSONMask(m [2]uint32, names []string) jsonMask {
+	return jsonMask{reasonNames(m[0], names), reasonNames(m[1], names)}
+}
+
+func (j jsonMask) mask(names []string) (m [2]uint32, err error) {
+	if m[0], err = parseReasons(j.Master, names); err != nil {
+		return
+	}
+	m[1], err = parseReasons(j.Slave, names)
+	return
+}
+
+type jsonAsyncConfig struct {
+	jsonHeader
+	PacketInMask    jsonMask `json:"packet_in_mask"`
+	PortStatusMask  jsonMask `json:"port_status_mask"`
+	FlowRemovedMask jsonMask `json:"flow_removed_mask"`
+}
+
+func (a AsyncConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonAsyncConfig{newJSONHeader(a.Header),
+		newJSONMask(a.PacketInMask, packetInReasons),
+		newJSONMask(a.PortStatusMask, portReasons),
+		newJSONMask(a.FlowRemovedMask, flowRemovedReasons)})
+}
+
+func (a *AsyncConfig) UnmarshalJSON(data []byte) error {
+	var j jsonAsyncConfig
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*a = *NewSetAsync()
+	if j.Type == typeNames[Type_GetAsyncReply] {
+		a.Header.Type = Type_GetAsyncReply
+	}
+	a.Header.Xid = j.Xid
+	var err error
+	if a.PacketInMask, err = j.PacketInMask.mask(packetInReasons); err != nil {
+		return err
+	}
+	if a.PortStatusMask, err = j.PortStatusMask.mask(portReasons); err != nil {
+		return err
+	}
+	if a.FlowRemovedMask, err = j.FlowRemovedMask.mask(flowRemovedReasons); err != nil {
+		return err
+	}
+	a.Header.Length = a.Len()
+	return nil
+}
+
+type jsonRoleRequest struct {
+	jsonHeader
+	Role         string `json:"role"`
+	GenerationId uint64 `json:"generation_id"`
+}
+
+func (r RoleRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonRoleRequest{newJSONHeader(r.Header),
+		enumString(int(r.Role), roles), r.GenerationId})
+}
+
+func (r *RoleRequest) UnmarshalJSON(data []byte) error {
+	j := jsonRoleRequest{Role: "nochange"}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	role, err := parseEnum(j.Role, roles)
+	if err != nil {
+		return err
+	}
+	*r = *NewRoleRequest(uint32(role), j.GenerationId)
+	if j.Type == typeNames[Type_RoleReply] {
+		r.Header.Type = Type_RoleReply
+	}
+	r.Header.Xid = j.Xid
+	r.Header.Length = r.Len()
+	return nil
+}
+
+type jsonErrorMsg struct {
+	jsonHeader
+	ErrorType string `json:"error_type"`
+	Code      uint16 `json:"code"`
+	Data      string `json:"data"`
+}
+
+func newJSONErrorMsg(e *ofp14.ErrorMsg) jsonErrorMsg {
+	return jsonErrorMsg{newJSONHeader(e.Header), enumString(int(e.Type), errorTypes),
+		e.Code, hex.EncodeToString(e.Data.Bytes())}
+}
+
+func (j jsonErrorMsg) message() (util.Message, error) {
+	t, err := parseEnum(j.ErrorType, errorTypes)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(j.Data)
+	if err != nil {
+		return nil, err
+	}
+	e := ofp14.NewErrorMsg()
+	j.set(&e.Header)
+	e.Type, e.Code, e.Data = uint16(t), j.Code, *util.NewBuffer(b)
+	e.Header.Length = e.Len()
+	return e, nil
+}
+
+type jsonSwitchFeatures struct {
+	jsonHeader
+	DPID         string   `json:"dpid"`
+	Buffers      uint32   `json:"n_buffers"`
+	Tables       uint8    `json:"n_tables"`
+	AuxiliaryId  uint8    `json:"auxiliary_id"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func newJSONSwitchFeatures(s *ofp14.SwitchFeatures) jsonSwitchFeatures {
+	return jsonSwitchFeatures{newJSONHeader(s.Header), s.DPID.String(), s.Buffers,
+		s.Tables, s.AuxiliaryId, flagsJSON(s.Capabilities, capabilityNames)}
+}
+
+func (j jsonSwitchFeatures) message() (util.Message, error) {
+	s := ofp14.NewFeaturesReply()
+	j.set(&s.Header)
+	if j.DPID != "" {
+		dpid, err := net.ParseMAC(j.DPID)
+		if err != nil || len(dpid) != 8 {
+			return nil, fmt.Errorf("Bad dpid %q.", j.DPID)
+		}
+		s.DPID = dpid
+	}
+	var err error
+	if s.Capabilities, err = parseFlags(j.Capabilities, capabilityNames); err != nil {
+		return nil, err
+	}
+	s.Buffers, s.Tables, s.AuxiliaryId = j.Buffers, j.Tables, j.AuxiliaryId
+	s.Header.Length = s.Len()
+	return s, nil
+}
+
+type jsonSwitchConfig struct {
+	jsonHeader
+	Frag        string `json:"frag"`
+	MissSendLen uint16 `json:"miss_send_len"`
+}
+
+func newJSONSwitchConfig(c *ofp14.SwitchConfig) jsonSwitchConfig {
+	return jsonSwitchConfig{newJSONHeader(c.Header),
+		enumString(int(c.Flags&ofp14.C_FRAG_MASK), fragModes), c.MissSendLen}
+}
+
+func (j jsonSwitchConfig) message() (util.Message, error) {
+	frag, err := parseEnum(j.Frag, fragModes)
+	if err != nil {
+		return nil, err
+	}
+	c := ofp14.NewSetConfig()
+	j.set(&c.Header)
+	if j.Type == typeNames[Type_GetConfigReply] {
+		c.Header.Type = Type_GetConfigReply
+	}
+	c.Flags, c.MissSendLen = uint16(frag), j.MissSendLen
+	c.Header.Length = c.Len()
+	return c, nil
+}
+
+type jsonPacketIn struct {
+	jsonHeader
+	BufferId *uint32   `json:"buffer_id,omitempty"`
+	TotalLen uint16    `json:"total_len"`
+	Reason   string    `json:"reason"`
+	TableId  uint8     `json:"table_id"`
+	Cookie   uint64    `json:"cookie"`
+	Match    jsonMatch `json:"match"`
+	Data     string    `json:"data"`
+}
+
+func newJSONPacketIn(p *ofp14.PacketIn) jsonPacketIn {
+	data, _ := p.Data.MarshalBinary()
+	return jsonPacketIn{newJSONHeader(p.Header), bufferJSON(p.BufferId), p.TotalLen,
+		enumString(int(p.Reason), packetInReasons), p.TableId, p.Cookie,
+		jsonMatch{p.Match}, hex.EncodeToString(data)}
+}
+
+func (j jsonPacketIn) message() (util.Message, error) {
+	reason, err := parseEnum(j.Reason, packetInReasons)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := parseFrame(j.Data)
+	if err != nil {
+		return nil, err
+	}
+	p := ofp14.NewPacketIn()
+	j.set(&p.Header)
+	p.BufferId, p.TotalLen, p.Reason = parseBuffer(j.BufferId), j.TotalLen, uint8(reason)
+	p.TableId, p.Cookie, p.Match, p.Data = j.TableId, j.Cookie, j.Match.Match, *frame
+	p.Header.Length = p.Len()
+	return p, nil
+}
+
+type jsonFlowMod struct {
+	jsonHeader
+	Command      string            `json:"command"`
+	TableId      uint8             `json:"table_id"`
+	Match        jsonMatch         `json:"match"`
+	Cookie       uint64            `json:"cookie"`
+	CookieMask   uint64            `json:"cookie_mask"`
+	IdleTimeout  uint16            `json:"idle_timeout"`
+	HardTimeout  uint16            `json:"hard_timeout"`
+	Priority     uint16            `json:"priority"`
+	BufferId     *uint32           `json:"buffer_id,omitempty"`
+	OutPort      jsonPort          `json:"out_port"`
+	OutGroup     *uint32           `json:"out_group,omitempty"`
+	Flags        []string          `json:"flags"`
+	Instructions []jsonInstruction `json:"instructions"`
+}
+
+func newJSONFlowMod(f *ofp14.FlowMod) jsonFlowMod {
+	instrs := make([]jsonInstruction, len(f.Instructions))
+	for i, in := range f.Instructions {
+		instrs[i] = jsonInstruction{in}
+	}
+	return jsonFlowMod{newJSONHeader(f.Header), enumString(int(f.Command), flowModCommands),
+		f.TableId, jsonMatch{f.Match}, f.Cookie, f.CookieMask, f.IdleTimeout,
+		f.HardTimeout, f.Priority, bufferJSON(f.BufferId), jsonPort(f.OutPort),
+		groupJSON(f.OutGroup), flagsJSON(uint32(f.Flags), flowModFlagNames), instrs}
+}
+
+func (j jsonFlowMod) message() (util.Message, error) {
+	cmd, err := parseEnum(j.Command, flowModCommands)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := parseFlags(j.Flags, flowModFlagNames)
+	if err != nil {
+		return nil, err
+	}
+	f := ofp14.NewFlowMod()
+	j.set(&f.Header)
+	f.Command, f.TableId, f.Match = uint8(cmd), j.TableId, j.Match.Match
+	f.Cookie, f.CookieMask = j.Cookie, j.CookieMask
+	f.IdleTimeout, f.HardTimeout, f.Priority = j.IdleTimeout, j.HardTimeout, j.Priority
+	f.BufferId, f.OutPort = parseBuffer(j.BufferId), uint32(j.OutPort)
+	if j.OutGroup != nil {
+		f.OutGroup = *j.OutGroup
+	}
+	f.Flags = uint16(flags)
+	for _, in := range j.Instructions {
+		f.AddInstruction(in.Instruction)
+	}
+	f.Header.Length = f.Len()
+	return f, nil
+}
+
+// Watch ports and groups, and the out groups of flow mods, are
+// left out if they are any.
+type jsonBucket struct {
+	Weight     uint16    `json:"weight"`
+	WatchPort  *jsonPort `json:"watch_port,omitempty"`
+	WatchGroup *uint32   `json:"watch_group,omitempty"`
+	Actions    []string  `json:"actions"`
+}
+
+type jsonGroupMod struct {
+	jsonHeader
+	Command   string       `json:"command"`
+	GroupType string       `json:"group_type"`
+	GroupId   uint32       `json:"group_id"`
+	Buckets   []jsonBucket `json:"buckets"`
+}
+
+func newJSONGroupMod(g *ofp14.GroupMod) jsonGroupMod {
+	buckets := make([]jsonBucket, len(g.Buckets))
+	for i, b := range g.Buckets {
+		buckets[i] = jsonBucket{Weight: b.Weight, Actions: actionsJSON(b.Actions)}
+		if b.WatchPort != ofp14.P_ANY {
+			port := jsonPort(b.WatchPort)
+			buckets[i].WatchPort = &port
+		}
+		buckets[i].WatchGroup = groupJSON(b.WatchGroup)
+	}
+	return jsonGroupMod{newJSONHeader(g.Header), enumString(int(g.Command), groupModCommands),
+		enumString(int(g.Type), groupTypes), g.GroupId, buckets}
+}
+
+func (j jsonGroupMod) message() (util.Message, error) {
+	cmd, err := parseEnum(j.Command, groupModCommands)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseEnum(j.GroupType, groupTypes)
+	if err != nil {
+		return nil, err
+	}
+	g := ofp14.NewGroupMod(uint16(cmd), uint8(t), j.GroupId)
+	j.set(&g.Header)
+	for _, jb := range j.Buckets {
+		b := ofp14.NewBucket()
+		b.Weight = jb.Weight
+		if jb.WatchPort != nil {
+			b.WatchPort = uint32(*jb.WatchPort)
+		}
+		if jb.WatchGroup != nil {
+			b.WatchGroup = *jb.WatchGroup
+		}
+		if b.Actions, err = parseActions(jb.Actions); err != nil {
+			return nil, err
+		}
+		g.AddBucket(b)
+	}
+	g.Header.Length = g.Len()
+	return g, nil
+}
+
+type jsonPortMod struct {
+	jsonHeader
+	PortNo jsonPort `json:"port_no"`
+	HWAddr string   `json:"hw_addr"`
+	Config []string `json:"config"`
+	Mask   []string `json:"mask"`
+}
+
+func newJSONPortMod(p *ofp14.PortMod) jsonPortMod {
+	return jsonPortMod{newJSONHeader(p.Header), jsonPort(p.PortNo), p.HWAddr.String(),
+		flagsJSON(p.Config, portConfigNames), flagsJSON(p.Mask, portConfigNames)}
+}
+
+func (j jsonPortMod) message() (util.Message, error) {
+	p := ofp14.NewPortMod(uint32(j.PortNo))
+	j.set(&p.Header)
+	if j.HWAddr != "" {
+		mac, err := net.ParseMAC(j.HWAddr)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("Bad hw_addr %q.", j.HWAddr)
+		}
+		p.HWAddr = mac
+	}
+	var err error
+	if p.Config, err = parseFlags(j.Config, portConfigNames); err != nil {
+		return nil, err
+	}
+	if p.Mask, err = parseFlags(j.Mask, portConfigNames); err != nil {
+		return nil, err
+	}
+	p.Header.Length = p.Len()
+	return p, nil
+}
+
+type jsonMeterBand struct {
+	Type      string `json:"type"`
+	Rate      uint32 `json:"rate"`
+	BurstSize uint32 `json:"burst_size"`
+	PrecLevel uint8  `json:"prec_level,omitempty"`
+}
+
+type jsonMeterMod struct {
+	jsonHeader
+	Command string          `json:"command"`
+	Flags   []string        `json:"flags"`
+	MeterId uint32          `json:"meter_id"`
+	Bands   []jsonMeterBand `json:"bands"`
+}
+
+func newJSONMeterMod(m *ofp14.MeterMod) jsonMeterMod {
+	bands := make([]jsonMeterBand, len(m.Bands))
+	for i, b := range m.Bands {
+		bands[i] = jsonMeterBand{enumString(int(b.Type), meterBandTypes), b.Rate, b.BurstSize, b.PrecLevel}
+	}
+	return jsonMeterMod{newJSONHeader(m.Header), enumString(int(m.Command), meterModCommands),
+		flagsJSON(uint32(m.Flags), meterFlagNames), m.MeterId, bands}
+}
+
+func (j jsonMeterMod) message() (util.Message, error) {
+	cmd, err := parseEnum(j.Command, meterModCommands)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := parseFlags(j.Flags, meterFlagNames)
+	if err != nil {
+		return nil, err
+	}
+	m := ofp14.NewMeterMod(uint16(cmd), j.MeterId)
+	j.set(&m.Header)
+	m.Flags = uint16(flags)
+	for _, jb := range j.Bands {
+		t, err := parseEnum(jb.Type, meterBandTypes)
+		if err != nil {
+			return nil, err
+		}
+		m.AddBand(ofp14.MeterBand{Type: uint16(t), Rate: jb.Rate, BurstSize: jb.BurstSize,
+			PrecLevel: jb.PrecLevel})
+	}
+	m.Header.Length = m.Len()
+	return m, nil
+}
+
+// Encodes msg, a message of this package, a message of package
+// ofp14 that OpenFlow 1.3 shares, or a header-only message, in
+// JSON.
+func EncodeJSON(msg util.Message) ([]byte, error) {
+	var j interface{}
+	switch m := msg.(type) {
+	case *ofpxx.Header:
+		j = newJSONHeader(*m)
+	case *ofpxx.Hello:
+		j = newJSONHeader(m.Header)
+	case *ofp14.ErrorMsg:
+		j = newJSONErrorMsg(m)
+	case *ofp14.SwitchFeatures:
+		j = newJSONSwitchFeatures(m)
+	case *ofp14.SwitchConfig:
+		j = newJSONSwitchConfig(m)
+	case *ofp14.PacketIn:
+		j = newJSONPacketIn(m)
+	case *ofp14.FlowMod:
+		j = newJSONFlowMod(m)
+	case *ofp14.GroupMod:
+		j = newJSONGroupMod(m)
+	case *ofp14.PortMod:
+		j = newJSONPortMod(m)
+	case *ofp14.MeterMod:
+		j = newJSONMeterMod(m)
+	case *AsyncConfig, *RoleRequest:
+		j = m
+	default:
+		return nil, fmt.Errorf("Messages of type %T have no JSON encoding.", msg)
+	}
+	return json.Marshal(j)
+}
+
+// Decodes a message encoded in JSON, of the type it names.
+// Messages of types without a body are decoded as an
+// ofpxx.Header.
+func DecodeJSON(data []byte) (util.Message, error) {
+	var h jsonHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	t, ok := uint8(0), false
+	for n, name := range typeNames {
+		if name == strings.ToLower(h.Type) {
+			t, ok = n, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("Unknown message type %q.", h.Type)
+	}
+	// Bodies are decoded into the encoding of their type,
+	// holding the defaults of the fields JSON may leave out,
+	// and converted to a message.
+	var j interface {
+		message() (util.Message, error)
+	}
+	switch t {
+	case Type_Hello, Type_EchoRequest, Type_EchoReply, Type_FeaturesRequest,
+		Type_GetConfigRequest, Type_BarrierRequest, Type_BarrierReply,
+		Type_GetAsyncRequest:
+		hdr := ofpxx.NewOfp13Header()
+		hdr.Type, hdr.Xid = t, h.Xid
+		hdr.Length = hdr.Len()
+		return &hdr, nil
+	case Type_GetAsyncReply, Type_SetAsync:
+		a := new(AsyncConfig)
+		err := json.Unmarshal(data, a)
+		return a, err
+	case Type_RoleRequest, Type_RoleReply:
+		r := new(RoleRequest)
+		err := json.Unmarshal(data, r)
+		return r, err
+	case Type_Error:
+		j = new(jsonErrorMsg)
+	case Type_FeaturesReply:
+		j = new(jsonSwitchFeatures)
+	case Type_GetConfigReply, Type_SetConfig:
+		j = &jsonSwitchConfig{Frag: "normal"}
+	case Type_PacketIn:
+		j = &jsonPacketIn{Reason: "no_match"}
+	case Type_FlowMod:
+		j = &jsonFlowMod{Command: "add", OutPort: ofp14.P_ANY}
+	case Type_GroupMod:
+		j = &jsonGroupMod{Command: "add"}
+	case Type_PortMod:
+		j = new(jsonPortMod)
+	case Type_MeterMod:
+		j = &jsonMeterMod{Command: "add"}
+	default:
+		return nil, fmt.Errorf("Messages of type %q have no JSON encoding.", h.Type)
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, err
+	}
+	return j.message()
+}
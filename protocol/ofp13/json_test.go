@@ -0,0 +1,283 @@
+package ofp13
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Encodes msg, decodes it and encodes it again, expecting the
+// same JSON and the same wire format.
+func jsonRoundTrip(t *testing.T, name string, msg util.Message) []byte {
+	b, err := EncodeJSON(msg)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	m, err := DecodeJSON(b)
+	if err != nil {
+		t.Fatalf("%s: couldn't decode %s: %v", name, b, err)
+	}
+	b2, err := EncodeJSON(m)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Errorf("%s: round trip changed\n%s\nto\n%s", name, b, b2)
+	}
+	w, _ := msg.MarshalBinary()
+	w2, _ := m.MarshalBinary()
+	if !bytes.Equal(w, w2) {
+		t.Errorf("%s: round trip changed the wire format\n%x\nto\n%x", name, w, w2)
+	}
+	return b
+}
+
+func testFlowMod() *ofp14.FlowMod {
+	f := ofp14.NewFlowMod()
+	f.Header.Version = VERSION
+	f.Header.Xid = 7
+	f.TableId = 1
+	f.Priority = 100
+	f.Flags = ofp14.FF_SEND_FLOW_REM
+	f.Match.AddField(ofp14.XMT_OFB_IN_PORT, []byte{0, 0, 0, 1})
+	f.Match.AddField(ofp14.XMT_OFB_ETH_TYPE, []byte{0x08, 0x00})
+	f.Match.AddMaskedField(ofp14.XMT_OFB_IPV4_DST, []byte{10, 0, 0, 0}, []byte{255, 255, 255, 0})
+	a := ofp14.NewInstrApplyActions()
+	a.AddAction(ofp14.NewActionSetField(ofp14.XMT_OFB_ETH_DST, []byte{2, 0, 0, 0, 0, 1}))
+	a.AddAction(ofp14.NewActionPushVlan(0x8100))
+	a.AddAction(ofp14.NewActionOutput(2))
+	f.AddInstruction(a)
+	f.AddInstruction(ofp14.NewInstrMeter(5))
+	f.AddInstruction(&ofp14.InstrWriteMetadata{Metadata: 1, MetadataMask: 0xff})
+	f.AddInstruction(ofp14.NewInstrGotoTable(2))
+	return f
+}
+
+func TestFlowModJSON(t *testing.T) {
+	b := jsonRoundTrip(t, "flow mod", testFlowMod())
+	for _, s := range []string{`"version":4`, `"type":"flow_mod"`, `"command":"add"`,
+		`"match":{"eth_type":"0x0800","in_port":"1","ipv4_dst":"10.0.0.0/24"}`,
+		`"flags":["send_flow_rem"]`, `"out_port":"any"`,
+		`{"apply_actions":["set_field:eth_dst=02:00:00:00:00:01","push_vlan:0x8100","output:2"]}`,
+		`{"meter":5}`, `{"write_metadata":"0x1/0xff"}`, `{"goto_table":2}`} {
+		if !bytes.Contains(b, []byte(s)) {
+			t.Errorf("%s lacks %s.", b, s)
+		}
+	}
+	if bytes.Contains(b, []byte("out_group")) || bytes.Contains(b, []byte("buffer_id")) {
+		t.Errorf("%s has an out group or buffer id of any.", b)
+	}
+}
+
+func TestDecodeJSONFixture(t *testing.T) {
+	msg, err := DecodeJSON([]byte(`{"version": 4, "type": "flow_mod", "xid": 9,
+		"command": "delete", "match": {"ipv4_src": "10.0.0.1", "in_port": "local",
+		"eth_type": "0x0800", "vlan_vid": "0x1000/0x1000"},
+		"out_port": 2, "out_group": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := msg.(*ofp14.FlowMod)
+	if !ok {
+		t.Fatalf("Got %T.", msg)
+	}
+	if f.Header.Version != VERSION || f.Header.Xid != 9 || f.Command != ofp14.FC_DELETE ||
+		f.OutPort != 2 || f.OutGroup != 3 || f.BufferId != 0xffffffff || f.Header.Length != f.Len() {
+		t.Errorf("Got %+v.", f)
+	}
+	// Fields are in the order of their ids, so the ethertype
+	// comes before the address it is a prerequisite of.
+	ids := f.Match.FieldIds()
+	expected := []uint32{ofp14.OxmId(ofp14.XMT_OFB_IN_PORT), ofp14.OxmId(ofp14.XMT_OFB_ETH_TYPE),
+		ofp14.OxmId(ofp14.XMT_OFB_VLAN_VID), ofp14.OxmId(ofp14.XMT_OFB_IPV4_SRC)}
+	if len(ids) != len(expected) {
+		t.Fatalf("Got fields %x.", ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("Got fields %x, expected %x.", ids, expected)
+			break
+		}
+	}
+	if p, ok := f.Match.Field(ofp14.XMT_OFB_IN_PORT); !ok || !bytes.Equal(p, []byte{0xff, 0xff, 0xff, 0xfe}) {
+		t.Errorf("Got in port %x.", p)
+	}
+}
+
+func TestMessagesJSON(t *testing.T) {
+	errMsg := ofp14.NewErrorMsg()
+	errMsg.Type, errMsg.Code = ofp14.ET_BAD_MATCH, 3
+	errMsg.Data = *util.NewBuffer([]byte{4, 14, 0, 8})
+
+	features := ofp14.NewFeaturesReply()
+	features.DPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	features.Buffers, features.Tables, features.AuxiliaryId = 256, 254, 1
+	features.Capabilities = ofp14.C_FLOW_STATS | ofp14.C_GROUP_STATS | 1<<4
+
+	config := ofp14.NewSetConfig()
+	config.Flags, config.MissSendLen = ofp14.C_FRAG_DROP, 128
+	configReply := ofp14.NewSetConfig()
+	configReply.Header.Type = Type_GetConfigReply
+
+	pkt := ofp14.NewPacketIn()
+	pkt.BufferId, pkt.TotalLen, pkt.Reason, pkt.TableId, pkt.Cookie = 3, 60, R_ACTION, 2, 0x10
+	pkt.Match.AddField(ofp14.XMT_OFB_IN_PORT, []byte{0, 0, 0, 4})
+	pkt.Match.AddField(ofp14.XMT_OFB_IPV6_SRC, net.ParseIP("2001:db8::1"))
+	pkt.Data = *eth.New()
+	pkt.Data.HWSrc = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	pkt.Data.HWDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	pkt.Data.Ethertype = 0x88cc
+
+	group := ofp14.NewGroupMod(ofp14.GC_ADD, ofp14.GT_FF, 7)
+	b := ofp14.NewBucket()
+	b.WatchPort = 1
+	b.AddAction(ofp14.NewActionOutput(1))
+	group.AddBucket(b)
+	b = ofp14.NewBucket()
+	b.WatchGroup = 8
+	b.AddAction(ofp14.NewActionGroup(8))
+	group.AddBucket(b)
+
+	port := ofp14.NewPortMod(3)
+	port.HWAddr = net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	port.Config, port.Mask = ofp14.PC_PORT_DOWN, ofp14.PC_PORT_DOWN|ofp14.PC_NO_FWD
+
+	meter := ofp14.NewMeterMod(ofp14.MC_MODIFY, 9)
+	meter.AddBand(ofp14.NewMeterBandDrop(1000, 100))
+	meter.AddBand(ofp14.MeterBand{Type: ofp14.MBT_DSCP_REMARK, Rate: 500, PrecLevel: 1})
+
+	roleReply := NewRoleRequest(CR_ROLE_SLAVE, 12)
+	roleReply.Header.Type = Type_RoleReply
+	async := NewSetAsync()
+	async.PacketInMask = [2]uint32{1<<R_NO_MATCH | 1<<R_ACTION, 0}
+
+	tests := []struct {
+		name string
+		msg  util.Message
+		// Strings the JSON must contain.
+		json []string
+	}{
+		{"hello", &ofpxx.Hello{Header: ofpxx.NewOfp13Header()}, []string{`"type":"hello"`}},
+		{"barrier", NewBarrierRequest(), []string{`"type":"barrier_request"`}},
+		{"error", errMsg, []string{`"error_type":"bad_match"`, `"code":3`, `"data":"040e0008"`}},
+		{"features reply", features, []string{`"dpid":"00:00:00:00:00:00:00:01"`,
+			`"auxiliary_id":1`, `"capabilities":["flow_stats","group_stats","0x10"]`}},
+		{"set config", config, []string{`"type":"set_config"`, `"frag":"drop"`}},
+		{"get config reply", configReply, []string{`"type":"get_config_reply"`, `"frag":"normal"`}},
+		{"packet in", pkt, []string{`"buffer_id":3`, `"reason":"action"`,
+			`"match":{"in_port":"4","ipv6_src":"2001:db8::1"}`, `"data":"ffffffffffff020000000001`}},
+		{"group mod", group, []string{`"group_type":"ff"`, `"group_id":7`,
+			`{"weight":0,"watch_port":1,"actions":["output:1"]}`,
+			`{"weight":0,"watch_group":8,"actions":["group:8"]}`}},
+		{"port mod", port, []string{`"port_no":3`, `"hw_addr":"02:00:00:00:00:03"`,
+			`"config":["port_down"]`, `"mask":["port_down","no_fwd"]`}},
+		{"meter mod", meter, []string{`"command":"modify"`, `"flags":["kbps","stats"]`,
+			`{"type":"drop","rate":1000,"burst_size":100}`, `{"type":"dscp_remark","rate":500,"burst_size":0,"prec_level":1}`}},
+		{"role request", NewRoleRequest(CR_ROLE_MASTER, 11), []string{`"type":"role_request"`,
+			`"role":"master"`, `"generation_id":11`}},
+		{"role reply", roleReply, []string{`"type":"role_reply"`, `"role":"slave"`}},
+		{"set async", async, []string{`"packet_in_mask":{"master":["no_match","action"],"slave":[]}`}},
+	}
+	for _, test := range tests {
+		// Messages of package ofp14 are decoded as OpenFlow 1.3.
+		setVersion(test.msg)
+		b := jsonRoundTrip(t, test.name, test.msg)
+		for _, s := range test.json {
+			if !bytes.Contains(b, []byte(s)) {
+				t.Errorf("%s: %s lacks %s.", test.name, b, s)
+			}
+		}
+	}
+}
+
+// Sets the version of a message of package ofp14 to 1.3.
+func setVersion(msg util.Message) {
+	switch m := msg.(type) {
+	case *ofp14.ErrorMsg:
+		m.Header.Version = VERSION
+	case *ofp14.SwitchFeatures:
+		m.Header.Version = VERSION
+	case *ofp14.SwitchConfig:
+		m.Header.Version = VERSION
+	case *ofp14.PacketIn:
+		m.Header.Version = VERSION
+	case *ofp14.GroupMod:
+		m.Header.Version = VERSION
+	case *ofp14.PortMod:
+		m.Header.Version = VERSION
+	case *ofp14.MeterMod:
+		m.Header.Version = VERSION
+	}
+}
+
+func TestActionJSON(t *testing.T) {
+	for _, s := range []string{
+		"output:3", "output:in_port", "output:flood", "controller:128",
+		"controller:65535", "group:4", "set_queue:1", "push_vlan:0x8100",
+		"push_mpls:0x8847", "pop_mpls:0x0800", "pop_vlan", "dec_nw_ttl",
+		"copy_ttl_out", "set_nw_ttl:64", "set_mpls_ttl:1",
+		"set_field:vlan_vid=0x1005", "set_field:ipv4_dst=10.0.0.2",
+		"set_field:tcp_dst=8080", "set_field:ipv6_dst=2001:db8::2",
+		"set_field:eth_src=02:00:00:00:00:05", "set_field:metadata=0x0000000000000001",
+		// An experimenter action.
+		"raw:ffff0010000023200000000000000000",
+	} {
+		a, err := ParseAction(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if got := actionJSON(a); got != s {
+			t.Errorf("Parsed %s, encoded it as %s.", s, got)
+		}
+	}
+	for _, s := range []string{"teleport:1", "output:nowhere", "set_field:eth_dst=1.2.3.4",
+		"set_field:ipv4_dst=10.0.0.0/8", "set_field:vlan=1", "push_vlan", "raw:0000",
+		"set_nw_ttl:300", "pop_vlan:1"} {
+		if _, err := ParseAction(s); err == nil {
+			t.Errorf("Parsed %s.", s)
+		}
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"version": 4, "type": "frob"}`,
+		`{"version": 4, "type": "multipart_request"}`,
+		`{"version": 4, "type": "flow_mod", "match": {"ipv4_dst": "10.0.0.0/33"}}`,
+		`{"version": 4, "type": "flow_mod", "match": {"nw_dst": "10.0.0.1"}}`,
+		`{"version": 4, "type": "flow_mod", "match": {"0x80001804": "0a00"}}`,
+		`{"version": 4, "type": "flow_mod", "instructions": [{"apply_actions": ["teleport:1"]}]}`,
+		`{"version": 4, "type": "flow_mod", "instructions": [{"goto_table": 1, "meter": 2}]}`,
+		`{"version": 4, "type": "flow_mod", "instructions": [{"clear_actions": ["output:1"]}]}`,
+		`{"version": 4, "type": "flow_mod", "command": "replace"}`,
+		`{"version": 4, "type": "packet_in", "data": "xyz"}`,
+		`{"version": 4, "type": "features_reply", "dpid": "00:01"}`,
+		`{"version": 4, "type": "group_mod", "group_type": "random"}`,
+		`{"version": 4, "type": "role_request", "role": "boss"}`,
+	} {
+		if _, err := DecodeJSON([]byte(s)); err == nil {
+			t.Errorf("Decoded %s.", s)
+		}
+	}
+	if _, err := EncodeJSON(ofp14.NewBundleCtrl(1, 0, 0)); err == nil {
+		t.Error("Encoded a message OpenFlow 1.3 doesn't have.")
+	}
+}
+
+func TestMatchJSONExperimenter(t *testing.T) {
+	// A field of the Nicira class is kept as is.
+	f := ofp14.NewFlowMod()
+	f.Header.Version = VERSION
+	f.Match.Fields = append(f.Match.Fields, 0x00, 0x01, 0x1e, 0x04, 0, 0, 0, 9)
+	f.Match.AddField(ofp14.XMT_OFB_ETH_TYPE, []byte{0x08, 0x06})
+	b := jsonRoundTrip(t, "experimenter field", f)
+	if !bytes.Contains(b, []byte(`"0x00011e04":"00000009"`)) {
+		t.Errorf("%s lacks the Nicira field.", b)
+	}
+}
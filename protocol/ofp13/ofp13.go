@@ -0,0 +1,128 @@
+// OpenFlow Wire Protocol 0x04
+// Package ofp13 provides OpenFlow 1.3 structs along with Read
+// and Write methods for each. OpenFlow 1.3 and 1.4 share the
+// wire format of the features, flow, group, meter, multipart,
+// packet-in and error messages, so those are reused from package
+// ofp14 with the header version set to 1.3. Only the messages
+// whose layout differs (role and async configuration) are
+// defined here.
+//
+// Struct documentation is taken from the OpenFlow Switch
+// Specification Version 1.3.0.
+// https://www.opennetworking.org/images/stories/downloads/sdn-resources/onf-specifications/openflow/openflow-spec-v1.3.0.pdf
+package ofp13
+
+import (
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+const (
+	VERSION = 4
+)
+
+// ofp_type 1.3
+const (
+	/* Immutable messages. */
+	Type_Hello = iota
+	Type_Error
+	Type_EchoRequest
+	Type_EchoReply
+	Type_Experimenter
+
+	/* Switch configuration messages. */
+	Type_FeaturesRequest
+	Type_FeaturesReply
+	Type_GetConfigRequest
+	Type_GetConfigReply
+	Type_SetConfig
+
+	/* Asynchronous messages. */
+	Type_PacketIn
+	Type_FlowRemoved
+	Type_PortStatus
+
+	/* Controller command messages. */
+	Type_PacketOut
+	Type_FlowMod
+	Type_GroupMod
+	Type_PortMod
+	Type_TableMod
+
+	/* Multipart messages. */
+	Type_MultipartRequest
+	Type_MultipartReply
+
+	/* Barrier messages. */
+	Type_BarrierRequest
+	Type_BarrierReply
+
+	/* Queue Configuration messages. */
+	Type_QueueGetConfigRequest
+	Type_QueueGetConfigReply
+
+	/* Controller role change request messages. */
+	Type_RoleRequest
+	Type_RoleReply
+
+	/* Asynchronous message configuration. */
+	Type_GetAsyncRequest
+	Type_GetAsyncReply
+	Type_SetAsync
+
+	/* Meters and rate limiters configuration messages. */
+	Type_MeterMod
+)
+
+// ofp_packet_in_reason 1.3
+const (
+	R_NO_MATCH = iota
+	R_ACTION
+	R_INVALID_TTL
+)
+
+// ofp_port_reason 1.3
+const (
+	PR_ADD = iota
+	PR_DELETE
+	PR_MODIFY
+)
+
+// ofp_flow_removed_reason 1.3
+const (
+	RR_IDLE_TIMEOUT = iota
+	RR_HARD_TIMEOUT
+	RR_DELETE
+	RR_GROUP_DELETE
+)
+
+func NewEchoRequest() *ofpxx.Header {
+	h := ofp14.NewEchoRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewEchoReply() *ofpxx.Header {
+	h := ofp14.NewEchoReply()
+	h.Version = VERSION
+	return h
+}
+
+func NewBarrierRequest() *ofpxx.Header {
+	h := ofp14.NewBarrierRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewFeaturesRequest() *ofpxx.Header {
+	h := ofp14.NewFeaturesRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewMultipartRequest(t uint16, body util.Message) *ofp14.MultipartRequest {
+	m := ofp14.NewMultipartRequest(t, body)
+	m.Header.Version = VERSION
+	return m
+}
@@ -1,15 +1,27 @@
 package ofp13
 
 import (
-	"errors"
-
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
 	"github.com/jonstout/ogo/protocol/util"
 )
 
 func Parse(b []byte) (message util.Message, err error) {
 	switch b[1] {
+	case Type_RoleReply:
+		message = new(RoleRequest)
+		err = message.UnmarshalBinary(b)
+	case Type_GetAsyncReply:
+		message = new(AsyncConfig)
+		err = message.UnmarshalBinary(b)
 	default:
-		err = errors.New("An unknown v1.3 packet type was received. Parse function will discard data.")
+		// Types past MeterMod (role status, bundles, ...)
+		// were added in OpenFlow 1.4.
+		if b[1] > Type_MeterMod {
+			err = &ofpxx.UnknownTypeError{Version: b[0], Type: b[1]}
+			return
+		}
+		message, err = ofp14.Parse(b)
 	}
 	return
 }
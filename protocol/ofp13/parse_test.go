@@ -0,0 +1,47 @@
+package ofp13
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func TestParseFeaturesReply(t *testing.T) {
+	b := "   04 06 00 20 00 00 00 01" + // Header
+		"   00 00 00 00 00 00 00 2a" + // DatapathId
+		"   00 00 01 00 fe 00 00 00" + // Buffers, Tables, AuxId, pad
+		"   00 00 00 4f 00 00 00 00" // Capabilities, Reserved
+	b = strings.Replace(b, " ", "", -1)
+
+	bytes, _ := hex.DecodeString(b)
+	msg, err := Parse(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := msg.(*ofp14.SwitchFeatures)
+	if !ok {
+		t.Fatalf("Parsed a features reply as %T.", msg)
+	}
+	if f.Header.Version != VERSION {
+		t.Errorf("Got version %d, expected %d.", f.Header.Version, VERSION)
+	}
+	if f.DPID.String() != "00:00:00:00:00:00:00:2a" || f.Tables != 0xfe {
+		t.Errorf("Got DPID %s with %d tables.", f.DPID, f.Tables)
+	}
+}
+
+func TestParseUnknownType(t *testing.T) {
+	for _, typ := range []string{"1e", "21", "fe"} { // RoleStatus, BundleControl, unknown
+		bytes, _ := hex.DecodeString("04" + typ + "000800000001")
+		msg, err := Parse(bytes)
+		if msg != nil {
+			t.Errorf("Parsed type %s as %T.", typ, msg)
+		}
+		if _, ok := err.(*ofpxx.UnknownTypeError); !ok {
+			t.Errorf("Got error %v for type %s, expected an UnknownTypeError.", err, typ)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Role request and reply messages. GenerationId orders the
+// master and slave requests of controllers; the switch rejects
+// those with an id older than the last it accepted.
+// ofp_role_request 1.3
+type RoleRequest struct {
+	ofpxx.Header
+	Role         uint32 // One of CR_ROLE_*
+	pad          []byte // 4 bytes
+	GenerationId uint64
+}
+
+func NewRoleRequest(role uint32, generation uint64) *RoleRequest {
+	r := new(RoleRequest)
+	r.Header = ofpxx.NewOfp13Header()
+	r.Header.Type = Type_RoleRequest
+	r.Role = role
+	r.pad = make([]byte, 4)
+	r.GenerationId = generation
+	return r
+}
+
+func (r *RoleRequest) Len() (n uint16) {
+	return r.Header.Len() + 16
+}
+
+func (r *RoleRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(r.Len()))
+	next := 0
+
+	r.Header.Length = r.Len()
+	bytes, err := r.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint32(data[next:], r.Role)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], r.GenerationId)
+	return
+}
+
+func (r *RoleRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < int(r.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"RoleRequest message.")
+	}
+	next := 0
+	err := r.Header.UnmarshalBinary(data[next:])
+	next += int(r.Header.Len())
+	r.Role = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	r.pad = make([]byte, 4)
+	copy(r.pad, data[next:])
+	next += 4
+	r.GenerationId = binary.BigEndian.Uint64(data[next:])
+	return err
+}
+
+// ofp_controller_role 1.3
+const (
+	CR_ROLE_NOCHANGE = iota
+	CR_ROLE_EQUAL
+	CR_ROLE_MASTER
+	CR_ROLE_SLAVE
+)
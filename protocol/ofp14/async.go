@@ -0,0 +1,138 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Asynchronous message configuration. Unlike OpenFlow 1.3 each
+// mask is sent as a separate property and properties that are
+// omitted from a SetAsync message are left unchanged.
+// ofp_async_config 1.4
+type AsyncConfig struct {
+	ofpxx.Header
+	Properties []AsyncConfigProp
+}
+
+func NewSetAsync() *AsyncConfig {
+	a := new(AsyncConfig)
+	a.Header = ofpxx.NewOfp14Header()
+	a.Header.Type = Type_SetAsync
+	a.Properties = make([]AsyncConfigProp, 0)
+	return a
+}
+
+func NewGetAsyncRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp14Header()
+	h.Type = Type_GetAsyncRequest
+	return &h
+}
+
+// Adds a reasons property of type t (one of ACPT_*) with mask.
+func (a *AsyncConfig) AddProperty(t uint16, mask uint32) {
+	a.Properties = append(a.Properties, AsyncConfigProp{t, 8, mask})
+}
+
+// Returns the mask of property type t and whether it was present.
+func (a *AsyncConfig) Property(t uint16) (uint32, bool) {
+	for _, p := range a.Properties {
+		if p.Type == t {
+			return p.Mask, true
+		}
+	}
+	return 0, false
+}
+
+func (a *AsyncConfig) Len() (n uint16) {
+	return a.Header.Len() + uint16(8*len(a.Properties))
+}
+
+func (a *AsyncConfig) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(a.Len()))
+	next := 0
+
+	a.Header.Length = a.Len()
+	bytes, err := a.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	for _, p := range a.Properties {
+		binary.BigEndian.PutUint16(data[next:], p.Type)
+		next += 2
+		binary.BigEndian.PutUint16(data[next:], 8)
+		next += 2
+		binary.BigEndian.PutUint32(data[next:], p.Mask)
+		next += 4
+	}
+	return
+}
+
+// Experimenter properties are skipped.
+func (a *AsyncConfig) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"AsyncConfig message.")
+	}
+	next := 0
+	err := a.Header.UnmarshalBinary(data[next:])
+	next += int(a.Header.Len())
+
+	a.Properties = make([]AsyncConfigProp, 0)
+	for next+4 <= len(data) {
+		p := AsyncConfigProp{}
+		p.Type = binary.BigEndian.Uint16(data[next:])
+		p.Length = binary.BigEndian.Uint16(data[next+2:])
+		if p.Length < 4 || next+int(p.Length) > len(data) {
+			return errors.New("AsyncConfig property has an invalid length.")
+		}
+		if p.Type < ACPT_EXPERIMENTER_SLAVE && p.Length >= 8 {
+			p.Mask = binary.BigEndian.Uint32(data[next+4:])
+			a.Properties = append(a.Properties, p)
+		}
+		next += (int(p.Length) + 7) / 8 * 8
+	}
+	return err
+}
+
+// ofp_async_config_prop_reasons 1.4
+type AsyncConfigProp struct {
+	Type   uint16 // One of ACPT_*
+	Length uint16
+	Mask   uint32
+}
+
+// ofp_async_config_prop_type 1.4
+const (
+	ACPT_PACKET_IN_SLAVE       = 0
+	ACPT_PACKET_IN_MASTER      = 1
+	ACPT_PORT_STATUS_SLAVE     = 2
+	ACPT_PORT_STATUS_MASTER    = 3
+	ACPT_FLOW_REMOVED_SLAVE    = 4
+	ACPT_FLOW_REMOVED_MASTER   = 5
+	ACPT_ROLE_STATUS_SLAVE     = 6
+	ACPT_ROLE_STATUS_MASTER    = 7
+	ACPT_TABLE_STATUS_SLAVE    = 8
+	ACPT_TABLE_STATUS_MASTER   = 9
+	ACPT_REQUESTFORWARD_SLAVE  = 10
+	ACPT_REQUESTFORWARD_MASTER = 11
+	ACPT_EXPERIMENTER_SLAVE    = 0xfffe
+	ACPT_EXPERIMENTER_MASTER   = 0xffff
+)
+
+// ofp_packet_in_reason 1.4
+const (
+	R_TABLE_MISS = iota
+	R_APPLY_ACTION
+	R_INVALID_TTL
+	R_ACTION_SET
+	R_GROUP
+	R_PACKET_OUT
+)
+
+// ofp_port_reason 1.4
+const (
+	PR_ADD = iota
+	PR_DELETE
+	PR_MODIFY
+)
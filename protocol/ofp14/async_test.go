@@ -0,0 +1,33 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAsyncConfigMarshalBinary(t *testing.T) {
+	b := "   05 1c 00 18 00 00 00 00" + // Header
+		"00 01 00 08 00 00 00 03" + // Packet-in master
+		"00 00 00 08 00 00 00 00" // Packet-in slave
+	b = strings.Replace(b, " ", "", -1)
+
+	a := NewSetAsync()
+	a.Header.Xid = 0
+	a.AddProperty(ACPT_PACKET_IN_MASTER, 1<<R_TABLE_MISS|1<<R_APPLY_ACTION)
+	a.AddProperty(ACPT_PACKET_IN_SLAVE, 0)
+	data, _ := a.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+
+	bytes, _ := hex.DecodeString(b)
+	r := NewSetAsync()
+	r.UnmarshalBinary(bytes)
+	if mask, ok := r.Property(ACPT_PACKET_IN_MASTER); !ok || mask != 3 {
+		t.Errorf("Got packet-in master mask %d, expected %d.", mask, 3)
+	}
+}
@@ -0,0 +1,242 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A bundle is a sequence of OpenFlow modification requests from
+// the controller that is applied as a single OpenFlow operation.
+// The controller opens a bundle, adds messages to it and then
+// either commits or discards it.
+// ofp_bundle_ctrl_msg 1.4
+type BundleCtrl struct {
+	ofpxx.Header
+	BundleId   uint32
+	Type       uint16 // One of BCT_*
+	Flags      uint16 // Bitmap of BF_* flags
+	Properties []util.Message
+}
+
+func NewBundleCtrl(id uint32, t uint16, flags uint16) *BundleCtrl {
+	b := new(BundleCtrl)
+	b.Header = ofpxx.NewOfp14Header()
+	b.Header.Type = Type_BundleControl
+	b.BundleId = id
+	b.Type = t
+	b.Flags = flags
+	b.Properties = make([]util.Message, 0)
+	return b
+}
+
+func (b *BundleCtrl) Len() (n uint16) {
+	n = b.Header.Len() + 8
+	for _, p := range b.Properties {
+		n += p.Len()
+	}
+	return
+}
+
+func (b *BundleCtrl) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(b.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	b.Header.Length = b.Len()
+	bytes, err = b.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint32(data[next:], b.BundleId)
+	next += 4
+	binary.BigEndian.PutUint16(data[next:], b.Type)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], b.Flags)
+	next += 2
+
+	for _, p := range b.Properties {
+		bytes, err = p.MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (b *BundleCtrl) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"BundleCtrl message.")
+	}
+	next := 0
+	err := b.Header.UnmarshalBinary(data[next:])
+	next += int(b.Header.Len())
+	b.BundleId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	b.Type = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	b.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+
+	end := int(b.Header.Length)
+	if end > len(data) {
+		end = len(data)
+	}
+	b.Properties, err = decodeProperties(data[next:end])
+	return err
+}
+
+// ofp_bundle_ctrl_type 1.4
+const (
+	BCT_OPEN_REQUEST = iota
+	BCT_OPEN_REPLY
+	BCT_CLOSE_REQUEST
+	BCT_CLOSE_REPLY
+	BCT_COMMIT_REQUEST
+	BCT_COMMIT_REPLY
+	BCT_DISCARD_REQUEST
+	BCT_DISCARD_REPLY
+)
+
+// ofp_bundle_flags 1.4
+const (
+	BF_ATOMIC  = 1 << 0 /* Execute atomically. */
+	BF_ORDERED = 1 << 1 /* Execute in specified order. */
+)
+
+// Adds a message to an open bundle. The message is validated
+// by the switch when it is added but only applied when the
+// bundle is committed.
+// ofp_bundle_add_msg 1.4
+type BundleAdd struct {
+	ofpxx.Header
+	BundleId   uint32
+	pad        []uint8 // Size 2
+	Flags      uint16
+	Message    util.Message
+	Properties []util.Message
+}
+
+func NewBundleAdd(id uint32, flags uint16, msg util.Message) *BundleAdd {
+	b := new(BundleAdd)
+	b.Header = ofpxx.NewOfp14Header()
+	b.Header.Type = Type_BundleAddMessage
+	b.BundleId = id
+	b.pad = make([]byte, 2)
+	b.Flags = flags
+	b.Message = msg
+	b.Properties = make([]util.Message, 0)
+	return b
+}
+
+func (b *BundleAdd) Len() (n uint16) {
+	n = b.Header.Len() + 8
+	if b.Message != nil {
+		n += b.Message.Len()
+	}
+	// Properties are aligned to 64 bits.
+	if len(b.Properties) > 0 {
+		n = (n + 7) / 8 * 8
+	}
+	for _, p := range b.Properties {
+		n += p.Len()
+	}
+	return
+}
+
+func (b *BundleAdd) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(b.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	b.Header.Length = b.Len()
+	bytes, err = b.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint32(data[next:], b.BundleId)
+	next += 4
+	copy(data[next:], b.pad)
+	next += len(b.pad)
+	binary.BigEndian.PutUint16(data[next:], b.Flags)
+	next += 2
+
+	if b.Message != nil {
+		bytes, err = b.Message.MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+
+	if len(b.Properties) > 0 {
+		next = (next + 7) / 8 * 8
+	}
+	for _, p := range b.Properties {
+		bytes, err = p.MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+// The bundled message is not decoded. It is stored in a
+// util.Buffer so it can be retransmitted as is.
+func (b *BundleAdd) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"BundleAdd message.")
+	}
+	next := 0
+	err := b.Header.UnmarshalBinary(data[next:])
+	next += int(b.Header.Len())
+	b.BundleId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	b.pad = make([]byte, 2)
+	copy(b.pad, data[next:])
+	next += len(b.pad)
+	b.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+
+	inner := new(ofpxx.Header)
+	inner.UnmarshalBinary(data[next:])
+	end := next + int(inner.Length)
+	if int(inner.Length) < 8 || end > len(data) {
+		return errors.New("BundleAdd contains a message with an invalid length.")
+	}
+	b.Message = util.NewBuffer(append([]byte(nil), data[next:end]...))
+
+	b.Properties = make([]util.Message, 0)
+	next = (end + 7) / 8 * 8
+	end = int(b.Header.Length)
+	if end > len(data) {
+		end = len(data)
+	}
+	if next < end {
+		b.Properties, err = decodeProperties(data[next:end])
+	}
+	return err
+}
+
+// Bundle properties are not interpreted. Each property is kept
+// as a util.Buffer holding its type, length and body.
+func decodeProperties(data []byte) (props []util.Message, err error) {
+	props = make([]util.Message, 0)
+	next := 0
+	for next+4 <= len(data) {
+		length := int(binary.BigEndian.Uint16(data[next+2:]))
+		if length < 4 || next+length > len(data) {
+			return props, errors.New("Bundle property has an invalid length.")
+		}
+		props = append(props, util.NewBuffer(append([]byte(nil), data[next:next+length]...)))
+		next += length
+	}
+	return
+}
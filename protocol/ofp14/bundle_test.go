@@ -0,0 +1,90 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func TestBundleCtrlMarshalBinary(t *testing.T) {
+	b := "   05 21 00 10 00 00 00 00" + // Header
+		"00 00 00 07" + // Bundle Id
+		"00 04" + // Type (Commit Request)
+		"00 01" // Flags (Atomic)
+	b = strings.Replace(b, " ", "", -1)
+
+	c := NewBundleCtrl(7, BCT_COMMIT_REQUEST, BF_ATOMIC)
+	c.Header.Xid = 0
+	data, _ := c.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestBundleAddMarshalBinary(t *testing.T) {
+	b := "   05 22 00 18 00 00 00 00" + // Header
+		"00 00 00 07" + // Bundle Id
+		"00 00" + // Pad
+		"00 01" + // Flags (Atomic)
+		"05 14 00 08 00 00 00 00" // Barrier Request
+	b = strings.Replace(b, " ", "", -1)
+
+	inner := ofpxx.NewOfp14Header()
+	inner.Type = Type_BarrierRequest
+	inner.Xid = 0
+	a := NewBundleAdd(7, BF_ATOMIC, &inner)
+	a.Header.Xid = 0
+	data, _ := a.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+
+	bytes, _ := hex.DecodeString(b)
+	r := new(BundleAdd)
+	if err := r.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if r.BundleId != 7 || r.Message.Len() != 8 {
+		t.Errorf("Got bundle %d with message length %d, expected %d and %d.",
+			r.BundleId, r.Message.Len(), 7, 8)
+	}
+}
+
+func TestFlowMonitorReplyUnmarshalBinary(t *testing.T) {
+	b := "   00 20 00 01" + // Length, Event (Added)
+		"03 00 00 0a 00 00 00 64" + // Table, Reason, Idle, Hard, Priority
+		"00 00 00 00" + // Pad
+		"00 00 00 00 00 00 00 2a" + // Cookie
+		"00 01 00 04 00 00 00 00" + // Empty OXM Match
+		"00 08 00 04 00 00 00 09" // Length, Event (Abbrev), Xid
+	b = strings.Replace(b, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	r := new(FlowMonitorReply)
+	if err := r.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Updates) != 2 {
+		t.Fatalf("Got %d updates, expected %d.", len(r.Updates), 2)
+	}
+	full, ok := r.Updates[0].(*FlowUpdateFull)
+	if !ok {
+		t.Fatal("Got wrong FlowUpdate type.")
+	} else if full.TableId != 3 || full.Priority != 100 || full.Cookie != 42 {
+		t.Errorf("Got table %d priority %d cookie %d.", full.TableId, full.Priority, full.Cookie)
+	}
+	abbrev, ok := r.Updates[1].(*FlowUpdateAbbrev)
+	if !ok {
+		t.Fatal("Got wrong FlowUpdate type.")
+	} else if abbrev.Xid != 9 {
+		t.Errorf("Got xid %d, expected %d.", abbrev.Xid, 9)
+	}
+}
@@ -0,0 +1,66 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func NewConfigRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp14Header()
+	h.Type = Type_GetConfigRequest
+	return &h
+}
+
+// ofp_config_flags 1.4
+const (
+	C_FRAG_NORMAL = 0
+	C_FRAG_DROP   = 1
+	C_FRAG_REASM  = 2
+	C_FRAG_MASK   = 3
+)
+
+// ofp_switch_config 1.4
+type SwitchConfig struct {
+	ofpxx.Header
+	Flags       uint16 // OFPC_* flags
+	MissSendLen uint16
+}
+
+func NewSetConfig() *SwitchConfig {
+	c := new(SwitchConfig)
+	c.Header = ofpxx.NewOfp14Header()
+	c.Header.Type = Type_SetConfig
+	return c
+}
+
+func (c *SwitchConfig) Len() (n uint16) {
+	return c.Header.Len() + 4
+}
+
+func (c *SwitchConfig) MarshalBinary() (data []byte, err error) {
+	c.Header.Length = c.Len()
+	data, err = c.Header.MarshalBinary()
+	if err != nil {
+		return
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, c.Flags)
+	binary.BigEndian.PutUint16(b[2:], c.MissSendLen)
+	data = append(data, b...)
+	return
+}
+
+func (c *SwitchConfig) UnmarshalBinary(data []byte) error {
+	if err := c.Header.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	n := int(c.Header.Len())
+	if len(data) < n+4 {
+		return errors.New("The []byte is too short to unmarshal a SwitchConfig.")
+	}
+	c.Flags = binary.BigEndian.Uint16(data[n:])
+	c.MissSendLen = binary.BigEndian.Uint16(data[n+2:])
+	return nil
+}
@@ -0,0 +1,34 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSwitchConfigMarshalBinary(t *testing.T) {
+	b := "   05 09 00 0c 00 00 00 00" + // Header
+		"00 02 ff ff" // Flags, miss send length
+	b = strings.Replace(b, " ", "", -1)
+
+	c := NewSetConfig()
+	c.Header.Xid = 0
+	c.Flags = C_FRAG_REASM
+	c.MissSendLen = 0xffff
+	data, _ := c.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+
+	bytes, _ := hex.DecodeString(b)
+	r := new(SwitchConfig)
+	if err := r.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if r.Flags&C_FRAG_MASK != C_FRAG_REASM || r.MissSendLen != 0xffff {
+		t.Errorf("Got flags %d and miss send length %d.", r.Flags, r.MissSendLen)
+	}
+}
@@ -0,0 +1,65 @@
+package ofp14
+
+import (
+	"errors"
+)
+
+// ofp_desc 1.4, the body of a MultipartType_Desc reply.
+type DescStats struct {
+	MfrDesc   []byte // Size DESC_STR_LEN
+	HWDesc    []byte // Size DESC_STR_LEN
+	SWDesc    []byte // Size DESC_STR_LEN
+	SerialNum []byte // Size SERIAL_NUM_LEN
+	DPDesc    []byte // Size DESC_STR_LEN
+}
+
+func NewDescStats() *DescStats {
+	s := new(DescStats)
+	s.MfrDesc = make([]byte, DESC_STR_LEN)
+	s.HWDesc = make([]byte, DESC_STR_LEN)
+	s.SWDesc = make([]byte, DESC_STR_LEN)
+	s.SerialNum = make([]byte, SERIAL_NUM_LEN)
+	s.DPDesc = make([]byte, DESC_STR_LEN)
+	return s
+}
+
+func (s *DescStats) Len() (n uint16) {
+	return uint16(DESC_STR_LEN*4 + SERIAL_NUM_LEN)
+}
+
+func (s *DescStats) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(s.Len()))
+	n := 0
+	copy(data[n:], s.MfrDesc)
+	n += DESC_STR_LEN
+	copy(data[n:], s.HWDesc)
+	n += DESC_STR_LEN
+	copy(data[n:], s.SWDesc)
+	n += DESC_STR_LEN
+	copy(data[n:], s.SerialNum)
+	n += SERIAL_NUM_LEN
+	copy(data[n:], s.DPDesc)
+	return
+}
+
+func (s *DescStats) UnmarshalBinary(data []byte) error {
+	if len(data) < int(s.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full DescStats.")
+	}
+	n := 0
+	copy(s.MfrDesc, data[n:])
+	n += DESC_STR_LEN
+	copy(s.HWDesc, data[n:])
+	n += DESC_STR_LEN
+	copy(s.SWDesc, data[n:])
+	n += DESC_STR_LEN
+	copy(s.SerialNum, data[n:])
+	n += SERIAL_NUM_LEN
+	copy(s.DPDesc, data[n:])
+	return nil
+}
+
+const (
+	DESC_STR_LEN   = 256
+	SERIAL_NUM_LEN = 32
+)
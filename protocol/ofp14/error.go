@@ -0,0 +1,105 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// ofp_error_msg 1.4
+type ErrorMsg struct {
+	ofpxx.Header
+	Type uint16
+	Code uint16
+	Data util.Buffer
+}
+
+func NewErrorMsg() *ErrorMsg {
+	e := new(ErrorMsg)
+	e.Header = ofpxx.NewOfp14Header()
+	e.Header.Type = Type_Error
+	e.Data = *util.NewBuffer(make([]byte, 0))
+	return e
+}
+
+func (e *ErrorMsg) Len() (n uint16) {
+	return e.Header.Len() + 4 + e.Data.Len()
+}
+
+func (e *ErrorMsg) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(e.Len()))
+	next := 0
+
+	e.Header.Length = e.Len()
+	bytes, err := e.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint16(data[next:], e.Type)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], e.Code)
+	next += 2
+	bytes, err = e.Data.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	return
+}
+
+func (e *ErrorMsg) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"ErrorMsg message.")
+	}
+	next := 0
+	e.Header.UnmarshalBinary(data[next:])
+	next += int(e.Header.Len())
+	e.Type = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	e.Code = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	return e.Data.UnmarshalBinary(data[next:])
+}
+
+// ofp_error_type 1.4 (partial)
+const (
+	ET_HELLO_FAILED          = 0
+	ET_BAD_REQUEST           = 1
+	ET_BAD_ACTION            = 2
+	ET_BAD_INSTRUCTION       = 3
+	ET_BAD_MATCH             = 4
+	ET_FLOW_MOD_FAILED       = 5
+	ET_GROUP_MOD_FAILED      = 6
+	ET_PORT_MOD_FAILED       = 7
+	ET_TABLE_MOD_FAILED      = 8
+	ET_QUEUE_OP_FAILED       = 9
+	ET_SWITCH_CONFIG_FAILED  = 10
+	ET_ROLE_REQUEST_FAILED   = 11
+	ET_METER_MOD_FAILED      = 12
+	ET_TABLE_FEATURES_FAILED = 13
+	ET_BAD_PROPERTY          = 14
+	ET_ASYNC_CONFIG_FAILED   = 15
+	ET_FLOW_MONITOR_FAILED   = 16
+	ET_BUNDLE_FAILED         = 17
+	ET_EXPERIMENTER          = 0xffff
+)
+
+// ofp_bundle_failed_code 1.4
+const (
+	BFC_UNKNOWN = iota
+	BFC_EPERM
+	BFC_BAD_ID
+	BFC_BUNDLE_EXIST
+	BFC_BUNDLE_CLOSED
+	BFC_OUT_OF_BUNDLES
+	BFC_BAD_TYPE
+	BFC_BAD_FLAGS
+	BFC_MSG_BAD_LEN
+	BFC_MSG_BAD_XID
+	BFC_MSG_UNSUP
+	BFC_MSG_CONFLICT
+	BFC_MSG_TOO_MANY
+	BFC_MSG_FAILED
+	BFC_TIMEOUT
+	BFC_BUNDLE_IN_PROGRESS
+)
@@ -0,0 +1,107 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// ofp_switch_features 1.4
+// Unlike OpenFlow 1.0, port descriptions are not included in
+// the features reply. They must be requested with a
+// MultipartType_PortDesc request.
+type SwitchFeatures struct {
+	ofpxx.Header
+	DPID         net.HardwareAddr // Size 8
+	Buffers      uint32
+	Tables       uint8
+	AuxiliaryId  uint8
+	pad          []uint8 // Size 2
+	Capabilities uint32
+	Reserved     uint32
+}
+
+// FeaturesRequest constructor
+func NewFeaturesRequest() *ofpxx.Header {
+	req := ofpxx.NewOfp14Header()
+	req.Type = Type_FeaturesRequest
+	return &req
+}
+
+// FeaturesReply constructor
+func NewFeaturesReply() *SwitchFeatures {
+	res := new(SwitchFeatures)
+	res.Header = ofpxx.NewOfp14Header()
+	res.Header.Type = Type_FeaturesReply
+	res.DPID = make([]byte, 8)
+	res.pad = make([]byte, 2)
+	return res
+}
+
+func (s *SwitchFeatures) Len() (n uint16) {
+	return s.Header.Len() + 24
+}
+
+func (s *SwitchFeatures) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(s.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	s.Header.Length = s.Len()
+	bytes, err = s.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	copy(data[next:], s.DPID)
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], s.Buffers)
+	next += 4
+	data[next] = s.Tables
+	next += 1
+	data[next] = s.AuxiliaryId
+	next += 1
+	copy(data[next:], s.pad)
+	next += len(s.pad)
+	binary.BigEndian.PutUint32(data[next:], s.Capabilities)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], s.Reserved)
+	next += 4
+	return
+}
+
+func (s *SwitchFeatures) UnmarshalBinary(data []byte) error {
+	if len(data) < int(s.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"SwitchFeatures message.")
+	}
+	next := 0
+	err := s.Header.UnmarshalBinary(data[next:])
+	next += int(s.Header.Len())
+	copy(s.DPID, data[next:])
+	next += len(s.DPID)
+	s.Buffers = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.Tables = data[next]
+	next += 1
+	s.AuxiliaryId = data[next]
+	next += 1
+	copy(s.pad, data[next:])
+	next += len(s.pad)
+	s.Capabilities = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.Reserved = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	return err
+}
+
+// ofp_capabilities 1.4
+const (
+	C_FLOW_STATS   = 1 << 0
+	C_TABLE_STATS  = 1 << 1
+	C_PORT_STATS   = 1 << 2
+	C_GROUP_STATS  = 1 << 3
+	C_IP_REASM     = 1 << 5
+	C_QUEUE_STATS  = 1 << 6
+	C_PORT_BLOCKED = 1 << 8
+)
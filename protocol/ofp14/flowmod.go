@@ -0,0 +1,628 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Appends an OXM basic field matching value exactly.
+func (m *Match) AddField(field uint8, value []byte) {
+	h := OxmId(field) | uint32(len(value))
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, h)
+	m.Fields = append(m.Fields, b...)
+	m.Fields = append(m.Fields, value...)
+	m.Length = uint16(4 + len(m.Fields))
+}
+
+// Appends an OXM basic field matching the bits of value set in
+// mask.
+func (m *Match) AddMaskedField(field uint8, value, mask []byte) {
+	h := OxmId(field) | 1<<8 | uint32(len(value)+len(mask))
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, h)
+	m.Fields = append(m.Fields, b...)
+	m.Fields = append(m.Fields, value...)
+	m.Fields = append(m.Fields, mask...)
+	m.Length = uint16(4 + len(m.Fields))
+}
+
+// Returns the OXM ids of the fields in m.
+func (m *Match) FieldIds() []uint32 {
+	a := make([]uint32, 0)
+	for n := 0; n+4 <= len(m.Fields); {
+		h := binary.BigEndian.Uint32(m.Fields[n:])
+		a = append(a, h&0xfffffe00)
+		n += 4 + int(h&0xff)
+	}
+	return a
+}
+
+// Returns the value of the OXM basic field in m, without its
+// mask if it has one.
+func (m *Match) Field(field uint8) ([]byte, bool) {
+	for n := 0; n+4 <= len(m.Fields); {
+		h := binary.BigEndian.Uint32(m.Fields[n:])
+		length := int(h & 0xff)
+		if n+4+length > len(m.Fields) {
+			break
+		}
+		if h&0xfffffe00 == OxmId(field) {
+			if h&(1<<8) != 0 {
+				length /= 2
+			}
+			return m.Fields[n+4 : n+4+length], true
+		}
+		n += 4 + int(h&0xff)
+	}
+	return nil, false
+}
+
+// ofp_flow_mod 1.4
+type FlowMod struct {
+	ofpxx.Header
+	Cookie       uint64
+	CookieMask   uint64
+	TableId      uint8
+	Command      uint8
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Priority     uint16
+	BufferId     uint32
+	OutPort      uint32
+	OutGroup     uint32
+	Flags        uint16
+	Importance   uint16
+	Match        Match
+	Instructions []Instruction
+}
+
+func NewFlowMod() *FlowMod {
+	f := new(FlowMod)
+	f.Header = ofpxx.NewOfp14Header()
+	f.Header.Type = Type_FlowMod
+	f.Command = FC_ADD
+	f.Priority = 1000
+	f.BufferId = 0xffffffff
+	f.OutPort = P_ANY
+	f.OutGroup = G_ANY
+	f.Match = *NewMatch()
+	f.Instructions = make([]Instruction, 0)
+	return f
+}
+
+func (f *FlowMod) AddInstruction(i Instruction) {
+	f.Instructions = append(f.Instructions, i)
+}
+
+func (f *FlowMod) Len() (n uint16) {
+	n = f.Header.Len() + 40 + f.Match.Len()
+	for _, i := range f.Instructions {
+		n += i.Len()
+	}
+	return
+}
+
+func (f *FlowMod) MarshalBinary() (data []byte, err error) {
+	f.Header.Length = f.Len()
+	data = make([]byte, int(f.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	bytes, err = f.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint64(data[next:], f.Cookie)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], f.CookieMask)
+	next += 8
+	data[next] = f.TableId
+	next += 1
+	data[next] = f.Command
+	next += 1
+	binary.BigEndian.PutUint16(data[next:], f.IdleTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.HardTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.Priority)
+	next += 2
+	binary.BigEndian.PutUint32(data[next:], f.BufferId)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutPort)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutGroup)
+	next += 4
+	binary.BigEndian.PutUint16(data[next:], f.Flags)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.Importance)
+	next += 2
+
+	bytes, err = f.Match.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	for _, i := range f.Instructions {
+		bytes, err = i.MarshalBinary()
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (f *FlowMod) UnmarshalBinary(data []byte) error {
+	if len(data) < 56 {
+		return errors.New("The []byte is too short to unmarshal a full FlowMod message.")
+	}
+	next := 0
+	err := f.Header.UnmarshalBinary(data[next:])
+	next += int(f.Header.Len())
+	f.Cookie = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	f.CookieMask = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	f.TableId = data[next]
+	next += 1
+	f.Command = data[next]
+	next += 1
+	f.IdleTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.HardTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.Priority = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.BufferId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.OutPort = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.OutGroup = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.Importance = binary.BigEndian.Uint16(data[next:])
+	next += 2
+
+	if err = f.Match.UnmarshalBinary(data[next:]); err != nil {
+		return err
+	}
+	next += int(f.Match.Len())
+	f.Instructions, err = DecodeInstructions(data[next:])
+	return err
+}
+
+// ofp_flow_mod_command 1.4
+const (
+	FC_ADD = iota
+	FC_MODIFY
+	FC_MODIFY_STRICT
+	FC_DELETE
+	FC_DELETE_STRICT
+)
+
+// ofp_flow_mod_flags 1.4
+const (
+	FF_SEND_FLOW_REM = 1 << 0
+	FF_CHECK_OVERLAP = 1 << 1
+	FF_RESET_COUNTS  = 1 << 2
+	FF_NO_PKT_COUNTS = 1 << 3
+	FF_NO_BYT_COUNTS = 1 << 4
+)
+
+type Instruction interface {
+	util.Message
+	InstructionType() uint16
+}
+
+// Decodes a list of instructions. Unknown instruction types are
+// kept as an InstrRaw.
+func DecodeInstructions(data []byte) ([]Instruction, error) {
+	a := make([]Instruction, 0)
+	for n := 0; n+4 <= len(data); {
+		t := binary.BigEndian.Uint16(data[n:])
+		l := int(binary.BigEndian.Uint16(data[n+2:]))
+		if l < 4 || n+l > len(data) {
+			return a, errors.New("Instruction has an invalid length.")
+		}
+		var i Instruction
+		switch t {
+		case IT_GOTO_TABLE:
+			i = new(InstrGotoTable)
+		case IT_WRITE_METADATA:
+			i = new(InstrWriteMetadata)
+		case IT_WRITE_ACTIONS, IT_APPLY_ACTIONS, IT_CLEAR_ACTIONS:
+			i = new(InstrActions)
+		case IT_METER:
+			i = new(InstrMeter)
+		default:
+			i = new(InstrRaw)
+		}
+		if err := i.UnmarshalBinary(data[n : n+l]); err != nil {
+			return a, err
+		}
+		a = append(a, i)
+		n += l
+	}
+	return a, nil
+}
+
+// ofp_instruction_goto_table 1.4
+type InstrGotoTable struct {
+	TableId uint8
+}
+
+func NewInstrGotoTable(table uint8) *InstrGotoTable {
+	return &InstrGotoTable{table}
+}
+
+func (i *InstrGotoTable) InstructionType() uint16 { return IT_GOTO_TABLE }
+
+func (i *InstrGotoTable) Len() (n uint16) {
+	return 8
+}
+
+func (i *InstrGotoTable) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], IT_GOTO_TABLE)
+	binary.BigEndian.PutUint16(data[2:], 8)
+	data[4] = i.TableId
+	return
+}
+
+func (i *InstrGotoTable) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full InstrGotoTable.")
+	}
+	i.TableId = data[4]
+	return nil
+}
+
+// ofp_instruction_write_metadata 1.4
+type InstrWriteMetadata struct {
+	Metadata     uint64
+	MetadataMask uint64
+}
+
+func (i *InstrWriteMetadata) InstructionType() uint16 { return IT_WRITE_METADATA }
+
+func (i *InstrWriteMetadata) Len() (n uint16) {
+	return 24
+}
+
+func (i *InstrWriteMetadata) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 24)
+	binary.BigEndian.PutUint16(data[0:], IT_WRITE_METADATA)
+	binary.BigEndian.PutUint16(data[2:], 24)
+	binary.BigEndian.PutUint64(data[8:], i.Metadata)
+	binary.BigEndian.PutUint64(data[16:], i.MetadataMask)
+	return
+}
+
+func (i *InstrWriteMetadata) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return errors.New("The []byte is too short to unmarshal a full InstrWriteMetadata.")
+	}
+	i.Metadata = binary.BigEndian.Uint64(data[8:])
+	i.MetadataMask = binary.BigEndian.Uint64(data[16:])
+	return nil
+}
+
+// ofp_instruction_actions 1.4. Used for apply, write and clear
+// actions instructions.
+type InstrActions struct {
+	Type    uint16
+	Actions []Action
+}
+
+func NewInstrApplyActions() *InstrActions {
+	return &InstrActions{IT_APPLY_ACTIONS, make([]Action, 0)}
+}
+
+func NewInstrWriteActions() *InstrActions {
+	return &InstrActions{IT_WRITE_ACTIONS, make([]Action, 0)}
+}
+
+func NewInstrClearActions() *InstrActions {
+	return &InstrActions{IT_CLEAR_ACTIONS, make([]Action, 0)}
+}
+
+func (i *InstrActions) AddAction(a Action) {
+	i.Actions = append(i.Actions, a)
+}
+
+func (i *InstrActions) InstructionType() uint16 { return i.Type }
+
+func (i *InstrActions) Len() (n uint16) {
+	n = 8
+	for _, a := range i.Actions {
+		n += a.Len()
+	}
+	return
+}
+
+func (i *InstrActions) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(i.Len()))
+	binary.BigEndian.PutUint16(data[0:], i.Type)
+	binary.BigEndian.PutUint16(data[2:], i.Len())
+	next := 8
+	for _, a := range i.Actions {
+		bytes, err := a.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (i *InstrActions) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full InstrActions.")
+	}
+	i.Type = binary.BigEndian.Uint16(data[0:])
+	l := int(binary.BigEndian.Uint16(data[2:]))
+	if l < 8 || l > len(data) {
+		return errors.New("InstrActions has an invalid length.")
+	}
+	var err error
+	i.Actions, err = DecodeActions(data[8:l])
+	return err
+}
+
+// ofp_instruction_meter 1.4
+type InstrMeter struct {
+	MeterId uint32
+}
+
+func NewInstrMeter(id uint32) *InstrMeter {
+	return &InstrMeter{id}
+}
+
+func (i *InstrMeter) InstructionType() uint16 { return IT_METER }
+
+func (i *InstrMeter) Len() (n uint16) {
+	return 8
+}
+
+func (i *InstrMeter) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], IT_METER)
+	binary.BigEndian.PutUint16(data[2:], 8)
+	binary.BigEndian.PutUint32(data[4:], i.MeterId)
+	return
+}
+
+func (i *InstrMeter) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full InstrMeter.")
+	}
+	i.MeterId = binary.BigEndian.Uint32(data[4:])
+	return nil
+}
+
+// An instruction that isn't decoded, kept with its header.
+type InstrRaw struct {
+	util.Buffer
+}
+
+func (i *InstrRaw) InstructionType() uint16 {
+	b := i.Bytes()
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+type Action interface {
+	util.Message
+	ActionType() uint16
+}
+
+// Decodes a list of actions. Unknown action types are kept as an
+// ActionRaw.
+func DecodeActions(data []byte) ([]Action, error) {
+	a := make([]Action, 0)
+	for n := 0; n+4 <= len(data); {
+		t := binary.BigEndian.Uint16(data[n:])
+		l := int(binary.BigEndian.Uint16(data[n+2:]))
+		if l < 8 || n+l > len(data) {
+			return a, errors.New("Action has an invalid length.")
+		}
+		var act Action
+		switch t {
+		case AT_OUTPUT:
+			act = new(ActionOutput)
+		case AT_SET_QUEUE, AT_GROUP:
+			act = &ActionId{Type: t}
+		case AT_PUSH_VLAN, AT_PUSH_MPLS, AT_PUSH_PBB, AT_POP_MPLS:
+			act = &ActionEthertype{Type: t}
+		case AT_SET_FIELD:
+			act = new(ActionSetField)
+		default:
+			act = new(ActionRaw)
+		}
+		if err := act.UnmarshalBinary(data[n : n+l]); err != nil {
+			return a, err
+		}
+		a = append(a, act)
+		n += l
+	}
+	return a, nil
+}
+
+// ofp_action_output 1.4
+type ActionOutput struct {
+	Port   uint32
+	MaxLen uint16
+}
+
+// Returns an action that sends packets out port.
+func NewActionOutput(port uint32) *ActionOutput {
+	return &ActionOutput{port, CML_NO_BUFFER}
+}
+
+func (a *ActionOutput) ActionType() uint16 { return AT_OUTPUT }
+
+func (a *ActionOutput) Len() (n uint16) {
+	return 16
+}
+
+func (a *ActionOutput) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 16)
+	binary.BigEndian.PutUint16(data[0:], AT_OUTPUT)
+	binary.BigEndian.PutUint16(data[2:], 16)
+	binary.BigEndian.PutUint32(data[4:], a.Port)
+	binary.BigEndian.PutUint16(data[8:], a.MaxLen)
+	return
+}
+
+func (a *ActionOutput) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full ActionOutput.")
+	}
+	a.Port = binary.BigEndian.Uint32(data[4:])
+	a.MaxLen = binary.BigEndian.Uint16(data[8:])
+	return nil
+}
+
+// ofp_controller_max_len 1.4
+const (
+	CML_MAX       = 0xffe5
+	CML_NO_BUFFER = 0xffff
+)
+
+// Actions carrying a single 32 bit id: ofp_action_set_queue and
+// ofp_action_group 1.4.
+type ActionId struct {
+	Type uint16
+	Id   uint32
+}
+
+// Returns an action that sets the output queue of packets.
+func NewActionSetQueue(queue uint32) *ActionId {
+	return &ActionId{AT_SET_QUEUE, queue}
+}
+
+// Returns an action that sends packets to group.
+func NewActionGroup(group uint32) *ActionId {
+	return &ActionId{AT_GROUP, group}
+}
+
+func (a *ActionId) ActionType() uint16 { return a.Type }
+
+func (a *ActionId) Len() (n uint16) {
+	return 8
+}
+
+func (a *ActionId) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], a.Type)
+	binary.BigEndian.PutUint16(data[2:], 8)
+	binary.BigEndian.PutUint32(data[4:], a.Id)
+	return
+}
+
+func (a *ActionId) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full ActionId.")
+	}
+	a.Type = binary.BigEndian.Uint16(data[0:])
+	a.Id = binary.BigEndian.Uint32(data[4:])
+	return nil
+}
+
+// Push actions and pop MPLS, which carry an ethertype:
+// ofp_action_push and ofp_action_pop_mpls 1.4.
+type ActionEthertype struct {
+	Type      uint16
+	Ethertype uint16
+}
+
+// Returns an action that pushes a new VLAN tag.
+func NewActionPushVlan(ethertype uint16) *ActionEthertype {
+	return &ActionEthertype{AT_PUSH_VLAN, ethertype}
+}
+
+func (a *ActionEthertype) ActionType() uint16 { return a.Type }
+
+func (a *ActionEthertype) Len() (n uint16) {
+	return 8
+}
+
+func (a *ActionEthertype) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], a.Type)
+	binary.BigEndian.PutUint16(data[2:], 8)
+	binary.BigEndian.PutUint16(data[4:], a.Ethertype)
+	return
+}
+
+func (a *ActionEthertype) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full ActionEthertype.")
+	}
+	a.Type = binary.BigEndian.Uint16(data[0:])
+	a.Ethertype = binary.BigEndian.Uint16(data[4:])
+	return nil
+}
+
+// ofp_action_set_field 1.4. Field holds a single OXM TLV.
+type ActionSetField struct {
+	Field []byte
+}
+
+// Returns an action that sets OXM basic field to value.
+func NewActionSetField(field uint8, value []byte) *ActionSetField {
+	m := NewMatch()
+	m.AddField(field, value)
+	return &ActionSetField{m.Fields}
+}
+
+func (a *ActionSetField) ActionType() uint16 { return AT_SET_FIELD }
+
+func (a *ActionSetField) Len() (n uint16) {
+	return uint16((4 + len(a.Field) + 7) / 8 * 8)
+}
+
+func (a *ActionSetField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(a.Len()))
+	binary.BigEndian.PutUint16(data[0:], AT_SET_FIELD)
+	binary.BigEndian.PutUint16(data[2:], a.Len())
+	copy(data[4:], a.Field)
+	return
+}
+
+func (a *ActionSetField) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full ActionSetField.")
+	}
+	h := binary.BigEndian.Uint32(data[4:])
+	end := 8 + int(h&0xff)
+	if end > len(data) {
+		return errors.New("ActionSetField has an invalid OXM length.")
+	}
+	a.Field = append([]byte(nil), data[4:end]...)
+	return nil
+}
+
+// Actions without a body, like pop VLAN, and actions that
+// aren't decoded, kept with their header.
+type ActionRaw struct {
+	util.Buffer
+}
+
+// Returns an action that pops the outermost VLAN tag.
+func NewActionPopVlan() *ActionRaw {
+	a := new(ActionRaw)
+	a.UnmarshalBinary([]byte{0, AT_POP_VLAN, 0, 8, 0, 0, 0, 0})
+	return a
+}
+
+func (a *ActionRaw) ActionType() uint16 {
+	b := a.Bytes()
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
@@ -0,0 +1,71 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var flowModHex = "   05 0e 00 58 00 00 00 00" + // Header
+	"00 00 00 00 00 00 00 00" + // Cookie
+	"00 00 00 00 00 00 00 00" + // Cookie mask
+	"00 00 00 00 00 00 03 e8" + // Table, command, timeouts, priority
+	"ff ff ff ff ff ff ff ff" + // Buffer id, out port
+	"ff ff ff ff 00 00 00 00" + // Out group, flags, importance
+	"00 01 00 0c 80 00 00 04" + // Match in_port
+	"00 00 00 01 00 00 00 00" +
+	"00 04 00 18 00 00 00 00" + // Apply actions
+	"00 00 00 10 00 00 00 02" + // Output port 2
+	"ff ff 00 00 00 00 00 00"
+
+func TestFlowModMarshalBinary(t *testing.T) {
+	b := strings.Replace(flowModHex, " ", "", -1)
+
+	f := NewFlowMod()
+	f.Header.Xid = 0
+	f.Match.AddField(XMT_OFB_IN_PORT, []byte{0, 0, 0, 1})
+	i := NewInstrApplyActions()
+	i.AddAction(NewActionOutput(2))
+	f.AddInstruction(i)
+	data, _ := f.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestFlowModUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(flowModHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	f := new(FlowMod)
+	if err := f.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if f.Priority != 1000 || f.OutPort != P_ANY {
+		t.Errorf("Got priority %d out port %x.", f.Priority, f.OutPort)
+	}
+	ids := f.Match.FieldIds()
+	if len(ids) != 1 || ids[0] != OxmId(XMT_OFB_IN_PORT) {
+		t.Errorf("Got match fields %x.", ids)
+	}
+	if v, ok := f.Match.Field(XMT_OFB_IN_PORT); !ok || binary.BigEndian.Uint32(v) != 1 {
+		t.Errorf("Got in port %x.", v)
+	}
+	if _, ok := f.Match.Field(XMT_OFB_ETH_TYPE); ok {
+		t.Error("Found a field that isn't in the match.")
+	}
+	if len(f.Instructions) != 1 {
+		t.Fatalf("Got %d instructions, expected 1.", len(f.Instructions))
+	}
+	i, ok := f.Instructions[0].(*InstrActions)
+	if !ok || i.Type != IT_APPLY_ACTIONS || len(i.Actions) != 1 {
+		t.Fatalf("Got instruction %+v.", f.Instructions[0])
+	}
+	if o, ok := i.Actions[0].(*ActionOutput); !ok || o.Port != 2 {
+		t.Errorf("Got action %+v.", i.Actions[0])
+	}
+}
@@ -0,0 +1,215 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Body of a MultipartType_Flow request. Selects the flows in
+// table TableId matching Match whose cookie equals Cookie in the
+// bits set in CookieMask.
+// ofp_flow_stats_request 1.4
+type FlowStatsRequest struct {
+	TableId    uint8
+	OutPort    uint32
+	OutGroup   uint32
+	Cookie     uint64
+	CookieMask uint64
+	Match      Match
+}
+
+// Returns a request for every flow of every table.
+func NewFlowStatsRequest() *FlowStatsRequest {
+	f := new(FlowStatsRequest)
+	f.TableId = TT_ALL
+	f.OutPort = P_ANY
+	f.OutGroup = G_ANY
+	f.Match = *NewMatch()
+	return f
+}
+
+func (f *FlowStatsRequest) Len() (n uint16) {
+	return 32 + f.Match.Len()
+}
+
+func (f *FlowStatsRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(f.Len()))
+	next := 0
+	data[next] = f.TableId
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutPort)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutGroup)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], f.Cookie)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], f.CookieMask)
+	next += 8
+
+	bytes, err := f.Match.MarshalBinary()
+	copy(data[next:], bytes)
+	return
+}
+
+func (f *FlowStatsRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 40 {
+		return errors.New("The []byte is too short to unmarshal a full FlowStatsRequest.")
+	}
+	next := 0
+	f.TableId = data[next]
+	next += 4
+	f.OutPort = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.OutGroup = binary.BigEndian.Uint32(data[next:])
+	next += 8
+	f.Cookie = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	f.CookieMask = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	return f.Match.UnmarshalBinary(data[next:])
+}
+
+// The body of a MultipartType_Flow reply.
+type FlowStatsReply struct {
+	Flows []FlowStats
+}
+
+func (r *FlowStatsReply) Len() (n uint16) {
+	for i := range r.Flows {
+		n += r.Flows[i].Len()
+	}
+	return
+}
+
+func (r *FlowStatsReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(r.Len()))
+	for i := range r.Flows {
+		b, err := r.Flows[i].MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (r *FlowStatsReply) UnmarshalBinary(data []byte) error {
+	r.Flows = make([]FlowStats, 0)
+	for next := 0; next+2 <= len(data); {
+		length := int(binary.BigEndian.Uint16(data[next:]))
+		if length < 56 || next+length > len(data) {
+			return errors.New("The []byte is too short to unmarshal a full FlowStats.")
+		}
+		f := FlowStats{}
+		if err := f.UnmarshalBinary(data[next : next+length]); err != nil {
+			return err
+		}
+		r.Flows = append(r.Flows, f)
+		next += length
+	}
+	return nil
+}
+
+// ofp_flow_stats 1.4
+type FlowStats struct {
+	TableId      uint8
+	DurationSec  uint32
+	DurationNSec uint32
+	Priority     uint16
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Flags        uint16
+	Importance   uint16
+	Cookie       uint64
+	PacketCount  uint64
+	ByteCount    uint64
+	Match        Match
+	Instructions []Instruction
+}
+
+func (f *FlowStats) Len() (n uint16) {
+	n = 48 + f.Match.Len()
+	for _, i := range f.Instructions {
+		n += i.Len()
+	}
+	return
+}
+
+func (f *FlowStats) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(f.Len()))
+	next := 0
+	binary.BigEndian.PutUint16(data[next:], f.Len())
+	next += 2
+	data[next] = f.TableId
+	next += 2
+	binary.BigEndian.PutUint32(data[next:], f.DurationSec)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.DurationNSec)
+	next += 4
+	binary.BigEndian.PutUint16(data[next:], f.Priority)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.IdleTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.HardTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.Flags)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], f.Importance)
+	next += 4
+	binary.BigEndian.PutUint64(data[next:], f.Cookie)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], f.PacketCount)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], f.ByteCount)
+	next += 8
+
+	bytes, err := f.Match.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	for _, i := range f.Instructions {
+		bytes, err = i.MarshalBinary()
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (f *FlowStats) UnmarshalBinary(data []byte) error {
+	if len(data) < 56 {
+		return errors.New("The []byte is too short to unmarshal a full FlowStats.")
+	}
+	next := 2
+	f.TableId = data[next]
+	next += 2
+	f.DurationSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.DurationNSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.Priority = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.IdleTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.HardTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.Importance = binary.BigEndian.Uint16(data[next:])
+	next += 4
+	f.Cookie = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	f.PacketCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	f.ByteCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+
+	if err := f.Match.UnmarshalBinary(data[next:]); err != nil {
+		return err
+	}
+	next += int(f.Match.Len())
+	if next > len(data) {
+		return errors.New("FlowStats has an invalid length.")
+	}
+	var err error
+	f.Instructions, err = DecodeInstructions(data[next:])
+	return err
+}
@@ -0,0 +1,61 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var flowStatsReplyHex = "   05 13 00 68 00 00 00 00" + // Header
+	"00 01 00 00 00 00 00 00" + // Type, flags
+	"00 58 00 00 00 00 00 0a" + // Length, table, duration
+	"00 00 00 00 03 e8 00 00" + // Priority, idle timeout
+	"00 00 00 00 00 00 00 00" + // Hard timeout, flags, importance
+	"00 00 00 00 00 00 00 07" + // Cookie
+	"00 00 00 00 00 00 00 03" + // Packet count
+	"00 00 00 00 00 00 01 00" + // Byte count
+	"00 01 00 0c 80 00 00 04" + // Match in_port
+	"00 00 00 01 00 00 00 00" +
+	"00 04 00 18 00 00 00 00" + // Apply actions
+	"00 00 00 10 00 00 00 02" + // Output port 2
+	"ff ff 00 00 00 00 00 00"
+
+func TestFlowStatsReplyUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(flowStatsReplyHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	m := new(MultipartReply)
+	if err := m.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := m.Body.(*FlowStatsReply)
+	if !ok || len(r.Flows) != 1 {
+		t.Fatalf("Got body %+v.", m.Body)
+	}
+	f := r.Flows[0]
+	if f.Priority != 1000 || f.Cookie != 7 || f.PacketCount != 3 || f.ByteCount != 256 {
+		t.Errorf("Got flow %+v.", f)
+	}
+	if len(f.Instructions) != 1 {
+		t.Fatalf("Got instructions %+v.", f.Instructions)
+	}
+
+	data, _ := m.MarshalBinary()
+	if d := hex.EncodeToString(data); d != hex.EncodeToString(bytes) {
+		t.Log("Exp:", hex.EncodeToString(bytes))
+		t.Log("Rec:", d)
+		t.Error("Marshaled reply differs.")
+	}
+}
+
+func TestFlowStatsRequestMarshalBinary(t *testing.T) {
+	b := "ff000000ffffffffffffffff00000000" +
+		"0000000000000000" + "0000000000000000" +
+		"0001000400000000"
+	data, _ := NewFlowStatsRequest().MarshalBinary()
+	if d := hex.EncodeToString(data); d != b {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
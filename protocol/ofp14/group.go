@@ -0,0 +1,310 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// ofp_group_mod 1.4
+type GroupMod struct {
+	ofpxx.Header
+	Command uint16
+	Type    uint8
+	pad     uint8
+	GroupId uint32
+	Buckets []Bucket
+}
+
+func NewGroupMod(command uint16, t uint8, id uint32) *GroupMod {
+	g := new(GroupMod)
+	g.Header = ofpxx.NewOfp14Header()
+	g.Header.Type = Type_GroupMod
+	g.Command = command
+	g.Type = t
+	g.GroupId = id
+	g.Buckets = make([]Bucket, 0)
+	return g
+}
+
+func (g *GroupMod) AddBucket(b Bucket) {
+	g.Buckets = append(g.Buckets, b)
+}
+
+func (g *GroupMod) Len() (n uint16) {
+	n = g.Header.Len() + 8
+	for _, b := range g.Buckets {
+		n += b.Len()
+	}
+	return
+}
+
+func (g *GroupMod) MarshalBinary() (data []byte, err error) {
+	g.Header.Length = g.Len()
+	data = make([]byte, int(g.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	bytes, err = g.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint16(data[next:], g.Command)
+	next += 2
+	data[next] = g.Type
+	next += 2 // Type and pad
+	binary.BigEndian.PutUint32(data[next:], g.GroupId)
+	next += 4
+	for _, b := range g.Buckets {
+		bytes, err = b.MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (g *GroupMod) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full GroupMod message.")
+	}
+	next := 0
+	err := g.Header.UnmarshalBinary(data[next:])
+	if err != nil {
+		return err
+	}
+	next += int(g.Header.Len())
+	g.Command = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	g.Type = data[next]
+	next += 2
+	g.GroupId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	g.Buckets = make([]Bucket, 0)
+	for next+16 <= len(data) {
+		b := Bucket{}
+		if err = b.UnmarshalBinary(data[next:]); err != nil {
+			return err
+		}
+		g.Buckets = append(g.Buckets, b)
+		next += int(b.Len())
+	}
+	return nil
+}
+
+// ofp_bucket 1.4. WatchPort and WatchGroup are only used by fast
+// failover groups.
+type Bucket struct {
+	Weight     uint16
+	WatchPort  uint32
+	WatchGroup uint32
+	Actions    []Action
+}
+
+func NewBucket() Bucket {
+	return Bucket{0, P_ANY, G_ANY, make([]Action, 0)}
+}
+
+func (b *Bucket) AddAction(a Action) {
+	b.Actions = append(b.Actions, a)
+}
+
+func (b *Bucket) Len() (n uint16) {
+	n = 16
+	for _, a := range b.Actions {
+		n += a.Len()
+	}
+	return
+}
+
+func (b *Bucket) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(b.Len()))
+	binary.BigEndian.PutUint16(data[0:], b.Len())
+	binary.BigEndian.PutUint16(data[2:], b.Weight)
+	binary.BigEndian.PutUint32(data[4:], b.WatchPort)
+	binary.BigEndian.PutUint32(data[8:], b.WatchGroup)
+	next := 16
+	for _, a := range b.Actions {
+		bytes, err := a.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (b *Bucket) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full Bucket.")
+	}
+	l := int(binary.BigEndian.Uint16(data[0:]))
+	if l < 16 || l > len(data) {
+		return errors.New("Bucket has an invalid length.")
+	}
+	b.Weight = binary.BigEndian.Uint16(data[2:])
+	b.WatchPort = binary.BigEndian.Uint32(data[4:])
+	b.WatchGroup = binary.BigEndian.Uint32(data[8:])
+	var err error
+	b.Actions, err = DecodeActions(data[16:l])
+	return err
+}
+
+// ofp_group_mod_command 1.4
+const (
+	GC_ADD = iota
+	GC_MODIFY
+	GC_DELETE
+)
+
+// ofp_group_type 1.4
+const (
+	GT_ALL = iota
+	GT_SELECT
+	GT_INDIRECT
+	GT_FF
+)
+
+// ofp_group_multipart_request 1.4
+type GroupMultipartRequest struct {
+	GroupId uint32
+}
+
+func (g *GroupMultipartRequest) Len() (n uint16) {
+	return 8
+}
+
+func (g *GroupMultipartRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint32(data, g.GroupId)
+	return
+}
+
+func (g *GroupMultipartRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full GroupMultipartRequest.")
+	}
+	g.GroupId = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// The body of a MultipartType_Group reply.
+type GroupStatsReply struct {
+	Stats []GroupStats
+}
+
+func (r *GroupStatsReply) Len() (n uint16) {
+	for _, s := range r.Stats {
+		n += s.Len()
+	}
+	return
+}
+
+func (r *GroupStatsReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(r.Len()))
+	for _, s := range r.Stats {
+		b, err := s.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (r *GroupStatsReply) UnmarshalBinary(data []byte) error {
+	r.Stats = make([]GroupStats, 0)
+	for next := 0; next+40 <= len(data); {
+		s := GroupStats{}
+		if err := s.UnmarshalBinary(data[next:]); err != nil {
+			return err
+		}
+		r.Stats = append(r.Stats, s)
+		next += int(s.Len())
+	}
+	return nil
+}
+
+// ofp_group_stats 1.4
+type GroupStats struct {
+	GroupId      uint32
+	RefCount     uint32
+	PacketCount  uint64
+	ByteCount    uint64
+	DurationSec  uint32
+	DurationNSec uint32
+	Buckets      []BucketCounter
+}
+
+// ofp_bucket_counter 1.4
+type BucketCounter struct {
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+func (s *GroupStats) Len() (n uint16) {
+	return 40 + uint16(16*len(s.Buckets))
+}
+
+func (s *GroupStats) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(s.Len()))
+	next := 0
+	binary.BigEndian.PutUint16(data[next:], s.Len())
+	next += 4 // len and pad
+	binary.BigEndian.PutUint32(data[next:], s.GroupId)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], s.RefCount)
+	next += 8 // ref count and pad
+	binary.BigEndian.PutUint64(data[next:], s.PacketCount)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], s.ByteCount)
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], s.DurationSec)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], s.DurationNSec)
+	next += 4
+	for _, b := range s.Buckets {
+		binary.BigEndian.PutUint64(data[next:], b.PacketCount)
+		next += 8
+		binary.BigEndian.PutUint64(data[next:], b.ByteCount)
+		next += 8
+	}
+	return
+}
+
+func (s *GroupStats) UnmarshalBinary(data []byte) error {
+	if len(data) < 40 {
+		return errors.New("The []byte is too short to unmarshal a full GroupStats.")
+	}
+	next := 0
+	l := int(binary.BigEndian.Uint16(data[next:]))
+	next += 4
+	if l < 40 || l > len(data) {
+		return errors.New("GroupStats has an invalid length.")
+	}
+	s.GroupId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.RefCount = binary.BigEndian.Uint32(data[next:])
+	next += 8
+	s.PacketCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	s.ByteCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	s.DurationSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.DurationNSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.Buckets = make([]BucketCounter, 0)
+	for next+16 <= l {
+		b := BucketCounter{}
+		b.PacketCount = binary.BigEndian.Uint64(data[next:])
+		next += 8
+		b.ByteCount = binary.BigEndian.Uint64(data[next:])
+		next += 8
+		s.Buckets = append(s.Buckets, b)
+	}
+	return nil
+}
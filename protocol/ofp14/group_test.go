@@ -0,0 +1,88 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var groupModHex = "   05 0f 00 50 00 00 00 00" + // Header
+	"00 00 00 00 00 00 00 07" + // Command, type, group id
+	"00 20 00 00 ff ff ff ff" + // Bucket 1
+	"ff ff ff ff 00 00 00 00" +
+	"00 00 00 10 00 00 00 01" + // Output port 1
+	"ff ff 00 00 00 00 00 00" +
+	"00 20 00 00 ff ff ff ff" + // Bucket 2
+	"ff ff ff ff 00 00 00 00" +
+	"00 00 00 10 00 00 00 02" + // Output port 2
+	"ff ff 00 00 00 00 00 00"
+
+func TestGroupModMarshalBinary(t *testing.T) {
+	b := strings.Replace(groupModHex, " ", "", -1)
+
+	g := NewGroupMod(GC_ADD, GT_ALL, 7)
+	g.Header.Xid = 0
+	for _, p := range []uint32{1, 2} {
+		bucket := NewBucket()
+		bucket.AddAction(NewActionOutput(p))
+		g.AddBucket(bucket)
+	}
+	data, _ := g.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestGroupModUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(groupModHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	g := new(GroupMod)
+	if err := g.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if g.GroupId != 7 || g.Type != GT_ALL || len(g.Buckets) != 2 {
+		t.Fatalf("Got group %d type %d with %d buckets.", g.GroupId, g.Type, len(g.Buckets))
+	}
+	out, ok := g.Buckets[1].Actions[0].(*ActionOutput)
+	if !ok || out.Port != 2 {
+		t.Errorf("Got bucket action %v, expected output to port 2.", g.Buckets[1].Actions[0])
+	}
+}
+
+var groupStatsHex = "00 48 00 00 00 00 00 07" + // Length, group id
+	"00 00 00 01 00 00 00 00" + // Ref count
+	"00 00 00 00 00 00 00 03" + // Packets
+	"00 00 00 00 00 00 01 2c" + // Bytes
+	"00 00 00 0a 00 00 00 00" + // Duration
+	"00 00 00 00 00 00 00 01" + // Bucket 1
+	"00 00 00 00 00 00 00 64" +
+	"00 00 00 00 00 00 00 02" + // Bucket 2
+	"00 00 00 00 00 00 00 c8"
+
+func TestGroupStatsReplyUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(groupStatsHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	r := new(GroupStatsReply)
+	if err := r.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Stats) != 1 || r.Stats[0].GroupId != 7 || r.Stats[0].ByteCount != 300 {
+		t.Fatalf("Got %+v, expected group 7 with 300 bytes.", r.Stats)
+	}
+	buckets := r.Stats[0].Buckets
+	if len(buckets) != 2 || buckets[1].PacketCount != 2 || buckets[1].ByteCount != 200 {
+		t.Errorf("Got buckets %+v.", buckets)
+	}
+
+	data, _ := r.MarshalBinary()
+	if d := hex.EncodeToString(data); d != b {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Error("Marshaled stats differ.")
+	}
+}
@@ -0,0 +1,28 @@
+package ofp14
+
+import (
+	"net"
+)
+
+type ErrorReactor interface {
+	Error(dpid net.HardwareAddr, err *ErrorMsg)
+}
+
+type BundleCtrlReactor interface {
+	BundleCtrl(dpid net.HardwareAddr, ctrl *BundleCtrl)
+}
+
+type MultipartReplyReactor interface {
+	MultipartReply(dpid net.HardwareAddr, rep *MultipartReply)
+}
+
+type FlowMonitorReactor interface {
+	FlowMonitor(dpid net.HardwareAddr, rep *FlowMonitorReply)
+}
+
+// FlowChanged is called for every flow added, modified or
+// removed on a monitored switch, including flows installed by
+// other controllers. event is one of FME_*.
+type FlowChangedReactor interface {
+	FlowChanged(dpid net.HardwareAddr, event uint16, flow *FlowUpdateFull)
+}
@@ -0,0 +1,296 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// ofp_meter_mod 1.4
+type MeterMod struct {
+	ofpxx.Header
+	Command uint16
+	Flags   uint16
+	MeterId uint32
+	Bands   []MeterBand
+}
+
+func NewMeterMod(command uint16, id uint32) *MeterMod {
+	m := new(MeterMod)
+	m.Header = ofpxx.NewOfp14Header()
+	m.Header.Type = Type_MeterMod
+	m.Command = command
+	m.Flags = MF_KBPS | MF_STATS
+	m.MeterId = id
+	m.Bands = make([]MeterBand, 0)
+	return m
+}
+
+func (m *MeterMod) AddBand(b MeterBand) {
+	m.Bands = append(m.Bands, b)
+}
+
+func (m *MeterMod) Len() (n uint16) {
+	return m.Header.Len() + 8 + uint16(16*len(m.Bands))
+}
+
+func (m *MeterMod) MarshalBinary() (data []byte, err error) {
+	m.Header.Length = m.Len()
+	data = make([]byte, int(m.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	bytes, err = m.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint16(data[next:], m.Command)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], m.Flags)
+	next += 2
+	binary.BigEndian.PutUint32(data[next:], m.MeterId)
+	next += 4
+	for _, b := range m.Bands {
+		bytes, err = b.MarshalBinary()
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (m *MeterMod) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full MeterMod message.")
+	}
+	next := 0
+	err := m.Header.UnmarshalBinary(data[next:])
+	next += int(m.Header.Len())
+	m.Command = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.MeterId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	m.Bands = make([]MeterBand, 0)
+	for next+16 <= len(data) {
+		b := MeterBand{}
+		b.UnmarshalBinary(data[next:])
+		m.Bands = append(m.Bands, b)
+		next += 16
+	}
+	return err
+}
+
+// ofp_meter_band_drop and ofp_meter_band_dscp_remark 1.4.
+// PrecLevel is only used by DSCP remark bands.
+type MeterBand struct {
+	Type      uint16
+	Rate      uint32
+	BurstSize uint32
+	PrecLevel uint8
+}
+
+// Returns a band that drops packets above rate.
+func NewMeterBandDrop(rate, burst uint32) MeterBand {
+	return MeterBand{MBT_DROP, rate, burst, 0}
+}
+
+func (b *MeterBand) Len() (n uint16) {
+	return 16
+}
+
+func (b *MeterBand) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 16)
+	binary.BigEndian.PutUint16(data[0:], b.Type)
+	binary.BigEndian.PutUint16(data[2:], 16)
+	binary.BigEndian.PutUint32(data[4:], b.Rate)
+	binary.BigEndian.PutUint32(data[8:], b.BurstSize)
+	if b.Type == MBT_DSCP_REMARK {
+		data[12] = b.PrecLevel
+	}
+	return
+}
+
+func (b *MeterBand) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full MeterBand.")
+	}
+	b.Type = binary.BigEndian.Uint16(data[0:])
+	b.Rate = binary.BigEndian.Uint32(data[4:])
+	b.BurstSize = binary.BigEndian.Uint32(data[8:])
+	if b.Type == MBT_DSCP_REMARK {
+		b.PrecLevel = data[12]
+	}
+	return nil
+}
+
+// ofp_meter_mod_command 1.4
+const (
+	MC_ADD = iota
+	MC_MODIFY
+	MC_DELETE
+)
+
+// ofp_meter_flags 1.4
+const (
+	MF_KBPS  = 1 << 0
+	MF_PKTPS = 1 << 1
+	MF_BURST = 1 << 2
+	MF_STATS = 1 << 3
+)
+
+// ofp_meter_band_type 1.4
+const (
+	MBT_DROP         = 1
+	MBT_DSCP_REMARK  = 2
+	MBT_EXPERIMENTER = 0xffff
+)
+
+// ofp_meter 1.4
+const (
+	M_MAX        = 0xffff0000
+	M_SLOWPATH   = 0xfffffffd
+	M_CONTROLLER = 0xfffffffe
+	M_ALL        = 0xffffffff
+)
+
+// ofp_meter_multipart_request 1.4
+type MeterMultipartRequest struct {
+	MeterId uint32
+}
+
+func (m *MeterMultipartRequest) Len() (n uint16) {
+	return 8
+}
+
+func (m *MeterMultipartRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint32(data, m.MeterId)
+	return
+}
+
+func (m *MeterMultipartRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full MeterMultipartRequest.")
+	}
+	m.MeterId = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// The body of a MultipartType_Meter reply.
+type MeterStatsReply struct {
+	Stats []MeterStats
+}
+
+func (r *MeterStatsReply) Len() (n uint16) {
+	for _, s := range r.Stats {
+		n += s.Len()
+	}
+	return
+}
+
+func (r *MeterStatsReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(r.Len()))
+	for _, s := range r.Stats {
+		b, err := s.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (r *MeterStatsReply) UnmarshalBinary(data []byte) error {
+	r.Stats = make([]MeterStats, 0)
+	for next := 0; next+40 <= len(data); {
+		s := MeterStats{}
+		if err := s.UnmarshalBinary(data[next:]); err != nil {
+			return err
+		}
+		r.Stats = append(r.Stats, s)
+		next += int(s.Len())
+	}
+	return nil
+}
+
+// ofp_meter_stats 1.4
+type MeterStats struct {
+	MeterId       uint32
+	FlowCount     uint32
+	PacketInCount uint64
+	ByteInCount   uint64
+	DurationSec   uint32
+	DurationNSec  uint32
+	Bands         []MeterBandStats
+}
+
+// ofp_meter_band_stats 1.4
+type MeterBandStats struct {
+	PacketBandCount uint64
+	ByteBandCount   uint64
+}
+
+func (s *MeterStats) Len() (n uint16) {
+	return 40 + uint16(16*len(s.Bands))
+}
+
+func (s *MeterStats) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(s.Len()))
+	next := 0
+	binary.BigEndian.PutUint32(data[next:], s.MeterId)
+	next += 4
+	binary.BigEndian.PutUint16(data[next:], s.Len())
+	next += 8 // len and pad
+	binary.BigEndian.PutUint32(data[next:], s.FlowCount)
+	next += 4
+	binary.BigEndian.PutUint64(data[next:], s.PacketInCount)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], s.ByteInCount)
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], s.DurationSec)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], s.DurationNSec)
+	next += 4
+	for _, b := range s.Bands {
+		binary.BigEndian.PutUint64(data[next:], b.PacketBandCount)
+		next += 8
+		binary.BigEndian.PutUint64(data[next:], b.ByteBandCount)
+		next += 8
+	}
+	return
+}
+
+func (s *MeterStats) UnmarshalBinary(data []byte) error {
+	if len(data) < 40 {
+		return errors.New("The []byte is too short to unmarshal a full MeterStats.")
+	}
+	next := 0
+	s.MeterId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	l := int(binary.BigEndian.Uint16(data[next:]))
+	next += 8
+	if l < 40 || l > len(data) {
+		return errors.New("MeterStats has an invalid length.")
+	}
+	s.FlowCount = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.PacketInCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	s.ByteInCount = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	s.DurationSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.DurationNSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	s.Bands = make([]MeterBandStats, 0)
+	for next+16 <= l {
+		b := MeterBandStats{}
+		b.PacketBandCount = binary.BigEndian.Uint64(data[next:])
+		next += 8
+		b.ByteBandCount = binary.BigEndian.Uint64(data[next:])
+		next += 8
+		s.Bands = append(s.Bands, b)
+	}
+	return nil
+}
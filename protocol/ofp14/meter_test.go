@@ -0,0 +1,55 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var meterModHex = "   05 1d 00 30 00 00 00 00" + // Header
+	"00 00 00 09 00 00 00 07" + // Command, flags, meter id
+	"00 01 00 10 00 00 03 e8" + // Drop band, rate 1000
+	"00 00 00 64 00 00 00 00" + // Burst 100
+	"00 02 00 10 00 00 01 f4" + // DSCP remark band, rate 500
+	"00 00 00 32 02 00 00 00" // Burst 50, precedence 2
+
+func TestMeterModMarshalBinary(t *testing.T) {
+	b := strings.Replace(meterModHex, " ", "", -1)
+
+	m := NewMeterMod(MC_ADD, 7)
+	m.Header.Xid = 0
+	m.AddBand(NewMeterBandDrop(1000, 100))
+	m.AddBand(MeterBand{MBT_DSCP_REMARK, 500, 50, 2})
+	data, _ := m.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestMeterModUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(meterModHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	m := new(MeterMod)
+	if err := m.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	if m.Command != MC_ADD || m.Flags != MF_KBPS|MF_STATS || m.MeterId != 7 {
+		t.Errorf("Got command %d flags %x meter %d.", m.Command, m.Flags, m.MeterId)
+	}
+	exp := []MeterBand{{MBT_DROP, 1000, 100, 0}, {MBT_DSCP_REMARK, 500, 50, 2}}
+	if len(m.Bands) != len(exp) {
+		t.Fatalf("Got %d bands.", len(m.Bands))
+	}
+	for i, band := range m.Bands {
+		if band != exp[i] {
+			t.Errorf("Band %d is %+v, expected %+v.", i, band, exp[i])
+		}
+	}
+	if err := m.UnmarshalBinary(bytes[:12]); err == nil {
+		t.Error("Unmarshalled a truncated meter mod.")
+	}
+}
@@ -0,0 +1,384 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Body of a MultipartType_FlowMonitor request. Adds, modifies
+// or removes a flow monitor identified by MonitorId. Monitors
+// report changes to flows matching Match in table TableId.
+// ofp_flow_monitor_request 1.4
+type FlowMonitorRequest struct {
+	MonitorId uint32
+	OutPort   uint32
+	OutGroup  uint32
+	Flags     uint16 // Bitmap of FMF_* flags
+	TableId   uint8
+	Command   uint8 // One of FMC_*
+	Match     Match
+}
+
+// Returns a request that adds monitor id, reporting every
+// change to every flow table.
+func NewFlowMonitorRequest(id uint32) *FlowMonitorRequest {
+	f := new(FlowMonitorRequest)
+	f.MonitorId = id
+	f.OutPort = P_ANY
+	f.OutGroup = G_ANY
+	f.Flags = FMF_INITIAL | FMF_ADD | FMF_REMOVED | FMF_MODIFY
+	f.TableId = TT_ALL
+	f.Command = FMC_ADD
+	f.Match = *NewMatch()
+	return f
+}
+
+func (f *FlowMonitorRequest) Len() (n uint16) {
+	return 16 + f.Match.Len()
+}
+
+func (f *FlowMonitorRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(f.Len()))
+	next := 0
+	binary.BigEndian.PutUint32(data[next:], f.MonitorId)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutPort)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], f.OutGroup)
+	next += 4
+	binary.BigEndian.PutUint16(data[next:], f.Flags)
+	next += 2
+	data[next] = f.TableId
+	next += 1
+	data[next] = f.Command
+	next += 1
+
+	bytes, err := f.Match.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	return
+}
+
+func (f *FlowMonitorRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"FlowMonitorRequest.")
+	}
+	next := 0
+	f.MonitorId = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.OutPort = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.OutGroup = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	f.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	f.TableId = data[next]
+	next += 1
+	f.Command = data[next]
+	next += 1
+	return f.Match.UnmarshalBinary(data[next:])
+}
+
+// ofp_flow_monitor_command 1.4
+const (
+	FMC_ADD = iota
+	FMC_MODIFY
+	FMC_DELETE
+)
+
+// ofp_flow_monitor_flags 1.4
+const (
+	FMF_INITIAL      = 1 << 0 /* Initially matching flows. */
+	FMF_ADD          = 1 << 1 /* New matching flows as they are added. */
+	FMF_REMOVED      = 1 << 2 /* Old matching flows as they are removed. */
+	FMF_MODIFY       = 1 << 3 /* Matching flows as they are changed. */
+	FMF_INSTRUCTIONS = 1 << 4 /* If set, instructions are included. */
+	FMF_NO_ABBREV    = 1 << 5 /* If set, include own changes in full. */
+	FMF_ONLY_OWN     = 1 << 6 /* If set, don't include other controllers. */
+)
+
+// ofp_flow_update_event 1.4
+const (
+	FME_INITIAL  = iota /* Flow present when flow monitor created. */
+	FME_ADDED           /* Flow was added. */
+	FME_REMOVED         /* Flow was removed. */
+	FME_MODIFIED        /* Flow instructions were changed. */
+	FME_ABBREV          /* Abbreviated reply. */
+	FME_PAUSED          /* Monitoring paused (out of buffer space). */
+	FME_RESUMED         /* Monitoring resumed. */
+)
+
+// Body of a MultipartType_FlowMonitor reply. A switch sends
+// these replies both in answer to a FlowMonitorRequest and
+// asynchronously as monitored flows change.
+type FlowMonitorReply struct {
+	Updates []FlowUpdate
+}
+
+func (f *FlowMonitorReply) Len() (n uint16) {
+	for _, u := range f.Updates {
+		n += u.Len()
+	}
+	return
+}
+
+func (f *FlowMonitorReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(f.Len()))
+	for _, u := range f.Updates {
+		bytes, err := u.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, bytes...)
+	}
+	return
+}
+
+func (f *FlowMonitorReply) UnmarshalBinary(data []byte) error {
+	f.Updates = make([]FlowUpdate, 0)
+	next := 0
+	for next+4 <= len(data) {
+		u, err := DecodeFlowUpdate(data[next:])
+		if err != nil {
+			return err
+		}
+		f.Updates = append(f.Updates, u)
+		next += int(u.Header().Length)
+	}
+	return nil
+}
+
+type FlowUpdate interface {
+	Header() *FlowUpdateHeader
+	util.Message
+}
+
+// ofp_flow_update_header 1.4
+type FlowUpdateHeader struct {
+	Length uint16
+	Event  uint16 // One of FME_*
+}
+
+func (h *FlowUpdateHeader) Header() *FlowUpdateHeader {
+	return h
+}
+
+func (h *FlowUpdateHeader) Len() (n uint16) {
+	return 4
+}
+
+func (h *FlowUpdateHeader) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], h.Length)
+	binary.BigEndian.PutUint16(data[2:], h.Event)
+	return
+}
+
+func (h *FlowUpdateHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"FlowUpdateHeader.")
+	}
+	h.Length = binary.BigEndian.Uint16(data[0:])
+	h.Event = binary.BigEndian.Uint16(data[2:])
+	return nil
+}
+
+// Decodes the flow update at the start of data based on its
+// event type.
+func DecodeFlowUpdate(data []byte) (FlowUpdate, error) {
+	h := new(FlowUpdateHeader)
+	if err := h.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if h.Length < 4 || int(h.Length) > len(data) {
+		return nil, errors.New("FlowUpdate has an invalid length.")
+	}
+
+	var u FlowUpdate
+	switch h.Event {
+	case FME_INITIAL, FME_ADDED, FME_REMOVED, FME_MODIFIED:
+		u = new(FlowUpdateFull)
+	case FME_ABBREV:
+		u = new(FlowUpdateAbbrev)
+	case FME_PAUSED, FME_RESUMED:
+		u = new(FlowUpdatePaused)
+	default:
+		return nil, errors.New("FlowUpdate has an unknown event type.")
+	}
+	err := u.UnmarshalBinary(data[:h.Length])
+	return u, err
+}
+
+// Sent for FME_INITIAL, FME_ADDED, FME_REMOVED and FME_MODIFIED
+// events. Instructions are only present when the monitor was
+// created with FMF_INSTRUCTIONS and are not decoded.
+// ofp_flow_update_full 1.4
+type FlowUpdateFull struct {
+	FlowUpdateHeader
+	TableId      uint8
+	Reason       uint8 // One of FRR_* for FME_REMOVED events
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Priority     uint16
+	pad          []uint8 // Size 4
+	Cookie       uint64
+	Match        Match
+	Instructions []byte
+}
+
+func NewFlowUpdateFull(event uint16) *FlowUpdateFull {
+	u := new(FlowUpdateFull)
+	u.Event = event
+	u.pad = make([]byte, 4)
+	u.Match = *NewMatch()
+	u.Instructions = make([]byte, 0)
+	return u
+}
+
+func (u *FlowUpdateFull) Len() (n uint16) {
+	return 24 + u.Match.Len() + uint16(len(u.Instructions))
+}
+
+func (u *FlowUpdateFull) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(u.Len()))
+	next := 0
+
+	u.Length = u.Len()
+	bytes, err := u.FlowUpdateHeader.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	data[next] = u.TableId
+	next += 1
+	data[next] = u.Reason
+	next += 1
+	binary.BigEndian.PutUint16(data[next:], u.IdleTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], u.HardTimeout)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], u.Priority)
+	next += 2
+	copy(data[next:], u.pad)
+	next += 4
+	binary.BigEndian.PutUint64(data[next:], u.Cookie)
+	next += 8
+
+	bytes, err = u.Match.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	copy(data[next:], u.Instructions)
+	next += len(u.Instructions)
+	return
+}
+
+func (u *FlowUpdateFull) UnmarshalBinary(data []byte) error {
+	if len(data) < 32 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"FlowUpdateFull.")
+	}
+	next := 0
+	u.FlowUpdateHeader.UnmarshalBinary(data[next:])
+	next += 4
+	u.TableId = data[next]
+	next += 1
+	u.Reason = data[next]
+	next += 1
+	u.IdleTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	u.HardTimeout = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	u.Priority = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	u.pad = make([]byte, 4)
+	copy(u.pad, data[next:])
+	next += 4
+	u.Cookie = binary.BigEndian.Uint64(data[next:])
+	next += 8
+
+	if err := u.Match.UnmarshalBinary(data[next:]); err != nil {
+		return err
+	}
+	next += int(u.Match.Len())
+	if next > len(data) {
+		return errors.New("FlowUpdateFull match exceeds update length.")
+	}
+	u.Instructions = append([]byte(nil), data[next:]...)
+	return nil
+}
+
+// Sent instead of FlowUpdateFull for changes made by this
+// controller unless FMF_NO_ABBREV was requested. Xid is the
+// transaction id of the message that caused the change.
+// ofp_flow_update_abbrev 1.4
+type FlowUpdateAbbrev struct {
+	FlowUpdateHeader
+	Xid uint32
+}
+
+func (u *FlowUpdateAbbrev) Len() (n uint16) {
+	return 8
+}
+
+func (u *FlowUpdateAbbrev) MarshalBinary() (data []byte, err error) {
+	u.Length = u.Len()
+	u.Event = FME_ABBREV
+	data, err = u.FlowUpdateHeader.MarshalBinary()
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, u.Xid)
+	data = append(data, b...)
+	return
+}
+
+func (u *FlowUpdateAbbrev) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"FlowUpdateAbbrev.")
+	}
+	u.FlowUpdateHeader.UnmarshalBinary(data)
+	u.Xid = binary.BigEndian.Uint32(data[4:])
+	return nil
+}
+
+// Sent for FME_PAUSED and FME_RESUMED events. While paused the
+// switch stops sending updates and the controller's view of
+// the flow tables may be stale.
+// ofp_flow_update_paused 1.4
+type FlowUpdatePaused struct {
+	FlowUpdateHeader
+	pad []uint8 // Size 4
+}
+
+func (u *FlowUpdatePaused) Len() (n uint16) {
+	return 8
+}
+
+func (u *FlowUpdatePaused) MarshalBinary() (data []byte, err error) {
+	u.Length = u.Len()
+	data, err = u.FlowUpdateHeader.MarshalBinary()
+	data = append(data, make([]byte, 4)...)
+	return
+}
+
+func (u *FlowUpdatePaused) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"FlowUpdatePaused.")
+	}
+	u.FlowUpdateHeader.UnmarshalBinary(data)
+	u.pad = make([]byte, 4)
+	copy(u.pad, data[4:])
+	return nil
+}
+
+// ofp_flow_removed_reason 1.4
+const (
+	FRR_IDLE_TIMEOUT = iota
+	FRR_HARD_TIMEOUT
+	FRR_DELETE
+	FRR_GROUP_DELETE
+	FRR_METER_DELETE
+	FRR_EVICTION
+)
@@ -0,0 +1,294 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// ofp_multipart_request 1.4
+type MultipartRequest struct {
+	ofpxx.Header
+	Type  uint16
+	Flags uint16
+	pad   []uint8 // Size 4
+	Body  util.Message
+}
+
+func NewMultipartRequest(t uint16, body util.Message) *MultipartRequest {
+	m := new(MultipartRequest)
+	m.Header = ofpxx.NewOfp14Header()
+	m.Header.Type = Type_MultipartRequest
+	m.Type = t
+	m.pad = make([]byte, 4)
+	m.Body = body
+	return m
+}
+
+func (m *MultipartRequest) Len() (n uint16) {
+	n = m.Header.Len() + 8
+	if m.Body != nil {
+		n += m.Body.Len()
+	}
+	return
+}
+
+func (m *MultipartRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(m.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	m.Header.Length = m.Len()
+	bytes, err = m.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint16(data[next:], m.Type)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], m.Flags)
+	next += 2
+	copy(data[next:], m.pad)
+	next += 4
+
+	if m.Body != nil {
+		bytes, err = m.Body.MarshalBinary()
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (m *MultipartRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"MultipartRequest message.")
+	}
+	next := 0
+	err := m.Header.UnmarshalBinary(data[next:])
+	next += int(m.Header.Len())
+	m.Type = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.pad = make([]byte, 4)
+	copy(m.pad, data[next:])
+	next += 4
+
+	switch m.Type {
+	case MultipartType_Flow:
+		m.Body = NewFlowStatsRequest()
+	case MultipartType_FlowMonitor:
+		m.Body = NewFlowMonitorRequest(0)
+	default:
+		m.Body = new(util.Buffer)
+	}
+	if e := m.Body.UnmarshalBinary(data[next:]); e != nil {
+		err = e
+	}
+	return err
+}
+
+// ofp_multipart_reply 1.4
+type MultipartReply struct {
+	ofpxx.Header
+	Type  uint16
+	Flags uint16
+	pad   []uint8 // Size 4
+	Body  util.Message
+}
+
+func NewMultipartReply(t uint16, body util.Message) *MultipartReply {
+	m := new(MultipartReply)
+	m.Header = ofpxx.NewOfp14Header()
+	m.Header.Type = Type_MultipartReply
+	m.Type = t
+	m.pad = make([]byte, 4)
+	m.Body = body
+	return m
+}
+
+func (m *MultipartReply) Len() (n uint16) {
+	n = m.Header.Len() + 8
+	if m.Body != nil {
+		n += m.Body.Len()
+	}
+	return
+}
+
+func (m *MultipartReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(m.Len()))
+	bytes := make([]byte, 0)
+	next := 0
+
+	m.Header.Length = m.Len()
+	bytes, err = m.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint16(data[next:], m.Type)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], m.Flags)
+	next += 2
+	copy(data[next:], m.pad)
+	next += 4
+
+	if m.Body != nil {
+		bytes, err = m.Body.MarshalBinary()
+		copy(data[next:], bytes)
+		next += len(bytes)
+	}
+	return
+}
+
+func (m *MultipartReply) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"MultipartReply message.")
+	}
+	next := 0
+	err := m.Header.UnmarshalBinary(data[next:])
+	next += int(m.Header.Len())
+	m.Type = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.Flags = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	m.pad = make([]byte, 4)
+	copy(m.pad, data[next:])
+	next += 4
+
+	end := int(m.Header.Length)
+	if end > len(data) || end < next {
+		end = len(data)
+	}
+	switch m.Type {
+	case MultipartType_Desc:
+		m.Body = NewDescStats()
+	case MultipartType_Flow:
+		m.Body = new(FlowStatsReply)
+	case MultipartType_FlowMonitor:
+		m.Body = new(FlowMonitorReply)
+	case MultipartType_Table:
+		m.Body = new(TableStatsReply)
+	case MultipartType_TableFeatures:
+		m.Body = new(TableFeaturesReply)
+	case MultipartType_Meter:
+		m.Body = new(MeterStatsReply)
+	case MultipartType_Group:
+		m.Body = new(GroupStatsReply)
+	default:
+		m.Body = new(util.Buffer)
+	}
+	if e := m.Body.UnmarshalBinary(data[next:end]); e != nil {
+		err = e
+	}
+	return err
+}
+
+// ofp_multipart_type 1.4
+const (
+	MultipartType_Desc = iota
+	MultipartType_Flow
+	MultipartType_Aggregate
+	MultipartType_Table
+	MultipartType_PortStats
+	MultipartType_Queue
+	MultipartType_Group
+	MultipartType_GroupDesc
+	MultipartType_GroupFeatures
+	MultipartType_Meter
+	MultipartType_MeterConfig
+	MultipartType_MeterFeatures
+	MultipartType_TableFeatures
+	MultipartType_PortDesc
+	MultipartType_TableDesc
+	MultipartType_QueueDesc
+	MultipartType_FlowMonitor
+	MultipartType_Experimenter = 0xffff
+)
+
+// ofp_multipart_request_flags 1.4
+const (
+	MPF_REQ_MORE = 1 << 0 /* More requests to follow. */
+)
+
+// ofp_multipart_reply_flags 1.4
+const (
+	MPF_REPLY_MORE = 1 << 0 /* More replies to follow. */
+)
+
+// Fields to match against flows. Only OXM matches are
+// supported in OpenFlow 1.4. The OXM TLVs are not decoded and
+// are kept as is in Fields.
+// ofp_match 1.4
+type Match struct {
+	Type   uint16
+	Length uint16 // Length of Match, excluding padding
+	Fields []byte
+}
+
+// Returns a Match that matches every packet.
+func NewMatch() *Match {
+	m := new(Match)
+	m.Type = MT_OXM
+	m.Length = 4
+	m.Fields = make([]byte, 0)
+	return m
+}
+
+func (m *Match) Len() (n uint16) {
+	m.Length = uint16(4 + len(m.Fields))
+	return (m.Length + 7) / 8 * 8
+}
+
+func (m *Match) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(m.Len()))
+	binary.BigEndian.PutUint16(data[0:], m.Type)
+	binary.BigEndian.PutUint16(data[2:], m.Length)
+	copy(data[4:], m.Fields)
+	return
+}
+
+func (m *Match) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("The []byte is too short to unmarshal a full Match.")
+	}
+	m.Type = binary.BigEndian.Uint16(data[0:])
+	m.Length = binary.BigEndian.Uint16(data[2:])
+	if m.Length < 4 || int(m.Length) > len(data) {
+		return errors.New("Match has an invalid length.")
+	}
+	m.Fields = append([]byte(nil), data[4:m.Length]...)
+	return nil
+}
+
+// ofp_match_type 1.4
+const (
+	MT_STANDARD = iota /* Deprecated. */
+	MT_OXM
+)
+
+// ofp_port_no 1.4
+const (
+	P_MAX        = 0xffffff00
+	P_IN_PORT    = 0xfffffff8
+	P_TABLE      = 0xfffffff9
+	P_NORMAL     = 0xfffffffa
+	P_FLOOD      = 0xfffffffb
+	P_ALL        = 0xfffffffc
+	P_CONTROLLER = 0xfffffffd
+	P_LOCAL      = 0xfffffffe
+	P_ANY        = 0xffffffff
+)
+
+// ofp_group 1.4
+const (
+	G_MAX = 0xffffff00
+	G_ALL = 0xfffffffc
+	G_ANY = 0xffffffff
+)
+
+// ofp_table 1.4
+const (
+	TT_MAX = 0xfe
+	TT_ALL = 0xff
+)
@@ -0,0 +1,109 @@
+// OpenFlow Wire Protocol 0x05
+// Package ofp14 provides OpenFlow 1.4 structs along with Read
+// and Write methods for each. Only the messages needed to
+// complete a handshake, build bundles and monitor flow tables
+// are implemented.
+//
+// Struct documentation is taken from the OpenFlow Switch
+// Specification Version 1.4.0.
+// https://www.opennetworking.org/images/stories/downloads/sdn-resources/onf-specifications/openflow/openflow-spec-v1.4.0.pdf
+package ofp14
+
+import (
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+const (
+	VERSION = 5
+)
+
+// Echo request/reply messages can be sent from either the
+// switch or the controller, and must return an echo reply.
+func NewEchoRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp14Header()
+	h.Type = Type_EchoRequest
+	return &h
+}
+
+// Echo request/reply messages can be sent from either the
+// switch or the controller, and must return an echo reply.
+func NewEchoReply() *ofpxx.Header {
+	h := ofpxx.NewOfp14Header()
+	h.Type = Type_EchoReply
+	return &h
+}
+
+// The barrier request is used to ensure message dependencies
+// have been met or to receive notifications for completed
+// operations.
+func NewBarrierRequest() *ofpxx.Header {
+	h := ofpxx.NewOfp14Header()
+	h.Type = Type_BarrierRequest
+	return &h
+}
+
+// ofp_type 1.4
+const (
+	/* Immutable messages. */
+	Type_Hello = iota
+	Type_Error
+	Type_EchoRequest
+	Type_EchoReply
+	Type_Experimenter
+
+	/* Switch configuration messages. */
+	Type_FeaturesRequest
+	Type_FeaturesReply
+	Type_GetConfigRequest
+	Type_GetConfigReply
+	Type_SetConfig
+
+	/* Asynchronous messages. */
+	Type_PacketIn
+	Type_FlowRemoved
+	Type_PortStatus
+
+	/* Controller command messages. */
+	Type_PacketOut
+	Type_FlowMod
+	Type_GroupMod
+	Type_PortMod
+	Type_TableMod
+
+	/* Multipart messages. */
+	Type_MultipartRequest
+	Type_MultipartReply
+
+	/* Barrier messages. */
+	Type_BarrierRequest
+	Type_BarrierReply
+
+	/* Queue Configuration messages. */
+	Type_QueueGetConfigRequest
+	Type_QueueGetConfigReply
+
+	/* Controller role change request messages. */
+	Type_RoleRequest
+	Type_RoleReply
+
+	/* Asynchronous message configuration. */
+	Type_GetAsyncRequest
+	Type_GetAsyncReply
+	Type_SetAsync
+
+	/* Meters and rate limiters configuration messages. */
+	Type_MeterMod
+
+	/* Controller role change event messages. */
+	Type_RoleStatus
+
+	/* Asynchronous messages. */
+	Type_TableStatus
+
+	/* Request forwarding by the switch. */
+	Type_RequestForward
+
+	/* Bundle operations (multiple messages as a single operation). */
+	Type_BundleControl
+	Type_BundleAddMessage
+)
@@ -0,0 +1,107 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// ofp_packet_in 1.4. The layout is the same in OpenFlow 1.3.
+type PacketIn struct {
+	ofpxx.Header
+	BufferId uint32
+	TotalLen uint16
+	Reason   uint8
+	TableId  uint8
+	Cookie   uint64
+	Match    Match
+	pad      []uint8 // Size 2
+	Data     eth.Ethernet
+}
+
+func NewPacketIn() *PacketIn {
+	p := new(PacketIn)
+	p.Header = ofpxx.NewOfp14Header()
+	p.Header.Type = Type_PacketIn
+	p.BufferId = 0xffffffff
+	p.Match = *NewMatch()
+	p.pad = make([]byte, 2)
+	p.Data = *eth.New()
+	return p
+}
+
+// Returns the port the packet arrived on, from the IN_PORT field
+// of the match.
+func (p *PacketIn) InPort() (uint32, bool) {
+	b, ok := p.Match.Field(XMT_OFB_IN_PORT)
+	if !ok || len(b) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+func (p *PacketIn) Len() (n uint16) {
+	return p.Header.Len() + 16 + p.Match.Len() + 2 + p.Data.Len()
+}
+
+func (p *PacketIn) MarshalBinary() (data []byte, err error) {
+	p.Header.Length = p.Len()
+	data, err = p.Header.MarshalBinary()
+	if err != nil {
+		return
+	}
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b, p.BufferId)
+	binary.BigEndian.PutUint16(b[4:], p.TotalLen)
+	b[6] = p.Reason
+	b[7] = p.TableId
+	binary.BigEndian.PutUint64(b[8:], p.Cookie)
+	data = append(data, b...)
+
+	if b, err = p.Match.MarshalBinary(); err != nil {
+		return
+	}
+	data = append(data, b...)
+	data = append(data, 0, 0)
+
+	b, err = p.Data.MarshalBinary()
+	data = append(data, b...)
+	return
+}
+
+func (p *PacketIn) UnmarshalBinary(data []byte) error {
+	if len(data) < 34 {
+		return errors.New("The []byte is too short to unmarshal a full PacketIn message.")
+	}
+	err := p.Header.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+	n := int(p.Header.Len())
+	p.BufferId = binary.BigEndian.Uint32(data[n:])
+	p.TotalLen = binary.BigEndian.Uint16(data[n+4:])
+	p.Reason = data[n+6]
+	p.TableId = data[n+7]
+	p.Cookie = binary.BigEndian.Uint64(data[n+8:])
+	n += 16
+
+	if err = p.Match.UnmarshalBinary(data[n:]); err != nil {
+		return err
+	}
+	n += int(p.Match.Len())
+	p.pad = make([]byte, 2)
+	n += 2
+	if n > len(data) {
+		return errors.New("PacketIn has an invalid match length.")
+	}
+
+	p.Data = *eth.New()
+	if n < len(data) {
+		// Ethernet unmarshals from the byte before the frame,
+		// the last byte of padding.
+		err = p.Data.UnmarshalBinary(data[n-1:])
+	}
+	return err
+}
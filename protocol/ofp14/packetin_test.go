@@ -0,0 +1,61 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var packetInHex = "   05 0a 00 38 00 00 00 00" + // Header
+	"ff ff ff ff 00 0e 01 03" + // Buffer, total len, reason, table
+	"00 00 00 00 00 00 00 2a" + // Cookie
+	"00 01 00 0c 80 00 00 04" + // Match with IN_PORT 7
+	"00 00 00 07 00 00 00 00" +
+	"00 00" + // Pad
+	"ff ff ff ff ff ff 00 00" + // Ethernet
+	"00 00 00 01 88 cc"
+
+func TestPacketInUnmarshalBinary(t *testing.T) {
+	b := strings.Replace(packetInHex, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	msg, err := Parse(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := msg.(*PacketIn)
+	if !ok {
+		t.Fatalf("Parsed a %T, expected a PacketIn.", msg)
+	}
+	if p.Reason != R_APPLY_ACTION || p.TableId != 3 || p.Cookie != 42 || p.TotalLen != 14 {
+		t.Errorf("Got reason %d table %d cookie %d total length %d.", p.Reason, p.TableId, p.Cookie, p.TotalLen)
+	}
+	if port, ok := p.InPort(); !ok || port != 7 {
+		t.Errorf("Got in port %d, expected 7.", port)
+	}
+	if p.Data.Ethertype != 0x88cc || p.Data.HWSrc.String() != "00:00:00:00:00:01" {
+		t.Errorf("Got ethertype %#x from %s.", p.Data.Ethertype, p.Data.HWSrc)
+	}
+}
+
+func TestPacketInMarshalBinary(t *testing.T) {
+	b := strings.Replace(packetInHex, " ", "", -1)
+
+	p := NewPacketIn()
+	p.Header.Xid = 0
+	p.TotalLen = 14
+	p.Reason = R_APPLY_ACTION
+	p.TableId = 3
+	p.Cookie = 42
+	p.Match.AddField(XMT_OFB_IN_PORT, []byte{0, 0, 0, 7})
+	p.Data.HWDst = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	p.Data.HWSrc = []byte{0, 0, 0, 0, 0, 1}
+	p.Data.Ethertype = 0x88cc
+	data, _ := p.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
@@ -0,0 +1,50 @@
+package ofp14
+
+import (
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+func Parse(b []byte) (message util.Message, err error) {
+	switch b[1] {
+	case Type_Hello:
+		message = new(ofpxx.Hello)
+	case Type_Error:
+		message = NewErrorMsg()
+	case Type_EchoRequest:
+		message = new(ofpxx.Header)
+	case Type_EchoReply:
+		message = new(ofpxx.Header)
+	case Type_FeaturesRequest:
+		message = new(ofpxx.Header)
+	case Type_FeaturesReply:
+		message = NewFeaturesReply()
+	case Type_GetConfigReply:
+		message = new(SwitchConfig)
+	case Type_PacketIn:
+		message = new(PacketIn)
+	case Type_MultipartRequest:
+		message = new(MultipartRequest)
+	case Type_MultipartReply:
+		message = new(MultipartReply)
+	case Type_BarrierRequest:
+		message = new(ofpxx.Header)
+	case Type_BarrierReply:
+		message = new(ofpxx.Header)
+	case Type_RoleReply:
+		message = new(RoleRequest)
+	case Type_RoleStatus:
+		message = new(RoleStatus)
+	case Type_GetAsyncReply:
+		message = NewSetAsync()
+	case Type_BundleControl:
+		message = new(BundleCtrl)
+	case Type_BundleAddMessage:
+		message = new(BundleAdd)
+	default:
+		err = &ofpxx.UnknownTypeError{Version: b[0], Type: b[1]}
+		return
+	}
+	err = message.UnmarshalBinary(b)
+	return
+}
@@ -0,0 +1,27 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func TestParseUnknownType(t *testing.T) {
+	b := "   05 fe 00 08 00 00 00 01" // Header of an unknown type
+	b = strings.Replace(b, " ", "", -1)
+
+	bytes, _ := hex.DecodeString(b)
+	msg, err := Parse(bytes)
+	if msg != nil {
+		t.Errorf("Parsed an unknown type as %T.", msg)
+	}
+	e, ok := err.(*ofpxx.UnknownTypeError)
+	if !ok {
+		t.Fatalf("Got error %v, expected an UnknownTypeError.", err)
+	}
+	if e.Version != 5 || e.Type != 0xfe {
+		t.Errorf("Got version %d and type %d.", e.Version, e.Type)
+	}
+}
@@ -0,0 +1,89 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+// Port config flags.
+const (
+	PC_PORT_DOWN    = 1 << 0
+	PC_NO_RECV      = 1 << 2
+	PC_NO_FWD       = 1 << 5
+	PC_NO_PACKET_IN = 1 << 6
+)
+
+// Changes the config of a port. Only the bits of Config set in
+// Mask are changed. HWAddr must be the address of the port.
+// Properties aren't supported.
+// ofp_port_mod 1.4
+type PortMod struct {
+	ofpxx.Header
+	PortNo uint32
+	pad    []byte // 4 bytes
+	HWAddr net.HardwareAddr
+	pad2   []byte // 2 bytes
+	Config uint32
+	Mask   uint32
+}
+
+func NewPortMod(port uint32) *PortMod {
+	p := new(PortMod)
+	p.Header = ofpxx.NewOfp14Header()
+	p.Header.Type = Type_PortMod
+	p.PortNo = port
+	p.pad = make([]byte, 4)
+	p.HWAddr = make([]byte, 6)
+	p.pad2 = make([]byte, 2)
+	return p
+}
+
+func (p *PortMod) Len() (n uint16) {
+	return p.Header.Len() + 24
+}
+
+func (p *PortMod) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(p.Len()))
+	next := 0
+
+	p.Header.Length = p.Len()
+	bytes, err := p.Header.MarshalBinary()
+	copy(data[next:], bytes)
+	next += len(bytes)
+	binary.BigEndian.PutUint32(data[next:], p.PortNo)
+	next += 8
+	copy(data[next:next+6], p.HWAddr)
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], p.Config)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], p.Mask)
+	return
+}
+
+func (p *PortMod) UnmarshalBinary(data []byte) error {
+	if len(data) < int(p.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"PortMod message.")
+	}
+	next := 0
+	err := p.Header.UnmarshalBinary(data[next:])
+	next += int(p.Header.Len())
+	p.PortNo = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	p.pad = make([]byte, 4)
+	copy(p.pad, data[next:])
+	next += 4
+	p.HWAddr = make([]byte, 6)
+	copy(p.HWAddr, data[next:])
+	next += 6
+	p.pad2 = make([]byte, 2)
+	copy(p.pad2, data[next:])
+	next += 2
+	p.Config = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	p.Mask = binary.BigEndian.Uint32(data[next:])
+	return err
+}
@@ -0,0 +1,37 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPortModMarshalBinary(t *testing.T) {
+	b := "   05 10 00 20 00 00 00 00" + // Header
+		"00 00 00 03 00 00 00 00" + // Port, pad
+		"02 00 00 00 00 01 00 00" + // HWAddr, pad
+		"00 00 00 01 00 00 00 01" // Config, mask
+	b = strings.Replace(b, " ", "", -1)
+
+	p := NewPortMod(3)
+	p.Header.Xid = 0
+	p.HWAddr = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	p.Config = PC_PORT_DOWN
+	p.Mask = PC_PORT_DOWN
+	data, _ := p.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+
+	q := new(PortMod)
+	if err := q.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if q.PortNo != 3 || q.HWAddr.String() != p.HWAddr.String() || q.Config != PC_PORT_DOWN || q.Mask != PC_PORT_DOWN {
+		t.Errorf("Unmarshaled %+v", q)
+	}
+}
@@ -0,0 +1,43 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRoleRequestMarshalBinary(t *testing.T) {
+	b := "   05 18 00 18 00 00 00 00" + // Header
+		"00 00 00 03 00 00 00 00" + // Role, pad
+		"00 00 00 00 00 00 00 07" // Generation id
+	b = strings.Replace(b, " ", "", -1)
+
+	r := NewRoleRequest(CR_ROLE_SLAVE, 7)
+	r.Header.Xid = 0
+	data, _ := r.MarshalBinary()
+	d := hex.EncodeToString(data)
+	if (len(b) != len(d)) || (b != d) {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Errorf("Received length of %d, expected %d", len(d), len(b))
+	}
+}
+
+func TestRoleStatusParse(t *testing.T) {
+	b := "   05 1e 00 18 00 00 00 09" + // Header
+		"00 00 00 03 00 00 00 00" + // Role, reason, pad
+		"00 00 00 00 00 00 00 2a" // Generation id
+	bytes, _ := hex.DecodeString(strings.Replace(b, " ", "", -1))
+
+	msg, err := Parse(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := msg.(*RoleStatus)
+	if !ok {
+		t.Fatalf("Parsed a %T, expected a RoleStatus.", msg)
+	}
+	if s.Role != CR_ROLE_SLAVE || s.Reason != CRR_MASTER_REQUEST || s.GenerationId != 42 {
+		t.Errorf("Got role %d reason %d generation %d.", s.Role, s.Reason, s.GenerationId)
+	}
+}
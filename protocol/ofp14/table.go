@@ -0,0 +1,434 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Body of a MultipartType_TableFeatures reply.
+type TableFeaturesReply struct {
+	Tables []TableFeatures
+}
+
+func (r *TableFeaturesReply) Len() (n uint16) {
+	for _, t := range r.Tables {
+		n += t.Len()
+	}
+	return
+}
+
+func (r *TableFeaturesReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(r.Len()))
+	for _, t := range r.Tables {
+		bytes, err := t.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, bytes...)
+	}
+	return
+}
+
+func (r *TableFeaturesReply) UnmarshalBinary(data []byte) error {
+	r.Tables = make([]TableFeatures, 0)
+	next := 0
+	for next+64 <= len(data) {
+		t := TableFeatures{}
+		if err := t.UnmarshalBinary(data[next:]); err != nil {
+			return err
+		}
+		r.Tables = append(r.Tables, t)
+		next += int(t.Length)
+	}
+	return nil
+}
+
+// Describes the matches, instructions and actions supported by
+// a flow table.
+// ofp_table_features 1.4
+type TableFeatures struct {
+	Length        uint16
+	TableId       uint8
+	pad           []uint8 // Size 5
+	Name          []byte  // Size MAX_TABLE_NAME_LEN
+	MetadataMatch uint64
+	MetadataWrite uint64
+	Capabilities  uint32
+	MaxEntries    uint32
+	Properties    []TableFeatureProp
+}
+
+func (t *TableFeatures) Len() (n uint16) {
+	n = 64
+	for _, p := range t.Properties {
+		n += (p.Len() + 7) / 8 * 8
+	}
+	return
+}
+
+func (t *TableFeatures) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(t.Len()))
+	next := 0
+
+	t.Length = t.Len()
+	binary.BigEndian.PutUint16(data[next:], t.Length)
+	next += 2
+	data[next] = t.TableId
+	next += 1
+	next += 5
+	copy(data[next:next+MAX_TABLE_NAME_LEN], t.Name)
+	next += MAX_TABLE_NAME_LEN
+	binary.BigEndian.PutUint64(data[next:], t.MetadataMatch)
+	next += 8
+	binary.BigEndian.PutUint64(data[next:], t.MetadataWrite)
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], t.Capabilities)
+	next += 4
+	binary.BigEndian.PutUint32(data[next:], t.MaxEntries)
+	next += 4
+
+	for _, p := range t.Properties {
+		bytes, err := p.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		copy(data[next:], bytes)
+		next += (len(bytes) + 7) / 8 * 8
+	}
+	return
+}
+
+func (t *TableFeatures) UnmarshalBinary(data []byte) error {
+	if len(data) < 64 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"TableFeatures.")
+	}
+	next := 0
+	t.Length = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	if t.Length < 64 || int(t.Length) > len(data) {
+		return errors.New("TableFeatures has an invalid length.")
+	}
+	t.TableId = data[next]
+	next += 1
+	t.pad = make([]byte, 5)
+	copy(t.pad, data[next:])
+	next += 5
+	t.Name = make([]byte, MAX_TABLE_NAME_LEN)
+	copy(t.Name, data[next:])
+	next += MAX_TABLE_NAME_LEN
+	t.MetadataMatch = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	t.MetadataWrite = binary.BigEndian.Uint64(data[next:])
+	next += 8
+	t.Capabilities = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	t.MaxEntries = binary.BigEndian.Uint32(data[next:])
+	next += 4
+
+	t.Properties = make([]TableFeatureProp, 0)
+	for next+4 <= int(t.Length) {
+		p := TableFeatureProp{}
+		if err := p.UnmarshalBinary(data[next:t.Length]); err != nil {
+			return err
+		}
+		t.Properties = append(t.Properties, p)
+		next += (int(p.Length) + 7) / 8 * 8
+	}
+	return nil
+}
+
+const (
+	MAX_TABLE_NAME_LEN = 32
+)
+
+// A table feature property lists the ids of the instructions,
+// tables, actions or OXM fields it describes. Ids holds
+// instruction and action types, next table ids or OXM headers
+// depending on Type. Experimenter properties keep their body in
+// Data instead.
+// ofp_table_feature_prop_header 1.4
+type TableFeatureProp struct {
+	Type   uint16 // One of TFPT_*
+	Length uint16 // Length excluding padding
+	Ids    []uint32
+	Data   []byte
+}
+
+func (p *TableFeatureProp) Len() (n uint16) {
+	switch p.Type {
+	case TFPT_INSTRUCTIONS, TFPT_INSTRUCTIONS_MISS,
+		TFPT_WRITE_ACTIONS, TFPT_WRITE_ACTIONS_MISS,
+		TFPT_APPLY_ACTIONS, TFPT_APPLY_ACTIONS_MISS:
+		return uint16(4 + 4*len(p.Ids))
+	case TFPT_NEXT_TABLES, TFPT_NEXT_TABLES_MISS, TFPT_TABLE_SYNC_FROM:
+		return uint16(4 + len(p.Ids))
+	case TFPT_MATCH, TFPT_WILDCARDS,
+		TFPT_WRITE_SETFIELD, TFPT_WRITE_SETFIELD_MISS,
+		TFPT_APPLY_SETFIELD, TFPT_APPLY_SETFIELD_MISS:
+		return uint16(4 + 4*len(p.Ids))
+	}
+	return uint16(4 + len(p.Data))
+}
+
+func (p *TableFeatureProp) MarshalBinary() (data []byte, err error) {
+	p.Length = p.Len()
+	data = make([]byte, int(p.Length))
+	binary.BigEndian.PutUint16(data[0:], p.Type)
+	binary.BigEndian.PutUint16(data[2:], p.Length)
+	next := 4
+
+	switch p.Type {
+	case TFPT_INSTRUCTIONS, TFPT_INSTRUCTIONS_MISS,
+		TFPT_WRITE_ACTIONS, TFPT_WRITE_ACTIONS_MISS,
+		TFPT_APPLY_ACTIONS, TFPT_APPLY_ACTIONS_MISS:
+		for _, id := range p.Ids {
+			binary.BigEndian.PutUint16(data[next:], uint16(id))
+			binary.BigEndian.PutUint16(data[next+2:], 4)
+			next += 4
+		}
+	case TFPT_NEXT_TABLES, TFPT_NEXT_TABLES_MISS, TFPT_TABLE_SYNC_FROM:
+		for _, id := range p.Ids {
+			data[next] = uint8(id)
+			next += 1
+		}
+	case TFPT_MATCH, TFPT_WILDCARDS,
+		TFPT_WRITE_SETFIELD, TFPT_WRITE_SETFIELD_MISS,
+		TFPT_APPLY_SETFIELD, TFPT_APPLY_SETFIELD_MISS:
+		for _, id := range p.Ids {
+			binary.BigEndian.PutUint32(data[next:], id)
+			next += 4
+		}
+	default:
+		copy(data[next:], p.Data)
+	}
+	return
+}
+
+func (p *TableFeatureProp) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"TableFeatureProp.")
+	}
+	p.Type = binary.BigEndian.Uint16(data[0:])
+	p.Length = binary.BigEndian.Uint16(data[2:])
+	if p.Length < 4 || int(p.Length) > len(data) {
+		return errors.New("TableFeatureProp has an invalid length.")
+	}
+	body := data[4:p.Length]
+	p.Ids = make([]uint32, 0)
+
+	switch p.Type {
+	case TFPT_INSTRUCTIONS, TFPT_INSTRUCTIONS_MISS,
+		TFPT_WRITE_ACTIONS, TFPT_WRITE_ACTIONS_MISS,
+		TFPT_APPLY_ACTIONS, TFPT_APPLY_ACTIONS_MISS:
+		// Instruction and action ids are type-length
+		// headers. Experimenter ids are longer than 4 bytes.
+		for n := 0; n+4 <= len(body); {
+			l := int(binary.BigEndian.Uint16(body[n+2:]))
+			if l < 4 {
+				l = 4
+			}
+			p.Ids = append(p.Ids, uint32(binary.BigEndian.Uint16(body[n:])))
+			n += l
+		}
+	case TFPT_NEXT_TABLES, TFPT_NEXT_TABLES_MISS, TFPT_TABLE_SYNC_FROM:
+		for _, id := range body {
+			p.Ids = append(p.Ids, uint32(id))
+		}
+	case TFPT_MATCH, TFPT_WILDCARDS,
+		TFPT_WRITE_SETFIELD, TFPT_WRITE_SETFIELD_MISS,
+		TFPT_APPLY_SETFIELD, TFPT_APPLY_SETFIELD_MISS:
+		for n := 0; n+4 <= len(body); {
+			h := binary.BigEndian.Uint32(body[n:])
+			p.Ids = append(p.Ids, h)
+			// Experimenter OXM headers are followed by
+			// an experimenter id.
+			if h>>16 == OXM_CLASS_EXPERIMENTER {
+				n += 4
+			}
+			n += 4
+		}
+	default:
+		p.Data = append([]byte(nil), body...)
+	}
+	return nil
+}
+
+// ofp_table_feature_prop_type 1.4
+const (
+	TFPT_INSTRUCTIONS        = 0
+	TFPT_INSTRUCTIONS_MISS   = 1
+	TFPT_NEXT_TABLES         = 2
+	TFPT_NEXT_TABLES_MISS    = 3
+	TFPT_WRITE_ACTIONS       = 4
+	TFPT_WRITE_ACTIONS_MISS  = 5
+	TFPT_APPLY_ACTIONS       = 6
+	TFPT_APPLY_ACTIONS_MISS  = 7
+	TFPT_MATCH               = 8
+	TFPT_WILDCARDS           = 10
+	TFPT_WRITE_SETFIELD      = 12
+	TFPT_WRITE_SETFIELD_MISS = 13
+	TFPT_APPLY_SETFIELD      = 14
+	TFPT_APPLY_SETFIELD_MISS = 15
+	TFPT_TABLE_SYNC_FROM     = 16
+	TFPT_EXPERIMENTER        = 0xfffe
+	TFPT_EXPERIMENTER_MISS   = 0xffff
+)
+
+// ofp_instruction_type 1.4
+const (
+	IT_GOTO_TABLE     = 1
+	IT_WRITE_METADATA = 2
+	IT_WRITE_ACTIONS  = 3
+	IT_APPLY_ACTIONS  = 4
+	IT_CLEAR_ACTIONS  = 5
+	IT_METER          = 6
+	IT_EXPERIMENTER   = 0xffff
+)
+
+// ofp_action_type 1.4
+const (
+	AT_OUTPUT       = 0
+	AT_COPY_TTL_OUT = 11
+	AT_COPY_TTL_IN  = 12
+	AT_SET_MPLS_TTL = 15
+	AT_DEC_MPLS_TTL = 16
+	AT_PUSH_VLAN    = 17
+	AT_POP_VLAN     = 18
+	AT_PUSH_MPLS    = 19
+	AT_POP_MPLS     = 20
+	AT_SET_QUEUE    = 21
+	AT_GROUP        = 22
+	AT_SET_NW_TTL   = 23
+	AT_DEC_NW_TTL   = 24
+	AT_SET_FIELD    = 25
+	AT_PUSH_PBB     = 26
+	AT_POP_PBB      = 27
+	AT_EXPERIMENTER = 0xffff
+)
+
+// ofp_oxm_class 1.4
+const (
+	OXM_CLASS_NXM_0          = 0x0000
+	OXM_CLASS_NXM_1          = 0x0001
+	OXM_CLASS_OPENFLOW_BASIC = 0x8000
+	OXM_CLASS_EXPERIMENTER   = 0xffff
+)
+
+// oxm_ofb_match_fields 1.4
+const (
+	XMT_OFB_IN_PORT = iota
+	XMT_OFB_IN_PHY_PORT
+	XMT_OFB_METADATA
+	XMT_OFB_ETH_DST
+	XMT_OFB_ETH_SRC
+	XMT_OFB_ETH_TYPE
+	XMT_OFB_VLAN_VID
+	XMT_OFB_VLAN_PCP
+	XMT_OFB_IP_DSCP
+	XMT_OFB_IP_ECN
+	XMT_OFB_IP_PROTO
+	XMT_OFB_IPV4_SRC
+	XMT_OFB_IPV4_DST
+	XMT_OFB_TCP_SRC
+	XMT_OFB_TCP_DST
+	XMT_OFB_UDP_SRC
+	XMT_OFB_UDP_DST
+	XMT_OFB_SCTP_SRC
+	XMT_OFB_SCTP_DST
+	XMT_OFB_ICMPV4_TYPE
+	XMT_OFB_ICMPV4_CODE
+	XMT_OFB_ARP_OP
+	XMT_OFB_ARP_SPA
+	XMT_OFB_ARP_TPA
+	XMT_OFB_ARP_SHA
+	XMT_OFB_ARP_THA
+	XMT_OFB_IPV6_SRC
+	XMT_OFB_IPV6_DST
+	XMT_OFB_IPV6_FLABEL
+	XMT_OFB_ICMPV6_TYPE
+	XMT_OFB_ICMPV6_CODE
+	XMT_OFB_IPV6_ND_TARGET
+	XMT_OFB_IPV6_ND_SLL
+	XMT_OFB_IPV6_ND_TLL
+	XMT_OFB_MPLS_LABEL
+	XMT_OFB_MPLS_TC
+	XMT_OFB_MPLS_BOS
+	XMT_OFB_PBB_ISID
+	XMT_OFB_TUNNEL_ID
+	XMT_OFB_IPV6_EXTHDR
+	XMT_OFB_PBB_UCA = 41
+)
+
+// Returns the OXM header of field in the OpenFlow basic class
+// with the hasmask bit and length cleared, which identifies the
+// field in table features and match comparisons.
+func OxmId(field uint8) uint32 {
+	return OXM_CLASS_OPENFLOW_BASIC<<16 | uint32(field)<<9
+}
+
+// Body of a MultipartType_Table reply.
+type TableStatsReply struct {
+	Tables []TableStats
+}
+
+func (r *TableStatsReply) Len() (n uint16) {
+	return uint16(24 * len(r.Tables))
+}
+
+func (r *TableStatsReply) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, int(r.Len()))
+	for _, t := range r.Tables {
+		b, err := t.MarshalBinary()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (r *TableStatsReply) UnmarshalBinary(data []byte) error {
+	r.Tables = make([]TableStats, 0)
+	for next := 0; next+24 <= len(data); next += 24 {
+		t := TableStats{}
+		if err := t.UnmarshalBinary(data[next:]); err != nil {
+			return err
+		}
+		r.Tables = append(r.Tables, t)
+	}
+	return nil
+}
+
+// ofp_table_stats 1.4
+type TableStats struct {
+	TableId      uint8
+	ActiveCount  uint32
+	LookupCount  uint64
+	MatchedCount uint64
+}
+
+func (t *TableStats) Len() (n uint16) {
+	return 24
+}
+
+func (t *TableStats) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(t.Len()))
+	data[0] = t.TableId
+	binary.BigEndian.PutUint32(data[4:], t.ActiveCount)
+	binary.BigEndian.PutUint64(data[8:], t.LookupCount)
+	binary.BigEndian.PutUint64(data[16:], t.MatchedCount)
+	return
+}
+
+func (t *TableStats) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return errors.New("The []byte is too short to unmarshal a full TableStats.")
+	}
+	t.TableId = data[0]
+	t.ActiveCount = binary.BigEndian.Uint32(data[4:])
+	t.LookupCount = binary.BigEndian.Uint64(data[8:])
+	t.MatchedCount = binary.BigEndian.Uint64(data[16:])
+	return nil
+}
@@ -0,0 +1,42 @@
+package ofp14
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestTableStatsReplyUnmarshalBinary(t *testing.T) {
+	b := "   05 13 00 40 00 00 00 00" + // Header
+		"00 03 00 00 00 00 00 00" + // Type, flags
+		"00 00 00 00 00 00 00 2a" + // Table 0, active count
+		"00 00 00 00 00 00 00 64" + // Lookup count
+		"00 00 00 00 00 00 00 32" + // Matched count
+		"01 00 00 00 00 00 00 05" + // Table 1
+		"00 00 00 00 00 00 00 00" +
+		"00 00 00 00 00 00 00 00"
+	b = strings.Replace(b, " ", "", -1)
+	bytes, _ := hex.DecodeString(b)
+
+	m := new(MultipartReply)
+	if err := m.UnmarshalBinary(bytes); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := m.Body.(*TableStatsReply)
+	if !ok || len(r.Tables) != 2 {
+		t.Fatalf("Got body %+v.", m.Body)
+	}
+	if s := r.Tables[0]; s.TableId != 0 || s.ActiveCount != 42 || s.LookupCount != 100 || s.MatchedCount != 50 {
+		t.Errorf("Got table %+v.", s)
+	}
+	if s := r.Tables[1]; s.TableId != 1 || s.ActiveCount != 5 {
+		t.Errorf("Got table %+v.", s)
+	}
+
+	data, _ := m.MarshalBinary()
+	if d := hex.EncodeToString(data); d != b {
+		t.Log("Exp:", b)
+		t.Log("Rec:", d)
+		t.Error("Marshalled reply differs.")
+	}
+}
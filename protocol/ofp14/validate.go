@@ -0,0 +1,380 @@
+package ofp14
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The sizes of the values of the OXM basic fields, by field. Zero
+// for fields that don't exist.
+var oxmSizes = [...]int{
+	XMT_OFB_IN_PORT: 4, XMT_OFB_IN_PHY_PORT: 4, XMT_OFB_METADATA: 8,
+	XMT_OFB_ETH_DST: 6, XMT_OFB_ETH_SRC: 6, XMT_OFB_ETH_TYPE: 2,
+	XMT_OFB_VLAN_VID: 2, XMT_OFB_VLAN_PCP: 1, XMT_OFB_IP_DSCP: 1,
+	XMT_OFB_IP_ECN: 1, XMT_OFB_IP_PROTO: 1, XMT_OFB_IPV4_SRC: 4,
+	XMT_OFB_IPV4_DST: 4, XMT_OFB_TCP_SRC: 2, XMT_OFB_TCP_DST: 2,
+	XMT_OFB_UDP_SRC: 2, XMT_OFB_UDP_DST: 2, XMT_OFB_SCTP_SRC: 2,
+	XMT_OFB_SCTP_DST: 2, XMT_OFB_ICMPV4_TYPE: 1, XMT_OFB_ICMPV4_CODE: 1,
+	XMT_OFB_ARP_OP: 2, XMT_OFB_ARP_SPA: 4, XMT_OFB_ARP_TPA: 4,
+	XMT_OFB_ARP_SHA: 6, XMT_OFB_ARP_THA: 6, XMT_OFB_IPV6_SRC: 16,
+	XMT_OFB_IPV6_DST: 16, XMT_OFB_IPV6_FLABEL: 4, XMT_OFB_ICMPV6_TYPE: 1,
+	XMT_OFB_ICMPV6_CODE: 1, XMT_OFB_IPV6_ND_TARGET: 16, XMT_OFB_IPV6_ND_SLL: 6,
+	XMT_OFB_IPV6_ND_TLL: 6, XMT_OFB_MPLS_LABEL: 4, XMT_OFB_MPLS_TC: 1,
+	XMT_OFB_MPLS_BOS: 1, XMT_OFB_PBB_ISID: 3, XMT_OFB_TUNNEL_ID: 8,
+	XMT_OFB_IPV6_EXTHDR: 2, XMT_OFB_PBB_UCA: 1,
+}
+
+// A field that must be matched, with one of values unless there
+// are none, before another can be.
+type oxmPrereq struct {
+	field  uint8
+	values []uint64
+}
+
+// The prerequisites of the OXM basic fields, from the table of
+// header match fields of the specification.
+var oxmPrereqs = map[uint8]oxmPrereq{
+	XMT_OFB_IN_PHY_PORT:    {XMT_OFB_IN_PORT, nil},
+	XMT_OFB_VLAN_PCP:       {XMT_OFB_VLAN_VID, nil},
+	XMT_OFB_IP_DSCP:        {XMT_OFB_ETH_TYPE, []uint64{0x0800, 0x86dd}},
+	XMT_OFB_IP_ECN:         {XMT_OFB_ETH_TYPE, []uint64{0x0800, 0x86dd}},
+	XMT_OFB_IP_PROTO:       {XMT_OFB_ETH_TYPE, []uint64{0x0800, 0x86dd}},
+	XMT_OFB_IPV4_SRC:       {XMT_OFB_ETH_TYPE, []uint64{0x0800}},
+	XMT_OFB_IPV4_DST:       {XMT_OFB_ETH_TYPE, []uint64{0x0800}},
+	XMT_OFB_TCP_SRC:        {XMT_OFB_IP_PROTO, []uint64{6}},
+	XMT_OFB_TCP_DST:        {XMT_OFB_IP_PROTO, []uint64{6}},
+	XMT_OFB_UDP_SRC:        {XMT_OFB_IP_PROTO, []uint64{17}},
+	XMT_OFB_UDP_DST:        {XMT_OFB_IP_PROTO, []uint64{17}},
+	XMT_OFB_SCTP_SRC:       {XMT_OFB_IP_PROTO, []uint64{132}},
+	XMT_OFB_SCTP_DST:       {XMT_OFB_IP_PROTO, []uint64{132}},
+	XMT_OFB_ICMPV4_TYPE:    {XMT_OFB_IP_PROTO, []uint64{1}},
+	XMT_OFB_ICMPV4_CODE:    {XMT_OFB_IP_PROTO, []uint64{1}},
+	XMT_OFB_ARP_OP:         {XMT_OFB_ETH_TYPE, []uint64{0x0806}},
+	XMT_OFB_ARP_SPA:        {XMT_OFB_ETH_TYPE, []uint64{0x0806}},
+	XMT_OFB_ARP_TPA:        {XMT_OFB_ETH_TYPE, []uint64{0x0806}},
+	XMT_OFB_ARP_SHA:        {XMT_OFB_ETH_TYPE, []uint64{0x0806}},
+	XMT_OFB_ARP_THA:        {XMT_OFB_ETH_TYPE, []uint64{0x0806}},
+	XMT_OFB_IPV6_SRC:       {XMT_OFB_ETH_TYPE, []uint64{0x86dd}},
+	XMT_OFB_IPV6_DST:       {XMT_OFB_ETH_TYPE, []uint64{0x86dd}},
+	XMT_OFB_IPV6_FLABEL:    {XMT_OFB_ETH_TYPE, []uint64{0x86dd}},
+	XMT_OFB_ICMPV6_TYPE:    {XMT_OFB_IP_PROTO, []uint64{58}},
+	XMT_OFB_ICMPV6_CODE:    {XMT_OFB_IP_PROTO, []uint64{58}},
+	XMT_OFB_IPV6_ND_TARGET: {XMT_OFB_ICMPV6_TYPE, []uint64{135, 136}},
+	XMT_OFB_IPV6_ND_SLL:    {XMT_OFB_ICMPV6_TYPE, []uint64{135}},
+	XMT_OFB_IPV6_ND_TLL:    {XMT_OFB_ICMPV6_TYPE, []uint64{136}},
+	XMT_OFB_MPLS_LABEL:     {XMT_OFB_ETH_TYPE, []uint64{0x8847, 0x8848}},
+	XMT_OFB_MPLS_TC:        {XMT_OFB_ETH_TYPE, []uint64{0x8847, 0x8848}},
+	XMT_OFB_MPLS_BOS:       {XMT_OFB_ETH_TYPE, []uint64{0x8847, 0x8848}},
+	XMT_OFB_PBB_ISID:       {XMT_OFB_ETH_TYPE, []uint64{0x88e7}},
+	XMT_OFB_IPV6_EXTHDR:    {XMT_OFB_ETH_TYPE, []uint64{0x86dd}},
+}
+
+// Checks msg, a message about to be sent to a switch, against the
+// OpenFlow 1.4 specification, which OpenFlow 1.5 shares for the
+// messages checked. Returns a *ofpxx.ValidationError describing
+// the first problem found, so applications learn of their mistakes
+// before the switch answers with an error message. Flow mods and
+// group mods are checked field by field, other messages only for
+// their header.
+func Validate(msg util.Message) error {
+	name := "message"
+	switch m := msg.(type) {
+	case *FlowMod:
+		name = "flow mod"
+		if err := validateFlowMod(m); err != nil {
+			return err
+		}
+	case *GroupMod:
+		name = "group mod"
+		if err := validateGroupMod(m); err != nil {
+			return err
+		}
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := ofpxx.ValidateHeader(name, data, VERSION, VERSION+1); err != nil {
+		return err
+	}
+	if data[1] > Type_BundleAddMessage {
+		return ofpxx.Invalid(name, "unknown message type %d", data[1])
+	}
+	if name != "message" && len(data)%8 != 0 {
+		return ofpxx.Invalid(name, "%d bytes long, not a multiple of 8", len(data))
+	}
+	return nil
+}
+
+func validateFlowMod(f *FlowMod) error {
+	if f.Command > FC_DELETE_STRICT {
+		return ofpxx.Invalid("flow mod", "unknown command %d", f.Command)
+	}
+	del := f.Command == FC_DELETE || f.Command == FC_DELETE_STRICT
+	if f.TableId == TT_ALL && !del {
+		return ofpxx.Invalid("flow mod", "table %d is only for deletes", TT_ALL)
+	}
+	if f.Flags >= FF_NO_BYT_COUNTS<<1 {
+		return ofpxx.Invalid("flow mod", "unknown flags %#x", f.Flags)
+	}
+	fields, reason := validateMatch(&f.Match)
+	if reason != "" {
+		return ofpxx.Invalid("flow mod", "%s", reason)
+	}
+	if del {
+		return nil
+	}
+	seen := make(map[uint16]bool)
+	for n, i := range f.Instructions {
+		t := i.InstructionType()
+		if seen[t] {
+			return ofpxx.Invalid("flow mod", "instruction %d repeats type %d", n, t)
+		}
+		seen[t] = true
+		if i.Len()%8 != 0 {
+			return ofpxx.Invalid("flow mod", "instruction %d is %d bytes long, not a multiple of 8", n, i.Len())
+		}
+		switch i := i.(type) {
+		case *InstrGotoTable:
+			if i.TableId <= f.TableId || i.TableId > TT_MAX {
+				return ofpxx.Invalid("flow mod", "instruction %d goes from table %d to table %d", n, f.TableId, i.TableId)
+			}
+		case *InstrActions:
+			if i.Type == IT_CLEAR_ACTIONS && len(i.Actions) > 0 {
+				return ofpxx.Invalid("flow mod", "instruction %d clears actions but has some", n)
+			}
+			if err := validateActions("flow mod", i.Actions, fields); err != nil {
+				return err
+			}
+		case *InstrRaw:
+			if t != IT_EXPERIMENTER {
+				return ofpxx.Invalid("flow mod", "instruction %d has unknown type %d", n, t)
+			}
+		}
+	}
+	return nil
+}
+
+func validateGroupMod(g *GroupMod) error {
+	if g.Command > GC_DELETE {
+		return ofpxx.Invalid("group mod", "unknown command %d", g.Command)
+	}
+	if g.Type > GT_FF {
+		return ofpxx.Invalid("group mod", "unknown type %d", g.Type)
+	}
+	if g.GroupId > G_MAX && !(g.Command == GC_DELETE && g.GroupId == G_ALL) {
+		return ofpxx.Invalid("group mod", "reserved group %#x", g.GroupId)
+	}
+	if g.Command == GC_DELETE {
+		return nil
+	}
+	if g.Type == GT_INDIRECT && len(g.Buckets) != 1 {
+		return ofpxx.Invalid("group mod", "indirect groups have one bucket, not %d", len(g.Buckets))
+	}
+	for n, b := range g.Buckets {
+		if g.Type == GT_SELECT && b.Weight == 0 {
+			return ofpxx.Invalid("group mod", "bucket %d of a select group has no weight", n)
+		}
+		if g.Type != GT_SELECT && b.Weight != 0 {
+			return ofpxx.Invalid("group mod", "bucket %d has a weight outside a select group", n)
+		}
+		if g.Type == GT_FF && b.WatchPort == P_ANY && b.WatchGroup == G_ANY {
+			return ofpxx.Invalid("group mod", "bucket %d of a fast failover group watches nothing", n)
+		}
+		// The flows sending packets to the group aren't known,
+		// so set field prerequisites can't be checked.
+		if err := validateActions("group mod", b.Actions, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the values of the fields of m by field, or why m is
+// invalid. Fields must be well formed, not repeated and have their
+// prerequisites matched.
+func validateMatch(m *Match) (map[uint8][]byte, string) {
+	if m.Type != MT_OXM {
+		return nil, fmt.Sprintf("match type %d isn't OXM", m.Type)
+	}
+	fields := make(map[uint8][]byte)
+	for n := 0; n < len(m.Fields); {
+		field, value, length, reason := parseOxm(m.Fields[n:], true)
+		if reason != "" {
+			return nil, "the match " + reason
+		}
+		if field >= 0 {
+			if _, ok := fields[uint8(field)]; ok {
+				return nil, fmt.Sprintf("the match has field %d twice", field)
+			}
+			fields[uint8(field)] = value
+		}
+		n += length
+	}
+	for f, v := range fields {
+		if reason := oxmPrereqMet(uint8(f), fields); reason != "" {
+			return nil, "the match " + reason
+		}
+		if f == XMT_OFB_VLAN_VID && binary.BigEndian.Uint16(v)&0x1000 == 0 && binary.BigEndian.Uint16(v) != 0 {
+			return nil, "the match has a VLAN without OFPVID_PRESENT"
+		}
+	}
+	return fields, ""
+}
+
+// Parses the OXM TLV at the start of data. field is -1 for fields
+// outside the basic class. masked tells whether a mask is allowed.
+// Returns the field, its value without mask and the length of the
+// TLV, or why it's invalid.
+func parseOxm(data []byte, masked bool) (field int, value []byte, length int, reason string) {
+	if len(data) < 4 {
+		return 0, nil, 0, "has a truncated field header"
+	}
+	h := binary.BigEndian.Uint32(data)
+	length = 4 + int(h&0xff)
+	if length > len(data) {
+		return 0, nil, 0, "has a truncated field"
+	}
+	if h>>16 != OXM_CLASS_OPENFLOW_BASIC {
+		return -1, nil, length, ""
+	}
+	field = int(h >> 9 & 0x7f)
+	size := 0
+	if field < len(oxmSizes) {
+		size = oxmSizes[field]
+	}
+	if size == 0 {
+		return 0, nil, 0, fmt.Sprintf("has unknown field %d", field)
+	}
+	hasMask := h&(1<<8) != 0
+	if hasMask && !masked {
+		return 0, nil, 0, fmt.Sprintf("masks field %d", field)
+	}
+	want := size
+	if hasMask {
+		want *= 2
+	}
+	if length-4 != want {
+		return 0, nil, 0, fmt.Sprintf("has field %d of %d bytes, not %d", field, length-4, want)
+	}
+	return field, data[4 : 4+size], length, ""
+}
+
+// Returns why field can't be matched or set where fields are
+// known, or "".
+func oxmPrereqMet(field uint8, fields map[uint8][]byte) string {
+	p, ok := oxmPrereqs[field]
+	if !ok {
+		return ""
+	}
+	v, ok := fields[p.field]
+	if !ok {
+		return fmt.Sprintf("needs field %d for field %d", p.field, field)
+	}
+	if len(p.values) == 0 {
+		return ""
+	}
+	var n uint64
+	for _, b := range v {
+		n = n<<8 | uint64(b)
+	}
+	for _, want := range p.values {
+		if n == want {
+			return ""
+		}
+	}
+	return fmt.Sprintf("needs field %d to be one of %#x, not %#x, for field %d", p.field, p.values, n, field)
+}
+
+// Checks actions, those of a message named message. fields are the
+// values of the fields matched by the flow they belong to, nil if
+// unknown.
+func validateActions(message string, actions []Action, fields map[uint8][]byte) error {
+	// The fields of the packet as the actions change it.
+	var state map[uint8][]byte
+	if fields != nil {
+		state = make(map[uint8][]byte)
+		for f, v := range fields {
+			state[f] = v
+		}
+	}
+	for i, a := range actions {
+		if reason := validateAction(a, state); reason != "" {
+			return ofpxx.Invalid(message, "action %d %s", i, reason)
+		}
+	}
+	return nil
+}
+
+// Returns why action a is invalid, or "". Updates state, the
+// fields of the packet, if known.
+func validateAction(a Action, state map[uint8][]byte) string {
+	if a.Len()%8 != 0 {
+		return fmt.Sprintf("is %d bytes long, not a multiple of 8", a.Len())
+	}
+	switch t := a.(type) {
+	case *ActionOutput:
+		switch {
+		case t.Port == 0 || t.Port == P_ANY:
+			return fmt.Sprintf("outputs to port %#x", t.Port)
+		case t.Port > P_MAX && t.Port < P_IN_PORT:
+			return fmt.Sprintf("outputs to reserved port %#x", t.Port)
+		case t.Port == P_TABLE && state != nil:
+			return "outputs to the flow table outside a packet-out"
+		case t.Port == P_CONTROLLER && t.MaxLen > CML_MAX && t.MaxLen != CML_NO_BUFFER:
+			return fmt.Sprintf("sends %d bytes to the controller", t.MaxLen)
+		}
+	case *ActionId:
+		if t.Type == AT_GROUP && t.Id > G_MAX {
+			return fmt.Sprintf("sends to reserved group %#x", t.Id)
+		}
+	case *ActionEthertype:
+		if state == nil {
+			break
+		}
+		switch t.Type {
+		case AT_PUSH_VLAN:
+			state[XMT_OFB_VLAN_VID] = []byte{0x10, 0}
+		case AT_PUSH_MPLS, AT_POP_MPLS:
+			state[XMT_OFB_ETH_TYPE] = []byte{byte(t.Ethertype >> 8), byte(t.Ethertype)}
+		}
+	case *ActionSetField:
+		field, value, length, reason := parseOxm(t.Field, false)
+		if reason != "" {
+			return "sets a field that " + reason
+		}
+		if length != len(t.Field) {
+			return "sets more than one field"
+		}
+		if field < 0 || state == nil {
+			break
+		}
+		if vid, ok := state[XMT_OFB_VLAN_VID]; field == XMT_OFB_VLAN_VID && (!ok || vid[0]&0x10 == 0) {
+			return "sets the VLAN of packets without a VLAN tag"
+		}
+		if reason := oxmPrereqMet(uint8(field), state); reason != "" {
+			return "sets a field that " + reason
+		}
+		state[uint8(field)] = value
+	case *ActionRaw:
+		b := t.Bytes()
+		if len(b) < 8 || int(binary.BigEndian.Uint16(b[2:])) != len(b) {
+			return fmt.Sprintf("has %d bytes but a header saying otherwise", len(b))
+		}
+		switch at := t.ActionType(); at {
+		case AT_POP_VLAN:
+			if state != nil {
+				delete(state, XMT_OFB_VLAN_VID)
+				delete(state, XMT_OFB_VLAN_PCP)
+			}
+		case AT_COPY_TTL_OUT, AT_COPY_TTL_IN, AT_SET_MPLS_TTL, AT_DEC_MPLS_TTL,
+			AT_SET_NW_TTL, AT_DEC_NW_TTL, AT_POP_PBB, AT_EXPERIMENTER:
+		default:
+			return fmt.Sprintf("has unknown type %d", at)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package ofp14
+
+import (
+	"strings"
+	"testing"
+)
+
+func validFlowMod() *FlowMod {
+	f := NewFlowMod()
+	f.Match.AddField(XMT_OFB_ETH_TYPE, []byte{0x08, 0x00})
+	f.Match.AddField(XMT_OFB_IP_PROTO, []byte{17})
+	f.Match.AddField(XMT_OFB_UDP_DST, []byte{0, 53})
+	i := NewInstrApplyActions()
+	i.AddAction(NewActionPushVlan(0x8100))
+	i.AddAction(NewActionSetField(XMT_OFB_VLAN_VID, []byte{0x10, 0x0a}))
+	i.AddAction(NewActionSetField(XMT_OFB_IPV4_DST, []byte{10, 0, 0, 1}))
+	i.AddAction(NewActionOutput(2))
+	f.AddInstruction(i)
+	f.AddInstruction(NewInstrGotoTable(1))
+	return f
+}
+
+func TestValidateFlowMod(t *testing.T) {
+	if err := Validate(validFlowMod()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		change func(f *FlowMod)
+		reason string
+	}{
+		{func(f *FlowMod) { f.Command = 9 }, "unknown command"},
+		{func(f *FlowMod) { f.TableId = TT_ALL }, "only for deletes"},
+		{func(f *FlowMod) { f.Match = *NewMatch(); f.Match.AddField(XMT_OFB_TCP_DST, []byte{0, 80}) },
+			"needs field 10"},
+		{func(f *FlowMod) { f.Match.AddField(XMT_OFB_IP_PROTO, []byte{6}) }, "twice"},
+		{func(f *FlowMod) { f.Match.AddField(XMT_OFB_VLAN_VID, []byte{0, 10}) }, "OFPVID_PRESENT"},
+		{func(f *FlowMod) { f.Match.Fields = append(f.Match.Fields, 0x80, 0, 0x14, 2, 6, 0) }, "of 2 bytes, not 1"},
+		{func(f *FlowMod) { f.Instructions[1] = NewInstrGotoTable(0) }, "to table 0"},
+		{func(f *FlowMod) { f.AddInstruction(NewInstrApplyActions()) }, "repeats"},
+		{func(f *FlowMod) {
+			a := f.Instructions[0].(*InstrActions)
+			a.Actions = a.Actions[1:]
+		}, "without a VLAN tag"},
+		{func(f *FlowMod) {
+			a := f.Instructions[0].(*InstrActions)
+			a.Actions[3] = NewActionOutput(P_ANY)
+		}, "outputs to port"},
+	}
+	for _, test := range tests {
+		f := validFlowMod()
+		test.change(f)
+		err := Validate(f)
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("Got %v, expected an error about %q.", err, test.reason)
+		}
+	}
+}
+
+func TestValidateGroupMod(t *testing.T) {
+	g := NewGroupMod(GC_ADD, GT_SELECT, 1)
+	b := NewBucket()
+	b.Weight = 1
+	b.AddAction(NewActionOutput(1))
+	g.AddBucket(b)
+	if err := Validate(g); err != nil {
+		t.Fatal(err)
+	}
+	g.Type = GT_FF
+	if err := Validate(g); err == nil || !strings.Contains(err.Error(), "weight") {
+		t.Errorf("Got %v for a weighted fast failover bucket.", err)
+	}
+	g.Buckets[0].Weight = 0
+	if err := Validate(g); err == nil || !strings.Contains(err.Error(), "watches nothing") {
+		t.Errorf("Got %v for a fast failover bucket watching nothing.", err)
+	}
+}
@@ -0,0 +1,157 @@
+// OpenFlow Wire Protocol 0x06
+// Package ofp15 provides OpenFlow 1.5 structs. OpenFlow 1.5 keeps
+// the OpenFlow 1.4 wire format for the handshake, bundle and
+// flow monitor messages, so those are reused from package ofp14
+// with the header version set to 1.5.
+//
+// Struct documentation is taken from the OpenFlow Switch
+// Specification Version 1.5.1.
+// https://www.opennetworking.org/images/stories/downloads/sdn-resources/onf-specifications/openflow/openflow-switch-v1.5.1.pdf
+package ofp15
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+const (
+	VERSION = 6
+)
+
+// ofp_type 1.5 additions. All other types share their OpenFlow
+// 1.4 values.
+const (
+	Type_ControllerStatus = ofp14.Type_BundleAddMessage + 1
+)
+
+func NewEchoRequest() *ofpxx.Header {
+	h := ofp14.NewEchoRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewEchoReply() *ofpxx.Header {
+	h := ofp14.NewEchoReply()
+	h.Version = VERSION
+	return h
+}
+
+func NewBarrierRequest() *ofpxx.Header {
+	h := ofp14.NewBarrierRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewFeaturesRequest() *ofpxx.Header {
+	h := ofp14.NewFeaturesRequest()
+	h.Version = VERSION
+	return h
+}
+
+func NewBundleCtrl(id uint32, t uint16, flags uint16) *ofp14.BundleCtrl {
+	b := ofp14.NewBundleCtrl(id, t, flags)
+	b.Header.Version = VERSION
+	return b
+}
+
+func NewBundleAdd(id uint32, flags uint16, msg util.Message) *ofp14.BundleAdd {
+	b := ofp14.NewBundleAdd(id, flags, msg)
+	b.Header.Version = VERSION
+	return b
+}
+
+func NewMultipartRequest(t uint16, body util.Message) *ofp14.MultipartRequest {
+	m := ofp14.NewMultipartRequest(t, body)
+	m.Header.Version = VERSION
+	return m
+}
+
+// ofp_bundle_flags 1.5 additions
+const (
+	BF_TIME = 1 << 2 /* Execute at a specified time. */
+)
+
+// ofp_bundle_prop_type 1.5
+const (
+	BPT_TIME         = 1
+	BPT_EXPERIMENTER = 0xffff
+)
+
+// Scheduled bundles are committed by the switch at Sec/NSec
+// (seconds and nanoseconds since the epoch) when the commit
+// request has the BF_TIME flag set.
+// ofp_bundle_prop_time 1.5
+type BundlePropTime struct {
+	Type   uint16
+	Length uint16
+	pad    []uint8 // Size 4
+	Sec    int64
+	NSec   uint32
+	pad2   []uint8 // Size 4
+}
+
+func NewBundlePropTime(sec int64, nsec uint32) *BundlePropTime {
+	p := new(BundlePropTime)
+	p.Type = BPT_TIME
+	p.Length = 24
+	p.pad = make([]byte, 4)
+	p.Sec = sec
+	p.NSec = nsec
+	p.pad2 = make([]byte, 4)
+	return p
+}
+
+func (p *BundlePropTime) Len() (n uint16) {
+	return 24
+}
+
+func (p *BundlePropTime) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(p.Len()))
+	next := 0
+	binary.BigEndian.PutUint16(data[next:], p.Type)
+	next += 2
+	binary.BigEndian.PutUint16(data[next:], p.Length)
+	next += 2
+	next += 4
+	binary.BigEndian.PutUint64(data[next:], uint64(p.Sec))
+	next += 8
+	binary.BigEndian.PutUint32(data[next:], p.NSec)
+	next += 4
+	return
+}
+
+func (p *BundlePropTime) UnmarshalBinary(data []byte) error {
+	if len(data) < int(p.Len()) {
+		return errors.New("The []byte is too short to unmarshal a full " +
+			"BundlePropTime.")
+	}
+	next := 0
+	p.Type = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	p.Length = binary.BigEndian.Uint16(data[next:])
+	next += 2
+	p.pad = make([]byte, 4)
+	copy(p.pad, data[next:])
+	next += 4
+	p.Sec = int64(binary.BigEndian.Uint64(data[next:]))
+	next += 8
+	p.NSec = binary.BigEndian.Uint32(data[next:])
+	next += 4
+	p.pad2 = make([]byte, 4)
+	copy(p.pad2, data[next:])
+	return nil
+}
+
+func Parse(b []byte) (message util.Message, err error) {
+	switch b[1] {
+	case Type_ControllerStatus:
+		err = &ofpxx.UnknownTypeError{Version: b[0], Type: b[1]}
+	default:
+		message, err = ofp14.Parse(b)
+	}
+	return
+}
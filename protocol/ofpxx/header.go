@@ -4,6 +4,8 @@ package ofpxx
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"sync/atomic"
 
 	"github.com/jonstout/ogo/protocol/util"
 )
@@ -12,13 +14,16 @@ import (
 var NewOfp10Header func() Header = newHeaderGenerator(1)
 // Returns a new OpenFlow header with version field set to v1.3.
 var NewOfp13Header func() Header = newHeaderGenerator(4)
+// Returns a new OpenFlow header with version field set to v1.4.
+var NewOfp14Header func() Header = newHeaderGenerator(5)
+// Returns a new OpenFlow header with version field set to v1.5.
+var NewOfp15Header func() Header = newHeaderGenerator(6)
 
 var messageXid uint32 = 1
 
 func newHeaderGenerator(ver int) func() Header {
 	return func() Header {
-		messageXid += 1
-		p := Header{uint8(ver), 0, 8, messageXid}
+		p := Header{uint8(ver), 0, 8, atomic.AddUint32(&messageXid, 1)}
 		return p
 	}
 }
@@ -196,6 +201,10 @@ func NewHello(ver int) (h *Hello, err error) {
 		h.Header = NewOfp10Header()
 	} else if ver == 4 {
 		h.Header = NewOfp13Header()
+	} else if ver == 5 {
+		h.Header = NewOfp14Header()
+	} else if ver == 6 {
+		h.Header = NewOfp15Header()
 	} else {
 		err = errors.New("New hello message with unsupported verion was attempted to be created.")
 	}
@@ -235,17 +244,104 @@ func (h *Hello) UnmarshalBinary(data []byte) error {
 	next += int(h.Header.Len())
 	
 	h.Elements = make([]HelloElem, 0)
-	for next < len(data) {
+	for next+4 <= len(data) {
 		e := NewHelloElemHeader()
 		e.UnmarshalBinary(data[next:])
+		if e.Length < 4 || next+int(e.Length) > len(data) {
+			return errors.New("Hello element has an invalid length.")
+		}
 
 		switch e.Type {
 		case HelloElemType_VersionBitmap:
 			v := NewHelloElemVersionBitmap()
-			err = v.UnmarshalBinary(data[next:])
-			next += int(v.Len())
+			err = v.UnmarshalBinary(data[next : next+int(e.Length)])
 			h.Elements = append(h.Elements, v)
 		}
+		// Elements are padded to a multiple of 8 bytes.
+		next += (int(e.Length) + 7) / 8 * 8
 	}
 	return err
 }
+
+// Returns true if the version bitmap advertises support for
+// OpenFlow version ver.
+func (h *HelloElemVersionBitmap) Supports(ver uint8) bool {
+	i := int(ver) / 32
+	if i >= len(h.Bitmaps) {
+		return false
+	}
+	return h.Bitmaps[i]&(1<<(uint(ver)%32)) != 0
+}
+
+// Returns a Hello advertising every version in vers. The header
+// version is set to the highest version in vers as required by
+// section 6.3.1 of the OpenFlow 1.3+ specifications.
+func NewHelloVersions(vers ...uint8) *Hello {
+	h := new(Hello)
+	var high uint8
+	bitmap := NewHelloElemVersionBitmap()
+	bitmap.Bitmaps[0] = 0
+	for _, v := range vers {
+		bitmap.Bitmaps[0] |= 1 << v
+		if v > high {
+			high = v
+		}
+	}
+	h.Header = newHeaderGenerator(int(high))()
+	h.Elements = []HelloElem{bitmap}
+	return h
+}
+
+// Returns the highest version supported by both the sender of
+// this Hello and the local side, which supports vers. Switches
+// that don't include a version bitmap are assumed to support
+// every version up to the header version. Returns false if no
+// common version exists.
+func (h *Hello) Negotiate(vers ...uint8) (uint8, bool) {
+	var bitmap *HelloElemVersionBitmap
+	for _, e := range h.Elements {
+		if b, ok := e.(*HelloElemVersionBitmap); ok {
+			bitmap = b
+		}
+	}
+
+	var best uint8
+	found := false
+	for _, v := range vers {
+		if bitmap != nil && !bitmap.Supports(v) {
+			continue
+		}
+		if bitmap == nil && v > h.Version {
+			continue
+		}
+		if !found || v > best {
+			best = v
+			found = true
+		}
+	}
+	// Without a bitmap the negotiated version must be the
+	// smaller of the two header versions.
+	if bitmap == nil && found {
+		high := vers[0]
+		for _, v := range vers {
+			if v > high {
+				high = v
+			}
+		}
+		if h.Version < high && best != h.Version {
+			return 0, false
+		}
+	}
+	return best, found
+}
+
+// Returned by the parsers of each version for messages of a
+// type they don't know.
+type UnknownTypeError struct {
+	Version uint8
+	Type    uint8
+}
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("Unknown OpenFlow message type %d for version %d.", e.Type, e.Version)
+}
@@ -51,3 +51,35 @@ func TestHelloUnmarshalBinary(t *testing.T) {
 		t.Errorf("Got %d bitmap, expected %d.", v.Bitmaps[0], uint32(8))
 	}
 }
+
+func TestHelloNegotiate(t *testing.T) {
+	// Switch supporting v1.0 and v1.3 using a version bitmap.
+	h := NewHelloVersions(1, 4)
+	if v, ok := h.Negotiate(6, 5, 4, 1); !ok || v != 4 {
+		t.Errorf("Negotiated version %d, expected %d.", v, 4)
+	}
+	if v, ok := h.Negotiate(6, 5, 1); !ok || v != 1 {
+		t.Errorf("Negotiated version %d, expected %d.", v, 1)
+	}
+
+	// Switch without a version bitmap.
+	s := "01 00 00 08 00 00 00 01"
+	s = strings.Replace(s, " ", "", -1)
+	bytes, _ := hex.DecodeString(s)
+	h = new(Hello)
+	h.UnmarshalBinary(bytes)
+	if v, ok := h.Negotiate(6, 5, 1); !ok || v != 1 {
+		t.Errorf("Negotiated version %d, expected %d.", v, 1)
+	}
+	if _, ok := h.Negotiate(6, 5); ok {
+		t.Error("Negotiated a version not supported by the switch.")
+	}
+
+	// OpenFlow 1.3 switch without a version bitmap.
+	bytes, _ = hex.DecodeString("0400000800000001")
+	h = new(Hello)
+	h.UnmarshalBinary(bytes)
+	if v, ok := h.Negotiate(6, 5, 4, 1); !ok || v != 4 {
+		t.Errorf("Negotiated version %d, expected %d.", v, 4)
+	}
+}
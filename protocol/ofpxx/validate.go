@@ -0,0 +1,41 @@
+package ofpxx
+
+import "fmt"
+
+// A ValidationError describes how a message breaks the OpenFlow
+// specification, as found before sending it. Message names the
+// kind of message, like "flow mod".
+type ValidationError struct {
+	Message string
+	Reason  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s.", e.Message, e.Reason)
+}
+
+// Returns a *ValidationError for message with the reason
+// formatted from format and a.
+func Invalid(message, format string, a ...interface{}) error {
+	return &ValidationError{message, fmt.Sprintf(format, a...)}
+}
+
+// Checks the header at the start of data, a marshaled message:
+// its length must be that of data, and its version one of
+// versions.
+func ValidateHeader(message string, data []byte, versions ...uint8) error {
+	if len(data) < 8 {
+		return Invalid(message, "%d bytes is shorter than a header", len(data))
+	}
+	version := false
+	for _, v := range versions {
+		version = version || data[0] == v
+	}
+	if !version {
+		return Invalid(message, "version %#x", data[0])
+	}
+	if n := int(data[2])<<8 | int(data[3]); n != len(data) {
+		return Invalid(message, "the header says %d bytes but the message has %d", n, len(data))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package ogo
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// A Punter is a packet-in handler for reactive applications. It
+// hands the first packet-in of every flow to Handle, which
+// usually calls Install to add an exact-match rule forwarding the
+// rest of the flow. The packet-ins of a flow that arrive before
+// its rule is in place, such as a burst of packets sent at once,
+// are consumed for Hold so the rule isn't installed twice. Flows
+// are identified by switch, input port, Ethernet addresses and
+// type, and IPv4 addresses and protocol.
+//
+// Rate limits the new flows of a switch handed to Handle per
+// second; the packet-ins of flows over the limit are consumed
+// without being handled, so a scan or flood can't swamp the
+// controller.
+type Punter struct {
+	// Handles the first packet of a flow, m matching exactly
+	// that flow. Returns false to pass pkt on down the
+	// packet-in chain instead, which also forgets the flow.
+	Handle func(dpid net.HardwareAddr, pkt *ofp10.PacketIn, m FlowMatch) bool
+	Hold   time.Duration
+	// New flows per second and switch; zero for no limit.
+	Rate int
+	// Priority and idle timeout of the rules of Install.
+	Priority    uint16
+	IdleTimeout uint16
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+	windows map[string]*puntWindow
+	swept   time.Time
+	stats   PuntStats
+}
+
+// Packet-in counts of a Punter.
+type PuntStats struct {
+	// Flows handed to Handle.
+	Flows uint64
+	// Packet-ins of flows already being handled.
+	Suppressed uint64
+	// Packet-ins of new flows over Rate.
+	Limited uint64
+}
+
+// The new flows of a switch in the current second.
+type puntWindow struct {
+	start time.Time
+	flows int
+}
+
+func NewPunter(handle func(dpid net.HardwareAddr, pkt *ofp10.PacketIn, m FlowMatch) bool) *Punter {
+	p := new(Punter)
+	p.Handle = handle
+	p.Hold = time.Second
+	p.Priority = 100
+	p.IdleTimeout = 10
+	p.pending = make(map[string]time.Time)
+	p.windows = make(map[string]*puntWindow)
+	return p
+}
+
+// Returns an exact match of the flow of pkt.
+func PacketInMatch(pkt *ofp10.PacketIn) FlowMatch {
+	m := FlowMatch{InPort: pkt.InPort, EthSrc: pkt.Data.HWSrc,
+		EthDst: pkt.Data.HWDst, EthType: pkt.Data.Ethertype}
+	if ip, ok := pkt.Data.Data.(*ipv4.IPv4); ok && pkt.Data.Ethertype == 0x0800 {
+		m.IPSrc, m.IPDst, m.IPProto = ip.NWSrc, ip.NWDst, ip.Protocol
+	}
+	return m
+}
+
+func puntKey(dpid net.HardwareAddr, m FlowMatch) string {
+	return fmt.Sprint(dpid, m.InPort, m.EthSrc, m.EthDst, m.EthType, m.IPSrc, m.IPDst, m.IPProto)
+}
+
+func (p *Punter) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	m := PacketInMatch(pkt)
+	key := puntKey(dpid, m)
+	now := time.Now()
+
+	p.mu.Lock()
+	if at, ok := p.pending[key]; ok && now.Sub(at) < p.Hold {
+		p.stats.Suppressed += 1
+		p.mu.Unlock()
+		return true
+	}
+	if p.Rate > 0 {
+		w, ok := p.windows[dpid.String()]
+		if !ok || now.Sub(w.start) >= time.Second {
+			w = &puntWindow{start: now}
+			p.windows[dpid.String()] = w
+		}
+		if w.flows >= p.Rate {
+			p.stats.Limited += 1
+			p.mu.Unlock()
+			return true
+		}
+		w.flows += 1
+	}
+	p.pending[key] = now
+	p.stats.Flows += 1
+	p.expire(now)
+	p.mu.Unlock()
+
+	if !p.Handle(dpid, pkt, m) {
+		p.Release(dpid, m)
+		return false
+	}
+	return true
+}
+
+// Forgets flows held longer than Hold, at most once per Hold.
+// Must be called with p.mu held.
+func (p *Punter) expire(now time.Time) {
+	if now.Sub(p.swept) < p.Hold {
+		return
+	}
+	p.swept = now
+	for key, at := range p.pending {
+		if now.Sub(at) >= p.Hold {
+			delete(p.pending, key)
+		}
+	}
+}
+
+// Forgets the flow of m on Switch dpid, so its next packet-in is
+// handled again, such as after its rule failed to install.
+func (p *Punter) Release(dpid net.HardwareAddr, m FlowMatch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, puntKey(dpid, m))
+}
+
+// Installs a rule forwarding the flow of m out ports on Switch
+// dpid, or dropping it if there are none, and sends pkt, the
+// packet that was punted, the same way. The rule expires after
+// IdleTimeout, and m is released if it couldn't be sent.
+func (p *Punter) Install(dpid net.HardwareAddr, pkt *ofp10.PacketIn, m FlowMatch, ports ...uint16) error {
+	sw, ok := Switch(dpid)
+	if !ok {
+		p.Release(dpid, m)
+		return ErrSwitchDisconnected
+	}
+	r := newRecipe(m, ports...)
+	r.Priority, r.IdleTimeout = p.Priority, p.IdleTimeout
+	if err := sw.InstallRecipe(r); err != nil {
+		p.Release(dpid, m)
+		return err
+	}
+	ReactiveFlowModSent(dpid, pkt)
+	if len(ports) == 0 {
+		return nil
+	}
+	out := ofp10.NewPacketOut()
+	out.InPort = pkt.InPort
+	if pkt.BufferId != 0xffffffff {
+		out.BufferId = pkt.BufferId
+	} else {
+		out.Data = &pkt.Data
+	}
+	for _, port := range ports {
+		out.AddAction(ofp10.NewActionOutput(port))
+	}
+	return sw.Send(out)
+}
+
+// Returns the packet-in counts of p.
+func (p *Punter) Stats() PuntStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
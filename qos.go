@@ -0,0 +1,364 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/ovsdb"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A QoSPolicy guarantees and/or limits the bandwidth of traffic
+// leaving Port of Switch DPID. Rates are in bits per second, a
+// rate of zero is not enforced.
+type QoSPolicy struct {
+	Name     string
+	DPID     net.HardwareAddr
+	Port     uint16
+	Match    FlowMatch
+	Priority uint16
+	MinRate  uint64
+	MaxRate  uint64
+	// Queue id on the port, and meter id on OpenFlow 1.3+
+	// switches. Must be unique per port.
+	Id uint32
+}
+
+// The measured state of a QoSPolicy.
+type QoSStatus struct {
+	Name string
+	// Rate measured leaving the queue or entering the meter,
+	// in bits per second.
+	Rate uint64
+	// Bytes dropped by the meter, always 0 for queues.
+	Dropped uint64
+	// False if Rate exceeds MaxRate by more than QoSTolerance.
+	Enforced bool
+}
+
+// How far above its maximum rate a policy may measure before it
+// is reported as not enforced, as a fraction of the rate.
+var QoSTolerance = 0.1
+
+// The queue configuration applied to a port through OVSDB.
+type portQoS struct {
+	qos    string
+	queues []string
+}
+
+// A QoSEngine maps QoSPolicies to queues on OpenFlow 1.0 Open
+// vSwitch targets, configured through OVSDB, and to meters on
+// OpenFlow 1.3+ switches.
+type QoSEngine struct {
+	db       *ovsdb.Client
+	mu       sync.Mutex
+	policies map[string]*QoSPolicy
+	ports    map[string]*portQoS
+}
+
+// Returns a QoSEngine. db may be nil if no policy needs queues,
+// which means only maximum rates on OpenFlow 1.3+ switches.
+func NewQoSEngine(db *ovsdb.Client) *QoSEngine {
+	e := new(QoSEngine)
+	e.db = db
+	e.policies = make(map[string]*QoSPolicy)
+	e.ports = make(map[string]*portQoS)
+	return e
+}
+
+var errQoSNeedsOVSDB = errors.New("QoS queues require an OVSDB connection.")
+
+// Installs policy p, replacing any policy with the same name. The
+// flow and meter of the replaced policy are removed first, so
+// there is a moment where neither policy is enforced.
+func (e *QoSEngine) Apply(p QoSPolicy) error {
+	sw, ok := Switch(p.DPID)
+	if !ok {
+		return fmt.Errorf("Unknown switch %s.", p.DPID)
+	}
+	useQueue := p.MinRate > 0 || sw.Version() == ofp10.VERSION
+	if useQueue && e.db == nil {
+		return errQoSNeedsOVSDB
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old, replaced := e.policies[p.Name]
+	if replaced {
+		if err := e.uninstall(old); err != nil {
+			return err
+		}
+	}
+	e.policies[p.Name] = &p
+	if useQueue {
+		if err := e.configurePort(sw, p.Port); err != nil {
+			delete(e.policies, p.Name)
+			return err
+		}
+	}
+	// The queue of the replaced policy is left behind if it was
+	// on another port.
+	if replaced && e.db != nil && (old.DPID.String() != p.DPID.String() || old.Port != p.Port) {
+		if osw, ok := Switch(old.DPID); ok {
+			if err := e.configurePort(osw, old.Port); err != nil {
+				return err
+			}
+		}
+	}
+	for _, msg := range qosInstallMessages(sw.Version(), &p, useQueue) {
+		if err := sw.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the policy called name.
+func (e *QoSEngine) Remove(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	p, ok := e.policies[name]
+	if !ok {
+		return fmt.Errorf("No QoS policy named %s.", name)
+	}
+	delete(e.policies, name)
+	if err := e.uninstall(p); err != nil {
+		return err
+	}
+	if sw, ok := Switch(p.DPID); ok && e.db != nil {
+		return e.configurePort(sw, p.Port)
+	}
+	return nil
+}
+
+// Removes the flow and meter of policy p from its switch, but not
+// its queue. Every message is sent even if one fails, and the
+// first error is returned. Must be called with e.mu held.
+func (e *QoSEngine) uninstall(p *QoSPolicy) error {
+	sw, ok := Switch(p.DPID)
+	if !ok {
+		return fmt.Errorf("Unknown switch %s.", p.DPID)
+	}
+	var err error
+	for _, msg := range qosRemoveMessages(sw.Version(), p) {
+		if serr := sw.Send(msg); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Returns the messages that install policy p on a switch speaking
+// version, in the order they must be sent.
+func qosInstallMessages(version uint8, p *QoSPolicy, useQueue bool) []util.Message {
+	if version == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Match = p.Match.ofp10()
+		f.Priority = p.Priority
+		f.AddAction(ofp10.NewActionEnqueue(p.Port, p.Id))
+		return []util.Message{f}
+	}
+
+	msgs := make([]util.Message, 0, 2)
+	f := ofp14.NewFlowMod()
+	f.Header.Version = version
+	f.Match = p.Match.ofp14()
+	f.Priority = p.Priority
+	if p.MaxRate > 0 {
+		m := ofp14.NewMeterMod(ofp14.MC_ADD, p.Id)
+		m.Header.Version = version
+		m.AddBand(ofp14.NewMeterBandDrop(uint32(p.MaxRate/1000), uint32(p.MaxRate/1000/10)))
+		msgs = append(msgs, m)
+		f.AddInstruction(ofp14.NewInstrMeter(p.Id))
+	}
+	actions := ofp14.NewInstrApplyActions()
+	if useQueue {
+		actions.AddAction(ofp14.NewActionSetQueue(p.Id))
+	}
+	actions.AddAction(ofp14.NewActionOutput(uint32(p.Port)))
+	f.AddInstruction(actions)
+	return append(msgs, f)
+}
+
+// Returns the messages that remove the flow and meter of policy p
+// from a switch speaking version.
+func qosRemoveMessages(version uint8, p *QoSPolicy) []util.Message {
+	if version == ofp10.VERSION {
+		f := ofp10.NewFlowMod()
+		f.Command = ofp10.FC_DELETE_STRICT
+		f.Match = p.Match.ofp10()
+		f.Priority = p.Priority
+		return []util.Message{f}
+	}
+
+	f := ofp14.NewFlowMod()
+	f.Header.Version = version
+	f.Command = ofp14.FC_DELETE_STRICT
+	f.Match = p.Match.ofp14()
+	f.Priority = p.Priority
+	msgs := []util.Message{f}
+	if p.MaxRate > 0 {
+		m := ofp14.NewMeterMod(ofp14.MC_DELETE, p.Id)
+		m.Header.Version = version
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Returns every installed policy.
+func (e *QoSEngine) Policies() []QoSPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a := make([]QoSPolicy, 0, len(e.policies))
+	for _, p := range e.policies {
+		a = append(a, *p)
+	}
+	return a
+}
+
+// Replaces the OVSDB QoS of a port with one holding a queue for
+// every policy on that port. Must be called with e.mu held.
+func (e *QoSEngine) configurePort(sw *OFSwitch, portNo uint16) error {
+	port, ok := sw.Port(portNo)
+	if !ok {
+		return fmt.Errorf("Unknown port %d on %s.", portNo, sw.DPID())
+	}
+	name := strings.TrimRight(string(port.Name), "\x00")
+	key := fmt.Sprintf("%s/%d", sw.DPID(), portNo)
+
+	ops := make([]ovsdb.Operation, 0)
+	queues := make(map[int]interface{})
+	var maxRate uint64
+	for _, p := range e.policies {
+		if p.DPID.String() != sw.DPID().String() || p.Port != portNo || (p.MinRate == 0 && sw.Version() != ofp10.VERSION) {
+			continue
+		}
+		cfg := make(map[string]string)
+		if p.MinRate > 0 {
+			cfg["min-rate"] = fmt.Sprint(p.MinRate)
+		}
+		if p.MaxRate > 0 {
+			cfg["max-rate"] = fmt.Sprint(p.MaxRate)
+			maxRate += p.MaxRate
+		}
+		qname := fmt.Sprintf("queue%d", p.Id)
+		ops = append(ops, ovsdb.Insert("Queue", map[string]interface{}{
+			"other_config": ovsdb.Map(cfg),
+		}, qname))
+		queues[int(p.Id)] = ovsdb.NamedUUID(qname)
+	}
+	nqueues := len(ops)
+
+	where := []ovsdb.Condition{ovsdb.Where("name", "==", name)}
+	if nqueues > 0 {
+		qos := map[string]interface{}{
+			"type":   "linux-htb",
+			"queues": ovsdb.IntMap(queues),
+		}
+		ops = append(ops, ovsdb.Insert("QoS", qos, "qos"))
+		ops = append(ops, ovsdb.Update("Port", where, map[string]interface{}{"qos": ovsdb.NamedUUID("qos")}))
+	} else {
+		ops = append(ops, ovsdb.Update("Port", where, map[string]interface{}{"qos": ovsdb.Set()}))
+	}
+	// The QoS and Queue tables are root tables, so rows
+	// replaced here must be deleted explicitly.
+	if old, ok := e.ports[key]; ok {
+		ops = append(ops, ovsdb.Delete("QoS", []ovsdb.Condition{ovsdb.Where("_uuid", "==", ovsdb.UUID(old.qos))}))
+		for _, q := range old.queues {
+			ops = append(ops, ovsdb.Delete("Queue", []ovsdb.Condition{ovsdb.Where("_uuid", "==", ovsdb.UUID(q))}))
+		}
+	}
+
+	res, err := e.db.Transact("Open_vSwitch", ops...)
+	if err != nil {
+		return err
+	}
+	delete(e.ports, key)
+	if nqueues > 0 {
+		pq := &portQoS{qos: res[nqueues].RowUUID()}
+		for i := 0; i < nqueues; i++ {
+			pq.queues = append(pq.queues, res[i].RowUUID())
+		}
+		e.ports[key] = pq
+	}
+	return nil
+}
+
+// Measures the traffic handled by policy name over interval and
+// reports whether its maximum rate is enforced.
+func (e *QoSEngine) Verify(name string, interval time.Duration) (QoSStatus, error) {
+	e.mu.Lock()
+	p, ok := e.policies[name]
+	e.mu.Unlock()
+	st := QoSStatus{Name: name}
+	if !ok {
+		return st, fmt.Errorf("No QoS policy named %s.", name)
+	}
+	sw, ok := Switch(p.DPID)
+	if !ok {
+		return st, fmt.Errorf("Unknown switch %s.", p.DPID)
+	}
+
+	first, dropped1, err := e.counters(sw, p)
+	if err != nil {
+		return st, err
+	}
+	time.Sleep(interval)
+	second, dropped2, err := e.counters(sw, p)
+	if err != nil {
+		return st, err
+	}
+	st.Rate = uint64(float64(second-first) * 8 / interval.Seconds())
+	st.Dropped = dropped2 - dropped1
+	st.Enforced = p.MaxRate == 0 || float64(st.Rate) <= float64(p.MaxRate)*(1+QoSTolerance)
+	return st, nil
+}
+
+// Returns the byte count for policy p, and the bytes dropped by
+// its meter.
+func (e *QoSEngine) counters(sw *OFSwitch, p *QoSPolicy) (bytes, dropped uint64, err error) {
+	if sw.Version() == ofp10.VERSION {
+		q := ofp10.NewQueueStatsRequest()
+		q.PortNo = p.Port
+		q.QueueId = p.Id
+		rep, err := sw.SendAndReceive(ofp10.NewStatsRequest(ofp10.StatsType_Queue, q), BundleTimeout)
+		if err != nil {
+			return 0, 0, err
+		}
+		if r, ok := rep.(*ofp10.StatsReply); ok {
+			if s, ok := r.Body.(*ofp10.QueueStats); ok {
+				return s.TxBytes, 0, nil
+			}
+		}
+		return 0, 0, fmt.Errorf("Queue stats request failed: %s", errorString(rep))
+	}
+
+	if p.MaxRate == 0 {
+		return 0, 0, errors.New("Only policies with a maximum rate can be verified on OpenFlow 1.3+.")
+	}
+	reps, err := sw.requestMultipart(sw.newMultipartRequest(ofp14.MultipartType_Meter,
+		&ofp14.MeterMultipartRequest{MeterId: p.Id}), BundleTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, rep := range reps {
+		if body, ok := rep.Body.(*ofp14.MeterStatsReply); ok {
+			for _, s := range body.Stats {
+				if s.MeterId != p.Id {
+					continue
+				}
+				for _, b := range s.Bands {
+					dropped += b.ByteBandCount
+				}
+				return s.ByteInCount, dropped, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("No stats for meter %d.", p.Id)
+}
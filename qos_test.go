@@ -0,0 +1,93 @@
+package ogo
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Describes msgs in a way that's easy to compare.
+func describeQoS(msgs []util.Message) string {
+	a := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		switch m := msg.(type) {
+		case *ofp10.FlowMod:
+			s := fmt.Sprintf("flow%d", m.Command)
+			for _, act := range m.Actions {
+				if q, ok := act.(*ofp10.ActionEnqueue); ok {
+					s += fmt.Sprintf(" enqueue %d:%d", q.Port, q.QueueId)
+				}
+			}
+			a = append(a, s)
+		case *ofp14.FlowMod:
+			s := fmt.Sprintf("v%d flow%d", m.Header.Version, m.Command)
+			for _, instr := range m.Instructions {
+				switch i := instr.(type) {
+				case *ofp14.InstrMeter:
+					s += fmt.Sprintf(" meter %d", i.MeterId)
+				case *ofp14.InstrActions:
+					for _, act := range i.Actions {
+						if id, ok := act.(*ofp14.ActionId); ok && id.Type == ofp14.AT_SET_QUEUE {
+							s += fmt.Sprintf(" queue %d", id.Id)
+						}
+					}
+				}
+			}
+			a = append(a, s)
+		case *ofp14.MeterMod:
+			s := fmt.Sprintf("v%d meter%d %d", m.Header.Version, m.Command, m.MeterId)
+			for _, b := range m.Bands {
+				s += fmt.Sprintf(" drop %d/%d", b.Rate, b.BurstSize)
+			}
+			a = append(a, s)
+		}
+	}
+	return strings.Join(a, ", ")
+}
+
+func TestQoSMessages(t *testing.T) {
+	limit := &QoSPolicy{Name: "limit", Port: 2, MaxRate: 10000000, Id: 7}
+	guarantee := &QoSPolicy{Name: "guarantee", Port: 2, MinRate: 1000000, Id: 3}
+	tests := []struct {
+		name     string
+		version  uint8
+		policy   *QoSPolicy
+		useQueue bool
+		install  string
+		remove   string
+	}{
+		{"queue on OpenFlow 1.0", 1, limit, true,
+			"flow0 enqueue 2:7", "flow4"},
+		{"meter on OpenFlow 1.3", 4, limit, false,
+			"v4 meter0 7 drop 10000/1000, v4 flow0 meter 7", "v4 flow4, v4 meter2 7"},
+		{"queue on OpenFlow 1.4", 5, guarantee, true,
+			"v5 flow0 queue 3", "v5 flow4"},
+	}
+	for _, test := range tests {
+		if s := describeQoS(qosInstallMessages(test.version, test.policy, test.useQueue)); s != test.install {
+			t.Errorf("%s: installed with %q, expected %q.", test.name, s, test.install)
+		}
+		if s := describeQoS(qosRemoveMessages(test.version, test.policy)); s != test.remove {
+			t.Errorf("%s: removed with %q, expected %q.", test.name, s, test.remove)
+		}
+	}
+}
+
+func TestQoSUnknownPolicy(t *testing.T) {
+	network = NewNetwork()
+	e := NewQoSEngine(nil)
+	if err := e.Apply(QoSPolicy{Name: "p", DPID: net.HardwareAddr{0, 0, 0, 0, 0, 0, 0xfe, 0xed}}); err == nil {
+		t.Error("Applied a policy to an unknown switch.")
+	}
+	if len(e.Policies()) != 0 {
+		t.Error("Kept a policy that wasn't applied.")
+	}
+	if err := e.Remove("p"); err == nil {
+		t.Error("Removed a policy that doesn't exist.")
+	}
+}
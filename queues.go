@@ -0,0 +1,198 @@
+package ogo
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The messages waiting to be sent to a switch, by type: those in
+// the outbound queue of its connection, and the flow mods held
+// back by its pacing.
+type SwitchQueue struct {
+	DPID     string         `json:"dpid"`
+	Outbound map[string]int `json:"outbound"`
+	Paced    map[string]int `json:"paced"`
+	// Messages in the outbound queue and its capacity.
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// Returns the total number of messages waiting in q.
+func (q SwitchQueue) Pending() int {
+	n := 0
+	for _, c := range q.Outbound {
+		n += c
+	}
+	for _, c := range q.Paced {
+		n += c
+	}
+	return n
+}
+
+// Returns the OpenFlow message type of msg, if it has a header.
+func queuedType(msg util.Message) (uint8, bool) {
+	if h, ok := messageHeader(msg); ok {
+		return h.Type, true
+	}
+	return 0, false
+}
+
+// Returns the messages waiting to be sent to Switch s.
+func (s *OFSwitch) Queue() SwitchQueue {
+	stream, _ := s.session()
+	q := SwitchQueue{DPID: s.DPID().String(), Outbound: make(map[string]int),
+		Paced: make(map[string]int), Length: len(stream.Outbound),
+		Capacity: cap(stream.Outbound)}
+	c := stream.counts
+	for t := range c.queued {
+		if n := atomic.LoadInt64(&c.queued[t]); n > 0 {
+			q.Outbound[messageTypeName(s.Version(), uint8(t))] += int(n)
+		}
+	}
+	if p := s.pacing(); p != nil {
+		p.mu.Lock()
+		for _, f := range p.queue {
+			t, _ := queuedType(f.msg)
+			q.Paced[messageTypeName(s.Version(), t)] += 1
+		}
+		p.mu.Unlock()
+	}
+	return q
+}
+
+// Returns the queues of every switch, in order of DPID.
+func Queues() []SwitchQueue {
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+	a := make([]SwitchQueue, len(sws))
+	for i, sw := range sws {
+		a[i] = sw.Queue()
+	}
+	return a
+}
+
+// Drops the messages waiting to be sent to Switch s whose type
+// is one of types, by the names of MessageStats, or every
+// message if there are none, and returns the numbers dropped by
+// type. Messages kept in the outbound queue are queued again
+// behind any sent while the queue was flushed.
+func (s *OFSwitch) FlushQueue(types ...string) map[string]int {
+	drop := func(t uint8) bool {
+		if len(types) == 0 {
+			return true
+		}
+		name := messageTypeName(s.Version(), t)
+		for _, n := range types {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	dropped := make(map[string]int)
+	if p := s.pacing(); p != nil {
+		p.mu.Lock()
+		kept := make(flowModQueue, 0, len(p.queue))
+		for _, f := range p.queue {
+			if t, _ := queuedType(f.msg); drop(t) {
+				dropped[messageTypeName(s.Version(), t)] += 1
+			} else {
+				kept = append(kept, f)
+			}
+		}
+		p.queue = kept
+		heap.Init(&p.queue)
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+
+	stream, _ := s.session()
+	kept := make([]util.Message, 0)
+drain:
+	for {
+		select {
+		case msg := <-stream.Outbound:
+			t, ok := queuedType(msg)
+			if ok && !drop(t) {
+				kept = append(kept, msg)
+				continue
+			}
+			stream.sends.forget(msg)
+			if !ok {
+				dropped["unknown"] += 1
+				continue
+			}
+			atomic.AddInt64(&stream.counts.queued[t], -1)
+			dropped[messageTypeName(s.Version(), t)] += 1
+		default:
+			break drain
+		}
+	}
+	for _, msg := range kept {
+		select {
+		case stream.Outbound <- msg:
+		case <-stream.Done():
+			return dropped
+		}
+	}
+	return dropped
+}
+
+// Names the interlock operation of a request to serveQueues.
+func queuesOp(r *http.Request) string {
+	if r.Method == "POST" || r.Method == "DELETE" {
+		return OpFlushQueue
+	}
+	return ""
+}
+
+// Serves the queues of the switches as JSON, all of them or that
+// of the switch given by the dpid parameter. POST or DELETE
+// flushes the queue of that switch, or only the message types
+// given by the repeatable type parameter, and returns the number
+// of messages dropped by type:
+//
+//	curl -X DELETE '/debug/queues?dpid=00:00:00:00:00:00:00:01&type=flow_mod'
+func serveQueues(w http.ResponseWriter, r *http.Request) {
+	var sw *OFSwitch
+	if d := r.URL.Query().Get("dpid"); d != "" {
+		dpid, err := net.ParseMAC(d)
+		if err != nil {
+			http.Error(w, "bad dpid", http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		if sw, ok = Switch(dpid); !ok {
+			http.Error(w, ErrSwitchDisconnected.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case "GET":
+		if sw != nil {
+			json.NewEncoder(w).Encode(sw.Queue())
+		} else {
+			json.NewEncoder(w).Encode(Queues())
+		}
+	case "POST", "DELETE":
+		if sw == nil {
+			http.Error(w, "flushing needs a dpid", http.StatusBadRequest)
+			return
+		}
+		types := r.URL.Query()["type"]
+		for i, t := range types {
+			types[i] = strings.ToLower(t)
+		}
+		json.NewEncoder(w).Encode(sw.FlushQueue(types...))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
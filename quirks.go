@@ -0,0 +1,172 @@
+package ogo
+
+import (
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A Quirk works around the misbehavior of some switches. It is
+// applied to every switch whose description matches, so
+// applications don't need to know about vendor differences.
+type Quirk struct {
+	Name string
+	// Regular expressions matched against the description of
+	// the switch. Empty expressions match anything.
+	Manufacturer string
+	Hardware     string
+	Software     string
+
+	// The switch ignores idle timeouts. Flow mods with an idle
+	// timeout and no hard timeout get a hard timeout of the
+	// same length instead.
+	NoIdleTimeout bool
+	// The switch loses flow mods unless a barrier is sent
+	// after every BarrierEvery of them.
+	BarrierEvery int
+	// The switch misreports port speeds, so they shouldn't be
+	// trusted.
+	BadPortSpeeds bool
+	// If set, flow mods sent to the switch are paced.
+	Pacing *Pacing
+	// If set, called when a matching switch connects.
+	Apply func(sw *OFSwitch)
+
+	manufacturer *regexp.Regexp
+	hardware     *regexp.Regexp
+	software     *regexp.Regexp
+}
+
+func (q *Quirk) matches(d *SwitchDescription) bool {
+	return q.manufacturer.MatchString(d.Manufacturer) &&
+		q.hardware.MatchString(d.Hardware) &&
+		q.software.MatchString(d.Software)
+}
+
+var quirks = struct {
+	sync.RWMutex
+	list []*Quirk
+}{}
+
+// Registers q. Returns an error if one of its expressions
+// doesn't compile.
+func RegisterQuirk(q Quirk) error {
+	var err error
+	if q.manufacturer, err = regexp.Compile(q.Manufacturer); err != nil {
+		return err
+	}
+	if q.hardware, err = regexp.Compile(q.Hardware); err != nil {
+		return err
+	}
+	if q.software, err = regexp.Compile(q.Software); err != nil {
+		return err
+	}
+	quirks.Lock()
+	quirks.list = append(quirks.list, &q)
+	quirks.Unlock()
+	return nil
+}
+
+// The combined quirks applied to a switch.
+type appliedQuirks struct {
+	names         []string
+	noIdleTimeout bool
+	barrierEvery  int
+	badPortSpeeds bool
+
+	mu       sync.Mutex
+	flowMods int
+}
+
+// Applies every registered quirk matching the description of
+// Switch s.
+func (s *OFSwitch) applyQuirks() {
+	d := s.Description()
+	if d == nil {
+		return
+	}
+	quirks.RLock()
+	list := quirks.list
+	quirks.RUnlock()
+
+	var a *appliedQuirks
+	for _, q := range list {
+		if !q.matches(d) {
+			continue
+		}
+		log.Println("Applying quirk", q.Name, "to", SwitchLabel(s.DPID()))
+		if a == nil {
+			a = new(appliedQuirks)
+		}
+		a.names = append(a.names, q.Name)
+		a.noIdleTimeout = a.noIdleTimeout || q.NoIdleTimeout
+		a.badPortSpeeds = a.badPortSpeeds || q.BadPortSpeeds
+		if q.BarrierEvery > 0 && (a.barrierEvery == 0 || q.BarrierEvery < a.barrierEvery) {
+			a.barrierEvery = q.BarrierEvery
+		}
+		if q.Pacing != nil {
+			s.SetPacing(*q.Pacing)
+		}
+		if q.Apply != nil {
+			q.Apply(s)
+		}
+	}
+	s.quirkMu.Lock()
+	s.quirk = a
+	s.quirkMu.Unlock()
+}
+
+func (s *OFSwitch) quirks() *appliedQuirks {
+	s.quirkMu.RLock()
+	defer s.quirkMu.RUnlock()
+	return s.quirk
+}
+
+// Returns the names of the quirks applied to Switch s.
+func (s *OFSwitch) Quirks() []string {
+	if q := s.quirks(); q != nil {
+		return append([]string(nil), q.names...)
+	}
+	return nil
+}
+
+// Returns true if the port speeds reported by Switch s can be
+// trusted.
+func (s *OFSwitch) PortSpeedsReliable() bool {
+	q := s.quirks()
+	return q == nil || !q.badPortSpeeds
+}
+
+// Sends flow mod req with the workarounds in q.
+func (s *OFSwitch) sendQuirky(req util.Message, q *appliedQuirks) error {
+	if q.noIdleTimeout {
+		switch f := req.(type) {
+		case *ofp10.FlowMod:
+			if f.IdleTimeout != 0 && f.HardTimeout == 0 {
+				f.HardTimeout = f.IdleTimeout
+			}
+		case *ofp14.FlowMod:
+			if f.IdleTimeout != 0 && f.HardTimeout == 0 {
+				f.HardTimeout = f.IdleTimeout
+			}
+		}
+	}
+	if err := s.write(req); err != nil {
+		return err
+	}
+	if q.barrierEvery == 0 {
+		return nil
+	}
+	q.mu.Lock()
+	q.flowMods += 1
+	barrier := q.flowMods%q.barrierEvery == 0
+	q.mu.Unlock()
+	if barrier {
+		return s.write(s.newBarrierRequest())
+	}
+	return nil
+}
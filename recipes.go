@@ -0,0 +1,210 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The priority of new recipes.
+var RecipePriority uint16 = 1000
+
+// A Recipe is a common flow, such as dropping the traffic of a
+// port or punting a protocol to the controller, that can be
+// built as a flow mod for any OpenFlow version. Recipes are made
+// by DropFromPort, RedirectSubnet, MirrorTraffic,
+// RewriteDestination and PuntProtocol, and may be adjusted before
+// they are installed:
+//
+//	r := ogo.PuntProtocol(0x0800, 89) // OSPF
+//	r.Priority = 2000
+//	err := sw.InstallRecipe(r)
+type Recipe struct {
+	Priority    uint16
+	Cookie      uint64
+	IdleTimeout uint16
+	HardTimeout uint16
+	Match       FlowMatch
+	// Rewrites of the destination, applied before the packets
+	// are output. Nil fields aren't rewritten.
+	SetEthDst net.HardwareAddr
+	SetIPDst  net.IP
+	// Ports the packets are sent out, in OpenFlow 1.0
+	// numbering. Packets are dropped if there are none.
+	Outputs []uint16
+}
+
+func newRecipe(m FlowMatch, outputs ...uint16) *Recipe {
+	return &Recipe{Priority: RecipePriority, Match: m, Outputs: outputs}
+}
+
+// Returns a recipe dropping every packet received on port.
+func DropFromPort(port uint16) *Recipe {
+	return newRecipe(FlowMatch{InPort: port})
+}
+
+// Returns a recipe sending the IPv4 traffic to subnet out port.
+func RedirectSubnet(subnet *net.IPNet, port uint16) *Recipe {
+	return newRecipe(FlowMatch{EthType: 0x0800, IPDst: subnet.IP, IPDstMask: subnet.Mask}, port)
+}
+
+// Returns a recipe forwarding traffic matching m out ports, and a
+// copy of it out mirror.
+func MirrorTraffic(m FlowMatch, ports []uint16, mirror uint16) *Recipe {
+	return newRecipe(m, append(append([]uint16(nil), ports...), mirror)...)
+}
+
+// Returns a recipe rewriting the destination MAC, IP address or
+// both of traffic matching m, and sending it out port. mac or ip
+// may be nil to leave that address alone.
+func RewriteDestination(m FlowMatch, mac net.HardwareAddr, ip net.IP, port uint16) *Recipe {
+	r := newRecipe(m, port)
+	r.SetEthDst, r.SetIPDst = mac, ip
+	if ip != nil && r.Match.EthType == 0 {
+		r.Match.EthType = 0x0800
+	}
+	return r
+}
+
+// Returns a recipe sending the packets of a protocol to the
+// controller: those of ethType, and if ipProto isn't zero, of
+// that IP protocol.
+func PuntProtocol(ethType uint16, ipProto uint8) *Recipe {
+	return newRecipe(FlowMatch{EthType: ethType, IPProto: ipProto}, ofp10.P_CONTROLLER)
+}
+
+// Checks that r can be installed: its ports exist in OpenFlow
+// numbering, its addresses and masks are IPv4, and the fields it
+// matches and rewrites have the ethertype they need.
+func (r *Recipe) Validate() error {
+	m := r.Match
+	if err := m.validate(); err != nil {
+		return err
+	}
+	ip := m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0
+	if r.SetIPDst != nil && m.EthType != 0 && m.EthType != 0x0800 {
+		return fmt.Errorf("IP fields need ethertype 0x0800, not 0x%04x.", m.EthType)
+	}
+	if r.SetIPDst != nil && !ip && m.EthType != 0x0800 {
+		return errors.New("Rewriting the IP destination needs a match on IPv4 packets.")
+	}
+	if r.SetIPDst != nil && r.SetIPDst.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address.", r.SetIPDst)
+	}
+	if r.SetEthDst != nil && len(r.SetEthDst) != 6 {
+		return fmt.Errorf("%s is not an Ethernet address.", r.SetEthDst)
+	}
+	if (r.SetEthDst != nil || r.SetIPDst != nil) && len(r.Outputs) == 0 {
+		return errors.New("Rewritten packets must be output.")
+	}
+	seen := make(map[uint16]bool)
+	for _, p := range r.Outputs {
+		if err := m.validateOutput(p); err != nil {
+			return err
+		}
+		if seen[p] {
+			return fmt.Errorf("Port %d is output twice.", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// Checks that the ports of m exist in OpenFlow numbering, its
+// addresses and masks are IPv4, and the fields it matches have
+// the ethertype they need.
+func (m FlowMatch) validate() error {
+	if m.InPort > ofp10.P_MAX && m.InPort != ofp10.P_LOCAL {
+		return fmt.Errorf("Bad input port %d.", m.InPort)
+	}
+	for _, a := range []struct {
+		name string
+		ip   net.IP
+		mask net.IPMask
+	}{{"source", m.IPSrc, m.IPSrcMask}, {"destination", m.IPDst, m.IPDstMask}} {
+		if a.ip == nil {
+			if a.mask != nil {
+				return fmt.Errorf("IP %s mask without an address.", a.name)
+			}
+			continue
+		}
+		if a.ip.To4() == nil {
+			return fmt.Errorf("IP %s %s is not an IPv4 address.", a.name, a.ip)
+		}
+		if _, bits := a.mask.Size(); a.mask != nil && bits != 32 {
+			return fmt.Errorf("IP %s mask %s is not a prefix.", a.name, a.mask)
+		}
+	}
+	if (m.TPSrc != 0 || m.TPDst != 0) && m.IPProto != 6 && m.IPProto != 17 {
+		return errors.New("TCP or UDP ports need ip_proto 6 or 17.")
+	}
+	if (m.IPSrc != nil || m.IPDst != nil || m.IPProto != 0) && m.EthType != 0 && m.EthType != 0x0800 {
+		return fmt.Errorf("IP fields need ethertype 0x0800, not 0x%04x.", m.EthType)
+	}
+	return nil
+}
+
+// Checks that traffic matching m can be output to port p.
+func (m FlowMatch) validateOutput(p uint16) error {
+	if p == 0 || p > ofp10.P_MAX && p < ofp10.P_IN_PORT || p == ofp10.P_NONE {
+		return fmt.Errorf("Bad output port %d.", p)
+	}
+	if p == m.InPort && m.InPort != 0 {
+		return fmt.Errorf("Port %d is the input port; output to in_port.", p)
+	}
+	return nil
+}
+
+// Returns the FlowRule r stands for.
+func (r *Recipe) Rule() *FlowRule {
+	rule := &FlowRule{Priority: r.Priority, Cookie: r.Cookie, IdleTimeout: r.IdleTimeout,
+		HardTimeout: r.HardTimeout, Match: r.Match}
+	if r.SetEthDst != nil {
+		rule.Actions = append(rule.Actions, SetEthDst(r.SetEthDst))
+	}
+	if r.SetIPDst != nil {
+		rule.Actions = append(rule.Actions, SetIPDst(r.SetIPDst))
+	}
+	for _, p := range r.Outputs {
+		rule.Actions = append(rule.Actions, Output(p))
+	}
+	return rule
+}
+
+// Returns a flow mod adding r to a switch of OpenFlow version.
+func (r *Recipe) FlowMod(version uint8) (util.Message, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r.Rule().FlowMod(version)
+}
+
+// Returns a flow mod deleting r from a switch of OpenFlow
+// version.
+func (r *Recipe) DeleteMod(version uint8) (util.Message, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r.Rule().DeleteMod(version)
+}
+
+// Installs recipe r on Switch s.
+func (s *OFSwitch) InstallRecipe(r *Recipe) error {
+	f, err := r.FlowMod(s.Version())
+	if err != nil {
+		return err
+	}
+	return s.Send(f)
+}
+
+// Removes recipe r from Switch s.
+func (s *OFSwitch) RemoveRecipe(r *Recipe) error {
+	f, err := r.DeleteMod(s.Version())
+	if err != nil {
+		return err
+	}
+	return s.Send(f)
+}
@@ -0,0 +1,225 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A RuleList is an ordered list of rules of an application whose
+// priorities are assigned by the controller within a priority
+// band of the application, so its authors only decide which rule
+// wins over which. The first rule gets the highest priority.
+// Rules are spread over the band so that most insertions fit
+// between their neighbours; when an insertion doesn't fit, every
+// rule is spread again and the flows of the rules that moved are
+// replaced on the switches the list is installed on, the new
+// flow before the old one is deleted so traffic keeps matching.
+// Rules are moved in an order that never lets one pass another,
+// and the inserted rule is installed once they are in place.
+type RuleList struct {
+	Band PriorityBand
+	// The cookie of the flows of the list, which should belong
+	// to the application of Band, see RegisterFlowOwner.
+	Cookie uint64
+
+	mu       sync.Mutex
+	rules    []*Recipe
+	switches map[string]*OFSwitch
+}
+
+var errRuleNotListed = errors.New("The rule is not in the list.")
+
+func NewRuleList(band PriorityBand, cookie uint64) *RuleList {
+	l := new(RuleList)
+	l.Band = band
+	l.Cookie = cookie
+	l.rules = make([]*Recipe, 0)
+	l.switches = make(map[string]*OFSwitch)
+	return l
+}
+
+// Returns the rules of l in order. Their priorities are assigned
+// by l and must not be changed.
+func (l *RuleList) Rules() []*Recipe {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Recipe(nil), l.rules...)
+}
+
+// Adds r at the bottom of l.
+func (l *RuleList) Append(r *Recipe) error {
+	l.mu.Lock()
+	n := len(l.rules)
+	l.mu.Unlock()
+	return l.Insert(n, r)
+}
+
+// Adds r to l at index i, so it has a lower priority than the
+// rules before it and a higher one than those after it, and
+// installs it on the switches of l.
+func (l *RuleList) Insert(i int, r *Recipe) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i < 0 || i > len(l.rules) {
+		return fmt.Errorf("Index %d is outside the rule list.", i)
+	}
+	size := int(l.Band.Max-l.Band.Min) + 1
+	if len(l.rules) >= size {
+		return fmt.Errorf("Priority band %d-%d of %s is full.", l.Band.Min, l.Band.Max, l.Band.App)
+	}
+	r.Cookie = l.Cookie
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	// The priorities around the gap r goes in.
+	above, below := int(l.Band.Max)+1, int(l.Band.Min)-1
+	if i > 0 {
+		above = int(l.rules[i-1].Priority)
+	}
+	if i < len(l.rules) {
+		below = int(l.rules[i].Priority)
+	}
+	l.rules = append(l.rules, nil)
+	copy(l.rules[i+1:], l.rules[i:])
+	l.rules[i] = r
+	if above-below > 1 {
+		r.Priority = uint16((above + below) / 2)
+		l.installRule(r)
+		return nil
+	}
+
+	old := make(map[*Recipe]uint16, len(l.rules))
+	for _, o := range l.rules {
+		old[o] = o.Priority
+	}
+	l.spread()
+	for _, o := range l.moveOrder(r, old) {
+		l.moveRule(o, old[o])
+	}
+	l.installRule(r)
+	return nil
+}
+
+// Returns the rules of l other than r whose priority changed from
+// old, in the order to move them: those moving up top-down, then
+// those moving down bottom-up. Each rule then only moves towards
+// rules that already moved away, so rules keep their order while
+// flows are replaced. l.mu must be held.
+func (l *RuleList) moveOrder(r *Recipe, old map[*Recipe]uint16) []*Recipe {
+	a := make([]*Recipe, 0)
+	for _, o := range l.rules {
+		if o != r && o.Priority > old[o] {
+			a = append(a, o)
+		}
+	}
+	for k := len(l.rules) - 1; k >= 0; k-- {
+		if o := l.rules[k]; o != r && o.Priority < old[o] {
+			a = append(a, o)
+		}
+	}
+	return a
+}
+
+// Removes r from l and deletes its flow from the switches of l.
+func (l *RuleList) Remove(r *Recipe) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, o := range l.rules {
+		if o == r {
+			l.rules = append(l.rules[:i], l.rules[i+1:]...)
+			for _, sw := range l.switches {
+				l.send(sw, r, true)
+			}
+			return nil
+		}
+	}
+	return errRuleNotListed
+}
+
+// Assigns the rules of l priorities evenly spaced over the band,
+// leaving the same gap above the first and below the last rule.
+// l.mu must be held.
+func (l *RuleList) spread() {
+	size := int(l.Band.Max-l.Band.Min) + 1
+	step := size / (len(l.rules) + 1)
+	for k, r := range l.rules {
+		if step == 0 {
+			r.Priority = l.Band.Max - uint16(k)
+		} else {
+			r.Priority = uint16(int(l.Band.Max) + 1 - (k+1)*step)
+		}
+	}
+}
+
+// Installs the rules of l on Switch sw, and keeps sw updated as
+// l changes until Uninstall.
+func (l *RuleList) Install(sw *OFSwitch) error {
+	if sw.Version() == ofp10.VERSION && l.Band.Table != 0 {
+		return errors.New("OpenFlow 1.0 switches only have table 0.")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.switches[sw.DPID().String()] = sw
+	for _, r := range l.rules {
+		if err := l.send(sw, r, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes the rules of l from Switch sw and stops updating it.
+func (l *RuleList) Uninstall(sw *OFSwitch) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.switches, sw.DPID().String())
+	for _, r := range l.rules {
+		if err := l.send(sw, r, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Installs r on the switches of l. l.mu must be held.
+func (l *RuleList) installRule(r *Recipe) {
+	for _, sw := range l.switches {
+		l.send(sw, r, false)
+	}
+}
+
+// Replaces the flow of r at priority old with one at its new
+// priority on the switches of l. l.mu must be held.
+func (l *RuleList) moveRule(r *Recipe, old uint16) {
+	moved := *r
+	moved.Priority = old
+	for _, sw := range l.switches {
+		if l.send(sw, r, false) == nil {
+			l.send(sw, &moved, true)
+		}
+	}
+}
+
+// Adds the flow of r to Switch sw, or deletes it.
+func (l *RuleList) send(sw *OFSwitch, r *Recipe, del bool) error {
+	var f util.Message
+	var err error
+	if del {
+		f, err = r.DeleteMod(sw.Version())
+	} else {
+		f, err = r.FlowMod(sw.Version())
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := f.(*ofp14.FlowMod); ok {
+		f.TableId = l.Band.Table
+	}
+	return sw.Send(f)
+}
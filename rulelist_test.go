@@ -0,0 +1,125 @@
+package ogo
+
+import (
+	"testing"
+)
+
+func testRecipe(port uint16) *Recipe {
+	return &Recipe{Match: FlowMatch{InPort: port}}
+}
+
+func TestRuleListInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		min     uint16
+		max     uint16
+		inserts []int // Index of each insertion
+		// The priorities of the rules, in order, afterwards.
+		priorities []uint16
+	}{
+		{"append", 100, 199, []int{0, 1, 2}, []uint16{149, 124, 111}},
+		{"insert at top", 100, 199, []int{0, 0, 0}, []uint16{187, 174, 149}},
+		{"insert between", 100, 199, []int{0, 1, 1}, []uint16{149, 136, 124}},
+		// The last rule doesn't fit, so all are respaced.
+		{"respace", 100, 103, []int{0, 1, 1}, []uint16{103, 102, 101}},
+		{"respace evenly", 100, 129, []int{0, 0, 0, 0, 0, 0}, []uint16{126, 122, 118, 114, 110, 106}},
+	}
+	for _, test := range tests {
+		l := NewRuleList(PriorityBand{App: "test", Min: test.min, Max: test.max}, 1)
+		for k, i := range test.inserts {
+			if err := l.Insert(i, testRecipe(uint16(k+1))); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		rules := l.Rules()
+		for k, r := range rules {
+			if k >= len(test.priorities) || r.Priority != test.priorities[k] {
+				t.Errorf("%s: rule %d has priority %d, expected %v.", test.name, k, r.Priority, test.priorities)
+				break
+			}
+		}
+	}
+
+	l := NewRuleList(PriorityBand{App: "test", Min: 100, Max: 101}, 1)
+	l.Append(testRecipe(1))
+	l.Append(testRecipe(2))
+	if err := l.Append(testRecipe(3)); err == nil {
+		t.Error("Inserted a rule into a full band.")
+	}
+}
+
+// Moves the rules of a respaced list one at a time, adding the new
+// flow before deleting the old one, and checks that the rules keep
+// their order at every step.
+func TestRuleListMoveOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []uint16 // Priorities of the listed rules
+		insert int
+	}{
+		{"insert at top", []uint16{109, 108, 107, 106}, 0},
+		{"insert in the middle", []uint16{109, 108, 107, 106}, 2},
+		{"insert at bottom", []uint16{103, 102, 101, 100}, 4},
+		{"shift up", []uint16{104, 103, 102, 101, 100}, 5},
+	}
+	for _, test := range tests {
+		l := NewRuleList(PriorityBand{App: "test", Min: 100, Max: 109}, 1)
+		for k, p := range test.before {
+			r := testRecipe(uint16(k + 1))
+			r.Priority = p
+			l.rules = append(l.rules, r)
+		}
+		r := testRecipe(100)
+		l.rules = append(l.rules, nil)
+		copy(l.rules[test.insert+1:], l.rules[test.insert:])
+		l.rules[test.insert] = r
+		old := make(map[*Recipe]uint16)
+		for _, o := range l.rules {
+			old[o] = o.Priority
+		}
+		l.spread()
+
+		// The priorities of the flows of each rule on the switch.
+		flows := make(map[*Recipe][]uint16)
+		for _, o := range l.rules {
+			if o != r {
+				flows[o] = []uint16{old[o]}
+			}
+		}
+		check := func(step string) {
+			prev := -1
+			for _, o := range l.rules {
+				fs, ok := flows[o]
+				if !ok {
+					continue
+				}
+				// Packets matching both rules hit the
+				// highest flow of each.
+				top := 0
+				for _, p := range fs {
+					if int(p) > top {
+						top = int(p)
+					}
+				}
+				if prev >= 0 && top >= prev {
+					t.Errorf("%s: rules out of order %s: %v", test.name, step, flows)
+					return
+				}
+				prev = top
+			}
+		}
+		for _, o := range l.moveOrder(r, old) {
+			flows[o] = append(flows[o], o.Priority)
+			check("while moving")
+			flows[o] = []uint16{o.Priority}
+			check("after moving")
+		}
+		for _, o := range l.rules {
+			if o != r && flows[o][0] != o.Priority {
+				t.Errorf("%s: rule at %d wasn't moved to %d.", test.name, flows[o][0], o.Priority)
+			}
+		}
+		flows[r] = []uint16{r.Priority}
+		check("after installing the new rule")
+	}
+}
@@ -0,0 +1,270 @@
+package scenario
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/emulator"
+	"github.com/jonstout/ogo/protocol/arp"
+	"github.com/jonstout/ogo/protocol/eth"
+)
+
+// How often expectations are checked while they are waited for.
+var PollInterval = time.Millisecond * 100
+
+// A Runner runs scenarios against the controller of this process,
+// which must be listening at Addr.
+type Runner struct {
+	Addr string
+	// How long expectations are waited for when a step doesn't
+	// say.
+	Timeout time.Duration
+	// If set, called before each step is done.
+	Log func(format string, a ...interface{})
+}
+
+func NewRunner(addr string) *Runner {
+	return &Runner{Addr: addr, Timeout: time.Second * 10}
+}
+
+// The outcome of a step. Error is empty if the step succeeded.
+type StepResult struct {
+	At    Duration `json:"at"`
+	Do    string   `json:"do,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+type Result struct {
+	Name     string        `json:"name"`
+	Steps    []StepResult  `json:"steps"`
+	Failures int           `json:"failures"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// A scenario being run.
+type run struct {
+	runner   *Runner
+	network  *emulator.Network
+	switches map[string]*emulator.Switch
+	hosts    map[string]HostSpec
+
+	eventsMu sync.Mutex
+	events   []ogo.Event
+}
+
+// Runs scenario sc: builds its network, connects the switches,
+// runs the steps in the order of their times and disconnects the
+// switches again. A failed step doesn't stop the scenario. Returns
+// an error if the network couldn't be set up.
+func (r *Runner) Run(sc *Scenario) (*Result, error) {
+	if err := sc.check(); err != nil {
+		return nil, err
+	}
+	x := &run{runner: r, network: emulator.NewNetwork(), switches: make(map[string]*emulator.Switch),
+		hosts: make(map[string]HostSpec)}
+	for _, s := range sc.Switches {
+		dpid, _ := net.ParseMAC(s.DPID)
+		x.switches[s.Name] = x.network.AddSwitch(dpid, s.Ports)
+	}
+	for _, l := range sc.Links {
+		ends := strings.Fields(l)
+		a, ap := x.port(ends[0])
+		b, bp := x.port(ends[1])
+		x.network.Link(a, ap, b, bp)
+	}
+	for _, h := range sc.Hosts {
+		x.hosts[h.Name] = h
+	}
+
+	sub := ogo.Subscribe(4096)
+	defer sub.Cancel()
+	go func() {
+		for e := range sub.C {
+			x.eventsMu.Lock()
+			x.events = append(x.events, e)
+			x.eventsMu.Unlock()
+		}
+	}()
+	defer func() {
+		for _, s := range x.switches {
+			s.Close()
+		}
+	}()
+	for _, s := range sc.Switches {
+		if err := x.dial(x.switches[s.Name]); err != nil {
+			return nil, fmt.Errorf("Failed to connect switch %s: %v", s.Name, err)
+		}
+	}
+
+	steps := make([]Step, len(sc.Steps))
+	copy(steps, sc.Steps)
+	sort.Stable(byTime(steps))
+	result := &Result{Name: sc.Name, Steps: make([]StepResult, 0)}
+	start := time.Now()
+	for _, st := range steps {
+		time.Sleep(start.Add(time.Duration(st.At)).Sub(time.Now()))
+		if r.Log != nil {
+			r.Log("%s %s", time.Duration(st.At), st.Do)
+		}
+		res := StepResult{At: st.At, Do: st.Do}
+		if err := x.step(st); err != nil {
+			res.Error = err.Error()
+			result.Failures += 1
+		}
+		result.Steps = append(result.Steps, res)
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+type byTime []Step
+
+func (s byTime) Len() int           { return len(s) }
+func (s byTime) Less(i, j int) bool { return s[i].At < s[j].At }
+func (s byTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the switch and port number of port p, written "s1:3".
+func (x *run) port(p string) (*emulator.Switch, uint16) {
+	name, n, _ := splitPort(p)
+	return x.switches[name], n
+}
+
+// Connects s to the controller, retrying while it may still be
+// starting.
+func (x *run) dial(s *emulator.Switch) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = s.Dial(x.runner.Addr); err == nil {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return err
+}
+
+// Does the action of st, then waits for its expectations.
+func (x *run) step(st Step) error {
+	x.eventsMu.Lock()
+	mark := len(x.events)
+	x.eventsMu.Unlock()
+
+	if w := strings.Fields(st.Do); len(w) > 0 {
+		var err error
+		switch w[0] {
+		case "link":
+			s, p := x.port(w[2])
+			x.network.SetLink(s, p, w[1] == "up")
+		case "switch":
+			if w[1] == "up" {
+				err = x.dial(x.switches[w[2]])
+			} else {
+				x.switches[w[2]].Close()
+			}
+		case "send":
+			err = x.send(x.hosts[w[1]], x.hosts[w[2]])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if st.Expect == nil {
+		return nil
+	}
+	within := time.Duration(st.Expect.Within)
+	if within == 0 {
+		within = x.runner.Timeout
+	}
+	deadline := time.Now().Add(within)
+	for {
+		err := x.expect(st.Expect, mark)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+// Sends an ARP request for the address of host to from host from,
+// into the port from is attached to.
+func (x *run) send(from, to HostSpec) error {
+	a, _ := arp.New(arp.Type_Request)
+	a.HWSrc, _ = net.ParseMAC(from.MAC)
+	if from.IP != "" {
+		a.IPSrc = net.ParseIP(from.IP).To4()
+	}
+	if to.IP != "" {
+		a.IPDst = net.ParseIP(to.IP).To4()
+	}
+	e := eth.New()
+	e.HWSrc = a.HWSrc
+	e.HWDst, _ = net.ParseMAC(to.MAC)
+	e.Ethertype = eth.ARP_MSG
+	e.Data = a
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s, p := x.port(from.Port)
+	return s.Receive(p, data)
+}
+
+// Returns an error describing the first expectation of e that
+// doesn't hold, counting the events published since the mark'th.
+func (x *run) expect(e *Expect, mark int) error {
+	if e.Switches != nil {
+		n := 0
+		for _, sw := range ogo.Switches() {
+			// Disconnected switches are kept for a while.
+			if sw.Uptime() > 0 {
+				n += 1
+			}
+		}
+		if n != *e.Switches {
+			return fmt.Errorf("%d switches connected, expected %d", n, *e.Switches)
+		}
+	}
+	if e.Links != nil {
+		if n := len(ogo.CurrentTopology().Links); n != *e.Links {
+			return fmt.Errorf("%d links discovered, expected %d", n, *e.Links)
+		}
+	}
+	names := make([]string, 0, len(e.Flows))
+	for name := range e.Flows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if n := x.switches[name].Flows(); n != e.Flows[name] {
+			return fmt.Errorf("switch %s has %d flows, expected %d", name, n, e.Flows[name])
+		}
+	}
+	x.eventsMu.Lock()
+	events := x.events[mark:]
+	x.eventsMu.Unlock()
+	for _, want := range e.Events {
+		if !x.published(events, want) {
+			return fmt.Errorf("no %s event", want)
+		}
+	}
+	return nil
+}
+
+// Returns whether events has one matching want, a type optionally
+// followed by a switch name.
+func (x *run) published(events []ogo.Event, want string) bool {
+	w := strings.Fields(want)
+	for _, e := range events {
+		if e.Type != w[0] {
+			continue
+		}
+		if len(w) == 1 || e.DPID.String() == x.switches[w[1]].DPID.String() {
+			return true
+		}
+	}
+	return false
+}
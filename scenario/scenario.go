@@ -0,0 +1,293 @@
+// Package scenario runs scenarios against the controller: a
+// virtual network of emulated switches, links and hosts is built
+// from a YAML description, a timeline of traffic and failures is
+// played against it, and the flows the controller installs and
+// the events it publishes are checked against expectations. The
+// same scenario gives the same run every time, so scenarios serve
+// as demos and as regression tests.
+//
+//	name: two switches
+//	switches:
+//	  - name: s1
+//	    dpid: 00:00:00:00:00:00:00:01
+//	    ports: 3
+//	  - name: s2
+//	    dpid: 00:00:00:00:00:00:00:02
+//	    ports: 3
+//	links:
+//	  - s1:1 s2:1
+//	hosts:
+//	  - name: h1
+//	    mac: 02:00:00:00:00:01
+//	    ip: 10.0.0.1
+//	    port: s1:3
+//	  - name: h2
+//	    mac: 02:00:00:00:00:02
+//	    ip: 10.0.0.2
+//	    port: s2:3
+//	steps:
+//	  - at: 0s
+//	    expect:
+//	      switches: 2
+//	      links: 2
+//	  - at: 1s
+//	    do: send h1 h2
+//	  - at: 2s
+//	    do: link down s1:1
+//	    expect:
+//	      within: 10s
+//	      events: [link.down s1]
+//
+// The actions a step can do are:
+//
+//	link down|up <switch>:<port>   take a link down or bring it back
+//	switch down|up <switch>        disconnect a switch or reconnect it
+//	send <host> <host>             the first host sends the second an ARP request
+//
+// and a step can expect the number of switches connected to the
+// controller, the number of unidirectional links it discovered,
+// the number of flows in the tables of switches, and events
+// published since the step started, each a type optionally
+// followed by the switch the event is about. Expectations are
+// waited for until they hold, for at most within.
+package scenario
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Duration is written as a string like "1.5s" or "200ms", or as
+// a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case float64:
+		*d = Duration(t * float64(time.Second))
+	case string:
+		p, err := time.ParseDuration(t)
+		if err != nil {
+			return err
+		}
+		*d = Duration(p)
+	default:
+		return fmt.Errorf("bad duration %s", data)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Scenario struct {
+	Name     string       `json:"name"`
+	Switches []SwitchSpec `json:"switches"`
+	// Links between two switch ports, written "s1:1 s2:1".
+	Links []string   `json:"links,omitempty"`
+	Hosts []HostSpec `json:"hosts,omitempty"`
+	// Run in the order of their times.
+	Steps []Step `json:"steps"`
+}
+
+// An emulated OpenFlow 1.0 switch with ports numbered from 1.
+type SwitchSpec struct {
+	Name  string `json:"name"`
+	DPID  string `json:"dpid"`
+	Ports int    `json:"ports"`
+}
+
+// A host attached to Port, written "s1:3".
+type HostSpec struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	IP   string `json:"ip,omitempty"`
+	Port string `json:"port"`
+}
+
+// Something done At a time after the start of the scenario, then
+// what is expected to follow. Either may be left out.
+type Step struct {
+	At     Duration `json:"at"`
+	Do     string   `json:"do,omitempty"`
+	Expect *Expect  `json:"expect,omitempty"`
+}
+
+// The state expected after a step. Unset fields aren't checked.
+type Expect struct {
+	// How long to wait for the expectations to hold. Defaults
+	// to the Timeout of the Runner.
+	Within   Duration `json:"within,omitempty"`
+	Switches *int     `json:"switches,omitempty"`
+	Links    *int     `json:"links,omitempty"`
+	// The number of flows of each switch, by name.
+	Flows map[string]int `json:"flows,omitempty"`
+	// Event types, each optionally followed by the name of the
+	// switch the event is about, like "link.down s1".
+	Events []string `json:"events,omitempty"`
+}
+
+// Parses a scenario written in YAML. The subset of YAML supported
+// is that of block mappings and sequences, plain and quoted
+// scalars and flow sequences like [a, b], which is also enough to
+// read JSON written one value per line.
+func Parse(data []byte) (*Scenario, error) {
+	v, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	// The generic value converts to the structs the way JSON
+	// would.
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sc := new(Scenario)
+	if err := json.Unmarshal(js, sc); err != nil {
+		return nil, err
+	}
+	if err := sc.check(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Reads the scenario in the file at path.
+func Load(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sc, nil
+}
+
+// Returns an error if sc refers to switches, ports or hosts it
+// doesn't have, or has a step that can't be done.
+func (sc *Scenario) check() error {
+	switches := make(map[string]int)
+	for _, s := range sc.Switches {
+		if _, dup := switches[s.Name]; dup || s.Name == "" {
+			return fmt.Errorf("switch name %q is empty or taken", s.Name)
+		}
+		if _, err := net.ParseMAC(s.DPID); err != nil {
+			return fmt.Errorf("switch %s has bad dpid %q", s.Name, s.DPID)
+		}
+		if s.Ports < 1 || s.Ports > 0xff00 {
+			return fmt.Errorf("switch %s has %d ports", s.Name, s.Ports)
+		}
+		switches[s.Name] = s.Ports
+	}
+	port := func(p string) error {
+		name, n, err := splitPort(p)
+		if err != nil {
+			return err
+		}
+		if ports, ok := switches[name]; !ok || int(n) > ports {
+			return fmt.Errorf("no port %s", p)
+		}
+		return nil
+	}
+	for _, l := range sc.Links {
+		ends := strings.Fields(l)
+		if len(ends) != 2 {
+			return fmt.Errorf("link %q doesn't have two ends", l)
+		}
+		for _, p := range ends {
+			if err := port(p); err != nil {
+				return fmt.Errorf("link %q: %v", l, err)
+			}
+		}
+	}
+	hosts := make(map[string]bool)
+	for _, h := range sc.Hosts {
+		if hosts[h.Name] || h.Name == "" {
+			return fmt.Errorf("host name %q is empty or taken", h.Name)
+		}
+		hosts[h.Name] = true
+		if _, err := net.ParseMAC(h.MAC); err != nil {
+			return fmt.Errorf("host %s has bad mac %q", h.Name, h.MAC)
+		}
+		if h.IP != "" && net.ParseIP(h.IP).To4() == nil {
+			return fmt.Errorf("host %s has bad ip %q", h.Name, h.IP)
+		}
+		if err := port(h.Port); err != nil {
+			return fmt.Errorf("host %s: %v", h.Name, err)
+		}
+	}
+	for i, st := range sc.Steps {
+		if err := checkStep(st, switches, hosts, port); err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func checkStep(st Step, switches map[string]int, hosts map[string]bool, port func(string) error) error {
+	if st.At < 0 {
+		return errors.New("negative time")
+	}
+	if w := strings.Fields(st.Do); len(w) > 0 {
+		switch {
+		case w[0] == "link" && len(w) == 3 && (w[1] == "down" || w[1] == "up"):
+			if err := port(w[2]); err != nil {
+				return err
+			}
+		case w[0] == "switch" && len(w) == 3 && (w[1] == "down" || w[1] == "up"):
+			if _, ok := switches[w[2]]; !ok {
+				return fmt.Errorf("no switch %s", w[2])
+			}
+		case w[0] == "send" && len(w) == 3:
+			if !hosts[w[1]] || !hosts[w[2]] {
+				return fmt.Errorf("no host %s or %s", w[1], w[2])
+			}
+		default:
+			return fmt.Errorf("unknown action %q", st.Do)
+		}
+	}
+	if st.Expect == nil {
+		return nil
+	}
+	for name := range st.Expect.Flows {
+		if _, ok := switches[name]; !ok {
+			return fmt.Errorf("no switch %s", name)
+		}
+	}
+	for _, e := range st.Expect.Events {
+		w := strings.Fields(e)
+		if len(w) == 0 || len(w) > 2 {
+			return fmt.Errorf("bad event %q", e)
+		}
+		if _, ok := switches[w[len(w)-1]]; len(w) == 2 && !ok {
+			return fmt.Errorf("no switch %s", w[1])
+		}
+	}
+	return nil
+}
+
+// Splits "s1:3" into the switch name and the port number.
+func splitPort(p string) (string, uint16, error) {
+	n := strings.LastIndex(p, ":")
+	if n < 0 {
+		return "", 0, fmt.Errorf("port %q isn't written switch:port", p)
+	}
+	port, err := strconv.ParseUint(p[n+1:], 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("bad port %q", p)
+	}
+	return p[:n], uint16(port), nil
+}
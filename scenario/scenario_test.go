@@ -0,0 +1,118 @@
+package scenario
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo"
+)
+
+const twoSwitches = `
+name: two switches # linked once
+switches:
+  - name: s1
+    dpid: 00:00:00:00:00:00:00:01
+    ports: 3
+  - name: s2
+    dpid: "00:00:00:00:00:00:00:02"
+    ports: 3
+links:
+- s1:1 s2:1
+hosts:
+  - name: h1
+    mac: 02:00:00:00:00:01
+    ip: 10.0.0.1
+    port: s1:3
+  - {name: h2}
+steps:
+  - at: 0
+    expect:
+      within: 15s
+      switches: 2
+      links: 2
+      events: [switch.up s1, switch.up s2]
+  - at: 500ms
+    do: send h1 h1
+  - at: 1s
+    do: switch down s2
+    expect:
+      switches: 1
+      events:
+        - switch.down s2
+`
+
+func TestParseYAML(t *testing.T) {
+	v, err := parseYAML([]byte(`
+a: 1
+b:
+  - x
+  - 'it''s'
+  - - 2
+    - true
+c:
+  d: [1, "#e"]  # comment
+  f:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": 1.0,
+		"b": []interface{}{"x", "it's", []interface{}{2.0, true}},
+		"c": map[string]interface{}{"d": []interface{}{1.0, "#e"}, "f": nil},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Got %#v.", v)
+	}
+	for _, bad := range []string{"a: 1\n  b: 2", "a: 1\na: 2", "a: [1, 2", "- a\nb: 1"} {
+		if _, err := parseYAML([]byte(bad)); err == nil {
+			t.Errorf("Parsed %q.", bad)
+		}
+	}
+}
+
+func TestParseScenario(t *testing.T) {
+	// Flow mappings aren't supported.
+	if _, err := Parse([]byte(twoSwitches)); err == nil {
+		t.Error("Parsed a flow mapping.")
+	}
+	text := strings.Replace(twoSwitches, "  - {name: h2}\n", "", 1)
+	sc, err := Parse([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Switches) != 2 || sc.Switches[1].DPID != "00:00:00:00:00:00:00:02" || len(sc.Links) != 1 {
+		t.Errorf("Got %+v.", sc)
+	}
+	if len(sc.Steps) != 3 || sc.Steps[1].At != Duration(time.Millisecond*500) || *sc.Steps[0].Expect.Links != 2 {
+		t.Errorf("Got steps %+v.", sc.Steps)
+	}
+	for _, bad := range []string{
+		strings.Replace(text, "s1:1 s2:1", "s1:1 s2:4", 1),
+		strings.Replace(text, "send h1 h1", "send h1 h3", 1),
+		strings.Replace(text, "switch down s2", "switch sideways s2", 1),
+		strings.Replace(text, "switch.down s2", "switch.down s3", 1),
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parsed %q.", bad)
+		}
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	ctrl := ogo.NewController()
+	go ctrl.Listen("127.0.0.1:16653")
+	sc, err := Parse([]byte(strings.Replace(twoSwitches, "  - {name: h2}\n", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewRunner("127.0.0.1:16653").Run(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failures != 0 || len(result.Steps) != 3 {
+		t.Errorf("Got %+v.", result)
+	}
+}
@@ -0,0 +1,219 @@
+package scenario
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A line of a YAML document, without its indentation and comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// Parses the subset of YAML scenarios are written in: block
+// mappings and sequences, scalars, which may be quoted, and flow
+// sequences of scalars like [a, b]. Anchors, multi-line scalars,
+// flow mappings and multiple documents aren't supported. Mappings
+// become map[string]interface{}, sequences []interface{}, and
+// scalars strings, float64s, bools or nil, as encoding/json would
+// decode them.
+func parseYAML(data []byte) (interface{}, error) {
+	lines := make([]yamlLine, 0)
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripComment(text), " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't indent YAML", i+1)
+		}
+		lines = append(lines, yamlLine{i + 1, len(text) - len(trimmed), trimmed})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	v, n, err := parseBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(lines) {
+		return nil, fmt.Errorf("line %d: bad indentation", lines[n].number)
+	}
+	return v, nil
+}
+
+// Returns text without a comment, which starts with a # at the
+// start of the line or after a space, outside quotes.
+func stripComment(text string) string {
+	var quote rune
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// Parses the block of lines starting at lines[i] that are
+// indented by indent, and returns its value and the index of the
+// line after it.
+func parseBlock(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if isItem(lines[i].text) {
+		return parseSequence(lines, i, indent)
+	}
+	if _, _, ok := splitKey(lines[i].text); ok {
+		return parseMapping(lines, i, indent)
+	}
+	if i+1 < len(lines) && lines[i+1].indent >= indent {
+		return nil, i, fmt.Errorf("line %d: a scalar can't be followed by more lines", lines[i+1].number)
+	}
+	v, err := parseScalar(lines[i].text)
+	if err != nil {
+		return nil, i, fmt.Errorf("line %d: %v", lines[i].number, err)
+	}
+	return v, i + 1, nil
+}
+
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseSequence(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	seq := make([]interface{}, 0)
+	for i < len(lines) && lines[i].indent == indent && isItem(lines[i].text) {
+		rest := strings.TrimLeft(lines[i].text[1:], " ")
+		var v interface{}
+		var err error
+		if rest == "" {
+			v, i, err = parseNested(lines, i+1, indent)
+		} else {
+			// The item continues on the following lines at the
+			// indentation of its text, like a mapping after "- ".
+			itemIndent := indent + len(lines[i].text) - len(rest)
+			lines[i] = yamlLine{lines[i].number, itemIndent, rest}
+			v, i, err = parseBlock(lines, i, itemIndent)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, i, nil
+}
+
+func parseMapping(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	m := make(map[string]interface{})
+	for i < len(lines) && lines[i].indent == indent && !isItem(lines[i].text) {
+		key, rest, ok := splitKey(lines[i].text)
+		if !ok {
+			return nil, i, fmt.Errorf("line %d: expected a key", lines[i].number)
+		}
+		if _, dup := m[key]; dup {
+			return nil, i, fmt.Errorf("line %d: duplicate key %s", lines[i].number, key)
+		}
+		var v interface{}
+		var err error
+		if rest == "" {
+			// A sequence may be indented as much as its key.
+			if i+1 < len(lines) && lines[i+1].indent == indent && isItem(lines[i+1].text) {
+				v, i, err = parseSequence(lines, i+1, indent)
+			} else {
+				v, i, err = parseNested(lines, i+1, indent)
+			}
+		} else {
+			v, err = parseScalar(rest)
+			if err != nil {
+				err = fmt.Errorf("line %d: %v", lines[i].number, err)
+			}
+			i += 1
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		m[key] = v
+	}
+	return m, i, nil
+}
+
+// Parses the block starting at lines[i] if it is indented more
+// than indent, otherwise returns nil for an empty value.
+func parseNested(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if i >= len(lines) || lines[i].indent <= indent {
+		return nil, i, nil
+	}
+	return parseBlock(lines, i, lines[i].indent)
+}
+
+// Splits "key: value" or "key:" into the key and the value.
+func splitKey(text string) (key, rest string, ok bool) {
+	if text[0] == '"' || text[0] == '\'' || text[0] == '[' {
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	n := strings.Index(text, ": ")
+	if n < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(text[:n]), strings.TrimSpace(text[n+2:]), true
+}
+
+func parseScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated sequence %s", text)
+		}
+		seq := make([]interface{}, 0)
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return seq, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			v, err := parseScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case strings.HasPrefix(text, "\""):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("bad quoted string %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	switch text {
+	case "~", "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	// Leaves words like inf and nan alone.
+	if strings.IndexAny(text[:1], "0123456789+-.") == 0 {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, nil
+		}
+	}
+	return text, nil
+}
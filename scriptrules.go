@@ -0,0 +1,132 @@
+package ogo
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// The script engine for ".rules" files, which are written in the
+// policy language, see Policy, without templates or triggers:
+//
+//	# Flood ARP, and keep web traffic to 10.0.0.80 on the switch.
+//	ports web = 2
+//	rule 0 match eth_type=0x0806 action output flood
+//	rule 100 match eth_type=0x0800 ip_dst=10.0.0.80 action output $web
+//
+// Each packet-in is checked against the rules from the highest
+// priority down. The first rule that matches consumes the packet:
+// it is sent out the rule's ports, or dropped, and the rule's flow
+// is installed unless its priority is 0, so later packets stay on
+// the switch. Packets are only sent on OpenFlow 1.0 switches.
+// Packets matching no rule are left to the next handler.
+type RulesScriptEngine struct{}
+
+func (RulesScriptEngine) Compile(name string, src []byte, api *ScriptAPI) (PacketInHandler, error) {
+	p, err := ParsePolicy(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Templates) > 0 || len(p.Triggers) > 0 {
+		return nil, errors.New("Scripts can't have templates or triggers.")
+	}
+	errs := make(PolicyErrors, 0)
+	for _, r := range p.Rules {
+		if r.Priority > ScriptMaxFlowPriority {
+			errs = append(errs, &PolicyError{Line: r.Line, Msg: ErrScriptPriority.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	flows, err := p.Compile()
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(policyFlowsByPriority(flows))
+	return &rulesScript{api, flows}, nil
+}
+
+type policyFlowsByPriority []PolicyFlow
+
+func (a policyFlowsByPriority) Len() int           { return len(a) }
+func (a policyFlowsByPriority) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a policyFlowsByPriority) Less(i, j int) bool { return a[i].Priority > a[j].Priority }
+
+type rulesScript struct {
+	api   *ScriptAPI
+	flows []PolicyFlow
+}
+
+func (s *rulesScript) HandlePacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) bool {
+	f, ok := s.match(dpid, pkt)
+	if !ok {
+		return false
+	}
+	var err error
+	switch {
+	case f.Priority == 0:
+	case len(f.Ports) == 0:
+		err = s.api.Drop(dpid, f.Match, f.Priority)
+	default:
+		err = s.api.Forward(dpid, f.Match, f.Priority, f.Ports)
+	}
+	if err != nil {
+		s.api.Log("failed to install flow:", err)
+	}
+	if len(f.Ports) > 0 {
+		if err := s.api.Reply(dpid, pkt, f.Ports); err != nil {
+			s.api.Log("failed to send packet:", err)
+		}
+	}
+	return true
+}
+
+// Returns the first flow of the script matching pkt.
+func (s *rulesScript) match(dpid net.HardwareAddr, pkt *ofp10.PacketIn) (PolicyFlow, bool) {
+	for _, f := range s.flows {
+		if f.DPID != nil && f.DPID.String() != dpid.String() {
+			continue
+		}
+		if f.Match.matchesPacket(pkt.InPort, &pkt.Data) {
+			return f, true
+		}
+	}
+	return PolicyFlow{}, false
+}
+
+// Returns true if the frame e, received on port inPort, matches m.
+// Transport ports and VLANs aren't checked.
+func (m FlowMatch) matchesPacket(inPort uint16, e *eth.Ethernet) bool {
+	if m.InPort != 0 && m.InPort != inPort {
+		return false
+	}
+	if m.EthSrc != nil && !bytes.Equal(m.EthSrc, e.HWSrc) {
+		return false
+	}
+	if m.EthDst != nil && !bytes.Equal(m.EthDst, e.HWDst) {
+		return false
+	}
+	if m.EthType != 0 && m.EthType != e.Ethertype {
+		return false
+	}
+	if m.IPSrc == nil && m.IPDst == nil && m.IPProto == 0 {
+		return true
+	}
+	ip, ok := e.Data.(*ipv4.IPv4)
+	if !ok || e.Ethertype != eth.IPv4_MSG {
+		return false
+	}
+	if m.IPSrc != nil && !maskIP(m.IPSrc, m.IPSrcMask).Equal(maskIP(ip.NWSrc, m.IPSrcMask)) {
+		return false
+	}
+	if m.IPDst != nil && !maskIP(m.IPDst, m.IPDstMask).Equal(maskIP(ip.NWDst, m.IPDstMask)) {
+		return false
+	}
+	return m.IPProto == 0 || m.IPProto == ip.Protocol
+}
@@ -0,0 +1,309 @@
+package ogo
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Priority of script packet-in handlers, below every handler of
+// the bundled applications.
+var ScriptPriority = 0
+
+// Scripts may only install flows at or below this priority, so
+// they can't override the flows of other applications.
+var ScriptMaxFlowPriority uint16 = 0x7fff
+
+// The top byte of the cookie of every flow installed by a script.
+// The rest of the cookie is a hash of the script's name, so a
+// script only ever removes its own flows.
+var ScriptCookie uint64 = 0x5c << 56
+
+const scriptCookieMask = 0xff << 56
+
+// A ScriptEngine compiles scripts in one language into packet-in
+// handlers. RulesScriptEngine is bundled for ".rules" files. An
+// engine wrapping a general purpose interpreter, such as Lua or
+// JavaScript, is made available with RegisterScriptEngine; none is
+// bundled, so the controller doesn't depend on one. Scripts
+// should only be given api, not the controller itself.
+type ScriptEngine interface {
+	Compile(name string, src []byte, api *ScriptAPI) (PacketInHandler, error)
+}
+
+// Script engines by file extension, including the dot.
+var scriptEngines = struct {
+	sync.RWMutex
+	m map[string]ScriptEngine
+}{m: map[string]ScriptEngine{".rules": RulesScriptEngine{}}}
+
+// Uses e for script files with extension ext, such as ".lua".
+func RegisterScriptEngine(ext string, e ScriptEngine) {
+	scriptEngines.Lock()
+	scriptEngines.m[ext] = e
+	scriptEngines.Unlock()
+}
+
+// The part of the controller exposed to a script.
+type ScriptAPI struct {
+	Name   string
+	cookie uint64
+}
+
+// Returns the API for the script called name.
+func newScriptAPI(name string) *ScriptAPI {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &ScriptAPI{Name: name, cookie: ScriptCookie | h.Sum64()&^scriptCookieMask}
+}
+
+// Logs v prefixed with the name of the script.
+func (a *ScriptAPI) Log(v ...interface{}) {
+	log.Println(append([]interface{}{"script " + a.Name + ":"}, v...)...)
+}
+
+// Returns a snapshot of the topology.
+func (a *ScriptAPI) Topology() *Topology {
+	return CurrentTopology()
+}
+
+var ErrScriptPriority = errors.New("The flow priority is above ScriptMaxFlowPriority.")
+
+// Installs a flow on Switch dpid sending packets matching m out
+// ports, or removes that flow if ports is empty.
+func (a *ScriptAPI) Forward(dpid net.HardwareAddr, m FlowMatch, priority uint16, ports []uint16) error {
+	if priority > ScriptMaxFlowPriority {
+		return ErrScriptPriority
+	}
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	if len(ports) == 0 {
+		return a.remove(sw, m, priority, true)
+	}
+	r := &FlowRule{Priority: priority, Cookie: a.cookie, Match: m}
+	for _, p := range ports {
+		r.Actions = append(r.Actions, Output(p))
+	}
+	return sw.InstallFlowRule(r)
+}
+
+// Installs a flow on Switch dpid dropping packets matching m.
+// Forward with no ports removes it.
+func (a *ScriptAPI) Drop(dpid net.HardwareAddr, m FlowMatch, priority uint16) error {
+	if priority > ScriptMaxFlowPriority {
+		return ErrScriptPriority
+	}
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	return sw.InstallFlowRule(&FlowRule{Priority: priority, Cookie: a.cookie, Match: m})
+}
+
+// Removes the flows of the script on Switch dpid at least as
+// specific as m. Flows of other scripts and applications are
+// kept.
+func (a *ScriptAPI) Remove(dpid net.HardwareAddr, m FlowMatch) error {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	return a.remove(sw, m, 0, false)
+}
+
+// Deletes the flows of the script matching m from Switch sw, or
+// only the flow with exactly m and priority if strict is set.
+func (a *ScriptAPI) remove(sw *OFSwitch, m FlowMatch, priority uint16, strict bool) error {
+	if sw.Version() != ofp10.VERSION {
+		f := ofp14.NewFlowMod()
+		f.Header.Version = sw.Version()
+		f.Command = ofp14.FC_DELETE
+		if strict {
+			f.Command = ofp14.FC_DELETE_STRICT
+			f.Priority = priority
+		} else {
+			f.TableId = 0xff // All tables
+		}
+		f.Match = m.ofp14()
+		f.Cookie = a.cookie
+		f.CookieMask = 0xffffffffffffffff
+		return sw.Send(f)
+	}
+
+	// OpenFlow 1.0 can't delete flows by cookie, so the flows
+	// are looked up and those of the script deleted one by one.
+	req := ofp10.NewFlowStatsRequest()
+	req.Match = m.ofp10()
+	req.TableId = 0xff // All tables
+	req.OutPort = ofp10.P_NONE
+	reps, err := sw.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Flow, req), SyncTimeout)
+	if err != nil {
+		return err
+	}
+	want, _ := req.Match.MarshalBinary()
+	for _, rep := range reps {
+		body, ok := rep.Body.(*ofp10.FlowStatsReply)
+		if !ok {
+			continue
+		}
+		for _, st := range body.Flows {
+			if st.Cookie != a.cookie {
+				continue
+			}
+			if strict {
+				match, _ := st.Match.MarshalBinary()
+				if st.Priority != priority || !bytes.Equal(match, want) {
+					continue
+				}
+			}
+			f := ofp10.NewFlowMod()
+			f.Command = ofp10.FC_DELETE_STRICT
+			f.Match = st.Match
+			f.Priority = st.Priority
+			if err := sw.Send(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sends the packet of pkt, received from OpenFlow 1.0 Switch dpid,
+// out ports, as if it had just arrived on its ingress port.
+func (a *ScriptAPI) Reply(dpid net.HardwareAddr, pkt *ofp10.PacketIn, ports []uint16) error {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	if sw.Version() != ofp10.VERSION {
+		return errors.New("Packet-outs from scripts need an OpenFlow 1.0 switch.")
+	}
+	out := ofp10.NewPacketOut()
+	out.InPort = pkt.InPort
+	if pkt.BufferId != 0xffffffff {
+		out.BufferId = pkt.BufferId
+	} else {
+		out.Data = &pkt.Data
+	}
+	for _, p := range ports {
+		out.AddAction(ofp10.NewActionOutput(p))
+	}
+	return sw.Send(out)
+}
+
+// Sends the frame data out port of OpenFlow 1.0 Switch dpid.
+func (a *ScriptAPI) PacketOut(dpid net.HardwareAddr, port uint16, data []byte) error {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	if sw.Version() != ofp10.VERSION {
+		return errors.New("Packet-outs from scripts need an OpenFlow 1.0 switch.")
+	}
+	out := ofp10.NewPacketOut()
+	out.Data = util.NewBuffer(data)
+	out.AddAction(ofp10.NewActionOutput(port))
+	return sw.Send(out)
+}
+
+// A ScriptLoader keeps the packet-in chain in sync with the
+// scripts in a directory. New and modified scripts are
+// (re)compiled and replace their previous handler, and the
+// handlers of deleted scripts are removed. A script that fails
+// to compile keeps its previous handler.
+type ScriptLoader struct {
+	Dir    string
+	c      *Controller
+	mu     sync.Mutex
+	loaded map[string]time.Time
+	stop   chan struct{}
+}
+
+// Loads the scripts in dir and checks it for changes every
+// interval.
+func (c *Controller) LoadScripts(dir string, interval time.Duration) *ScriptLoader {
+	RegisterFlowOwner("scripts", ScriptCookie, scriptCookieMask)
+	l := &ScriptLoader{Dir: dir, c: c, loaded: make(map[string]time.Time), stop: make(chan struct{})}
+	l.Scan()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				l.Scan()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Stops checking for changes. Loaded scripts stay in the chain.
+func (l *ScriptLoader) Stop() {
+	close(l.stop)
+}
+
+// Reloads the scripts that changed since the last scan.
+func (l *ScriptLoader) Scan() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		log.Println("Failed to read scripts:", err)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, fi := range files {
+		scriptEngines.RLock()
+		e, ok := scriptEngines.m[filepath.Ext(fi.Name())]
+		scriptEngines.RUnlock()
+		if !ok || fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		seen[name] = true
+		if t, ok := l.loaded[name]; ok && t.Equal(fi.ModTime()) {
+			continue
+		}
+		l.loaded[name] = fi.ModTime()
+		if err := l.load(e, name); err != nil {
+			log.Println("Failed to load script", name, err)
+		}
+	}
+	for name := range l.loaded {
+		if !seen[name] {
+			l.c.RemovePacketInHandler("script:" + name)
+			delete(l.loaded, name)
+			log.Println("Unloaded script", name)
+		}
+	}
+}
+
+func (l *ScriptLoader) load(e ScriptEngine, name string) error {
+	src, err := ioutil.ReadFile(filepath.Join(l.Dir, name))
+	if err != nil {
+		return err
+	}
+	h, err := e.Compile(name, src, newScriptAPI(name))
+	if err != nil {
+		return err
+	}
+	l.c.RemovePacketInHandler("script:" + name)
+	l.c.AddPacketInHandler("script:"+name, ScriptPriority, h)
+	log.Println("Loaded script", name)
+	return nil
+}
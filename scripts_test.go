@@ -0,0 +1,93 @@
+package ogo
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/eth"
+	"github.com/jonstout/ogo/protocol/ipv4"
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+func TestRulesScriptCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"valid", "rule 0 match eth_type=0x0806 action output flood\nrule 100 action drop\n", ""},
+		{"bad rule", "rule 0 match eth_type=0x0806\n", "line 1: missing action"},
+		{"priority too high", "\nrule 40000 action drop\n", "line 2: " + ErrScriptPriority.Error()},
+		{"template", "template t port rule 1 match in_port={port} action drop\n", "templates or triggers"},
+	}
+	for _, test := range tests {
+		_, err := RulesScriptEngine{}.Compile("test.rules", []byte(test.src), newScriptAPI("test.rules"))
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got error %v, expected %q.", test.name, err, test.err)
+		}
+	}
+}
+
+// Returns a packet-in of an IPv4 packet from src to dst.
+func testIPPacketIn(inPort uint16, src, dst string, proto uint8) *ofp10.PacketIn {
+	pkt := ofp10.NewPacketIn()
+	pkt.InPort = inPort
+	pkt.Data.Ethertype = eth.IPv4_MSG
+	ip := ipv4.New()
+	ip.Protocol = proto
+	ip.NWSrc = net.ParseIP(src).To4()
+	ip.NWDst = net.ParseIP(dst).To4()
+	pkt.Data.Data = ip
+	return pkt
+}
+
+func TestRulesScriptMatch(t *testing.T) {
+	src := `
+hosts web = 10.0.0.80 10.0.0.81
+rule 10 match ip_proto=6 action drop
+rule 100 match ip_dst=$web ip_proto=6 action output 2
+rule 50 on 00:00:00:00:00:00:00:02 match in_port=3 action output 1
+`
+	h, err := RulesScriptEngine{}.Compile("test.rules", []byte(src), newScriptAPI("test.rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := h.(*rulesScript)
+	sw1 := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	sw2 := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 2}
+	tests := []struct {
+		name     string
+		dpid     net.HardwareAddr
+		pkt      *ofp10.PacketIn
+		priority int // -1 if no rule matches
+	}{
+		{"web", sw1, testIPPacketIn(1, "10.0.0.5", "10.0.0.81", 6), 100},
+		{"other host", sw1, testIPPacketIn(1, "10.0.0.5", "10.0.0.9", 6), 10},
+		{"not TCP", sw1, testIPPacketIn(1, "10.0.0.5", "10.0.0.80", 17), -1},
+		{"rule of another switch", sw1, testIPPacketIn(3, "10.0.0.5", "10.0.0.9", 17), -1},
+		{"rule of this switch", sw2, testIPPacketIn(3, "10.0.0.5", "10.0.0.9", 17), 50},
+		{"higher priority first", sw2, testIPPacketIn(3, "10.0.0.5", "10.0.0.80", 6), 100},
+	}
+	for _, test := range tests {
+		f, ok := s.match(test.dpid, test.pkt)
+		if !ok && test.priority != -1 || ok && int(f.Priority) != test.priority {
+			t.Errorf("%s: matched %t priority %d, expected %d.", test.name, ok, f.Priority, test.priority)
+		}
+	}
+}
+
+func TestScriptCookie(t *testing.T) {
+	RegisterFlowOwner("scripts", ScriptCookie, scriptCookieMask)
+	a, b := newScriptAPI("a.rules"), newScriptAPI("b.rules")
+	if a.cookie == b.cookie {
+		t.Error("Two scripts have the same cookie.")
+	}
+	for _, api := range []*ScriptAPI{a, b} {
+		if owner := FlowOwner(api.cookie); owner != "scripts" {
+			t.Errorf("Flows of %s are owned by %q.", api.Name, owner)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package ogo
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The average send latency above which a switch is slow. It is
+// no longer slow once the average falls below half of it.
+var SlowSwitchLatency = time.Millisecond * 100
+
+// The weight of each new sample in the average send latency.
+const sendLatencyWeight = 0.1
+
+// Published when a switch becomes slow and when it recovers,
+// with its SendLatency as data.
+const (
+	EventSwitchSlow      = "switch.slow"
+	EventSwitchRecovered = "switch.recovered"
+)
+
+// How long messages to a switch take from being queued on its
+// main connection to the completion of the write that sends
+// them. A switch that doesn't read its connection fast enough
+// makes writes block, and the messages queued behind them wait.
+type SendLatency struct {
+	// Moving average and maximum, since the switch connected.
+	Mean    time.Duration `json:"mean_ns"`
+	Max     time.Duration `json:"max_ns"`
+	Last    time.Duration `json:"last_ns"`
+	Samples uint64        `json:"samples"`
+	// How long the write in progress has been blocked, 0 if
+	// none.
+	Writing time.Duration `json:"writing_ns"`
+	Slow    bool          `json:"slow"`
+}
+
+// Measures the send latency of a connection.
+type sendLatency struct {
+	mu      sync.Mutex
+	dpid    net.HardwareAddr
+	queued  map[util.Message]time.Time
+	mean    float64
+	max     time.Duration
+	last    time.Duration
+	samples uint64
+	writeAt time.Time
+	slow    bool
+}
+
+func newSendLatency() *sendLatency {
+	l := new(sendLatency)
+	l.queued = make(map[util.Message]time.Time)
+	return l
+}
+
+// Only messages that are pointers can be told apart while
+// queued, so only they are measured.
+func measurable(msg util.Message) bool {
+	return msg != nil && reflect.TypeOf(msg).Kind() == reflect.Ptr
+}
+
+func (l *sendLatency) setDPID(dpid net.HardwareAddr) {
+	l.mu.Lock()
+	l.dpid = dpid
+	l.mu.Unlock()
+}
+
+// Records that msg was queued.
+func (l *sendLatency) enqueued(msg util.Message) {
+	if !measurable(msg) {
+		return
+	}
+	l.mu.Lock()
+	l.queued[msg] = time.Now()
+	l.mu.Unlock()
+}
+
+// Forgets msg, which was removed from the queue unsent.
+func (l *sendLatency) forget(msg util.Message) {
+	if !measurable(msg) {
+		return
+	}
+	l.mu.Lock()
+	delete(l.queued, msg)
+	l.mu.Unlock()
+}
+
+func (l *sendLatency) writing() {
+	l.mu.Lock()
+	l.writeAt = time.Now()
+	l.mu.Unlock()
+}
+
+// Records that msgs were written, and publishes an event if the
+// switch became slow or recovered.
+func (l *sendLatency) written(msgs []util.Message) {
+	now := time.Now()
+	l.mu.Lock()
+	l.writeAt = time.Time{}
+	for _, msg := range msgs {
+		if !measurable(msg) {
+			continue
+		}
+		at, ok := l.queued[msg]
+		if !ok {
+			continue
+		}
+		delete(l.queued, msg)
+		d := now.Sub(at)
+		if l.samples == 0 {
+			l.mean = float64(d)
+		} else {
+			l.mean += sendLatencyWeight * (float64(d) - l.mean)
+		}
+		l.samples += 1
+		l.last = d
+		if d > l.max {
+			l.max = d
+		}
+	}
+	changed := false
+	if !l.slow && l.mean > float64(SlowSwitchLatency) {
+		l.slow, changed = true, true
+	} else if l.slow && l.mean < float64(SlowSwitchLatency/2) {
+		l.slow, changed = false, true
+	}
+	dpid := l.dpid
+	l.mu.Unlock()
+
+	if changed && dpid != nil {
+		stats := l.stats()
+		if stats.Slow {
+			Publish(EventSwitchSlow, dpid, stats)
+		} else {
+			Publish(EventSwitchRecovered, dpid, stats)
+		}
+	}
+}
+
+func (l *sendLatency) stats() SendLatency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := SendLatency{Mean: time.Duration(l.mean), Max: l.max, Last: l.last,
+		Samples: l.samples, Slow: l.slow}
+	if !l.writeAt.IsZero() {
+		s.Writing = time.Since(l.writeAt)
+	}
+	return s
+}
+
+// Returns the send latency of the main connection of Switch s.
+func (s *OFSwitch) SendLatency() SendLatency {
+	stream, _ := s.session()
+	return stream.sends.stats()
+}
+
+// Returns true if Switch s is slow: its average send latency is
+// above SlowSwitchLatency, or a write to it has been blocked for
+// longer than that.
+func (s *OFSwitch) Slow() bool {
+	l := s.SendLatency()
+	return l.Slow || l.Writing > SlowSwitchLatency
+}
+
+// Returns the connected switches that are slow, in order of
+// DPID, so applications can keep control heavy work away from
+// them.
+func SlowSwitches() []*OFSwitch {
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+	slow := make([]*OFSwitch, 0)
+	for _, sw := range sws {
+		if sw.connected() && sw.Slow() {
+			slow = append(slow, sw)
+		}
+	}
+	return slow
+}
@@ -0,0 +1,229 @@
+package ogo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Picks which of n shards the switch dpid belongs to, a number
+// from 0 to n-1. It must always give a DPID the same shard.
+type ShardFunc func(dpid net.HardwareAddr, n int) int
+
+// The number of shards the switches are split into, and how they
+// are picked. Set them before NewController.
+var (
+	ShardCount           = 16
+	ShardHash  ShardFunc = HashDPID
+)
+
+// The number of goroutines of each shard handing messages to the
+// packet-in chain and the applications, and how many messages
+// each of them queues. Every switch is pinned to one worker of
+// its shard, so its messages are handled in the order they
+// arrived, unless its worker's queue overflows. With no workers,
+// the default, every message is handled by a goroutine of its
+// own. Set them before NewController.
+var (
+	ShardWorkers    = 0
+	ShardQueueDepth = 1024
+)
+
+// Shards by an FNV-1a hash of the DPID.
+func HashDPID(dpid net.HardwareAddr, n int) int {
+	h := fnv.New32a()
+	h.Write(dpid)
+	return int(h.Sum32() % uint32(n))
+}
+
+// A map from DPIDs to all Switches that have connected since
+// Ogo started. It is split into shards, each with its own lock
+// and dispatcher, so thousands of switches connecting and leaving
+// don't contend on a single lock. Looking up switches takes no
+// lock at all.
+type Network struct {
+	shards []*networkShard
+	hash   ShardFunc
+}
+
+// Lookups read the switches of a shard from snapshot without
+// locking; writers hold the lock, copy the map and store the copy
+// in snapshot. Switches come and go rarely, lookups happen for
+// every message.
+type networkShard struct {
+	sync.Mutex
+	// The map in snapshot, only read or replaced with the lock
+	// held.
+	switches map[string]*OFSwitch
+	snapshot atomic.Value
+	// The queue of each worker
+	work []chan func()
+	// Updated atomically.
+	lookups    uint64
+	dispatched uint64
+	overflowed uint64
+}
+
+// The state of a shard.
+type ShardStats struct {
+	Shard     int `json:"shard"`
+	Switches  int `json:"switches"`
+	Connected int `json:"connected"`
+	// Switch lookups by DPID.
+	Lookups uint64 `json:"lookups"`
+	// Messages handed to the workers of the shard, and those
+	// handled by a goroutine of their own because its queue was
+	// full.
+	Dispatched uint64 `json:"dispatched"`
+	Overflowed uint64 `json:"overflowed"`
+	Queued     int    `json:"queued"`
+}
+
+func NewNetwork() *Network {
+	return NewShardedNetwork(ShardCount, ShardHash, ShardWorkers)
+}
+
+// Returns a network of n shards picked by hash, each with workers
+// dispatching goroutines.
+func NewShardedNetwork(n int, hash ShardFunc, workers int) *Network {
+	if n < 1 {
+		n = 1
+	}
+	nw := &Network{make([]*networkShard, n), hash}
+	for i := range nw.shards {
+		s := &networkShard{switches: make(map[string]*OFSwitch)}
+		s.snapshot.Store(s.switches)
+		for j := 0; j < workers; j++ {
+			work := make(chan func(), ShardQueueDepth)
+			s.work = append(s.work, work)
+			go run(work)
+		}
+		nw.shards[i] = s
+	}
+	return nw
+}
+
+func (n *Network) shard(dpid net.HardwareAddr) *networkShard {
+	return n.shards[n.hash(dpid, len(n.shards))]
+}
+
+// Returns the switches of s. The map must not be modified.
+func (s *networkShard) load() map[string]*OFSwitch {
+	return s.snapshot.Load().(map[string]*OFSwitch)
+}
+
+// Sets the switch of key to sw, or removes it if sw is nil. The
+// lock of s must be held.
+func (s *networkShard) set(key string, sw *OFSwitch) {
+	m := make(map[string]*OFSwitch, len(s.switches)+1)
+	for k, v := range s.switches {
+		m[k] = v
+	}
+	if sw == nil {
+		delete(m, key)
+	} else {
+		m[key] = sw
+	}
+	s.switches = m
+	s.snapshot.Store(m)
+}
+
+func (n *Network) get(dpid net.HardwareAddr) (*OFSwitch, bool) {
+	s := n.shard(dpid)
+	atomic.AddUint64(&s.lookups, 1)
+	sw, ok := s.load()[dpid.String()]
+	return sw, ok
+}
+
+func (n *Network) all() []*OFSwitch {
+	a := make([]*OFSwitch, 0)
+	for _, s := range n.shards {
+		for _, sw := range s.load() {
+			a = append(a, sw)
+		}
+	}
+	return a
+}
+
+// Runs f on the worker of the shard of dpid that dpid is pinned
+// to, or on a goroutine of its own if the shard has no workers or
+// the worker's queue is full. A receive loop must never block on
+// a full queue: the handlers holding up the workers may be
+// waiting for replies only it can deliver.
+func (n *Network) dispatch(dpid net.HardwareAddr, f func()) {
+	s := n.shard(dpid)
+	if len(s.work) > 0 {
+		select {
+		case s.work[workerOf(dpid, len(s.work))] <- f:
+			atomic.AddUint64(&s.dispatched, 1)
+			return
+		default:
+			atomic.AddUint64(&s.overflowed, 1)
+		}
+	}
+	go f()
+}
+
+// Picks which of n workers the switch dpid is pinned to. It uses
+// FNV-1, not the FNV-1a of HashDPID, so the switches of a shard
+// are spread over its workers.
+func workerOf(dpid net.HardwareAddr, n int) int {
+	h := fnv.New32()
+	h.Write(dpid)
+	return int(h.Sum32() % uint32(n))
+}
+
+func run(work chan func()) {
+	for f := range work {
+		f()
+	}
+}
+
+// Returns the state of every shard of the network, in order.
+func Shards() []ShardStats {
+	a := make([]ShardStats, len(network.shards))
+	for i, s := range network.shards {
+		st := ShardStats{Shard: i, Lookups: atomic.LoadUint64(&s.lookups),
+			Dispatched: atomic.LoadUint64(&s.dispatched),
+			Overflowed: atomic.LoadUint64(&s.overflowed)}
+		for _, work := range s.work {
+			st.Queued += len(work)
+		}
+		switches := s.load()
+		st.Switches = len(switches)
+		for _, sw := range switches {
+			if sw.connected() {
+				st.Connected += 1
+			}
+		}
+		a[i] = st
+	}
+	return a
+}
+
+func writeShardMetrics(w io.Writer) {
+	shards := Shards()
+	fmt.Fprintln(w, "# TYPE ogo_shard_switches gauge")
+	for _, s := range shards {
+		fmt.Fprintf(w, "ogo_shard_switches{shard=\"%d\"} %d\n", s.Shard, s.Switches)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_shard_lookups_total counter")
+	for _, s := range shards {
+		fmt.Fprintf(w, "ogo_shard_lookups_total{shard=\"%d\"} %d\n", s.Shard, s.Lookups)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_shard_dispatched_total counter")
+	for _, s := range shards {
+		fmt.Fprintf(w, "ogo_shard_dispatched_total{shard=\"%d\"} %d\n", s.Shard, s.Dispatched)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_shard_overflowed_total counter")
+	for _, s := range shards {
+		fmt.Fprintf(w, "ogo_shard_overflowed_total{shard=\"%d\"} %d\n", s.Shard, s.Overflowed)
+	}
+	fmt.Fprintln(w, "# TYPE ogo_shard_queued gauge")
+	for _, s := range shards {
+		fmt.Fprintf(w, "ogo_shard_queued{shard=\"%d\"} %d\n", s.Shard, s.Queued)
+	}
+}
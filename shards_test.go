@@ -0,0 +1,171 @@
+package ogo
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// The number of switches and ports of each benchmark network.
+const benchSwitches, benchPorts = 1000, 48
+
+// Fills the network with switches and returns their DPIDs, and a
+// map of the same switches behind a single RWMutex, the way the
+// network was kept before it was sharded.
+func benchNetwork() ([]net.HardwareAddr, *lockedNetwork) {
+	network = NewNetwork()
+	locked := &lockedNetwork{switches: make(map[string]*OFSwitch)}
+	dpids := make([]net.HardwareAddr, benchSwitches)
+	for i := range dpids {
+		dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, byte(i >> 8), byte(i)}
+		sw := &OFSwitch{dpid: dpid, ports: make(map[uint16]ofp10.PhyPort)}
+		for p := uint16(1); p <= benchPorts; p++ {
+			sw.ports[p] = ofp10.PhyPort{PortNo: p}
+		}
+		sw.portSnap.Store(sw.ports)
+		shard := network.shard(dpid)
+		shard.Lock()
+		shard.set(dpid.String(), sw)
+		shard.Unlock()
+		locked.switches[dpid.String()] = sw
+		dpids[i] = dpid
+	}
+	return dpids, locked
+}
+
+type lockedNetwork struct {
+	sync.RWMutex
+	switches map[string]*OFSwitch
+}
+
+func (n *lockedNetwork) get(dpid net.HardwareAddr) (*OFSwitch, bool) {
+	n.RLock()
+	defer n.RUnlock()
+	sw, ok := n.switches[dpid.String()]
+	return sw, ok
+}
+
+func TestNetworkShards(t *testing.T) {
+	dpids, _ := benchNetwork()
+	if n := len(Switches()); n != benchSwitches {
+		t.Fatalf("Got %d switches.", n)
+	}
+	for _, dpid := range dpids[:10] {
+		sw, ok := Switch(dpid)
+		if !ok || sw.DPID().String() != dpid.String() {
+			t.Fatalf("Switch %s not found.", dpid)
+		}
+		if p, ok := sw.Port(benchPorts); !ok || p.PortNo != benchPorts {
+			t.Errorf("Port %d of %s not found.", benchPorts, dpid)
+		}
+	}
+	shard := network.shard(dpids[0])
+	shard.Lock()
+	shard.set(dpids[0].String(), nil)
+	shard.Unlock()
+	if _, ok := Switch(dpids[0]); ok {
+		t.Error("Removed switch still found.")
+	}
+	total, used := 0, 0
+	for _, s := range network.shards {
+		total += len(s.load())
+		if len(s.load()) > 0 {
+			used += 1
+		}
+	}
+	if total != benchSwitches-1 || used != len(network.shards) {
+		t.Errorf("%d shards have %d switches.", used, total)
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	const switches, messages = 8, 100
+	nw := NewShardedNetwork(1, HashDPID, 4)
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	var wg sync.WaitGroup
+	wg.Add(switches * messages)
+	for i := 0; i < messages; i++ {
+		for j := 0; j < switches; j++ {
+			dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, byte(j)}
+			i := i
+			nw.dispatch(dpid, func() {
+				mu.Lock()
+				seen[dpid.String()] = append(seen[dpid.String()], i)
+				mu.Unlock()
+				wg.Done()
+			})
+		}
+	}
+	wg.Wait()
+	for dpid, a := range seen {
+		for i, n := range a {
+			if n != i {
+				t.Fatalf("Message %d of %s was handled as number %d.", n, dpid, i)
+			}
+		}
+	}
+
+	workers := make(map[int]bool)
+	for i := 0; i < 64; i++ {
+		workers[workerOf(net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, byte(i)}, 4)] = true
+	}
+	if len(workers) != 4 {
+		t.Errorf("64 switches were pinned to %d of 4 workers.", len(workers))
+	}
+}
+
+func BenchmarkSwitchLookup(b *testing.B) {
+	dpids, _ := benchNetwork()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			Switch(dpids[i%len(dpids)])
+			i++
+		}
+	})
+}
+
+func BenchmarkSwitchLookupLocked(b *testing.B) {
+	dpids, locked := benchNetwork()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			locked.get(dpids[i%len(dpids)])
+			i++
+		}
+	})
+}
+
+func BenchmarkPortLookup(b *testing.B) {
+	dpids, _ := benchNetwork()
+	sw, _ := Switch(dpids[0])
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			sw.Port(uint16(i%benchPorts + 1))
+			i++
+		}
+	})
+}
+
+func BenchmarkPortLookupLocked(b *testing.B) {
+	dpids, _ := benchNetwork()
+	sw, _ := Switch(dpids[0])
+	var mu sync.RWMutex
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			mu.RLock()
+			_ = sw.ports[uint16(i%benchPorts+1)]
+			mu.RUnlock()
+			i++
+		}
+	})
+}
@@ -2,11 +2,12 @@ package ogo
 
 import (
 	"encoding/binary"
-	"github.com/jonstout/ogo/protocol/ofp"
 	"github.com/jonstout/ogo/protocol/util"
 	"log"
 	"net"
 	"bytes"
+	"sync"
+	"sync/atomic"
 )
 
 type BufferPool struct {
@@ -25,8 +26,22 @@ func NewBufferPool() *BufferPool {
 	return m
 }
 
+// Buffers grown past this by a long message are replaced, rather
+// than returned to the pool, so a few jumbo packet-ins don't pin
+// their memory.
+const maxPooledBuffer = 16 * 1024
+
+// Returns b to the pool of empty buffers.
+func (m *BufferPool) recycle(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		b = bytes.NewBuffer(make([]byte, 0, 2048))
+	}
+	b.Reset()
+	m.Empty <- b
+}
+
 type MessageStream struct {
-	conn *net.TCPConn
+	conn net.Conn
 	pool *BufferPool
 	// OpenFlow Version
 	Version uint8
@@ -38,27 +53,98 @@ type MessageStream struct {
 	Outbound chan util.Message
 	// Channel on which to receive a shutdown command
 	Shutdown chan bool
+	// Closed once the stream has shut down
+	done     chan struct{}
+	doneOnce sync.Once
+	// Messages sent and received by type
+	counts *messageCounts
+	// Writes to conn
+	writes *WriteStats
+	// How long queued messages take to be written
+	sends *sendLatency
+	// Errors reading conn, and what to do about them
+	errors chan *StreamError
+	policy atomic.Value
+	// The longest message accepted
+	maxLength int64
+	// Receive stamps of buffers and messages on their way to
+	// Inbound, and the round trips of echo requests
+	stampsMu sync.Mutex
+	stamps   map[interface{}]Timestamp
+	clock    *echoClock
+	// The codec set by SetCodec, as a codecBox
+	codec atomic.Value
+}
+
+// The most messages, and bytes, combined into a single write to
+// a switch.
+var (
+	MaxWriteMessages = 64
+	MaxWriteBytes    = 64 * 1024
+)
+
+// Statistics of the writes to a switch connection. Queued
+// messages are combined into a single write, so Messages is at
+// least Writes.
+type WriteStats struct {
+	Writes   uint64
+	Messages uint64
+	Bytes    uint64
+}
+
+func (s *WriteStats) add(messages, bytes int) {
+	atomic.AddUint64(&s.Writes, 1)
+	atomic.AddUint64(&s.Messages, uint64(messages))
+	atomic.AddUint64(&s.Bytes, uint64(bytes))
+}
+
+// Returns the average number of messages per write.
+func (s WriteStats) MessagesPerWrite() float64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return float64(s.Messages) / float64(s.Writes)
+}
+
+// Returns the average number of bytes per write.
+func (s WriteStats) BytesPerWrite() float64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Writes)
 }
 
 // Returns a pointer to a new MessageStream. Used to parse
 // OpenFlow messages from conn.
-func NewMessageStream(conn *net.TCPConn) *MessageStream {
+func NewMessageStream(conn net.Conn) *MessageStream {
 	m := &MessageStream{
 		conn,
 		NewBufferPool(),
 		0,
 		make(chan error, 1),        // Error
 		make(chan util.Message, 1), // Inbound
-		make(chan util.Message, 1), // Outbound
+		make(chan util.Message, 64), // Outbound
 		make(chan bool, 1),         // Shutdown
+		make(chan struct{}),
+		sync.Once{},
+		newMessageCounts(),
+		new(WriteStats),
+		newSendLatency(),
+		make(chan *StreamError, 16),
+		atomic.Value{},
+		int64(MaxMessageLength),
+		sync.Mutex{},
+		make(map[interface{}]Timestamp),
+		new(echoClock),
+		atomic.Value{},
 	}
 
 	go m.outbound()
 	go m.inbound()
 
-	for i := 0; i < 25; i++ {
-		go m.parse()
-	}
+	// A single parser keeps messages in the order they were
+	// read, which multipart replies and barriers rely on.
+	go m.parse()
 	return m
 }
 
@@ -66,8 +152,45 @@ func (m *MessageStream) GetAddr() net.Addr {
 	return m.conn.RemoteAddr()
 }
 
+// Returns a channel that is closed once the stream has shut
+// down. Nothing sent on Outbound after that is delivered.
+func (m *MessageStream) Done() <-chan struct{} {
+	return m.done
+}
+
+// Shuts down the stream. Never blocks and is safe to call more
+// than once.
+func (m *MessageStream) Close() {
+	select {
+	case m.Shutdown <- true:
+	default:
+	}
+}
+
+// Returns the length of the longest message m accepts.
+func (m *MessageStream) MaxLength() int {
+	return int(atomic.LoadInt64(&m.maxLength))
+}
+
+// Sets the length of the longest message m accepts. Longer
+// messages are StreamOversized errors.
+func (m *MessageStream) SetMaxLength(n int) {
+	atomic.StoreInt64(&m.maxLength, int64(n))
+}
+
+// Publishes err, unless an error has already been published,
+// and shuts down the stream.
+func (m *MessageStream) fail(err error) {
+	select {
+	case m.Error <- err:
+	default:
+	}
+	m.Close()
+}
+
 // Listen for a Shutdown signal or Outbound messages.
 func (m *MessageStream) outbound() {
+	defer m.doneOnce.Do(func() { close(m.done) })
 	for {
 		select {
 		case <-m.Shutdown:
@@ -75,48 +198,111 @@ func (m *MessageStream) outbound() {
 			m.conn.Close()
 			return
 		case msg := <-m.Outbound:
-			// Forward outbound messages to conn
-			data, _ := msg.MarshalBinary()
-			if _, err := m.conn.Write(data); err != nil {
+			// Forward outbound messages to conn, along with
+			// any others already queued, in a single write.
+			batch := m.appendMessage(make([]byte, 0, 2048), msg)
+			msgs := []util.Message{msg}
+		coalesce:
+			for len(msgs) < MaxWriteMessages && len(batch) < MaxWriteBytes {
+				select {
+				case msg = <-m.Outbound:
+					batch = m.appendMessage(batch, msg)
+					msgs = append(msgs, msg)
+				default:
+					break coalesce
+				}
+			}
+			m.sends.writing()
+			if _, err := m.conn.Write(batch); err != nil {
 				log.Println("OutboundError:", err)
-				m.Error <- err
-				m.Shutdown <- true
+				m.fail(err)
+			} else {
+				m.writes.add(len(msgs), len(batch))
+				m.sends.written(msgs)
 			}
 		}
 	}
 }
 
+// Appends the encoding of msg to batch and counts it.
+func (m *MessageStream) appendMessage(batch []byte, msg util.Message) []byte {
+	data, _ := msg.MarshalBinary()
+	if len(data) > 1 {
+		m.counts.countSent(data[1])
+	}
+	if len(data) >= 8 && data[1] == 2 { // Echo request
+		m.clock.requestSent(binary.BigEndian.Uint32(data[4:]), stampNow())
+	}
+	return append(batch, data...)
+}
+
 func (m *MessageStream) inbound() {
 	msg := 0
 	hdr := 0
+	// Set while discarding an oversized message.
+	skip := false
 	hdrBuf := make([]byte, 4)
 
 	tmp := make([]byte, 2048)
 	buf := <- m.pool.Empty
+	// When the current message's first byte was read.
+	var stamp Timestamp
 	for {
 		n, err := m.conn.Read(tmp)
+		readAt := stampNow()
 		if err != nil {
 			log.Println("InboundError", err)
-			m.Error <- err
-			m.Shutdown <- true
+			if hdr > 0 {
+				m.handle(newStreamError(StreamShortRead, hdrBuf[:hdr], err))
+			} else {
+				m.fail(err)
+			}
 			return
 		}		
 		
 		for i := 0; i < n; i++ {
 			if hdr < 4 {
+				if hdr == 0 {
+					stamp = readAt
+				}
 				hdrBuf[hdr] = tmp[i]
 				buf.WriteByte(tmp[i])
 				hdr += 1
 				if hdr >= 4 {
-					msg = int(binary.BigEndian.Uint16(hdrBuf[2:])) - 4
+					length := int(binary.BigEndian.Uint16(hdrBuf[2:]))
+					msg = length - 4
+					if length < 8 {
+						// The start of the next message is lost.
+						m.handle(newStreamError(StreamBadLength, hdrBuf, nil))
+						return
+					}
+					if length > m.MaxLength() {
+						e := newStreamError(StreamOversized, hdrBuf, ErrMessageTooLong)
+						if m.handle(e) == StreamDrop {
+							return
+						}
+						skip = true
+						buf.Reset()
+					} else {
+						buf.Grow(msg)
+					}
 				}
 				continue
 			}
 			if msg > 0 {
-				buf.WriteByte(tmp[i])
 				msg = msg - 1
+				if skip {
+					if msg == 0 {
+						hdr = 0
+						skip = false
+					}
+					continue
+				}
+				buf.WriteByte(tmp[i])
 				if msg == 0 {
 					hdr = 0
+					m.counts.countReceived(hdrBuf[1])
+					m.setStamp(buf, stamp)
 					m.pool.Full <- buf
 					buf = <- m.pool.Empty
 				}
@@ -128,15 +314,46 @@ func (m *MessageStream) inbound() {
 
 func (m *MessageStream) parse() {
 	for {
-		b := <- m.pool.Full
-		msg, err := ofp.Parse(b.Bytes())
-		// Log all message parsing errors.
+		var b *bytes.Buffer
+		select {
+		case b = <- m.pool.Full:
+		case <-m.done:
+			return
+		}
+		msg, err := parseMessage(m.Codec(), b.Bytes())
+		stamp, _ := m.takeStamp(b)
 		if err != nil {
-			log.Print(err)
+			// Messages that don't parse are never delivered.
+			m.handle(err)
+		} else {
+			if data := b.Bytes(); data[1] == 3 { // Echo reply
+				m.clock.replyReceived(binary.BigEndian.Uint32(data[4:]), stamp)
+			}
+			m.setStamp(msg, stamp)
+			select {
+			case m.Inbound <- msg:
+			case <-m.done:
+				return
+			}
 		}
-		
-		m.Inbound <- msg
-		b.Reset()
-		m.pool.Empty <- b
+		m.pool.recycle(b)
 	}
 }
+
+// Records when the message in buffer or msg k was received.
+func (m *MessageStream) setStamp(k interface{}, t Timestamp) {
+	m.stampsMu.Lock()
+	m.stamps[k] = t
+	m.stampsMu.Unlock()
+}
+
+// Returns and forgets when the message in buffer or msg k was
+// received. Receivers of Inbound messages call it to learn when
+// they were read.
+func (m *MessageStream) takeStamp(k interface{}) (Timestamp, bool) {
+	m.stampsMu.Lock()
+	defer m.stampsMu.Unlock()
+	t, ok := m.stamps[k]
+	delete(m.stamps, k)
+	return t, ok
+}
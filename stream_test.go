@@ -0,0 +1,225 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Returns an OpenFlow 1.0 packet-in of length bytes, carrying an
+// Ethernet frame of an experimental type.
+func rawPacketIn(length int) []byte {
+	b := make([]byte, length)
+	b[0], b[1] = 1, ofp10.Type_PacketIn
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+	binary.BigEndian.PutUint32(b[8:], 0xffffffff)
+	binary.BigEndian.PutUint16(b[12:], uint16(length-18))
+	binary.BigEndian.PutUint16(b[14:], 1)
+	binary.BigEndian.PutUint16(b[30:], 0x88b5)
+	for i := 32; i < length; i++ {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// Returns the last byte of the frame in p, which tells whether
+// it was truncated.
+func lastByte(p *ofp10.PacketIn) byte {
+	b := p.Data.Data.(*util.Buffer).Bytes()
+	if len(b) == 0 {
+		return 0
+	}
+	return b[len(b)-1]
+}
+
+func receiveMessage(t *testing.T, m *MessageStream) util.Message {
+	select {
+	case msg := <-m.Inbound:
+		return msg
+	case err := <-m.Error:
+		t.Fatal(err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("No message received.")
+	}
+	return nil
+}
+
+func TestStreamMaximumSizeMessages(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	m := NewMessageStream(c)
+	defer m.Close()
+
+	for _, length := range []int{2048, 2049, 9018 + 18, 0xffff} {
+		go s.Write(rawPacketIn(length))
+		p, ok := receiveMessage(t, m).(*ofp10.PacketIn)
+		if !ok {
+			t.Fatalf("Length %d: didn't receive a packet-in.", length)
+		}
+		if lastByte(p) != byte(length-1) {
+			t.Errorf("Length %d: the frame was truncated.", length)
+		}
+		if p.TotalLen != uint16(length-18) {
+			t.Errorf("Length %d: got a total length of %d.", length, p.TotalLen)
+		}
+	}
+}
+
+func TestStreamMaxLength(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	m := NewMessageStream(c)
+	defer m.Close()
+	m.SetMaxLength(100)
+
+	go func() {
+		s.Write(rawPacketIn(101))
+		s.Write(rawPacketIn(100))
+	}()
+	select {
+	case e := <-m.Errors():
+		if e.Kind != StreamOversized || e.Length != 101 || e.Err != ErrMessageTooLong {
+			t.Errorf("Got error %v, expected an oversized message of 101 bytes.", e)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("No error for an oversized message.")
+	}
+	// The oversized message is skipped, and the next one read.
+	p, ok := receiveMessage(t, m).(*ofp10.PacketIn)
+	if !ok || lastByte(p) != byte(100-1) {
+		t.Errorf("Didn't receive the message after the oversized one.")
+	}
+}
+
+func TestStreamOversizedDrop(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	m := NewMessageStream(c)
+	m.SetMaxLength(100)
+	m.SetErrorPolicy(DropOnError)
+
+	go s.Write(rawPacketIn(101))
+	select {
+	case err := <-m.Error:
+		if e, ok := err.(*StreamError); !ok || e.Kind != StreamOversized {
+			t.Errorf("Got error %v, expected an oversized message.", err)
+		}
+	case <-m.Inbound:
+		t.Error("Received an oversized message.")
+	case <-time.After(time.Second * 5):
+		t.Fatal("The connection wasn't dropped.")
+	}
+}
+
+func TestStreamBadLength(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	m := NewMessageStream(c)
+
+	go s.Write([]byte{1, ofp10.Type_EchoRequest, 0, 7, 0, 0, 0, 1})
+	select {
+	case err := <-m.Error:
+		if e, ok := err.(*StreamError); !ok || e.Kind != StreamBadLength {
+			t.Errorf("Got error %v, expected a bad length.", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The connection wasn't dropped.")
+	}
+}
+
+func TestStreamOrder(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	m := NewMessageStream(c)
+	defer m.Close()
+
+	// Enough messages to fill the buffer pool several times.
+	var b []byte
+	for i := 0; i < 200; i++ {
+		p := rawPacketIn(64)
+		binary.BigEndian.PutUint32(p[4:], uint32(i))
+		b = append(b, p...)
+	}
+	go s.Write(b)
+	for i := 0; i < 200; i++ {
+		p, ok := receiveMessage(t, m).(*ofp10.PacketIn)
+		if !ok {
+			t.Fatalf("Message %d: didn't receive a packet-in.", i)
+		}
+		if p.Xid != uint32(i) {
+			t.Fatalf("Message %d: got message %d instead.", i, p.Xid)
+		}
+	}
+}
+
+func TestStreamCoalesce(t *testing.T) {
+	defer func(messages, bytes int) {
+		MaxWriteMessages, MaxWriteBytes = messages, bytes
+	}(MaxWriteMessages, MaxWriteBytes)
+
+	tests := []struct {
+		name     string
+		messages int
+		bytes    int
+		// The messages in each write but the first and last.
+		batch int
+	}{
+		{"message limit", 4, 64 * 1024, 4},
+		// A write ends with the message that reaches the limit.
+		{"byte limit", 64, 20, 3},
+	}
+	for _, test := range tests {
+		MaxWriteMessages, MaxWriteBytes = test.messages, test.bytes
+		c, s := net.Pipe()
+		m := NewMessageStream(c)
+
+		// The first write blocks until s is read, so the
+		// others are queued by then.
+		for i := 0; i < 20; i++ {
+			h := ofpxx.NewOfp10Header()
+			h.Type = ofp10.Type_BarrierRequest
+			h.Xid = uint32(i)
+			m.Outbound <- &h
+		}
+		var writes [][]uint32
+		for n := 0; n < 20; {
+			b := make([]byte, 64*1024)
+			s.SetReadDeadline(time.Now().Add(time.Second))
+			l, err := s.Read(b)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			// A pipe read returns the rest of a single write.
+			var xids []uint32
+			for b = b[:l]; len(b) >= 8; b = b[8:] {
+				xids = append(xids, binary.BigEndian.Uint32(b[4:]))
+			}
+			writes = append(writes, xids)
+			n += len(xids)
+		}
+		m.Close()
+		<-m.Done()
+		s.Close()
+
+		next := uint32(0)
+		for i, xids := range writes {
+			if i > 0 && i < len(writes)-1 && len(xids) != test.batch {
+				t.Errorf("%s: write %d has %d messages, expected %d.", test.name, i, len(xids), test.batch)
+			}
+			for _, xid := range xids {
+				if xid != next {
+					t.Errorf("%s: write %d has message %d, expected %d.", test.name, i, xid, next)
+				}
+				next = xid + 1
+			}
+		}
+		if st := *m.writes; st.Writes != uint64(len(writes)) || st.Messages != 20 || st.Bytes != 160 {
+			t.Errorf("%s: counted %+v, expected %d writes of 20 messages.", test.name, st, len(writes))
+		}
+	}
+}
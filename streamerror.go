@@ -0,0 +1,204 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/jonstout/ogo/protocol/ofp"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The kinds of errors found reading a MessageStream.
+type StreamErrorKind int
+
+const (
+	// The connection failed in the middle of a message.
+	StreamShortRead StreamErrorKind = iota
+	// The length of a message is shorter than its header.
+	StreamBadLength
+	// The length of a message is over the MaxLength of the
+	// stream.
+	StreamOversized
+	// A message of an OpenFlow version that isn't supported.
+	StreamBadVersion
+	// A message of a type its version's parser doesn't know.
+	StreamUnknownType
+	// A message its parser rejected, or that is too short for
+	// its type.
+	StreamMalformed
+)
+
+var streamErrorKinds = []string{"short_read", "bad_length", "oversized",
+	"bad_version", "unknown_type", "malformed"}
+
+func (k StreamErrorKind) String() string {
+	if k < 0 || int(k) >= len(streamErrorKinds) {
+		return fmt.Sprintf("StreamErrorKind(%d)", int(k))
+	}
+	return streamErrorKinds[k]
+}
+
+// An error reading a message from a MessageStream. Version, Type
+// and Length are those of the message's header, as far as it was
+// read.
+type StreamError struct {
+	Kind    StreamErrorKind
+	Version uint8
+	Type    uint8
+	Length  int
+	Err     error
+}
+
+func newStreamError(kind StreamErrorKind, hdr []byte, err error) *StreamError {
+	e := &StreamError{Kind: kind, Err: err}
+	if len(hdr) > 0 {
+		e.Version = hdr[0]
+	}
+	if len(hdr) > 1 {
+		e.Type = hdr[1]
+	}
+	if len(hdr) > 3 {
+		e.Length = int(binary.BigEndian.Uint16(hdr[2:]))
+	}
+	return e
+}
+
+func (e *StreamError) Error() string {
+	s := fmt.Sprintf("OpenFlow stream error %s (version %d, type %d, length %d)",
+		e.Kind, e.Version, e.Type, e.Length)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// What a MessageStream does about a StreamError.
+type StreamAction int
+
+const (
+	// Discards the message and reads on.
+	StreamSkip StreamAction = iota
+	// Closes the connection.
+	StreamDrop
+)
+
+// Decides what a MessageStream does about an error. Short reads
+// and bad lengths always drop the connection, as it is gone or
+// the start of the next message is lost, and aren't passed to
+// the policy.
+type StreamErrorPolicy func(e *StreamError) StreamAction
+
+// The policy of new streams.
+var DefaultStreamErrorPolicy StreamErrorPolicy = SkipBadMessages
+
+// Skips every message it can, logging it, so one message a
+// switch gets wrong doesn't cost its connection.
+func SkipBadMessages(e *StreamError) StreamAction {
+	return StreamSkip
+}
+
+// Drops the connection on any error, for switches that are
+// expected to speak the protocol exactly.
+func DropOnError(e *StreamError) StreamAction {
+	return StreamDrop
+}
+
+// The longest message new streams accept. By default it is the
+// most a header can say, 64KB, so maximum-size messages and jumbo
+// packet-ins are read whole. Lower it, or set the MaxLength of a
+// stream, to bound the memory a switch can make the controller
+// buffer.
+var MaxMessageLength = 0xffff
+
+// The error of StreamOversized errors.
+var ErrMessageTooLong = errors.New("Message longer than the maximum message length.")
+
+// Returns the error policy of m.
+func (m *MessageStream) ErrorPolicy() StreamErrorPolicy {
+	if p, ok := m.policy.Load().(StreamErrorPolicy); ok && p != nil {
+		return p
+	}
+	return DefaultStreamErrorPolicy
+}
+
+// Sets the error policy of m. A nil policy is
+// DefaultStreamErrorPolicy.
+func (m *MessageStream) SetErrorPolicy(p StreamErrorPolicy) {
+	m.policy.Store(p)
+}
+
+// Returns a channel on which the errors of m are published, the
+// ones that close the connection included. Errors are dropped,
+// not queued, if it is full.
+func (m *MessageStream) Errors() <-chan *StreamError {
+	return m.errors
+}
+
+// Counts and publishes e, and returns what to do about it,
+// failing m if that is to drop the connection.
+func (m *MessageStream) handle(e *StreamError) StreamAction {
+	atomic.AddUint64(&streamErrorCounts[e.Kind], 1)
+	select {
+	case m.errors <- e:
+	default:
+	}
+	action := StreamDrop
+	if e.Kind != StreamShortRead && e.Kind != StreamBadLength {
+		action = m.ErrorPolicy()(e)
+	}
+	if action == StreamDrop {
+		log.Println("Closing OpenFlow message stream:", e)
+		m.fail(e)
+	} else {
+		log.Println("Skipped OpenFlow message:", e)
+	}
+	return action
+}
+
+// Parses the message in b with codec, if it is of the codec's
+// version, or ofp.Parse, classifying failures. b is at least a
+// header long.
+func parseMessage(codec ofp.Codec, b []byte) (msg util.Message, serr *StreamError) {
+	defer func() {
+		// Parsers index past the end of messages too short
+		// for their type.
+		if r := recover(); r != nil {
+			msg, serr = nil, newStreamError(StreamMalformed, b, fmt.Errorf("%v", r))
+		}
+	}()
+	var err error
+	if codec != nil && b[1] != 0 && b[0] == codec.Version() {
+		msg, err = codec.Parse(b)
+	} else {
+		msg, err = ofp.Parse(b)
+	}
+	if err == ofp.ErrUnknownVersion {
+		return nil, newStreamError(StreamBadVersion, b, err)
+	}
+	if _, ok := err.(*ofpxx.UnknownTypeError); ok {
+		return nil, newStreamError(StreamUnknownType, b, err)
+	}
+	if err != nil || msg == nil {
+		return nil, newStreamError(StreamMalformed, b, err)
+	}
+	return msg, nil
+}
+
+// Stream errors of every connection by kind, for the metrics
+// endpoint.
+var streamErrorCounts = make([]uint64, len(streamErrorKinds))
+
+// Writes the number of stream errors, by kind, in the Prometheus
+// text format.
+func writeStreamMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# TYPE ogo_stream_errors_total counter")
+	for k, name := range streamErrorKinds {
+		fmt.Fprintf(w, "ogo_stream_errors_total{kind=%q} %d\n", name,
+			atomic.LoadUint64(&streamErrorCounts[k]))
+	}
+}
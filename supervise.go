@@ -0,0 +1,88 @@
+package ogo
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// If true, an application instance that panics is replaced by a
+// new instance from the same generator. Instances added with
+// AddInstance directly are never replaced.
+var RestartOnPanic = false
+
+// Panics recovered from application handlers, by application.
+var panics = struct {
+	sync.Mutex
+	counts map[string]uint64
+}{counts: make(map[string]uint64)}
+
+// Returns the number of panics recovered from each application
+// and packet-in handler since the controller started.
+// Application instances are named by type.
+func (c *Controller) Panics() map[string]uint64 {
+	panics.Lock()
+	defer panics.Unlock()
+	m := make(map[string]uint64, len(panics.counts))
+	for k, v := range panics.counts {
+		m[k] = v
+	}
+	return m
+}
+
+// Logs a panic p raised by app while handling msg from Switch
+// dpid and counts it.
+func recordPanic(app string, dpid net.HardwareAddr, msg util.Message, p interface{}) {
+	log.Printf("Recovered panic in %s handling %T from %s: %v\n%s", app, msg, SwitchLabel(dpid), p, debug.Stack())
+	panics.Lock()
+	panics.counts[app] += 1
+	panics.Unlock()
+}
+
+func appName(app interface{}) string {
+	return fmt.Sprintf("%T", app)
+}
+
+// Calls f, which runs a handler of application instance i,
+// recovering from any panic so other applications and the
+// switch are unaffected.
+func (s *OFSwitch) supervise(i int, app interface{}, msg util.Message, f func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			recordPanic(appName(app), s.dpid, msg, p)
+			if RestartOnPanic {
+				s.restartInstance(i)
+			}
+		}
+	}()
+	f()
+}
+
+// Replaces application instance i with a new one.
+func (s *OFSwitch) restartInstance(i int) {
+	s.appsMu.Lock()
+	if i >= len(s.appGens) || s.appGens[i] == nil {
+		s.appsMu.Unlock()
+		return
+	}
+	inst := s.appGens[i]()
+	log.Println("Restarting", appName(inst), "on", SwitchLabel(s.dpid))
+	apps := make([]interface{}, len(s.appInstance))
+	copy(apps, s.appInstance)
+	apps[i] = inst
+	s.appInstance = apps
+	n := len(apps)
+	s.appsMu.Unlock()
+	if actor, ok := inst.(ofp10.ConnectionUpReactor); ok {
+		// Out of range so a panic here doesn't restart it
+		// again.
+		s.supervise(n, inst, nil, func() {
+			actor.ConnectionUp(s.dpid)
+		})
+	}
+}
@@ -1,112 +1,300 @@
 package ogo
 
 import (
+	"encoding/binary"
+	"errors"
 	"log"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
 	"github.com/jonstout/ogo/protocol/ofpxx"
 	"github.com/jonstout/ogo/protocol/util"
 )
 
-// A map from DPIDs to all Switches that have connected since
-// Ogo started.
-type Network struct {
-	sync.RWMutex
-	Switches map[string]*OFSwitch
-}
-
-func NewNetwork() *Network {
-	n := new(Network)
-	n.Switches = make(map[string]*OFSwitch)
-	return n
-}
-
 var network *Network
 
 type OFSwitch struct {
+	// The main connection and the channel closed when its
+	// receive loop exits. Both are replaced when the switch
+	// reconnects; read them with session.
 	stream      *MessageStream
+	receiving   chan struct{}
+	sessionMu   sync.RWMutex
+	// Replaced, never modified, with appsMu held; read them with
+	// instances.
 	appInstance []interface{}
+	// The generator of each application instance, if known
+	appGens     []ApplicationInstanceGenerator
+	appsMu      sync.RWMutex
 	dpid        net.HardwareAddr
+	// The map in portSnap, read by Port and Ports without
+	// locking. Writers hold portsMu, copy it and store the copy.
 	ports       map[uint16]ofp10.PhyPort
-	portsMu     sync.RWMutex
+	portSnap    atomic.Value
+	portsMu     sync.Mutex
 	links       map[string]*Link
 	linksMu     sync.RWMutex
 	reqs        map[uint32]chan util.Message
 	reqsMu      sync.RWMutex
+	// Spans of traced transactions, by transaction id
+	spans       map[uint32]Span
+	flows       map[string]*FlowEntry
+	monitors    map[uint32]*ofp14.FlowMonitorRequest
+	flowsMu     sync.RWMutex
+	caps        *Capabilities
+	capsMu      sync.RWMutex
+	aux       []*MessageStream
+	auxMu     sync.Mutex
+	pacer     *pacer
+	pacerMu   sync.Mutex
+	desc      *SwitchDescription
+	descMu    sync.RWMutex
+	quirk     *appliedQuirks
+	quirkMu   sync.RWMutex
+	// Why and when the main connection last went down
+	downErr   error
+	downAt    time.Time
+	// When the main connection last came up
+	upAt      time.Time
+	downMu    sync.Mutex
 }
 
+// What to do when a switch connects with the DPID of a switch
+// that is still connected.
+type DuplicateDPIDPolicy int
+
+const (
+	// Close the old connection and use the new one.
+	DuplicateReplace DuplicateDPIDPolicy = iota
+	// Refuse the new connection.
+	DuplicateReject
+	// Keep the old connection and accept the new one as an
+	// auxiliary connection. Messages received on it are
+	// handled like those of the main connection but nothing is
+	// sent on it.
+	DuplicateAuxiliary
+)
+
+var ErrDuplicateDPID = errors.New("A switch with this DPID is already connected.")
+
 // Builds and populates a Switch struct then starts listening
 // for OpenFlow messages on conn.
 func NewSwitch(stream *MessageStream, msg ofp10.SwitchFeatures) {
-	network.Lock()
-	if sw, ok := network.Switches[msg.DPID.String()]; ok {
-		log.Println("Recovered connection from:", sw.DPID())
-		sw.stream = stream
-		go sw.receive()
+	newSwitch(stream, msg.DPID, msg.Ports, DuplicateReplace)
+}
+
+// Adds the switch dpid connected over stream. Returns false if
+// stream was added as an auxiliary connection of a connected
+// switch, in which case applications aren't notified.
+func newSwitch(stream *MessageStream, dpid net.HardwareAddr, ports []ofp10.PhyPort, policy DuplicateDPIDPolicy) (bool, error) {
+	sw, ok := Switch(dpid)
+	if ok && sw.connected() {
+		switch policy {
+		case DuplicateReject:
+			return false, ErrDuplicateDPID
+		case DuplicateAuxiliary:
+			log.Println("Auxiliary connection from:", SwitchLabel(dpid))
+			sw.addAuxiliary(stream)
+			return false, nil
+		}
+		log.Println("Replacing connection from:", SwitchLabel(dpid))
+	}
+	if ok {
+		// The receive loop of the old connection must have
+		// handled its disconnect, even if it already closed,
+		// or it could mark the switch down after it is up.
+		stream, receiving := sw.session()
+		stream.Close()
+		<-receiving
+	}
+
+	topology.Lock()
+	shard := network.shard(dpid)
+	shard.Lock()
+	if ok {
+		log.Println("Recovered connection from:", SwitchLabel(sw.DPID()))
+		sw.downMu.Lock()
+		sw.downErr, sw.downAt = nil, time.Time{}
+		sw.upAt = time.Now()
+		sw.downMu.Unlock()
+		// Applications are notified again like for a new
+		// switch.
+		sw.appsMu.Lock()
+		sw.appInstance = *new([]interface{})
+		sw.appGens = nil
+		sw.appsMu.Unlock()
+		sw.startReceive(stream)
 	} else {
-		log.Println("Openflow Connection:", msg.DPID)
+		log.Println("Openflow Connection:", SwitchLabel(dpid))
 		s := new(OFSwitch)
 		s.stream = stream
 		s.appInstance = *new([]interface{})
-		s.dpid = msg.DPID
+		s.dpid = dpid
 		s.ports = make(map[uint16]ofp10.PhyPort)
 		s.links = make(map[string]*Link)
 		s.reqs = make(map[uint32]chan util.Message)
-		for _, p := range msg.Ports {
+		s.spans = make(map[uint32]Span)
+		s.flows = make(map[string]*FlowEntry)
+		s.monitors = make(map[uint32]*ofp14.FlowMonitorRequest)
+		s.aux = make([]*MessageStream, 0)
+		s.upAt = time.Now()
+		for _, p := range ports {
 			s.ports[p.PortNo] = p
 		}
-		network.Switches[msg.DPID.String()] = s
-		go s.receive()
+		s.portSnap.Store(s.ports)
+		s.restore()
+		shard.set(dpid.String(), s)
+		s.startReceive(stream)
+	}
+	shard.Unlock()
+	topology.unlock(true)
+	Publish(EventSwitchUp, dpid, nil)
+	return true, nil
+}
+
+// Returns true if the main connection of Switch s is up.
+func (s *OFSwitch) connected() bool {
+	stream, _ := s.session()
+	select {
+	case <-stream.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// Returns the main connection of Switch s and the channel closed
+// when its receive loop exits.
+func (s *OFSwitch) session() (*MessageStream, chan struct{}) {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	return s.stream, s.receiving
+}
+
+// Makes stream the main connection of Switch s and starts
+// receiving from it.
+func (s *OFSwitch) startReceive(stream *MessageStream) {
+	receiving := make(chan struct{})
+	s.sessionMu.Lock()
+	s.stream, s.receiving = stream, receiving
+	s.sessionMu.Unlock()
+	stream.sends.setDPID(s.dpid)
+	go s.receive(stream, receiving)
+}
+
+// Returns the application instances of Switch s. The slice must
+// not be modified.
+func (s *OFSwitch) instances() []interface{} {
+	s.appsMu.RLock()
+	defer s.appsMu.RUnlock()
+	return s.appInstance
+}
+
+func (s *OFSwitch) addAuxiliary(stream *MessageStream) {
+	s.auxMu.Lock()
+	s.aux = append(s.aux, stream)
+	s.auxMu.Unlock()
+	go s.receive(stream, nil)
+}
+
+func (s *OFSwitch) removeAuxiliary(stream *MessageStream) {
+	s.auxMu.Lock()
+	defer s.auxMu.Unlock()
+	for i, a := range s.aux {
+		if a == stream {
+			s.aux = append(s.aux[:i], s.aux[i+1:]...)
+			return
+		}
 	}
-	network.Unlock()
 }
 
 func (sw *OFSwitch) AddInstance(inst interface{}) {
+	sw.addInstance(inst, nil)
+}
+
+// Adds inst, created by gen, so it can be replaced by a new
+// instance if it panics.
+func (sw *OFSwitch) addInstance(inst interface{}, gen ApplicationInstanceGenerator) {
 	if actor, ok := inst.(ofp10.ConnectionUpReactor); ok {
-		actor.ConnectionUp(sw.DPID())
+		// inst isn't added yet, so it isn't restarted if
+		// this panics.
+		sw.supervise(len(sw.instances()), inst, nil, func() {
+			actor.ConnectionUp(sw.DPID())
+		})
 	}
-	sw.appInstance = append(sw.appInstance, inst)
+	sw.appsMu.Lock()
+	defer sw.appsMu.Unlock()
+	apps := make([]interface{}, len(sw.appInstance), len(sw.appInstance)+1)
+	copy(apps, sw.appInstance)
+	sw.appInstance = append(apps, inst)
+	gens := make([]ApplicationInstanceGenerator, len(sw.appGens), len(sw.appGens)+1)
+	copy(gens, sw.appGens)
+	sw.appGens = append(gens, gen)
 }
 
 func (sw *OFSwitch) SetPort(portNo uint16, port ofp10.PhyPort) {
+	topology.Lock()
+	defer topology.unlock(true)
 	sw.portsMu.Lock()
 	defer sw.portsMu.Unlock()
-	sw.ports[portNo] = port
+	ports := make(map[uint16]ofp10.PhyPort, len(sw.ports)+1)
+	for k, v := range sw.ports {
+		ports[k] = v
+	}
+	ports[portNo] = port
+	sw.ports = ports
+	sw.portSnap.Store(ports)
+}
+
+func (sw *OFSwitch) deletePort(portNo uint16) {
+	topology.Lock()
+	defer topology.unlock(true)
+	sw.portsMu.Lock()
+	defer sw.portsMu.Unlock()
+	ports := make(map[uint16]ofp10.PhyPort, len(sw.ports))
+	for k, v := range sw.ports {
+		if k != portNo {
+			ports[k] = v
+		}
+	}
+	sw.ports = ports
+	sw.portSnap.Store(ports)
 }
 
 // Returns a pointer to the Switch mapped to dpid.
 func Switch(dpid net.HardwareAddr) (*OFSwitch, bool) {
-	network.RLock()
-	defer network.RUnlock()
-	if sw, ok := network.Switches[dpid.String()]; ok {
-		return sw, ok
-	}
-	return nil, false
+	return network.get(dpid)
 }
 
 // Returns a slice of *OFPSwitches for operations across all
 // switches.
 func Switches() []*OFSwitch {
-	network.RLock()
-	defer network.RUnlock()
-	a := make([]*OFSwitch, len(network.Switches))
-	i := 0
-	for _, v := range network.Switches {
-		a[i] = v
-		i++
-	}
-	return a
+	return network.all()
 }
 
 // Disconnects Switch dpid.
 func disconnect(dpid net.HardwareAddr) {
-	network.Lock()
-	defer network.Unlock()
-	log.Printf("Closing connection with: %s", dpid)
-	network.Switches[dpid.String()].stream.Shutdown <- true
-	delete(network.Switches, dpid.String())
+	topology.Lock()
+	defer topology.unlock(true)
+	shard := network.shard(dpid)
+	shard.Lock()
+	defer shard.Unlock()
+	log.Printf("Closing connection with: %s", SwitchLabel(dpid))
+	if sw, ok := shard.switches[dpid.String()]; ok {
+		stream, _ := sw.session()
+		stream.Close()
+		sw.auxMu.Lock()
+		for _, a := range sw.aux {
+			a.Close()
+		}
+		sw.auxMu.Unlock()
+	}
+	shard.set(dpid.String(), nil)
 }
 
 // Returns a slice of all links connected to Switch s.
@@ -131,14 +319,57 @@ func (s *OFSwitch) Link(dpid net.HardwareAddr) (l Link, ok bool) {
 	return
 }
 
-// Updates the link between s.DPID and l.DPID.
+// Updates the link between s.DPID and l.DPID. An indirect link
+// doesn't replace a direct one until the direct one times out.
 func (s *OFSwitch) setLink(dpid net.HardwareAddr, l *Link) {
+	topology.Lock()
 	s.linksMu.Lock()
-	if _, ok := s.links[l.DPID.String()]; !ok {
-		log.Println("Link discovered:", dpid, l.Port, l.DPID)
+	old, ok := s.links[l.DPID.String()]
+	if ok && l.Indirect && !old.Indirect && time.Since(old.Updated) <= LinkTimeout {
+		s.linksMu.Unlock()
+		topology.unlock(false)
+		return
+	}
+	if !ok {
+		log.Println("Link discovered:", SwitchLabel(dpid), l.Port, SwitchLabel(l.DPID))
+		Publish(EventLinkUp, dpid, *l)
+	} else {
+		l.BFD = old.BFD
 	}
 	s.links[l.DPID.String()] = l
 	s.linksMu.Unlock()
+	// Refreshing a link doesn't change the topology.
+	topology.unlock(!ok || old.Port != l.Port || old.Indirect != l.Indirect)
+}
+
+// Removes the link between Switch s and the Switch dpid.
+func (s *OFSwitch) deleteLink(dpid net.HardwareAddr) {
+	topology.Lock()
+	s.linksMu.Lock()
+	l, ok := s.links[dpid.String()]
+	delete(s.links, dpid.String())
+	s.linksMu.Unlock()
+	topology.unlock(ok)
+	if ok {
+		log.Println("Link down:", SwitchLabel(s.dpid), l.Port, SwitchLabel(l.DPID))
+		Publish(EventLinkDown, s.dpid, *l)
+	}
+}
+
+// Removes links of Switch s that link discovery hasn't seen for
+// timeout. Links tracked with BFD are left alone.
+func (s *OFSwitch) expireLinks(timeout time.Duration) {
+	expired := make([]net.HardwareAddr, 0)
+	s.linksMu.RLock()
+	for _, l := range s.links {
+		if !l.BFD && time.Since(l.Updated) > timeout {
+			expired = append(expired, l.DPID)
+		}
+	}
+	s.linksMu.RUnlock()
+	for _, dpid := range expired {
+		s.deleteLink(dpid)
+	}
 }
 
 // Returns the dpid of Switch s.
@@ -146,46 +377,425 @@ func (s *OFSwitch) DPID() net.HardwareAddr {
 	return s.dpid
 }
 
+// Returns the OpenFlow version negotiated with Switch s.
+func (s *OFSwitch) Version() uint8 {
+	stream, _ := s.session()
+	return stream.Version
+}
+
 // Returns a slice of all the ports from Switch s.
 func (s *OFSwitch) Ports() []ofp10.PhyPort {
-	s.portsMu.RLock()
-	a := make([]ofp10.PhyPort, len(s.ports))
+	ports := s.portSnap.Load().(map[uint16]ofp10.PhyPort)
+	a := make([]ofp10.PhyPort, len(ports))
 	i := 0
-	for _, v := range s.ports {
+	for _, v := range ports {
 		a[i] = v
 		i++
 	}
-	s.portsMu.RUnlock()
 	return a
 }
 
 // Returns a pointer to the OfpPhyPort at port number from Switch s.
 func (sw *OFSwitch) Port(portNo uint16) (port ofp10.PhyPort, ok bool) {
-	sw.portsMu.RLock()
-	defer sw.portsMu.RUnlock()
-
-	port, ok = sw.ports[portNo]
+	port, ok = sw.portSnap.Load().(map[uint16]ofp10.PhyPort)[portNo]
 	return
 }
 
-// Sends an OpenFlow message to this Switch.
-func (s *OFSwitch) Send(req util.Message) {
-	s.stream.Outbound <- req
+var ErrSwitchDisconnected = errors.New("The switch is not connected.")
+
+// Sends an OpenFlow message to this Switch. Returns
+// ErrSwitchDisconnected instead of blocking if the connection to
+// the switch has been closed. If the switch has pacing enabled,
+// flow mods are queued and Send blocks while the queue is full,
+// and barriers and bundle messages are queued behind them.
+// Flow mods breaking the reserved priority bands are refused
+// with a *PriorityBandError, and with StrictValidation set,
+// messages breaking the specification with a
+// *ofpxx.ValidationError. Flow mods the switch's tables can't
+// hold, by its capability model, are refused too, see
+// ValidateCapabilities.
+func (s *OFSwitch) Send(req util.Message) error {
+	span := startMessageSpan("ofp.send", s.transactionSpan(req), s.dpid, req)
+	defer span.End()
+	if StrictValidation {
+		if err := s.Validate(req); err != nil {
+			return err
+		}
+	}
+	if err := s.validateCapabilities(req); err != nil {
+		return err
+	}
+	if isFlowMod(req) {
+		if err := checkFlowModPriority(req); err != nil {
+			return err
+		}
+		if p := s.pacing(); p != nil {
+			return p.enqueue(req, PriorityNormal, true)
+		}
+	}
+	if isFence(req) {
+		if p := s.pacing(); p != nil {
+			return p.enqueueFence(req)
+		}
+	}
+	return s.send(req)
+}
+
+// Sends req without pacing, applying the quirks of the switch.
+func (s *OFSwitch) send(req util.Message) error {
+	if q := s.quirks(); q != nil && isFlowMod(req) {
+		return s.sendQuirky(req, q)
+	}
+	return s.write(req)
+}
+
+// Queues req on the main connection.
+func (s *OFSwitch) write(req util.Message) error {
+	stream, _ := s.session()
+	select {
+	case <-stream.Done():
+		return ErrSwitchDisconnected
+	default:
+	}
+	t, counted := queuedType(req)
+	if counted {
+		atomic.AddInt64(&stream.counts.queued[t], 1)
+	}
+	stream.sends.enqueued(req)
+	select {
+	case stream.Outbound <- req:
+		return nil
+	case <-stream.Done():
+		if counted {
+			atomic.AddInt64(&stream.counts.queued[t], -1)
+		}
+		stream.sends.forget(req)
+		return ErrSwitchDisconnected
+	}
+}
+
+var ErrRequestTimeout = errors.New("Timed out waiting for a reply from the switch.")
+
+// Returned by requests whose connection closed before they were
+// answered, such as when the switch reconnects mid-request.
+var ErrSessionLost = errors.New("The connection to the switch was lost before the reply arrived.")
+
+// If set, idempotent requests, like stats and barrier requests,
+// whose connection is lost are sent again once the switch
+// reconnects, until their timeout.
+var RetryLostRequests = false
+
+// Sends req to this Switch and waits up to timeout for the
+// message with the same transaction id. The reply is also
+// delivered to applications as usual.
+func (s *OFSwitch) SendAndReceive(req util.Message, timeout time.Duration) (util.Message, error) {
+	var rep util.Message
+	err := s.retryLost(req, timeout, func(timeout time.Duration) (err error) {
+		rep, err = s.sendAndReceive(req, timeout)
+		return
+	})
+	return rep, err
+}
+
+func (s *OFSwitch) sendAndReceive(req util.Message, timeout time.Duration) (util.Message, error) {
+	x, ok := xid(req)
+	if !ok {
+		return nil, errors.New("Message has no OpenFlow header.")
+	}
+	ch := make(chan util.Message, 1)
+	session := s.expect(x, ch)
+	defer s.forget(x)
+	span := s.startTransaction(x, req)
+	defer s.endTransaction(x, span)
+
+	if err := s.Send(req); err != nil {
+		return nil, err
+	}
+	select {
+	case rep := <-ch:
+		return rep, nil
+	case <-session.Done():
+		return nil, ErrSessionLost
+	case <-time.After(timeout):
+		return nil, ErrRequestTimeout
+	}
+}
+
+// Runs attempt, a request of req given the time left until
+// timeout. If it fails with ErrSessionLost, RetryLostRequests is
+// set and req is idempotent, runs it again once the switch has
+// reconnected.
+func (s *OFSwitch) retryLost(req util.Message, timeout time.Duration, attempt func(time.Duration) error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		session, _ := s.session()
+		err := attempt(deadline.Sub(time.Now()))
+		if err != ErrSessionLost || !RetryLostRequests || !idempotent(req) {
+			return err
+		}
+		if !s.awaitSession(session, deadline) {
+			return err
+		}
+	}
+}
+
+// Waits until Switch s has a connection other than session, and
+// returns false if it doesn't before deadline.
+func (s *OFSwitch) awaitSession(session *MessageStream, deadline time.Time) bool {
+	sub := Subscribe(4, EventSwitchUp)
+	defer sub.Cancel()
+	timeout := time.After(deadline.Sub(time.Now()))
+	for {
+		if stream, _ := s.session(); stream != session && s.connected() {
+			return true
+		}
+		select {
+		case <-sub.C:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// Returns true if sending msg again has no further effect, so it
+// can be retried.
+func idempotent(msg util.Message) bool {
+	h, ok := messageHeader(msg)
+	if !ok {
+		return false
+	}
+	if h.Version == ofp10.VERSION {
+		switch h.Type {
+		case ofp10.Type_EchoRequest, ofp10.Type_FeaturesRequest, ofp10.Type_GetConfigRequest,
+			ofp10.Type_StatsRequest, ofp10.Type_BarrierRequest, ofp10.Type_QueueGetConfigRequest:
+			return true
+		}
+		return false
+	}
+	switch h.Type {
+	case ofp14.Type_EchoRequest, ofp14.Type_FeaturesRequest, ofp14.Type_GetConfigRequest,
+		ofp14.Type_MultipartRequest, ofp14.Type_BarrierRequest, ofp14.Type_GetAsyncRequest:
+		return true
+	}
+	return false
+}
+
+// Sends a multipart request to this Switch and collects every
+// reply until one without the MPF_REPLY_MORE flag arrives.
+func (s *OFSwitch) requestMultipart(req *ofp14.MultipartRequest, timeout time.Duration) ([]*ofp14.MultipartReply, error) {
+	var reps []*ofp14.MultipartReply
+	err := s.retryLost(req, timeout, func(timeout time.Duration) (err error) {
+		reps, err = s.sendMultipart(req, timeout)
+		return
+	})
+	return reps, err
+}
+
+func (s *OFSwitch) sendMultipart(req *ofp14.MultipartRequest, timeout time.Duration) ([]*ofp14.MultipartReply, error) {
+	ch := make(chan util.Message, 16)
+	session := s.expect(req.Xid, ch)
+	defer s.forget(req.Xid)
+	span := s.startTransaction(req.Xid, req)
+	defer s.endTransaction(req.Xid, span)
+
+	if err := s.Send(req); err != nil {
+		return nil, err
+	}
+	reps := make([]*ofp14.MultipartReply, 0)
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-ch:
+			rep, ok := msg.(*ofp14.MultipartReply)
+			if !ok {
+				return reps, errors.New("Multipart request failed: " + errorString(msg))
+			}
+			reps = append(reps, rep)
+			if rep.Flags&ofp14.MPF_REPLY_MORE == 0 {
+				return reps, nil
+			}
+		case <-session.Done():
+			return reps, ErrSessionLost
+		case <-deadline:
+			return reps, ErrRequestTimeout
+		}
+	}
+}
+
+// Sends an OpenFlow 1.0 stats request to this Switch and
+// collects every reply until one without the more flag arrives.
+func (s *OFSwitch) requestStats(req *ofp10.StatsRequest, timeout time.Duration) ([]*ofp10.StatsReply, error) {
+	var reps []*ofp10.StatsReply
+	err := s.retryLost(req, timeout, func(timeout time.Duration) (err error) {
+		reps, err = s.sendStats(req, timeout)
+		return
+	})
+	return reps, err
+}
+
+func (s *OFSwitch) sendStats(req *ofp10.StatsRequest, timeout time.Duration) ([]*ofp10.StatsReply, error) {
+	ch := make(chan util.Message, 16)
+	session := s.expect(req.Xid, ch)
+	defer s.forget(req.Xid)
+	span := s.startTransaction(req.Xid, req)
+	defer s.endTransaction(req.Xid, span)
+
+	if err := s.Send(req); err != nil {
+		return nil, err
+	}
+	reps := make([]*ofp10.StatsReply, 0)
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-ch:
+			rep, ok := msg.(*ofp10.StatsReply)
+			if !ok {
+				return reps, errors.New("Stats request failed: " + errorString(msg))
+			}
+			reps = append(reps, rep)
+			// OFPSF_REPLY_MORE
+			if rep.Flags&1 == 0 {
+				return reps, nil
+			}
+		case <-session.Done():
+			return reps, ErrSessionLost
+		case <-deadline:
+			return reps, ErrRequestTimeout
+		}
+	}
+}
+
+// Routes received messages with transaction id x to ch. Returns
+// the connection of the session the request is sent on; the
+// request is lost if it closes first.
+func (s *OFSwitch) expect(x uint32, ch chan util.Message) *MessageStream {
+	s.reqsMu.Lock()
+	s.reqs[x] = ch
+	s.reqsMu.Unlock()
+	stream, _ := s.session()
+	return stream
+}
+
+func (s *OFSwitch) forget(x uint32) {
+	s.reqsMu.Lock()
+	delete(s.reqs, x)
+	s.reqsMu.Unlock()
+}
+
+// Hands msg to the caller waiting on its transaction id, if
+// any. Never blocks.
+func (s *OFSwitch) deliver(msg util.Message) {
+	x, ok := xid(msg)
+	if !ok {
+		return
+	}
+	s.reqsMu.RLock()
+	ch, ok := s.reqs[x]
+	s.reqsMu.RUnlock()
+	if ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Returns the transaction id of msg if it has an OpenFlow header.
+func xid(msg util.Message) (uint32, bool) {
+	if h, ok := messageHeader(msg); ok {
+		return h.Xid, true
+	}
+	return 0, false
+}
+
+// Returns the OpenFlow header of msg, if it has one.
+func messageHeader(msg util.Message) (*ofpxx.Header, bool) {
+	if h, ok := msg.(interface {
+		Header() *ofpxx.Header
+	}); ok {
+		return h.Header(), true
+	}
+	// The Header method of an embedded header is hidden by the
+	// field of the same name.
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	f := v.Elem().FieldByName("Header")
+	if !f.IsValid() || !f.CanAddr() {
+		return nil, false
+	}
+	h, ok := f.Addr().Interface().(*ofpxx.Header)
+	return h, ok
+}
+
+// Returns true if msg is an error reporting a flow rejected
+// because the switch's tables are full.
+func tableFull(msg util.Message) bool {
+	switch m := msg.(type) {
+	case *ofp10.ErrorMsg:
+		// The 1.0 error code is the start of Data.
+		b := m.Data.Bytes()
+		return m.Code == ofp10.ET_FLOW_MOD_FAILED && len(b) >= 2 &&
+			binary.BigEndian.Uint16(b) == ofp10.FMFC_ALL_TABLES_FULL
+	case *ofp14.ErrorMsg:
+		// OFPFMFC_TABLE_FULL
+		return m.Type == ofp14.ET_FLOW_MOD_FAILED && m.Code == 1
+	}
+	return false
 }
 
 // Receive loop for each Switch.
-func (s *OFSwitch) receive() {
+// Handles messages received on stream until it fails. done is
+// closed on return, and is nil for auxiliary connections.
+func (s *OFSwitch) receive(stream *MessageStream, done chan struct{}) {
+	if done != nil {
+		defer close(done)
+	}
 	for {
 		select {
-		case msg := <-s.stream.Inbound:
+		case msg := <-stream.Inbound:
 			// New message has been received from message
 			// stream.
-			go s.distributeMessages(s.dpid, msg)
-		case err := <-s.stream.Error:
+			stamp, _ := stream.takeStamp(msg)
+			setMessageTime(msg, stamp)
+			span := startMessageSpan("ofp.receive", s.transactionSpan(msg), s.dpid, msg)
+			s.deliver(msg)
+			if tableFull(msg) {
+				Publish(EventTableFull, s.DPID(), msg)
+			}
+			if rep, ok := msg.(*ofp14.MultipartReply); ok {
+				if body, ok := rep.Body.(*ofp14.FlowMonitorReply); ok {
+					s.updateFlows(body)
+				}
+			}
+			network.dispatch(s.dpid, func() {
+				s.distributeMessages(s.dpid, msg, span)
+			})
+		case err := <-stream.Errors():
+			Publish(EventStreamError, s.DPID(), err)
+		case err := <-stream.Error:
 			// Message stream has been disconnected.
-			for _, app := range s.appInstance {
+			if done == nil {
+				log.Println("Auxiliary connection closed:", SwitchLabel(s.DPID()), err)
+				s.removeAuxiliary(stream)
+				return
+			}
+			// The switch leaves the topology until it reconnects.
+			topology.Lock()
+			s.downMu.Lock()
+			// A probe failure closing the connection came first.
+			if s.downErr == nil {
+				s.downErr, s.downAt = err, time.Now()
+			}
+			s.downMu.Unlock()
+			topology.unlock(true)
+			Publish(EventSwitchDown, s.DPID(), err)
+			for i, app := range s.instances() {
 				if actor, ok := app.(ofp10.ConnectionDownReactor); ok {
-					actor.ConnectionDown(s.DPID(), err)
+					s.supervise(i, app, nil, func() {
+						actor.ConnectionDown(s.DPID(), err)
+					})
 				}
 			}
 			return
@@ -193,94 +803,163 @@ func (s *OFSwitch) receive() {
 	}
 }
 
-func (s *OFSwitch) distributeMessages(dpid net.HardwareAddr, msg util.Message) {
-	for _, app := range s.appInstance {
-		switch t := msg.(type) {
-		case *ofpxx.Header:
-			switch t.Header().Type {
-			case ofp10.Type_Hello:
-				if actor, ok := app.(ofp10.HelloReactor); ok {
-					actor.Hello(t)
-				}
-			case ofp10.Type_EchoRequest:
-				if actor, ok := app.(ofp10.EchoRequestReactor); ok {
-					actor.EchoRequest(s.DPID())
-				}	
-			case ofp10.Type_EchoReply:
-				if actor, ok := app.(ofp10.EchoReplyReactor); ok {
-					actor.EchoReply(s.DPID())
-				}
-			case ofp10.Type_FeaturesRequest:
-				if actor, ok := app.(ofp10.FeaturesRequestReactor); ok {
-					actor.FeaturesRequest(t)
-				}
-			case ofp10.Type_GetConfigRequest:
-				if actor, ok := app.(ofp10.GetConfigRequestReactor); ok {
-					actor.GetConfigRequest(t)
-				}
-			case ofp10.Type_BarrierRequest:
-				if actor, ok := app.(ofp10.BarrierRequestReactor); ok {
-					actor.BarrierRequest(t)
-				}
-			case ofp10.Type_BarrierReply:
-				if actor, ok := app.(ofp10.BarrierReplyReactor); ok {
-					actor.BarrierReply(s.DPID(), t)
-				}
-			}
-		case *ofp10.ErrorMsg:
-			if actor, ok := app.(ofp10.ErrorReactor); ok {
-				actor.Error(s.DPID(), t)
-			}
-		case *ofp10.VendorHeader:
-			if actor, ok := app.(ofp10.VendorReactor); ok {
-				actor.VendorHeader(s.DPID(), t)
+// Hands msg to the packet-in chain and the applications. span
+// is the span of msg, ended once every application handled it.
+func (s *OFSwitch) distributeMessages(dpid net.HardwareAddr, msg util.Message, span Span) {
+	defer span.End()
+	defer clearMessageTime(msg)
+	// Packet-ins go through the handler chain first and only
+	// reach applications if no handler consumed them.
+	if pkt, ok := msg.(*ofp10.PacketIn); ok {
+		chain := startSpan("ofp.packetin", span)
+		consumed := packetIns.handle(dpid, pkt, packetInMeta{reason: pkt.Reason})
+		chain.End()
+		if consumed {
+			return
+		}
+	}
+	// Newer packet-ins only reach filtered handlers.
+	if p, ok := msg.(*ofp14.PacketIn); ok {
+		chain := startSpan("ofp.packetin", span)
+		pkt, m := packetIn10(p)
+		// Handlers see the converted packet-in.
+		if t, ok := MessageTime(p); ok {
+			setMessageTime(pkt, t)
+			defer clearMessageTime(pkt)
+		}
+		consumed := packetIns.handle(dpid, pkt, m)
+		chain.End()
+		if consumed {
+			return
+		}
+	}
+	for i, app := range s.instances() {
+		s.supervise(i, app, msg, func() {
+			appSpan := startSpan("ofp.app", span)
+			defer appSpan.End()
+			if _, ok := appSpan.(noopSpan); !ok {
+				appSpan.SetAttribute("ofp.app", appName(app))
 			}
-		case *ofp10.SwitchFeatures:
-			if actor, ok := app.(ofp10.FeaturesReplyReactor); ok {
-				actor.FeaturesReply(s.DPID(), t)
+			s.deliverTo(app, msg)
+		})
+	}
+}
+
+// Hands msg to the handler of app for its type, if any.
+func (s *OFSwitch) deliverTo(app interface{}, msg util.Message) {
+	switch t := msg.(type) {
+	case *ofpxx.Hello:
+		if actor, ok := app.(ofp10.HelloReactor); ok {
+			actor.Hello(&t.Header)
+		}
+	case *ofpxx.Header:
+		switch t.Header().Type {
+		case ofp10.Type_Hello:
+			if actor, ok := app.(ofp10.HelloReactor); ok {
+				actor.Hello(t)
 			}
-		case *ofp10.SwitchConfig:
-			switch t.Header.Type {
-			case ofp10.Type_GetConfigReply:
-				if actor, ok := app.(ofp10.GetConfigReplyReactor); ok {
-					actor.GetConfigReply(s.DPID(), t)
-				}
-			case ofp10.Type_SetConfig:
-				if actor, ok := app.(ofp10.SetConfigReactor); ok {
-					actor.SetConfig(t)
-				}
+		case ofp10.Type_EchoRequest:
+			if actor, ok := app.(ofp10.EchoRequestReactor); ok {
+				actor.EchoRequest(s.DPID())
+			}	
+		case ofp10.Type_EchoReply:
+			if actor, ok := app.(ofp10.EchoReplyReactor); ok {
+				actor.EchoReply(s.DPID())
 			}
-		case *ofp10.PacketIn:
-			if actor, ok := app.(ofp10.PacketInReactor); ok {
-				actor.PacketIn(s.DPID(), t)
+		case ofp10.Type_FeaturesRequest:
+			if actor, ok := app.(ofp10.FeaturesRequestReactor); ok {
+				actor.FeaturesRequest(t)
 			}
-		case *ofp10.FlowRemoved:
-			if actor, ok := app.(ofp10.FlowRemovedReactor); ok {
-				actor.FlowRemoved(s.DPID(), t)
+		case ofp10.Type_GetConfigRequest:
+			if actor, ok := app.(ofp10.GetConfigRequestReactor); ok {
+				actor.GetConfigRequest(t)
 			}
-		case *ofp10.PortStatus:
-			if actor, ok := app.(ofp10.PortStatusReactor); ok {
-				actor.PortStatus(s.DPID(), t)
+		case ofp10.Type_BarrierRequest:
+			if actor, ok := app.(ofp10.BarrierRequestReactor); ok {
+				actor.BarrierRequest(t)
 			}
-		case *ofp10.PacketOut:
-			if actor, ok := app.(ofp10.PacketOutReactor); ok {
-				actor.PacketOut(t)
+		case ofp10.Type_BarrierReply:
+			if actor, ok := app.(ofp10.BarrierReplyReactor); ok {
+				actor.BarrierReply(s.DPID(), t)
 			}
-		case *ofp10.FlowMod:
-			if actor, ok := app.(ofp10.FlowModReactor); ok {
-				actor.FlowMod(t)
+		}
+	case *ofp10.ErrorMsg:
+		if actor, ok := app.(ofp10.ErrorReactor); ok {
+			actor.Error(s.DPID(), t)
+		}
+	case *ofp10.VendorHeader:
+		if actor, ok := app.(ofp10.VendorReactor); ok {
+			actor.VendorHeader(s.DPID(), t)
+		}
+	case *ofp10.SwitchFeatures:
+		if actor, ok := app.(ofp10.FeaturesReplyReactor); ok {
+			actor.FeaturesReply(s.DPID(), t)
+		}
+	case *ofp10.SwitchConfig:
+		switch t.Header.Type {
+		case ofp10.Type_GetConfigReply:
+			if actor, ok := app.(ofp10.GetConfigReplyReactor); ok {
+				actor.GetConfigReply(s.DPID(), t)
 			}
-		case *ofp10.PortMod:
-			if actor, ok := app.(ofp10.PortModReactor); ok {
-				actor.PortMod(t)
+		case ofp10.Type_SetConfig:
+			if actor, ok := app.(ofp10.SetConfigReactor); ok {
+				actor.SetConfig(t)
 			}
-		case *ofp10.StatsRequest:
-			if actor, ok := app.(ofp10.StatsRequestReactor); ok {
-				actor.StatsRequest(t)
+		}
+	case *ofp10.PacketIn:
+		if actor, ok := app.(ofp10.PacketInReactor); ok {
+			actor.PacketIn(s.DPID(), t)
+		}
+	case *ofp10.FlowRemoved:
+		if actor, ok := app.(ofp10.FlowRemovedReactor); ok {
+			actor.FlowRemoved(s.DPID(), t)
+		}
+	case *ofp10.PortStatus:
+		if actor, ok := app.(ofp10.PortStatusReactor); ok {
+			actor.PortStatus(s.DPID(), t)
+		}
+	case *ofp10.PacketOut:
+		if actor, ok := app.(ofp10.PacketOutReactor); ok {
+			actor.PacketOut(t)
+		}
+	case *ofp10.FlowMod:
+		if actor, ok := app.(ofp10.FlowModReactor); ok {
+			actor.FlowMod(t)
+		}
+	case *ofp10.PortMod:
+		if actor, ok := app.(ofp10.PortModReactor); ok {
+			actor.PortMod(t)
+		}
+	case *ofp10.StatsRequest:
+		if actor, ok := app.(ofp10.StatsRequestReactor); ok {
+			actor.StatsRequest(t)
+		}
+	case *ofp10.StatsReply:
+		if actor, ok := app.(ofp10.StatsReplyReactor); ok {
+			actor.StatsReply(s.DPID(), t)
+		}
+	case *ofp14.ErrorMsg:
+		if actor, ok := app.(ofp14.ErrorReactor); ok {
+			actor.Error(s.DPID(), t)
+		}
+	case *ofp14.BundleCtrl:
+		if actor, ok := app.(ofp14.BundleCtrlReactor); ok {
+			actor.BundleCtrl(s.DPID(), t)
+		}
+	case *ofp14.MultipartReply:
+		if actor, ok := app.(ofp14.MultipartReplyReactor); ok {
+			actor.MultipartReply(s.DPID(), t)
+		}
+		if rep, ok := t.Body.(*ofp14.FlowMonitorReply); ok {
+			if actor, ok := app.(ofp14.FlowMonitorReactor); ok {
+				actor.FlowMonitor(s.DPID(), rep)
 			}
-		case *ofp10.StatsReply:
-			if actor, ok := app.(ofp10.StatsReplyReactor); ok {
-				actor.StatsReply(s.DPID(), t)
+			if actor, ok := app.(ofp14.FlowChangedReactor); ok {
+				for _, u := range rep.Updates {
+					if f, ok := u.(*ofp14.FlowUpdateFull); ok {
+						actor.FlowChanged(s.DPID(), f.Event, f)
+					}
+				}
 			}
 		}
 	}
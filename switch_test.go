@@ -0,0 +1,248 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Returns an OpenFlow 1.0 vendor stats reply to xid, carrying
+// part in its body.
+func rawStatsReply(xid uint32, part int, more bool) []byte {
+	b := make([]byte, 16)
+	b[0], b[1] = ofp10.VERSION, ofp10.Type_StatsReply
+	binary.BigEndian.PutUint16(b[2:], 16)
+	binary.BigEndian.PutUint32(b[4:], xid)
+	binary.BigEndian.PutUint16(b[8:], 0xffff)
+	if more {
+		binary.BigEndian.PutUint16(b[10:], 1)
+	}
+	binary.BigEndian.PutUint32(b[12:], uint32(part))
+	return b
+}
+
+// Adds a switch receiving from one end of a pipe, and returns
+// the other end.
+func receivingSwitch(dpid net.HardwareAddr) (*OFSwitch, net.Conn) {
+	sw, conn := testSwitch(dpid)
+	sw.reqs = make(map[uint32]chan util.Message)
+	sw.startReceive(sw.stream)
+	return sw, conn
+}
+
+// Closes the connection of sw and waits for its receive loop.
+func closeSession(sw *OFSwitch) {
+	stream, receiving := sw.session()
+	stream.Close()
+	<-receiving
+}
+
+// Replaces the connection of sw with a new pipe, as a reconnect
+// would, and returns its other end.
+func reconnect(sw *OFSwitch) net.Conn {
+	client, server := net.Pipe()
+	stream := NewMessageStream(client)
+	stream.Version = ofp10.VERSION
+	sw.startReceive(stream)
+	Publish(EventSwitchUp, sw.DPID(), nil)
+	return server
+}
+
+func TestSwitchStatsOrder(t *testing.T) {
+	network = NewNetwork()
+	sw, conn := receivingSwitch(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	defer conn.Close()
+
+	go func() {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		var b []byte
+		for i := 0; i < 100; i++ {
+			b = append(b, rawStatsReply(msg.(*ofp10.StatsRequest).Xid, i, i < 99)...)
+		}
+		conn.Write(b)
+	}()
+	reps, err := sw.requestStats(ofp10.NewStatsRequest(0xffff, nil), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 100 {
+		t.Fatalf("Got %d replies, expected 100.", len(reps))
+	}
+	for i, rep := range reps {
+		b, _ := rep.Body.MarshalBinary()
+		if part := binary.BigEndian.Uint32(b); part != uint32(i) {
+			t.Fatalf("Reply %d was part %d.", i, part)
+		}
+	}
+	closeSession(sw)
+}
+
+func TestSwitchSessionLost(t *testing.T) {
+	defer func(retry bool) { RetryLostRequests = retry }(RetryLostRequests)
+	tests := []struct {
+		retry bool
+		req   util.Message
+		err   error
+	}{
+		// Requests fail when their connection closes.
+		{false, ofp10.NewStatsRequest(ofp10.StatsType_Table, nil), ErrSessionLost},
+		// Idempotent requests are sent again after a reconnect.
+		{true, ofp10.NewStatsRequest(ofp10.StatsType_Table, nil), nil},
+		{true, ofp10.NewBarrierRequest(), nil},
+		// Others are not.
+		{true, ofp10.NewFlowMod(), ErrSessionLost},
+	}
+	for i, test := range tests {
+		network = NewNetwork()
+		RetryLostRequests = test.retry
+		sw, conn := receivingSwitch(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+		go func() {
+			// The first connection closes before replying,
+			// the second replies.
+			if _, err := readMessage(conn); err != nil {
+				return
+			}
+			closeSession(sw)
+			conn.Close()
+			if !test.retry {
+				return
+			}
+			conn := reconnect(sw)
+			defer conn.Close()
+			msg, err := readMessage(conn)
+			if err != nil {
+				return
+			}
+			var b []byte
+			if req, ok := msg.(*ofp10.StatsRequest); ok {
+				b = rawStatsReply(req.Xid, 0, false)
+			} else {
+				b, _ = msg.MarshalBinary()
+				b[1] = ofp10.Type_BarrierReply
+			}
+			conn.Write(b)
+			time.Sleep(100 * time.Millisecond)
+		}()
+		var err error
+		switch req := test.req.(type) {
+		case *ofp10.StatsRequest:
+			_, err = sw.requestStats(req, time.Second)
+		default:
+			_, err = sw.SendAndReceive(req, time.Second)
+		}
+		if err != test.err {
+			t.Errorf("Test %d: got %v, expected %v.", i, err, test.err)
+		}
+		closeSession(sw)
+	}
+}
+
+// Returns a stream of a switch connection whose messages are
+// discarded, and the other end.
+func discardingStream() (*MessageStream, net.Conn) {
+	client, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	stream := NewMessageStream(client)
+	stream.Version = ofp10.VERSION
+	return stream, server
+}
+
+func TestSwitchDuplicateDPID(t *testing.T) {
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+	tests := []struct {
+		policy DuplicateDPIDPolicy
+		added  bool
+		err    error
+		// Whether the second connection becomes the main
+		// one, or an auxiliary one.
+		main, aux bool
+	}{
+		{DuplicateReject, false, ErrDuplicateDPID, false, false},
+		{DuplicateReplace, true, nil, true, false},
+		{DuplicateAuxiliary, false, nil, false, true},
+	}
+	for i, test := range tests {
+		network = NewNetwork()
+		first, c1 := discardingStream()
+		defer c1.Close()
+		second, c2 := discardingStream()
+		defer c2.Close()
+		if _, err := newSwitch(first, dpid, nil, test.policy); err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		added, err := newSwitch(second, dpid, nil, test.policy)
+		if added != test.added || err != test.err {
+			t.Errorf("Test %d: got %v and %v.", i, added, err)
+		}
+		sw, _ := Switch(dpid)
+		main, _ := sw.session()
+		if (main == second) != test.main {
+			t.Errorf("Test %d: expected the second connection to be the main one to be %v.", i, test.main)
+		}
+		if test.main {
+			select {
+			case <-first.Done():
+			case <-time.After(time.Second):
+				t.Errorf("Test %d: the first connection wasn't closed.", i)
+			}
+		}
+		sw.auxMu.Lock()
+		aux := len(sw.aux) == 1 && sw.aux[0] == second
+		sw.auxMu.Unlock()
+		if aux != test.aux {
+			t.Errorf("Test %d: expected the second connection to be auxiliary to be %v.", i, test.aux)
+		}
+		closeSession(sw)
+		second.Close()
+	}
+}
+
+// A switch reconnecting right after its connection closed is
+// marked down, then up, never the other way round.
+func TestSwitchRecover(t *testing.T) {
+	dpid := net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 2}
+	for i := 0; i < 20; i++ {
+		network = NewNetwork()
+		first, c1 := discardingStream()
+		if _, err := newSwitch(first, dpid, nil, DuplicateReject); err != nil {
+			t.Fatal(err)
+		}
+		sub := Subscribe(4, EventSwitchDown, EventSwitchUp)
+		c1.Close()
+		<-first.Done()
+		second, c2 := discardingStream()
+		if added, err := newSwitch(second, dpid, nil, DuplicateReject); !added || err != nil {
+			t.Fatalf("Got %v and %v, expected the switch to recover.", added, err)
+		}
+		var events []string
+		for len(events) < 2 {
+			select {
+			case e := <-sub.C:
+				if e.DPID.String() == dpid.String() {
+					events = append(events, e.Type)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Got %v only.", events)
+			}
+		}
+		if events[0] != EventSwitchDown || events[1] != EventSwitchUp {
+			t.Fatalf("Got %v.", events)
+		}
+		sw, _ := Switch(dpid)
+		if !sw.connected() || sw.downErr != nil {
+			t.Fatal("The recovered switch is down.")
+		}
+		sub.Cancel()
+		closeSession(sw)
+		c2.Close()
+	}
+}
@@ -0,0 +1,341 @@
+package ogo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How long to wait for the flows of a switch when synchronizing
+// them.
+var SyncTimeout = time.Second * 10
+
+// The changes that bring the flow tables of a switch to a
+// desired flow set. Modified flows have the same table,
+// priority and match as a live flow but different actions,
+// cookie or timeouts, and are given as desired.
+type FlowDiff struct {
+	Added    []FlowSpec `json:"added"`
+	Modified []FlowSpec `json:"modified"`
+	Deleted  []FlowSpec `json:"deleted"`
+}
+
+// Returns true if d has no changes.
+func (d *FlowDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Modified) == 0 && len(d.Deleted) == 0
+}
+
+// Returns the flows in the flow tables of Switch s. OpenFlow 1.0
+// and 1.4 switches are asked for their flow stats. OpenFlow 1.5
+// flows are read from the flow shadow, which needs an active
+// flow monitor. Flows that can't be expressed as a FlowSpec, such
+// as those matching fields it has no name for, are logged and
+// left out, so they are never changed by SyncFlows.
+func (s *OFSwitch) DumpFlows(timeout time.Duration) (*FlowSet, error) {
+	fs := &FlowSet{Flows: make([]FlowSpec, 0)}
+	switch s.Version() {
+	case ofp10.VERSION:
+		req := ofp10.NewFlowStatsRequest()
+		req.TableId = 0xff // All tables
+		req.OutPort = ofp10.P_NONE
+		reps, err := s.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Flow, req), timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range reps {
+			body, ok := rep.Body.(*ofp10.FlowStatsReply)
+			if !ok {
+				continue
+			}
+			for i := range body.Flows {
+				f, err := ofp10FlowSpec(&body.Flows[i])
+				if err != nil {
+					s.skipFlow(err)
+					continue
+				}
+				fs.Flows = append(fs.Flows, f)
+			}
+		}
+	case ofp13.VERSION, ofp14.VERSION:
+		req := s.newMultipartRequest(ofp14.MultipartType_Flow, ofp14.NewFlowStatsRequest())
+		reps, err := s.requestMultipart(req, timeout)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]FlowEntry, 0)
+		for _, rep := range reps {
+			body, ok := rep.Body.(*ofp14.FlowStatsReply)
+			if !ok {
+				continue
+			}
+		flows:
+			for _, f := range body.Flows {
+				e := FlowEntry{TableId: f.TableId, Priority: f.Priority, Cookie: f.Cookie,
+					IdleTimeout: f.IdleTimeout, HardTimeout: f.HardTimeout, Match: f.Match}
+				for _, i := range f.Instructions {
+					b, err := i.MarshalBinary()
+					if err != nil {
+						s.skipFlow(err)
+						continue flows
+					}
+					e.Instructions = append(e.Instructions, b...)
+				}
+				entries = append(entries, e)
+			}
+		}
+		fs.Flows = s.flowSpecs(entries)
+	default:
+		if s.Version() < ofp14.VERSION {
+			return nil, errors.New("Flows can only be dumped from switches with a flow shadow.")
+		}
+		fs.Flows = s.flowSpecs(s.Flows())
+	}
+	return fs, nil
+}
+
+// Converts entries, sorted, to flow specs, leaving out those that
+// can't be.
+func (s *OFSwitch) flowSpecs(entries []FlowEntry) []FlowSpec {
+	sort.Sort(flowEntries(entries))
+	specs := make([]FlowSpec, 0, len(entries))
+	for _, e := range entries {
+		f, err := flowSpec(e)
+		if err != nil {
+			s.skipFlow(err)
+			continue
+		}
+		specs = append(specs, f)
+	}
+	return specs
+}
+
+// Logs a flow of Switch s left out of a dump.
+func (s *OFSwitch) skipFlow(err error) {
+	log.Println("Skipping a flow of", SwitchLabel(s.dpid)+":", err)
+}
+
+// Converts the flow stats of an OpenFlow 1.0 switch.
+func ofp10FlowSpec(st *ofp10.FlowStats) (FlowSpec, error) {
+	f := FlowSpec{Table: st.TableId, Priority: st.Priority, Cookie: st.Cookie,
+		IdleTimeout: st.IdleTimeout, HardTimeout: st.HardTimeout, Match: make(map[string]string)}
+	if f.Table == 0xff {
+		f.Table = 0
+	}
+	m := &st.Match
+	w := m.Wildcards
+	if w&ofp10.FW_IN_PORT == 0 {
+		f.Match["in_port"] = strconv.Itoa(int(m.InPort))
+	}
+	if w&ofp10.FW_DL_SRC == 0 {
+		f.Match["eth_src"] = m.DLSrc.String()
+	}
+	if w&ofp10.FW_DL_DST == 0 {
+		f.Match["eth_dst"] = m.DLDst.String()
+	}
+	if w&ofp10.FW_DL_VLAN == 0 {
+		f.Match["vlan_vid"] = strconv.Itoa(int(m.DLVLAN))
+	}
+	if w&ofp10.FW_DL_TYPE == 0 {
+		f.Match["eth_type"] = fmt.Sprintf("0x%04x", m.DLType)
+	}
+	if w&ofp10.FW_NW_PROTO == 0 {
+		f.Match["ip_proto"] = strconv.Itoa(int(m.NWProto))
+	}
+	for _, ip := range []struct {
+		name  string
+		shift uint
+		addr  net.IP
+	}{{"ipv4_src", ofp10.FW_NW_SRC_SHIFT, m.NWSrc}, {"ipv4_dst", ofp10.FW_NW_DST_SHIFT, m.NWDst}} {
+		switch bits := w >> ip.shift & 0x3f; {
+		case bits == 0:
+			f.Match[ip.name] = ip.addr.String()
+		case bits < 32:
+			return f, fmt.Errorf("Flow matches %s on a prefix, which can't be synchronized.", ip.name)
+		}
+	}
+	proto := "tcp"
+	if m.NWProto == 17 {
+		proto = "udp"
+	}
+	if w&ofp10.FW_TP_SRC == 0 {
+		f.Match[proto+"_src"] = strconv.Itoa(int(m.TPSrc))
+	}
+	if w&ofp10.FW_TP_DST == 0 {
+		f.Match[proto+"_dst"] = strconv.Itoa(int(m.TPDst))
+	}
+
+	names := make(map[uint16]string)
+	for name, p := range flowSetPorts {
+		names[p] = name
+	}
+	for _, a := range st.Actions {
+		o, ok := a.(*ofp10.ActionOutput)
+		if !ok {
+			return f, errors.New("Flow has an action that can't be synchronized.")
+		}
+		if name, ok := names[o.Port]; ok {
+			f.Actions = append(f.Actions, name)
+		} else {
+			f.Actions = append(f.Actions, fmt.Sprintf("output:%d", o.Port))
+		}
+	}
+	return f, nil
+}
+
+// Returns the changes that turn the flows in live into those in
+// desired. Flows are compared by table, priority and match, so
+// field values written differently, like 0x0800 and 2048,
+// compare equal.
+func DiffFlows(live, desired *FlowSet) (*FlowDiff, error) {
+	d := &FlowDiff{Added: make([]FlowSpec, 0), Modified: make([]FlowSpec, 0), Deleted: make([]FlowSpec, 0)}
+	have := make(map[string]FlowSpec)
+	for i, f := range live.Flows {
+		k, err := f.key()
+		if err != nil {
+			return nil, fmt.Errorf("live flow %d: %v", i+1, err)
+		}
+		have[k] = f
+	}
+	want := make(map[string]bool)
+	for i, f := range desired.Flows {
+		k, err := f.key()
+		if err != nil {
+			return nil, fmt.Errorf("flow %d: %v", i+1, err)
+		}
+		if want[k] {
+			return nil, fmt.Errorf("flow %d: duplicate of an earlier flow", i+1)
+		}
+		want[k] = true
+		cur, ok := have[k]
+		if !ok {
+			d.Added = append(d.Added, f)
+			continue
+		}
+		same, err := f.same(cur)
+		if err != nil {
+			return nil, fmt.Errorf("flow %d: %v", i+1, err)
+		}
+		if !same {
+			d.Modified = append(d.Modified, f)
+		}
+	}
+	for _, f := range live.Flows {
+		if k, _ := f.key(); !want[k] {
+			d.Deleted = append(d.Deleted, f)
+		}
+	}
+	return d, nil
+}
+
+// Returns the table, priority and canonical match of f.
+func (f FlowSpec) key() (string, error) {
+	m, err := f.ofp14Match()
+	if err != nil {
+		return "", err
+	}
+	return flowKey(f.Table, f.Priority, &m), nil
+}
+
+// Returns true if f and g have the same cookie, timeouts and
+// actions.
+func (f FlowSpec) same(g FlowSpec) (bool, error) {
+	if f.Cookie != g.Cookie || f.IdleTimeout != g.IdleTimeout || f.HardTimeout != g.HardTimeout {
+		return false, nil
+	}
+	a, err := f.ofp14Instructions()
+	if err != nil {
+		return false, err
+	}
+	b, err := g.ofp14Instructions()
+	if err != nil {
+		return false, err
+	}
+	if len(a) != len(b) {
+		return false, nil
+	}
+	for i := range a {
+		x, _ := a[i].MarshalBinary()
+		y, _ := b[i].MarshalBinary()
+		if !bytes.Equal(x, y) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Brings the flow tables of Switch s to fs, changing only the
+// flows that differ, and returns the changes. Every flow not in
+// fs is deleted. The changes are applied in a single bundle, so
+// on OpenFlow 1.4+ switches either all of them are applied or
+// none. If dryRun is true the changes are only computed.
+func (s *OFSwitch) SyncFlows(fs *FlowSet, dryRun bool) (*FlowDiff, error) {
+	live, err := s.DumpFlows(SyncTimeout)
+	if err != nil {
+		return nil, err
+	}
+	d, err := DiffFlows(live, fs)
+	if err != nil || dryRun || d.Empty() {
+		return d, err
+	}
+	msgs, err := s.syncMessages(d)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := s.OpenBundle()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if err := s.AddToBundle(b, m); err != nil {
+			s.DiscardBundle(b)
+			return nil, err
+		}
+	}
+	if err := s.CommitBundle(b); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Returns the flow mods applying d to s: adds for added and
+// modified flows, then strict deletes for deleted ones.
+func (s *OFSwitch) syncMessages(d *FlowDiff) ([]util.Message, error) {
+	msgs := make([]util.Message, 0)
+	// Adding a flow with the table, priority and match of an
+	// existing flow replaces it, including its cookie and
+	// timeouts, which a modify would leave unchanged.
+	for _, f := range append(d.Added, d.Modified...) {
+		m, err := s.flowSpecMod(f)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	for _, f := range d.Deleted {
+		m, err := s.flowSpecMod(f)
+		if err != nil {
+			return nil, err
+		}
+		switch fm := m.(type) {
+		case *ofp10.FlowMod:
+			fm.Command = ofp10.FC_DELETE_STRICT
+			fm.Actions = nil
+		case *ofp14.FlowMod:
+			fm.Command = ofp14.FC_DELETE_STRICT
+			fm.Instructions = nil
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
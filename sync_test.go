@@ -0,0 +1,146 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+)
+
+func testFlowSpec(prio uint16, port string, actions ...string) FlowSpec {
+	return FlowSpec{Priority: prio, Match: map[string]string{"in_port": port}, Actions: actions}
+}
+
+func TestDiffFlows(t *testing.T) {
+	live := []FlowSpec{
+		testFlowSpec(100, "1", "output:2"),
+		testFlowSpec(100, "2", "output:1"),
+		testFlowSpec(0, "3", "controller"),
+	}
+	tests := []struct {
+		name     string
+		desired  []FlowSpec
+		added    int
+		modified int
+		deleted  int
+		ok       bool
+	}{
+		{"unchanged", live, 0, 0, 0, true},
+		{"added", append([]FlowSpec{testFlowSpec(100, "4")}, live...), 1, 0, 0, true},
+		{"deleted", live[:2], 0, 0, 1, true},
+		{"modified actions", []FlowSpec{testFlowSpec(100, "1", "output:3"), live[1], live[2]}, 0, 1, 0, true},
+		{"modified cookie", []FlowSpec{{Priority: 100, Cookie: 7, Match: live[0].Match, Actions: live[0].Actions},
+			live[1], live[2]}, 0, 1, 0, true},
+		// Another priority is another flow.
+		{"moved", []FlowSpec{testFlowSpec(200, "1", "output:2"), live[1], live[2]}, 1, 0, 1, true},
+		{"all", []FlowSpec{testFlowSpec(100, "1", "flood"), testFlowSpec(100, "5", "output:1")}, 1, 1, 2, true},
+		{"duplicate", []FlowSpec{live[0], live[0]}, 0, 0, 0, false},
+		{"bad match", []FlowSpec{{Priority: 1, Match: map[string]string{"in_port": "x"}}}, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		d, err := DiffFlows(&FlowSet{Flows: live}, &FlowSet{Flows: test.desired})
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v.", test.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(d.Added) != test.added || len(d.Modified) != test.modified || len(d.Deleted) != test.deleted {
+			t.Errorf("%s: got %d added, %d modified, %d deleted, expected %d, %d, %d.", test.name,
+				len(d.Added), len(d.Modified), len(d.Deleted), test.added, test.modified, test.deleted)
+		}
+		if d.Empty() != (test.added+test.modified+test.deleted == 0) {
+			t.Errorf("%s: Empty returned %t.", test.name, d.Empty())
+		}
+	}
+}
+
+func TestSyncMessages(t *testing.T) {
+	d := &FlowDiff{
+		Added:    []FlowSpec{testFlowSpec(100, "4", "output:1")},
+		Modified: []FlowSpec{testFlowSpec(100, "1", "output:3")},
+		Deleted:  []FlowSpec{testFlowSpec(0, "3", "controller")},
+	}
+	for _, version := range []uint8{ofp10.VERSION, 4, ofp14.VERSION} {
+		client, _ := net.Pipe()
+		stream := NewMessageStream(client)
+		stream.Version = version
+		sw := &OFSwitch{stream: stream}
+		msgs, err := sw.syncMessages(d)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		// Added and modified flows are added, replacing the
+		// live flow, and deleted flows are deleted strictly.
+		commands := make([]uint16, 0)
+		for _, m := range msgs {
+			switch f := m.(type) {
+			case *ofp10.FlowMod:
+				if f.Command == ofp10.FC_DELETE_STRICT && len(f.Actions) > 0 {
+					t.Errorf("version %d: deletion has actions.", version)
+				}
+				commands = append(commands, f.Command)
+			case *ofp14.FlowMod:
+				if f.Header.Version != version {
+					t.Errorf("version %d: got a version %d flow mod.", version, f.Header.Version)
+				}
+				if f.Command == ofp14.FC_DELETE_STRICT && len(f.Instructions) > 0 {
+					t.Errorf("version %d: deletion has instructions.", version)
+				}
+				commands = append(commands, uint16(f.Command))
+			}
+		}
+		if len(commands) != 3 || commands[0] != ofp10.FC_ADD || commands[1] != ofp10.FC_ADD ||
+			commands[2] != ofp10.FC_DELETE_STRICT {
+			t.Errorf("version %d: got commands %v.", version, commands)
+		}
+		client.Close()
+	}
+}
+
+func TestDumpFlowsSkips(t *testing.T) {
+	network = NewNetwork()
+	sw, conn := receivingSwitch(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	defer conn.Close()
+	defer closeSession(sw)
+
+	go func() {
+		// Flow stats requests don't parse, only their
+		// transaction id is needed.
+		req, err := readRaw(conn)
+		if err != nil {
+			return
+		}
+		body := new(ofp10.FlowStatsReply)
+		for _, prio := range []uint16{1, 2, 3} {
+			f := ofp10.NewFlowStats()
+			f.Priority = prio
+			f.Actions = []ofp10.Action{ofp10.NewActionOutput(1)}
+			if prio == 2 {
+				// A prefix match has no flow spec.
+				f.Match.Wildcards &^= 0x3f << ofp10.FW_NW_SRC_SHIFT
+				f.Match.Wildcards |= 8 << ofp10.FW_NW_SRC_SHIFT
+			}
+			f.Length = f.Len()
+			body.Flows = append(body.Flows, *f)
+		}
+		rep := &ofp10.StatsReply{Header: ofpxx.NewOfp10Header(), Type: ofp10.StatsType_Flow, Body: body}
+		rep.Header.Type = ofp10.Type_StatsReply
+		rep.Header.Xid = binary.BigEndian.Uint32(req[4:])
+		rep.Header.Length = rep.Len()
+		b, _ := rep.MarshalBinary()
+		conn.Write(b)
+	}()
+	fs, err := sw.DumpFlows(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.Flows) != 2 || fs.Flows[0].Priority != 1 || fs.Flows[1].Priority != 3 {
+		t.Errorf("Got %+v, expected the flows of priority 1 and 3.", fs.Flows)
+	}
+}
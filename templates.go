@@ -0,0 +1,356 @@
+package ogo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The cookie of the flows of a template group is TemplateCookie
+// with the id of the group in the low 32 bits.
+var TemplateCookie uint64 = 0x73 << 56
+
+const templateCookieMask = 0xffffffff00000000
+
+// The placeholders of template rules: a parameter name in braces.
+var templateParam = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+
+// A FlowTemplate is a list of policy rules with named parameters,
+// written {name} in the switch, match values and action of the
+// rules. Instantiating it with a value for every parameter gives
+// flows. In a text policy, each line
+//
+//	template access-port port vlan rule 100 match in_port={port} action output 1
+//
+// adds a rule to the template, after its name and parameters.
+type FlowTemplate struct {
+	Name   string       `json:"name"`
+	Params []string     `json:"params"`
+	Rules  []PolicyRule `json:"rules"`
+	// The policy the template was defined in, whose sets the
+	// rules can refer to.
+	policy *Policy
+}
+
+// Returns an error if a rule of t uses a parameter t doesn't
+// have.
+func (t *FlowTemplate) check() error {
+	params := make(map[string]bool)
+	for _, p := range t.Params {
+		params[p] = true
+	}
+	for _, r := range t.Rules {
+		texts := []string{r.Switch, r.Action}
+		for _, v := range r.Match {
+			texts = append(texts, v)
+		}
+		for _, text := range texts {
+			for _, m := range templateParam.FindAllStringSubmatch(text, -1) {
+				if !params[m[1]] {
+					return fmt.Errorf("template %s has no parameter %s", t.Name, m[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the flows of t with the parameters set to params.
+func (t *FlowTemplate) Instantiate(params map[string]string) ([]PolicyFlow, error) {
+	for _, p := range t.Params {
+		if _, ok := params[p]; !ok {
+			return nil, fmt.Errorf("Template %s needs parameter %s.", t.Name, p)
+		}
+	}
+	subst := func(s string) string {
+		return templateParam.ReplaceAllStringFunc(s, func(m string) string {
+			return params[m[1:len(m)-1]]
+		})
+	}
+	p := t.policy
+	if p == nil {
+		p = new(Policy)
+	}
+	flows := make([]PolicyFlow, 0)
+	for i, r := range t.Rules {
+		rule := PolicyRule{Priority: r.Priority, Switch: subst(r.Switch), Action: subst(r.Action),
+			Match: make(map[string]string), Line: r.Line}
+		for k, v := range r.Match {
+			rule.Match[k] = subst(v)
+		}
+		fs, err := p.compileRule(rule)
+		if err != nil {
+			return nil, &PolicyError{Line: r.Line, Rule: i + 1, Msg: err.Error()}
+		}
+		flows = append(flows, fs...)
+	}
+	return flows, nil
+}
+
+// Returns the values listed in s, separated by commas, with
+// ranges of numbers like 1-24 expanded.
+func ExpandValues(s string) ([]string, error) {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		a := strings.SplitN(v, "-", 2)
+		if len(a) == 1 {
+			values = append(values, v)
+			continue
+		}
+		from, err := strconv.ParseUint(a[0], 10, 32)
+		to, err2 := strconv.ParseUint(a[1], 10, 32)
+		if err != nil || err2 != nil || from > to {
+			return nil, fmt.Errorf("Bad range %q.", v)
+		}
+		for n := from; n <= to; n++ {
+			values = append(values, strconv.FormatUint(n, 10))
+		}
+	}
+	return values, nil
+}
+
+// The flows of a template instantiated once for each of Values of
+// parameter Param, with the other parameters set to Fixed, on
+// Switch DPID. They are installed with the cookie of the group,
+// and removed together.
+type TemplateGroup struct {
+	Id       int               `json:"id"`
+	Template string            `json:"template"`
+	DPID     string            `json:"dpid"`
+	Param    string            `json:"param"`
+	Values   []string          `json:"values"`
+	Fixed    map[string]string `json:"fixed,omitempty"`
+	Flows    int               `json:"flows"`
+	recipes  []*Recipe
+}
+
+// Templates keeps flow templates and the groups of flows applied
+// from them. Switches get the flows of their groups again
+// whenever they connect.
+//
+// It serves an HTTP API: GET lists the groups, POST applies a
+// template with the template, dpid, param and values parameters,
+// any other parameter setting a fixed one, and replies with the
+// group, and DELETE with an id parameter removes a group.
+//
+//	POST /templates?template=access-port&dpid=00:00:00:00:00:00:00:01&param=port&values=1-24&vlan=10
+type Templates struct {
+	mu        sync.Mutex
+	templates map[string]*FlowTemplate
+	groups    map[int]*TemplateGroup
+	nextId    int
+	sub       *Subscription
+}
+
+func NewTemplates() *Templates {
+	t := new(Templates)
+	t.templates = make(map[string]*FlowTemplate)
+	t.groups = make(map[int]*TemplateGroup)
+	t.nextId = 1
+	return t
+}
+
+func (t *Templates) Start() {
+	RegisterFlowOwner("templates", TemplateCookie, templateCookieMask)
+	t.sub = Subscribe(64, EventSwitchUp)
+	go func() {
+		for e := range t.sub.C {
+			if sw, ok := Switch(e.DPID); ok {
+				t.installAll(sw)
+			}
+		}
+	}()
+}
+
+func (t *Templates) Stop() {
+	if t.sub != nil {
+		t.sub.Cancel()
+	}
+}
+
+// Adds tmpl, replacing the template of the same name. Groups
+// applied from the old template keep their flows.
+func (t *Templates) Define(tmpl *FlowTemplate) error {
+	if err := tmpl.check(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.templates[tmpl.Name] = tmpl
+	t.mu.Unlock()
+	return nil
+}
+
+// Defines the templates of policy p.
+func (t *Templates) Load(p *Policy) error {
+	for _, tmpl := range p.Templates {
+		if err := t.Define(tmpl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Templates) Template(name string) (*FlowTemplate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tmpl, ok := t.templates[name]
+	return tmpl, ok
+}
+
+// Instantiates template name once for each of values of param,
+// with the other parameters set to fixed, and installs the flows
+// on Switch dpid as a new group. Rules of the template naming
+// another switch are ignored. Nothing is installed if an
+// instance is invalid.
+//
+//	t.Apply("access-port", dpid, "port", ports, map[string]string{"vlan": "10"})
+func (t *Templates) Apply(name string, dpid net.HardwareAddr, param string, values []string, fixed map[string]string) (TemplateGroup, error) {
+	tmpl, ok := t.Template(name)
+	if !ok {
+		return TemplateGroup{}, fmt.Errorf("No template %s.", name)
+	}
+	g := &TemplateGroup{Template: name, DPID: dpid.String(), Param: param, Values: values, Fixed: fixed}
+	params := make(map[string]string)
+	for k, v := range fixed {
+		params[k] = v
+	}
+	for _, v := range values {
+		params[param] = v
+		flows, err := tmpl.Instantiate(params)
+		if err != nil {
+			return TemplateGroup{}, fmt.Errorf("%s=%s: %v", param, v, err)
+		}
+		for _, f := range flows {
+			if f.DPID == nil || f.DPID.String() == g.DPID {
+				g.recipes = append(g.recipes, &Recipe{Priority: f.Priority, Match: f.Match, Outputs: f.Ports})
+			}
+		}
+	}
+	g.Flows = len(g.recipes)
+
+	t.mu.Lock()
+	g.Id = t.nextId
+	t.nextId += 1
+	for _, r := range g.recipes {
+		r.Cookie = TemplateCookie | uint64(g.Id)
+	}
+	t.groups[g.Id] = g
+	t.mu.Unlock()
+	if sw, ok := Switch(dpid); ok {
+		t.install(sw, g, false)
+	}
+	return *g, nil
+}
+
+// Removes group id and its flows.
+func (t *Templates) Remove(id int) error {
+	t.mu.Lock()
+	g, ok := t.groups[id]
+	delete(t.groups, id)
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("No template group %d.", id)
+	}
+	if dpid, err := net.ParseMAC(g.DPID); err == nil {
+		if sw, ok := Switch(dpid); ok {
+			t.install(sw, g, true)
+		}
+	}
+	return nil
+}
+
+// Returns the groups in the order they were applied.
+func (t *Templates) Groups() []TemplateGroup {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]int, 0, len(t.groups))
+	for id := range t.groups {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	groups := make([]TemplateGroup, len(ids))
+	for i, id := range ids {
+		groups[i] = *t.groups[id]
+	}
+	return groups
+}
+
+func (t *Templates) installAll(sw *OFSwitch) {
+	for _, g := range t.Groups() {
+		if g.DPID == sw.DPID().String() {
+			t.install(sw, &g, false)
+		}
+	}
+}
+
+// Installs the flows of g on Switch sw, or removes them.
+func (t *Templates) install(sw *OFSwitch, g *TemplateGroup, remove bool) {
+	for _, r := range g.recipes {
+		var err error
+		if remove {
+			err = sw.RemoveRecipe(r)
+		} else {
+			err = sw.InstallRecipe(r)
+		}
+		if err != nil {
+			log.Println("Failed to update template flow on", SwitchLabel(sw.DPID()), err)
+			return
+		}
+	}
+}
+
+func (t *Templates) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Groups())
+	case "POST":
+		dpid, err := net.ParseMAC(q.Get("dpid"))
+		if err != nil {
+			http.Error(w, "bad dpid", http.StatusBadRequest)
+			return
+		}
+		values, err := ExpandValues(q.Get("values"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fixed := make(map[string]string)
+		for k := range q {
+			switch k {
+			case "template", "dpid", "param", "values":
+			default:
+				fixed[k] = q.Get(k)
+			}
+		}
+		g, err := t.Apply(q.Get("template"), dpid, q.Get("param"), values, fixed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g)
+	case "DELETE":
+		id, err := strconv.Atoi(q.Get("id"))
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+		if err := t.Remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
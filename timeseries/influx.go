@@ -0,0 +1,56 @@
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writes samples to InfluxDB using the line protocol.
+type InfluxSink struct {
+	// The write endpoint including the database, for example
+	// http://localhost:8086/write?db=ogo.
+	URL    string
+	Client *http.Client
+}
+
+func NewInfluxSink(url string) *InfluxSink {
+	return &InfluxSink{url, http.DefaultClient}
+}
+
+func (s *InfluxSink) Write(samples []Sample) error {
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		buf.WriteString(influxLine(sample))
+		buf.WriteByte('\n')
+	}
+	resp, err := s.Client.Post(s.URL, "text/plain", &buf)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB write failed: %s", resp.Status)
+	}
+	return nil
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// Returns s in line protocol, with labels as tags.
+func influxLine(s Sample) string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	line := influxEscaper.Replace(s.Metric)
+	for _, k := range keys {
+		line += "," + influxEscaper.Replace(k) + "=" + influxEscaper.Replace(s.Labels[k])
+	}
+	return line + " value=" + strconv.FormatFloat(s.Value, 'g', -1, 64) +
+		" " + strconv.FormatInt(s.Time.UnixNano(), 10)
+}
@@ -0,0 +1,162 @@
+package timeseries
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jonstout/ogo"
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// How long to wait for each statistics reply.
+var RequestTimeout = time.Second * 2
+
+// A Recorder periodically polls the statistics of every switch
+// and writes them to its sinks. Only OpenFlow 1.0 switches are
+// polled, but message counts are recorded for every switch.
+//
+// Metrics recorded, labelled with the switch "dpid":
+//
+//	ogo_port_{rx,tx}_{packets,bytes,dropped,errors}, also labelled with "port"
+//	ogo_flow_{count,packets,bytes}, totals over all flows
+//	ogo_table_{active,lookups,matched}, also labelled with "table"
+//	ogo_messages_{sent,received}, also labelled with message "type"
+type Recorder struct {
+	Interval time.Duration
+	sinks    []Sink
+	stop     chan bool
+}
+
+func NewRecorder(interval time.Duration, sinks ...Sink) *Recorder {
+	r := new(Recorder)
+	r.Interval = interval
+	r.sinks = sinks
+	r.stop = make(chan bool, 1)
+	return r
+}
+
+func (r *Recorder) Start() {
+	go func() {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.Record()
+			}
+		}
+	}()
+}
+
+func (r *Recorder) Stop() {
+	select {
+	case r.stop <- true:
+	default:
+	}
+}
+
+// Polls every switch once and writes the samples to each sink.
+func (r *Recorder) Record() {
+	samples := make([]Sample, 0)
+	for _, sw := range ogo.Switches() {
+		samples = append(samples, messageSamples(sw)...)
+		if sw.Version() != ofp10.VERSION {
+			continue
+		}
+		samples = append(samples, portSamples(sw)...)
+		samples = append(samples, flowSamples(sw)...)
+		samples = append(samples, tableSamples(sw)...)
+	}
+	for _, s := range r.sinks {
+		if err := s.Write(samples); err != nil {
+			log.Println("Failed to write statistics:", err)
+		}
+	}
+}
+
+// Sends a stats request of type t to sw and returns the body of
+// the reply.
+func stats(sw *ogo.OFSwitch, t uint16, body util.Message) util.Message {
+	rep, err := sw.SendAndReceive(ofp10.NewStatsRequest(t, body), RequestTimeout)
+	if err != nil {
+		return nil
+	}
+	if r, ok := rep.(*ofp10.StatsReply); ok {
+		return r.Body
+	}
+	return nil
+}
+
+func portSamples(sw *ogo.OFSwitch) []Sample {
+	a := make([]Sample, 0)
+	now := time.Now()
+	for _, port := range sw.Ports() {
+		req := ofp10.NewPortStatsRequest()
+		req.PortNo = port.PortNo
+		s, ok := stats(sw, ofp10.StatsType_Port, req).(*ofp10.PortStats)
+		if !ok {
+			continue
+		}
+		labels := map[string]string{"dpid": sw.DPID().String(), "port": fmt.Sprint(port.PortNo)}
+		values := map[string]uint64{
+			"ogo_port_rx_packets": s.RxPackets,
+			"ogo_port_tx_packets": s.TxPackets,
+			"ogo_port_rx_bytes":   s.RxBytes,
+			"ogo_port_tx_bytes":   s.TxBytes,
+			"ogo_port_rx_dropped": s.RxDropped,
+			"ogo_port_tx_dropped": s.TxDropped,
+			"ogo_port_rx_errors":  s.RxErrors,
+			"ogo_port_tx_errors":  s.TxErrors,
+		}
+		for m, v := range values {
+			a = append(a, Sample{m, labels, now, float64(v)})
+		}
+	}
+	return a
+}
+
+func flowSamples(sw *ogo.OFSwitch) []Sample {
+	req := ofp10.NewAggregateStatsRequest()
+	req.TableId = 0xff
+	req.OutPort = ofp10.P_NONE
+	s, ok := stats(sw, ofp10.StatsType_Aggregate, req).(*ofp10.AggregateStats)
+	if !ok {
+		return nil
+	}
+	labels := map[string]string{"dpid": sw.DPID().String()}
+	now := time.Now()
+	return []Sample{
+		{"ogo_flow_count", labels, now, float64(s.FlowCount)},
+		{"ogo_flow_packets", labels, now, float64(s.PacketCount)},
+		{"ogo_flow_bytes", labels, now, float64(s.ByteCount)},
+	}
+}
+
+func tableSamples(sw *ogo.OFSwitch) []Sample {
+	s, ok := stats(sw, ofp10.StatsType_Table, nil).(*ofp10.TableStats)
+	if !ok {
+		return nil
+	}
+	labels := map[string]string{"dpid": sw.DPID().String(), "table": fmt.Sprint(s.TableId)}
+	now := time.Now()
+	return []Sample{
+		{"ogo_table_active", labels, now, float64(s.ActiveCount)},
+		{"ogo_table_lookups", labels, now, float64(s.LookupCount)},
+		{"ogo_table_matched", labels, now, float64(s.MatchedCount)},
+	}
+}
+
+func messageSamples(sw *ogo.OFSwitch) []Sample {
+	a := make([]Sample, 0)
+	now := time.Now()
+	for _, m := range sw.MessageStats() {
+		labels := map[string]string{"dpid": sw.DPID().String(), "type": m.Type}
+		a = append(a, Sample{"ogo_messages_sent", labels, now, float64(m.Sent)},
+			Sample{"ogo_messages_received", labels, now, float64(m.Received)})
+	}
+	return a
+}
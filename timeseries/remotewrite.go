@@ -0,0 +1,120 @@
+package timeseries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// Writes samples to a Prometheus remote write endpoint.
+type RemoteWriteSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewRemoteWriteSink(url string) *RemoteWriteSink {
+	return &RemoteWriteSink{url, http.DefaultClient}
+}
+
+func (s *RemoteWriteSink) Write(samples []Sample) error {
+	body := snappyEncode(writeRequest(samples))
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Remote write failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Encodes samples as a prometheus.WriteRequest protobuf, with a
+// TimeSeries per distinct metric and labels.
+func writeRequest(samples []Sample) []byte {
+	series := make(map[string][]Sample)
+	keys := make([]string, 0)
+	for _, s := range samples {
+		k := s.series()
+		if _, ok := series[k]; !ok {
+			keys = append(keys, k)
+		}
+		series[k] = append(series[k], s)
+	}
+
+	req := make([]byte, 0)
+	for _, k := range keys {
+		ts := make([]byte, 0)
+		first := series[k][0]
+		labels := map[string]string{"__name__": first.Metric}
+		for n, v := range first.Labels {
+			labels[n] = v
+		}
+		names := make([]string, 0, len(labels))
+		for n := range labels {
+			names = append(names, n)
+		}
+		// Labels must be sorted by name.
+		sort.Strings(names)
+		for _, n := range names {
+			l := protoBytes(nil, 1, []byte(n))
+			l = protoBytes(l, 2, []byte(labels[n]))
+			ts = protoBytes(ts, 1, l)
+		}
+		for _, s := range series[k] {
+			sample := make([]byte, 9)
+			sample[0] = 1<<3 | 1 // Field 1, 64-bit
+			binary.LittleEndian.PutUint64(sample[1:], math.Float64bits(s.Value))
+			sample = append(sample, 2<<3) // Field 2, varint
+			sample = appendUvarint(sample, uint64(s.Time.UnixNano()/1e6))
+			ts = protoBytes(ts, 2, sample)
+		}
+		req = protoBytes(req, 1, ts)
+	}
+	return req
+}
+
+// Appends a length delimited protobuf field to b.
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = append(b, byte(field<<3|2))
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// Returns data in the snappy block format. Data is stored as
+// literals without compression, which every decoder accepts.
+func snappyEncode(data []byte) []byte {
+	b := appendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 65536 {
+			n = 65536
+		}
+		if n <= 60 {
+			b = append(b, byte(n-1)<<2)
+		} else {
+			// Tag 61: the length minus one follows in two
+			// little endian bytes.
+			b = append(b, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,98 @@
+// Package timeseries records the history of switch statistics.
+// A Recorder polls port, flow and table counters and writes them
+// to one or more Sinks: an in-memory Ring that can be queried to
+// chart recent utilization, or adapters for InfluxDB and
+// Prometheus remote write.
+package timeseries
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single measurement of Metric for the series identified by
+// Labels, for example the received bytes of one port.
+type Sample struct {
+	Metric string
+	Labels map[string]string
+	Time   time.Time
+	Value  float64
+}
+
+// Returns a key identifying the series s belongs to.
+func (s Sample) series() string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{s.Metric}
+	for _, k := range keys {
+		parts = append(parts, k+"="+s.Labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+// A Sink stores samples.
+type Sink interface {
+	Write(samples []Sample) error
+}
+
+// A Ring keeps the most recent samples in memory, discarding the
+// oldest once it is full.
+type Ring struct {
+	mu   sync.RWMutex
+	buf  []Sample
+	next int
+	full bool
+}
+
+func NewRing(size int) *Ring {
+	r := new(Ring)
+	r.buf = make([]Sample, size)
+	return r
+}
+
+func (r *Ring) Write(samples []Sample) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range samples {
+		r.buf[r.next] = s
+		r.next = (r.next + 1) % len(r.buf)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+	return nil
+}
+
+// Returns the samples of metric taken after since, oldest first.
+// Only samples having every label in labels are returned.
+func (r *Ring) Query(metric string, labels map[string]string, since time.Time) []Sample {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	a := make([]Sample, 0)
+	start, n := 0, r.next
+	if r.full {
+		start, n = r.next, len(r.buf)
+	}
+	for i := 0; i < n; i++ {
+		s := r.buf[(start+i)%len(r.buf)]
+		if s.Metric != metric || !s.Time.After(since) {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if s.Labels[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			a = append(a, s)
+		}
+	}
+	return a
+}
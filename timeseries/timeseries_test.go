@@ -0,0 +1,44 @@
+package timeseries
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestRingQuery(t *testing.T) {
+	r := NewRing(3)
+	start := time.Unix(100, 0)
+	for i := 0; i < 5; i++ {
+		port := "1"
+		if i%2 == 1 {
+			port = "2"
+		}
+		r.Write([]Sample{{"rx", map[string]string{"port": port}, start.Add(time.Duration(i) * time.Second), float64(i)}})
+	}
+	// Only the last three samples are kept.
+	a := r.Query("rx", map[string]string{"port": "1"}, start)
+	if len(a) != 2 || a[0].Value != 2 || a[1].Value != 4 {
+		t.Errorf("Got samples %v, expected values 2 and 4.", a)
+	}
+}
+
+func TestInfluxLine(t *testing.T) {
+	s := Sample{"ogo_port_rx_bytes", map[string]string{"port": "1", "dpid": "00:01"}, time.Unix(1, 5), 42}
+	exp := "ogo_port_rx_bytes,dpid=00:01,port=1 value=42 1000000005"
+	if l := influxLine(s); l != exp {
+		t.Errorf("Got line %q, expected %q.", l, exp)
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	s := Sample{"up", nil, time.Unix(1, 0), 1}
+	exp := "0a1e" + // TimeSeries
+		"0a0e" + "0a085f5f6e616d655f5f" + "1202" + "7570" + // __name__="up"
+		"120c" + "09000000000000f03f" + "10e807" // Value 1, timestamp 1000
+	if d := hex.EncodeToString(writeRequest([]Sample{s})); d != exp {
+		t.Log("Exp:", exp)
+		t.Log("Rec:", d)
+		t.Error("WriteRequest encoded incorrectly.")
+	}
+}
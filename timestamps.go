@@ -0,0 +1,149 @@
+package ogo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// The monotonic clock of Timestamps starts when the controller
+// does.
+var processStart = time.Now()
+
+// When a message was received or an event happened. Wall is the
+// wall clock time, for correlating with logs and other systems;
+// Mono the monotonic time since the controller started, for
+// measuring intervals that clock adjustments must not distort.
+type Timestamp struct {
+	Wall time.Time     `json:"wall"`
+	Mono time.Duration `json:"mono_ns"`
+}
+
+func stampNow() Timestamp {
+	now := time.Now()
+	return Timestamp{now, now.Sub(processStart)}
+}
+
+// Returns the time from u to t on the monotonic clock.
+func (t Timestamp) Sub(u Timestamp) time.Duration {
+	return t.Mono - u.Mono
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.Wall.IsZero()
+}
+
+// The receive stamps of the messages being handled, by message.
+var messageStamps = struct {
+	sync.RWMutex
+	m map[util.Message]Timestamp
+}{m: make(map[util.Message]Timestamp)}
+
+// Returns when msg, a message being handled by the controller,
+// was read from its connection: when the read returning its first
+// byte completed. Applications can call it for the messages
+// they're handed, until they return.
+func MessageTime(msg util.Message) (Timestamp, bool) {
+	messageStamps.RLock()
+	defer messageStamps.RUnlock()
+	t, ok := messageStamps.m[msg]
+	return t, ok
+}
+
+func setMessageTime(msg util.Message, t Timestamp) {
+	if t.IsZero() {
+		return
+	}
+	messageStamps.Lock()
+	messageStamps.m[msg] = t
+	messageStamps.Unlock()
+}
+
+func clearMessageTime(msg util.Message) {
+	messageStamps.Lock()
+	delete(messageStamps.m, msg)
+	messageStamps.Unlock()
+}
+
+// The timing of a switch's connection, from the round trips of
+// echo requests. Switches don't tell the controller their time,
+// so the skew of a message's receive stamp from when the switch
+// sent it is estimated as half the smallest round trip, the
+// latency of the connection without queueing.
+type ClockEstimate struct {
+	// The last and smallest round trips.
+	RTT    time.Duration `json:"rtt_ns"`
+	MinRTT time.Duration `json:"min_rtt_ns"`
+	// Echo replies measured.
+	Samples uint64    `json:"samples"`
+	Last    Timestamp `json:"last"`
+}
+
+// Returns the estimated time between the switch sending a message
+// and the controller stamping it.
+func (c ClockEstimate) Skew() time.Duration {
+	return c.MinRTT / 2
+}
+
+// Returns when a message received at t was estimated to be sent.
+func (c ClockEstimate) Sent(t Timestamp) Timestamp {
+	d := c.Skew()
+	return Timestamp{t.Wall.Add(-d), t.Mono - d}
+}
+
+// Echo requests in flight and the resulting estimate of a
+// MessageStream.
+type echoClock struct {
+	mu       sync.Mutex
+	sent     map[uint32]Timestamp
+	estimate ClockEstimate
+}
+
+// The most echo requests tracked per connection; unanswered ones
+// are forgotten beyond it.
+const maxEchoesInFlight = 16
+
+func (c *echoClock) requestSent(xid uint32, t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sent == nil {
+		c.sent = make(map[uint32]Timestamp)
+	}
+	if len(c.sent) >= maxEchoesInFlight {
+		for x := range c.sent {
+			delete(c.sent, x)
+		}
+	}
+	c.sent[xid] = t
+}
+
+func (c *echoClock) replyReceived(xid uint32, t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent, ok := c.sent[xid]
+	if !ok {
+		return
+	}
+	delete(c.sent, xid)
+	rtt := t.Sub(sent)
+	if rtt < 0 {
+		return
+	}
+	e := &c.estimate
+	e.RTT, e.Last = rtt, t
+	if e.Samples == 0 || rtt < e.MinRTT {
+		e.MinRTT = rtt
+	}
+	e.Samples += 1
+}
+
+// Returns the clock estimate of the main connection of Switch s,
+// measured from the echo requests sent to it.
+func (s *OFSwitch) Clock() ClockEstimate {
+	stream, _ := s.session()
+	c := stream.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.estimate
+}
@@ -0,0 +1,303 @@
+package ogo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// A snapshot of the network: every connected switch, the links
+// discovered between them and the hosts attached to them.
+type Topology struct {
+	Switches []TopologySwitch `json:"switches"`
+	Links    []TopologyLink   `json:"links"`
+	Hosts    []TopologyHost   `json:"hosts"`
+}
+
+type TopologySwitch struct {
+	DPID        string             `json:"dpid"`
+	Name        string             `json:"name,omitempty"`
+	Description *SwitchDescription `json:"description,omitempty"`
+	// True if the switch is drained for maintenance, see
+	// DrainSwitch.
+	Drained bool `json:"drained,omitempty"`
+}
+
+// A unidirectional link from Src out SrcPort to Dst.
+type TopologyLink struct {
+	Src     string `json:"src"`
+	SrcPort uint16 `json:"src_port"`
+	Dst     string `json:"dst"`
+	Latency int64  `json:"latency_ns"`
+	// True if the link crosses a legacy L2 cloud, see
+	// BDDPEthertype.
+	Indirect bool `json:"indirect,omitempty"`
+	// The interface type of SrcPort if it is an Open vSwitch
+	// tunnel or patch port, see OVSPortTracker.
+	Kind string `json:"kind,omitempty"`
+	// True if the port or the switch at either end of the link
+	// is drained. Paths don't cross drained links.
+	Drained bool `json:"drained,omitempty"`
+	// The speed of the slower port of the link and the traffic
+	// sent out SrcPort, in bits per second, if known. See
+	// PortLoads.
+	Capacity uint64 `json:"capacity_bps,omitempty"`
+	Load     uint64 `json:"load_bps,omitempty"`
+}
+
+// Returns the capacity of l not in use, 0 if the capacity is
+// unknown.
+func (l TopologyLink) Available() uint64 {
+	if l.Load >= l.Capacity {
+		return 0
+	}
+	return l.Capacity - l.Load
+}
+
+type TopologyHost struct {
+	MAC  string `json:"mac"`
+	DPID string `json:"dpid"`
+	Port uint16 `json:"port"`
+}
+
+// Returns a snapshot of the current network topology. Switches
+// whose connection is down, kept while they may reconnect, are
+// left out with their links and hosts, so paths never cross them.
+func CurrentTopology() *Topology {
+	topology.RLock()
+	defer topology.RUnlock()
+	t := new(Topology)
+	t.Switches = make([]TopologySwitch, 0)
+	t.Links = make([]TopologyLink, 0)
+	t.Hosts = make([]TopologyHost, 0)
+	for _, sw := range Switches() {
+		t.Switches = append(t.Switches, TopologySwitch{DPID: sw.DPID().String(), Name: SwitchName(sw.DPID()),
+			Description: sw.Description()})
+		for _, l := range sw.Links() {
+			t.Links = append(t.Links, TopologyLink{Src: sw.DPID().String(), SrcPort: l.Port,
+				Dst: l.DPID.String(), Latency: int64(l.Latency), Indirect: l.Indirect,
+				Kind: virtualPortType(sw.DPID(), l.Port)})
+		}
+	}
+	if hostTracker != nil {
+		for _, h := range hostTracker.Hosts() {
+			t.AddHost(h.MAC, h.DPID, h.Port)
+		}
+	}
+	t.dropDisconnected(func(dpid string) bool {
+		sw, ok := switchByString(dpid)
+		return ok && sw.connected()
+	})
+	t.markDrained(switchDrained, portDrained)
+	t.setCapacities(func(dpid string, port uint16) uint64 {
+		if sw, ok := switchByString(dpid); ok {
+			if p, ok := sw.Port(port); ok {
+				return p.CurrentSpeed()
+			}
+		}
+		return 0
+	})
+	sort.Sort(topologySwitches(t.Switches))
+	sort.Sort(topologyLinks(t.Links))
+	sort.Sort(topologyHosts(t.Hosts))
+	return t
+}
+
+// Removes the switches connected returns false for from t, along
+// with the links from and to them and the hosts attached to them.
+func (t *Topology) dropDisconnected(connected func(dpid string) bool) {
+	switches := make([]TopologySwitch, 0, len(t.Switches))
+	for _, s := range t.Switches {
+		if connected(s.DPID) {
+			switches = append(switches, s)
+		}
+	}
+	links := make([]TopologyLink, 0, len(t.Links))
+	for _, l := range t.Links {
+		if connected(l.Src) && connected(l.Dst) {
+			links = append(links, l)
+		}
+	}
+	hosts := make([]TopologyHost, 0, len(t.Hosts))
+	for _, h := range t.Hosts {
+		if connected(h.DPID) {
+			hosts = append(hosts, h)
+		}
+	}
+	t.Switches, t.Links, t.Hosts = switches, links, hosts
+}
+
+// Adds a host attached to port of Switch dpid.
+func (t *Topology) AddHost(mac net.HardwareAddr, dpid net.HardwareAddr, port uint16) {
+	t.Hosts = append(t.Hosts, TopologyHost{mac.String(), dpid.String(), port})
+}
+
+// Writes t as JSON.
+func (t *Topology) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(t)
+}
+
+// Writes t as a node-link JSON graph, the format used by d3 and
+// most graph visualization libraries.
+func (t *Topology) WriteNodeLink(w io.Writer) error {
+	type node struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+	}
+	type link struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Port   uint16 `json:"port"`
+	}
+	g := struct {
+		Nodes []node `json:"nodes"`
+		Links []link `json:"links"`
+	}{make([]node, 0), make([]link, 0)}
+
+	for _, s := range t.Switches {
+		g.Nodes = append(g.Nodes, node{s.DPID, "switch"})
+	}
+	for _, h := range t.Hosts {
+		g.Nodes = append(g.Nodes, node{h.MAC, "host"})
+		g.Links = append(g.Links, link{h.DPID, h.MAC, h.Port})
+	}
+	for _, l := range t.Links {
+		g.Links = append(g.Links, link{l.Src, l.Dst, l.SrcPort})
+	}
+	return json.NewEncoder(w).Encode(g)
+}
+
+// Writes t as a Graphviz DOT digraph. Indirect links are dashed.
+func (t *Topology) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph ogo {"); err != nil {
+		return err
+	}
+	for _, s := range t.Switches {
+		if s.Name != "" {
+			fmt.Fprintf(w, "\t%q [shape=box, label=%q];\n", s.DPID, s.Name)
+		} else {
+			fmt.Fprintf(w, "\t%q [shape=box];\n", s.DPID)
+		}
+	}
+	for _, h := range t.Hosts {
+		fmt.Fprintf(w, "\t%q [shape=ellipse];\n", h.MAC)
+		fmt.Fprintf(w, "\t%q -> %q [label=\"%d\"];\n", h.DPID, h.MAC, h.Port)
+	}
+	for _, l := range t.Links {
+		style := ""
+		if l.Indirect {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "\t%q -> %q [label=\"%d\"%s];\n", l.Src, l.Dst, l.SrcPort, style)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// Writes t as a directed GraphML graph. Nodes have a "type" of
+// switch or host and edges carry the source "port".
+func (t *Topology) WriteGraphML(w io.Writer) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		Id   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type key struct {
+		Id   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	type graph struct {
+		Id          string `xml:"id,attr"`
+		EdgeDefault string `xml:"edgedefault,attr"`
+		Nodes       []node `xml:"node"`
+		Edges       []edge `xml:"edge"`
+	}
+	type graphml struct {
+		XMLName xml.Name `xml:"graphml"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Keys    []key    `xml:"key"`
+		Graph   graph    `xml:"graph"`
+	}
+
+	g := graphml{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	g.Keys = []key{
+		{"type", "node", "type", "string"},
+		{"port", "edge", "port", "int"},
+	}
+	g.Graph = graph{Id: "ogo", EdgeDefault: "directed"}
+	for _, s := range t.Switches {
+		g.Graph.Nodes = append(g.Graph.Nodes, node{s.DPID, []data{{"type", "switch"}}})
+	}
+	for _, h := range t.Hosts {
+		g.Graph.Nodes = append(g.Graph.Nodes, node{h.MAC, []data{{"type", "host"}}})
+		g.Graph.Edges = append(g.Graph.Edges,
+			edge{h.DPID, h.MAC, []data{{"port", fmt.Sprint(h.Port)}}})
+	}
+	for _, l := range t.Links {
+		g.Graph.Edges = append(g.Graph.Edges,
+			edge{l.Src, l.Dst, []data{{"port", fmt.Sprint(l.SrcPort)}}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Writes t in format, one of "json", "nodelink", "dot" or
+// "graphml".
+func (t *Topology) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return t.WriteJSON(w)
+	case "nodelink":
+		return t.WriteNodeLink(w)
+	case "dot":
+		return t.WriteDOT(w)
+	case "graphml":
+		return t.WriteGraphML(w)
+	}
+	return fmt.Errorf("Unknown topology format %q.", format)
+}
+
+type topologySwitches []TopologySwitch
+
+func (a topologySwitches) Len() int           { return len(a) }
+func (a topologySwitches) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a topologySwitches) Less(i, j int) bool { return a[i].DPID < a[j].DPID }
+
+type topologyLinks []TopologyLink
+
+func (a topologyLinks) Len() int      { return len(a) }
+func (a topologyLinks) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a topologyLinks) Less(i, j int) bool {
+	if a[i].Src != a[j].Src {
+		return a[i].Src < a[j].Src
+	}
+	return a[i].SrcPort < a[j].SrcPort
+}
+
+type topologyHosts []TopologyHost
+
+func (a topologyHosts) Len() int           { return len(a) }
+func (a topologyHosts) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a topologyHosts) Less(i, j int) bool { return a[i].MAC < a[j].MAC }
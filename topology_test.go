@@ -0,0 +1,47 @@
+package ogo
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTopologyConnected(t *testing.T) {
+	a, _ := net.ParseMAC("00:00:00:00:00:00:00:01")
+	b, _ := net.ParseMAC("00:00:00:00:00:00:00:02")
+	c, _ := net.ParseMAC("00:00:00:00:00:00:00:03")
+	host, _ := net.ParseMAC("02:00:00:00:00:01")
+	v := &NetworkSnapshot{
+		Switches: []SwitchView{
+			{DPID: a, Links: []Link{{DPID: b, Port: 1}, {DPID: c, Port: 2}}, Connected: true},
+			{DPID: b, Links: []Link{{DPID: a, Port: 1}}, Connected: true},
+			// c's connection is down, but it still has its
+			// links and host.
+			{DPID: c, Links: []Link{{DPID: a, Port: 1}}},
+		},
+		Hosts:  []Host{{MAC: host, DPID: c, Port: 3}},
+		byDPID: map[string]int{a.String(): 0, b.String(): 1, c.String(): 2},
+	}
+	topo := v.Topology()
+	if len(topo.Switches) != 2 {
+		t.Errorf("Got switches %v, expected a and b.", topo.Switches)
+	}
+	for _, s := range topo.Switches {
+		if s.DPID == c.String() {
+			t.Errorf("Disconnected switch %s is in the topology.", s.DPID)
+		}
+	}
+	if len(topo.Links) != 2 {
+		t.Errorf("Got links %v, expected those between a and b.", topo.Links)
+	}
+	for _, l := range topo.Links {
+		if l.Src == c.String() || l.Dst == c.String() {
+			t.Errorf("Got link %s-%s of a disconnected switch.", l.Src, l.Dst)
+		}
+	}
+	if len(topo.Hosts) != 0 {
+		t.Errorf("Got hosts %v of a disconnected switch.", topo.Hosts)
+	}
+	if p := topo.ShortestPath(a.String(), c.String()); p != nil {
+		t.Errorf("Got path %v to a disconnected switch.", p)
+	}
+}
@@ -0,0 +1,139 @@
+package ogo
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// The most switches a trace follows a probe through.
+var MaxTraceHops = 32
+
+// A switch a traced probe went through. OutPort is 0 if the
+// probe left the network or was dropped there.
+type TraceHop struct {
+	DPID    net.HardwareAddr
+	InPort  uint16
+	OutPort uint16
+}
+
+type Trace struct {
+	Hops []TraceHop
+	// True if the probe came back to a switch it had already
+	// gone through.
+	Loop bool
+}
+
+// Traces the path of a packet with headers h received by Switch
+// src on port inPort. Every other switch gets a temporary flow
+// sending the probe to the controller, above ProbePriority so
+// it overrides the production flows. The probe is injected into
+// src, caught at the next switch and injected there again, with
+// that switch's flow removed, until it no longer arrives
+// anywhere within hopTimeout. The flows are removed when the
+// trace is done.
+//
+// Only OpenFlow 1.0 switches can be traced.
+func (p *Prober) Trace(src net.HardwareAddr, inPort uint16, h ProbeHeaders, hopTimeout time.Duration) (*Trace, error) {
+	first, ok := Switch(src)
+	if !ok {
+		return nil, ErrSwitchDisconnected
+	}
+	if first.Version() != ofp10.VERSION {
+		return nil, errors.New("Traces need OpenFlow 1.0 switches.")
+	}
+	match := FlowMatch{IPSrc: h.IPSrc, IPDst: h.IPDst, IPProto: ProbeProto}
+	priority := ProbePriority + 1
+	sws := make([]*OFSwitch, 0)
+	for _, sw := range Switches() {
+		if sw.Version() == ofp10.VERSION {
+			sws = append(sws, sw)
+		}
+	}
+	defer func() {
+		for _, sw := range sws {
+			if err := sw.installOutputs(match, priority, nil); err != nil {
+				log.Println("Failed to remove trace flow from", SwitchLabel(sw.DPID()), err)
+			}
+		}
+	}()
+	for _, sw := range sws {
+		if sw == first {
+			continue
+		}
+		if err := sw.installOutputs(match, priority, []uint16{ofp10.P_CONTROLLER}); err != nil {
+			return nil, err
+		}
+	}
+	for _, sw := range sws {
+		if _, err := sw.SendAndReceive(sw.newBarrierRequest(), hopTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	id, ch := p.register()
+	defer p.unregister(id)
+	t := &Trace{Hops: []TraceHop{{DPID: src, InPort: inPort}}}
+	visited := map[string]bool{src.String(): true}
+	var prev *OFSwitch
+	cur := first
+	for len(t.Hops) < MaxTraceHops {
+		// Only the switch being injected into lets the probe
+		// through.
+		if prev != nil {
+			if err := traceFlows(prev, cur, match, priority, hopTimeout); err != nil {
+				return t, err
+			}
+		}
+		r, err := p.inject(cur, t.Hops[len(t.Hops)-1].InPort, h, id, ch, hopTimeout)
+		if err == ErrProbeLost {
+			return t, nil
+		} else if err != nil {
+			return t, err
+		}
+
+		last := &t.Hops[len(t.Hops)-1]
+		last.OutPort = linkPort(cur, r.DPID)
+		t.Hops = append(t.Hops, TraceHop{DPID: r.DPID, InPort: r.InPort})
+		if visited[r.DPID.String()] {
+			t.Loop = true
+			return t, nil
+		}
+		visited[r.DPID.String()] = true
+		next, ok := Switch(r.DPID)
+		if !ok {
+			return t, ErrSwitchDisconnected
+		}
+		prev, cur = cur, next
+	}
+	return t, nil
+}
+
+// Restores the trace flow on prev and removes the one on cur.
+func traceFlows(prev, cur *OFSwitch, match FlowMatch, priority uint16, timeout time.Duration) error {
+	if err := prev.installOutputs(match, priority, []uint16{ofp10.P_CONTROLLER}); err != nil {
+		return err
+	}
+	if err := cur.installOutputs(match, priority, nil); err != nil {
+		return err
+	}
+	for _, sw := range []*OFSwitch{prev, cur} {
+		if _, err := sw.SendAndReceive(sw.newBarrierRequest(), timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the port of Switch sw linked to Switch dpid, or 0.
+func linkPort(sw *OFSwitch, dpid net.HardwareAddr) uint16 {
+	for _, l := range sw.Links() {
+		if l.DPID.String() == dpid.String() {
+			return l.Port
+		}
+	}
+	return 0
+}
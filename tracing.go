@@ -0,0 +1,245 @@
+package ogo
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// A Span is a timed step of message processing.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// A Tracer starts spans. parent is nil for spans starting a
+// trace. An OpenTelemetry tracer is adapted by starting its span
+// in the context of the parent's span.
+//
+// The controller traces messages through these spans:
+//
+//	ofp.transaction  a SendAndReceive or multipart request, from
+//	                 sending the request to the last reply
+//	ofp.send         queueing a message for a switch, including
+//	                 any pacing delay
+//	ofp.receive      handling a received message, from decoding
+//	                 until every application has handled it; a
+//	                 child of the transaction it replies to
+//	ofp.packetin     the packet-in handler chain
+//	ofp.app          an application handling a message
+//
+// Message spans have the attributes ofp.dpid, ofp.type and
+// ofp.xid, application spans ofp.app.
+type Tracer interface {
+	StartSpan(name string, parent Span) Span
+}
+
+var tracer = struct {
+	sync.RWMutex
+	t Tracer
+}{}
+
+// Set while a tracer is installed, so attributes aren't built
+// for nothing.
+var tracing int32
+
+// Installs t to trace message processing, or stops tracing if t
+// is nil.
+func SetTracer(t Tracer) {
+	tracer.Lock()
+	tracer.t = t
+	tracer.Unlock()
+	if t != nil {
+		atomic.StoreInt32(&tracing, 1)
+	} else {
+		atomic.StoreInt32(&tracing, 0)
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End()                                       {}
+
+func startSpan(name string, parent Span) Span {
+	if atomic.LoadInt32(&tracing) == 0 {
+		return noopSpan{}
+	}
+	tracer.RLock()
+	t := tracer.t
+	tracer.RUnlock()
+	if t == nil {
+		return noopSpan{}
+	}
+	if _, ok := parent.(noopSpan); ok {
+		parent = nil
+	}
+	return t.StartSpan(name, parent)
+}
+
+// Starts a span about msg exchanged with Switch dpid.
+func startMessageSpan(name string, parent Span, dpid net.HardwareAddr, msg util.Message) Span {
+	span := startSpan(name, parent)
+	if _, ok := span.(noopSpan); ok {
+		return span
+	}
+	span.SetAttribute("ofp.dpid", dpid.String())
+	if h, ok := msg.(interface {
+		Header() *ofpxx.Header
+	}); ok {
+		span.SetAttribute("ofp.type", messageTypeName(h.Header().Version, h.Header().Type))
+		span.SetAttribute("ofp.xid", h.Header().Xid)
+	}
+	// The time received messages waited between being read and
+	// being traced.
+	if t, ok := MessageTime(msg); ok {
+		span.SetAttribute("ofp.received", t.Wall.Format(time.RFC3339Nano))
+		span.SetAttribute("ofp.wait_ns", int64(stampNow().Sub(t)))
+	}
+	return span
+}
+
+// Starts the span of a transaction with id x on Switch s.
+// Replies with id x are traced as its children.
+func (s *OFSwitch) startTransaction(x uint32, req util.Message) Span {
+	span := startMessageSpan("ofp.transaction", nil, s.dpid, req)
+	if _, ok := span.(noopSpan); !ok {
+		s.reqsMu.Lock()
+		s.spans[x] = span
+		s.reqsMu.Unlock()
+	}
+	return span
+}
+
+func (s *OFSwitch) endTransaction(x uint32, span Span) {
+	if _, ok := span.(noopSpan); !ok {
+		s.reqsMu.Lock()
+		delete(s.spans, x)
+		s.reqsMu.Unlock()
+	}
+	span.End()
+}
+
+// Returns the span of the transaction msg belongs to, or nil.
+func (s *OFSwitch) transactionSpan(msg util.Message) Span {
+	if atomic.LoadInt32(&tracing) == 0 {
+		return nil
+	}
+	x, ok := xid(msg)
+	if !ok {
+		return nil
+	}
+	s.reqsMu.RLock()
+	defer s.reqsMu.RUnlock()
+	if span, ok := s.spans[x]; ok {
+		return span
+	}
+	return nil
+}
+
+// A finished span recorded by a RecordingTracer.
+type SpanRecord struct {
+	Id         uint64                 `json:"id"`
+	Parent     uint64                 `json:"parent,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	Duration   time.Duration          `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// A RecordingTracer keeps the last size finished spans in
+// memory, for looking at where time goes without an external
+// tracing system. It serves them as JSON, newest first, on
+// /debug/traces of the ops endpoint while installed.
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []SpanRecord
+	next  int
+	full  bool
+	ids   uint64
+}
+
+func NewRecordingTracer(size int) *RecordingTracer {
+	t := new(RecordingTracer)
+	t.spans = make([]SpanRecord, size)
+	return t
+}
+
+type recordedSpan struct {
+	t *RecordingTracer
+	r SpanRecord
+	sync.Mutex
+}
+
+func (t *RecordingTracer) StartSpan(name string, parent Span) Span {
+	s := &recordedSpan{t: t}
+	s.r.Id = atomic.AddUint64(&t.ids, 1)
+	if p, ok := parent.(*recordedSpan); ok {
+		s.r.Parent = p.r.Id
+	}
+	s.r.Name = name
+	s.r.Start = time.Now()
+	return s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.Lock()
+	if s.r.Attributes == nil {
+		s.r.Attributes = make(map[string]interface{})
+	}
+	s.r.Attributes[key] = value
+	s.Unlock()
+}
+
+func (s *recordedSpan) End() {
+	s.Lock()
+	s.r.Duration = time.Since(s.r.Start)
+	r := s.r
+	s.Unlock()
+	t := s.t
+	if len(t.spans) == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.spans[t.next] = r
+	t.next = (t.next + 1) % len(t.spans)
+	t.full = t.full || t.next == 0
+	t.mu.Unlock()
+}
+
+// Returns the recorded spans, newest first.
+func (t *RecordingTracer) Spans() []SpanRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.next
+	if t.full {
+		n = len(t.spans)
+	}
+	a := make([]SpanRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		a = append(a, t.spans[(t.next-i+len(t.spans))%len(t.spans)])
+	}
+	return a
+}
+
+func (t *RecordingTracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Spans())
+}
+
+func serveTraces(w http.ResponseWriter, r *http.Request) {
+	tracer.RLock()
+	h, ok := tracer.t.(http.Handler)
+	tracer.RUnlock()
+	if !ok {
+		http.Error(w, "no recording tracer installed", http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
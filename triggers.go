@@ -0,0 +1,381 @@
+package ogo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A PolicyTrigger applies a template when a host joins or leaves
+// the network, or a link comes up or goes down. In a text policy,
+// it is a line
+//
+//	on host join in group guests at 00:00:00:00:00:00:00:01 apply template guest-acl vlan=20
+//
+// where the event is host join, host leave, link up or link down,
+// and "in group" and "at" are optional. A host is in a group if
+// the hosts set of that name lists its MAC or IP address, or a
+// network containing its IP address, like 10.0.8.0/24.
+//
+// The template is applied on the switch of the event. Parameters
+// not given after the template name take the value of the event
+// variable of the same name: mac, ip, dpid and port for hosts, the
+// switch and port the host is attached to, and dpid, port and peer
+// for links, the switch and port the link was discovered on and
+// the switch at its other end. Given values can refer to the
+// variables too, like port={port}.
+//
+// The template stays applied until the opposite event, a host
+// leaving after it joined or a link coming up after it went down,
+// removes it.
+type PolicyTrigger struct {
+	Event string `json:"event"`
+	// The hosts set a host must be in, any host if empty.
+	Group string `json:"group,omitempty"`
+	// The DPID of the switch the event must be about, any
+	// switch if empty.
+	Switch   string            `json:"switch,omitempty"`
+	Template string            `json:"template"`
+	Args     map[string]string `json:"args,omitempty"`
+	// The line the trigger was parsed from, 0 for JSON.
+	Line int `json:"-"`
+}
+
+// The events of triggers, and the events ending them.
+var triggerOpposites = map[string]string{
+	"host join":  "host leave",
+	"host leave": "host join",
+	"link up":    "link down",
+	"link down":  "link up",
+}
+
+// Parses the fields of a line starting with "on".
+func parseTrigger(f []string) (PolicyTrigger, error) {
+	var t PolicyTrigger
+	if len(f) < 3 {
+		return t, fmt.Errorf("missing event")
+	}
+	t.Event = f[1] + " " + f[2]
+	n := 3
+	for n < len(f) && f[n] != "apply" {
+		switch {
+		case f[n] == "in" && n+2 < len(f) && f[n+1] == "group":
+			t.Group = f[n+2]
+			n += 3
+		case f[n] == "at" && n+1 < len(f):
+			t.Switch = f[n+1]
+			n += 2
+		default:
+			return t, fmt.Errorf("unexpected %q", f[n])
+		}
+	}
+	if n+2 >= len(f) || f[n+1] != "template" {
+		return t, fmt.Errorf("expected apply template <name>")
+	}
+	t.Template = f[n+2]
+	if n+3 < len(f) {
+		t.Args = make(map[string]string)
+	}
+	for _, arg := range f[n+3:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return t, fmt.Errorf("expected param=value, got %q", arg)
+		}
+		t.Args[kv[0]] = kv[1]
+	}
+	return t, nil
+}
+
+func (p *Policy) checkTrigger(t PolicyTrigger) error {
+	if _, ok := triggerOpposites[t.Event]; !ok {
+		return fmt.Errorf("unknown event %q", t.Event)
+	}
+	if t.Group != "" {
+		if !strings.HasPrefix(t.Event, "host ") {
+			return fmt.Errorf("%s events have no group", t.Event)
+		}
+		if _, ok := p.Hosts[t.Group]; !ok {
+			return fmt.Errorf("undefined set %s", t.Group)
+		}
+	}
+	if t.Switch != "" {
+		if _, err := net.ParseMAC(t.Switch); err != nil {
+			return fmt.Errorf("bad switch %q", t.Switch)
+		}
+	}
+	if t.Template == "" {
+		return fmt.Errorf("missing template")
+	}
+	return nil
+}
+
+// Returns true if hosts set name lists mac, ip or a network
+// containing ip.
+func (p *Policy) inHosts(name string, mac net.HardwareAddr, ip net.IP) bool {
+	for _, v := range p.Hosts[name] {
+		if m, err := net.ParseMAC(v); err == nil {
+			if m.String() == mac.String() {
+				return true
+			}
+			continue
+		}
+		if ip == nil {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if a := net.ParseIP(v); a != nil && a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// A TriggerEngine applies the templates of the triggers of a
+// policy as hosts and links come and go. It serves the triggers
+// and the templates they applied as JSON over HTTP.
+//
+//	e := ogo.NewTriggerEngine(templates)
+//	e.Start()
+//	e.Load(policy)
+//
+// A PolicyManager with Triggers set loads every policy it applies
+// into the engine.
+type TriggerEngine struct {
+	Templates *Templates
+
+	mu     sync.Mutex
+	policy *Policy
+	// The templates applied for each host or link, by its
+	// subject.
+	applied map[string][]TriggerApplication
+	fired   uint64
+	failed  uint64
+	sub     *Subscription
+}
+
+// A template applied by a trigger. Subject is the host, by MAC,
+// or the link, by switch and port, the event was about.
+type TriggerApplication struct {
+	Subject  string    `json:"subject"`
+	Event    string    `json:"event"`
+	Template string    `json:"template"`
+	DPID     string    `json:"dpid"`
+	Group    int       `json:"group"`
+	Time     time.Time `json:"time"`
+}
+
+// An event as triggers see it.
+type triggerEvent struct {
+	event   string
+	subject string
+	dpid    net.HardwareAddr
+	mac     net.HardwareAddr
+	ip      net.IP
+	vars    map[string]string
+}
+
+func NewTriggerEngine(templates *Templates) *TriggerEngine {
+	e := new(TriggerEngine)
+	e.Templates = templates
+	e.policy = new(Policy)
+	e.applied = make(map[string][]TriggerApplication)
+	return e
+}
+
+func (e *TriggerEngine) Start() {
+	e.sub = Subscribe(256, EventHostAdded, EventHostMoved, EventHostDown, EventLinkUp, EventLinkDown)
+	go func() {
+		for ev := range e.sub.C {
+			for _, te := range triggerEvents(ev) {
+				e.handle(te)
+			}
+		}
+	}()
+}
+
+func (e *TriggerEngine) Stop() {
+	if e.sub != nil {
+		e.sub.Cancel()
+	}
+}
+
+// Makes the triggers of p the ones evaluated, and defines its
+// templates. Templates already applied stay until the events
+// ending them.
+func (e *TriggerEngine) Load(p *Policy) error {
+	if err := e.Templates.Load(p); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.policy = p
+	e.mu.Unlock()
+	return nil
+}
+
+// Returns the events of the triggers bus event ev stands for. A
+// host moving leaves its old port and joins its new one.
+func triggerEvents(ev Event) []triggerEvent {
+	host := func(event string, h Host, dpid net.HardwareAddr, port uint16) triggerEvent {
+		te := triggerEvent{event: event, subject: "host " + h.MAC.String(), dpid: dpid, mac: h.MAC, ip: h.IP}
+		te.vars = map[string]string{"mac": h.MAC.String(), "dpid": dpid.String(),
+			"port": strconv.Itoa(int(port)), "ip": ""}
+		if h.IP != nil {
+			te.vars["ip"] = h.IP.String()
+		}
+		return te
+	}
+	switch data := ev.Data.(type) {
+	case Host:
+		if ev.Type == EventHostAdded {
+			return []triggerEvent{host("host join", data, data.DPID, data.Port)}
+		}
+		return []triggerEvent{host("host leave", data, data.DPID, data.Port)}
+	case HostMove:
+		return []triggerEvent{host("host leave", data.Host, data.OldDPID, data.OldPort),
+			host("host join", data.Host, data.Host.DPID, data.Host.Port)}
+	case Link:
+		event := "link up"
+		if ev.Type == EventLinkDown {
+			event = "link down"
+		}
+		te := triggerEvent{event: event, subject: fmt.Sprintf("link %s:%d", ev.DPID, data.Port), dpid: ev.DPID}
+		te.vars = map[string]string{"dpid": ev.DPID.String(), "port": strconv.Itoa(int(data.Port)),
+			"peer": data.DPID.String()}
+		return []triggerEvent{te}
+	}
+	return nil
+}
+
+// Removes the templates the opposite event applied for the
+// subject of te, then applies those of the triggers matching te.
+func (e *TriggerEngine) handle(te triggerEvent) {
+	event := te.event
+	e.mu.Lock()
+	p := e.policy
+	var undo []TriggerApplication
+	kept := make([]TriggerApplication, 0)
+	for _, a := range e.applied[te.subject] {
+		if a.Event == triggerOpposites[event] {
+			undo = append(undo, a)
+		} else {
+			kept = append(kept, a)
+		}
+	}
+	e.applied[te.subject] = kept
+	e.mu.Unlock()
+	for _, a := range undo {
+		if err := e.Templates.Remove(a.Group); err != nil {
+			log.Println("Failed to remove template", a.Template, "applied on", a.Subject, err)
+		}
+	}
+
+	for _, t := range p.Triggers {
+		if t.Event != event || !p.triggerMatches(t, te) {
+			continue
+		}
+		g, err := e.apply(t, te)
+		e.mu.Lock()
+		e.fired += 1
+		if err != nil {
+			e.failed += 1
+		} else {
+			e.applied[te.subject] = append(e.applied[te.subject], TriggerApplication{te.subject, event,
+				t.Template, g.DPID, g.Id, time.Now()})
+		}
+		e.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to apply template %s on %s of %s: %v", t.Template, event, te.subject, err)
+		}
+	}
+
+	e.mu.Lock()
+	if len(e.applied[te.subject]) == 0 {
+		delete(e.applied, te.subject)
+	}
+	e.mu.Unlock()
+}
+
+func (p *Policy) triggerMatches(t PolicyTrigger, te triggerEvent) bool {
+	if t.Switch != "" {
+		if dpid, _ := net.ParseMAC(t.Switch); dpid.String() != te.dpid.String() {
+			return false
+		}
+	}
+	return t.Group == "" || p.inHosts(t.Group, te.mac, te.ip)
+}
+
+// Applies the template of t on the switch of te, with the
+// parameters set from its arguments and the variables of te.
+func (e *TriggerEngine) apply(t PolicyTrigger, te triggerEvent) (TemplateGroup, error) {
+	tmpl, ok := e.Templates.Template(t.Template)
+	if !ok {
+		return TemplateGroup{}, fmt.Errorf("No template %s.", t.Template)
+	}
+	params := make(map[string]string)
+	for _, name := range tmpl.Params {
+		v, ok := t.Args[name]
+		if !ok {
+			v, ok = te.vars[name]
+		}
+		if !ok || v == "" {
+			return TemplateGroup{}, fmt.Errorf("no value for parameter %s", name)
+		}
+		params[name] = templateParam.ReplaceAllStringFunc(v, func(m string) string {
+			return te.vars[m[1:len(m)-1]]
+		})
+	}
+	// A group is one template applied once for each value of a
+	// parameter; triggers apply it once, for the first.
+	if len(tmpl.Params) == 0 {
+		return e.Templates.Apply(t.Template, te.dpid, "", []string{""}, params)
+	}
+	first := tmpl.Params[0]
+	value := params[first]
+	delete(params, first)
+	return e.Templates.Apply(t.Template, te.dpid, first, []string{value}, params)
+}
+
+// Returns the templates applied by triggers, by subject.
+func (e *TriggerEngine) Applied() []TriggerApplication {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a := make([]TriggerApplication, 0)
+	for _, apps := range e.applied {
+		a = append(a, apps...)
+	}
+	sort.Sort(applicationsByGroup(a))
+	return a
+}
+
+type applicationsByGroup []TriggerApplication
+
+func (a applicationsByGroup) Len() int           { return len(a) }
+func (a applicationsByGroup) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a applicationsByGroup) Less(i, j int) bool { return a[i].Group < a[j].Group }
+
+func (e *TriggerEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	triggers := e.policy.Triggers
+	fired, failed := e.fired, e.failed
+	e.mu.Unlock()
+	if triggers == nil {
+		triggers = make([]PolicyTrigger, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"triggers": triggers,
+		"applied":  e.Applied(),
+		"fired":    fired,
+		"failed":   failed,
+	})
+}
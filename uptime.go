@@ -0,0 +1,111 @@
+package ogo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp13"
+	"github.com/jonstout/ogo/protocol/ofp14"
+)
+
+// Returns when the main connection of Switch s last came up.
+func (s *OFSwitch) ConnectedAt() time.Time {
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+	return s.upAt
+}
+
+// Returns how long the main connection of Switch s has been up,
+// 0 if it is down.
+func (s *OFSwitch) Uptime() time.Duration {
+	if !s.connected() {
+		return 0
+	}
+	return time.Since(s.ConnectedAt())
+}
+
+// Returns when a flow that had been installed for sec seconds and
+// nsec nanoseconds at time at, as reported in flow statistics or
+// a flow removed message, was installed.
+func FlowInstallTime(at time.Time, sec, nsec uint32) time.Time {
+	return at.Add(-(time.Duration(sec)*time.Second + time.Duration(nsec)))
+}
+
+// How long a flow of a switch has been installed.
+type FlowAge struct {
+	DPID      string        `json:"dpid"`
+	Table     uint8         `json:"table"`
+	Priority  uint16        `json:"priority"`
+	Cookie    uint64        `json:"cookie"`
+	Owner     string        `json:"owner,omitempty"`
+	Installed time.Time     `json:"installed"`
+	Age       time.Duration `json:"age_ns"`
+}
+
+func newFlowAge(dpid string, table uint8, priority uint16, cookie uint64, installed, now time.Time) FlowAge {
+	return FlowAge{dpid, table, priority, cookie, FlowOwner(cookie), installed, now.Sub(installed)}
+}
+
+// Returns how long the flow has been installed, 0 if unknown.
+func (f FlowEntry) Age() time.Duration {
+	if f.Installed.IsZero() {
+		return 0
+	}
+	return time.Since(f.Installed)
+}
+
+// Reads the durations of every flow of Switch s and converts them
+// to install times on the controller's clock. Durations are
+// counted from when the reply arrived, so install times are late
+// by up to the time the reply took to arrive. The flows of the
+// flow shadow get their install time if they had none.
+func (s *OFSwitch) FlowAges(timeout time.Duration) ([]FlowAge, error) {
+	dpid := s.DPID().String()
+	ages := make([]FlowAge, 0)
+	switch {
+	case s.Version() == ofp10.VERSION:
+		req := ofp10.NewFlowStatsRequest()
+		req.TableId = 0xff // All tables
+		req.OutPort = ofp10.P_NONE
+		reps, err := s.requestStats(ofp10.NewStatsRequest(ofp10.StatsType_Flow, req), timeout)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, rep := range reps {
+			if body, ok := rep.Body.(*ofp10.FlowStatsReply); ok {
+				for _, f := range body.Flows {
+					installed := FlowInstallTime(now, f.DurationSec, f.DurationNSec)
+					ages = append(ages, newFlowAge(dpid, f.TableId, f.Priority, f.Cookie, installed, now))
+				}
+			}
+		}
+	case s.Version() >= ofp13.VERSION:
+		req := s.newMultipartRequest(ofp14.MultipartType_Flow, ofp14.NewFlowStatsRequest())
+		reps, err := s.requestMultipart(req, timeout)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		s.flowsMu.Lock()
+		for _, rep := range reps {
+			body, ok := rep.Body.(*ofp14.FlowStatsReply)
+			if !ok {
+				continue
+			}
+			for i := range body.Flows {
+				f := &body.Flows[i]
+				installed := FlowInstallTime(now, f.DurationSec, f.DurationNSec)
+				ages = append(ages, newFlowAge(dpid, f.TableId, f.Priority, f.Cookie, installed, now))
+				if e, ok := s.flows[flowKey(f.TableId, f.Priority, &f.Match)]; ok && e.Installed.IsZero() {
+					e.Installed = installed
+				}
+			}
+		}
+		s.flowsMu.Unlock()
+	default:
+		return nil, errors.New("Flow statistics aren't supported for this OpenFlow version.")
+	}
+	return ages, nil
+}
@@ -0,0 +1,33 @@
+package ogo
+
+import (
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/ofpxx"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// If set, Send checks every message against the OpenFlow
+// specification before it reaches the wire and refuses those
+// breaking it with a *ofpxx.ValidationError, see Validate. Meant
+// for development and testing: applications building bad messages
+// learn why at once instead of from an error message of the switch
+// arriving later. Checking marshals messages twice.
+var StrictValidation = false
+
+// Checks msg, a message for Switch s, against the specification of
+// the OpenFlow version s uses: its version and length, and for
+// flow mods, group mods and packet-outs the values of their
+// fields, the lengths of their matches, actions and instructions
+// and whether their actions are consistent with their match.
+func (s *OFSwitch) Validate(msg util.Message) error {
+	if h, ok := msg.(interface {
+		Header() *ofpxx.Header
+	}); ok && h.Header().Version != s.Version() {
+		return ofpxx.Invalid("message", "version %#x for a switch using %#x", h.Header().Version, s.Version())
+	}
+	if s.Version() == ofp10.VERSION {
+		return ofp10.Validate(msg)
+	}
+	return ofp14.Validate(msg)
+}
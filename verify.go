@@ -0,0 +1,265 @@
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// Published with a RuleVerification when a probe sent through a
+// rule doesn't come out where the rule sends it.
+const EventFlowUnverified = "flow.unverified"
+
+// Outcomes of verifying a rule, or one of its outputs.
+const (
+	VerifyPass    = "pass"
+	VerifyFail    = "fail"
+	VerifySkipped = "skipped"
+)
+
+// Headers given to probes for the fields a rule leaves
+// wildcarded: locally administered MACs and IPs of the
+// documentation range, so probes can't be mistaken for real
+// traffic.
+var (
+	VerifyEthSrc = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	VerifyEthDst = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x03}
+	VerifyIPSrc  = net.IPv4(192, 0, 2, 1)
+	VerifyIPDst  = net.IPv4(192, 0, 2, 2)
+)
+
+// The outcome of verifying one output port of a rule. The probe
+// is expected at PeerPort of Peer, the switch at the far end of
+// the link out Port.
+type PortVerification struct {
+	Port     uint16
+	Peer     net.HardwareAddr
+	PeerPort uint16
+	Status   string
+	// Why the port was skipped or failed.
+	Reason string
+}
+
+// The outcome of verifying a rule. Status is VerifyFail if any
+// output failed, VerifyPass if any passed and VerifySkipped if
+// the rule couldn't be probed at all.
+type RuleVerification struct {
+	DPID   net.HardwareAddr
+	Recipe *Recipe
+	Status string
+	Reason string
+	Ports  []PortVerification
+}
+
+// Installs recipe r on Switch dpid and verifies it with
+// VerifyRecipe once the switch has acknowledged it with a
+// barrier.
+func (p *Prober) InstallAndVerify(dpid net.HardwareAddr, r *Recipe, timeout time.Duration) (RuleVerification, error) {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return RuleVerification{}, ErrSwitchDisconnected
+	}
+	if err := sw.InstallRecipe(r); err != nil {
+		return RuleVerification{}, err
+	}
+	if _, err := sw.SendAndReceive(sw.newBarrierRequest(), timeout); err != nil {
+		return RuleVerification{}, err
+	}
+	return p.VerifyRecipe(dpid, r, timeout)
+}
+
+// Checks that recipe r, installed on Switch dpid, is programmed
+// into the switch and not only acknowledged. A probe matching r
+// is injected into the flow table of the switch, and for each
+// output of r leading over a discovered link a temporary flow on
+// the switch at the far end sends the probe to the controller
+// when it arrives on that link. An output passes if the probe
+// arrives there within timeout.
+//
+// Probes are IP packets with protocol ProbeProto, so rules
+// matching other ethertypes, IP protocols or transport ports,
+// and outputs to reserved ports or hosts, are skipped. A rule of
+// higher priority matching the probe makes r fail. Both switches
+// must use OpenFlow 1.0, see Prober.
+func (p *Prober) VerifyRecipe(dpid net.HardwareAddr, r *Recipe, timeout time.Duration) (RuleVerification, error) {
+	v := RuleVerification{DPID: dpid, Recipe: r, Status: VerifySkipped, Ports: make([]PortVerification, 0)}
+	sw, ok := Switch(dpid)
+	if !ok {
+		return v, ErrSwitchDisconnected
+	}
+	if sw.Version() != ofp10.VERSION {
+		return v, errors.New("Probes need OpenFlow 1.0 switches.")
+	}
+	h, reason := verifyHeaders(r.Match)
+	if reason == "" && len(r.Outputs) == 0 {
+		reason = "the rule drops packets"
+	}
+	if reason != "" {
+		v.Reason = reason
+		return v, nil
+	}
+
+	// The ports the probe is expected at, by peer switch and
+	// port.
+	expected := make(map[string]int)
+	for _, port := range r.Outputs {
+		pv := verifyPeer(sw, port)
+		if pv.Status == "" {
+			if err := p.catch(pv.Peer, pv.PeerPort, timeout); err != nil {
+				pv.Status, pv.Reason = VerifySkipped, err.Error()
+			} else {
+				defer p.release(pv.Peer, pv.PeerPort)
+				expected[peerKey(pv.Peer, pv.PeerPort)] = len(v.Ports)
+			}
+		}
+		v.Ports = append(v.Ports, pv)
+	}
+	if len(expected) == 0 {
+		v.Reason = "no output leads to a switch"
+		return v, nil
+	}
+
+	inPort := r.Match.InPort
+	if inPort == 0 {
+		inPort = ofp10.P_NONE
+	}
+	id, ch := p.registerN(len(expected))
+	defer p.unregister(id)
+	deadline := time.After(timeout)
+	result, err := p.inject(sw, inPort, h, id, ch, timeout)
+	for remaining := len(expected); err == nil; {
+		if i, ok := expected[peerKey(result.DPID, result.InPort)]; ok && v.Ports[i].Status == "" {
+			v.Ports[i].Status = VerifyPass
+			remaining -= 1
+		}
+		if remaining == 0 {
+			break
+		}
+		select {
+		case result = <-ch:
+		case <-deadline:
+			err = ErrProbeLost
+		}
+	}
+	if err != nil && err != ErrProbeLost {
+		return v, err
+	}
+
+	v.Status = VerifyPass
+	for i := range v.Ports {
+		if v.Ports[i].Status == "" {
+			v.Ports[i].Status = VerifyFail
+			v.Ports[i].Reason = "the probe did not arrive"
+			v.Status = VerifyFail
+		}
+	}
+	if v.Status == VerifyFail {
+		v.Reason = "the switch doesn't forward as the rule says"
+		Publish(EventFlowUnverified, dpid, v)
+	}
+	return v, nil
+}
+
+// Returns the headers of a probe matching m, or why there are
+// none.
+func verifyHeaders(m FlowMatch) (ProbeHeaders, string) {
+	switch {
+	case m.EthType != 0 && m.EthType != 0x0800:
+		return ProbeHeaders{}, fmt.Sprintf("the rule matches ethertype %#04x", m.EthType)
+	case m.IPProto != 0 && m.IPProto != ProbeProto:
+		return ProbeHeaders{}, fmt.Sprintf("the rule matches IP protocol %d", m.IPProto)
+	case m.TPSrc != 0 || m.TPDst != 0 || m.laterFragments:
+		return ProbeHeaders{}, "the rule matches transport ports"
+	case m.VLAN != 0:
+		return ProbeHeaders{}, "the rule matches a VLAN"
+	}
+	h := ProbeHeaders{VerifyEthSrc, VerifyEthDst, VerifyIPSrc, VerifyIPDst}
+	if m.EthSrc != nil {
+		h.EthSrc = m.EthSrc
+	}
+	if m.EthDst != nil {
+		h.EthDst = m.EthDst
+	}
+	if m.IPSrc != nil {
+		h.IPSrc = maskIP(m.IPSrc, m.IPSrcMask)
+	}
+	if m.IPDst != nil {
+		h.IPDst = maskIP(m.IPDst, m.IPDstMask)
+	}
+	return h, ""
+}
+
+// Finds where the link out port of Switch sw leads. The Status of
+// the result is empty if the port can be verified.
+func verifyPeer(sw *OFSwitch, port uint16) PortVerification {
+	pv := PortVerification{Port: port}
+	if port >= ofp10.P_MAX {
+		pv.Status, pv.Reason = VerifySkipped, "reserved port"
+		return pv
+	}
+	for _, l := range sw.Links() {
+		if l.Port != port {
+			continue
+		}
+		pv.Peer = l.DPID
+		peer, ok := Switch(l.DPID)
+		if !ok {
+			pv.Status, pv.Reason = VerifySkipped, "the peer is disconnected"
+		} else if peer.Version() != ofp10.VERSION {
+			pv.Status, pv.Reason = VerifySkipped, "the peer doesn't use OpenFlow 1.0"
+		} else if pv.PeerPort = linkPort(peer, sw.DPID()); pv.PeerPort == 0 {
+			pv.Status, pv.Reason = VerifySkipped, "the link back from the peer is unknown"
+		}
+		return pv
+	}
+	pv.Status, pv.Reason = VerifySkipped, "no link out of the port"
+	return pv
+}
+
+func peerKey(dpid net.HardwareAddr, port uint16) string {
+	return fmt.Sprintf("%s/%d", dpid, port)
+}
+
+// Installs the temporary flow sending probes received on port of
+// Switch dpid to the controller. Verifications of the same port
+// share the flow; release removes it after the last one.
+func (p *Prober) catch(dpid net.HardwareAddr, port uint16, timeout time.Duration) error {
+	sw, ok := Switch(dpid)
+	if !ok {
+		return ErrSwitchDisconnected
+	}
+	key := peerKey(dpid, port)
+	p.mu.Lock()
+	p.catching[key] += 1
+	first := p.catching[key] == 1
+	p.mu.Unlock()
+	if !first {
+		return nil
+	}
+	m := FlowMatch{InPort: port, IPProto: ProbeProto}
+	err := sw.installOutputs(m, ProbePriority, []uint16{ofp10.P_CONTROLLER})
+	if err == nil {
+		_, err = sw.SendAndReceive(sw.newBarrierRequest(), timeout)
+	}
+	if err != nil {
+		p.release(dpid, port)
+	}
+	return err
+}
+
+func (p *Prober) release(dpid net.HardwareAddr, port uint16) {
+	key := peerKey(dpid, port)
+	p.mu.Lock()
+	p.catching[key] -= 1
+	last := p.catching[key] == 0
+	if last {
+		delete(p.catching, key)
+	}
+	p.mu.Unlock()
+	if sw, ok := Switch(dpid); ok && last {
+		sw.installOutputs(FlowMatch{InPort: port, IPProto: ProbeProto}, ProbePriority, nil)
+	}
+}
@@ -0,0 +1,169 @@
+package ogo
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+)
+
+// Serializes changes to the switches, ports, links and hosts of
+// the network with NetworkView. Writers take it before any lock
+// of the state they change, and count an epoch if the topology
+// changed.
+type topologyLock struct {
+	sync.RWMutex
+	epoch uint64
+}
+
+func (t *topologyLock) unlock(changed bool) {
+	if changed {
+		t.epoch += 1
+	}
+	t.Unlock()
+}
+
+var topology = new(topologyLock)
+
+// An immutable snapshot of the network, taken while no switch,
+// port, link or host changes, so paths computed from it never
+// mix two states of the topology. Epoch counts the changes of
+// the topology; two snapshots with the same epoch are the same,
+// except for link latencies and the last sightings of hosts.
+// Snapshots must not be modified.
+type NetworkSnapshot struct {
+	Epoch    uint64
+	Time     time.Time
+	Switches []SwitchView
+	Hosts    []Host
+
+	byDPID map[string]int
+}
+
+// A switch in a NetworkSnapshot.
+type SwitchView struct {
+	DPID    net.HardwareAddr
+	Version uint8
+	Ports   []ofp10.PhyPort
+	Links   []Link
+	Drained bool
+	// The drained ports of the switch.
+	DrainedPorts []uint16
+	// False if the connection of the switch is down and it is
+	// only kept in case it reconnects, see SwitchAudit.
+	Connected bool
+}
+
+// Returns a snapshot of the network. Snapshots are cheap enough
+// to take for every path computation, and callers may keep one
+// as long as they like.
+func NetworkView() *NetworkSnapshot {
+	topology.RLock()
+	defer topology.RUnlock()
+	v := &NetworkSnapshot{Epoch: topology.epoch, Time: time.Now(), byDPID: make(map[string]int)}
+	sws := Switches()
+	sort.Sort(switchesByDPID(sws))
+	v.Switches = make([]SwitchView, len(sws))
+	for i, sw := range sws {
+		ports := sw.Ports()
+		sort.Sort(phyPortsByNumber(ports))
+		links := sw.Links()
+		sort.Sort(linksByDPID(links))
+		v.Switches[i] = SwitchView{sw.DPID(), sw.Version(), ports, links, switchDrained(sw.DPID().String()),
+			drainedPortsOf(sw.DPID().String()), sw.connected()}
+		v.byDPID[sw.DPID().String()] = i
+	}
+	v.Hosts = make([]Host, 0)
+	if hostTracker != nil {
+		v.Hosts = hostTracker.Hosts()
+		sort.Sort(hostsByMAC(v.Hosts))
+	}
+	return v
+}
+
+// Returns the epoch of the current topology, so a snapshot can
+// be checked for being current without taking a new one.
+func NetworkEpoch() uint64 {
+	topology.RLock()
+	defer topology.RUnlock()
+	return topology.epoch
+}
+
+// Returns Switch dpid as it was in v.
+func (v *NetworkSnapshot) Switch(dpid net.HardwareAddr) (SwitchView, bool) {
+	i, ok := v.byDPID[dpid.String()]
+	if !ok {
+		return SwitchView{}, false
+	}
+	return v.Switches[i], true
+}
+
+// Returns the Topology of v, for path computations. Like
+// CurrentTopology, it leaves out the switches that weren't
+// connected.
+func (v *NetworkSnapshot) Topology() *Topology {
+	t := &Topology{Switches: make([]TopologySwitch, 0, len(v.Switches)),
+		Links: make([]TopologyLink, 0), Hosts: make([]TopologyHost, 0, len(v.Hosts))}
+	for _, sw := range v.Switches {
+		t.Switches = append(t.Switches, TopologySwitch{DPID: sw.DPID.String(), Name: SwitchName(sw.DPID)})
+		for _, l := range sw.Links {
+			t.Links = append(t.Links, TopologyLink{Src: sw.DPID.String(), SrcPort: l.Port,
+				Dst: l.DPID.String(), Latency: int64(l.Latency), Indirect: l.Indirect})
+		}
+	}
+	for _, h := range v.Hosts {
+		t.AddHost(h.MAC, h.DPID, h.Port)
+	}
+	t.dropDisconnected(func(dpid string) bool {
+		mac, _ := net.ParseMAC(dpid)
+		sw, _ := v.Switch(mac)
+		return sw.Connected
+	})
+	t.markDrained(func(dpid string) bool {
+		mac, _ := net.ParseMAC(dpid)
+		sw, _ := v.Switch(mac)
+		return sw.Drained
+	}, func(dpid string, port uint16) bool {
+		mac, _ := net.ParseMAC(dpid)
+		sw, _ := v.Switch(mac)
+		for _, p := range sw.DrainedPorts {
+			if p == port {
+				return true
+			}
+		}
+		return false
+	})
+	t.setCapacities(func(dpid string, port uint16) uint64 {
+		mac, _ := net.ParseMAC(dpid)
+		sw, _ := v.Switch(mac)
+		for _, p := range sw.Ports {
+			if p.PortNo == port {
+				return p.CurrentSpeed()
+			}
+		}
+		return 0
+	})
+	sort.Sort(topologyLinks(t.Links))
+	sort.Sort(topologyHosts(t.Hosts))
+	return t
+}
+
+type phyPortsByNumber []ofp10.PhyPort
+
+func (a phyPortsByNumber) Len() int           { return len(a) }
+func (a phyPortsByNumber) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a phyPortsByNumber) Less(i, j int) bool { return a[i].PortNo < a[j].PortNo }
+
+type linksByDPID []Link
+
+func (a linksByDPID) Len() int           { return len(a) }
+func (a linksByDPID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a linksByDPID) Less(i, j int) bool { return a[i].DPID.String() < a[j].DPID.String() }
+
+type hostsByMAC []Host
+
+func (a hostsByMAC) Len() int           { return len(a) }
+func (a hostsByMAC) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a hostsByMAC) Less(i, j int) bool { return a[i].MAC.String() < a[j].MAC.String() }
@@ -0,0 +1,316 @@
+package ogo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jonstout/ogo/protocol/ofp10"
+	"github.com/jonstout/ogo/protocol/ofp14"
+	"github.com/jonstout/ogo/protocol/util"
+)
+
+// Priority of the flows translating VLAN tags at edge ports.
+var VLANTranslationPriority uint16 = 0xe000
+
+// The cookie of VLAN translation flows is VLANTranslationCookie
+// with the id of their mapping in the low 32 bits.
+var VLANTranslationCookie uint64 = 0x71 << 56
+
+const vlanTranslationCookieMask = 0xffffffff00000000
+
+// The ethertype of the outer tag pushed by Q-in-Q mappings.
+const QinQEthertype = 0x88a8
+
+// Maps a customer VLAN on an edge port to a transport VLAN. Frames
+// arriving on Port of Switch DPID tagged CustomerVLAN are sent out
+// Uplink tagged TransportVLAN, and frames arriving on Uplink
+// tagged TransportVLAN are sent out Port tagged CustomerVLAN.
+//
+// With QinQ the transport tag is pushed as an outer 802.1ad tag
+// and the customer tag is carried inside it, which needs
+// OpenFlow 1.3 or later. Otherwise the tag is rewritten.
+type VLANMapping struct {
+	Id            int    `json:"id"`
+	DPID          string `json:"dpid"`
+	Port          uint16 `json:"port"`
+	Uplink        uint16 `json:"uplink"`
+	CustomerVLAN  uint16 `json:"customer_vlan"`
+	TransportVLAN uint16 `json:"transport_vlan"`
+	QinQ          bool   `json:"qinq,omitempty"`
+	Tenant        string `json:"tenant,omitempty"`
+}
+
+// A VLANTranslator keeps a table of VLANMappings and installs the
+// flows translating the tags of each one on its switch, again
+// whenever the switch connects.
+//
+// It serves an HTTP API: GET lists the mappings, POST adds the
+// VLANMapping in the body and replies with it, and DELETE with an
+// id parameter removes a mapping.
+type VLANTranslator struct {
+	mu       sync.Mutex
+	mappings map[int]*VLANMapping
+	nextId   int
+	sub      *Subscription
+}
+
+func NewVLANTranslator() *VLANTranslator {
+	t := new(VLANTranslator)
+	t.mappings = make(map[int]*VLANMapping)
+	t.nextId = 1
+	return t
+}
+
+func (t *VLANTranslator) Start() {
+	RegisterFlowOwner("vlan-translation", VLANTranslationCookie, vlanTranslationCookieMask)
+	t.sub = Subscribe(64, EventSwitchUp)
+	go func() {
+		for e := range t.sub.C {
+			if sw, ok := Switch(e.DPID); ok {
+				t.installAll(sw)
+			}
+		}
+	}()
+	for _, sw := range Switches() {
+		go t.installAll(sw)
+	}
+}
+
+func (t *VLANTranslator) Stop() {
+	if t.sub != nil {
+		t.sub.Cancel()
+	}
+}
+
+// Adds m, returning it with its id. A mapping can't reuse the
+// customer VLAN of the same port, or the transport VLAN of the
+// same uplink, as another mapping on the switch.
+func (t *VLANTranslator) Add(m VLANMapping) (VLANMapping, error) {
+	dpid, err := net.ParseMAC(m.DPID)
+	if err != nil {
+		return m, err
+	}
+	m.DPID = dpid.String()
+	for _, vid := range []uint16{m.CustomerVLAN, m.TransportVLAN} {
+		if vid == 0 || vid > 4094 {
+			return m, fmt.Errorf("Bad VLAN %d, expected 1 to 4094.", vid)
+		}
+	}
+	if m.Port == m.Uplink {
+		return m, errors.New("The port and the uplink must differ.")
+	}
+	if sw, ok := Switch(dpid); ok && m.QinQ && sw.Version() == ofp10.VERSION {
+		return m, errors.New("Q-in-Q needs OpenFlow 1.3 or later.")
+	}
+
+	t.mu.Lock()
+	for _, o := range t.mappings {
+		if o.DPID != m.DPID {
+			continue
+		}
+		if o.Port == m.Port && o.CustomerVLAN == m.CustomerVLAN {
+			t.mu.Unlock()
+			return m, fmt.Errorf("VLAN %d of port %d is already mapped by %d.", m.CustomerVLAN, m.Port, o.Id)
+		}
+		if o.Uplink == m.Uplink && o.TransportVLAN == m.TransportVLAN {
+			t.mu.Unlock()
+			return m, fmt.Errorf("VLAN %d of uplink %d is already mapped by %d.", m.TransportVLAN, m.Uplink, o.Id)
+		}
+	}
+	m.Id = t.nextId
+	t.nextId += 1
+	t.mappings[m.Id] = &m
+	t.mu.Unlock()
+
+	if sw, ok := Switch(dpid); ok {
+		t.install(sw, &m, false)
+	}
+	return m, nil
+}
+
+// Removes mapping id and its flows.
+func (t *VLANTranslator) Remove(id int) bool {
+	t.mu.Lock()
+	m, ok := t.mappings[id]
+	delete(t.mappings, id)
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if dpid, err := net.ParseMAC(m.DPID); err == nil {
+		if sw, ok := Switch(dpid); ok {
+			t.install(sw, m, true)
+		}
+	}
+	return true
+}
+
+// Returns the mappings by id.
+func (t *VLANTranslator) Mappings() []VLANMapping {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]int, 0, len(t.mappings))
+	for id := range t.mappings {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	a := make([]VLANMapping, len(ids))
+	for i, id := range ids {
+		a[i] = *t.mappings[id]
+	}
+	return a
+}
+
+func (t *VLANTranslator) installAll(sw *OFSwitch) {
+	t.mu.Lock()
+	mappings := make([]*VLANMapping, 0)
+	for _, m := range t.mappings {
+		if m.DPID == sw.DPID().String() {
+			mappings = append(mappings, m)
+		}
+	}
+	t.mu.Unlock()
+	for _, m := range mappings {
+		t.install(sw, m, false)
+	}
+}
+
+// Installs the flows of m on Switch sw, or deletes them.
+func (t *VLANTranslator) install(sw *OFSwitch, m *VLANMapping, remove bool) {
+	mods, err := m.flowMods(sw.Version(), remove)
+	if err != nil {
+		log.Println("Failed to translate VLAN", m.CustomerVLAN, "on", SwitchLabel(sw.DPID()), err)
+		return
+	}
+	for _, f := range mods {
+		if err := sw.Send(f); err != nil {
+			log.Println("Failed to update VLAN translation flow on", SwitchLabel(sw.DPID()), err)
+			return
+		}
+	}
+}
+
+// Returns the flow mods adding or deleting the flows of m on a
+// switch of version: one from the edge port to the uplink and one
+// back.
+func (m *VLANMapping) flowMods(version uint8, remove bool) ([]util.Message, error) {
+	cookie := VLANTranslationCookie | uint64(m.Id)
+	in := FlowMatch{InPort: m.Port, VLAN: m.CustomerVLAN}
+	out := FlowMatch{InPort: m.Uplink, VLAN: m.TransportVLAN}
+	mods := make([]util.Message, 0, 2)
+
+	if version == ofp10.VERSION {
+		if m.QinQ {
+			return nil, errors.New("Q-in-Q needs OpenFlow 1.3 or later.")
+		}
+		for _, dir := range []struct {
+			match FlowMatch
+			vid   uint16
+			port  uint16
+		}{{in, m.TransportVLAN, m.Uplink}, {out, m.CustomerVLAN, m.Port}} {
+			f := ofp10.NewFlowMod()
+			f.Match = dir.match.ofp10()
+			f.Priority = VLANTranslationPriority
+			f.Cookie = cookie
+			if remove {
+				f.Command = ofp10.FC_DELETE_STRICT
+			} else {
+				f.AddAction(ofp10.NewActionVLANVID(dir.vid))
+				f.AddAction(ofp10.NewActionOutput(dir.port))
+			}
+			mods = append(mods, f)
+		}
+		return mods, nil
+	}
+
+	// Towards the uplink.
+	ingress := ofp14.NewInstrApplyActions()
+	if m.QinQ {
+		ingress.AddAction(ofp14.NewActionPushVlan(QinQEthertype))
+	}
+	ingress.AddAction(setVLANAction(m.TransportVLAN))
+	ingress.AddAction(ofp14.NewActionOutput(uint32(m.Uplink)))
+
+	// Towards the edge port.
+	egress := ofp14.NewInstrApplyActions()
+	if m.QinQ {
+		egress.AddAction(ofp14.NewActionPopVlan())
+	} else {
+		egress.AddAction(setVLANAction(m.CustomerVLAN))
+	}
+	egress.AddAction(ofp14.NewActionOutput(uint32(m.Port)))
+
+	for _, dir := range []struct {
+		match   FlowMatch
+		actions *ofp14.InstrActions
+	}{{in, ingress}, {out, egress}} {
+		f := ofp14.NewFlowMod()
+		f.Header.Version = version
+		f.Match = dir.match.ofp14()
+		f.Priority = VLANTranslationPriority
+		f.Cookie = cookie
+		if remove {
+			f.Command = ofp14.FC_DELETE_STRICT
+			f.CookieMask = 0xffffffffffffffff
+		} else {
+			f.AddInstruction(dir.actions)
+		}
+		mods = append(mods, f)
+	}
+	return mods, nil
+}
+
+// Returns the OXM value of VLAN id vid, with OFPVID_PRESENT set.
+func vidField(vid uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, vid|0x1000)
+	return b
+}
+
+// Returns an action setting the id of the outermost VLAN tag.
+func setVLANAction(vid uint16) ofp14.Action {
+	return ofp14.NewActionSetField(ofp14.XMT_OFB_VLAN_VID, vidField(vid))
+}
+
+func (t *VLANTranslator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Mappings())
+	case "POST":
+		var m VLANMapping
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := t.Add(m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(m)
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+		if !t.Remove(id) {
+			http.Error(w, "no such mapping", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,331 @@
+package ogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many events may wait for delivery to a single webhook.
+// Events published while its queue is full are dropped.
+var WebhookQueue = 256
+
+// A Webhook receives the events of the event bus as JSON POSTs
+// to URL. Only events whose type starts with one of Events, and
+// that are about one of DPIDs, are sent; an empty list selects
+// every event or switch. The body of each POST is an
+// EventRecord, like
+//
+//	{"schema": 1, "type": "switch.down", "dpid": "00:00:00:00:00:01",
+//		"time": "2014-03-01T10:00:00Z", "data": "EOF"}
+type Webhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	DPIDs  []string `json:"dpids,omitempty"`
+}
+
+// The delivery counters of a webhook.
+type WebhookStatus struct {
+	Webhook
+	Id        int    `json:"id"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Retries   uint64 `json:"retries"`
+	Dropped   uint64 `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Webhooks delivers events to registered webhooks so ogo can
+// feed external alerting and automation. Each webhook has its
+// own queue, so a slow receiver only delays its own events. A
+// POST answered with anything but a 2xx status is retried up to
+// Retries times with exponential backoff, starting at Backoff;
+// client errors other than 429 are not retried.
+//
+// It serves an HTTP API: GET lists the webhooks with their
+// delivery counters, POST registers the Webhook in the body and
+// replies with its status, and DELETE with an id parameter
+// removes a webhook.
+type Webhooks struct {
+	Retries int
+	Backoff time.Duration
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	hooks  map[int]*webhook
+	nextId int
+	sub    *Subscription
+	client *http.Client
+}
+
+type webhook struct {
+	status WebhookStatus
+	queue  chan EventRecord
+	done   chan bool
+}
+
+// Active webhook registries, for the metrics endpoint.
+var webhookSets = struct {
+	sync.Mutex
+	m map[*Webhooks]bool
+}{m: make(map[*Webhooks]bool)}
+
+func NewWebhooks() *Webhooks {
+	w := new(Webhooks)
+	w.Retries = 5
+	w.Backoff = time.Second
+	w.Timeout = time.Second * 5
+	w.hooks = make(map[int]*webhook)
+	w.nextId = 1
+	return w
+}
+
+// Starts delivering events.
+func (w *Webhooks) Start() {
+	w.client = &http.Client{Timeout: w.Timeout}
+	w.sub = Subscribe(1024)
+	webhookSets.Lock()
+	webhookSets.m[w] = true
+	webhookSets.Unlock()
+	go w.loop()
+}
+
+// Stops delivering events. Events still queued are dropped.
+func (w *Webhooks) Stop() {
+	if w.sub != nil {
+		w.sub.Cancel()
+	}
+	webhookSets.Lock()
+	delete(webhookSets.m, w)
+	webhookSets.Unlock()
+	w.mu.Lock()
+	for id, h := range w.hooks {
+		close(h.done)
+		delete(w.hooks, id)
+	}
+	w.mu.Unlock()
+}
+
+// Registers h and returns its id.
+func (w *Webhooks) Add(h Webhook) (int, error) {
+	if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return 0, errors.New("Webhook URL must be http or https.")
+	}
+	for i, d := range h.DPIDs {
+		mac, err := net.ParseMAC(d)
+		if err != nil {
+			return 0, err
+		}
+		h.DPIDs[i] = mac.String()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextId
+	w.nextId += 1
+	hook := &webhook{queue: make(chan EventRecord, WebhookQueue), done: make(chan bool)}
+	hook.status.Id = id
+	hook.status.Webhook = h
+	w.hooks[id] = hook
+	go w.deliver(hook)
+	return id, nil
+}
+
+// Removes webhook id.
+func (w *Webhooks) Remove(id int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h, ok := w.hooks[id]
+	if ok {
+		close(h.done)
+		delete(w.hooks, id)
+	}
+	return ok
+}
+
+// Returns the registered webhooks and their delivery counters,
+// by id.
+func (w *Webhooks) Status() []WebhookStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]int, 0, len(w.hooks))
+	for id := range w.hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	a := make([]WebhookStatus, 0, len(ids))
+	for _, id := range ids {
+		a = append(a, w.hooks[id].status)
+	}
+	return a
+}
+
+func (w *Webhooks) loop() {
+	for e := range w.sub.C {
+		ev := newEventRecord(e)
+		w.mu.Lock()
+		for _, h := range w.hooks {
+			if !h.status.wants(ev) {
+				continue
+			}
+			select {
+			case h.queue <- ev:
+			default:
+				h.status.Dropped += 1
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (h *Webhook) wants(e EventRecord) bool {
+	if len(h.DPIDs) > 0 {
+		found := false
+		for _, d := range h.DPIDs {
+			found = found || d == e.DPID
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, p := range h.Events {
+		if strings.HasPrefix(e.Type, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhooks) deliver(h *webhook) {
+	for {
+		select {
+		case e := <-h.queue:
+			w.post(h, e)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// Posts e to h, retrying until it's accepted, the retries run
+// out or h is removed.
+func (w *Webhooks) post(h *webhook, e EventRecord) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		// The data can't be encoded, send the event without it.
+		e.Data = fmt.Sprint(e.Data)
+		body, _ = json.Marshal(e)
+	}
+	backoff := w.Backoff
+	for try := 0; ; try++ {
+		retry, err := w.postOnce(h.status.URL, body)
+		w.mu.Lock()
+		if err == nil {
+			h.status.Delivered += 1
+			w.mu.Unlock()
+			return
+		}
+		h.status.LastError = err.Error()
+		if !retry || try >= w.Retries {
+			h.status.Failed += 1
+			w.mu.Unlock()
+			log.Println("Webhook", h.status.URL, "failed:", err)
+			return
+		}
+		h.status.Retries += 1
+		w.mu.Unlock()
+		select {
+		case <-time.After(backoff):
+		case <-h.done:
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// Posts body to url once. Returns whether a failure is worth
+// retrying.
+func (w *Webhooks) postOnce(url string, body []byte) (bool, error) {
+	rep, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	rep.Body.Close()
+	if rep.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = errors.New(rep.Status)
+	return rep.StatusCode/100 == 5 || rep.StatusCode == http.StatusTooManyRequests, err
+}
+
+func (w *Webhooks) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(w.Status())
+	case "POST":
+		var h Webhook
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := w.Add(h)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(WebhookStatus{Id: id, Webhook: h})
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(rw, "bad id", http.StatusBadRequest)
+			return
+		}
+		if !w.Remove(id) {
+			http.Error(rw, "no such webhook", http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Writes the delivery counters of every active webhook in the
+// Prometheus text format.
+func writeWebhookMetrics(out io.Writer) {
+	webhookSets.Lock()
+	status := make([]WebhookStatus, 0)
+	for w := range webhookSets.m {
+		status = append(status, w.Status()...)
+	}
+	webhookSets.Unlock()
+	for _, m := range []struct {
+		name  string
+		value func(WebhookStatus) uint64
+	}{
+		{"delivered", func(s WebhookStatus) uint64 { return s.Delivered }},
+		{"failed", func(s WebhookStatus) uint64 { return s.Failed }},
+		{"retries", func(s WebhookStatus) uint64 { return s.Retries }},
+		{"dropped", func(s WebhookStatus) uint64 { return s.Dropped }},
+	} {
+		fmt.Fprintf(out, "# TYPE ogo_webhook_%s_total counter\n", m.name)
+		for _, s := range status {
+			fmt.Fprintf(out, "ogo_webhook_%s_total{url=%q} %d\n", m.name, s.URL, m.value(s))
+		}
+	}
+}